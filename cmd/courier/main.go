@@ -1,13 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -17,19 +23,38 @@ import (
 	"github.com/trisacrypto/courier/pkg/api/v1"
 	"github.com/trisacrypto/courier/pkg/config"
 	"github.com/trisacrypto/courier/pkg/secrets"
+	"github.com/trisacrypto/courier/pkg/service"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/gcs"
+	"github.com/trisacrypto/courier/pkg/store/local"
+	"github.com/trisacrypto/courier/pkg/store/migrate"
+	"github.com/trisacrypto/courier/pkg/store/postgres"
+	"github.com/trisacrypto/courier/pkg/store/s3"
+	"github.com/trisacrypto/courier/pkg/store/snapshot"
 	"github.com/urfave/cli/v2"
 )
 
 func main() {
-	// Load the dotenv file if it exists
-	godotenv.Load()
-
 	// Create the CLI application
 	app := &cli.App{
 		Name:    "courier",
 		Version: courier.Version(),
 		Usage:   "a standalone certificate delivery service",
-		Flags:   []cli.Flag{},
+		Before:  loadEnv,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "env-file",
+				Aliases: []string{"e"},
+				Usage:   "load the configuration from the dotenv file instead of .env",
+				EnvVars: []string{"COURIER_ENV_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "profile",
+				Aliases: []string{"p"},
+				Usage:   "load the configuration from .env.<profile> instead of .env",
+				EnvVars: []string{"COURIER_PROFILE"},
+			},
+		},
 		Commands: []*cli.Command{
 			{
 				Name:     "serve",
@@ -38,11 +63,74 @@ func main() {
 				Action:   serve,
 				Flags: []cli.Flag{
 					&cli.StringFlag{
-						Name:     "addr",
-						Aliases:  []string{"a"},
-						Usage:    "address:port to bind the server on",
-						EnvVars:  []string{"COURIER_BIND_ADDR"},
-						Required: true,
+						Name:    "addr",
+						Aliases: []string{"a"},
+						Usage:   "address:port to bind the server on if no listeners are configured",
+						EnvVars: []string{"COURIER_BIND_ADDR"},
+					},
+				},
+			},
+			{
+				Name:     "service",
+				Usage:    "install and control courier as a windows service or macos launchd daemon",
+				Category: "server",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "install",
+						Usage:  "install the serve command as a service that starts at boot",
+						Action: installService,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "name",
+								Aliases: []string{"n"},
+								Usage:   "name of the windows service or label of the launchd daemon",
+								Value:   service.DefaultName,
+							},
+							&cli.StringFlag{
+								Name:    "addr",
+								Aliases: []string{"a"},
+								Usage:   "address:port to bind the server on if no listeners are configured",
+							},
+						},
+					},
+					{
+						Name:   "uninstall",
+						Usage:  "stop and remove the service",
+						Action: uninstallService,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "name",
+								Aliases: []string{"n"},
+								Usage:   "name of the windows service or label of the launchd daemon",
+								Value:   service.DefaultName,
+							},
+						},
+					},
+					{
+						Name:   "start",
+						Usage:  "start the installed service",
+						Action: startService,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "name",
+								Aliases: []string{"n"},
+								Usage:   "name of the windows service or label of the launchd daemon",
+								Value:   service.DefaultName,
+							},
+						},
+					},
+					{
+						Name:   "stop",
+						Usage:  "stop the running service",
+						Action: stopService,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "name",
+								Aliases: []string{"n"},
+								Usage:   "name of the windows service or label of the launchd daemon",
+								Value:   service.DefaultName,
+							},
+						},
 					},
 				},
 			},
@@ -68,12 +156,33 @@ func main() {
 					&cli.StringFlag{
 						Name:     "url",
 						Aliases:  []string{"u", "endpoint"},
-						Usage:    "url to connect to the courier server",
+						Usage:    "url of the courier server, or comma separated urls to fail over between",
 						EnvVars:  []string{"COURIER_CLIENT_URL"},
 						Required: true,
 					},
 				},
 			},
+			{
+				Name:     "simulate",
+				Usage:    "run a synthetic delivery to validate the server and its storage",
+				Category: "client",
+				Action:   simulate,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "url",
+						Aliases:  []string{"u", "endpoint"},
+						Usage:    "url of the courier server, or comma separated urls to fail over between",
+						EnvVars:  []string{"COURIER_CLIENT_URL"},
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:    "token",
+						Aliases: []string{"t"},
+						Usage:   "admin token of the courier server",
+						EnvVars: []string{"COURIER_ADMIN_TOKEN"},
+					},
+				},
+			},
 			{
 				Name:     "store:password",
 				Usage:    "store a pkcs12 password using the courier server",
@@ -83,7 +192,7 @@ func main() {
 					&cli.StringFlag{
 						Name:     "url",
 						Aliases:  []string{"u", "endpoint"},
-						Usage:    "url to connect to the courier server",
+						Usage:    "url of the courier server, or comma separated urls to fail over between",
 						EnvVars:  []string{"COURIER_CLIENT_URL"},
 						Required: true,
 					},
@@ -103,6 +212,10 @@ func main() {
 						Aliases: []string{"f"},
 						Usage:   "specify a file to read the password from",
 					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "store the password even if it cannot decrypt the stored certificate",
+					},
 				},
 			},
 			{
@@ -114,7 +227,7 @@ func main() {
 					&cli.StringFlag{
 						Name:     "url",
 						Aliases:  []string{"u", "endpoint"},
-						Usage:    "url to connect to the courier server",
+						Usage:    "url of the courier server, or comma separated urls to fail over between",
 						EnvVars:  []string{"COURIER_CLIENT_URL"},
 						Required: true,
 					},
@@ -137,6 +250,177 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:     "retrieve:certificate",
+				Usage:    "retrieve a stored certificate from the courier server",
+				Category: "client",
+				Action:   retrieveCertificate,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "url",
+						Aliases:  []string{"u", "endpoint"},
+						Usage:    "url of the courier server, or comma separated urls to fail over between",
+						EnvVars:  []string{"COURIER_CLIENT_URL"},
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "id",
+						Aliases:  []string{"i"},
+						Usage:    "the id of the certificate to retrieve",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "out",
+						Aliases:  []string{"o"},
+						Usage:    "path to write the certificate file to",
+						Required: true,
+					},
+				},
+			},
+			{
+				Name:     "rotate:password",
+				Usage:    "rotate a stored pkcs12 password and re-encrypt the stored certificate",
+				Category: "store",
+				Action:   rotatePassword,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "id",
+						Aliases:  []string{"i"},
+						Usage:    "the id of the certificate to rotate the password for",
+						Required: true,
+					},
+					&cli.IntFlag{
+						Name:    "keep",
+						Aliases: []string{"k"},
+						Usage:   "number of most recent versions to keep, zero or less keeps all versions",
+						Value:   1,
+					},
+				},
+			},
+			{
+				Name:     "postgres:migrate",
+				Usage:    "apply pending schema migrations to the postgres storage backend",
+				Category: "store",
+				Action:   migratePostgres,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "dry-run",
+						Aliases: []string{"n"},
+						Usage:   "list the pending migrations without applying them",
+					},
+				},
+			},
+			{
+				Name:     "local:rekey",
+				Usage:    "encrypt local storage files that are not encrypted with the current key",
+				Category: "store",
+				Action:   rekeyLocal,
+			},
+			{
+				Name:     "local:export",
+				Usage:    "write every local storage file to an archive encrypted with the current key",
+				Category: "store",
+				Action:   exportLocal,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "out",
+						Aliases:  []string{"o"},
+						Usage:    "path of the archive file to write",
+						Required: true,
+					},
+				},
+			},
+			{
+				Name:     "local:import",
+				Usage:    "write the files of an exported archive into empty local storage",
+				Category: "store",
+				Action:   importLocal,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "in",
+						Aliases:  []string{"i"},
+						Usage:    "path of the archive file to read",
+						Required: true,
+					},
+				},
+			},
+			{
+				Name:     "store:migrate",
+				Aliases:  []string{"migrate"},
+				Usage:    "copy all passwords, certificates, and secrets from one storage backend to another",
+				Category: "store",
+				Action:   migrateStore,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "from",
+						Aliases:  []string{"f"},
+						Usage:    "the storage backend to copy resources from (e.g. local)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "to",
+						Aliases:  []string{"t"},
+						Usage:    "the storage backend to copy resources to (e.g. gcloud or gcp_secret_manager)",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:    "dry-run",
+						Aliases: []string{"n"},
+						Usage:   "list the resources that would be copied without copying them",
+					},
+					&cli.BoolFlag{
+						Name:  "overwrite",
+						Usage: "replace resources that exist in the destination with different data",
+					},
+				},
+			},
+			{
+				Name:     "store:backup",
+				Usage:    "write an encrypted snapshot of every stored resource to a file or bucket",
+				Category: "store",
+				Action:   backupStore,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "backend",
+						Aliases: []string{"b"},
+						Usage:   "the enabled storage backend to back up (default is the primary backend)",
+					},
+					&cli.StringFlag{
+						Name:     "out",
+						Aliases:  []string{"o"},
+						Usage:    "path of the snapshot file or s3:key or gcs:key to write it to the bucket",
+						Required: true,
+					},
+				},
+			},
+			{
+				Name:     "store:restore",
+				Usage:    "restore an encrypted snapshot from a file or bucket into a storage backend",
+				Category: "store",
+				Action:   restoreStore,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "backend",
+						Aliases: []string{"b"},
+						Usage:   "the enabled storage backend to restore into (default is the primary backend)",
+					},
+					&cli.StringFlag{
+						Name:     "in",
+						Aliases:  []string{"i"},
+						Usage:    "path of the snapshot file or s3:key or gcs:key to read it from the bucket",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:    "dry-run",
+						Aliases: []string{"n"},
+						Usage:   "list the resources that would be restored without restoring them",
+					},
+					&cli.BoolFlag{
+						Name:  "overwrite",
+						Usage: "replace resources that exist in the backend with different data",
+					},
+				},
+			},
 			{
 				Name:     "secrets:get",
 				Usage:    "get a secret from the secret manager",
@@ -165,6 +449,46 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:     "gcp:bootstrap",
+				Usage:    "validate or create the api, service account, and iam binding that the secret manager backend needs",
+				Category: "secrets",
+				Action:   bootstrapGCP,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "project",
+						Aliases:  []string{"p"},
+						Usage:    "project that secrets are stored in",
+						EnvVars:  []string{"COURIER_GCP_SECRET_MANAGER_PROJECT"},
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:    "member",
+						Aliases: []string{"m"},
+						Usage:   "principal that courier runs as, e.g. serviceAccount:courier@project.iam.gserviceaccount.com",
+					},
+					&cli.StringFlag{
+						Name:    "service-account",
+						Aliases: []string{"s"},
+						Usage:   "account id of a dedicated service account for courier to create if it does not exist",
+					},
+					&cli.BoolFlag{
+						Name:  "apply",
+						Usage: "create the missing service account and iam binding instead of only validating them",
+					},
+					&cli.StringFlag{
+						Name:    "terraform",
+						Aliases: []string{"t"},
+						Usage:   "path to write the equivalent terraform configuration to instead of stdout",
+					},
+					&cli.StringFlag{
+						Name:    "credentials",
+						Aliases: []string{"c"},
+						Usage:   "path to the credentials file of a principal that can manage the project iam policy",
+						EnvVars: []string{"GOOGLE_APPLICATION_CREDENTIALS"},
+					},
+				},
+			},
 		},
 	}
 
@@ -173,6 +497,32 @@ func main() {
 	}
 }
 
+// Load the configuration of the deployment that the command is run against into the
+// environment. Variables that are already set in the environment are not replaced. If
+// neither an env file nor a profile is specified, .env is loaded if it exists;
+// otherwise the specified files must exist and .env is not loaded so that settings of
+// different deployments are not mixed.
+func loadEnv(c *cli.Context) (err error) {
+	var files []string
+	if path := c.String("env-file"); path != "" {
+		files = append(files, path)
+	}
+
+	if profile := c.String("profile"); profile != "" {
+		files = append(files, ".env."+profile)
+	}
+
+	if len(files) == 0 {
+		godotenv.Load()
+		return nil
+	}
+
+	if err = godotenv.Load(files...); err != nil {
+		return cli.Exit(fmt.Errorf("could not load configuration: %w", err), 1)
+	}
+	return nil
+}
+
 //===========================================================================
 // Server Actions
 //===========================================================================
@@ -193,13 +543,104 @@ func serve(c *cli.Context) (err error) {
 		return cli.Exit(err, 1)
 	}
 
-	if err = srv.Serve(); err != nil {
+	// Serve as a service if courier was started by the service manager
+	if err = service.Run(func(stop <-chan struct{}) error {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-stop:
+				srv.Stop()
+			case <-done:
+			}
+		}()
+		return srv.Serve()
+	}); err != nil {
 		return cli.Exit(err, 1)
 	}
 
 	return nil
 }
 
+// Install the serve command as a native service. The configuration files given to the
+// command are passed to the service as absolute paths since the service does not run
+// in the current directory on Windows.
+func installService(c *cli.Context) (err error) {
+	conf := service.Config{
+		Name:        c.String("name"),
+		DisplayName: "TRISA Courier",
+		Description: "A standalone certificate delivery service",
+	}
+
+	if conf.Executable, err = os.Executable(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if conf.WorkingDirectory, err = os.Getwd(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var files []string
+	if path := c.String("env-file"); path != "" {
+		files = append(files, path)
+	}
+
+	if profile := c.String("profile"); profile != "" {
+		files = append(files, ".env."+profile)
+	}
+
+	switch len(files) {
+	case 0:
+	case 1:
+		var path string
+		if path, err = filepath.Abs(files[0]); err != nil {
+			return cli.Exit(err, 1)
+		}
+		conf.Arguments = append(conf.Arguments, "--env-file", path)
+	default:
+		return cli.Exit("specify either an env file or a profile to install the service with", 1)
+	}
+
+	conf.Arguments = append(conf.Arguments, "serve")
+	if addr := c.String("addr"); addr != "" {
+		conf.Arguments = append(conf.Arguments, "--addr", addr)
+	}
+
+	if err = service.Install(conf); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("installed service %s: %s %s\n", conf.Name, conf.Executable, strings.Join(conf.Arguments, " "))
+	return nil
+}
+
+func uninstallService(c *cli.Context) (err error) {
+	if err = service.Uninstall(c.String("name")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("uninstalled service %s\n", c.String("name"))
+	return nil
+}
+
+func startService(c *cli.Context) (err error) {
+	if err = service.Start(c.String("name")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("started service %s\n", c.String("name"))
+	return nil
+}
+
+func stopService(c *cli.Context) (err error) {
+	if err = service.Stop(c.String("name")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("stopped service %s\n", c.String("name"))
+	return nil
+}
+
 func usage(c *cli.Context) (err error) {
 	tabs := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
 	format := confire.DefaultTableFormat
@@ -237,6 +678,32 @@ func status(c *cli.Context) (err error) {
 	return printJSON(rep)
 }
 
+// Run a synthetic delivery through the courier service, exiting with an error if any
+// step of the simulation failed.
+func simulate(c *cli.Context) (err error) {
+	var client api.CourierClient
+	if client, err = api.New(c.String("url"), api.WithAdminToken(c.String("token"))); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var rep *api.SimulationReply
+	if rep, err = client.Simulate(ctx); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if err = printJSON(rep); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if !rep.Success {
+		return cli.Exit("delivery simulation failed", 1)
+	}
+	return nil
+}
+
 // Store a password using the courier service.
 func storePassword(c *cli.Context) (err error) {
 	var client api.CourierClient
@@ -269,6 +736,7 @@ func storePassword(c *cli.Context) (err error) {
 	req := &api.StorePasswordRequest{
 		ID:       c.String("id"),
 		Password: password,
+		Force:    c.Bool("force"),
 	}
 	if err = client.StoreCertificatePassword(ctx, req); err != nil {
 		return cli.Exit(err, 1)
@@ -292,23 +760,476 @@ func storeCertificate(c *cli.Context) (err error) {
 		return cli.Exit(err, 1)
 	}
 
+	defer f.Close()
+
+	if err = client.UploadCertificate(ctx, c.String("id"), f, c.Bool("no-decrypt")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return nil
+}
+
+// Retrieve a certificate using the courier service.
+func retrieveCertificate(c *cli.Context) (err error) {
+	var client api.CourierClient
+	if client, err = api.New(c.String("url")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var rep *api.CertificateReply
+	if rep, err = client.RetrieveCertificate(ctx, c.String("id")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
 	var data []byte
-	if data, err = io.ReadAll(f); err != nil {
+	if data, err = base64.StdEncoding.DecodeString(rep.Base64Certificate); err != nil {
 		return cli.Exit(err, 1)
 	}
 
-	req := &api.StoreCertificateRequest{
-		ID:                c.String("id"),
-		NoDecrypt:         c.Bool("no-decrypt"),
-		Base64Certificate: base64.StdEncoding.EncodeToString(data),
+	if err = os.WriteFile(c.String("out"), data, 0600); err != nil {
+		return cli.Exit(err, 1)
 	}
-	if err = client.StoreCertificate(ctx, req); err != nil {
+	return nil
+}
+
+//===========================================================================
+// Store Actions
+//===========================================================================
+
+// Rotate a stored password by generating a new password, re-encrypting the stored
+// certificate if necessary, and destroying old versions of both.
+func rotatePassword(c *cli.Context) (err error) {
+	var conf config.Config
+	if conf, err = config.New(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var db store.Store
+	if db, err = courier.OpenStore(conf); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Generate a new random password
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return cli.Exit(err, 1)
+	}
+	password := []byte(base64.RawURLEncoding.EncodeToString(secret))
+
+	id := c.String("id")
+	var reencrypted bool
+	if reencrypted, err = store.RotatePassword(ctx, db, id, password); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	// Destroy old versions according to the retention policy
+	if keep := c.Int("keep"); keep > 0 {
+		if err = db.PrunePasswordVersions(ctx, id, keep); err != nil {
+			return cli.Exit(err, 1)
+		}
+
+		if reencrypted {
+			if err = db.PruneCertificateVersions(ctx, id, keep); err != nil {
+				return cli.Exit(err, 1)
+			}
+		}
+	}
+
+	fmt.Printf("rotated pkcs12 password for %s (certificate re-encrypted: %t)\n", id, reencrypted)
+	return nil
+}
+
+func migratePostgres(c *cli.Context) (err error) {
+	var conf config.Config
+	if conf, err = config.New(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if !conf.Postgres.Enabled {
+		return cli.Exit("postgres storage is not enabled", 1)
+	}
+
+	var db *sql.DB
+	if db, err = postgres.Connect(conf.Postgres); err != nil {
 		return cli.Exit(err, 1)
 	}
+	defer db.Close()
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var migrations []postgres.Migration
+	if c.Bool("dry-run") {
+		migrations, err = postgres.Pending(ctx, db)
+	} else {
+		migrations, err = postgres.Migrate(ctx, db)
+	}
+
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if len(migrations) == 0 {
+		fmt.Println("postgres schema is up to date")
+		return nil
+	}
+
+	for _, migration := range migrations {
+		if c.Bool("dry-run") {
+			fmt.Printf("pending migration %04d %s\n", migration.Version, migration.Name)
+		} else {
+			fmt.Printf("applied migration %04d %s\n", migration.Version, migration.Name)
+		}
+	}
 	return nil
 }
 
+func rekeyLocal(c *cli.Context) (err error) {
+	var conf config.Config
+	if conf, err = config.New(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if !conf.LocalStorage.Enabled {
+		return cli.Exit("local storage is not enabled", 1)
+	}
+
+	var db *local.Store
+	if db, err = local.Open(conf.LocalStorage); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var rekeyed int
+	if rekeyed, err = db.Rekey(ctx); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("encrypted %d local storage files with the current key\n", rekeyed)
+	return nil
+}
+
+// Export the local storage files to an archive, e.g. to move courier to another host.
+// The archive file is only written if every file was exported.
+func exportLocal(c *cli.Context) (err error) {
+	var db *local.Store
+	if db, err = openLocal(); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var (
+		buf      bytes.Buffer
+		exported int
+	)
+	if exported, err = db.Export(&buf); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if err = os.WriteFile(c.String("out"), buf.Bytes(), 0600); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("exported %d local storage files to %s\n", exported, c.String("out"))
+	return nil
+}
+
+// Import an exported archive into local storage that has no resources.
+func importLocal(c *cli.Context) (err error) {
+	var f *os.File
+	if f, err = os.Open(c.String("in")); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer f.Close()
+
+	var db *local.Store
+	if db, err = openLocal(); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var imported int
+	if imported, err = db.Import(f); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("imported %d local storage files from %s\n", imported, c.String("in"))
+	return nil
+}
+
+// Open the local storage backend of the configuration for the local commands.
+func openLocal() (_ *local.Store, err error) {
+	var conf config.Config
+	if conf, err = config.New(); err != nil {
+		return nil, err
+	}
+
+	if !conf.LocalStorage.Enabled {
+		return nil, errors.New("local storage is not enabled")
+	}
+	return local.Open(conf.LocalStorage)
+}
+
+// Copy the resources from one storage backend to another. Both backends are enabled for
+// the migration and the configuration is only validated for the two backends.
+func migrateStore(c *cli.Context) (err error) {
+	var conf config.Config
+	if conf, err = config.Load(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	from, to := config.BackendName(c.String("from")), config.BackendName(c.String("to"))
+	if from == to {
+		return cli.Exit("cannot migrate a storage backend to itself", 1)
+	}
+
+	// Both backends are opened for the migration even if the server does not use them
+	for _, backend := range []string{from, to} {
+		if conf, err = conf.EnableBackend(backend); err != nil {
+			return cli.Exit(err, 1)
+		}
+	}
+
+	var src, dst store.Store
+	if src, err = courier.OpenBackend(conf, from); err != nil {
+		return cli.Exit(fmt.Errorf("could not open %s: %w", from, err), 1)
+	}
+	defer src.Close()
+
+	if dst, err = courier.OpenBackend(conf, to); err != nil {
+		return cli.Exit(fmt.Errorf("could not open %s: %w", to, err), 1)
+	}
+	defer dst.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	opts := migrate.Options{
+		DryRun:    c.Bool("dry-run"),
+		Overwrite: c.Bool("overwrite"),
+		Progress: func(r migrate.Result) {
+			if r.Err != nil {
+				fmt.Printf("%-9s %s/%s: %s\n", r.Status, r.Prefix, r.Name, r.Err)
+				return
+			}
+			fmt.Printf("%-9s %s/%s %s\n", r.Status, r.Prefix, r.Name, r.Checksum)
+		},
+	}
+
+	var report *migrate.Report
+	if report, err = migrate.Migrate(ctx, src, dst, opts); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("%s to %s: %d copied, %d planned, %d unchanged, %d skipped, %d failed\n", from, to, report.Copied, report.Planned, report.Unchanged, report.Skipped, report.Failed)
+	if report.Failed > 0 {
+		return cli.Exit(fmt.Sprintf("could not migrate %d resources", report.Failed), 1)
+	}
+	return nil
+}
+
+// Write an encrypted snapshot of a storage backend to a file or bucket. Snapshots are
+// encrypted with the snapshot key in the configuration.
+func backupStore(c *cli.Context) (err error) {
+	var (
+		conf config.Config
+		key  []byte
+	)
+	if conf, key, err = loadSnapshotConfig(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	backend := c.String("backend")
+	if backend == "" {
+		backend = conf.StorageBackend()
+	}
+
+	var db store.Store
+	if db, err = courier.OpenBackend(conf, backend); err != nil {
+		return cli.Exit(fmt.Errorf("could not open %s: %w", backend, err), 1)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	var snap *snapshot.Snapshot
+	if snap, err = snapshot.Take(ctx, db); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var data []byte
+	if data, err = snap.Encrypt(key); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if err = writeSnapshot(ctx, conf, c.String("out"), data); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	counts := snap.Counts()
+	fmt.Printf("%s backed up to %s: %d passwords, %d certificates, %d secrets\n", backend, c.String("out"), counts.Passwords, counts.Certificates, counts.Secrets)
+	return nil
+}
+
+// Restore an encrypted snapshot from a file or bucket into a storage backend, which can
+// be a different backend than the snapshot was taken from.
+func restoreStore(c *cli.Context) (err error) {
+	var (
+		conf config.Config
+		key  []byte
+	)
+	if conf, key, err = loadSnapshotConfig(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	backend := c.String("backend")
+	if backend == "" {
+		backend = conf.StorageBackend()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	var data []byte
+	if data, err = readSnapshot(ctx, conf, c.String("in")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var snap *snapshot.Snapshot
+	if snap, err = snapshot.Decrypt(data, key); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var db store.Store
+	if db, err = courier.OpenBackend(conf, backend); err != nil {
+		return cli.Exit(fmt.Errorf("could not open %s: %w", backend, err), 1)
+	}
+	defer db.Close()
+
+	opts := migrate.Options{
+		DryRun:    c.Bool("dry-run"),
+		Overwrite: c.Bool("overwrite"),
+		Progress: func(r migrate.Result) {
+			if r.Err != nil {
+				fmt.Printf("%-9s %s/%s: %s\n", r.Status, r.Prefix, r.Name, r.Err)
+				return
+			}
+			fmt.Printf("%-9s %s/%s %s\n", r.Status, r.Prefix, r.Name, r.Checksum)
+		},
+	}
+
+	var report *migrate.Report
+	if report, err = snapshot.Restore(ctx, db, snap, opts); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("snapshot from %s restored to %s: %d copied, %d planned, %d unchanged, %d skipped, %d failed\n", snap.Created.Format(time.RFC3339), backend, report.Copied, report.Planned, report.Unchanged, report.Skipped, report.Failed)
+	if report.Failed > 0 {
+		return cli.Exit(fmt.Sprintf("could not restore %d resources", report.Failed), 1)
+	}
+	return nil
+}
+
+// Load the configuration and snapshot key without validating the configuration of the
+// server, since only the backends that are opened are validated.
+func loadSnapshotConfig() (conf config.Config, key []byte, err error) {
+	if conf, err = config.Load(); err != nil {
+		return conf, nil, err
+	}
+
+	if key, err = conf.Snapshot.Secret(); err != nil {
+		return conf, nil, err
+	}
+
+	if key == nil {
+		return conf, nil, errors.New("set COURIER_SNAPSHOT_KEY to the base64 encoded 32 byte key that snapshots are encrypted with")
+	}
+	return conf, key, nil
+}
+
+// Write the snapshot to the file at the location or, if the location is s3:key or
+// gcs:key, to the object at the key in the bucket of the enabled backend.
+func writeSnapshot(ctx context.Context, conf config.Config, location string, data []byte) (err error) {
+	var (
+		bucket snapshot.Bucket
+		key    string
+	)
+	if bucket, key, err = openBucket(conf, location); err != nil {
+		return err
+	}
+
+	if bucket == nil {
+		return os.WriteFile(location, data, 0600)
+	}
+	defer bucket.Close()
+	return bucket.PutObject(ctx, key, data)
+}
+
+// Read the snapshot from the file at the location or, if the location is s3:key or
+// gcs:key, from the object at the key in the bucket of the enabled backend.
+func readSnapshot(ctx context.Context, conf config.Config, location string) (_ []byte, err error) {
+	var (
+		bucket snapshot.Bucket
+		key    string
+	)
+	if bucket, key, err = openBucket(conf, location); err != nil {
+		return nil, err
+	}
+
+	if bucket == nil {
+		return os.ReadFile(location)
+	}
+	defer bucket.Close()
+	return bucket.GetObject(ctx, key)
+}
+
+// Open the bucket of the s3 or gcs backend if the location is an object key in it,
+// otherwise the location is a file path and the bucket is nil.
+func openBucket(conf config.Config, location string) (_ snapshot.Bucket, key string, err error) {
+	backend, key, ok := strings.Cut(location, ":")
+	if !ok || (backend != "s3" && backend != "gcs") {
+		return nil, "", nil
+	}
+
+	if key == "" {
+		return nil, "", fmt.Errorf("missing object key in %q", location)
+	}
+
+	if err = conf.ValidateBackend(backend); err != nil {
+		return nil, "", err
+	}
+
+	switch backend {
+	case "s3":
+		var db *s3.Store
+		if db, err = s3.Open(conf.S3); err != nil {
+			return nil, "", err
+		}
+		return db, key, nil
+	default:
+		var db *gcs.Store
+		if db, err = gcs.Open(conf.GCS); err != nil {
+			return nil, "", err
+		}
+		return db, key, nil
+	}
+}
+
+//===========================================================================
+// Secrets Actions
+//===========================================================================
+
 // Get a secret from the secret manager.
 func getSecret(c *cli.Context) (err error) {
 	conf := config.GCPSecretsConfig{
@@ -334,6 +1255,65 @@ func getSecret(c *cli.Context) (err error) {
 	return nil
 }
 
+// Validate that a project is ready for the secret manager backend, creating the missing
+// service account and iam binding if requested, and emit the equivalent terraform so
+// that the setup can be reviewed and managed with the rest of the infrastructure.
+func bootstrapGCP(c *cli.Context) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var bootstrapper *secrets.Bootstrapper
+	if bootstrapper, err = secrets.NewBootstrapper(ctx, c.String("credentials")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	opts := secrets.BootstrapOptions{
+		Project:        c.String("project"),
+		Member:         c.String("member"),
+		ServiceAccount: c.String("service-account"),
+		Apply:          c.Bool("apply"),
+	}
+
+	var report *secrets.BootstrapReport
+	if report, err = bootstrapper.Bootstrap(ctx, opts); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	check := func(ok, created bool, desc string) {
+		switch {
+		case created:
+			fmt.Printf("created  %s\n", desc)
+		case ok:
+			fmt.Printf("ok       %s\n", desc)
+		default:
+			fmt.Printf("missing  %s\n", desc)
+		}
+	}
+
+	check(report.APIEnabled, false, secrets.SecretManagerService+" api is enabled in "+report.Project)
+	if report.ServiceAccount != "" {
+		check(report.ServiceAccountExists, report.ServiceAccountCreated, "service account "+report.ServiceAccount)
+	}
+	check(report.Bound, report.BindingCreated, report.Role+" is granted to "+report.Member)
+
+	if path := c.String("terraform"); path != "" {
+		if err = os.WriteFile(path, []byte(report.Terraform()), 0644); err != nil {
+			return cli.Exit(err, 1)
+		}
+		fmt.Printf("wrote the equivalent terraform to %s\n", path)
+	} else {
+		fmt.Printf("\n%s", report.Terraform())
+	}
+
+	if !report.Ready() {
+		if !report.APIEnabled {
+			return cli.Exit("enable the secret manager api in the project, e.g. gcloud services enable "+secrets.SecretManagerService, 1)
+		}
+		return cli.Exit("the project is not ready for courier, rerun with --apply to create the missing resources", 1)
+	}
+	return nil
+}
+
 //===========================================================================
 // Helpers
 //===========================================================================
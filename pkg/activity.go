@@ -0,0 +1,104 @@
+package courier
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+)
+
+// Activity is counted in one minute slices over the last hour so that a status poll
+// reveals whether the server has recently been doing useful work in addition to the
+// totals since it was started.
+const (
+	activitySlice  = time.Minute
+	activityWindow = time.Hour
+	activitySlices = int(activityWindow / activitySlice)
+)
+
+// Kinds of activity that are counted.
+const (
+	activityPasswordStored = iota
+	activityCertificateStored
+	activityFailure
+)
+
+// RecordFailures counts the requests to the resource routes that fail with a server
+// error so that failures are reported by the status endpoint.
+func (s *Server) RecordFailures() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			s.activity.record(activityFailure, time.Now())
+		}
+	}
+}
+
+// recordEvent counts the passwords and certificates that are stored by their events.
+func (s *Server) recordEvent(eventType string) {
+	switch eventType {
+	case api.EventPasswordStored:
+		s.activity.record(activityPasswordStored, time.Now())
+	case api.EventCertificateStored:
+		s.activity.record(activityCertificateStored, time.Now())
+	}
+}
+
+// activity counts the passwords and certificates stored and the failed requests since
+// the server was started and in each slice of the last hour.
+type activity struct {
+	sync.Mutex
+	total  api.ActivityCounts
+	slices [activitySlices]activitySliceCounts
+}
+
+type activitySliceCounts struct {
+	start  time.Time
+	counts api.ActivityCounts
+}
+
+func newActivity() *activity {
+	return &activity{}
+}
+
+func (a *activity) record(kind int, now time.Time) {
+	a.Lock()
+	defer a.Unlock()
+
+	start := now.Truncate(activitySlice)
+	slice := &a.slices[int(start.Unix()/int64(activitySlice.Seconds()))%activitySlices]
+	if !slice.start.Equal(start) {
+		slice.start, slice.counts = start, api.ActivityCounts{}
+	}
+
+	for _, counts := range []*api.ActivityCounts{&a.total, &slice.counts} {
+		switch kind {
+		case activityPasswordStored:
+			counts.PasswordsStored++
+		case activityCertificateStored:
+			counts.CertificatesStored++
+		case activityFailure:
+			counts.Failures++
+		}
+	}
+}
+
+// counters returns the totals since the server started and the counts of the slices
+// in the last hour.
+func (a *activity) counters(started, now time.Time) *api.StatusCounters {
+	a.Lock()
+	defer a.Unlock()
+
+	out := &api.StatusCounters{Since: started, Total: a.total}
+	oldest := now.Truncate(activitySlice).Add(-activityWindow)
+	for _, slice := range a.slices {
+		if slice.start.After(oldest) {
+			out.LastHour.PasswordsStored += slice.counts.PasswordsStored
+			out.LastHour.CertificatesStored += slice.counts.CertificatesStored
+			out.LastHour.Failures += slice.counts.Failures
+		}
+	}
+	return out
+}
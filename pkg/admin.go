@@ -0,0 +1,162 @@
+package courier
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/auth"
+	"github.com/trisacrypto/courier/pkg/middleware"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/migrate"
+	"github.com/trisacrypto/courier/pkg/store/snapshot"
+)
+
+// AdminAuth returns middleware that authenticates requests to the admin api with the
+// admin authenticator chain, which accepts the bearer token in the configuration by
+// default. If the chain cannot authenticate any request, e.g. the token is not
+// configured, the admin api is disabled and a 404 is returned.
+func (s *Server) AdminAuth() gin.HandlerFunc {
+	authenticate := auth.Middleware(s.admin, "courier admin")
+	return func(c *gin.Context) {
+		if !s.conf.AdminEnabled() {
+			c.AbortWithStatusJSON(http.StatusNotFound, api.ErrorResponse("admin api is not enabled"))
+			return
+		}
+		authenticate(c)
+	}
+}
+
+// AdminConfig returns the effective configuration of the server with secrets redacted
+// so that operators can confirm what a running server is configured with.
+func (s *Server) AdminConfig(c *gin.Context) {
+	config, err := s.conf.Effective()
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("could not gather the effective configuration")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not get the server configuration"))
+		return
+	}
+
+	c.JSON(http.StatusOK, &api.ConfigReply{Config: config})
+}
+
+// AdminSnapshot returns a snapshot of every password, certificate, and secret in the
+// storage backend, encrypted with the snapshot key so that it can be stored outside of
+// courier and restored into any storage backend.
+func (s *Server) AdminSnapshot(c *gin.Context) {
+	key, err := s.conf.Snapshot.Secret()
+	if err != nil || key == nil {
+		c.JSON(http.StatusNotFound, api.ErrorResponse("snapshots are not enabled"))
+		return
+	}
+
+	var snap *snapshot.Snapshot
+	if snap, err = snapshot.Take(c.Request.Context(), s.store); err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("could not take a snapshot of the store")
+		c.JSON(http.StatusServiceUnavailable, api.ErrorResponse("could not take a snapshot of the store"))
+		return
+	}
+
+	var data []byte
+	if data, err = snap.Encrypt(key); err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("could not encrypt the snapshot")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not take a snapshot of the store"))
+		return
+	}
+
+	counts := snap.Counts()
+	middleware.Logger(c).Info().
+		Str("audit", "snapshot").
+		Int("passwords", counts.Passwords).
+		Int("certificates", counts.Certificates).
+		Int("secrets", counts.Secrets).
+		Msg("snapshot of the store taken")
+
+	filename := fmt.Sprintf("courier-%s.snapshot", snap.Created.Format("20060102T150405Z"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, api.ContentTypeOctetStream, data)
+}
+
+// AdminRestore restores an encrypted snapshot in the request body into the storage
+// backend. Resources that already exist with different data are reported as failed
+// unless the overwrite query parameter is true, and the dry_run query parameter
+// reports the resources that would be restored without writing them.
+func (s *Server) AdminRestore(c *gin.Context) {
+	key, err := s.conf.Snapshot.Secret()
+	if err != nil || key == nil {
+		c.JSON(http.StatusNotFound, api.ErrorResponse("snapshots are not enabled"))
+		return
+	}
+
+	var opts migrate.Options
+	if param := c.Query("dry_run"); param != "" {
+		if opts.DryRun, err = strconv.ParseBool(param); err != nil {
+			c.JSON(http.StatusBadRequest, api.ErrorResponse("could not parse dry_run query parameter"))
+			return
+		}
+	}
+
+	if param := c.Query("overwrite"); param != "" {
+		if opts.Overwrite, err = strconv.ParseBool(param); err != nil {
+			c.JSON(http.StatusBadRequest, api.ErrorResponse("could not parse overwrite query parameter"))
+			return
+		}
+	}
+
+	var snap *snapshot.Snapshot
+	if snap, err = snapshot.Read(c.Request.Body, key); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(fmt.Errorf("could not read snapshot: %w", err)))
+		return
+	}
+
+	var report *migrate.Report
+	if report, err = snapshot.Restore(c.Request.Context(), s.store, snap, opts); err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("could not restore the snapshot")
+		c.JSON(http.StatusServiceUnavailable, api.ErrorResponse("could not restore the snapshot"))
+		return
+	}
+
+	out := &api.RestoreReply{
+		Restored:  report.Copied,
+		Planned:   report.Planned,
+		Unchanged: report.Unchanged,
+		Skipped:   report.Skipped,
+		Failed:    report.Failed,
+		Resources: make([]*api.RestoredResource, 0, len(report.Results)),
+	}
+
+	for _, result := range report.Results {
+		resource := &api.RestoredResource{
+			Resource: result.Prefix,
+			ID:       result.Name,
+			Status:   result.Status,
+			Checksum: result.Checksum,
+		}
+		if result.Err != nil {
+			resource.Error = result.Err.Error()
+		}
+		out.Resources = append(out.Resources, resource)
+
+		if result.Status == migrate.StatusCopied {
+			switch result.Prefix {
+			case store.PasswordPrefix:
+				s.publish(api.EventPasswordStored, result.Name)
+			case store.CertificatePrefix:
+				s.publish(api.EventCertificateStored, result.Name)
+			}
+		}
+	}
+
+	middleware.Logger(c).Info().
+		Str("audit", "restore").
+		Bool("dry_run", opts.DryRun).
+		Bool("overwrite", opts.Overwrite).
+		Time("snapshot", snap.Created).
+		Int("restored", report.Copied).
+		Int("unchanged", report.Unchanged).
+		Int("failed", report.Failed).
+		Msg("snapshot restored")
+	c.JSON(http.StatusOK, out)
+}
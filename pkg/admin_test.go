@@ -0,0 +1,250 @@
+package courier_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+	"github.com/trisacrypto/courier/pkg/store/migrate"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+func (s *courierTestSuite) TestAdminConfig() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.Run("Disabled", func() {
+		_, err := s.client.AdminConfig(ctx)
+		s.CheckHTTPStatus(err, http.StatusNotFound, "expected the admin api to be disabled without a token")
+	})
+
+	conf := testConfig()
+	conf.AdminToken = "admin-token"
+	conf.Codec.EncryptionKey = "c3VwZXJzZWNyZXRzcXVpcnJlbDEyMzQ1Njc4OTAxMjM="
+	srv, client, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	s.Run("Unauthenticated", func() {
+		_, err := client.AdminConfig(ctx)
+		s.CheckHTTPStatus(err, http.StatusUnauthorized, "expected a token to be required")
+
+		wrong, err := api.New(srv.URL(), api.WithRetries(0), api.WithAdminToken("wrong-token"))
+		require.NoError(err, "could not create client")
+		_, err = wrong.AdminConfig(ctx)
+		s.CheckHTTPStatus(err, http.StatusUnauthorized, "expected the token to be checked")
+	})
+
+	s.Run("HappyPath", func() {
+		admin, err := api.New(srv.URL(), api.WithRetries(0), api.WithAdminToken("admin-token"))
+		require.NoError(err, "could not create client")
+
+		rep, err := admin.AdminConfig(ctx)
+		require.NoError(err, "could not get the admin config")
+		require.Equal(conf.BindAddr, rep.Config["COURIER_BIND_ADDR"])
+		require.Equal("true", rep.Config["COURIER_LOCAL_STORAGE_ENABLED"])
+		require.Equal("/tmp/courier", rep.Config["COURIER_LOCAL_STORAGE_PATH"])
+		require.Equal(config.Redacted, rep.Config["COURIER_ADMIN_TOKEN"])
+		require.Equal(config.Redacted, rep.Config["COURIER_CODEC_ENCRYPTION_KEY"])
+	})
+}
+
+func (s *courierTestSuite) TestAdminSnapshot() {
+	require := s.Require()
+	ctx := context.Background()
+
+	conf := testConfig()
+	conf.AdminToken = "admin-token"
+	srv, _, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	admin, err := api.New(srv.URL(), api.WithRetries(0), api.WithAdminToken("admin-token"))
+	require.NoError(err, "could not create client")
+
+	s.Run("Disabled", func() {
+		_, err := admin.AdminSnapshot(ctx)
+		s.CheckHTTPStatus(err, http.StatusNotFound, "expected snapshots to be disabled without a key")
+	})
+
+	conf.Snapshot.Key = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+	srv, _, _ = s.startServer(conf)
+	defer srv.Shutdown()
+
+	admin, err = api.New(srv.URL(), api.WithRetries(0), api.WithAdminToken("admin-token"))
+	require.NoError(err, "could not create client")
+
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(err, "could not open memory store")
+	srv.SetStore(db)
+
+	require.NoError(db.UpdatePassword(ctx, "alice", []byte("password")))
+	require.NoError(db.UpdateCertificate(ctx, "alice", []byte("certificate")))
+	require.NoError(db.UpdateSecret(ctx, "carol", []byte("secret")))
+
+	snapshot, err := admin.AdminSnapshot(ctx)
+	require.NoError(err, "could not take a snapshot")
+	require.NotContains(string(snapshot), "certificate", "expected the snapshot to be encrypted")
+
+	s.Run("Restore", func() {
+		// Restore the snapshot into an empty store
+		restored, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+		require.NoError(err, "could not open memory store")
+		srv.SetStore(restored)
+
+		rep, err := admin.AdminRestore(ctx, bytes.NewReader(snapshot), &api.RestoreOptions{DryRun: true})
+		require.NoError(err, "could not restore the snapshot")
+		require.Equal(3, rep.Planned)
+
+		rep, err = admin.AdminRestore(ctx, bytes.NewReader(snapshot), nil)
+		require.NoError(err, "could not restore the snapshot")
+		require.Equal(3, rep.Restored)
+		require.Zero(rep.Failed)
+		require.Len(rep.Resources, 3)
+		require.Equal(store.PasswordPrefix, rep.Resources[0].Resource)
+		require.Equal(migrate.StatusCopied, rep.Resources[0].Status)
+
+		cert, err := restored.GetCertificate(ctx, "alice")
+		require.NoError(err)
+		require.Equal([]byte("certificate"), cert)
+
+		// Restoring again leaves the resources unchanged
+		rep, err = admin.AdminRestore(ctx, bytes.NewReader(snapshot), nil)
+		require.NoError(err, "could not restore the snapshot")
+		require.Equal(3, rep.Unchanged)
+	})
+
+	s.Run("BadRequest", func() {
+		_, err := admin.AdminRestore(ctx, bytes.NewReader([]byte("not a snapshot")), nil)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "expected an invalid snapshot to be rejected")
+
+		_, err = admin.AdminRestore(ctx, bytes.NewReader(snapshot[:len(snapshot)-1]), nil)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "expected a modified snapshot to be rejected")
+	})
+}
+
+func (s *courierTestSuite) TestAdminVerify() {
+	require := s.Require()
+	ctx := context.Background()
+
+	conf := testConfig()
+	conf.AdminToken = "admin-token"
+	conf.Verification.Expiring = 30 * 24 * time.Hour
+	srv, _, db := s.startServer(conf)
+	defer srv.Shutdown()
+
+	admin, err := api.New(srv.URL(), api.WithRetries(0), api.WithAdminToken("admin-token"))
+	require.NoError(err, "could not create client")
+
+	s.Run("NoReport", func() {
+		_, err := admin.AdminVerification(ctx)
+		s.CheckHTTPStatus(err, http.StatusNotFound, "expected no report before a verification")
+	})
+
+	// The fixture expired in 2022 but is signed by the root in its chain
+	sz, err := trust.NewSerializer(true, "supersecretsquirrel")
+	require.NoError(err, "could not create serializer")
+	provider, err := sz.ReadFile("testdata/cert.zip")
+	require.NoError(err, "could not read cert fixture")
+	expired, err := provider.Encode()
+	require.NoError(err, "could not encode cert fixture")
+	encrypted, err := provider.Encrypt("supersecretsquirrel")
+	require.NoError(err, "could not encrypt cert fixture")
+
+	certs := map[string][]byte{
+		"valid":     selfSignedCertificate(s.T(), 365*24*time.Hour),
+		"expiring":  selfSignedCertificate(s.T(), 24*time.Hour),
+		"expired":   expired,
+		"encrypted": encrypted,
+		"invalid":   []byte("-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydGlmaWNhdGU=\n-----END CERTIFICATE-----\n"),
+	}
+
+	db.OnList = func(ctx context.Context, prefix string) ([]string, error) {
+		require.Equal(store.CertificatePrefix, prefix, "only certificates should be verified")
+		return []string{"corrupted", "deleted", "encrypted", "expired", "expiring", "invalid", "valid"}, nil
+	}
+	db.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		switch name {
+		case "corrupted":
+			return nil, store.ErrCorrupted
+		case "deleted":
+			return nil, store.ErrNotFound
+		}
+		return certs[name], nil
+	}
+
+	s.Run("Verify", func() {
+		rep, err := admin.AdminVerify(ctx)
+		require.NoError(err, "could not verify certificates")
+		require.Equal(1, rep.Valid)
+		require.Equal(1, rep.Expiring)
+		require.Equal(1, rep.Encrypted)
+		require.Equal(3, rep.Failed)
+		require.Len(rep.Certificates, 6, "deleted certificates should not be reported")
+
+		for _, cert := range rep.Certificates {
+			require.Equal(cert.ID, cert.Status, "wrong verification result for %s", cert.ID)
+			switch cert.ID {
+			case "corrupted":
+				require.Empty(cert.Checksum)
+				require.NotEmpty(cert.Error)
+			case "invalid":
+				require.Equal(store.Checksum(certs[cert.ID]), cert.Checksum)
+				require.NotEmpty(cert.Error)
+			case "encrypted":
+				require.Nil(cert.NotAfter, "encrypted certificates cannot be parsed")
+			default:
+				require.NotNil(cert.NotAfter)
+				require.Empty(cert.Error)
+			}
+		}
+
+		last, err := admin.AdminVerification(ctx)
+		require.NoError(err, "could not get the verification report")
+		require.Equal(rep, last, "the report should be kept")
+	})
+
+	s.Run("ListError", func() {
+		db.OnList = func(ctx context.Context, prefix string) ([]string, error) {
+			return nil, store.ErrUnavailable
+		}
+
+		_, err := admin.AdminVerify(ctx)
+		s.CheckHTTPStatus(err, http.StatusServiceUnavailable, "expected an error if certificates cannot be listed")
+	})
+}
+
+// Returns a PEM encoded self-signed certificate and private key that expires after the
+// duration.
+func selfSignedCertificate(t *testing.T, expires time.Duration) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err, "could not generate key")
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "courier.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(expires),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err, "could not create certificate")
+
+	cert, err := trust.PEMEncodeCertificate(&x509.Certificate{Raw: der})
+	require.NoError(t, err, "could not encode certificate")
+
+	pk, err := trust.PEMEncodePrivateKey(key)
+	require.NoError(t, err, "could not encode private key")
+	return append(cert, pk...)
+}
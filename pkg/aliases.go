@@ -0,0 +1,189 @@
+package courier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// Aliases are stored as generic secrets whose name is the alias with the reserved
+// prefix and whose data is the id of the certificate that the alias resolves to, so
+// that aliases are kept by every storage backend and included in snapshots. Secret
+// names with the prefix are rejected by the secret routes.
+const aliasPrefix = "courier-alias-"
+
+// aliasSecret returns the name of the secret that stores the alias.
+func aliasSecret(alias string) string {
+	return aliasPrefix + alias
+}
+
+// ResolveAlias returns middleware that replaces the id in the URL of certificate
+// requests with the id that it is an alias of, so that a certificate can be delivered
+// and retrieved by any of the keys that identify it, e.g. a registration id and a
+// common name. Ids that are not aliases are unchanged. Aliases are resolved on a best
+// effort basis: if the alias cannot be read the request continues with the id in the
+// URL and the handler reports the store failure.
+func (s *Server) ResolveAlias() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := s.store.GetSecret(c.Request.Context(), aliasSecret(c.Param("id")))
+		switch {
+		case err == nil:
+			for i := range c.Params {
+				if c.Params[i].Key == "id" {
+					c.Params[i].Value = string(id)
+				}
+			}
+		case !errors.Is(err, store.ErrNotFound):
+			log.Warn().Err(err).Str("id", c.Param("id")).Msg("could not resolve certificate alias")
+		}
+		c.Next()
+	}
+}
+
+// ListAliases returns the aliases that resolve to the certificate id in sorted order.
+func (s *Server) ListAliases(c *gin.Context) {
+	id := c.Param("id")
+	aliases, err := s.aliases(c.Request.Context(), id)
+	if err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, &api.AliasesReply{ID: id, Aliases: aliases})
+}
+
+// AddAlias registers the alias in the URL for the certificate id and returns a 204 No
+// Content response. Registering an alias that already resolves to the id has no
+// effect. A 409 Conflict is returned if the alias resolves to another certificate or
+// a certificate is stored with the alias as its id, since the alias would hide it.
+// The certificate does not have to be stored yet so that aliases can be registered
+// before the certificate is delivered.
+func (s *Server) AddAlias(c *gin.Context) {
+	var (
+		err     error
+		current []byte
+	)
+
+	ctx := c.Request.Context()
+	id, alias := c.Param("id"), c.Param("alias")
+	if alias == id {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse("a certificate id cannot be an alias of itself"))
+		return
+	}
+
+	if current, err = s.store.GetSecret(ctx, aliasSecret(alias)); err == nil {
+		if string(current) != id {
+			c.JSON(http.StatusConflict, api.ErrorResponse("alias is registered for another certificate"))
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	} else if !errors.Is(err, store.ErrNotFound) {
+		storeError(c, err, "alias not found")
+		return
+	}
+
+	var exists bool
+	if exists, err = s.store.Exists(ctx, store.CertificatePrefix, alias); err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	if exists {
+		c.JSON(http.StatusConflict, api.ErrorResponse("a certificate is stored with the alias as its id"))
+		return
+	}
+
+	if err = s.store.UpdateSecret(ctx, aliasSecret(alias), []byte(id)); err != nil {
+		storeError(c, err, "alias not found")
+		return
+	}
+
+	log.Info().Str("id", id).Str("alias", alias).Msg("certificate alias registered")
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteAlias removes the alias in the URL of the certificate id and returns a 204 No
+// Content response. A 404 is returned if the alias does not resolve to the id. Aliases
+// are not removed when the certificate is deleted so that they resolve to a reissued
+// certificate that is stored with the same id.
+func (s *Server) DeleteAlias(c *gin.Context) {
+	var (
+		err     error
+		current []byte
+	)
+
+	ctx := c.Request.Context()
+	id, alias := c.Param("id"), c.Param("alias")
+	if current, err = s.store.GetSecret(ctx, aliasSecret(alias)); err != nil {
+		storeError(c, err, "alias not found")
+		return
+	}
+
+	if string(current) != id {
+		c.JSON(http.StatusNotFound, api.ErrorResponse("alias not found"))
+		return
+	}
+
+	if err = s.store.DeleteSecret(ctx, aliasSecret(alias)); err != nil {
+		storeError(c, err, "alias not found")
+		return
+	}
+
+	// Cached responses of requests made with the alias are removed with the id
+	s.invalidate(id)
+
+	log.Info().Str("id", id).Str("alias", alias).Msg("certificate alias deleted")
+	c.Status(http.StatusNoContent)
+}
+
+// aliases returns the aliases that resolve to the id by reading every alias secret.
+func (s *Server) aliases(ctx context.Context, id string) (aliases []string, err error) {
+	var names []string
+	if names, err = s.store.List(ctx, store.SecretPrefix); err != nil {
+		return nil, err
+	}
+
+	aliases = make([]string, 0)
+	for _, name := range names {
+		if !strings.HasPrefix(name, aliasPrefix) {
+			continue
+		}
+
+		var target []byte
+		if target, err = s.store.GetSecret(ctx, name); err != nil {
+			// The alias was deleted since the secrets were listed
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		if bytes.Equal(target, []byte(id)) {
+			aliases = append(aliases, strings.TrimPrefix(name, aliasPrefix))
+		}
+	}
+
+	sort.Strings(aliases)
+	return aliases, nil
+}
+
+// reservedName returns middleware that rejects requests for secrets whose name is
+// reserved for the aliases of certificates.
+func reservedName(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Param(param), aliasPrefix) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, api.ErrorResponse(param+" is reserved by courier"))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,107 @@
+package courier_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+)
+
+func (s *courierTestSuite) TestAliases() {
+	require := s.Require()
+	ctx := context.Background()
+
+	conf := testConfig()
+	conf.AllowSecretRetrieval = true
+	srv, client, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(err, "could not open in-memory store")
+	srv.SetStore(db)
+
+	// Aliases can be registered before the certificate is delivered
+	require.NoError(client.AddAlias(ctx, "certID", "registration-uuid"), "could not add alias")
+	require.NoError(client.AddAlias(ctx, "certID", "example_com"), "could not add alias")
+	require.NoError(client.AddAlias(ctx, "certID", "example_com"), "adding an existing alias should have no effect")
+
+	s.Run("Deliver", func() {
+		req := &api.StoreCertificateRequest{ID: "registration-uuid", NoDecrypt: true, Base64Certificate: base64.StdEncoding.EncodeToString([]byte("certificate"))}
+		require.NoError(client.StoreCertificate(ctx, req), "could not store certificate with alias")
+
+		data, err := db.GetCertificate(ctx, "certID")
+		require.NoError(err, "certificate should be stored with the id of the alias")
+		require.Equal([]byte("certificate"), data)
+
+		exists, err := db.Exists(ctx, store.CertificatePrefix, "registration-uuid")
+		require.NoError(err)
+		require.False(exists, "certificate should not be stored with the alias")
+	})
+
+	s.Run("Retrieve", func() {
+		for _, id := range []string{"certID", "registration-uuid", "example_com"} {
+			rep, err := client.RetrieveCertificate(ctx, id)
+			require.NoError(err, "could not retrieve certificate with %q", id)
+			require.Equal(base64.StdEncoding.EncodeToString([]byte("certificate")), rep.Base64Certificate)
+		}
+	})
+
+	s.Run("List", func() {
+		rep, err := client.ListAliases(ctx, "certID")
+		require.NoError(err, "could not list aliases")
+		require.Equal("certID", rep.ID)
+		require.Equal([]string{"example_com", "registration-uuid"}, rep.Aliases)
+
+		rep, err = client.ListAliases(ctx, "example_com")
+		require.NoError(err, "could not list aliases with an alias")
+		require.Equal("certID", rep.ID, "the alias should resolve to the id")
+
+		rep, err = client.ListAliases(ctx, "otherID")
+		require.NoError(err, "could not list aliases")
+		require.Empty(rep.Aliases)
+	})
+
+	s.Run("Conflict", func() {
+		err := client.AddAlias(ctx, "otherID", "example_com")
+		s.CheckHTTPStatus(err, http.StatusConflict, "an alias cannot resolve to two ids")
+
+		require.NoError(db.UpdateCertificate(ctx, "otherID", []byte("other")))
+		err = client.AddAlias(ctx, "certID", "otherID")
+		s.CheckHTTPStatus(err, http.StatusConflict, "an alias cannot hide a stored certificate")
+
+		err = client.AddAlias(ctx, "certID", "certID")
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "an id cannot be an alias of itself")
+
+		err = client.AddAlias(ctx, "certID", "example.com")
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "aliases must be valid resource names")
+	})
+
+	s.Run("ReservedSecret", func() {
+		_, err := client.RetrieveSecret(ctx, "courier-alias-example_com")
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "alias secrets should not be accessible")
+
+		err = client.DeleteSecret(ctx, "courier-alias-example_com")
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "alias secrets should not be accessible")
+	})
+
+	s.Run("Delete", func() {
+		err := client.DeleteAlias(ctx, "otherID", "example_com")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "the alias does not resolve to the id")
+
+		require.NoError(client.DeleteAlias(ctx, "certID", "example_com"), "could not delete alias")
+
+		_, err = client.RetrieveCertificate(ctx, "example_com")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "deleted alias should not resolve")
+
+		err = client.DeleteAlias(ctx, "certID", "example_com")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "alias was already deleted")
+
+		rep, err := client.ListAliases(ctx, "certID")
+		require.NoError(err, "could not list aliases")
+		require.Equal([]string{"registration-uuid"}, rep.Aliases)
+	})
+}
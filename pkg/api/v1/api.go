@@ -1,11 +1,77 @@
 package api
 
-import "context"
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Content types of certificate uploads. ContentTypeOctetStream and ContentTypePKCS12
+// are used to upload raw pkcs12 certificate data without wrapping it in a JSON request
+// and ContentTypePEM is used for PEM encoded certificate chains, which are stored
+// without decryption. The PKCS12 and PEM content types are also used to describe the
+// base64 encoded certificate in a JSON request.
+const (
+	ContentTypeOctetStream = "application/octet-stream"
+	ContentTypePKCS12      = "application/x-pkcs12"
+	ContentTypePEM         = "application/x-pem-file"
+)
+
+// HeaderResourceSize is the size in bytes of the stored resource that is returned by
+// HEAD requests so that its size is known without downloading it.
+const HeaderResourceSize = "Courier-Resource-Size"
+
+// HeaderCache is hit if a cacheable response was served from the response cache and
+// miss if it was not.
+const HeaderCache = "Courier-Cache"
+
+// SimulationID is the id reserved for the throwaway certificate and password that are
+// delivered by a delivery simulation. Other requests for the id are rejected.
+const SimulationID = "courier-simulation"
 
 type CourierClient interface {
 	Status(context.Context) (*StatusReply, error)
+	Versions(context.Context) (*VersionsReply, error)
+	Stats(context.Context) (*StatsReply, error)
+	BuildInfo(context.Context) (*BuildInfoReply, error)
 	StoreCertificate(context.Context, *StoreCertificateRequest) error
+	UploadCertificate(ctx context.Context, id string, cert io.Reader, noDecrypt bool) error
+	RetrieveCertificate(ctx context.Context, id string) (*CertificateReply, error)
+	PickupCertificate(ctx context.Context, id string) (*CertificateReply, error)
+	RetrieveCertificateIfNoneMatch(ctx context.Context, id, etag string) (*CertificateReply, error)
+	StatCertificate(ctx context.Context, id string) (*ResourceInfo, error)
+	WaitForCertificate(ctx context.Context, id string, timeout time.Duration, withPassword bool) (*CertificateReply, error)
+	DeleteCertificate(ctx context.Context, id string) error
+	CertificateDetails(ctx context.Context, id string) (*CertificateDetailsReply, error)
+	CertificateMetadata(ctx context.Context, id string) (*MetadataReply, error)
+	PublicCertificate(ctx context.Context, id string) ([]byte, error)
+	PublicCertificateURL(ctx context.Context, id string, ttl time.Duration) (*SignedURLReply, error)
+	DownloadCertificate(ctx context.Context, id string, w io.Writer, resume *ResourceInfo) (*DownloadReply, error)
+	ListCertificateVersions(ctx context.Context, id string) (*CertificateVersionsReply, error)
+	RetrieveCertificateVersion(ctx context.Context, id, version string) (*CertificateReply, error)
+	RestoreCertificateVersion(ctx context.Context, id, version string) error
 	StoreCertificatePassword(context.Context, *StorePasswordRequest) error
+	RetrieveCertificatePassword(ctx context.Context, id string) (*PasswordReply, error)
+	PickupCertificatePassword(ctx context.Context, id string) (*PasswordReply, error)
+	StatCertificatePassword(ctx context.Context, id string) (*ResourceInfo, error)
+	DeleteCertificatePassword(ctx context.Context, id string) error
+	ListAliases(ctx context.Context, id string) (*AliasesReply, error)
+	AddAlias(ctx context.Context, id, alias string) error
+	DeleteAlias(ctx context.Context, id, alias string) error
+	StoreSecret(context.Context, *StoreSecretRequest) error
+	RetrieveSecret(ctx context.Context, name string) (*SecretReply, error)
+	DeleteSecret(ctx context.Context, name string) error
+	Events(ctx context.Context, handler func(*Event) error) error
+	Subscribe(ctx context.Context, ids []string, handler func(*Event) error) error
+	TraceDump(context.Context) (*TraceReply, error)
+	AdminConfig(context.Context) (*ConfigReply, error)
+	Simulate(context.Context) (*SimulationReply, error)
+	AdminSnapshot(context.Context) ([]byte, error)
+	AdminRestore(ctx context.Context, snapshot io.Reader, opts *RestoreOptions) (*RestoreReply, error)
+	AdminVerify(context.Context) (*VerificationReply, error)
+	AdminVerification(context.Context) (*VerificationReply, error)
+	Bulk(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error
 }
 
 // Reply encodes generic JSON responses from the API.
@@ -14,19 +80,310 @@ type Reply struct {
 	Error   string `json:"error,omitempty"`
 }
 
+// VersionMismatchReply is returned when a conditional update fails because the stored
+// resource does not match the expected version.
+type VersionMismatchReply struct {
+	Success        bool   `json:"success"`
+	Error          string `json:"error,omitempty"`
+	CurrentVersion string `json:"current_version,omitempty"`
+}
+
 type StatusReply struct {
-	Status  string `json:"status"`
-	Uptime  string `json:"uptime,omitempty"`
-	Version string `json:"version,omitempty"`
+	Status   string          `json:"status"`
+	Uptime   string          `json:"uptime,omitempty"`
+	Version  string          `json:"version,omitempty"`
+	Counters *StatusCounters `json:"counters,omitempty"`
+}
+
+// StatusCounters reports the activity of the server since it was started and in the
+// last hour so that a status poll reveals whether it has been doing useful work.
+type StatusCounters struct {
+	Since    time.Time      `json:"since"`
+	Total    ActivityCounts `json:"total"`
+	LastHour ActivityCounts `json:"last_hour"`
+}
+
+type ActivityCounts struct {
+	PasswordsStored    int64 `json:"passwords_stored"`
+	CertificatesStored int64 `json:"certificates_stored"`
+	Failures           int64 `json:"failures"`
+}
+
+type BuildInfoReply struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+type StatsReply struct {
+	Certificates *int        `json:"certificates,omitempty"`
+	Passwords    *int        `json:"passwords,omitempty"`
+	Secrets      *int        `json:"secrets,omitempty"`
+	LastDelivery *time.Time  `json:"last_delivery,omitempty"`
+	Store        StoreHealth `json:"store"`
+}
+
+// ConfigReply contains the effective configuration of the server by environment
+// variable, with the values of secrets redacted.
+type ConfigReply struct {
+	Config map[string]string `json:"config"`
+}
+
+// SimulationReply contains the result of a simulated delivery and the timing of each
+// of its steps. Success is false if any step failed.
+type SimulationReply struct {
+	ID       string            `json:"id"`
+	Success  bool              `json:"success"`
+	Duration string            `json:"duration"`
+	Steps    []*SimulationStep `json:"steps"`
+}
+
+// SimulationStep describes a step of a simulated delivery. The status is the HTTP
+// status of the request made by the step, if any.
+type SimulationStep struct {
+	Name     string `json:"name"`
+	Status   int    `json:"status,omitempty"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RestoreOptions control how a snapshot is restored into the storage backend. Resources
+// that exist with different data are only replaced if overwrite is true.
+type RestoreOptions struct {
+	DryRun    bool
+	Overwrite bool
+}
+
+// RestoreReply summarizes the restore of a snapshot into the storage backend.
+type RestoreReply struct {
+	Restored  int                 `json:"restored"`
+	Planned   int                 `json:"planned"`
+	Unchanged int                 `json:"unchanged"`
+	Skipped   int                 `json:"skipped"`
+	Failed    int                 `json:"failed"`
+	Resources []*RestoredResource `json:"resources"`
+}
+
+// RestoredResource describes the restore of a single resource in a snapshot. The
+// checksum is the hex encoded SHA-256 digest of the resource in the snapshot.
+type RestoredResource struct {
+	Resource string `json:"resource"`
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Checksum string `json:"checksum,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// VerificationReply reports the result of verifying every stored certificate. Every
+// certificate that is not valid, expiring, or encrypted is counted as failed.
+type VerificationReply struct {
+	Started      time.Time              `json:"started"`
+	Finished     time.Time              `json:"finished"`
+	Valid        int                    `json:"valid"`
+	Expiring     int                    `json:"expiring"`
+	Encrypted    int                    `json:"encrypted"`
+	Failed       int                    `json:"failed"`
+	Certificates []*VerifiedCertificate `json:"certificates"`
+}
+
+// Results of verifying a stored certificate. Encrypted certificates were stored without
+// decryption, so only their checksum can be verified.
+const (
+	VerificationValid     = "valid"
+	VerificationExpiring  = "expiring"
+	VerificationEncrypted = "encrypted"
+	VerificationExpired   = "expired"
+	VerificationInvalid   = "invalid"
+	VerificationCorrupted = "corrupted"
+	VerificationError     = "error"
+)
+
+// VerifiedCertificate describes the verification of a single stored certificate. The
+// checksum is the hex encoded SHA-256 digest of the stored data and the expiration is
+// only set if the certificate could be parsed.
+type VerifiedCertificate struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	NotAfter *time.Time `json:"not_after,omitempty"`
+	Checksum string     `json:"checksum,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// TraceReply contains the most recently traced requests, newest first.
+type TraceReply struct {
+	Requests []*TracedRequest `json:"requests"`
+}
+
+// TracedRequest records the headers of a request and its response for debugging.
+// Credentials are redacted from the headers and the bodies of routes that carry
+// certificates, passwords, or secrets are redacted; other bodies are truncated.
+type TracedRequest struct {
+	Timestamp       time.Time   `json:"timestamp"`
+	Duration        string      `json:"duration"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	Proto           string      `json:"proto"`
+	ClientIP        string      `json:"client_ip"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestSize     int64       `json:"request_size"`
+	RequestBody     string      `json:"request_body,omitempty"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	ResponseSize    int64       `json:"response_size"`
+	ResponseBody    string      `json:"response_body,omitempty"`
+}
+
+type StoreHealth struct {
+	Backend string `json:"backend"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
 }
 
 type StoreCertificateRequest struct {
 	ID                string `json:"id"`
 	NoDecrypt         bool   `json:"no_decrypt"`
+	ContentType       string `json:"content_type,omitempty"`
+	ExpectedVersion   string `json:"expected_version,omitempty"`
+	Base64Certificate string `json:"base64_certificate"`
+}
+
+// Types of the delivery events sent on the event stream.
+const (
+	EventPasswordStored       = "password_stored"
+	EventPasswordDeleted      = "password_deleted"
+	EventCertificateStored    = "certificate_stored"
+	EventCertificateDeleted   = "certificate_deleted"
+	EventCertificateDecrypted = "certificate_decrypted"
+	EventCertificateArchived  = "certificate_archived"
+	EventExternalChange       = "external_change"
+	EventSubscribed           = "subscribed"
+	EventUnsubscribed         = "unsubscribed"
+	EventNotFound             = "not_found"
+)
+
+// Event describes a change to the password or certificate stored with the id. The
+// fingerprint is the SHA-256 digest of the leaf certificate and is only set for
+// certificate events if the stored certificate is not encrypted. External change
+// events report a file of the resource that was created, modified, or removed outside
+// of courier, with the type of the resource and the kind of change.
+type Event struct {
+	Type        string    `json:"type"`
+	ID          string    `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Fingerprint string    `json:"sha256_fingerprint,omitempty"`
+	Resource    string    `json:"resource,omitempty"`
+	Change      string    `json:"change,omitempty"`
+}
+
+// Actions of the subscription requests sent on the notifications websocket.
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+)
+
+// SubscriptionRequest adds or removes the ids whose events are sent on the
+// notifications websocket. Each id is acknowledged with a subscribed or unsubscribed
+// event, or with a not found event if the id cannot be subscribed.
+type SubscriptionRequest struct {
+	Action string   `json:"action"`
+	IDs    []string `json:"ids"`
+}
+
+type CertificateReply struct {
+	ID                string `json:"id"`
+	Version           string `json:"version,omitempty"`
 	Base64Certificate string `json:"base64_certificate"`
+	ETag              string `json:"-"`
+}
+
+type CertificateVersionsReply struct {
+	ID       string                `json:"id"`
+	Versions []*CertificateVersion `json:"versions"`
+}
+
+type CertificateVersion struct {
+	Version string    `json:"version"`
+	Created time.Time `json:"created"`
+	State   string    `json:"state"`
+}
+
+type CertificateDetailsReply struct {
+	ID                string    `json:"id"`
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SerialNumber      string    `json:"serial_number"`
+	DNSNames          []string  `json:"dns_names,omitempty"`
+	IPAddresses       []string  `json:"ip_addresses,omitempty"`
+	EmailAddresses    []string  `json:"email_addresses,omitempty"`
+	URIs              []string  `json:"uris,omitempty"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	SHA256Fingerprint string    `json:"sha256_fingerprint"`
 }
 
+// MetadataReply describes when the resource stored with the id was written and how it
+// is stored without returning its data. The size and digest are of the stored data.
+// Created and Encrypted are omitted if the storage backend does not record them.
+type MetadataReply struct {
+	ID        string     `json:"id"`
+	Created   *time.Time `json:"created,omitempty"`
+	Updated   time.Time  `json:"updated"`
+	SHA256    string     `json:"sha256"`
+	Size      int64      `json:"size"`
+	Encrypted *bool      `json:"encrypted,omitempty"`
+}
+
+// AliasesReply lists the aliases that resolve to the certificate id in sorted order.
+type AliasesReply struct {
+	ID      string   `json:"id"`
+	Aliases []string `json:"aliases"`
+}
+
+// StorePasswordRequest stores the pkcs12 password of a certificate. If an encrypted
+// certificate is already stored with the id the password must decrypt it, otherwise a
+// 409 Conflict is returned unless Force is set.
 type StorePasswordRequest struct {
 	ID       string `json:"id"`
 	Password string `json:"password"`
+	Force    bool   `json:"force,omitempty"`
+}
+
+type PasswordReply struct {
+	ID       string `json:"id"`
+	Password string `json:"password"`
+}
+
+// ResourceInfo describes a stored resource without its data, as returned by HEAD
+// requests. The ETag is only returned for certificates.
+type ResourceInfo struct {
+	ID   string
+	ETag string
+	Size int64
+}
+
+// DownloadReply describes the certificate data written by a download. If a download was
+// resumed, the offset is the number of bytes that were skipped; it is zero if the
+// certificate changed since the partial download and was downloaded in full.
+type DownloadReply struct {
+	ETag   string
+	Offset int64
+	Size   int64
+}
+
+// SignedURLReply contains a url that downloads a published artifact directly from the
+// object storage backend without credentials until it expires.
+type SignedURLReply struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+type StoreSecretRequest struct {
+	Name       string `json:"name"`
+	Base64Data string `json:"base64_data"`
+}
+
+type SecretReply struct {
+	Name       string `json:"name"`
+	Base64Data string `json:"base64_data"`
 }
@@ -3,14 +3,23 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog"
+	"github.com/trisacrypto/courier/pkg/websocket"
 )
 
 const DefaultRetries = 3
@@ -21,15 +30,19 @@ func DefaultBackoff() BackoffFactory {
 	}
 }
 
-// New creates a new API client that implements the CourierClient interface.
+// New creates a new API client that implements the CourierClient interface. The
+// endpoint may be a comma separated list of urls, e.g. of a primary and a standby
+// courier instance, in which case requests fail over to the next url when a connection
+// cannot be established. Urls with an srv+https or srv+http scheme are discovered from
+// the SRV records of their host, e.g. srv+https://_courier._tcp.example.com.
 func New(endpoint string, opts ...ClientOption) (_ CourierClient, err error) {
 	if endpoint == "" {
 		return nil, ErrEndpointRequired
 	}
 
-	// Create a client with the parsed endpoint.
-	c := &APIv1{retries: -1}
-	if c.url, err = url.Parse(endpoint); err != nil {
+	// Create a client with the parsed endpoints.
+	c := &APIv1{retries: -1, log: zerolog.Nop(), timeouts: DefaultTimeouts()}
+	if c.endpoints, err = parseEndpoints(endpoint); err != nil {
 		return nil, err
 	}
 
@@ -40,81 +53,834 @@ func New(endpoint string, opts ...ClientOption) (_ CourierClient, err error) {
 		}
 	}
 
-	// If a client hasn't been specified, create the default client.
-	if c.client == nil {
-		c.client = &http.Client{
-			Transport:     nil,
-			CheckRedirect: nil,
-			Timeout:       30 * time.Second,
+	// Create the http clients with the configured timeouts.
+	c.client = &http.Client{
+		Transport: c.transport(c.timeouts.ResponseHeader),
+		Timeout:   c.timeouts.Total,
+	}
+
+	// Long polls and streams wait for the server before it responds, so the response
+	// header timeout and total timeout are not applied to them.
+	c.stream = &http.Client{
+		Transport: c.transport(0),
+	}
+
+	// If backoff hasn't been specified add the default backoff factory
+	if c.backoff == nil {
+		c.backoff = DefaultBackoff()
+	}
+
+	// If retries haven't been specified add the default number of retries
+	if c.retries < 0 {
+		c.retries = DefaultRetries
+	}
+
+	return c, nil
+}
+
+// APIv1 implements the CourierClient interface.
+type APIv1 struct {
+	endpoints      *endpoints
+	client         *http.Client
+	stream         *http.Client
+	tlsConfig      *tls.Config
+	timeouts       Timeouts
+	backoff        BackoffFactory
+	retries        int
+	idempotentOnly bool
+	onDeprecated   DeprecationHandler
+	log            zerolog.Logger
+	budget         *RetryBudget
+	limiter        chan struct{}
+	adminToken     string
+}
+
+var _ CourierClient = &APIv1{}
+
+//===========================================================================
+// Client Methods
+//===========================================================================
+
+// Status returns the status of the courier service.
+func (c *APIv1) Status(ctx context.Context) (out *StatusReply, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/status", nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	var rep *http.Response
+	if rep, err = c.client.Do(req); err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+
+	// Catch status errors
+	if rep.StatusCode != http.StatusOK && rep.StatusCode != http.StatusServiceUnavailable {
+		return nil, NewStatusError(rep.StatusCode, rep.Status)
+	}
+
+	// Decode the response
+	out = &StatusReply{}
+	if err = json.NewDecoder(rep.Body).Decode(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Versions returns the API versions served by the courier service, allowing the
+// caller to negotiate which version of the API to use.
+func (c *APIv1) Versions(ctx context.Context) (out *VersionsReply, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/versions", nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &VersionsReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BuildInfo returns the version, git commit, build date, and go runtime version of the
+// courier server.
+func (c *APIv1) BuildInfo(ctx context.Context) (out *BuildInfoReply, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/version", nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &BuildInfoReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Stats returns the number of stored certificates and passwords, the time of the last
+// certificate delivery, and the health of the storage backend.
+func (c *APIv1) Stats(ctx context.Context) (out *StatsReply, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/stats", nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &StatsReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TraceDump returns the most recent requests traced by the server, which must have
+// request tracing enabled and requires the client to be created with the admin token.
+func (c *APIv1) TraceDump(ctx context.Context) (out *TraceReply, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/admin/debug/requests", nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &TraceReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminConfig returns the effective runtime configuration of the server, which requires
+// the client to be created with the admin token of the server.
+func (c *APIv1) AdminConfig(ctx context.Context) (out *ConfigReply, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/admin/config", nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &ConfigReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminSnapshot returns a snapshot of every resource in the storage backend of the
+// server, encrypted with the snapshot key of the server.
+func (c *APIv1) AdminSnapshot(ctx context.Context) (_ []byte, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/admin/snapshot", nil, nil); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentTypeOctetStream)
+
+	// Do the request
+	out := &bytes.Buffer{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// AdminRestore restores an encrypted snapshot into the storage backend of the server.
+func (c *APIv1) AdminRestore(ctx context.Context, snapshot io.Reader, opts *RestoreOptions) (out *RestoreReply, err error) {
+	var params *url.Values
+	if opts != nil {
+		params = &url.Values{}
+		if opts.DryRun {
+			params.Set("dry_run", "true")
+		}
+		if opts.Overwrite {
+			params.Set("overwrite", "true")
+		}
+	}
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, "/v1/admin/restore", snapshot, params); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &RestoreReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminVerify verifies every certificate stored by the server and returns the report.
+func (c *APIv1) AdminVerify(ctx context.Context) (out *VerificationReply, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, "/v1/admin/verify", nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &VerificationReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminVerification returns the report of the most recent verification of the stored
+// certificates, whether it was scheduled or requested by an operator.
+func (c *APIv1) AdminVerification(ctx context.Context) (out *VerificationReply, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/admin/verify", nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &VerificationReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Simulate runs a synthetic delivery of a throwaway certificate and password through
+// the server and its storage backend and returns the result of each step, which
+// requires the client to be created with the admin token of the server.
+func (c *APIv1) Simulate(ctx context.Context) (out *SimulationReply, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, "/v1/admin/simulate", nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &SimulationReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StoreCertificate stores the certificate in the request.
+func (c *APIv1) StoreCertificate(ctx context.Context, in *StoreCertificateRequest) (err error) {
+	if in.ID == "" {
+		return ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s", in.ID)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, path, in, nil); err != nil {
+		return err
+	}
+
+	// Do the request
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UploadCertificate streams the raw pkcs12 certificate data to the server so that it
+// does not have to be base64 encoded and wrapped in JSON. The upload is only retried
+// if the reader can be rewound (e.g. a *bytes.Reader or *strings.Reader).
+func (c *APIv1) UploadCertificate(ctx context.Context, id string, cert io.Reader, noDecrypt bool) (err error) {
+	if id == "" {
+		return ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s", id)
+
+	var params *url.Values
+	if noDecrypt {
+		params = &url.Values{"no_decrypt": []string{strconv.FormatBool(noDecrypt)}}
+	}
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, path, cert, params); err != nil {
+		return err
+	}
+
+	// Do the request
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RetrieveCertificate returns the base64 encoded certificate stored with the id.
+func (c *APIv1) RetrieveCertificate(ctx context.Context, id string) (out *CertificateReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	var rep *http.Response
+	out = &CertificateReply{}
+	if rep, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+
+	out.ETag = rep.Header.Get("ETag")
+	return out, nil
+}
+
+// PickupCertificate retrieves the certificate stored with the id and deletes it from
+// the server so that it cannot be retrieved again. A 404 status error is returned if
+// the certificate does not exist or was already picked up.
+func (c *APIv1) PickupCertificate(ctx context.Context, id string) (out *CertificateReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s", id)
+	params := &url.Values{"once": []string{"true"}}
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, params); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	var rep *http.Response
+	out = &CertificateReply{}
+	if rep, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+
+	out.ETag = rep.Header.Get("ETag")
+	return out, nil
+}
+
+// RetrieveCertificateIfNoneMatch retrieves the certificate stored with the id unless
+// its ETag matches the etag from a previous retrieval, in which case ErrNotModified is
+// returned so that polling clients do not repeatedly download unchanged certificates.
+func (c *APIv1) RetrieveCertificateIfNoneMatch(ctx context.Context, id, etag string) (out *CertificateReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	// Do the request
+	var rep *http.Response
+	out = &CertificateReply{}
+	if rep, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+
+	if rep.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
+
+	out.ETag = rep.Header.Get("ETag")
+	return out, nil
+}
+
+// StatCertificate checks that a certificate is stored with the id and returns its ETag
+// and size without downloading it, e.g. to poll cheaply for a delivery to complete. A
+// 404 status error is returned if the certificate does not exist.
+func (c *APIv1) StatCertificate(ctx context.Context, id string) (out *ResourceInfo, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s", id)
+	return c.stat(ctx, id, path)
+}
+
+// WaitForCertificate blocks until the certificate with the id is stored or the timeout
+// expires, in which case a 404 status error is returned. If withPassword is true the
+// request also waits for the pkcs12 password to be stored. Long polls are not retried
+// and the timeout of the http client must be longer than the timeout of the request.
+func (c *APIv1) WaitForCertificate(ctx context.Context, id string, timeout time.Duration, withPassword bool) (out *CertificateReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/wait", id)
+	params := &url.Values{}
+	if timeout > 0 {
+		params.Set("timeout", timeout.String())
+	}
+	if withPassword {
+		params.Set("password", strconv.FormatBool(withPassword))
+	}
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, params); err != nil {
+		return nil, err
+	}
+
+	// Do the request without retries since the server has already waited
+	var rep *http.Response
+	out = &CertificateReply{}
+	if rep, err = c.waiting(timeout).do(req, out, true); err != nil {
+		return nil, err
+	}
+
+	out.ETag = rep.Header.Get("ETag")
+	return out, nil
+}
+
+// ListCertificateVersions returns the versions of the certificate stored with the id,
+// newest first.
+func (c *APIv1) ListCertificateVersions(ctx context.Context, id string) (out *CertificateVersionsReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/versions", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &CertificateVersionsReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RetrieveCertificateVersion retrieves a specific version of the certificate stored
+// with the id.
+func (c *APIv1) RetrieveCertificateVersion(ctx context.Context, id, version string) (out *CertificateReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	if version == "" {
+		return nil, ErrVersionRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/versions/%s", id, version)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &CertificateReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RestoreCertificateVersion stores a prior version of the certificate with the id as
+// its latest version, e.g. to roll back an accidental overwrite.
+func (c *APIv1) RestoreCertificateVersion(ctx context.Context, id, version string) (err error) {
+	if id == "" {
+		return ErrIDRequired
+	}
+
+	if version == "" {
+		return ErrVersionRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/versions/%s/restore", id, version)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, path, nil, nil); err != nil {
+		return err
+	}
+
+	// Do the request
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteCertificate removes the certificate stored with the id.
+func (c *APIv1) DeleteCertificate(ctx context.Context, id string) (err error) {
+	if id == "" {
+		return ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodDelete, path, nil, nil); err != nil {
+		return err
+	}
+
+	// Do the request
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CertificateDetails returns metadata parsed from the certificate stored with the id
+// without downloading any private key material.
+func (c *APIv1) CertificateDetails(ctx context.Context, id string) (out *CertificateDetailsReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/details", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &CertificateDetailsReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CertificateMetadata returns when the certificate stored with the id was written and
+// how it is stored without downloading it.
+func (c *APIv1) CertificateMetadata(ctx context.Context, id string) (out *MetadataReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/metadata", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &MetadataReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PublicCertificate returns the PEM encoded leaf certificate and chain stored with the
+// id without the private key.
+func (c *APIv1) PublicCertificate(ctx context.Context, id string) (_ []byte, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/public", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentTypePEM)
+
+	// Do the request
+	out := &bytes.Buffer{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// PublicCertificateURL publishes the PEM encoded leaf certificate and chain stored with
+// the id to the object storage backend and returns a url that downloads it directly
+// from the backend until the ttl expires. If the ttl is zero the server default is used.
+func (c *APIv1) PublicCertificateURL(ctx context.Context, id string, ttl time.Duration) (out *SignedURLReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/public/url", id)
+	params := &url.Values{}
+	if ttl > 0 {
+		params.Set("ttl", ttl.String())
+	}
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, path, nil, params); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &SignedURLReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DownloadCertificate writes the raw PEM or pkcs12 data of the certificate stored with
+// the id to the writer. To resume an interrupted download, pass the ETag of the partial
+// download and the number of bytes that were already written as the size; only the
+// remaining bytes are written unless the certificate changed, in which case the full
+// certificate is written and the offset of the reply is zero so that the partial
+// download can be discarded.
+func (c *APIv1) DownloadCertificate(ctx context.Context, id string, w io.Writer, resume *ResourceInfo) (out *DownloadReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/download", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentTypeOctetStream)
+
+	if resume != nil && resume.Size > 0 && resume.ETag != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resume.Size))
+		req.Header.Set("If-Range", resume.ETag)
+	}
+
+	// Do the request
+	var rep *http.Response
+	if rep, err = c.Do(req, w, true); err != nil {
+		// The partial download is already complete if there are no remaining bytes
+		var serr *StatusError
+		if rep == nil || resume == nil || !errors.As(err, &serr) || serr.Code != http.StatusRequestedRangeNotSatisfiable {
+			return nil, err
+		}
+
+		var size int64
+		if _, err = fmt.Sscanf(rep.Header.Get("Content-Range"), "bytes */%d", &size); err != nil || size != resume.Size {
+			return nil, serr
 		}
+		return &DownloadReply{ETag: resume.ETag, Offset: size, Size: size}, nil
 	}
 
-	// If backoff hasn't been specified add the default backoff factory
-	if c.backoff == nil {
-		c.backoff = DefaultBackoff()
+	out = &DownloadReply{ETag: rep.Header.Get("ETag"), Size: rep.ContentLength}
+	if rep.StatusCode == http.StatusPartialContent {
+		var last int64
+		if _, err = fmt.Sscanf(rep.Header.Get("Content-Range"), "bytes %d-%d/%d", &out.Offset, &last, &out.Size); err != nil {
+			return nil, fmt.Errorf("could not parse content range: %w", err)
+		}
 	}
+	return out, nil
+}
 
-	// If retries haven't been specified add the default number of retries
-	if c.retries < 0 {
-		c.retries = DefaultRetries
+// StoreCertificatePassword stores a password for an encrypted certificate.
+func (c *APIv1) StoreCertificatePassword(ctx context.Context, in *StorePasswordRequest) (err error) {
+	if in.ID == "" {
+		return ErrIDRequired
 	}
 
-	return c, nil
+	path := fmt.Sprintf("/v1/certs/%s/pkcs12password", in.ID)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, path, in, nil); err != nil {
+		return err
+	}
+
+	// Do the request
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+	return nil
 }
 
-// APIv1 implements the CourierClient interface.
-type APIv1 struct {
-	url     *url.URL
-	client  *http.Client
-	backoff BackoffFactory
-	retries int
+// RetrieveCertificatePassword returns the pkcs12 password stored with the id. The
+// server must be configured to allow password retrieval.
+func (c *APIv1) RetrieveCertificatePassword(ctx context.Context, id string) (out *PasswordReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/pkcs12password", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &PasswordReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-var _ CourierClient = &APIv1{}
+// PickupCertificatePassword retrieves the pkcs12 password stored with the id and
+// deletes it from the server so that it cannot be retrieved again. The server must be
+// configured to allow password retrieval.
+func (c *APIv1) PickupCertificatePassword(ctx context.Context, id string) (out *PasswordReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
 
-//===========================================================================
-// Client Methods
-//===========================================================================
+	path := fmt.Sprintf("/v1/certs/%s/pkcs12password", id)
+	params := &url.Values{"once": []string{"true"}}
 
-// Status returns the status of the courier service.
-func (c *APIv1) Status(ctx context.Context) (out *StatusReply, err error) {
 	// Create the HTTP request
 	var req *http.Request
-	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/status", nil, nil); err != nil {
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, params); err != nil {
 		return nil, err
 	}
 
 	// Do the request
-	var rep *http.Response
-	if rep, err = c.client.Do(req); err != nil {
+	out = &PasswordReply{}
+	if _, err = c.Do(req, out, true); err != nil {
 		return nil, err
 	}
-	defer rep.Body.Close()
+	return out, nil
+}
 
-	// Catch status errors
-	if rep.StatusCode != http.StatusOK && rep.StatusCode != http.StatusServiceUnavailable {
-		return nil, NewStatusError(rep.StatusCode, rep.Status)
+// StatCertificatePassword checks that a pkcs12 password is stored with the id and
+// returns its size without retrieving it. The server must be configured to allow
+// password retrieval. A 404 status error is returned if the password does not exist.
+func (c *APIv1) StatCertificatePassword(ctx context.Context, id string) (out *ResourceInfo, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
 	}
 
-	// Decode the response
-	out = &StatusReply{}
-	if err = json.NewDecoder(rep.Body).Decode(out); err != nil {
+	path := fmt.Sprintf("/v1/certs/%s/pkcs12password", id)
+	return c.stat(ctx, id, path)
+}
+
+// DeleteCertificatePassword removes the pkcs12 password stored with the id, e.g. after
+// the certificate has been decrypted.
+func (c *APIv1) DeleteCertificatePassword(ctx context.Context, id string) (err error) {
+	if id == "" {
+		return ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/pkcs12password", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodDelete, path, nil, nil); err != nil {
+		return err
+	}
+
+	// Do the request
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListAliases returns the aliases that resolve to the certificate id.
+func (c *APIv1) ListAliases(ctx context.Context, id string) (out *AliasesReply, err error) {
+	if id == "" {
+		return nil, ErrIDRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/aliases", id)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &AliasesReply{}
+	if _, err = c.Do(req, out, true); err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-// StoreCertificate stores the certificate in the request.
-func (c *APIv1) StoreCertificate(ctx context.Context, in *StoreCertificateRequest) (err error) {
-	if in.ID == "" {
+// AddAlias registers the alias for the certificate id so that the certificate can be
+// stored and retrieved with either of them.
+func (c *APIv1) AddAlias(ctx context.Context, id, alias string) (err error) {
+	if id == "" {
 		return ErrIDRequired
 	}
 
-	path := fmt.Sprintf("/v1/certs/%s", in.ID)
+	if alias == "" {
+		return ErrAliasRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/aliases/%s", id, alias)
 
 	// Create the HTTP request
 	var req *http.Request
-	if req, err = c.NewRequest(ctx, http.MethodPost, path, in, nil); err != nil {
+	if req, err = c.NewRequest(ctx, http.MethodPut, path, nil, nil); err != nil {
 		return err
 	}
 
@@ -125,17 +891,85 @@ func (c *APIv1) StoreCertificate(ctx context.Context, in *StoreCertificateReques
 	return nil
 }
 
-// StoreCertificatePassword stores a password for an encrypted certificate.
-func (c *APIv1) StoreCertificatePassword(ctx context.Context, in *StorePasswordRequest) (err error) {
-	if in.ID == "" {
+// DeleteAlias removes the alias of the certificate id.
+func (c *APIv1) DeleteAlias(ctx context.Context, id, alias string) (err error) {
+	if id == "" {
 		return ErrIDRequired
 	}
 
-	path := fmt.Sprintf("/v1/certs/%s/pkcs12password", in.ID)
+	if alias == "" {
+		return ErrAliasRequired
+	}
+
+	path := fmt.Sprintf("/v1/certs/%s/aliases/%s", id, alias)
 
 	// Create the HTTP request
 	var req *http.Request
-	if req, err = c.NewRequest(ctx, http.MethodPost, path, in, nil); err != nil {
+	if req, err = c.NewRequest(ctx, http.MethodDelete, path, nil, nil); err != nil {
+		return err
+	}
+
+	// Do the request
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// StoreSecret stores the base64 encoded secret data with the name in the request.
+func (c *APIv1) StoreSecret(ctx context.Context, in *StoreSecretRequest) (err error) {
+	if in.Name == "" {
+		return ErrNameRequired
+	}
+
+	path := fmt.Sprintf("/v1/secrets/%s", in.Name)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPut, path, in, nil); err != nil {
+		return err
+	}
+
+	// Do the request
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RetrieveSecret returns the base64 encoded secret data stored with the name.
+func (c *APIv1) RetrieveSecret(ctx context.Context, name string) (out *SecretReply, err error) {
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+
+	path := fmt.Sprintf("/v1/secrets/%s", name)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	out = &SecretReply{}
+	if _, err = c.Do(req, out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeleteSecret deletes the secret stored with the name.
+func (c *APIv1) DeleteSecret(ctx context.Context, name string) (err error) {
+	if name == "" {
+		return ErrNameRequired
+	}
+
+	path := fmt.Sprintf("/v1/secrets/%s", name)
+
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodDelete, path, nil, nil); err != nil {
 		return err
 	}
 
@@ -146,6 +980,91 @@ func (c *APIv1) StoreCertificatePassword(ctx context.Context, in *StorePasswordR
 	return nil
 }
 
+// Events subscribes to the delivery event stream and calls the handler with each event
+// until the context is canceled, the server closes the stream, or the handler returns
+// an error, which is returned by Events. A nil error is returned if the server closed
+// the stream, e.g. because it is shutting down, in which case the caller should
+// reconnect. Event streams are not retried and the timeout of the http client does not
+// apply since the stream is long lived.
+func (c *APIv1) Events(ctx context.Context, handler func(*Event) error) (err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/events", nil, nil); err != nil {
+		return err
+	}
+	req.Header.Set("Accept", ContentTypeEventStream)
+
+	// Do the request without retries, dispatching events as they are read
+	stream := &eventStream{handler: handler}
+	if _, err = c.streaming().do(req, stream, true); err != nil {
+		switch {
+		case stream.err != nil:
+			return stream.err
+		case ctx.Err() != nil:
+			return ctx.Err()
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe opens the notifications websocket, subscribes to the events of the ids,
+// and calls the handler with each event until the context is canceled, the server
+// closes the connection, or the handler returns an error, which is returned by
+// Subscribe. The handler first receives a subscribed event for each id, after which no
+// events for the id will be missed. A nil error is returned if the server closed the
+// connection, e.g. because it is shutting down, in which case the caller should
+// reconnect. Subscriptions are not retried.
+func (c *APIv1) Subscribe(ctx context.Context, ids []string, handler func(*Event) error) (err error) {
+	if len(ids) == 0 {
+		return ErrIDRequired
+	}
+
+	header := make(http.Header)
+	header.Set("User-Agent", userAgent)
+	header.Set(HeaderAPIVersion, Version)
+
+	var base *url.URL
+	if base, err = c.endpoints.current(ctx); err != nil {
+		return err
+	}
+	endpoint := base.ResolveReference(&url.URL{Path: "/v1/ws"})
+
+	var conn *websocket.Conn
+	if conn, err = websocket.Dial(ctx, endpoint.String(), header, c.tlsConfig); err != nil {
+		return err
+	}
+	defer conn.Close(websocket.CloseNormal, "")
+
+	// Close the connection when the context is canceled to interrupt reads
+	stop := context.AfterFunc(ctx, func() { conn.Close(websocket.CloseNormal, "") })
+	defer stop()
+
+	if err = conn.WriteJSON(&SubscriptionRequest{Action: ActionSubscribe, IDs: ids}); err != nil {
+		return err
+	}
+
+	for {
+		event := &Event{}
+		if err = conn.ReadJSON(event); err != nil {
+			var closed *websocket.CloseError
+			switch {
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case errors.As(err, &closed) && (closed.Code == websocket.CloseNormal || closed.Code == websocket.CloseGoingAway):
+				return nil
+			default:
+				return err
+			}
+		}
+
+		if err = handler(event); err != nil {
+			return err
+		}
+	}
+}
+
 //===========================================================================
 // Client Helpers
 //===========================================================================
@@ -156,26 +1075,37 @@ const (
 	acceptLang   = "en-US,en"
 	acceptEncode = "gzip, deflate, br"
 	contentType  = "application/json; charset=utf-8"
+	adminPrefix  = "/v1/admin/"
 )
 
 // NewRequest creates an http.Request with the specified context and method, resolving
 // the path to the root endpoint of the API (e.g. /v1) and serializes the data to JSON.
+// If the data is an io.Reader it is streamed as the raw request body instead.
 func (c *APIv1) NewRequest(ctx context.Context, method, path string, data interface{}, params *url.Values) (req *http.Request, err error) {
-	// Resolve the URL reference from the path
-	endpoint := c.url.ResolveReference(&url.URL{Path: path})
+	// Resolve the URL reference from the path against the active endpoint
+	var base *url.URL
+	if base, err = c.endpoints.current(ctx); err != nil {
+		return nil, err
+	}
+
+	endpoint := base.ResolveReference(&url.URL{Path: path})
 	if params != nil && len(*params) > 0 {
 		endpoint.RawQuery = params.Encode()
 	}
 
-	var body io.ReadWriter
-	switch {
-	case data == nil:
+	var body io.Reader
+	ctype := contentType
+	switch data := data.(type) {
+	case nil:
 		body = nil
+	case io.Reader:
+		body, ctype = data, ContentTypeOctetStream
 	default:
-		body = &bytes.Buffer{}
-		if err = json.NewEncoder(body).Encode(data); err != nil {
+		buf := &bytes.Buffer{}
+		if err = json.NewEncoder(buf).Encode(data); err != nil {
 			return nil, err
 		}
+		body = buf
 	}
 
 	// Create the http request
@@ -188,42 +1118,129 @@ func (c *APIv1) NewRequest(ctx context.Context, method, path string, data interf
 	req.Header.Add("Accept", accept)
 	req.Header.Add("Accept-Language", acceptLang)
 	req.Header.Add("Accept-Encoding", acceptEncode)
-	req.Header.Add("Content-Type", contentType)
+	req.Header.Add("Content-Type", ctype)
+	req.Header.Add(HeaderAPIVersion, Version)
+
+	// Only send the admin token to the admin api
+	if c.adminToken != "" && strings.HasPrefix(path, adminPrefix) {
+		req.Header.Set("Authorization", "Bearer "+c.adminToken)
+	}
 
 	return req, nil
 }
 
 // Do executes an http request against the server, performs error checking, and
 // deserializes response data into the specified struct. This function also manages
-// retries using a backoff strategy.
+// retries using a backoff strategy. Requests are never retried if the server
+// responded with a success status code, even if the response could not be processed,
+// since the request may have already modified state on the server. For the same
+// reason, non-idempotent requests are not retried if the request was sent but no
+// response was received. If the client has several endpoints, requests that could not
+// connect are sent to the next endpoint without waiting, which does not count as a
+// retry until every endpoint has been tried.
 func (s *APIv1) Do(req *http.Request, data interface{}, checkStatus bool) (rep *http.Response, err error) {
 	attempts := 0
+	failovers := 0
 	start := time.Now()
 	ctx := req.Context()
 	delay := s.backoff()
 	errs := make([]error, 0, s.retries+1)
 
-	for attempts <= s.retries {
+	retries := s.retries
+	if s.idempotentOnly && !isIdempotent(req.Method) {
+		retries = 0
+	}
+
+	// A streamed request body that cannot be rewound can only be sent once
+	if req.Body != nil && req.GetBody == nil {
+		retries = 0
+	}
+
+	if s.budget != nil {
+		s.budget.request()
+	}
+
+	s.logBody(req)
+	for attempts <= retries+failovers {
 		attempts++
-		if rep, err = s.do(req, data, checkStatus); err == nil {
+
+		// Rewind the request body if it was consumed by a previous attempt
+		if attempts > 1 && req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				errs = append(errs, err)
+				return rep, JoinStatusErrors(attempts, time.Since(start), errs...)
+			}
+		}
+
+		// Wait for a request slot if the number of concurrent requests is limited
+		if err = s.acquire(ctx); err != nil {
+			errs = append(errs, err)
+			return rep, JoinStatusErrors(attempts, time.Since(start), errs...)
+		}
+
+		sent := time.Now()
+		rep, err = s.do(req, data, checkStatus)
+		s.release()
+		s.logAttempt(req, attempts, rep, err, time.Since(sent))
+		if err == nil {
 			// Success!
 			return rep, nil
 		}
 
+		// Do not retry if the request succeeded but the response could not be handled.
+		var partial *PartialSuccessError
+		if errors.As(err, &partial) {
+			s.logRetry(req, attempts, 0, "not retrying request that succeeded with an unreadable response")
+			return rep, err
+		}
+
+		// Do not retry if the request may have been applied without a response.
+		if errors.Is(err, ErrRequestSent) {
+			s.logRetry(req, attempts, 0, "not retrying request that may have been applied without a response")
+			errs = append(errs, err)
+			return rep, JoinStatusErrors(attempts, time.Since(start), errs...)
+		}
+
 		// Failure! Retry as needed.
 		errs = append(errs, err)
 
+		// Send the request to the next endpoint if it could not connect.
+		if isConnectError(err) {
+			req = redirect(req, s.endpoints.failover(req.URL))
+			s.client.CloseIdleConnections()
+			if failovers < s.endpoints.len()-1 {
+				failovers++
+				s.logRetry(req, attempts, 0, "failing over to the next endpoint after a connection error")
+				continue
+			}
+		}
+
+		if attempts > retries+failovers {
+			s.logRetry(req, attempts, 0, "not retrying request after the final attempt")
+			break
+		}
+
 		// Compute the backoff delay before the next request
 		dur := delay.NextBackOff()
 		if dur == backoff.Stop {
 			// Stop indicates no more retries should be allowed.
+			s.logRetry(req, attempts, 0, "not retrying request since the backoff stopped")
+			return rep, JoinStatusErrors(attempts, time.Since(start), errs...)
+		}
+
+		// Do not retry if the retry budget shared with other requests is exhausted.
+		if s.budget != nil && !s.budget.retry() {
+			s.logRetry(req, attempts, 0, "not retrying request since the retry budget is exhausted")
+			errs = append(errs, ErrRetryBudget)
 			return rep, JoinStatusErrors(attempts, time.Since(start), errs...)
 		}
+		s.logRetry(req, attempts, dur, "retrying request after backoff delay")
 
 		// Wait for backoff delay or until context is canceled
 		wait := time.After(dur)
 		select {
 		case <-ctx.Done():
+			s.logRetry(req, attempts, 0, "not retrying request since the context is done")
 			errs = append(errs, ctx.Err())
 			return rep, JoinStatusErrors(attempts, time.Since(start), errs...)
 		case <-wait:
@@ -234,12 +1251,101 @@ func (s *APIv1) Do(req *http.Request, data interface{}, checkStatus bool) (rep *
 	return rep, JoinStatusErrors(attempts, time.Since(start), errs...)
 }
 
+// Sends a HEAD request for the resource and returns its ETag and size.
+func (c *APIv1) stat(ctx context.Context, id, path string) (out *ResourceInfo, err error) {
+	// Create the HTTP request
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodHead, path, nil, nil); err != nil {
+		return nil, err
+	}
+
+	// Do the request
+	var rep *http.Response
+	if rep, err = c.Do(req, nil, true); err != nil {
+		return nil, err
+	}
+
+	out = &ResourceInfo{ID: id, ETag: rep.Header.Get("ETag")}
+	if size := rep.Header.Get(HeaderResourceSize); size != "" {
+		if out.Size, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return nil, &PartialSuccessError{Code: rep.StatusCode, Err: fmt.Errorf("could not parse resource size: %w", err)}
+		}
+	}
+	return out, nil
+}
+
+// Returns a copy of the client without the response header or total timeouts for long
+// lived streams that are only ended by the caller's context or the server.
+func (s *APIv1) streaming() *APIv1 {
+	stream := *s
+	stream.client = s.stream
+	return &stream
+}
+
+// Returns a copy of the client for long polls that wait up to the duration before the
+// server responds, extending the total timeout by the wait. If the wait is zero the
+// server chooses how long to wait so the total timeout is not applied.
+func (s *APIv1) waiting(wait time.Duration) *APIv1 {
+	client := *s.stream
+	if wait > 0 && s.timeouts.Total > 0 {
+		client.Timeout = s.timeouts.Total + wait
+	}
+
+	poll := *s
+	poll.client = &client
+	return &poll
+}
+
+// Creates a transport with the connect and tls handshake timeouts of the client and
+// the response header timeout, using the same defaults as http.DefaultTransport.
+func (s *APIv1) transport(responseHeader time.Duration) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   s.timeouts.Connect,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       s.tlsConfig,
+		TLSHandshakeTimeout:   s.timeouts.TLSHandshake,
+		ResponseHeaderTimeout: responseHeader,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
 func (s *APIv1) do(req *http.Request, data interface{}, checkStatus bool) (rep *http.Response, err error) {
-	if rep, err = s.client.Do(req); err != nil {
+	// Track if the request was written to the server in case no response is received
+	var sent atomic.Bool
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			sent.Store(info.Err == nil)
+		},
+	}
+
+	if rep, err = s.client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace))); err != nil {
+		if sent.Load() && !isIdempotent(req.Method) {
+			return rep, fmt.Errorf("%w: %w", ErrRequestSent, err)
+		}
 		return rep, err
 	}
 	defer rep.Body.Close()
 
+	// Notify the caller if the route is deprecated
+	if s.onDeprecated != nil {
+		if notice := ParseDeprecation(rep.Header); notice != nil {
+			s.onDeprecated(req, notice)
+		}
+	}
+
+	// Conditional requests are not errors if the resource has not been modified
+	if rep.StatusCode == http.StatusNotModified {
+		return rep, nil
+	}
+
 	// Detects http status errors if they've occurred
 	if checkStatus {
 		if rep.StatusCode < 200 || rep.StatusCode >= 300 {
@@ -254,16 +1360,42 @@ func (s *APIv1) do(req *http.Request, data interface{}, checkStatus bool) (rep *
 		}
 	}
 
+	// Copies raw response data such as PEM encoded certificates into the writer
+	if w, ok := data.(io.Writer); ok && rep.StatusCode >= 200 && rep.StatusCode < 300 && rep.StatusCode != http.StatusNoContent {
+		if _, err = io.Copy(w, rep.Body); err != nil {
+			return rep, &PartialSuccessError{Code: rep.StatusCode, Err: fmt.Errorf("could not read response data: %w", err)}
+		}
+		return rep, nil
+	}
+
 	// Deserializes the JSON data from the body
 	if data != nil && rep.StatusCode >= 200 && rep.StatusCode < 300 && rep.StatusCode != http.StatusNoContent {
 		// Checks the content type to ensure data deserialization is possible
 		if ct := rep.Header.Get("Content-Type"); ct != contentType {
-			return rep, fmt.Errorf("unexpected content type: %q", ct)
+			return rep, &PartialSuccessError{Code: rep.StatusCode, Err: fmt.Errorf("unexpected content type: %q", ct)}
 		}
 
 		if err = json.NewDecoder(rep.Body).Decode(data); err != nil {
-			return nil, fmt.Errorf("could not deserialize response data: %s", err)
+			return rep, &PartialSuccessError{Code: rep.StatusCode, Err: fmt.Errorf("could not deserialize response data: %w", err)}
+		}
+		return rep, nil
+	}
+
+	// Read the rest of the response so that an interrupted success is still detected
+	if rep.StatusCode >= 200 && rep.StatusCode < 300 {
+		if _, err = io.Copy(io.Discard, rep.Body); err != nil {
+			return rep, &PartialSuccessError{Code: rep.StatusCode, Err: fmt.Errorf("could not read response: %w", err)}
 		}
 	}
 	return rep, nil
 }
+
+// Returns true if the HTTP method is idempotent and can safely be retried.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
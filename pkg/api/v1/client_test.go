@@ -1,14 +1,24 @@
 package api_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
 	"github.com/trisacrypto/courier/pkg/api/v1"
 )
@@ -40,6 +50,355 @@ func TestStoreCertificate(t *testing.T) {
 	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
 }
 
+func TestUploadCertificate(t *testing.T) {
+	// Create a test server that checks the raw request body
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/v1/certs/1234", r.URL.Path)
+		require.Equal(t, api.ContentTypeOctetStream, r.Header.Get("Content-Type"))
+		require.Equal(t, "true", r.URL.Query().Get("no_decrypt"))
+
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err, "could not read request body")
+		require.Equal(t, []byte("pkcs12-certificate"), data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	err = client.UploadCertificate(context.Background(), "1234", strings.NewReader("pkcs12-certificate"), true)
+	require.NoError(t, err, "could not execute certificate upload request")
+
+	// Should error if there is no ID in the request
+	err = client.UploadCertificate(context.Background(), "", strings.NewReader("pkcs12-certificate"), true)
+	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
+}
+
+func TestRetrieveCertificate(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/v1/certs/1234", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(&api.CertificateReply{ID: "1234", Base64Certificate: "base64-encoded-certificate"})
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	rep, err := client.RetrieveCertificate(context.Background(), "1234")
+	require.NoError(t, err, "could not execute certificate retrieve request")
+	require.Equal(t, "1234", rep.ID)
+	require.Equal(t, "base64-encoded-certificate", rep.Base64Certificate)
+
+	// Should error if there is no ID in the request
+	_, err = client.RetrieveCertificate(context.Background(), "")
+	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
+}
+
+func TestRetrieveCertificateIfNoneMatch(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/v1/certs/1234", r.URL.Path)
+
+		w.Header().Set("ETag", `"abc"`)
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(&api.CertificateReply{ID: "1234", Base64Certificate: "base64-encoded-certificate"})
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL, api.WithRetries(0))
+	require.NoError(t, err, "could not create client")
+
+	rep, err := client.RetrieveCertificateIfNoneMatch(context.Background(), "1234", "")
+	require.NoError(t, err, "could not execute certificate retrieve request")
+	require.Equal(t, "base64-encoded-certificate", rep.Base64Certificate)
+	require.Equal(t, `"abc"`, rep.ETag)
+
+	_, err = client.RetrieveCertificateIfNoneMatch(context.Background(), "1234", rep.ETag)
+	require.ErrorIs(t, err, api.ErrNotModified, "expected not modified if the etag matches")
+
+	// Should error if there is no ID in the request
+	_, err = client.RetrieveCertificateIfNoneMatch(context.Background(), "", "")
+	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
+}
+
+func TestStatCertificate(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodHead, r.Method)
+
+		switch r.URL.Path {
+		case "/v1/certs/1234":
+			w.Header().Set("ETag", `"abc"`)
+			w.Header().Set(api.HeaderResourceSize, "2048")
+		case "/v1/certs/1234/pkcs12password":
+			w.Header().Set(api.HeaderResourceSize, "16")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL, api.WithRetries(0))
+	require.NoError(t, err, "could not create client")
+
+	info, err := client.StatCertificate(context.Background(), "1234")
+	require.NoError(t, err, "could not execute certificate head request")
+	require.Equal(t, &api.ResourceInfo{ID: "1234", ETag: `"abc"`, Size: 2048}, info)
+
+	info, err = client.StatCertificatePassword(context.Background(), "1234")
+	require.NoError(t, err, "could not execute password head request")
+	require.Equal(t, &api.ResourceInfo{ID: "1234", Size: 16}, info)
+
+	// Should return the status code if the resource does not exist
+	_, err = client.StatCertificate(context.Background(), "5678")
+	var serr *api.StatusError
+	require.ErrorAs(t, err, &serr, "expected a status error for a missing certificate")
+	require.Equal(t, http.StatusNotFound, serr.Code)
+
+	// Should error if there is no ID in the request
+	_, err = client.StatCertificate(context.Background(), "")
+	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
+}
+
+func TestWaitForCertificate(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/v1/certs/1234/wait", r.URL.Path)
+		require.Equal(t, "5m0s", r.URL.Query().Get("timeout"))
+		require.Equal(t, "true", r.URL.Query().Get("password"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(&api.CertificateReply{ID: "1234", Base64Certificate: "base64-encoded-certificate"})
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	rep, err := client.WaitForCertificate(context.Background(), "1234", 5*time.Minute, true)
+	require.NoError(t, err, "could not execute wait for certificate request")
+	require.Equal(t, "base64-encoded-certificate", rep.Base64Certificate)
+
+	// Should error if there is no ID in the request
+	_, err = client.WaitForCertificate(context.Background(), "", time.Minute, false)
+	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
+}
+
+func TestCertificateDetails(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/v1/certs/1234/details", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(&api.CertificateDetailsReply{ID: "1234", Subject: "CN=example.com", SHA256Fingerprint: "abcd"})
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	rep, err := client.CertificateDetails(context.Background(), "1234")
+	require.NoError(t, err, "could not execute certificate details request")
+	require.Equal(t, "1234", rep.ID)
+	require.Equal(t, "CN=example.com", rep.Subject)
+	require.Equal(t, "abcd", rep.SHA256Fingerprint)
+
+	// Should error if there is no ID in the request
+	_, err = client.CertificateDetails(context.Background(), "")
+	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
+}
+
+func TestCertificateVersions(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		switch r.URL.Path {
+		case "/v1/certs/1234/versions":
+			json.NewEncoder(w).Encode(&api.CertificateVersionsReply{ID: "1234", Versions: []*api.CertificateVersion{{Version: "1", State: "enabled"}}})
+		case "/v1/certs/1234/versions/1":
+			json.NewEncoder(w).Encode(&api.CertificateReply{ID: "1234", Version: "1", Base64Certificate: "base64-encoded-certificate"})
+		default:
+			t.Fatalf("unexpected request path %q", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	versions, err := client.ListCertificateVersions(context.Background(), "1234")
+	require.NoError(t, err, "could not execute list versions request")
+	require.Len(t, versions.Versions, 1)
+	require.Equal(t, "1", versions.Versions[0].Version)
+
+	rep, err := client.RetrieveCertificateVersion(context.Background(), "1234", "1")
+	require.NoError(t, err, "could not execute retrieve version request")
+	require.Equal(t, "1", rep.Version)
+	require.Equal(t, "base64-encoded-certificate", rep.Base64Certificate)
+
+	// Should error if there is no ID or version in the request
+	_, err = client.ListCertificateVersions(context.Background(), "")
+	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
+	_, err = client.RetrieveCertificateVersion(context.Background(), "1234", "")
+	require.ErrorIs(t, err, api.ErrVersionRequired, "client should error if no version is provided")
+}
+
+func TestBuildInfo(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/v1/version", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(&api.BuildInfoReply{Version: "1.0.0", GitCommit: "abc1234", BuildDate: "2023-09-01T00:00:00Z", GoVersion: "go1.21.0"})
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	rep, err := client.BuildInfo(context.Background())
+	require.NoError(t, err, "could not execute build info request")
+	require.Equal(t, "1.0.0", rep.Version)
+	require.Equal(t, "abc1234", rep.GitCommit)
+	require.Equal(t, "2023-09-01T00:00:00Z", rep.BuildDate)
+	require.Equal(t, "go1.21.0", rep.GoVersion)
+}
+
+func TestStats(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/v1/stats", r.URL.Path)
+		certs := 4
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(&api.StatsReply{Certificates: &certs, Store: api.StoreHealth{Backend: "local", Healthy: true}})
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	rep, err := client.Stats(context.Background())
+	require.NoError(t, err, "could not execute stats request")
+	require.Equal(t, 4, *rep.Certificates)
+	require.Nil(t, rep.Passwords)
+	require.Equal(t, "local", rep.Store.Backend)
+	require.True(t, rep.Store.Healthy)
+}
+
+func TestTraceDump(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/v1/admin/debug/requests", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(&api.TraceReply{Requests: []*api.TracedRequest{{Method: http.MethodGet, URL: "/v1/status", Status: http.StatusOK}}})
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	rep, err := client.TraceDump(context.Background())
+	require.NoError(t, err, "could not execute trace dump request")
+	require.Len(t, rep.Requests, 1)
+	require.Equal(t, "/v1/status", rep.Requests[0].URL)
+	require.Equal(t, http.StatusOK, rep.Requests[0].Status)
+}
+
+func TestSimulate(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/v1/admin/simulate", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(&api.SimulationReply{ID: api.SimulationID, Success: true, Steps: []*api.SimulationStep{{Name: "generate", Duration: "1ms"}}})
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	rep, err := client.Simulate(context.Background())
+	require.NoError(t, err, "could not execute simulate request")
+	require.True(t, rep.Success)
+	require.Equal(t, api.SimulationID, rep.ID)
+	require.Len(t, rep.Steps, 1)
+}
+
+func TestAdminRestore(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/v1/admin/restore", r.URL.Path)
+		require.Equal(t, "Bearer admin-token", r.Header.Get("Authorization"))
+		require.Equal(t, api.ContentTypeOctetStream, r.Header.Get("Content-Type"))
+		require.Equal(t, "true", r.URL.Query().Get("overwrite"))
+		require.Empty(t, r.URL.Query().Get("dry_run"))
+
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, []byte("snapshot"), data)
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(&api.RestoreReply{Restored: 1, Resources: []*api.RestoredResource{{Resource: "secret", ID: "carol", Status: "copied"}}})
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL, api.WithAdminToken("admin-token"))
+	require.NoError(t, err, "could not create client")
+
+	rep, err := client.AdminRestore(context.Background(), bytes.NewReader([]byte("snapshot")), &api.RestoreOptions{Overwrite: true})
+	require.NoError(t, err, "could not execute restore request")
+	require.Equal(t, 1, rep.Restored)
+	require.Len(t, rep.Resources, 1)
+}
+
+func TestDeleteCertificate(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodDelete, r.Method)
+		require.Equal(t, "/v1/certs/1234", r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client method
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	err = client.DeleteCertificate(context.Background(), "1234")
+	require.NoError(t, err, "could not execute certificate delete request")
+
+	// Should error if there is no ID in the request
+	err = client.DeleteCertificate(context.Background(), "")
+	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
+}
+
 func TestStoreCertificatePassword(t *testing.T) {
 	// Create a test server
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -67,6 +426,123 @@ func TestStoreCertificatePassword(t *testing.T) {
 	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
 }
 
+func TestDeprecationHandler(t *testing.T) {
+	deprecated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	version := api.APIVersion{
+		Version:    api.Version,
+		Prefix:     "/v1",
+		Deprecated: &deprecated,
+		Sunset:     &sunset,
+		Successor:  "/v2",
+	}
+
+	// Create a test server that marks the route as deprecated
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, api.Version, r.Header.Get(api.HeaderAPIVersion), "client should send the api version")
+		version.SetHeaders(w.Header())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	// Create a client that records deprecation notices
+	var notice *api.Deprecation
+	client, err := api.New(ts.URL, api.WithDeprecationHandler(func(req *http.Request, n *api.Deprecation) {
+		notice = n
+	}))
+	require.NoError(t, err, "could not create client")
+
+	req := &api.StorePasswordRequest{ID: "1234", Password: "hunter2"}
+	err = client.StoreCertificatePassword(context.Background(), req)
+	require.NoError(t, err, "could not execute password store request")
+
+	require.NotNil(t, notice, "expected deprecation handler to be called")
+	require.Equal(t, api.Version, notice.Version)
+	require.True(t, deprecated.Equal(notice.Deprecated), "wrong deprecation date parsed")
+	require.True(t, sunset.Equal(notice.Sunset), "wrong sunset date parsed")
+	require.Equal(t, "/v2", notice.Successor)
+}
+
+func TestRetrieveAndDeleteCertificatePassword(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/certs/1234/pkcs12password", r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewEncoder(w).Encode(&api.PasswordReply{ID: "1234", Password: "hunter2"})
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client methods
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	rep, err := client.RetrieveCertificatePassword(context.Background(), "1234")
+	require.NoError(t, err, "could not execute password retrieve request")
+	require.Equal(t, "hunter2", rep.Password)
+
+	err = client.DeleteCertificatePassword(context.Background(), "1234")
+	require.NoError(t, err, "could not execute password delete request")
+
+	// Should error if there is no ID in the request
+	_, err = client.RetrieveCertificatePassword(context.Background(), "")
+	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
+	err = client.DeleteCertificatePassword(context.Background(), "")
+	require.ErrorIs(t, err, api.ErrIDRequired, "client should error if no ID is provided")
+}
+
+func TestSecrets(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/secrets/signing-key", r.URL.Path)
+		switch r.Method {
+		case http.MethodPut:
+			in := &api.StoreSecretRequest{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(in), "could not decode secret request")
+			require.Equal(t, "signing-key", in.Name)
+			require.Equal(t, "c2VjcmV0", in.Base64Data)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewEncoder(w).Encode(&api.SecretReply{Name: "signing-key", Base64Data: "c2VjcmV0"})
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer ts.Close()
+
+	// Create a client to test the client methods
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	err = client.StoreSecret(context.Background(), &api.StoreSecretRequest{Name: "signing-key", Base64Data: "c2VjcmV0"})
+	require.NoError(t, err, "could not execute secret store request")
+
+	rep, err := client.RetrieveSecret(context.Background(), "signing-key")
+	require.NoError(t, err, "could not execute secret retrieve request")
+	require.Equal(t, "signing-key", rep.Name)
+	require.Equal(t, "c2VjcmV0", rep.Base64Data)
+
+	err = client.DeleteSecret(context.Background(), "signing-key")
+	require.NoError(t, err, "could not execute secret delete request")
+
+	// Should error if there is no name in the request
+	err = client.StoreSecret(context.Background(), &api.StoreSecretRequest{Base64Data: "c2VjcmV0"})
+	require.ErrorIs(t, err, api.ErrNameRequired, "client should error if no name is provided")
+	_, err = client.RetrieveSecret(context.Background(), "")
+	require.ErrorIs(t, err, api.ErrNameRequired, "client should error if no name is provided")
+	err = client.DeleteSecret(context.Background(), "")
+	require.ErrorIs(t, err, api.ErrNameRequired, "client should error if no name is provided")
+}
+
 func TestRetriesWithBackoff(t *testing.T) {
 	// Create a test server
 	var attempts uint32
@@ -94,3 +570,499 @@ func TestRetriesWithBackoff(t *testing.T) {
 	require.Equal(t, uint32(11), attempts, "expected 10 retry attempts")
 	require.Greater(t, time.Since(start), 950*time.Millisecond, "expected backoff delay")
 }
+
+func TestTimeouts(t *testing.T) {
+	// Create a test server that is slow to respond or to send the response body
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/certs/slow-header", "/v1/certs/1234/wait":
+			time.Sleep(200 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewEncoder(w).Encode(&api.CertificateReply{ID: "1234", Base64Certificate: "base64-encoded-certificate"})
+		case "/v1/certs/slow-body":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+			json.NewEncoder(w).Encode(&api.CertificateReply{ID: "1234", Base64Certificate: "base64-encoded-certificate"})
+		}
+	}))
+	defer ts.Close()
+
+	_, err := api.New(ts.URL, api.WithConnectTimeout(-1*time.Second))
+	require.ErrorIs(t, err, api.ErrInvalidTimeout, "expected a negative timeout to be rejected")
+	_, err = api.New(ts.URL, api.WithTimeouts(api.Timeouts{Total: -1 * time.Second}))
+	require.ErrorIs(t, err, api.ErrInvalidTimeout, "expected a negative timeout to be rejected")
+
+	// The response header timeout should fail slow responses without a total timeout
+	client, err := api.New(ts.URL, api.WithRetries(0), api.WithResponseHeaderTimeout(50*time.Millisecond), api.WithTimeout(0))
+	require.NoError(t, err, "could not create client")
+
+	_, err = client.RetrieveCertificate(context.Background(), "slow-header")
+	require.Error(t, err, "expected the response header timeout to be exceeded")
+
+	rep, err := client.RetrieveCertificate(context.Background(), "slow-body")
+	require.NoError(t, err, "the response header timeout should not apply to the body")
+	require.Equal(t, "base64-encoded-certificate", rep.Base64Certificate)
+
+	// Long polls should not be subject to the response header or total timeout
+	client, err = api.New(ts.URL, api.WithRetries(0), api.WithTimeouts(api.Timeouts{ResponseHeader: 50 * time.Millisecond, Total: 100 * time.Millisecond}))
+	require.NoError(t, err, "could not create client")
+
+	rep, err = client.WaitForCertificate(context.Background(), "1234", time.Second, false)
+	require.NoError(t, err, "long polls should wait for the server")
+	require.Equal(t, "base64-encoded-certificate", rep.Base64Certificate)
+
+	// The total timeout should include reading the response body
+	_, err = client.RetrieveCertificate(context.Background(), "slow-body")
+	require.Error(t, err, "expected the total timeout to be exceeded")
+}
+
+func TestRetryBudget(t *testing.T) {
+	// Create a test server that always fails
+	var attempts uint32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&attempts, 1)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	// Two clients sharing a budget of two retries that is not refilled by requests
+	budget := api.NewRetryBudget(0, 2)
+	clients := make([]api.CourierClient, 2)
+	for i := range clients {
+		var err error
+		clients[i], err = api.New(ts.URL, api.WithRetries(3), api.WithZeroBackoff(), api.WithRetryBudget(budget))
+		require.NoError(t, err, "could not create client")
+	}
+
+	err := clients[0].DeleteCertificate(context.Background(), "1234")
+	require.ErrorIs(t, err, api.ErrRetryBudget, "expected the retry budget to be exhausted")
+	require.Equal(t, uint32(3), atomic.LoadUint32(&attempts), "expected two retries from the budget")
+	require.Zero(t, budget.Available())
+
+	err = clients[1].DeleteCertificate(context.Background(), "1234")
+	require.ErrorIs(t, err, api.ErrRetryBudget, "expected the budget to be shared between clients")
+	require.Equal(t, uint32(4), atomic.LoadUint32(&attempts), "expected no retries once the budget is exhausted")
+
+	// Requests should refill the budget up to the burst
+	budget = api.NewRetryBudget(0.5, 1)
+	require.Equal(t, 1, budget.Available())
+	client, err := api.New(ts.URL, api.WithRetries(3), api.WithZeroBackoff(), api.WithRetryBudget(budget))
+	require.NoError(t, err, "could not create client")
+
+	atomic.StoreUint32(&attempts, 0)
+	err = client.DeleteCertificate(context.Background(), "1234")
+	require.ErrorIs(t, err, api.ErrRetryBudget, "expected the retry budget to be exhausted")
+	require.Equal(t, uint32(2), atomic.LoadUint32(&attempts), "expected one retry from the budget")
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	// Create a test server that tracks the number of concurrent requests
+	var active, peak int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			prev := atomic.LoadInt32(&peak)
+			if n <= prev || atomic.CompareAndSwapInt32(&peak, prev, n) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	_, err := api.New(ts.URL, api.WithConcurrencyLimit(-1))
+	require.ErrorIs(t, err, api.ErrInvalidLimit, "expected a negative limit to be rejected")
+
+	client, err := api.New(ts.URL, api.WithConcurrencyLimit(3))
+	require.NoError(t, err, "could not create client")
+
+	var calls int32
+	err = client.Bulk(context.Background(), 12, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&calls, 1)
+		return client.DeleteCertificate(ctx, fmt.Sprintf("cert-%d", i))
+	})
+	require.NoError(t, err, "could not execute bulk requests")
+	require.Equal(t, int32(12), calls, "expected every item to be processed")
+	require.LessOrEqual(t, atomic.LoadInt32(&peak), int32(3), "expected at most 3 concurrent requests")
+
+	// Requests made outside of bulk should also be limited
+	peak = 0
+	done := make(chan struct{})
+	for i := 0; i < 9; i++ {
+		go func() {
+			client.DeleteCertificate(context.Background(), "1234")
+			done <- struct{}{}
+		}()
+	}
+
+	for i := 0; i < 9; i++ {
+		<-done
+	}
+	require.LessOrEqual(t, atomic.LoadInt32(&peak), int32(3), "expected at most 3 concurrent requests")
+}
+
+func TestBulk(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/certs/missing" {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	client, err := api.New(ts.URL, api.WithRetries(0))
+	require.NoError(t, err, "could not create client")
+
+	ids := []string{"1234", "missing", "5678"}
+	err = client.Bulk(context.Background(), len(ids), func(ctx context.Context, i int) error {
+		return client.DeleteCertificate(ctx, ids[i])
+	})
+
+	var bulk *api.BulkError
+	require.ErrorAs(t, err, &bulk, "expected a bulk error")
+	require.Equal(t, 1, bulk.Failed)
+	require.Len(t, bulk.Errs, 3)
+	require.NoError(t, bulk.Errs[0])
+	require.Error(t, bulk.Errs[1], "expected the missing certificate to fail")
+	require.NoError(t, bulk.Errs[2])
+
+	// Items should not be processed after the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = client.Bulk(ctx, 2, func(ctx context.Context, i int) error {
+		return errors.New("should not be called")
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, client.Bulk(context.Background(), 0, nil), "expected no error without items")
+}
+
+func TestLogger(t *testing.T) {
+	// Create a test server that fails the first attempt
+	var attempts uint32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddUint32(&attempts, 1) == 1 {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	// Create a client that logs to a buffer
+	logs := &bytes.Buffer{}
+	logger := zerolog.New(logs).Level(zerolog.TraceLevel)
+	client, err := api.New(ts.URL, api.WithLogger(logger), api.WithRetries(1), api.WithBackoff(func() backoff.BackOff {
+		return backoff.NewConstantBackOff(10 * time.Millisecond)
+	}))
+	require.NoError(t, err, "could not create client")
+
+	req := &api.StorePasswordRequest{
+		ID:       "1234",
+		Password: "hunter2",
+	}
+	err = client.StoreCertificatePassword(context.Background(), req)
+	require.NoError(t, err, "could not execute password store request")
+
+	entries := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		entry := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), "could not parse log entry")
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 4, "expected body, failed attempt, retry, and success entries")
+
+	// The request body should be logged with the password redacted
+	require.Equal(t, "courier api request body", entries[0]["message"])
+	require.Equal(t, map[string]interface{}{"id": "1234", "password": "[REDACTED]"}, entries[0]["body"])
+	require.NotContains(t, logs.String(), "hunter2", "the password should never be logged")
+
+	// Each attempt and the retry decision should be logged
+	require.Equal(t, "courier api request failed", entries[1]["message"])
+	require.Equal(t, http.MethodPost, entries[1]["method"])
+	require.Equal(t, "/v1/certs/1234/pkcs12password", entries[1]["path"])
+	require.Equal(t, float64(http.StatusServiceUnavailable), entries[1]["status"])
+	require.Equal(t, float64(1), entries[1]["attempt"])
+	require.Contains(t, entries[1], "duration")
+
+	require.Equal(t, "retrying request after backoff delay", entries[2]["message"])
+	require.Equal(t, float64(10), entries[2]["delay"])
+
+	require.Equal(t, "courier api request", entries[3]["message"])
+	require.Equal(t, float64(http.StatusNoContent), entries[3]["status"])
+	require.Equal(t, float64(2), entries[3]["attempt"])
+}
+
+func TestPartialSuccess(t *testing.T) {
+	// Create a test server that succeeds but returns an unparseable response
+	var attempts uint32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&attempts, 1)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte("{not json"))
+	}))
+	defer ts.Close()
+
+	client, err := api.New(ts.URL, api.WithRetries(3), api.WithZeroBackoff())
+	require.NoError(t, err, "could not create client")
+
+	_, err = client.RetrieveCertificate(context.Background(), "1234")
+	require.Error(t, err, "expected an error to be returned")
+
+	var partial *api.PartialSuccessError
+	require.ErrorAs(t, err, &partial, "expected a partial success error")
+	require.Equal(t, http.StatusOK, partial.Code)
+	require.Equal(t, uint32(1), attempts, "partial success should not be retried")
+}
+
+func TestPartialSuccessNoData(t *testing.T) {
+	// Create a test server that succeeds but drops the connection mid-response
+	var attempts uint32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&attempts, 1)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err, "could not hijack connection")
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 128\r\n\r\n{\"success\":")
+		buf.Flush()
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	client, err := api.New(ts.URL, api.WithRetries(3), api.WithZeroBackoff())
+	require.NoError(t, err, "could not create client")
+
+	err = client.StoreCertificate(context.Background(), &api.StoreCertificateRequest{ID: "1234", Base64Certificate: "Y2VydA=="})
+	require.Error(t, err, "expected an error to be returned")
+
+	var partial *api.PartialSuccessError
+	require.ErrorAs(t, err, &partial, "expected a partial success error")
+	require.Equal(t, http.StatusOK, partial.Code)
+	require.Equal(t, uint32(1), attempts, "partial success should not be retried")
+}
+
+func TestRequestSentNotRetried(t *testing.T) {
+	// Create a test server that reads the request and drops the connection
+	var attempts uint32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&attempts, 1)
+		io.Copy(io.Discard, r.Body)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err, "could not hijack connection")
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	client, err := api.New(ts.URL, api.WithRetries(2), api.WithZeroBackoff())
+	require.NoError(t, err, "could not create client")
+
+	// A POST that may have been applied by the server should not be retried
+	err = client.StoreCertificate(context.Background(), &api.StoreCertificateRequest{ID: "1234", Base64Certificate: "Y2VydA=="})
+	require.ErrorIs(t, err, api.ErrRequestSent, "expected a request sent error")
+	require.Equal(t, uint32(1), atomic.SwapUint32(&attempts, 0), "expected no retries for POST request")
+
+	// Idempotent requests can still be retried
+	_, err = client.RetrieveCertificate(context.Background(), "1234")
+	require.Error(t, err, "expected an error to be returned")
+	require.NotErrorIs(t, err, api.ErrRequestSent, "idempotent requests should not report a sent request")
+	require.Equal(t, uint32(3), atomic.LoadUint32(&attempts), "expected retries for GET request")
+}
+
+func TestIdempotentRetries(t *testing.T) {
+	// Create a test server that always fails
+	var attempts uint32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&attempts, 1)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	client, err := api.New(ts.URL, api.WithRetries(2), api.WithZeroBackoff(), api.WithIdempotentRetries())
+	require.NoError(t, err, "could not create client")
+
+	// Non-idempotent requests should only be sent once
+	err = client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "1234", Password: "hunter2"})
+	require.Error(t, err, "expected an error to be returned")
+	require.Equal(t, uint32(1), atomic.SwapUint32(&attempts, 0), "expected no retries for POST request")
+
+	// Idempotent requests should be retried
+	_, err = client.RetrieveCertificate(context.Background(), "1234")
+	require.Error(t, err, "expected an error to be returned")
+	require.Equal(t, uint32(3), atomic.LoadUint32(&attempts), "expected retries for GET request")
+}
+
+func TestRetriesResendBody(t *testing.T) {
+	// Create a test server that fails the first request and checks the body
+	var attempts uint32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &api.StorePasswordRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(req), "could not decode request body")
+		require.Equal(t, "hunter2", req.Password, "request body not resent on retry")
+
+		if atomic.AddUint32(&attempts, 1) == 1 {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	client, err := api.New(ts.URL, api.WithRetries(1), api.WithZeroBackoff())
+	require.NoError(t, err, "could not create client")
+
+	err = client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "1234", Password: "hunter2"})
+	require.NoError(t, err, "expected request to succeed after retry")
+	require.Equal(t, uint32(2), attempts)
+}
+
+func TestStreamedBodyNotRetried(t *testing.T) {
+	// Create a test server that always fails
+	var attempts uint32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&attempts, 1)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client, err := api.New(ts.URL, api.WithRetries(2), api.WithZeroBackoff())
+	require.NoError(t, err, "could not create client")
+
+	// A reader that cannot be rewound should only be sent once
+	body := io.MultiReader(strings.NewReader("pkcs12-certificate"))
+	err = client.UploadCertificate(context.Background(), "1234", body, false)
+	require.Error(t, err, "expected an error to be returned")
+	require.Equal(t, uint32(1), atomic.LoadUint32(&attempts), "expected no retries for a streamed body")
+}
+
+func TestEvents(t *testing.T) {
+	// Create a test server that streams events in fragments with keepalive comments
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/v1/events", r.URL.Path)
+		require.Equal(t, api.ContentTypeEventStream, r.Header.Get("Accept"))
+
+		w.Header().Set("Content-Type", api.ContentTypeEventStream)
+		w.WriteHeader(http.StatusOK)
+		for _, chunk := range []string{
+			": keepalive\n\n",
+			"event:password_stored\ndata:{\"type\":\"password_stored\",",
+			"\"id\":\"1234\",\"timestamp\":\"2024-01-01T00:00:00Z\"}\n\n",
+			"event:certificate_stored\r\ndata: {\"type\":\"certificate_stored\",\"id\":\"1234\"}\r\n\r\n",
+		} {
+			w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := api.New(ts.URL)
+	require.NoError(t, err, "could not create client")
+
+	// Events should be dispatched until the server closes the stream
+	var events []*api.Event
+	err = client.Events(context.Background(), func(event *api.Event) error {
+		events = append(events, event)
+		return nil
+	})
+	require.NoError(t, err, "expected no error when the server closes the stream")
+	require.Len(t, events, 2)
+	require.Equal(t, api.EventPasswordStored, events[0].Type)
+	require.Equal(t, "1234", events[0].ID)
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), events[0].Timestamp)
+	require.Equal(t, api.EventCertificateStored, events[1].Type)
+}
+
+func TestEndpointFailover(t *testing.T) {
+	var requests atomic.Int32
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer standby.Close()
+
+	// The primary refuses connections
+	primary := httptest.NewServer(http.NotFoundHandler())
+	primary.Close()
+
+	// Failing over to the standby should not count as a retry
+	client, err := api.New(primary.URL+", "+standby.URL, api.WithRetries(0))
+	require.NoError(t, err, "could not create api client")
+
+	require.NoError(t, client.DeleteCertificate(context.Background(), "1234"), "request should fail over to the standby")
+	require.NoError(t, client.DeleteCertificate(context.Background(), "1234"), "request should be sent to the standby")
+	require.Equal(t, int32(2), requests.Load())
+
+	// Requests fail once every endpoint has been tried
+	standby.Close()
+	err = client.DeleteCertificate(context.Background(), "1234")
+	require.Error(t, err, "expected an error when every endpoint is down")
+
+	_, err = api.New(" , ")
+	require.ErrorIs(t, err, api.ErrEndpointRequired)
+}
+
+func TestSRVDiscovery(t *testing.T) {
+	// Connections are not kept alive so that every request connects to its target
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	// The highest priority target is down so requests fail over to the next target
+	resolver := &fakeResolver{records: []*net.SRV{srvRecord(t, down.URL), srvRecord(t, ts.URL)}}
+	client, err := api.New("srv+http://_courier._tcp.example.com", api.WithRetries(0), api.WithResolver(resolver))
+	require.NoError(t, err, "could not create api client")
+
+	require.NoError(t, client.DeleteCertificate(context.Background(), "1234"), "request should be sent to the discovered target")
+	require.Equal(t, "_courier._tcp.example.com", resolver.name)
+	require.Equal(t, int32(1), resolver.lookups.Load(), "records should be cached")
+
+	// Once every target has failed the records are resolved again
+	moved := httptest.NewServer(handler)
+	defer moved.Close()
+
+	ts.Close()
+	resolver.records = []*net.SRV{srvRecord(t, moved.URL)}
+	require.Error(t, client.DeleteCertificate(context.Background(), "1234"))
+	require.NoError(t, client.DeleteCertificate(context.Background(), "1234"), "expected the re-resolved target to be used")
+	require.Equal(t, int32(2), resolver.lookups.Load(), "records should be resolved again")
+
+	resolver.records = nil
+	client, err = api.New("srv+http://_courier._tcp.example.com", api.WithResolver(resolver))
+	require.NoError(t, err, "could not create api client")
+	require.ErrorIs(t, client.DeleteCertificate(context.Background(), "1234"), api.ErrNoEndpoints)
+}
+
+type fakeResolver struct {
+	records []*net.SRV
+	name    string
+	lookups atomic.Int32
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.lookups.Add(1)
+	r.name = name
+	return name, r.records, nil
+}
+
+func srvRecord(t *testing.T, endpoint string) *net.SRV {
+	u, err := url.Parse(endpoint)
+	require.NoError(t, err)
+
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	return &net.SRV{Target: u.Hostname() + ".", Port: uint16(port)}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SRV endpoints are url whose scheme is prefixed with srv+, e.g.
+// srv+https://_courier._tcp.example.com, which are expanded into the targets of the SRV
+// records of the host in priority order.
+const srvScheme = "srv+"
+
+const (
+	// How long the targets of SRV records are used before they are resolved again.
+	srvRefresh = time.Minute
+
+	// How long after failing over the client returns to the first endpoint.
+	failback = time.Minute
+)
+
+// Resolver looks up the SRV records of an endpoint, e.g. a *net.Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+}
+
+// Endpoints are the servers that requests are sent to in order of preference, e.g. a
+// primary and standby courier instance. Requests are sent to the active endpoint,
+// which moves to the next endpoint when a connection cannot be established and returns
+// to the first endpoint once the failback interval has passed. Endpoints are shared by
+// the copies of the client used for streams and long polls.
+type endpoints struct {
+	sync.Mutex
+	sources  []*url.URL
+	urls     []*url.URL
+	active   int
+	failedAt time.Time
+	resolved time.Time
+	resolver Resolver
+}
+
+// Parses the comma separated list of endpoints.
+func parseEndpoints(endpoint string) (_ *endpoints, err error) {
+	e := &endpoints{resolver: net.DefaultResolver}
+	for _, s := range strings.Split(endpoint, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		var u *url.URL
+		if u, err = url.Parse(s); err != nil {
+			return nil, err
+		}
+		e.sources = append(e.sources, u)
+	}
+
+	if len(e.sources) == 0 {
+		return nil, ErrEndpointRequired
+	}
+	return e, nil
+}
+
+// Returns the active endpoint, resolving SRV records if they have not been resolved
+// recently. If the records cannot be resolved, the previously resolved targets are
+// used until they can be.
+func (e *endpoints) current(ctx context.Context) (_ *url.URL, err error) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.urls == nil || (e.hasSRV() && time.Since(e.resolved) > srvRefresh) {
+		var urls []*url.URL
+		if urls, err = e.resolve(ctx); err != nil && e.urls == nil {
+			return nil, err
+		}
+
+		if err == nil {
+			e.urls, e.active = urls, 0
+			e.resolved = time.Now()
+		}
+	}
+
+	if e.active != 0 && time.Since(e.failedAt) > failback {
+		e.active = 0
+	}
+	return e.urls[e.active], nil
+}
+
+// Moves the active endpoint to the next endpoint if the request was sent to it and
+// returns the endpoint that the request should be sent to next. SRV records are
+// resolved again once every endpoint has failed in case the targets have moved.
+func (e *endpoints) failover(failed *url.URL) *url.URL {
+	e.Lock()
+	defer e.Unlock()
+
+	active := e.urls[e.active]
+	if active.Scheme == failed.Scheme && active.Host == failed.Host {
+		e.active = (e.active + 1) % len(e.urls)
+		e.failedAt = time.Now()
+		if e.active == 0 {
+			e.resolved = time.Time{}
+		}
+	}
+	return e.urls[e.active]
+}
+
+// Returns the number of endpoints that requests can be sent to.
+func (e *endpoints) len() int {
+	e.Lock()
+	defer e.Unlock()
+	return len(e.urls)
+}
+
+// Expands the SRV sources into the targets of their records in priority order.
+func (e *endpoints) resolve(ctx context.Context) (urls []*url.URL, err error) {
+	for _, source := range e.sources {
+		if !strings.HasPrefix(source.Scheme, srvScheme) {
+			urls = append(urls, source)
+			continue
+		}
+
+		var records []*net.SRV
+		if _, records, err = e.resolver.LookupSRV(ctx, "", "", source.Hostname()); err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			target := *source
+			target.Scheme = strings.TrimPrefix(source.Scheme, srvScheme)
+			target.Host = net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+			urls = append(urls, &target)
+		}
+	}
+
+	if len(urls) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	return urls, nil
+}
+
+func (e *endpoints) hasSRV() bool {
+	for _, source := range e.sources {
+		if strings.HasPrefix(source.Scheme, srvScheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the request that is sent to the endpoint.
+func redirect(req *http.Request, endpoint *url.URL) *http.Request {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = endpoint.Scheme
+	req.URL.Host = endpoint.Host
+	req.Host = ""
+	return req
+}
+
+// Returns true if the error occurred before a connection to the server was
+// established, in which case the request can be sent to another endpoint.
+func isConnectError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -14,9 +14,18 @@ var (
 	unsuccessful        = Reply{Success: false}
 	notFound            = Reply{Success: false, Error: "resource not found"}
 	notAllowed          = Reply{Success: false, Error: "method not allowed"}
+	ErrAliasRequired    = errors.New("missing alias in request")
 	ErrEndpointRequired = errors.New("endpoint is required")
 	ErrIDRequired       = errors.New("missing ID in request")
 	ErrInvalidRetries   = errors.New("number of retries must be zero or more")
+	ErrInvalidLimit     = errors.New("concurrency limit must be zero or more")
+	ErrInvalidTimeout   = errors.New("timeouts must be zero or more")
+	ErrNameRequired     = errors.New("missing name in request")
+	ErrNoEndpoints      = errors.New("no endpoints were discovered from the srv records")
+	ErrNotModified      = errors.New("resource has not been modified")
+	ErrRequestSent      = errors.New("request was sent but no response was received, the server may have applied it")
+	ErrRetryBudget      = errors.New("request was not retried since the retry budget is exhausted")
+	ErrVersionRequired  = errors.New("missing version in request")
 )
 
 // ErrorResponse constructs an new response from the error or returns a success: false.
@@ -62,6 +71,22 @@ func (e StatusError) Error() string {
 	return fmt.Sprintf("[%d]: %s", e.Code, e.Err)
 }
 
+// PartialSuccessError is returned when the server responded with a success status
+// code but the response could not be processed by the client. The request should not
+// be retried since the server may have already applied it.
+type PartialSuccessError struct {
+	Code int
+	Err  error
+}
+
+func (e *PartialSuccessError) Error() string {
+	return fmt.Sprintf("[%d]: request succeeded but response could not be processed: %s", e.Code, e.Err)
+}
+
+func (e *PartialSuccessError) Unwrap() error {
+	return e.Err
+}
+
 // Deduplicates status errors and creates a multi-status error to return. Removes nil
 // errors and returns nil if all errs are nil. If only one errors is returned, return
 // that error instead of a multierror (e.g. if all responses have the same status code).
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ContentTypeEventStream is the content type of the server-sent event stream.
+const ContentTypeEventStream = "text/event-stream"
+
+// eventStream parses server-sent events as the response body is written to it and
+// calls the handler with each complete event. Comments, such as keepalives, and
+// fields other than data are ignored since the event type is also in the data.
+type eventStream struct {
+	handler func(*Event) error
+	buf     []byte
+	data    []byte
+	err     error
+}
+
+func (s *eventStream) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for {
+		idx := bytes.IndexByte(s.buf, '\n')
+		if idx < 0 {
+			return len(p), nil
+		}
+
+		line := bytes.TrimSuffix(s.buf[:idx], []byte("\r"))
+		s.buf = s.buf[idx+1:]
+
+		if err := s.line(line); err != nil {
+			s.err = err
+			return 0, err
+		}
+	}
+}
+
+// Handles a single line of the event stream, dispatching the event on a blank line.
+func (s *eventStream) line(line []byte) (err error) {
+	switch {
+	case len(line) == 0:
+		if len(s.data) == 0 {
+			return nil
+		}
+
+		event := &Event{}
+		if err = json.Unmarshal(s.data, event); err != nil {
+			return fmt.Errorf("could not parse event: %w", err)
+		}
+
+		s.data = s.data[:0]
+		return s.handler(event)
+	case bytes.HasPrefix(line, []byte("data:")):
+		if len(s.data) > 0 {
+			s.data = append(s.data, '\n')
+		}
+		s.data = append(s.data, bytes.TrimPrefix(line[len("data:"):], []byte(" "))...)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DefaultBulkConcurrency is the number of items processed at a time by Bulk if the
+// client does not have a concurrency limit.
+const DefaultBulkConcurrency = 8
+
+// RetryBudget limits the retries made by one or more clients to a fraction of the
+// requests they send so that retries cannot amplify the load on a server that is
+// failing, e.g. when a CA delivers hundreds of certificates at once. Every request
+// deposits ratio tokens into the budget, up to the burst, and every retry withdraws one
+// token; requests are not retried when the budget is empty. The budget starts full so
+// that a client can retry up to burst requests before it has sent any. A budget is safe
+// for concurrent use and can be shared by several clients.
+type RetryBudget struct {
+	sync.Mutex
+	ratio  float64
+	burst  float64
+	tokens float64
+}
+
+// NewRetryBudget creates a budget that allows retries of up to ratio of the requests,
+// e.g. 0.1 allows one retry for every ten requests, with a reserve of burst retries.
+// Negative values are treated as zero, which disables retries for clients sharing it.
+func NewRetryBudget(ratio float64, burst int) *RetryBudget {
+	budget := &RetryBudget{ratio: max(ratio, 0), burst: float64(max(burst, 0))}
+	budget.tokens = budget.burst
+	return budget
+}
+
+// Available returns the number of retries that the budget currently allows.
+func (b *RetryBudget) Available() int {
+	b.Lock()
+	defer b.Unlock()
+	return int(b.tokens)
+}
+
+// Deposits tokens for a request that is being sent for the first time.
+func (b *RetryBudget) request() {
+	b.Lock()
+	defer b.Unlock()
+	b.tokens = min(b.tokens+b.ratio, b.burst)
+}
+
+// Withdraws a token for a retry, returning false if the budget is empty.
+func (b *RetryBudget) retry() bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Waits for a request slot if the client has a concurrency limit.
+func (s *APIv1) acquire(ctx context.Context) error {
+	if s.limiter == nil {
+		return nil
+	}
+
+	select {
+	case s.limiter <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Releases a request slot acquired by acquire.
+func (s *APIv1) release() {
+	if s.limiter != nil {
+		<-s.limiter
+	}
+}
+
+// Bulk calls fn for each of the n items, e.g. to deliver a batch of certificates, using
+// as many goroutines as the concurrency limit of the client allows or
+// DefaultBulkConcurrency if it is unlimited. Every item is processed even if some of
+// them fail; items that have not started when the context is done fail with the
+// context error. If any items fail a *BulkError is returned.
+func (s *APIv1) Bulk(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	workers := DefaultBulkConcurrency
+	if s.limiter != nil {
+		workers = cap(s.limiter)
+	}
+	workers = min(workers, n)
+
+	items := make(chan int)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				errs[i] = fn(ctx, i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		items <- i
+	}
+	close(items)
+	wg.Wait()
+
+	err := &BulkError{Errs: errs}
+	for _, e := range errs {
+		if e != nil {
+			err.Failed++
+		}
+	}
+
+	if err.Failed > 0 {
+		return err
+	}
+	return nil
+}
+
+// BulkError is returned by Bulk if any items fail. Errs contains the error of every
+// item by index, which is nil for items that succeeded.
+type BulkError struct {
+	Errs   []error
+	Failed int
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("%d of %d bulk requests failed", e.Failed, len(e.Errs))
+}
+
+func (e *BulkError) Unwrap() []error {
+	errs := make([]error, 0, e.Failed)
+	for _, err := range e.Errs {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
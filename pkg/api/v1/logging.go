@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Replaces the values of sensitive fields in logged request bodies.
+const redacted = "[REDACTED]"
+
+// JSON fields of request bodies that contain passwords, certificates, or secrets and
+// are always redacted from the logs.
+var sensitiveFields = map[string]struct{}{
+	"password":           {},
+	"base64_certificate": {},
+	"base64_data":        {},
+}
+
+// Logs the method, path, status, and duration of a single attempt of a request.
+func (s *APIv1) logAttempt(req *http.Request, attempt int, rep *http.Response, err error, duration time.Duration) {
+	event := s.log.Debug()
+	if !event.Enabled() {
+		return
+	}
+
+	event = event.Str("method", req.Method).Str("path", req.URL.Path).Int("attempt", attempt).Dur("duration", duration)
+	if rep != nil {
+		event = event.Int("status", rep.StatusCode)
+	}
+
+	if err != nil {
+		event.Err(err).Msg("courier api request failed")
+		return
+	}
+	event.Msg("courier api request")
+}
+
+// Logs why a failed request will or will not be retried.
+func (s *APIv1) logRetry(req *http.Request, attempt int, delay time.Duration, reason string) {
+	event := s.log.Debug().Str("method", req.Method).Str("path", req.URL.Path).Int("attempt", attempt)
+	if delay > 0 {
+		event = event.Dur("delay", delay)
+	}
+	event.Msg(reason)
+}
+
+// Logs the JSON body of the request at trace level with sensitive fields redacted.
+// Bodies that are streamed or are not JSON objects are not logged.
+func (s *APIv1) logBody(req *http.Request) {
+	event := s.log.Trace()
+	if !event.Enabled() || req.GetBody == nil || req.Header.Get("Content-Type") != contentType {
+		return
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return
+	}
+	defer body.Close()
+
+	var data []byte
+	if data, err = io.ReadAll(body); err != nil {
+		return
+	}
+
+	if data = redactBody(data); data != nil {
+		event.Str("method", req.Method).Str("path", req.URL.Path).RawJSON("body", data).Msg("courier api request body")
+	}
+}
+
+// Returns the JSON object with the values of sensitive fields redacted or nil if the
+// data is not a JSON object.
+func redactBody(data []byte) []byte {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+
+	for key := range fields {
+		if _, ok := sensitiveFields[key]; ok {
+			fields[key] = json.RawMessage(`"` + redacted + `"`)
+		}
+	}
+
+	data, _ = json.Marshal(fields)
+	return data
+}
@@ -0,0 +1,10 @@
+package api
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3 specification describing the endpoints and schemas of this
+// version of the courier API. It must be updated whenever a route or a request or
+// reply struct in this package is changed.
+//
+//go:embed openapi.json
+var OpenAPI []byte
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog"
 )
 
 // ClientOption allows the API client to be configured when it is created.
@@ -14,6 +15,10 @@ type ClientOption func(c *APIv1) error
 // BackoffFactory creates a new backoff delay for a specific request.
 type BackoffFactory func() backoff.BackOff
 
+// DeprecationHandler is called when the server responds to a request with deprecation
+// headers, e.g. so that the caller can log a warning before the route is removed.
+type DeprecationHandler func(req *http.Request, notice *Deprecation)
+
 // WithBackoff allows the user to create a client that retries requests with a fixed or
 // exponential backoff to allow the remote service time to recover. By default, the
 // courier client uses exponential backoff and three retries.
@@ -48,21 +53,173 @@ func WithRetries(attempts int) ClientOption {
 	}
 }
 
+// WithIdempotentRetries creates a client that only retries idempotent requests (e.g.
+// GET, PUT, and DELETE). Non-idempotent requests such as POST are sent only once so
+// that a request that was applied by the server is never repeated.
+func WithIdempotentRetries() ClientOption {
+	return func(c *APIv1) error {
+		c.idempotentOnly = true
+		return nil
+	}
+}
+
 // WithTLSConfig allows the user to specify a custom tls configuration for the client.
 func WithTLSConfig(conf *tls.Config) ClientOption {
 	return func(c *APIv1) error {
-		if c.client != nil {
-			c.client.Transport = &http.Transport{
-				TLSClientConfig: conf,
-			}
-		} else {
-			c.client = &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: conf,
-				},
-				CheckRedirect: nil,
-				Timeout:       30 * time.Second,
-			}
+		c.tlsConfig = conf
+		return nil
+	}
+}
+
+// Timeouts bound the phases of every request sent by the client so that unreachable
+// servers and failed handshakes fail fast while large uploads still have time to
+// complete. Zero disables a timeout; the request context can still cancel it.
+type Timeouts struct {
+	Connect        time.Duration // Establishing the tcp connection, including dns
+	TLSHandshake   time.Duration // Completing the tls handshake after connecting
+	ResponseHeader time.Duration // Receiving the response headers after the request is sent
+	Total          time.Duration // The entire request including reading the response body
+}
+
+// DefaultTimeouts returns the timeouts used by the client unless they are configured.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Connect:        10 * time.Second,
+		TLSHandshake:   10 * time.Second,
+		ResponseHeader: 30 * time.Second,
+		Total:          5 * time.Minute,
+	}
+}
+
+// WithTimeouts replaces all of the request timeouts of the client. Long polls and
+// event streams are not subject to the response header timeout, and the total timeout
+// of a long poll is extended by how long it waits.
+func WithTimeouts(timeouts Timeouts) ClientOption {
+	return func(c *APIv1) error {
+		if timeouts.Connect < 0 || timeouts.TLSHandshake < 0 || timeouts.ResponseHeader < 0 || timeouts.Total < 0 {
+			return ErrInvalidTimeout
+		}
+
+		c.timeouts = timeouts
+		return nil
+	}
+}
+
+// WithConnectTimeout sets how long the client waits to connect to the server,
+// including resolving its address. The default is 10 seconds.
+func WithConnectTimeout(timeout time.Duration) ClientOption {
+	return func(c *APIv1) error {
+		if timeout < 0 {
+			return ErrInvalidTimeout
+		}
+
+		c.timeouts.Connect = timeout
+		return nil
+	}
+}
+
+// WithTLSHandshakeTimeout sets how long the client waits for the tls handshake to
+// complete after connecting. The default is 10 seconds.
+func WithTLSHandshakeTimeout(timeout time.Duration) ClientOption {
+	return func(c *APIv1) error {
+		if timeout < 0 {
+			return ErrInvalidTimeout
+		}
+
+		c.timeouts.TLSHandshake = timeout
+		return nil
+	}
+}
+
+// WithResponseHeaderTimeout sets how long the client waits for the server to respond
+// after the request has been sent. The default is 30 seconds.
+func WithResponseHeaderTimeout(timeout time.Duration) ClientOption {
+	return func(c *APIv1) error {
+		if timeout < 0 {
+			return ErrInvalidTimeout
+		}
+
+		c.timeouts.ResponseHeader = timeout
+		return nil
+	}
+}
+
+// WithTimeout sets the total time allowed for each request attempt, including sending
+// the request body and reading the response. The default is 5 minutes.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *APIv1) error {
+		if timeout < 0 {
+			return ErrInvalidTimeout
+		}
+
+		c.timeouts.Total = timeout
+		return nil
+	}
+}
+
+// WithResolver looks up the SRV records of srv+https and srv+http endpoints with the
+// resolver rather than the default resolver.
+func WithResolver(resolver Resolver) ClientOption {
+	return func(c *APIv1) error {
+		c.endpoints.resolver = resolver
+		return nil
+	}
+}
+
+// WithAdminToken authenticates requests to the admin api of the server with the bearer
+// token. The token is not sent with requests to the other routes.
+func WithAdminToken(token string) ClientOption {
+	return func(c *APIv1) error {
+		c.adminToken = token
+		return nil
+	}
+}
+
+// WithDeprecationHandler allows the user to be notified when a request is made to an
+// API route that the server has marked as deprecated.
+func WithDeprecationHandler(handler DeprecationHandler) ClientOption {
+	return func(c *APIv1) error {
+		c.onDeprecated = handler
+		return nil
+	}
+}
+
+// WithLogger logs the method, path, status, and duration of every request attempt and
+// the decision to retry or give up at debug level, and the JSON request bodies at trace
+// level, to help integrators debug their deliveries. Passwords, certificates, and
+// secrets are always redacted from the logged bodies. The client does not log by
+// default.
+func WithLogger(logger zerolog.Logger) ClientOption {
+	return func(c *APIv1) error {
+		c.log = logger
+		return nil
+	}
+}
+
+// WithRetryBudget limits the retries of the client to the budget, which can be shared
+// by several clients so that their retries are coordinated. Requests that are not
+// retried because the budget is exhausted return ErrRetryBudget along with the errors
+// of the attempts that were made. By default retries are only limited per request.
+func WithRetryBudget(budget *RetryBudget) ClientOption {
+	return func(c *APIv1) error {
+		c.budget = budget
+		return nil
+	}
+}
+
+// WithConcurrencyLimit bounds the number of requests that the client sends at the same
+// time; additional requests wait until a request completes or their context is done.
+// Backoff delays between retries, long polls, and event streams do not count against
+// the limit. Set to zero for no limit, which is the default.
+func WithConcurrencyLimit(requests int) ClientOption {
+	return func(c *APIv1) error {
+		if requests < 0 {
+			return ErrInvalidLimit
+		}
+
+		c.limiter = nil
+		if requests > 0 {
+			c.limiter = make(chan struct{}, requests)
 		}
 		return nil
 	}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the API implemented by this package.
+const Version = "v1"
+
+// Headers used to negotiate API versions and to communicate deprecations.
+const (
+	HeaderAPIVersion  = "Courier-Api-Version"
+	HeaderDeprecation = "Deprecation"
+	HeaderSunset      = "Sunset"
+	HeaderLink        = "Link"
+)
+
+// VersionsReply lists the API versions that are served by courier.
+type VersionsReply struct {
+	Versions []APIVersion `json:"versions"`
+}
+
+// APIVersion describes a single version of the API and its deprecation schedule.
+type APIVersion struct {
+	Version    string     `json:"version"`
+	Prefix     string     `json:"prefix"`
+	Deprecated *time.Time `json:"deprecated,omitempty"`
+	Sunset     *time.Time `json:"sunset,omitempty"`
+	Successor  string     `json:"successor,omitempty"`
+}
+
+// Supports returns true if the specified version is served by courier.
+func (r *VersionsReply) Supports(version string) bool {
+	for _, v := range r.Versions {
+		if v.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDeprecated returns true if the version has a deprecation date at or before now.
+func (v APIVersion) IsDeprecated() bool {
+	return v.Deprecated != nil && !v.Deprecated.After(time.Now())
+}
+
+// SetHeaders writes the version and any deprecation headers to the response header.
+// Deprecation dates are formatted as structured field dates (RFC 9745) and sunset
+// dates are formatted as HTTP dates (RFC 8594).
+func (v APIVersion) SetHeaders(header http.Header) {
+	header.Set(HeaderAPIVersion, v.Version)
+	if v.Deprecated == nil {
+		return
+	}
+
+	header.Set(HeaderDeprecation, "@"+strconv.FormatInt(v.Deprecated.Unix(), 10))
+	if v.Sunset != nil {
+		header.Set(HeaderSunset, v.Sunset.UTC().Format(http.TimeFormat))
+	}
+
+	if v.Successor != "" {
+		header.Add(HeaderLink, "<"+v.Successor+`>; rel="successor-version"`)
+	}
+}
+
+// Deprecation is parsed from the response headers of a deprecated API route.
+type Deprecation struct {
+	Version    string
+	Deprecated time.Time
+	Sunset     time.Time
+	Successor  string
+}
+
+var successorLink = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?successor-version"?`)
+
+// ParseDeprecation returns the deprecation notice in the response headers or nil if
+// the route is not deprecated.
+func ParseDeprecation(header http.Header) *Deprecation {
+	value := strings.TrimSpace(header.Get(HeaderDeprecation))
+	if value == "" {
+		return nil
+	}
+
+	notice := &Deprecation{Version: header.Get(HeaderAPIVersion)}
+	if ts, err := strconv.ParseInt(strings.TrimPrefix(value, "@"), 10, 64); err == nil {
+		notice.Deprecated = time.Unix(ts, 0)
+	}
+
+	if sunset, err := http.ParseTime(header.Get(HeaderSunset)); err == nil {
+		notice.Sunset = sunset
+	}
+
+	for _, link := range header.Values(HeaderLink) {
+		if match := successorLink.FindStringSubmatch(link); match != nil {
+			notice.Successor = match[1]
+			break
+		}
+	}
+
+	return notice
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/trisacrypto/courier/pkg/middleware"
+)
+
+// APIKeyHeader is the header that api keys are sent in.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyAuthenticator authenticates requests by the api key in the X-API-Key header.
+// Keys are compared by their digests in constant time so that the comparison does not
+// reveal how much of a key matched.
+type APIKeyAuthenticator struct {
+	keys map[string][sha256.Size]byte
+}
+
+// NewAPIKeys returns an authenticator for the api keys, configured as name:key pairs.
+// The name of the key is the subject of the identity.
+func NewAPIKeys(items []string) (_ *APIKeyAuthenticator, err error) {
+	if len(items) == 0 {
+		return nil, ErrMissingKeys
+	}
+
+	var keys map[string]string
+	if keys, err = pairs(items); err != nil {
+		return nil, err
+	}
+
+	a := &APIKeyAuthenticator{keys: make(map[string][sha256.Size]byte, len(keys))}
+	for name, key := range keys {
+		a.keys[name] = sha256.Sum256([]byte(key))
+	}
+	return a, nil
+}
+
+// Authenticate returns the name of the api key in the request.
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*middleware.Identity, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	for name, expected := range a.keys {
+		if subtle.ConstantTimeCompare(digest[:], expected[:]) == 1 {
+			return &middleware.Identity{Subject: name, Method: middleware.AuthAPIKey}, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// TokenAuthenticator authenticates requests by a static bearer token, e.g. the admin
+// token. Bearer tokens that do not match are passed to the next authenticator since
+// they may be JWTs.
+type TokenAuthenticator struct {
+	subject string
+	token   []byte
+}
+
+// NewToken returns an authenticator for the bearer token that identifies the caller as
+// the subject. An empty token never matches.
+func NewToken(subject, token string) *TokenAuthenticator {
+	return &TokenAuthenticator{subject: subject, token: []byte(token)}
+}
+
+// Authenticate returns the subject of the token if the request has the bearer token.
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*middleware.Identity, error) {
+	token, ok := bearer(r)
+	if !ok || len(a.token) == 0 || subtle.ConstantTimeCompare([]byte(token), a.token) != 1 {
+		return nil, ErrNoCredentials
+	}
+	return &middleware.Identity{Subject: a.subject, Method: middleware.AuthToken}, nil
+}
@@ -0,0 +1,131 @@
+/*
+Package auth authenticates requests to the courier api with an ordered chain of
+authenticators, so that deployments can combine mechanisms for different route groups,
+e.g. mTLS client certificates for deliveries and signed JWTs for the admin api, without
+bespoke middleware for every combination.
+
+Each authenticator in a chain either finds its credentials in the request, in which
+case it decides whether the request is authenticated, or returns ErrNoCredentials so
+that the next authenticator is tried. A request is rejected if its credentials are
+invalid or if no authenticator in the chain finds credentials.
+*/
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/middleware"
+)
+
+// Names of the authenticators that can be configured in a chain.
+const (
+	MTLS   = config.AuthMTLS
+	Token  = config.AuthToken
+	APIKey = config.AuthAPIKey
+	JWT    = config.AuthJWT
+	HMAC   = config.AuthHMAC
+)
+
+// Authenticator returns the identity of the caller that made the request. If the
+// request does not contain the credentials of the authenticator, ErrNoCredentials is
+// returned so that the next authenticator in the chain can be tried.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*middleware.Identity, error)
+}
+
+// Chain tries each authenticator in order until one finds credentials in the request.
+type Chain []Authenticator
+
+var _ Authenticator = Chain{}
+
+// New creates the chain of the named authenticators, in order, from the configuration.
+func New(names []string, conf config.Config) (chain Chain, err error) {
+	chain = make(Chain, 0, len(names))
+	for _, name := range names {
+		var authenticator Authenticator
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case MTLS:
+			authenticator = NewMTLS(conf.Auth.MTLSSubjects)
+		case Token:
+			authenticator = NewToken("admin", conf.AdminToken)
+		case APIKey:
+			if authenticator, err = NewAPIKeys(conf.Auth.APIKeys); err != nil {
+				return nil, err
+			}
+		case JWT:
+			if authenticator, err = NewJWT(conf.Auth.JWTSecret, conf.Auth.JWTIssuer, conf.Auth.JWTAudience, conf.Auth.ClockSkew); err != nil {
+				return nil, err
+			}
+		case HMAC:
+			if authenticator, err = NewHMAC(conf.Auth.HMACKeys, conf.Auth.ClockSkew, conf.MaxUploadSize); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnknownAuthenticator, name)
+		}
+		chain = append(chain, authenticator)
+	}
+	return chain, nil
+}
+
+// Authenticate returns the identity from the first authenticator that finds
+// credentials in the request, or ErrNoCredentials if none of them do.
+func (c Chain) Authenticate(r *http.Request) (_ *middleware.Identity, err error) {
+	for _, authenticator := range c {
+		var identity *middleware.Identity
+		if identity, err = authenticator.Authenticate(r); err != nil {
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			return nil, err
+		}
+		return identity, nil
+	}
+	return nil, ErrNoCredentials
+}
+
+// Middleware returns a gin handler that authenticates requests with the chain, storing
+// the identity of the caller in the request context, and responds 401 Unauthorized if
+// the request cannot be authenticated.
+func Middleware(chain Chain, realm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, err := chain.Authenticate(c.Request)
+		if err != nil {
+			middleware.Logger(c).Debug().Err(err).Str("realm", realm).Msg("request was not authenticated")
+			c.Header("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", realm))
+			if errors.Is(err, ErrNoCredentials) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse("authentication is required"))
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse("invalid credentials"))
+			return
+		}
+
+		middleware.SetIdentity(c, identity)
+		c.Next()
+	}
+}
+
+// bearer returns the bearer token in the authorization header of the request.
+func bearer(r *http.Request) (token string, ok bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// pairs parses name:value pairs from the configuration.
+func pairs(items []string) (_ map[string]string, err error) {
+	parsed := make(map[string]string, len(items))
+	for _, item := range items {
+		name, value, ok := strings.Cut(item, ":")
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("%w: expected name:value", ErrInvalidKey)
+		}
+		parsed[name] = value
+	}
+	return parsed, nil
+}
@@ -0,0 +1,230 @@
+package auth_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/auth"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/middleware"
+)
+
+var (
+	jwtSecret = []byte("supersecretjwtsigningkey")
+	hmacKey   = []byte("supersecrethmacsigningkey")
+)
+
+func testConfig() config.Config {
+	return config.Config{
+		AdminToken:    "admin-token",
+		MaxUploadSize: 1024,
+		Auth: config.AuthConfig{
+			APIKeys:      []string{"alice:alice-key", "bob:bob-key"},
+			JWTSecret:    base64.StdEncoding.EncodeToString(jwtSecret),
+			JWTIssuer:    "courier-tests",
+			JWTAudience:  "courier",
+			HMACKeys:     []string{"node:" + base64.StdEncoding.EncodeToString(hmacKey)},
+			ClockSkew:    time.Minute,
+			MTLSSubjects: []string{"trisa.example.com"},
+		},
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain, err := auth.New([]string{"token", "apikey", "jwt"}, testConfig())
+	require.NoError(t, err, "could not create chain")
+	require.Len(t, chain, 3)
+
+	testCases := []struct {
+		header, value string
+		subject       string
+		method        string
+		err           error
+	}{
+		{"", "", "", "", auth.ErrNoCredentials},
+		{"Authorization", "Bearer admin-token", "admin", middleware.AuthToken, nil},
+		{"X-API-Key", "bob-key", "bob", middleware.AuthAPIKey, nil},
+		{"X-API-Key", "wrong-key", "", "", auth.ErrInvalidCredentials},
+		{"Authorization", "Bearer " + token(t, map[string]any{"sub": "carol", "iss": "courier-tests", "aud": "courier"}), "carol", middleware.AuthJWT, nil},
+		{"Authorization", "Bearer wrong-token", "", "", auth.ErrNoCredentials},
+		{"Authorization", "Basic YWRtaW46YWRtaW4=", "", "", auth.ErrNoCredentials},
+	}
+
+	for i, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/v1/admin/config", nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+
+		identity, err := chain.Authenticate(req)
+		if tc.err != nil {
+			require.ErrorIs(t, err, tc.err, "test case %d failed", i)
+			continue
+		}
+
+		require.NoError(t, err, "test case %d failed", i)
+		require.Equal(t, &middleware.Identity{Subject: tc.subject, Method: tc.method}, identity, "test case %d failed", i)
+	}
+
+	_, err = auth.New([]string{"token", "basic"}, testConfig())
+	require.ErrorIs(t, err, auth.ErrUnknownAuthenticator)
+
+	conf := testConfig()
+	conf.Auth.APIKeys = []string{"missing-name"}
+	_, err = auth.New([]string{"apikey"}, conf)
+	require.ErrorIs(t, err, auth.ErrInvalidKey)
+
+	conf.Auth.APIKeys = nil
+	_, err = auth.New([]string{"apikey"}, conf)
+	require.ErrorIs(t, err, auth.ErrMissingKeys)
+}
+
+func TestToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer ")
+
+	_, err := auth.NewToken("admin", "").Authenticate(req)
+	require.ErrorIs(t, err, auth.ErrNoCredentials, "expected an empty token to never match")
+}
+
+func TestJWT(t *testing.T) {
+	authenticator, err := auth.NewJWT(base64.StdEncoding.EncodeToString(jwtSecret), "courier-tests", "courier", time.Minute)
+	require.NoError(t, err, "could not create jwt authenticator")
+
+	now := time.Now()
+	testCases := []struct {
+		token string
+		err   error
+	}{
+		{token(t, map[string]any{"sub": "carol", "iss": "courier-tests", "aud": []string{"other", "courier"}, "exp": now.Add(time.Hour).Unix()}), nil},
+		{token(t, map[string]any{"sub": "carol", "iss": "courier-tests", "aud": "courier", "exp": now.Add(-30 * time.Second).Unix()}), nil},
+		{token(t, map[string]any{"sub": "carol", "iss": "courier-tests", "aud": "courier", "exp": now.Add(-time.Hour).Unix()}), auth.ErrExpired},
+		{token(t, map[string]any{"sub": "carol", "iss": "courier-tests", "aud": "courier", "nbf": now.Add(time.Hour).Unix()}), auth.ErrInvalidCredentials},
+		{token(t, map[string]any{"sub": "carol", "iss": "someone-else", "aud": "courier"}), auth.ErrInvalidCredentials},
+		{token(t, map[string]any{"sub": "carol", "iss": "courier-tests", "aud": "other"}), auth.ErrInvalidCredentials},
+		{token(t, map[string]any{"iss": "courier-tests", "aud": "courier"}), auth.ErrInvalidCredentials},
+		{encode(t, "none", map[string]any{"sub": "carol", "iss": "courier-tests", "aud": "courier"}) + ".", auth.ErrInvalidCredentials},
+		{encode(t, "HS256", map[string]any{"sub": "carol", "iss": "courier-tests", "aud": "courier"}) + ".c2lnbmF0dXJl", auth.ErrInvalidCredentials},
+		{"not-a-jwt", auth.ErrNoCredentials},
+	}
+
+	for i, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+
+		identity, err := authenticator.Authenticate(req)
+		if tc.err != nil {
+			require.ErrorIs(t, err, tc.err, "test case %d failed", i)
+			continue
+		}
+
+		require.NoError(t, err, "test case %d failed", i)
+		require.Equal(t, "carol", identity.Subject, "test case %d failed", i)
+	}
+
+	_, err = auth.NewJWT("not base64!", "", "", 0)
+	require.ErrorIs(t, err, auth.ErrInvalidKey)
+}
+
+func TestHMAC(t *testing.T) {
+	authenticator, err := auth.NewHMAC([]string{"node:" + base64.StdEncoding.EncodeToString(hmacKey)}, time.Minute, 16)
+	require.NoError(t, err, "could not create hmac authenticator")
+
+	signed := func(body string, now time.Time) *http.Request {
+		req := httptest.NewRequest(http.MethodPut, "/v1/secrets/foo?version=2", strings.NewReader(body))
+		auth.Sign(req, "node", hmacKey, []byte(body), now)
+		return req
+	}
+
+	// The body can be read by the handler after the signature is verified
+	req := signed(`{"secret":"a"}`, time.Now())
+	identity, err := authenticator.Authenticate(req)
+	require.NoError(t, err, "could not authenticate signed request")
+	require.Equal(t, &middleware.Identity{Subject: "node", Method: middleware.AuthHMAC}, identity)
+
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"secret":"a"}`, string(body))
+
+	// Modified requests are rejected
+	req = signed(`{"secret":"a"}`, time.Now())
+	req.Body = io.NopCloser(strings.NewReader(`{"secret":"b"}`))
+	_, err = authenticator.Authenticate(req)
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	req = signed("", time.Now())
+	req.URL.RawQuery = "version=3"
+	_, err = authenticator.Authenticate(req)
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	// Requests signed outside of the clock skew are rejected
+	_, err = authenticator.Authenticate(signed("", time.Now().Add(-time.Hour)))
+	require.ErrorIs(t, err, auth.ErrClockSkew)
+
+	// Bodies larger than the limit are not read into memory
+	_, err = authenticator.Authenticate(signed(strings.Repeat("a", 17), time.Now()))
+	require.ErrorIs(t, err, auth.ErrBodyTooLarge)
+
+	// Requests signed with an unknown key are rejected
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	auth.Sign(req, "other", hmacKey, nil, time.Now())
+	_, err = authenticator.Authenticate(req)
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	_, err = authenticator.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.ErrorIs(t, err, auth.ErrNoCredentials)
+}
+
+func TestMTLS(t *testing.T) {
+	withCert := func(subject string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: subject}}
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return req
+	}
+
+	authenticator := auth.NewMTLS([]string{"trisa.example.com"})
+	identity, err := authenticator.Authenticate(withCert("trisa.example.com"))
+	require.NoError(t, err, "could not authenticate client certificate")
+	require.Equal(t, &middleware.Identity{Subject: "trisa.example.com", Method: middleware.AuthMTLS}, identity)
+
+	_, err = authenticator.Authenticate(withCert("other.example.com"))
+	require.ErrorIs(t, err, auth.ErrSubjectNotAllowed)
+
+	_, err = authenticator.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.ErrorIs(t, err, auth.ErrNoCredentials)
+
+	identity, err = auth.NewMTLS(nil).Authenticate(withCert("other.example.com"))
+	require.NoError(t, err, "expected any verified certificate to be accepted without subjects")
+	require.Equal(t, "other.example.com", identity.Subject)
+}
+
+// token creates a JWT with the claims signed with the test secret.
+func token(t *testing.T, claims map[string]any) string {
+	unsigned := encode(t, "HS256", claims)
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// encode creates the header and payload of a JWT with the algorithm and claims.
+func encode(t *testing.T, alg string, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+}
@@ -0,0 +1,15 @@
+package auth
+
+import "errors"
+
+var (
+	ErrNoCredentials        = errors.New("request does not contain credentials for the authenticator")
+	ErrUnknownAuthenticator = errors.New("unknown authenticator")
+	ErrInvalidKey           = errors.New("invalid authentication key")
+	ErrMissingKeys          = errors.New("authenticator requires at least one key")
+	ErrInvalidCredentials   = errors.New("invalid credentials")
+	ErrSubjectNotAllowed    = errors.New("client certificate subject is not allowed")
+	ErrExpired              = errors.New("credentials have expired")
+	ErrClockSkew            = errors.New("request date is outside of the allowed clock skew")
+	ErrBodyTooLarge         = errors.New("request body is too large to verify")
+)
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/middleware"
+)
+
+// HMACScheme is the authorization scheme of signed requests, which are authorized with
+// "HMAC-SHA256 {key id}:{base64 signature}" and dated with the Date header.
+const HMACScheme = "HMAC-SHA256"
+
+// HMACAuthenticator authenticates requests that are signed with a shared key. The
+// signature covers the method, the request uri, the date, and the digest of the body,
+// and the date must be within the clock skew of the server so that signed requests
+// cannot be replayed indefinitely.
+type HMACAuthenticator struct {
+	keys    map[string][]byte
+	skew    time.Duration
+	maxBody int64
+	now     func() time.Time
+}
+
+// NewHMAC returns an authenticator for the base64 encoded keys, configured as id:key
+// pairs. Bodies larger than maxBody are rejected since they must be read to verify
+// the signature; zero does not limit the body.
+func NewHMAC(items []string, skew time.Duration, maxBody int64) (_ *HMACAuthenticator, err error) {
+	if len(items) == 0 {
+		return nil, ErrMissingKeys
+	}
+
+	var keys map[string]string
+	if keys, err = pairs(items); err != nil {
+		return nil, err
+	}
+
+	a := &HMACAuthenticator{keys: make(map[string][]byte, len(keys)), skew: skew, maxBody: maxBody, now: time.Now}
+	for id, key := range keys {
+		if a.keys[id], err = base64.StdEncoding.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("%w: hmac key %s must be base64 encoded", ErrInvalidKey, id)
+		}
+	}
+	return a, nil
+}
+
+// Authenticate returns the id of the key that signed the request. The body is read to
+// verify the signature and replaced so that handlers can read it.
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (_ *middleware.Identity, err error) {
+	credentials, ok := strings.CutPrefix(r.Header.Get("Authorization"), HMACScheme+" ")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	id, encoded, ok := strings.Cut(credentials, ":")
+	key, known := a.keys[id]
+	if !ok || !known {
+		return nil, ErrInvalidCredentials
+	}
+
+	var signature []byte
+	if signature, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	var date time.Time
+	if date, err = http.ParseTime(r.Header.Get("Date")); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if skew := a.now().Sub(date); skew > a.skew || skew < -a.skew {
+		return nil, ErrClockSkew
+	}
+
+	var body []byte
+	if body, err = a.readBody(r); err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(signature, sign(key, r, body)) {
+		return nil, ErrInvalidCredentials
+	}
+	return &middleware.Identity{Subject: id, Method: middleware.AuthHMAC}, nil
+}
+
+func (a *HMACAuthenticator) readBody(r *http.Request) (body []byte, err error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	reader := io.Reader(r.Body)
+	if a.maxBody > 0 {
+		reader = io.LimitReader(r.Body, a.maxBody+1)
+	}
+
+	if body, err = io.ReadAll(reader); err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+
+	if a.maxBody > 0 && int64(len(body)) > a.maxBody {
+		return nil, ErrBodyTooLarge
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// Sign signs the request with the key so that it can be authenticated by an
+// HMACAuthenticator, setting the Date header to now if it is not already set.
+func Sign(r *http.Request, id string, key, body []byte, now time.Time) {
+	if r.Header.Get("Date") == "" {
+		r.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	}
+	r.Header.Set("Authorization", HMACScheme+" "+id+":"+base64.StdEncoding.EncodeToString(sign(key, r, body)))
+}
+
+func sign(key []byte, r *http.Request, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", r.Method, r.URL.RequestURI(), r.Header.Get("Date"), hex.EncodeToString(digest[:]))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/middleware"
+)
+
+// JWTAuthenticator authenticates requests by a bearer JWT signed with HS256. The
+// subject claim is required and the expiration, not before, issuer, and audience
+// claims are checked if they are present or configured.
+type JWTAuthenticator struct {
+	secret   []byte
+	issuer   string
+	audience string
+	skew     time.Duration
+	now      func() time.Time
+}
+
+// NewJWT returns an authenticator for JWTs signed with the base64 encoded secret.
+func NewJWT(secret, issuer, audience string, skew time.Duration) (_ *JWTAuthenticator, err error) {
+	a := &JWTAuthenticator{issuer: issuer, audience: audience, skew: skew, now: time.Now}
+	if a.secret, err = base64.StdEncoding.DecodeString(secret); err != nil || len(a.secret) == 0 {
+		return nil, fmt.Errorf("%w: jwt secret must be base64 encoded", ErrInvalidKey)
+	}
+	return a, nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+}
+
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// audience is a single audience or a list of audiences.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// Authenticate returns the subject of the JWT in the authorization header. Bearer
+// tokens that are not JWTs are passed to the next authenticator.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (_ *middleware.Identity, err error) {
+	token, ok := bearer(r)
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+
+	parts := strings.Split(token, ".")
+	var header jwtHeader
+	if err = decodeSegment(parts[0], &header); err != nil || header.Algorithm != "HS256" {
+		return nil, ErrInvalidCredentials
+	}
+
+	var signature []byte
+	if signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidCredentials
+	}
+
+	var claims jwtClaims
+	if err = decodeSegment(parts[1], &claims); err != nil || claims.Subject == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	now := a.now()
+	if claims.ExpiresAt != 0 && now.After(time.Unix(claims.ExpiresAt, 0).Add(a.skew)) {
+		return nil, ErrExpired
+	}
+
+	if claims.NotBefore != 0 && now.Add(a.skew).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrInvalidCredentials
+	}
+
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, ErrInvalidCredentials
+	}
+
+	if a.audience != "" && !contains(claims.Audience, a.audience) {
+		return nil, ErrInvalidCredentials
+	}
+	return &middleware.Identity{Subject: claims.Subject, Method: middleware.AuthJWT}, nil
+}
+
+func decodeSegment(segment string, v any) (err error) {
+	var data []byte
+	if data, err = base64.RawURLEncoding.DecodeString(segment); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
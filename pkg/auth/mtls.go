@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/trisacrypto/courier/pkg/middleware"
+)
+
+// MTLSAuthenticator authenticates requests by the verified client certificate of the
+// connection. If subjects are configured, only certificates with one of the common
+// names are accepted.
+type MTLSAuthenticator struct {
+	subjects map[string]struct{}
+}
+
+// NewMTLS returns an authenticator that accepts verified client certificates with one
+// of the common names, or any verified client certificate if there are none.
+func NewMTLS(subjects []string) *MTLSAuthenticator {
+	a := &MTLSAuthenticator{}
+	if len(subjects) > 0 {
+		a.subjects = make(map[string]struct{}, len(subjects))
+		for _, subject := range subjects {
+			a.subjects[subject] = struct{}{}
+		}
+	}
+	return a
+}
+
+// Authenticate returns the common name of the verified client certificate.
+func (a *MTLSAuthenticator) Authenticate(r *http.Request) (*middleware.Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if a.subjects != nil {
+		if _, ok := a.subjects[subject]; !ok {
+			return nil, ErrSubjectNotAllowed
+		}
+	}
+	return &middleware.Identity{Subject: subject, Method: middleware.AuthMTLS}, nil
+}
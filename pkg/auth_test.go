@@ -0,0 +1,157 @@
+package courier_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/auth"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+)
+
+func (s *courierTestSuite) TestAuthChains() {
+	require := s.Require()
+	ctx := context.Background()
+
+	hmacKey := []byte("supersecrethmacsigningkey")
+	jwtSecret := []byte("supersecretjwtsigningkey")
+
+	conf := testConfig()
+	conf.Auth = config.AuthConfig{
+		Delivery:  []string{"apikey", "hmac"},
+		Admin:     []string{"jwt"},
+		APIKeys:   []string{"alice:alice-key"},
+		HMACKeys:  []string{"node:" + base64.StdEncoding.EncodeToString(hmacKey)},
+		JWTSecret: base64.StdEncoding.EncodeToString(jwtSecret),
+		ClockSkew: time.Minute,
+	}
+	srv, client, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(err, "could not open memory store")
+	srv.SetStore(db)
+
+	storeSecret := func(sign func(*http.Request, []byte)) *http.Response {
+		body := []byte(`{"base64_data":"c2VjcmV0"}`)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, srv.URL()+"/v1/secrets/foo", bytes.NewReader(body))
+		require.NoError(err, "could not create request")
+		req.Header.Set("Content-Type", "application/json")
+		sign(req, body)
+
+		rep, err := http.DefaultClient.Do(req)
+		require.NoError(err, "could not make request")
+		rep.Body.Close()
+		return rep
+	}
+
+	s.Run("Delivery", func() {
+		err := client.StoreSecret(ctx, &api.StoreSecretRequest{Name: "foo", Base64Data: "c2VjcmV0"})
+		s.CheckHTTPStatus(err, http.StatusUnauthorized, "expected deliveries to require authentication")
+
+		rep := storeSecret(func(req *http.Request, _ []byte) { req.Header.Set(auth.APIKeyHeader, "wrong-key") })
+		require.Equal(http.StatusUnauthorized, rep.StatusCode, "expected the api key to be checked")
+		require.Contains(rep.Header.Get("WWW-Authenticate"), `realm="courier"`)
+
+		rep = storeSecret(func(req *http.Request, _ []byte) { req.Header.Set(auth.APIKeyHeader, "alice-key") })
+		require.Equal(http.StatusNoContent, rep.StatusCode, "expected the api key to be accepted")
+
+		rep = storeSecret(func(req *http.Request, body []byte) { auth.Sign(req, "node", hmacKey, body, time.Now()) })
+		require.Equal(http.StatusNoContent, rep.StatusCode, "expected the signed request to be accepted")
+
+		secret, err := db.GetSecret(ctx, "foo")
+		require.NoError(err, "expected the secret to be stored")
+		require.Equal([]byte("secret"), secret)
+	})
+
+	s.Run("Admin", func() {
+		// The admin token is not accepted since it is not in the admin chain
+		admin, err := api.New(srv.URL(), api.WithRetries(0), api.WithAdminToken("admin-token"))
+		require.NoError(err, "could not create client")
+		_, err = admin.AdminConfig(ctx)
+		s.CheckHTTPStatus(err, http.StatusUnauthorized, "expected a jwt to be required")
+
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+		claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"operator"}`))
+		mac := hmac.New(sha256.New, jwtSecret)
+		mac.Write([]byte(header + "." + claims))
+		token := header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+		admin, err = api.New(srv.URL(), api.WithRetries(0), api.WithAdminToken(token))
+		require.NoError(err, "could not create client")
+		rep, err := admin.AdminConfig(ctx)
+		require.NoError(err, "expected the jwt to be accepted")
+		require.Equal("apikey,hmac", rep.Config["COURIER_AUTH_DELIVERY"])
+		require.Equal(config.Redacted, rep.Config["COURIER_AUTH_JWT_SECRET"])
+	})
+}
+
+func (s *courierTestSuite) TestAuthPolicy() {
+	require := s.Require()
+	ctx := context.Background()
+
+	path := filepath.Join(s.T().TempDir(), "policy.rules")
+	require.NoError(os.WriteFile(path, []byte(`
+# Only alice can delete secrets
+deny: method == "DELETE" && identity.subject != "alice"
+
+# Callers can only write their own secrets unless they are on the ops team
+allow: id.startsWith(identity.subject + "-")
+  || metadata["x-team"] == "ops"
+`), 0600))
+
+	conf := testConfig()
+	conf.Disclosure.Policy = config.DisclosureDetailed
+	conf.Auth = config.AuthConfig{
+		Delivery: []string{"apikey"},
+		Admin:    []string{"token"},
+		APIKeys:  []string{"alice:alice-key", "bob:bob-key"},
+		Policy:   path,
+	}
+	srv, _, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(err, "could not open memory store")
+	srv.SetStore(db)
+
+	do := func(method, name, key string, header http.Header) int {
+		body := []byte(`{"base64_data":"c2VjcmV0"}`)
+		req, err := http.NewRequestWithContext(ctx, method, srv.URL()+"/v1/secrets/"+name, bytes.NewReader(body))
+		require.NoError(err, "could not create request")
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(auth.APIKeyHeader, key)
+
+		rep, err := http.DefaultClient.Do(req)
+		require.NoError(err, "could not make request")
+		rep.Body.Close()
+		return rep.StatusCode
+	}
+
+	require.Equal(http.StatusNoContent, do(http.MethodPut, "bob-1", "bob-key", nil), "bob can write his own secrets")
+	require.Equal(http.StatusForbidden, do(http.MethodPut, "alice-1", "bob-key", nil), "bob cannot write the secrets of alice")
+	require.Equal(http.StatusNoContent, do(http.MethodPut, "alice-1", "bob-key", http.Header{"X-Team": {"ops"}}), "the ops team can write any secret")
+	require.Equal(http.StatusForbidden, do(http.MethodDelete, "bob-1", "bob-key", nil), "only alice can delete secrets")
+	require.Equal(http.StatusNoContent, do(http.MethodDelete, "alice-1", "alice-key", nil), "alice can delete her own secrets")
+	require.Equal(http.StatusUnauthorized, do(http.MethodPut, "bob-1", "wrong-key", nil), "requests are authenticated before they are authorized")
+
+	// The policy is reloaded from the file and an invalid policy is not loaded
+	require.NoError(os.WriteFile(path, []byte(`allow: identity.subject in ["alice", "bob"]`), 0600))
+	require.NoError(srv.ReloadPolicy(), "could not reload policy")
+	require.Equal(http.StatusNoContent, do(http.MethodPut, "alice-1", "bob-key", nil), "the reloaded policy should be in effect")
+
+	require.NoError(os.WriteFile(path, []byte(`allow: identity.subject in`), 0600))
+	require.Error(srv.ReloadPolicy(), "an invalid policy should not be loaded")
+	require.Equal(http.StatusNoContent, do(http.MethodDelete, "alice-1", "bob-key", nil), "the previous policy should be kept")
+}
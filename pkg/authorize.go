@@ -0,0 +1,152 @@
+package courier
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/middleware"
+	"github.com/trisacrypto/courier/pkg/policy"
+)
+
+// Authorize evaluates the policy for each request of the route group after it has been
+// authenticated, with the id in the URL parameter, and rejects the requests that the
+// policy denies like other forbidden requests so that the uniform disclosure policy
+// applies. Denied requests and rules that could not be evaluated are logged with the
+// line of the rule that decided the request.
+func (s *Server) Authorize(param, notFound string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var id string
+		if param != "" {
+			id = c.Param(param)
+		}
+
+		in := s.policyInput(c, id)
+		decision := s.policy.Evaluate(in)
+		if decision.Allowed {
+			c.Next()
+			return
+		}
+
+		ctx := middleware.Logger(c).Info()
+		if decision.Err != nil {
+			ctx = middleware.Logger(c).Warn().Err(decision.Err)
+		}
+
+		if decision.Rule != nil {
+			ctx = ctx.Int("rule", decision.Rule.Line).Str("effect", decision.Rule.Effect)
+		}
+
+		ctx.Str("method", in.Method).
+			Str("path", in.Route).
+			Str("id", in.ID).
+			Str("subject", in.Identity.Subject).
+			Msg("request denied by authorization policy")
+
+		s.forbidden(c, "request is not permitted by the authorization policy", notFound)
+		c.Abort()
+	}
+}
+
+// permitted returns true if the policy allows the request to access the resource with
+// the id, e.g. to receive the events of a certificate on an event stream. Streams are
+// not rejected when an id is denied, so denials are only logged at debug level and
+// rules that could not be evaluated are logged as warnings.
+func (s *Server) permitted(c *gin.Context, id string) bool {
+	if s.policy == nil {
+		return true
+	}
+
+	in := s.policyInput(c, id)
+	decision := s.policy.Evaluate(in)
+	if decision.Allowed {
+		return true
+	}
+
+	ctx := middleware.Logger(c).Debug()
+	if decision.Err != nil {
+		ctx = middleware.Logger(c).Warn().Err(decision.Err)
+	}
+
+	if decision.Rule != nil {
+		ctx = ctx.Int("rule", decision.Rule.Line).Str("effect", decision.Rule.Effect)
+	}
+
+	ctx.Str("path", in.Route).
+		Str("id", id).
+		Str("subject", in.Identity.Subject).
+		Msg("event stream access denied by authorization policy")
+	return false
+}
+
+// policyInput describes the request to the policy as a request for the resource with
+// the id. Credential headers are not included in the metadata of the request.
+func (s *Server) policyInput(c *gin.Context, id string) *policy.Input {
+	in := &policy.Input{
+		Method:   c.Request.Method,
+		Route:    c.FullPath(),
+		ID:       id,
+		Tenant:   middleware.Tenant(c),
+		IP:       c.ClientIP(),
+		Metadata: make(map[string]string, len(c.Request.Header)),
+	}
+
+	if identity, ok := middleware.GetIdentity(c); ok {
+		in.Identity = policy.Identity{Subject: identity.Subject, Method: identity.Method}
+	}
+
+	for name, values := range c.Request.Header {
+		if !isCredentialHeader(name) {
+			in.Metadata[strings.ToLower(name)] = strings.Join(values, ",")
+		}
+	}
+	return in
+}
+
+// ReloadPolicy reloads the authorization policy from its file. The current policy is
+// kept if the file cannot be read or parsed.
+func (s *Server) ReloadPolicy() error {
+	if s.policy == nil {
+		return nil
+	}
+
+	if err := s.policy.Reload(); err != nil {
+		return err
+	}
+
+	log.Info().Str("path", s.conf.Auth.Policy).Int("rules", s.policy.Policy().Rules()).Msg("authorization policy loaded")
+	return nil
+}
+
+// reloadPolicy reloads the authorization policy when the server receives a SIGHUP so
+// that rules can be changed without restarting the server.
+func (s *Server) reloadPolicy(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+
+		if err := s.ReloadPolicy(); err != nil {
+			log.Error().Err(err).Msg("could not reload authorization policy, the previous policy is still in effect")
+		}
+	}
+}
+
+func isCredentialHeader(name string) bool {
+	for _, header := range credentialHeaders {
+		if strings.EqualFold(name, header) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package courier
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/o11y"
+)
+
+// Results of cacheable requests, recorded by the cache metric and the cache header.
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// CacheResponse serves successful responses to the status, version, and certificate
+// metadata endpoints from a short lived cache so that aggressive monitoring pollers do
+// not consume handler and store resources. Responses are sent with a Cache-Control
+// header so that clients can also avoid repeating requests within the ttl. Cached
+// certificate metadata is invalidated when the certificate is written or deleted.
+func (s *Server) CacheResponse() gin.HandlerFunc {
+	control := "private, max-age=" + strconv.Itoa(int(s.cache.ttl.Seconds()))
+	return func(c *gin.Context) {
+		key := c.Request.URL.RequestURI()
+		if entry, ok := s.cache.get(key, time.Now()); ok {
+			o11y.CachedResponses.WithLabelValues(cacheHit).Inc()
+			c.Header("Cache-Control", control)
+			c.Header(api.HeaderCache, cacheHit)
+			c.Data(entry.status, entry.contentType, entry.body)
+			c.Abort()
+			return
+		}
+
+		o11y.CachedResponses.WithLabelValues(cacheMiss).Inc()
+		c.Header("Cache-Control", control)
+		c.Header(api.HeaderCache, cacheMiss)
+
+		response := &cacheWriter{ResponseWriter: c.Writer}
+		c.Writer = response
+		c.Next()
+
+		if c.Writer.Status() == http.StatusOK {
+			s.cache.put(key, &cached{
+				id:          c.Param("id"),
+				status:      c.Writer.Status(),
+				contentType: c.Writer.Header().Get("Content-Type"),
+				body:        response.body.Bytes(),
+			}, time.Now())
+		}
+	}
+}
+
+// cacheable returns the handler with the response cache middleware if it is enabled.
+func (s *Server) cacheable(handler gin.HandlerFunc) []gin.HandlerFunc {
+	if s.cache == nil {
+		return []gin.HandlerFunc{handler}
+	}
+	return []gin.HandlerFunc{s.CacheResponse(), handler}
+}
+
+// invalidate removes the cached metadata of the certificate, if caching is enabled, and
+// publishes the invalidation to the other replicas if they share a bus.
+func (s *Server) invalidate(id string) {
+	if s.cache != nil {
+		s.cache.invalidate(id)
+	}
+
+	if s.peers != nil {
+		s.peers.publish(id)
+	}
+}
+
+// responses holds cached responses by request uri until they expire. If the cache is
+// full, expired responses are removed and new responses are not cached until there is
+// room for them.
+type responses struct {
+	sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]*cached
+}
+
+type cached struct {
+	id          string
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+func newResponses(ttl time.Duration, size int) *responses {
+	return &responses{ttl: ttl, size: size, entries: make(map[string]*cached)}
+}
+
+func (r *responses) get(key string, now time.Time) (_ *cached, ok bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	var entry *cached
+	if entry, ok = r.entries[key]; !ok {
+		return nil, false
+	}
+
+	if now.After(entry.expires) {
+		delete(r.entries, key)
+		return nil, false
+	}
+	return entry, true
+}
+
+func (r *responses) put(key string, entry *cached, now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.size > 0 && len(r.entries) >= r.size {
+		for k, e := range r.entries {
+			if now.After(e.expires) {
+				delete(r.entries, k)
+			}
+		}
+
+		if len(r.entries) >= r.size {
+			return
+		}
+	}
+
+	entry.expires = now.Add(r.ttl)
+	r.entries[key] = entry
+}
+
+// Removes every cached response of the resource with the id.
+func (r *responses) invalidate(id string) {
+	r.Lock()
+	defer r.Unlock()
+	for key, entry := range r.entries {
+		if entry.id == id {
+			delete(r.entries, key)
+		}
+	}
+}
+
+// CacheWriter copies the response body so that it can be cached.
+type cacheWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *cacheWriter) Write(p []byte) (n int, err error) {
+	n, err = w.ResponseWriter.Write(p)
+	w.body.Write(p[:n])
+	return n, err
+}
+
+func (w *cacheWriter) WriteString(s string) (n int, err error) {
+	n, err = w.ResponseWriter.WriteString(s)
+	w.body.WriteString(s[:n])
+	return n, err
+}
@@ -0,0 +1,144 @@
+package courier_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	natsd "github.com/nats-io/nats-server/v2/server"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+)
+
+func (s *courierTestSuite) TestCacheResponse() {
+	require := s.Require()
+	ctx := context.Background()
+
+	get := func(url string) (*http.Response, *api.CertificateVersionsReply) {
+		rep, err := http.Get(url)
+		require.NoError(err, "could not make request")
+		defer rep.Body.Close()
+
+		out := &api.CertificateVersionsReply{}
+		if rep.StatusCode == http.StatusOK {
+			require.NoError(json.NewDecoder(rep.Body).Decode(out))
+		}
+		return rep, out
+	}
+
+	s.Run("Disabled", func() {
+		rep, _ := get(s.courier.URL() + "/v1/status")
+		require.Equal(http.StatusOK, rep.StatusCode)
+		require.Empty(rep.Header.Get("Cache-Control"), "expected no cache headers when the cache is disabled")
+		require.Empty(rep.Header.Get(api.HeaderCache))
+	})
+
+	conf := testConfig()
+	conf.Cache = config.CacheConfig{TTL: time.Minute, MaxEntries: 10}
+	srv, client, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(err, "could not open memory store")
+	srv.SetStore(db)
+
+	s.Run("Status", func() {
+		rep, _ := get(srv.URL() + "/v1/status")
+		require.Equal(http.StatusOK, rep.StatusCode)
+		require.Equal("private, max-age=60", rep.Header.Get("Cache-Control"))
+		require.Equal("miss", rep.Header.Get(api.HeaderCache))
+
+		rep, _ = get(srv.URL() + "/v1/status")
+		require.Equal(http.StatusOK, rep.StatusCode)
+		require.Equal("hit", rep.Header.Get(api.HeaderCache))
+		require.Equal("application/json; charset=utf-8", rep.Header.Get("Content-Type"))
+	})
+
+	s.Run("Invalidated", func() {
+		require.NoError(db.UpdateCertificate(ctx, "foo", []byte("certificate")))
+
+		rep, out := get(srv.URL() + "/v1/certs/foo/versions")
+		require.Equal("miss", rep.Header.Get(api.HeaderCache))
+		require.Len(out.Versions, 1)
+
+		// Writes outside of the api are not seen until the response expires
+		require.NoError(db.UpdateCertificate(ctx, "foo", []byte("rotated")))
+		rep, out = get(srv.URL() + "/v1/certs/foo/versions")
+		require.Equal("hit", rep.Header.Get(api.HeaderCache))
+		require.Len(out.Versions, 1)
+
+		// Deleting the certificate with the api invalidates its cached metadata
+		require.NoError(client.DeleteCertificate(ctx, "foo"))
+		rep, _ = get(srv.URL() + "/v1/certs/foo/versions")
+		require.Equal(http.StatusNotFound, rep.StatusCode)
+		require.Equal("miss", rep.Header.Get(api.HeaderCache))
+
+		// Error responses are not cached
+		rep, _ = get(srv.URL() + "/v1/certs/foo/versions")
+		require.Equal("miss", rep.Header.Get(api.HeaderCache))
+	})
+}
+
+func (s *courierTestSuite) TestCachePeers() {
+	require := s.Require()
+	ctx := context.Background()
+
+	bus, err := natsd.NewServer(&natsd.Options{Host: "127.0.0.1", Port: natsd.RANDOM_PORT, NoLog: true, NoSigs: true})
+	require.NoError(err, "could not create nats server")
+	go bus.Start()
+	defer bus.Shutdown()
+	require.True(bus.ReadyForConnections(5*time.Second), "nats server did not start")
+
+	// Two replicas share the store and publish invalidations to the bus
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(err, "could not open memory store")
+
+	conf := testConfig()
+	conf.Cache = config.CacheConfig{TTL: time.Minute, Bus: bus.ClientURL(), Subject: "courier.cache.invalidate"}
+
+	replicaA, client, _ := s.startServer(conf)
+	defer replicaA.Shutdown()
+	replicaA.SetStore(db)
+
+	replicaB, _, _ := s.startServer(conf)
+	defer replicaB.Shutdown()
+	replicaB.SetStore(db)
+
+	require.Eventually(func() bool {
+		connz, err := bus.Connz(&natsd.ConnzOptions{})
+		require.NoError(err, "could not list nats connections")
+
+		var subs uint32
+		for _, conn := range connz.Conns {
+			subs += conn.NumSubs
+		}
+		return subs == 2
+	}, time.Second, 10*time.Millisecond)
+
+	versions := func() (string, int) {
+		rep, err := http.Get(replicaB.URL() + "/v1/certs/foo/versions")
+		require.NoError(err, "could not make request")
+		defer rep.Body.Close()
+
+		out := &api.CertificateVersionsReply{}
+		json.NewDecoder(rep.Body).Decode(out)
+		return rep.Header.Get(api.HeaderCache), len(out.Versions)
+	}
+
+	require.NoError(db.UpdateCertificate(ctx, "foo", []byte("certificate")))
+	result, n := versions()
+	require.Equal("miss", result)
+	require.Equal(1, n)
+
+	result, _ = versions()
+	require.Equal("hit", result)
+
+	// Deleting the certificate on replica A invalidates the cache of replica B
+	require.NoError(client.DeleteCertificate(ctx, "foo"))
+	require.Eventually(func() bool {
+		result, n = versions()
+		return result == "miss" && n == 0
+	}, time.Second, 10*time.Millisecond, "expected the cache of replica b to be invalidated")
+}
@@ -0,0 +1,256 @@
+/*
+Package canonical serializes values to the JSON Canonicalization Scheme (RFC 8785) so
+that anything that is signed or hashed, such as receipts and audit records, has exactly
+one byte representation. Signatures and digests computed over canonical JSON verify
+across courier versions and in other languages, regardless of struct field order, map
+iteration order, whitespace, or how numbers and strings would otherwise be encoded.
+
+Values are first marshaled with encoding/json, so struct tags and json.Marshaler
+implementations are honored, and the result is then rewritten in canonical form: object
+members are sorted by the UTF-16 code units of their names, insignificant whitespace is
+removed, strings use the minimal escaping of RFC 8785, and numbers are formatted as
+ECMAScript formats doubles.
+*/
+package canonical
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidJSON   = errors.New("data is not valid json")
+	ErrInvalidNumber = errors.New("number cannot be represented as a finite double")
+)
+
+// Marshal returns the canonical JSON encoding of v.
+func Marshal(v interface{}) (_ []byte, err error) {
+	var data []byte
+	if data, err = json.Marshal(v); err != nil {
+		return nil, err
+	}
+	return Transform(data)
+}
+
+// Transform rewrites JSON data in canonical form. Duplicate object member names are
+// rejected since they cannot be canonicalized unambiguously.
+func Transform(data []byte) (_ []byte, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	if err = transform(dec, &buf); err != nil {
+		return nil, err
+	}
+
+	// The data must contain exactly one value.
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%w: unexpected data after the top-level value", ErrInvalidJSON)
+	}
+	return buf.Bytes(), nil
+}
+
+// Equal returns true if a and b are the same JSON value in canonical form.
+func Equal(a, b []byte) bool {
+	ca, err := Transform(a)
+	if err != nil {
+		return false
+	}
+
+	cb, err := Transform(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ca, cb)
+}
+
+type member struct {
+	name  string
+	key   []uint16
+	value []byte
+}
+
+// transform reads the next value from the decoder and writes it in canonical form.
+func transform(dec *json.Decoder, buf *bytes.Buffer) (err error) {
+	var tok json.Token
+	if tok, err = dec.Token(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidJSON, err)
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			return transformObject(dec, buf)
+		case '[':
+			return transformArray(dec, buf)
+		default:
+			return fmt.Errorf("%w: unexpected %s", ErrInvalidJSON, t)
+		}
+	case string:
+		writeString(buf, t)
+	case json.Number:
+		var num string
+		if num, err = formatNumber(t); err != nil {
+			return err
+		}
+		buf.WriteString(num)
+	case bool:
+		buf.WriteString(strconv.FormatBool(t))
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func transformObject(dec *json.Decoder, buf *bytes.Buffer) (err error) {
+	members := make([]member, 0)
+	seen := make(map[string]struct{})
+
+	for dec.More() {
+		var tok json.Token
+		if tok, err = dec.Token(); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidJSON, err)
+		}
+
+		name := tok.(string)
+		if _, ok := seen[name]; ok {
+			return fmt.Errorf("%w: duplicate member %q", ErrInvalidJSON, name)
+		}
+		seen[name] = struct{}{}
+
+		var value bytes.Buffer
+		if err = transform(dec, &value); err != nil {
+			return err
+		}
+		members = append(members, member{name: name, key: utf16.Encode([]rune(name)), value: value.Bytes()})
+	}
+
+	// Consume the closing delimiter
+	if _, err = dec.Token(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidJSON, err)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return less(members[i].key, members[j].key)
+	})
+
+	buf.WriteByte('{')
+	for i, m := range members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeString(buf, m.name)
+		buf.WriteByte(':')
+		buf.Write(m.value)
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func transformArray(dec *json.Decoder, buf *bytes.Buffer) (err error) {
+	buf.WriteByte('[')
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err = transform(dec, buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+
+	// Consume the closing delimiter
+	if _, err = dec.Token(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidJSON, err)
+	}
+	return nil
+}
+
+// less compares member names by their UTF-16 code units as required by RFC 8785.
+func less(a, b []uint16) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+const hex = "0123456789abcdef"
+
+// writeString writes s as a JSON string, escaping only the characters that must be
+// escaped and using the short escapes where they exist.
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			buf.WriteRune(r)
+			i += size
+			continue
+		}
+
+		switch c {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[c>>4])
+				buf.WriteByte(hex[c&0xf])
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+		i++
+	}
+	buf.WriteByte('"')
+}
+
+// formatNumber formats the number as ECMAScript formats doubles, which is the same
+// algorithm encoding/json uses to marshal float64 values.
+func formatNumber(n json.Number) (_ string, err error) {
+	var f float64
+	if f, err = strconv.ParseFloat(string(n), 64); err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidNumber, n)
+	}
+
+	if f == 0 {
+		// Negative zero is serialized as zero
+		return "0", nil
+	}
+
+	abs := math.Abs(f)
+	if abs < 1e21 && abs >= 1e-6 {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+
+	// Exponents are written without leading zeros, e.g. 1e-7 rather than 1e-07
+	num := strconv.FormatFloat(f, 'e', -1, 64)
+	if n := len(num); n >= 4 && num[n-4] == 'e' && num[n-3] == '-' && num[n-2] == '0' {
+		num = num[:n-2] + num[n-1:]
+	}
+	return num, nil
+}
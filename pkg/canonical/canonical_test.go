@@ -0,0 +1,86 @@
+package canonical_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/canonical"
+)
+
+func TestTransform(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Whitespace", "{ \"b\" : [ 1 , 2 ] ,\n\t\"a\" : null }", `{"a":null,"b":[1,2]}`},
+		{"Nested", `{"z":{"y":true,"x":false},"a":[{"d":1,"c":2}]}`, `{"a":[{"c":2,"d":1}],"z":{"x":false,"y":true}}`},
+		{"Empty", `{"a":{},"b":[]}`, `{"a":{},"b":[]}`},
+		{"Scalar", `"courier"`, `"courier"`},
+		{"Escapes", `"\u0041\u000f\n\"\\\/\u20ac\u2028"`, "\"A\\u000f\\n\\\"\\\\/\u20ac\u2028\""},
+		{"HTML", `"<a href=\"x\">&</a>"`, `"<a href=\"x\">&</a>"`},
+		{"Numbers", `[1.0, -0, 1E2, 0.000001, 1e-7, 1e21, 123456789012345678, 3.3333333333333335, -1.5e+300]`, `[1,0,100,0.000001,1e-7,1e+21,123456789012345680,3.3333333333333335,-1.5e+300]`},
+		// Members are sorted by their UTF-16 code units (RFC 8785 section 3.2.3)
+		{"Sorting", `{"\u20ac":"Euro Sign","\r":"Carriage Return","\ufb33":"Hebrew Letter Dalet With Dagesh","1":"One","\ud83d\ude00":"Emoji: Grinning Face","\u0080":"Control","\u00f6":"Latin Small Letter O With Diaeresis"}`,
+			"{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001F600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := canonical.Transform([]byte(tc.input))
+			require.NoError(t, err, "could not transform json")
+			require.Equal(t, tc.expected, string(actual))
+
+			// Canonical json is a fixed point of the transform
+			again, err := canonical.Transform(actual)
+			require.NoError(t, err, "could not transform canonical json")
+			require.Equal(t, actual, again)
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, input := range []string{``, `{`, `{"a":1,"a":2}`, `[1,]`, `{} {}`, `{} x`, `1e400`} {
+			_, err := canonical.Transform([]byte(input))
+			require.Error(t, err, "expected error for %q", input)
+		}
+	})
+}
+
+func TestMarshal(t *testing.T) {
+	type record struct {
+		Type      string            `json:"type"`
+		ID        string            `json:"id"`
+		Timestamp time.Time         `json:"timestamp"`
+		Size      int64             `json:"size"`
+		Labels    map[string]string `json:"labels,omitempty"`
+	}
+
+	rec := record{
+		Type:      "certificate_stored",
+		ID:        "certID",
+		Timestamp: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Size:      2048,
+		Labels:    map[string]string{"zone": "b", "env": "<prod>"},
+	}
+
+	data, err := canonical.Marshal(rec)
+	require.NoError(t, err, "could not marshal record")
+	require.Equal(t, `{"id":"certID","labels":{"env":"<prod>","zone":"b"},"size":2048,"timestamp":"2024-03-01T12:00:00Z","type":"certificate_stored"}`, string(data))
+
+	// Values that encode to the same json have the same canonical form
+	generic := map[string]interface{}{"type": rec.Type, "size": 2048.0, "id": rec.ID, "timestamp": rec.Timestamp, "labels": rec.Labels}
+	other, err := canonical.Marshal(generic)
+	require.NoError(t, err, "could not marshal map")
+	require.Equal(t, data, other)
+
+	indented, err := json.MarshalIndent(rec, "", "  ")
+	require.NoError(t, err, "could not indent record")
+	require.True(t, canonical.Equal(data, indented), "indented json should be canonically equal")
+	require.False(t, canonical.Equal(data, []byte(`{"id":"other"}`)), "different json should not be equal")
+
+	_, err = canonical.Marshal(math.Inf(1))
+	require.Error(t, err, "infinity cannot be marshaled")
+}
@@ -1,60 +1,109 @@
 package courier
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 	"github.com/trisacrypto/courier/pkg/api/v1"
 	"github.com/trisacrypto/courier/pkg/o11y"
 	"github.com/trisacrypto/courier/pkg/store"
 	"github.com/trisacrypto/trisa/pkg/trust"
 )
 
+// Limits that oversized certificate uploads exceed, recorded by the oversized metric.
+const (
+	oversizedUpload      = "upload"
+	oversizedCertificate = "certificate"
+)
+
+var errCertificateTooLarge = errors.New("certificate exceeds the maximum certificate size")
+
 // StoreCertificate decodes a base64-encoded certificate in the request, decrypts it
 // using the password in the store, and stores the decrypted certificate in the store.
 // The NoDecrypt option can be used to skip the decryption and store the certificate in
-// its encrypted form.
+// its encrypted form. Certificates can also be uploaded as raw pkcs12 data with the
+// application/octet-stream content type, in which case the no_decrypt query parameter
+// is used in place of the NoDecrypt option. PEM encoded certificate chains, either
+// declared with the PEM content type or detected from the payload, are not encrypted
+// so they are validated and stored directly without decryption. If the If-Match header
+// or the ExpectedVersion option is set, the certificate is only stored if the ETag of
+// the stored certificate matches, otherwise a 412 Precondition Failed is returned.
+// Certificates larger than the maximum certificate size are rejected with a 413
+// before they are decoded.
 func (s *Server) StoreCertificate(c *gin.Context) {
 	var (
-		err error
-		req *api.StoreCertificateRequest
+		err         error
+		data        []byte
+		contentType string
+		noDecrypt   bool
 	)
 
 	id := c.Param("id")
 	ctx := c.Request.Context()
 
-	// Parse the request body
-	req = &api.StoreCertificateRequest{}
-	if err := c.BindJSON(req); err != nil {
-		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
-		return
+	// Bound the size of the request body before it is read
+	if s.conf.MaxUploadSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.conf.MaxUploadSize)
 	}
 
-	// Certificate is required
-	if req.Base64Certificate == "" {
-		c.JSON(http.StatusBadRequest, api.ErrorResponse("missing certificate in request"))
-		return
+	// Parse the certificate data from the request body
+	expectedVersion := c.GetHeader("If-Match")
+	switch contentType = c.ContentType(); contentType {
+	case api.ContentTypeOctetStream, api.ContentTypePKCS12, api.ContentTypePEM:
+		data, noDecrypt, err = rawCertificate(c, s.conf.MaxCertificateSize)
+	default:
+		var req *api.StoreCertificateRequest
+		if req, data, err = jsonCertificate(c, s.conf.MaxCertificateSize); err == nil {
+			contentType, noDecrypt = req.ContentType, req.NoDecrypt
+			if req.ExpectedVersion != "" {
+				expectedVersion = req.ExpectedVersion
+			}
+		}
 	}
 
-	// Decode the certificate data from the request
-	var data []byte
-	if data, err = base64.StdEncoding.DecodeString(req.Base64Certificate); err != nil {
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			o11y.Oversized.WithLabelValues(oversizedUpload).Inc()
+			c.JSON(http.StatusRequestEntityTooLarge, api.ErrorResponse("certificate exceeds the maximum upload size"))
+			return
+		}
+
+		if errors.Is(err, errCertificateTooLarge) {
+			o11y.Oversized.WithLabelValues(oversizedCertificate).Inc()
+			c.JSON(http.StatusRequestEntityTooLarge, api.ErrorResponse(err))
+			return
+		}
 		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
 		return
 	}
 
-	if !req.NoDecrypt {
+	// PEM encoded certificates are stored as is
+	if contentType == api.ContentTypePEM || isPEM(data) {
+		if !validPEM(data) {
+			c.JSON(http.StatusBadRequest, api.ErrorResponse("could not parse PEM encoded certificate chain"))
+			return
+		}
+		noDecrypt = true
+	}
+
+	if !noDecrypt {
 		// If decryption is enabled, retrieve the pkcs12 password from the store
 		var password []byte
 		if password, err = s.store.GetPassword(ctx, id); err != nil {
-			if errors.Is(err, store.ErrNotFound) {
-				c.JSON(http.StatusNotFound, api.ErrorResponse("pkcs12 password not found, unable to decrypt certificate"))
-				return
-			}
-
-			c.JSON(http.StatusInternalServerError, api.ErrorResponse(err))
+			storeError(c, err, "pkcs12 password not found, unable to decrypt certificate")
 			return
 		}
 
@@ -72,23 +121,544 @@ func (s *Server) StoreCertificate(c *gin.Context) {
 		}
 	}
 
-	// Store the certificate data
-	if err = s.store.UpdateCertificate(ctx, id, data); err != nil {
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse(err))
+	// Store the certificate data, only replacing the expected version if specified
+	if expectedVersion != "" {
+		if !s.compareAndUpdateCertificate(c, id, expectedVersion, data) {
+			return
+		}
+	} else if err = s.store.UpdateCertificate(ctx, id, data); err != nil {
+		storeError(c, err, "certificate not found")
 		return
 	}
 
 	// Return 204 No Content
 	o11y.Certificates.Inc()
+	s.setLastDelivery(time.Now())
+	s.arrivals.notify(id)
+	if !noDecrypt {
+		s.publishCertificate(api.EventCertificateDecrypted, id, data)
+	}
+	s.publishCertificate(api.EventCertificateStored, id, data)
+	c.Status(http.StatusNoContent)
+}
+
+// Parse the certificate data from a base64 encoded certificate in a JSON request. The
+// decoded size of the certificate is checked against the maximum size before it is
+// decoded so that an oversized certificate is rejected without allocating it again.
+func jsonCertificate(c *gin.Context, maxSize int64) (req *api.StoreCertificateRequest, data []byte, err error) {
+	req = &api.StoreCertificateRequest{}
+	if err = c.ShouldBindJSON(req); err != nil {
+		return nil, nil, err
+	}
+
+	// Certificate is required
+	if req.Base64Certificate == "" {
+		return nil, nil, errors.New("missing certificate in request")
+	}
+
+	switch req.ContentType {
+	case "", api.ContentTypePKCS12, api.ContentTypePEM:
+	default:
+		return nil, nil, fmt.Errorf("unsupported certificate content type %q", req.ContentType)
+	}
+
+	if maxSize > 0 && decodedLen(req.Base64Certificate) > maxSize {
+		return nil, nil, errCertificateTooLarge
+	}
+
+	if data, err = base64.StdEncoding.DecodeString(req.Base64Certificate); err != nil {
+		return nil, nil, err
+	}
+	return req, data, nil
+}
+
+// Replaces the stored certificate only if its ETag matches the expected version so
+// that a second delivery does not silently clobber a newer certificate. If the stored
+// certificate has changed a 412 Precondition Failed response is written with the
+// current version and false is returned.
+func (s *Server) compareAndUpdateCertificate(c *gin.Context, id, expected string, data []byte) bool {
+	var (
+		err     error
+		current []byte
+		token   string
+	)
+
+	ctx := c.Request.Context()
+	if current, token, err = s.store.GetCertificateWithToken(ctx, id); err != nil && !errors.Is(err, store.ErrNotFound) {
+		storeError(c, err, "certificate not found")
+		return false
+	}
+
+	// A missing certificate does not match any version, including *
+	if current == nil || !matchETag(expected, contentETag(current), false) {
+		versionMismatch(c, current)
+		return false
+	}
+
+	if _, err = s.store.CompareAndUpdateCertificate(ctx, id, token, data); err != nil {
+		if errors.Is(err, store.ErrVersionMismatch) {
+			// The certificate was modified concurrently, report the newest version
+			current, _ = s.store.GetCertificate(ctx, id)
+			versionMismatch(c, current)
+			return false
+		}
+
+		storeError(c, err, "certificate not found")
+		return false
+	}
+	return true
+}
+
+// Writes a 412 Precondition Failed response with the version of the current data.
+func versionMismatch(c *gin.Context, current []byte) {
+	out := &api.VersionMismatchReply{
+		Success: false,
+		Error:   "certificate does not match the expected version",
+	}
+
+	if current != nil {
+		out.CurrentVersion = contentETag(current)
+		c.Header("ETag", out.CurrentVersion)
+	}
+	c.JSON(http.StatusPreconditionFailed, out)
+}
+
+// Read the raw certificate data from the request body.
+func rawCertificate(c *gin.Context, maxSize int64) (data []byte, noDecrypt bool, err error) {
+	if param := c.Query("no_decrypt"); param != "" {
+		if noDecrypt, err = strconv.ParseBool(param); err != nil {
+			return nil, false, errors.New("could not parse no_decrypt query parameter")
+		}
+	}
+
+	// Read at most one byte more than the maximum size to detect oversized certificates
+	body := io.Reader(c.Request.Body)
+	if maxSize > 0 {
+		body = io.LimitReader(body, maxSize+1)
+	}
+
+	if data, err = io.ReadAll(body); err != nil {
+		return nil, false, err
+	}
+
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, false, errCertificateTooLarge
+	}
+
+	// Certificate is required
+	if len(data) == 0 {
+		return nil, false, errors.New("missing certificate in request")
+	}
+	return data, noDecrypt, nil
+}
+
+// Returns the number of bytes that the base64 encoded data decodes to, ignoring the
+// padding, without decoding it.
+func decodedLen(encoded string) int64 {
+	n := int64(base64.StdEncoding.DecodedLen(len(encoded)))
+	return n - int64(len(encoded)-len(strings.TrimRight(encoded, "=")))
+}
+
+// Returns true if the certificate data looks like a PEM encoded certificate chain
+// rather than pkcs12 data.
+func isPEM(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN"))
+}
+
+// Returns true if the certificate data is a PEM encoded chain with a leaf certificate.
+func validPEM(data []byte) bool {
+	provider, err := trust.New(data)
+	if err != nil {
+		return false
+	}
+
+	_, err = provider.GetLeafCertificate()
+	return err == nil
+}
+
+// RetrieveCertificate returns the certificate stored with the id as base64-encoded
+// data, allowing nodes to retrieve their identity certificates from courier. The
+// response includes an ETag so that polling clients can send If-None-Match and receive
+// a 304 Not Modified response if the certificate has not changed. If one-time pickup
+// is configured or requested with the once query parameter, the certificate is deleted
+// when it is returned.
+func (s *Server) RetrieveCertificate(c *gin.Context) {
+	var (
+		err  error
+		data []byte
+		once bool
+	)
+
+	if once, err = s.oneTime(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	id := c.Param("id")
+	if data, err = s.store.GetCertificate(c.Request.Context(), id); err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	// Polling clients can skip downloading certificates that have not changed
+	if notModified(c, contentETag(data)) {
+		return
+	}
+
+	// One-time certificates are deleted before they are returned
+	if once && !s.pickup(c, store.CertificatePrefix, id, "certificate not found") {
+		return
+	}
+
+	c.JSON(http.StatusOK, &api.CertificateReply{
+		ID:                id,
+		Base64Certificate: base64.StdEncoding.EncodeToString(data),
+	})
+}
+
+// HeadCertificate responds to HEAD requests with the ETag and size of the certificate
+// stored with the id so that clients can check that a delivery completed without
+// downloading the certificate.
+func (s *Server) HeadCertificate(c *gin.Context) {
+	var (
+		err  error
+		data []byte
+	)
+
+	if data, err = s.store.GetCertificate(c.Request.Context(), c.Param("id")); err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	c.Header(api.HeaderResourceSize, strconv.Itoa(len(data)))
+	if notModified(c, contentETag(data)) {
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// DownloadCertificate returns the certificate stored with the id as raw PEM or pkcs12
+// data instead of base64 encoded JSON so that large certificate chains can be saved
+// directly by provisioning tools. Range requests are supported so that an interrupted
+// download can be resumed; clients should send the ETag of the partial download in the
+// If-Range header so that the full certificate is returned if it changed in between.
+// If-None-Match and If-Match requests are evaluated against the ETag. One-time
+// downloads are always returned in full since the certificate is deleted when it is
+// returned and the remaining bytes could not be requested again.
+func (s *Server) DownloadCertificate(c *gin.Context) {
+	var (
+		err  error
+		data []byte
+		once bool
+	)
+
+	if once, err = s.oneTime(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	id := c.Param("id")
+	if data, err = s.store.GetCertificate(c.Request.Context(), id); err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	contentType, filename := api.ContentTypePKCS12, id+".p12"
+	if isPEM(data) {
+		contentType, filename = api.ContentTypePEM, id+".pem"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if once {
+		if notModified(c, contentETag(data)) {
+			return
+		}
+
+		if !s.pickup(c, store.CertificatePrefix, id, "certificate not found") {
+			return
+		}
+
+		c.Data(http.StatusOK, contentType, data)
+		return
+	}
+
+	c.Header("ETag", contentETag(data))
+	c.Header("Content-Type", contentType)
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(data))
+}
+
+// ListCertificateVersions returns the versions of the certificate stored with the id,
+// newest first.
+func (s *Server) ListCertificateVersions(c *gin.Context) {
+	var (
+		err      error
+		versions []store.Version
+	)
+
+	id := c.Param("id")
+	if versions, err = s.store.ListCertificateVersions(c.Request.Context(), id); err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	out := &api.CertificateVersionsReply{
+		ID:       id,
+		Versions: make([]*api.CertificateVersion, 0, len(versions)),
+	}
+
+	for _, version := range versions {
+		out.Versions = append(out.Versions, &api.CertificateVersion{
+			Version: version.Version,
+			Created: version.Created,
+			State:   version.State,
+		})
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// RetrieveCertificateVersion returns a specific version of the certificate stored with
+// the id as a base64 encoded string.
+func (s *Server) RetrieveCertificateVersion(c *gin.Context) {
+	var (
+		err  error
+		data []byte
+	)
+
+	id := c.Param("id")
+	version := c.Param("version")
+	if data, err = s.store.GetCertificateVersion(c.Request.Context(), id, version); err != nil {
+		storeError(c, err, "certificate version not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, &api.CertificateReply{
+		ID:                id,
+		Version:           version,
+		Base64Certificate: base64.StdEncoding.EncodeToString(data),
+	})
+}
+
+// RestoreCertificateVersion rolls back an overwritten certificate by storing a prior
+// version as the latest version and returns a 204 No Content response. The restored
+// data is written as a new version so that the certificate it replaces is kept as a
+// prior version and the rollback can itself be undone.
+func (s *Server) RestoreCertificateVersion(c *gin.Context) {
+	var (
+		err  error
+		data []byte
+	)
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	version := c.Param("version")
+	if data, err = s.store.GetCertificateVersion(ctx, id, version); err != nil {
+		storeError(c, err, "certificate version not found")
+		return
+	}
+
+	if err = s.store.UpdateCertificate(ctx, id, data); err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	log.Info().Str("id", id).Str("version", version).Msg("certificate version restored")
+	s.arrivals.notify(id)
+	s.publishCertificate(api.EventCertificateStored, id, data)
+	c.Status(http.StatusNoContent)
+}
+
+// CertificateDetails parses the certificate stored with the id and returns metadata
+// about the leaf certificate so that operators can verify what was delivered without
+// downloading private key material. Certificates that were stored without decryption
+// cannot be parsed and return a 422 Unprocessable Entity response.
+func (s *Server) CertificateDetails(c *gin.Context) {
+	var (
+		err  error
+		data []byte
+	)
+
+	id := c.Param("id")
+	if data, err = s.store.GetCertificate(c.Request.Context(), id); err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	var out *api.CertificateDetailsReply
+	if out, err = certificateDetails(data); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, api.ErrorResponse("could not parse stored certificate, it may still be encrypted"))
+		return
+	}
+
+	out.ID = id
+	c.JSON(http.StatusOK, out)
+}
+
+// CertificateMetadata returns when the certificate stored with the id was written and
+// how it is stored, e.g. so that operators can see when a certificate arrived. The
+// size and digest are computed from the stored data; the created timestamp and whether
+// the certificate is encrypted at rest are only returned by backends that record them.
+func (s *Server) CertificateMetadata(c *gin.Context) {
+	var (
+		err  error
+		data []byte
+	)
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if data, err = s.store.GetCertificate(ctx, id); err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	out := &api.MetadataReply{
+		ID:     id,
+		SHA256: store.Checksum(data),
+		Size:   int64(len(data)),
+	}
+
+	if describer, ok := store.Describer(s.store); ok {
+		var meta *store.Metadata
+		if meta, err = describer.Metadata(ctx, store.CertificatePrefix, id); err != nil {
+			storeError(c, err, "certificate not found")
+			return
+		}
+
+		if !meta.Created.IsZero() {
+			out.Created = &meta.Created
+		}
+		out.Updated = meta.Updated
+		out.Encrypted = &meta.Encrypted
+	} else {
+		if out.Updated, err = s.store.Modified(ctx, store.CertificatePrefix, id); err != nil {
+			storeError(c, err, "certificate not found")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// PublicCertificate returns the leaf certificate and chain of the certificate stored
+// with the id as PEM encoded data without the private key, so that the public material
+// can be shared with counterparties. Certificates that were stored without decryption
+// cannot be parsed and return a 422 Unprocessable Entity response.
+func (s *Server) PublicCertificate(c *gin.Context) {
+	data, ok := s.publicChain(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.Data(http.StatusOK, api.ContentTypePEM, data)
+}
+
+const (
+	// Default amount of time a signed url is valid if no ttl is specified.
+	DefaultSignedURLTTL = 15 * time.Minute
+
+	// Maximum amount of time a signed url can be valid, which is the longest expiration
+	// of both s3 presigned urls and cloud storage signed urls.
+	MaxSignedURLTTL = 7 * 24 * time.Hour
+)
+
+// PublicCertificateURL publishes the leaf certificate and chain of the certificate
+// stored with the id to the object storage backend and returns a signed url that
+// downloads it directly from the bucket, so that external systems can fetch the public
+// chain without proxying it through courier. The ttl query parameter limits how long
+// the url is valid. A 501 Not Implemented response is returned if the storage backend
+// cannot sign urls. Published chains are not removed when the certificate is deleted.
+func (s *Server) PublicCertificateURL(c *gin.Context) {
+	var (
+		err    error
+		signed string
+		ttl    = DefaultSignedURLTTL
+	)
+
+	if param := c.Query("ttl"); param != "" {
+		if ttl, err = time.ParseDuration(param); err != nil || ttl < time.Second || ttl > MaxSignedURLTTL {
+			c.JSON(http.StatusBadRequest, api.ErrorResponse("ttl must be a duration between 1s and 168h"))
+			return
+		}
+	}
+
+	publisher, ok := store.Publisher(s.store)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, api.ErrorResponse("store does not support signed urls"))
+		return
+	}
+
+	id := c.Param("id")
+	data, ok := s.publicChain(c, id)
+	if !ok {
+		return
+	}
+
+	// The expiration is computed before signing so that it is never later than the url
+	expires := time.Now().Add(ttl).UTC().Truncate(time.Second)
+	if signed, err = publisher.Publish(c.Request.Context(), id+".pem", data, api.ContentTypePEM, ttl); err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, &api.SignedURLReply{URL: signed, Expires: expires})
+}
+
+// publicChain returns the PEM encoded leaf certificate and chain of the certificate
+// stored with the id without its private key. If the chain cannot be returned the error
+// response is written and false is returned.
+func (s *Server) publicChain(c *gin.Context, id string) (_ []byte, ok bool) {
+	var (
+		err  error
+		data []byte
+	)
+
+	if data, err = s.store.GetCertificate(c.Request.Context(), id); err != nil {
+		storeError(c, err, "certificate not found")
+		return nil, false
+	}
+
+	var provider *trust.Provider
+	if provider, err = trust.New(data); err == nil {
+		_, err = provider.GetLeafCertificate()
+	}
+
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, api.ErrorResponse("could not parse stored certificate, it may still be encrypted"))
+		return nil, false
+	}
+
+	if data, err = provider.Public().Encode(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not encode public certificate"))
+		return nil, false
+	}
+	return data, true
+}
+
+// DeleteCertificate removes the certificate stored with the id, e.g. if it was
+// delivered in error or has been rotated out, and returns a 204 No Content response.
+func (s *Server) DeleteCertificate(c *gin.Context) {
+	if err := s.store.DeleteCertificate(c.Request.Context(), c.Param("id")); err != nil {
+		storeError(c, err, "certificate not found")
+		return
+	}
+
+	s.publish(api.EventCertificateDeleted, c.Param("id"))
 	c.Status(http.StatusNoContent)
 }
 
 // StoreCertificatePassword stores the password for an encrypted certificate and
-// returns a 204 No Content response.
+// returns a 204 No Content response. The password is normalized before it is stored as
+// described by the server configuration and the normalizations that were applied are
+// logged for auditing, without the password. If an encrypted certificate was already
+// stored with the id, e.g. uploaded without decryption, the password is verified
+// against it and a 409 Conflict is returned if it cannot decrypt the certificate so
+// that mistyped passwords are detected immediately; the Force option stores the
+// password anyway.
 func (s *Server) StoreCertificatePassword(c *gin.Context) {
 	var (
-		err error
-		req *api.StorePasswordRequest
+		err     error
+		req     *api.StorePasswordRequest
+		applied []string
 	)
 
 	// Parse the request body
@@ -98,19 +668,188 @@ func (s *Server) StoreCertificatePassword(c *gin.Context) {
 		return
 	}
 
+	// Normalize the password before it is validated
+	if req.Password, applied, err = normalizePassword(s.conf.Passwords, req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
 	// Password is required
 	if req.Password == "" {
 		c.JSON(http.StatusBadRequest, api.ErrorResponse("missing password in request"))
 		return
 	}
 
+	if len(applied) > 0 {
+		log.Info().Str("id", c.Param("id")).Strs("normalized", applied).Msg("normalized pkcs12 password before storing")
+	}
+
+	// Verify the password against an encrypted certificate that is already stored
+	if err = s.verifyPassword(c.Request.Context(), c.Param("id"), req.Password); err != nil {
+		if !errors.Is(err, errPasswordMismatch) {
+			storeError(c, err, "certificate not found")
+			return
+		}
+
+		if !req.Force {
+			c.JSON(http.StatusConflict, api.ErrorResponse("pkcs12 password cannot decrypt the stored certificate"))
+			return
+		}
+		log.Warn().Str("id", c.Param("id")).Msg("storing pkcs12 password that cannot decrypt the stored certificate")
+	}
+
 	// Store the password
 	if err = s.store.UpdatePassword(c.Request.Context(), c.Param("id"), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse(err))
+		storeError(c, err, "pkcs12 password not found")
 		return
 	}
 
 	// Return 204 No Content
 	o11y.Passwords.Inc()
+	s.arrivals.notify(c.Param("id"))
+	s.publish(api.EventPasswordStored, c.Param("id"))
+	c.Status(http.StatusNoContent)
+}
+
+// RetrieveCertificatePassword returns the pkcs12 password stored with the id. Because
+// the password protects private key material, retrieval must be explicitly enabled
+// in the server configuration; otherwise the response depends on the disclosure
+// policy. The password is deleted when it is returned if one-time pickup is configured
+// or requested with the once query parameter.
+func (s *Server) RetrieveCertificatePassword(c *gin.Context) {
+	if !s.conf.AllowPasswordRetrieval {
+		s.forbidden(c, "pkcs12 password retrieval is disabled", "pkcs12 password not found")
+		return
+	}
+
+	var (
+		err      error
+		password []byte
+		once     bool
+	)
+
+	if once, err = s.oneTime(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	id := c.Param("id")
+	if password, err = s.store.GetPassword(c.Request.Context(), id); err != nil {
+		storeError(c, err, "pkcs12 password not found")
+		return
+	}
+
+	// One-time passwords are deleted before they are returned
+	if once && !s.pickup(c, store.PasswordPrefix, id, "pkcs12 password not found") {
+		return
+	}
+
+	c.JSON(http.StatusOK, &api.PasswordReply{
+		ID:       id,
+		Password: string(password),
+	})
+}
+
+// HeadCertificatePassword responds to HEAD requests with the size of the pkcs12
+// password stored with the id if password retrieval is enabled. No ETag is returned
+// since a digest of the password could be used to guess it.
+func (s *Server) HeadCertificatePassword(c *gin.Context) {
+	if !s.conf.AllowPasswordRetrieval {
+		s.forbidden(c, "pkcs12 password retrieval is disabled", "pkcs12 password not found")
+		return
+	}
+
+	var (
+		err      error
+		password []byte
+	)
+
+	if password, err = s.store.GetPassword(c.Request.Context(), c.Param("id")); err != nil {
+		storeError(c, err, "pkcs12 password not found")
+		return
+	}
+
+	c.Header(api.HeaderResourceSize, strconv.Itoa(len(password)))
+	c.Status(http.StatusOK)
+}
+
+// DeleteCertificatePassword removes the pkcs12 password stored with the id and returns
+// a 204 No Content response.
+func (s *Server) DeleteCertificatePassword(c *gin.Context) {
+	if err := s.store.DeletePassword(c.Request.Context(), c.Param("id")); err != nil {
+		storeError(c, err, "pkcs12 password not found")
+		return
+	}
+
+	s.publish(api.EventPasswordDeleted, c.Param("id"))
 	c.Status(http.StatusNoContent)
 }
+
+// Returns a strong ETag computed from the digest of the stored data.
+func contentETag(data []byte) string {
+	digest := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(digest[:]) + `"`
+}
+
+// Sets the ETag header on the response and returns true with a 304 Not Modified
+// response if the etag matches one of the etags in the If-None-Match header of the
+// request. Weak comparison is used as recommended for GET requests by RFC 9110.
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	if matchETag(c.GetHeader("If-None-Match"), etag, true) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// Returns true if the etag matches any of the comma separated etags in the header or
+// if the header is *. Weak etags only match if weak comparison is allowed.
+func matchETag(header, etag string, weak bool) bool {
+	for _, match := range strings.Split(header, ",") {
+		match = strings.TrimSpace(match)
+		if weak {
+			match = strings.TrimPrefix(match, "W/")
+		}
+
+		if match == "*" || match == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse the PEM encoded certificate chain and return the details of the leaf.
+func certificateDetails(data []byte) (out *api.CertificateDetailsReply, err error) {
+	var provider *trust.Provider
+	if provider, err = trust.New(data); err != nil {
+		return nil, err
+	}
+
+	var leaf *x509.Certificate
+	if leaf, err = provider.GetLeafCertificate(); err != nil {
+		return nil, err
+	}
+
+	fingerprint := sha256.Sum256(leaf.Raw)
+	out = &api.CertificateDetailsReply{
+		Subject:           leaf.Subject.String(),
+		Issuer:            leaf.Issuer.String(),
+		SerialNumber:      hex.EncodeToString(leaf.SerialNumber.Bytes()),
+		DNSNames:          leaf.DNSNames,
+		EmailAddresses:    leaf.EmailAddresses,
+		NotBefore:         leaf.NotBefore,
+		NotAfter:          leaf.NotAfter,
+		SHA256Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+
+	for _, ip := range leaf.IPAddresses {
+		out.IPAddresses = append(out.IPAddresses, ip.String())
+	}
+
+	for _, uri := range leaf.URIs {
+		out.URIs = append(out.URIs, uri.String())
+	}
+
+	return out, nil
+}
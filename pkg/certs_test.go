@@ -1,13 +1,22 @@
 package courier_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
+	courier "github.com/trisacrypto/courier/pkg"
 	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/config"
 	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/local"
+	"github.com/trisacrypto/courier/pkg/store/mock"
 	"github.com/trisacrypto/trisa/pkg/trust"
 )
 
@@ -72,6 +81,228 @@ func (s *courierTestSuite) TestStoreCertificate() {
 		require.NoError(err, "could not store certificate")
 	})
 
+	s.Run("Upload", func() {
+		s.store.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+			return []byte("supersecretsquirrel"), nil
+		}
+
+		s.store.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			require.Equal("certID", name, "wrong cert name passed to update cert")
+			require.Equal(decrypted, cert, "wrong cert data passed to update cert")
+			return nil
+		}
+		defer s.store.Reset()
+
+		err := s.client.UploadCertificate(context.Background(), "certID", bytes.NewReader(encrypted), false)
+		require.NoError(err, "could not upload certificate")
+	})
+
+	s.Run("UploadNoDecrypt", func() {
+		s.store.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			require.Equal(encrypted, cert, "wrong cert data passed to update cert")
+			return nil
+		}
+		defer s.store.Reset()
+
+		err := s.client.UploadCertificate(context.Background(), "certID", bytes.NewReader(encrypted), true)
+		require.NoError(err, "could not upload certificate")
+	})
+
+	s.Run("UploadMissingCertificate", func() {
+		err := s.client.UploadCertificate(context.Background(), "certID", bytes.NewReader(nil), true)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for missing certificate")
+	})
+
+	s.Run("UploadTooLarge", func() {
+		conf := testConfig()
+		conf.MaxUploadSize = 64
+		srv, client, db := s.startServer(conf)
+		defer srv.Shutdown()
+
+		db.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			require.Fail("store should not be called when the upload is too large")
+			return nil
+		}
+
+		err := client.UploadCertificate(context.Background(), "certID", bytes.NewReader(encrypted), true)
+		s.CheckHTTPStatus(err, http.StatusRequestEntityTooLarge, "wrong error code for large upload")
+
+		err = client.StoreCertificate(context.Background(), &api.StoreCertificateRequest{ID: "certID", Base64Certificate: base64.StdEncoding.EncodeToString(encrypted), NoDecrypt: true})
+		s.CheckHTTPStatus(err, http.StatusRequestEntityTooLarge, "wrong error code for large certificate")
+	})
+
+	s.Run("CertificateTooLarge", func() {
+		conf := testConfig()
+		conf.MaxCertificateSize = 64
+		srv, client, db := s.startServer(conf)
+		defer srv.Shutdown()
+
+		db.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			require.Fail("store should not be called when the certificate is too large")
+			return nil
+		}
+
+		err := client.UploadCertificate(context.Background(), "certID", bytes.NewReader(encrypted), true)
+		s.CheckHTTPStatus(err, http.StatusRequestEntityTooLarge, "wrong error code for large upload")
+
+		err = client.StoreCertificate(context.Background(), &api.StoreCertificateRequest{ID: "certID", Base64Certificate: base64.StdEncoding.EncodeToString(encrypted), NoDecrypt: true})
+		s.CheckHTTPStatus(err, http.StatusRequestEntityTooLarge, "wrong error code for large certificate")
+	})
+
+	s.Run("PEM", func() {
+		req := &api.StoreCertificateRequest{
+			ID:                "certID",
+			ContentType:       api.ContentTypePEM,
+			Base64Certificate: base64.StdEncoding.EncodeToString(decrypted),
+		}
+
+		// The password should not be retrieved since PEM data is not decrypted
+		s.store.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			require.Equal(decrypted, cert, "wrong cert data passed to update cert")
+			return nil
+		}
+		defer s.store.Reset()
+
+		err := s.client.StoreCertificate(context.Background(), req)
+		require.NoError(err, "could not store PEM certificate")
+	})
+
+	s.Run("DetectPEM", func() {
+		s.store.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			require.Equal(decrypted, cert, "wrong cert data passed to update cert")
+			return nil
+		}
+		defer s.store.Reset()
+
+		err := s.client.UploadCertificate(context.Background(), "certID", bytes.NewReader(decrypted), false)
+		require.NoError(err, "could not upload PEM certificate")
+	})
+
+	s.Run("ExpectedVersion", func() {
+		stored := []byte("stored certificate")
+		s.store.OnGetCertificateWithToken = func(ctx context.Context, name string) ([]byte, string, error) {
+			return stored, "token", nil
+		}
+		s.store.OnCompareAndUpdateCertificate = func(ctx context.Context, name, token string, cert []byte) (string, error) {
+			require.Equal("token", token, "wrong token passed to compare and update")
+			require.Equal(decrypted, cert, "wrong cert data passed to compare and update")
+			return "newtoken", nil
+		}
+		defer s.store.Reset()
+
+		// Get the version of the stored certificate
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return stored, nil
+		}
+		rep, err := s.client.RetrieveCertificate(context.Background(), "certID")
+		require.NoError(err, "could not retrieve certificate")
+
+		req := &api.StoreCertificateRequest{
+			ID:                "certID",
+			ExpectedVersion:   rep.ETag,
+			Base64Certificate: base64.StdEncoding.EncodeToString(decrypted),
+		}
+		err = s.client.StoreCertificate(context.Background(), req)
+		require.NoError(err, "could not store certificate with the expected version")
+	})
+
+	s.Run("VersionMismatch", func() {
+		s.store.OnGetCertificateWithToken = func(ctx context.Context, name string) ([]byte, string, error) {
+			return []byte("newer certificate"), "token", nil
+		}
+		s.store.OnCompareAndUpdateCertificate = func(ctx context.Context, name, token string, cert []byte) (string, error) {
+			require.Fail("certificate should not be updated if the version does not match")
+			return "", nil
+		}
+		defer s.store.Reset()
+
+		req := &api.StoreCertificateRequest{
+			ID:                "certID",
+			ExpectedVersion:   `"older"`,
+			Base64Certificate: base64.StdEncoding.EncodeToString(decrypted),
+		}
+		err := s.client.StoreCertificate(context.Background(), req)
+		s.CheckHTTPStatus(err, http.StatusPreconditionFailed, "wrong error code for version mismatch")
+
+		// The current version should be returned with the If-Match header as well
+		body, err := json.Marshal(&api.StoreCertificateRequest{Base64Certificate: req.Base64Certificate})
+		require.NoError(err, "could not marshal request")
+		httpreq, err := http.NewRequest(http.MethodPost, s.courier.URL()+"/v1/certs/certID", bytes.NewReader(body))
+		require.NoError(err, "could not create request")
+		httpreq.Header.Set("Content-Type", "application/json")
+		httpreq.Header.Set("If-Match", `"older"`)
+
+		httprep, err := http.DefaultClient.Do(httpreq)
+		require.NoError(err, "could not make request")
+		defer httprep.Body.Close()
+		require.Equal(http.StatusPreconditionFailed, httprep.StatusCode)
+
+		out := &api.VersionMismatchReply{}
+		require.NoError(json.NewDecoder(httprep.Body).Decode(out), "could not decode reply")
+		require.NotEmpty(out.CurrentVersion, "expected the current version in the reply")
+		require.Equal(out.CurrentVersion, httprep.Header.Get("ETag"))
+	})
+
+	s.Run("ConcurrentModification", func() {
+		stored := []byte("stored certificate")
+		s.store.OnGetCertificateWithToken = func(ctx context.Context, name string) ([]byte, string, error) {
+			return stored, "token", nil
+		}
+		s.store.OnCompareAndUpdateCertificate = func(ctx context.Context, name, token string, cert []byte) (string, error) {
+			return "", store.ErrVersionMismatch
+		}
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return stored, nil
+		}
+		defer s.store.Reset()
+
+		rep, err := s.client.RetrieveCertificate(context.Background(), "certID")
+		require.NoError(err, "could not retrieve certificate")
+
+		req := &api.StoreCertificateRequest{
+			ID:                "certID",
+			ExpectedVersion:   rep.ETag,
+			Base64Certificate: base64.StdEncoding.EncodeToString(decrypted),
+		}
+		err = s.client.StoreCertificate(context.Background(), req)
+		s.CheckHTTPStatus(err, http.StatusPreconditionFailed, "wrong error code for concurrent modification")
+	})
+
+	s.Run("ExpectedVersionNotFound", func() {
+		s.store.OnGetCertificateWithToken = func(ctx context.Context, name string) ([]byte, string, error) {
+			return nil, "", store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		req := &api.StoreCertificateRequest{
+			ID:                "certID",
+			ExpectedVersion:   "*",
+			Base64Certificate: base64.StdEncoding.EncodeToString(decrypted),
+		}
+		err := s.client.StoreCertificate(context.Background(), req)
+		s.CheckHTTPStatus(err, http.StatusPreconditionFailed, "wrong error code for missing certificate")
+	})
+
+	s.Run("InvalidPEM", func() {
+		req := &api.StoreCertificateRequest{
+			ID:                "certID",
+			ContentType:       api.ContentTypePEM,
+			Base64Certificate: cert64,
+		}
+		err := s.client.StoreCertificate(context.Background(), req)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for invalid PEM data")
+	})
+
+	s.Run("UnsupportedContentType", func() {
+		req := &api.StoreCertificateRequest{
+			ID:                "certID",
+			ContentType:       "application/pdf",
+			Base64Certificate: cert64,
+		}
+		err := s.client.StoreCertificate(context.Background(), req)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for unsupported content type")
+	})
+
 	s.Run("MissingCertificate", func() {
 		req := &api.StoreCertificateRequest{
 			ID: "certID",
@@ -138,6 +369,671 @@ func (s *courierTestSuite) TestStoreCertificate() {
 	})
 }
 
+func (s *courierTestSuite) TestRetrieveCertificate() {
+	require := s.Require()
+
+	s.Run("HappyPath", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			require.Equal("certID", name, "wrong cert name passed to get certificate")
+			return []byte("certificate"), nil
+		}
+		defer s.store.Reset()
+
+		rep, err := s.client.RetrieveCertificate(context.Background(), "certID")
+		require.NoError(err, "could not retrieve certificate")
+		require.Equal("certID", rep.ID, "wrong certificate id returned")
+		require.Equal(base64.StdEncoding.EncodeToString([]byte("certificate")), rep.Base64Certificate, "wrong certificate data returned")
+		require.NotEmpty(rep.ETag, "expected an etag to be returned")
+	})
+
+	s.Run("NotModified", func() {
+		cert := []byte("certificate")
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return cert, nil
+		}
+		defer s.store.Reset()
+
+		rep, err := s.client.RetrieveCertificate(context.Background(), "certID")
+		require.NoError(err, "could not retrieve certificate")
+
+		_, err = s.client.RetrieveCertificateIfNoneMatch(context.Background(), "certID", rep.ETag)
+		require.ErrorIs(err, api.ErrNotModified, "expected not modified for the same certificate")
+
+		_, err = s.client.RetrieveCertificateIfNoneMatch(context.Background(), "certID", `W/"other", `+rep.ETag)
+		require.ErrorIs(err, api.ErrNotModified, "expected any etag in the list to match")
+
+		// A new certificate should be downloaded
+		cert = []byte("renewed certificate")
+		renewed, err := s.client.RetrieveCertificateIfNoneMatch(context.Background(), "certID", rep.ETag)
+		require.NoError(err, "could not retrieve renewed certificate")
+		require.Equal(base64.StdEncoding.EncodeToString(cert), renewed.Base64Certificate, "wrong certificate data returned")
+		require.NotEqual(rep.ETag, renewed.ETag, "expected the etag to change")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.RetrieveCertificate(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing certificate")
+	})
+
+	s.Run("StoreError", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, errors.New("internal store error")
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.RetrieveCertificate(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusInternalServerError, "wrong error code for store error")
+	})
+}
+
+func (s *courierTestSuite) TestHeadCertificate() {
+	require := s.Require()
+
+	s.Run("HappyPath", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			require.Equal("certID", name, "wrong cert name passed to get certificate")
+			return []byte("certificate"), nil
+		}
+		defer s.store.Reset()
+
+		info, err := s.client.StatCertificate(context.Background(), "certID")
+		require.NoError(err, "could not stat certificate")
+		require.Equal("certID", info.ID, "wrong certificate id returned")
+		require.Equal(int64(len("certificate")), info.Size, "wrong certificate size returned")
+
+		rep, err := s.client.RetrieveCertificate(context.Background(), "certID")
+		require.NoError(err, "could not retrieve certificate")
+		require.Equal(rep.ETag, info.ETag, "expected the etag to match the retrieved certificate")
+	})
+
+	s.Run("NotModified", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return []byte("certificate"), nil
+		}
+		defer s.store.Reset()
+
+		info, err := s.client.StatCertificate(context.Background(), "certID")
+		require.NoError(err, "could not stat certificate")
+
+		req, err := http.NewRequest(http.MethodHead, s.courier.URL()+"/v1/certs/certID", nil)
+		require.NoError(err, "could not create request")
+		req.Header.Set("If-None-Match", info.ETag)
+
+		rep, err := http.DefaultClient.Do(req)
+		require.NoError(err, "could not send request")
+		rep.Body.Close()
+		require.Equal(http.StatusNotModified, rep.StatusCode, "expected not modified for the same certificate")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.StatCertificate(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing certificate")
+	})
+}
+
+func (s *courierTestSuite) TestDownloadCertificate() {
+	require := s.Require()
+	ctx := context.Background()
+
+	cert := []byte("-----BEGIN CERTIFICATE-----\nlarge certificate chain\n-----END CERTIFICATE-----\n")
+	s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		require.Equal("certID", name, "wrong cert name passed to get certificate")
+		return cert, nil
+	}
+	defer s.store.Reset()
+
+	s.Run("HappyPath", func() {
+		buf := &bytes.Buffer{}
+		rep, err := s.client.DownloadCertificate(ctx, "certID", buf, nil)
+		require.NoError(err, "could not download certificate")
+		require.Equal(cert, buf.Bytes(), "wrong certificate data returned")
+		require.Equal(int64(0), rep.Offset)
+		require.Equal(int64(len(cert)), rep.Size)
+
+		info, err := s.client.StatCertificate(ctx, "certID")
+		require.NoError(err, "could not stat certificate")
+		require.Equal(info.ETag, rep.ETag, "expected the etag to match the stored certificate")
+	})
+
+	s.Run("Resume", func() {
+		// Download the first bytes of the certificate as if the connection was lost
+		req, err := http.NewRequest(http.MethodGet, s.courier.URL()+"/v1/certs/certID/download", nil)
+		require.NoError(err, "could not create request")
+		req.Header.Set("Range", "bytes=0-9")
+
+		rep, err := http.DefaultClient.Do(req)
+		require.NoError(err, "could not send request")
+		partial, _ := io.ReadAll(rep.Body)
+		rep.Body.Close()
+		require.Equal(http.StatusPartialContent, rep.StatusCode)
+		require.Equal(api.ContentTypePEM, rep.Header.Get("Content-Type"))
+		require.Equal(`attachment; filename="certID.pem"`, rep.Header.Get("Content-Disposition"))
+		require.Equal(cert[:10], partial)
+
+		// Resume the download from the end of the partial download
+		buf := bytes.NewBuffer(partial)
+		out, err := s.client.DownloadCertificate(ctx, "certID", buf, &api.ResourceInfo{ETag: rep.Header.Get("ETag"), Size: 10})
+		require.NoError(err, "could not resume download")
+		require.Equal(int64(10), out.Offset)
+		require.Equal(int64(len(cert)), out.Size)
+		require.Equal(cert, buf.Bytes(), "expected the resumed download to complete the certificate")
+
+		// A complete download does not have any remaining bytes
+		out, err = s.client.DownloadCertificate(ctx, "certID", io.Discard, &api.ResourceInfo{ETag: out.ETag, Size: out.Size})
+		require.NoError(err, "could not resume a complete download")
+		require.Equal(out.Size, out.Offset)
+
+		// The full certificate is returned if it changed since the partial download
+		buf.Reset()
+		out, err = s.client.DownloadCertificate(ctx, "certID", buf, &api.ResourceInfo{ETag: `"renewed"`, Size: 10})
+		require.NoError(err, "could not resume download")
+		require.Equal(int64(0), out.Offset, "expected the download to restart")
+		require.Equal(cert, buf.Bytes())
+	})
+
+	s.Run("NotModified", func() {
+		info, err := s.client.StatCertificate(ctx, "certID")
+		require.NoError(err, "could not stat certificate")
+
+		req, err := http.NewRequest(http.MethodGet, s.courier.URL()+"/v1/certs/certID/download", nil)
+		require.NoError(err, "could not create request")
+		req.Header.Set("If-None-Match", info.ETag)
+
+		rep, err := http.DefaultClient.Do(req)
+		require.NoError(err, "could not send request")
+		rep.Body.Close()
+		require.Equal(http.StatusNotModified, rep.StatusCode, "expected not modified for the same certificate")
+	})
+
+	s.Run("Once", func() {
+		deleted := false
+		s.store.OnDelete = func(ctx context.Context, prefix, name string) error {
+			require.Equal(store.CertificatePrefix, prefix)
+			deleted = true
+			return nil
+		}
+
+		req, err := http.NewRequest(http.MethodGet, s.courier.URL()+"/v1/certs/certID/download?once=true", nil)
+		require.NoError(err, "could not create request")
+		req.Header.Set("Range", "bytes=0-9")
+
+		rep, err := http.DefaultClient.Do(req)
+		require.NoError(err, "could not send request")
+		data, _ := io.ReadAll(rep.Body)
+		rep.Body.Close()
+		require.Equal(http.StatusOK, rep.StatusCode, "expected one-time downloads to ignore the range")
+		require.Equal(cert, data)
+		require.True(deleted, "expected the certificate to be deleted")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+
+		_, err := s.client.DownloadCertificate(ctx, "certID", io.Discard, nil)
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing certificate")
+	})
+}
+
+func (s *courierTestSuite) TestCertificateDetails() {
+	require := s.Require()
+
+	// Load the cert fixture
+	sz, err := trust.NewSerializer(true, "supersecretsquirrel")
+	require.NoError(err, "could not create serializer")
+	provider, err := sz.ReadFile("testdata/cert.zip")
+	require.NoError(err, "could not read cert fixture")
+	decrypted, err := provider.Encode()
+	require.NoError(err, "could not read cert fixture")
+	encrypted, err := provider.Encrypt("supersecretsquirrel")
+	require.NoError(err, "could not encrypt cert fixture")
+	leaf, err := provider.GetLeafCertificate()
+	require.NoError(err, "could not parse leaf certificate")
+
+	s.Run("HappyPath", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			require.Equal("certID", name, "wrong cert name passed to get cert")
+			return decrypted, nil
+		}
+		defer s.store.Reset()
+
+		rep, err := s.client.CertificateDetails(context.Background(), "certID")
+		require.NoError(err, "could not get certificate details")
+		require.Equal("certID", rep.ID)
+		require.Equal(leaf.Subject.String(), rep.Subject)
+		require.Equal(leaf.Issuer.String(), rep.Issuer)
+		require.Equal(leaf.DNSNames, rep.DNSNames)
+		require.True(leaf.NotBefore.Equal(rep.NotBefore), "wrong not before timestamp")
+		require.True(leaf.NotAfter.Equal(rep.NotAfter), "wrong not after timestamp")
+		require.Len(rep.SHA256Fingerprint, 64, "expected a hex encoded sha256 fingerprint")
+		require.NotEmpty(rep.SerialNumber)
+	})
+
+	s.Run("Encrypted", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return encrypted, nil
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.CertificateDetails(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusUnprocessableEntity, "wrong error code for encrypted certificate")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.CertificateDetails(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing certificate")
+	})
+}
+
+func (s *courierTestSuite) TestCertificateMetadata() {
+	require := s.Require()
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	s.Run("Modified", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return []byte("certificate"), nil
+		}
+		s.store.OnModified = func(ctx context.Context, prefix, name string) (time.Time, error) {
+			require.Equal(store.CertificatePrefix, prefix)
+			require.Equal("certID", name)
+			return modified, nil
+		}
+		defer s.store.Reset()
+
+		rep, err := s.client.CertificateMetadata(context.Background(), "certID")
+		require.NoError(err, "could not get certificate metadata")
+		require.Equal("certID", rep.ID)
+		require.True(modified.Equal(rep.Updated), "wrong updated timestamp")
+		require.Equal(store.Checksum([]byte("certificate")), rep.SHA256)
+		require.Equal(int64(11), rep.Size)
+		require.Nil(rep.Created, "the mock store does not record when certificates are created")
+		require.Nil(rep.Encrypted, "the mock store does not record whether certificates are encrypted")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.CertificateMetadata(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing certificate")
+	})
+
+	s.Run("Local", func() {
+		srv, client, _ := s.startServer(testConfig())
+		defer srv.Shutdown()
+
+		db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: s.T().TempDir()})
+		require.NoError(err, "could not open local store")
+		srv.SetStore(db)
+
+		require.NoError(db.UpdateCertificate(context.Background(), "certID", []byte("certificate")))
+
+		rep, err := client.CertificateMetadata(context.Background(), "certID")
+		require.NoError(err, "could not get certificate metadata")
+		require.NotNil(rep.Created, "the local store records when certificates are created")
+		require.True(rep.Created.Equal(rep.Updated), "expected a single write")
+		require.Equal(store.Checksum([]byte("certificate")), rep.SHA256)
+		require.Equal(int64(11), rep.Size)
+		require.NotNil(rep.Encrypted)
+		require.False(*rep.Encrypted, "the local store is not encrypted")
+	})
+}
+
+func (s *courierTestSuite) TestPublicCertificate() {
+	require := s.Require()
+
+	// Load the cert fixture
+	sz, err := trust.NewSerializer(true, "supersecretsquirrel")
+	require.NoError(err, "could not create serializer")
+	provider, err := sz.ReadFile("testdata/cert.zip")
+	require.NoError(err, "could not read cert fixture")
+	decrypted, err := provider.Encode()
+	require.NoError(err, "could not read cert fixture")
+	encrypted, err := provider.Encrypt("supersecretsquirrel")
+	require.NoError(err, "could not encrypt cert fixture")
+	leaf, err := provider.GetLeafCertificate()
+	require.NoError(err, "could not parse leaf certificate")
+
+	s.Run("HappyPath", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			require.Equal("certID", name, "wrong cert name passed to get cert")
+			return decrypted, nil
+		}
+		defer s.store.Reset()
+
+		data, err := s.client.PublicCertificate(context.Background(), "certID")
+		require.NoError(err, "could not get public certificate")
+
+		public, err := trust.New(data)
+		require.NoError(err, "could not parse public certificate")
+		require.False(public.IsPrivate(), "private key should not be returned")
+
+		cert, err := public.GetLeafCertificate()
+		require.NoError(err, "could not parse public leaf certificate")
+		require.Equal(leaf.Raw, cert.Raw, "wrong leaf certificate returned")
+	})
+
+	s.Run("Encrypted", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return encrypted, nil
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.PublicCertificate(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusUnprocessableEntity, "wrong error code for encrypted certificate")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.PublicCertificate(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing certificate")
+	})
+}
+
+func (s *courierTestSuite) TestPublicCertificateURL() {
+	require := s.Require()
+
+	sz, err := trust.NewSerializer(true, "supersecretsquirrel")
+	require.NoError(err, "could not create serializer")
+	provider, err := sz.ReadFile("testdata/cert.zip")
+	require.NoError(err, "could not read cert fixture")
+	decrypted, err := provider.Encode()
+	require.NoError(err, "could not read cert fixture")
+
+	s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		return decrypted, nil
+	}
+	defer s.store.Reset()
+
+	s.Run("NotImplemented", func() {
+		_, err := s.client.PublicCertificateURL(context.Background(), "certID", 0)
+		s.CheckHTTPStatus(err, http.StatusNotImplemented, "the mock store cannot sign urls")
+	})
+
+	db := &publicStore{Store: s.store}
+	s.courier.SetStore(db)
+	defer s.courier.SetStore(s.store)
+
+	s.Run("HappyPath", func() {
+		rep, err := s.client.PublicCertificateURL(context.Background(), "certID", time.Hour)
+		require.NoError(err, "could not get signed url")
+		require.Equal("https://bucket.example.com/public/certID.pem?signature", rep.URL)
+		require.WithinDuration(time.Now().Add(time.Hour), rep.Expires, 5*time.Second)
+
+		require.Equal("certID.pem", db.name)
+		require.Equal(api.ContentTypePEM, db.contentType)
+		require.Equal(time.Hour, db.ttl)
+
+		public, err := trust.New(db.data)
+		require.NoError(err, "could not parse published chain")
+		require.False(public.IsPrivate(), "private key should not be published")
+	})
+
+	s.Run("DefaultTTL", func() {
+		_, err := s.client.PublicCertificateURL(context.Background(), "certID", 0)
+		require.NoError(err, "could not get signed url")
+		require.Equal(courier.DefaultSignedURLTTL, db.ttl)
+	})
+
+	s.Run("InvalidTTL", func() {
+		_, err := s.client.PublicCertificateURL(context.Background(), "certID", 8*24*time.Hour)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "ttl should be limited to seven days")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+
+		_, err := s.client.PublicCertificateURL(context.Background(), "certID", 0)
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing certificate")
+	})
+}
+
+// publicStore adds signed urls to the mock store and records the published artifact.
+type publicStore struct {
+	*mock.Store
+	name        string
+	data        []byte
+	contentType string
+	ttl         time.Duration
+}
+
+func (s *publicStore) Publish(_ context.Context, name string, data []byte, contentType string, ttl time.Duration) (string, error) {
+	s.name, s.data, s.contentType, s.ttl = name, data, contentType, ttl
+	return "https://bucket.example.com/public/" + name + "?signature", nil
+}
+
+func (s *courierTestSuite) TestCertificateVersions() {
+	require := s.Require()
+	created := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	s.Run("List", func() {
+		s.store.OnListCertificateVersions = func(ctx context.Context, name string) ([]store.Version, error) {
+			require.Equal("certID", name, "wrong cert name passed to list versions")
+			return []store.Version{
+				{Version: "2", Created: created, State: "enabled"},
+				{Version: "1", Created: created, State: "disabled"},
+			}, nil
+		}
+		defer s.store.Reset()
+
+		rep, err := s.client.ListCertificateVersions(context.Background(), "certID")
+		require.NoError(err, "could not list certificate versions")
+		require.Equal("certID", rep.ID)
+		require.Len(rep.Versions, 2)
+		require.Equal("2", rep.Versions[0].Version)
+		require.True(created.Equal(rep.Versions[0].Created), "wrong created timestamp")
+		require.Equal("disabled", rep.Versions[1].State)
+	})
+
+	s.Run("ListNotFound", func() {
+		s.store.OnListCertificateVersions = func(ctx context.Context, name string) ([]store.Version, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.ListCertificateVersions(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing certificate")
+	})
+
+	s.Run("Retrieve", func() {
+		s.store.OnGetCertificateVersion = func(ctx context.Context, name, version string) ([]byte, error) {
+			require.Equal("certID", name, "wrong cert name passed to get version")
+			require.Equal("1", version, "wrong version passed to get version")
+			return []byte("certificate"), nil
+		}
+		defer s.store.Reset()
+
+		rep, err := s.client.RetrieveCertificateVersion(context.Background(), "certID", "1")
+		require.NoError(err, "could not retrieve certificate version")
+		require.Equal("certID", rep.ID)
+		require.Equal("1", rep.Version)
+		require.Equal(base64.StdEncoding.EncodeToString([]byte("certificate")), rep.Base64Certificate)
+	})
+
+	s.Run("RetrieveNotFound", func() {
+		s.store.OnGetCertificateVersion = func(ctx context.Context, name, version string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.RetrieveCertificateVersion(context.Background(), "certID", "42")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing version")
+	})
+
+	s.Run("Restore", func() {
+		var restored []byte
+		s.store.OnGetCertificateVersion = func(ctx context.Context, name, version string) ([]byte, error) {
+			require.Equal("certID", name, "wrong cert name passed to get version")
+			require.Equal("1", version, "wrong version passed to get version")
+			return []byte("certificate"), nil
+		}
+		s.store.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			require.Equal("certID", name, "wrong cert name passed to update certificate")
+			restored = cert
+			return nil
+		}
+		defer s.store.Reset()
+
+		err := s.client.RestoreCertificateVersion(context.Background(), "certID", "1")
+		require.NoError(err, "could not restore certificate version")
+		require.Equal([]byte("certificate"), restored, "the version should be stored as the latest")
+	})
+
+	s.Run("RestoreNotFound", func() {
+		s.store.OnGetCertificateVersion = func(ctx context.Context, name, version string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		err := s.client.RestoreCertificateVersion(context.Background(), "certID", "42")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing version")
+	})
+
+	s.Run("RestoreLocal", func() {
+		srv, client, _ := s.startServer(testConfig())
+		defer srv.Shutdown()
+
+		db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: s.T().TempDir(), MaxVersions: 3})
+		require.NoError(err, "could not open local store")
+		srv.SetStore(db)
+
+		ctx := context.Background()
+		require.NoError(db.UpdateCertificate(ctx, "certID", []byte("original")))
+		require.NoError(db.UpdateCertificate(ctx, "certID", []byte("overwrite")))
+
+		versions, err := client.ListCertificateVersions(ctx, "certID")
+		require.NoError(err, "could not list certificate versions")
+		require.Len(versions.Versions, 2, "the overwritten certificate should be kept")
+
+		prior := versions.Versions[len(versions.Versions)-1].Version
+		require.NoError(client.RestoreCertificateVersion(ctx, "certID", prior), "could not restore certificate version")
+
+		data, err := db.GetCertificate(ctx, "certID")
+		require.NoError(err)
+		require.Equal([]byte("original"), data, "the prior version should be the latest")
+
+		versions, err = client.ListCertificateVersions(ctx, "certID")
+		require.NoError(err, "could not list certificate versions")
+		require.Len(versions.Versions, 3, "the rollback should keep the overwrite as a prior version")
+	})
+}
+
+func (s *courierTestSuite) TestDeleteCertificate() {
+	require := s.Require()
+
+	s.Run("HappyPath", func() {
+		s.store.OnDeleteCertificate = func(ctx context.Context, name string) error {
+			require.Equal("certID", name, "wrong cert name passed to delete certificate")
+			return nil
+		}
+		defer s.store.Reset()
+
+		err := s.client.DeleteCertificate(context.Background(), "certID")
+		require.NoError(err, "could not delete certificate")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnDeleteCertificate = func(ctx context.Context, name string) error {
+			return store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		err := s.client.DeleteCertificate(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing certificate")
+	})
+
+	s.Run("StoreError", func() {
+		s.store.OnDeleteCertificate = func(ctx context.Context, name string) error {
+			return errors.New("internal store error")
+		}
+		defer s.store.Reset()
+
+		err := s.client.DeleteCertificate(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusInternalServerError, "wrong error code for store error")
+	})
+}
+
+func (s *courierTestSuite) TestInvalidID() {
+	// Ids that would collide with the version or metadata files of another certificate
+	// in the local store should be rejected before they reach the store.
+	for _, id := range []string{"certID@2", "certID.meta", "certID.gz"} {
+		err := s.client.StoreCertificate(context.Background(), &api.StoreCertificateRequest{ID: id, Base64Certificate: "Y2VydA==", NoDecrypt: true})
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for storing certificate %q", id)
+
+		_, err = s.client.RetrieveCertificate(context.Background(), id)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for retrieving certificate %q", id)
+
+		err = s.client.DeleteCertificate(context.Background(), id)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for deleting certificate %q", id)
+
+		err = s.client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: id, Password: "password"})
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for storing password %q", id)
+	}
+}
+
+func (s *courierTestSuite) TestStoreErrors() {
+	testCases := []struct {
+		err    error
+		status int
+	}{
+		{store.ErrNotFound, http.StatusNotFound},
+		{store.ErrAlreadyExists, http.StatusConflict},
+		{store.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge},
+		{store.ErrUnavailable, http.StatusServiceUnavailable},
+		{store.ErrPermissionDenied, http.StatusInternalServerError},
+		{errors.New("internal store error"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		s.store.OnDeleteCertificate = func(ctx context.Context, name string) error {
+			return tc.err
+		}
+
+		err := s.client.DeleteCertificate(context.Background(), "certID")
+		s.CheckHTTPStatus(err, tc.status, "wrong error code for %q", tc.err)
+	}
+
+	// Backend details wrapped by store errors should not be returned to the user
+	s.store.OnDeleteCertificate = func(ctx context.Context, name string) error {
+		return fmt.Errorf("local store: open /var/lib/courier/certificate-certID.gz: %w", store.ErrUnavailable)
+	}
+	err := s.client.DeleteCertificate(context.Background(), "certID")
+	s.CheckHTTPStatus(err, http.StatusServiceUnavailable, "wrong error code for wrapped store error")
+	s.Require().NotContains(err.Error(), "/var/lib/courier", "store error details should not be returned to the user")
+
+	s.store.OnDeleteCertificate = func(ctx context.Context, name string) error {
+		return errors.New("open /var/lib/courier/certificate-certID.gz: input/output error")
+	}
+	err = s.client.DeleteCertificate(context.Background(), "certID")
+	s.CheckHTTPStatus(err, http.StatusInternalServerError, "wrong error code for unhandled store error")
+	s.Require().NotContains(err.Error(), "/var/lib/courier", "store error details should not be returned to the user")
+	s.store.Reset()
+}
+
 func (s *courierTestSuite) TestStoreCertificatePassword() {
 	require := s.Require()
 
@@ -147,6 +1043,9 @@ func (s *courierTestSuite) TestStoreCertificatePassword() {
 			ID:       "certID",
 			Password: "password",
 		}
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
 		s.store.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
 			require.Equal(req.ID, name, "wrong password name passed to store")
 			require.Equal([]byte(req.Password), password, "wrong password passed to store")
@@ -168,6 +1067,9 @@ func (s *courierTestSuite) TestStoreCertificatePassword() {
 	})
 
 	s.Run("StoreError", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
 		s.store.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
 			return errors.New("internal store error")
 		}
@@ -181,3 +1083,109 @@ func (s *courierTestSuite) TestStoreCertificatePassword() {
 		s.CheckHTTPStatus(err, http.StatusInternalServerError, "wrong error code for store error")
 	})
 }
+
+func (s *courierTestSuite) TestRetrieveCertificatePassword() {
+	require := s.Require()
+
+	s.Run("HappyPath", func() {
+		s.store.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+			require.Equal("certID", name, "wrong password name passed to store")
+			return []byte("password"), nil
+		}
+		defer s.store.Reset()
+
+		rep, err := s.client.RetrieveCertificatePassword(context.Background(), "certID")
+		require.NoError(err, "could not retrieve certificate password")
+		require.Equal("certID", rep.ID)
+		require.Equal("password", rep.Password)
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.RetrieveCertificatePassword(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing password")
+	})
+
+	s.Run("RetrievalDisabled", func() {
+		srv, client, db := s.startServer(testConfig())
+		defer srv.Shutdown()
+
+		db.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+			require.Fail("store should not be called when password retrieval is disabled")
+			return nil, nil
+		}
+
+		_, err := client.RetrieveCertificatePassword(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "forbidden requests should be indistinguishable from missing passwords")
+	})
+}
+
+func (s *courierTestSuite) TestHeadCertificatePassword() {
+	require := s.Require()
+
+	s.Run("HappyPath", func() {
+		s.store.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+			require.Equal("certID", name, "wrong password name passed to store")
+			return []byte("password"), nil
+		}
+		defer s.store.Reset()
+
+		info, err := s.client.StatCertificatePassword(context.Background(), "certID")
+		require.NoError(err, "could not stat certificate password")
+		require.Equal("certID", info.ID)
+		require.Equal(int64(len("password")), info.Size)
+		require.Empty(info.ETag, "no etag should be returned for passwords")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.StatCertificatePassword(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing password")
+	})
+
+	s.Run("RetrievalDisabled", func() {
+		srv, client, db := s.startServer(testConfig())
+		defer srv.Shutdown()
+
+		db.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+			require.Fail("store should not be called when password retrieval is disabled")
+			return nil, nil
+		}
+
+		_, err := client.StatCertificatePassword(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "forbidden requests should be indistinguishable from missing passwords")
+	})
+}
+
+func (s *courierTestSuite) TestDeleteCertificatePassword() {
+	require := s.Require()
+
+	s.Run("HappyPath", func() {
+		s.store.OnDeletePassword = func(ctx context.Context, name string) error {
+			require.Equal("certID", name, "wrong password name passed to store")
+			return nil
+		}
+		defer s.store.Reset()
+
+		err := s.client.DeleteCertificatePassword(context.Background(), "certID")
+		require.NoError(err, "could not delete certificate password")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnDeletePassword = func(ctx context.Context, name string) error {
+			return store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		err := s.client.DeleteCertificatePassword(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing password")
+	})
+}
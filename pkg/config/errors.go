@@ -3,13 +3,75 @@ package config
 import "errors"
 
 var (
-	ErrMissingBindAddr           = errors.New("invalid configuration: missing bindaddr")
-	ErrMissingServerMode         = errors.New("invalid configuration: missing server mode (debug, release, test)")
-	ErrMissingCertPaths          = errors.New("invalid configuration: missing cert path or pool path")
-	ErrTLSNotConfigured          = errors.New("cannot create TLS configuration in insecure mode")
-	ErrMissingLocalPath          = errors.New("invalid configuration: missing path for local storage")
-	ErrNoStorageEnabled          = errors.New("invalid configuration: must enable either local storage or secret manager storage")
-	ErrMultipleStorageEnabled    = errors.New("invalid configuration: cannot enable both local storage and secret manager storage")
-	ErrMissingSecretsCredentials = errors.New("invalid configuration: missing credentials for secret manager storage")
-	ErrMissingSecretsProject     = errors.New("invalid configuration: missing project name for secret manager storage")
+	ErrMissingBindAddr            = errors.New("invalid configuration: missing bindaddr")
+	ErrInvalidListener            = errors.New("invalid configuration: listeners must be http:// or https:// addresses")
+	ErrInsecureTLSListener        = errors.New("invalid configuration: https listeners require mtls to be configured")
+	ErrMissingServerMode          = errors.New("invalid configuration: missing server mode (debug, release, test)")
+	ErrInvalidMaxUploadSize       = errors.New("invalid configuration: max upload size cannot be negative")
+	ErrInvalidMaxCertificateSize  = errors.New("invalid configuration: max certificate size cannot be negative")
+	ErrInvalidTraceRequests       = errors.New("invalid configuration: number of traced requests cannot be negative")
+	ErrTraceInRelease             = errors.New("invalid configuration: request tracing cannot be enabled in release mode")
+	ErrInvalidProxyTimeout        = errors.New("invalid configuration: proxy header timeout cannot be negative")
+	ErrInvalidTrustedProxy        = errors.New("invalid configuration: trusted proxy is not an ip address or cidr")
+	ErrInvalidDisclosurePolicy    = errors.New("invalid configuration: disclosure policy must be uniform or detailed")
+	ErrInvalidProbeThreshold      = errors.New("invalid configuration: disclosure probe threshold cannot be negative")
+	ErrInvalidProbeWindow         = errors.New("invalid configuration: disclosure probe window must be positive when probes are logged")
+	ErrInvalidDecryptionLimit     = errors.New("invalid configuration: decryption limits cannot be negative")
+	ErrInvalidDecryptionBurst     = errors.New("invalid configuration: decryption burst must be at least one when the rate is limited")
+	ErrMissingCertPaths           = errors.New("invalid configuration: missing cert path or pool path")
+	ErrTLSNotConfigured           = errors.New("cannot create TLS configuration in insecure mode")
+	ErrMissingLocalPath           = errors.New("invalid configuration: missing path for local storage")
+	ErrInvalidMaxVersions         = errors.New("invalid configuration: local storage max versions cannot be negative")
+	ErrConflictingLocalKeys       = errors.New("invalid configuration: specify either an encryption key file or a passphrase for local storage, not both")
+	ErrMissingLocalKey            = errors.New("invalid configuration: previous local storage keys require a current encryption key file or passphrase")
+	ErrInvalidWatchInterval       = errors.New("invalid configuration: local storage watch interval cannot be negative")
+	ErrInvalidLocalPermissions    = errors.New("invalid configuration: local storage permissions must be octal and allow the owner to read and write")
+	ErrNoStorageEnabled           = errors.New("invalid configuration: must enable local, in-memory, secret manager, kubernetes, postgres, s3, gcs, or ssm storage")
+	ErrMultipleStorageEnabled     = errors.New("invalid configuration: cannot enable more than one storage backend")
+	ErrMirrorRequiresBackends     = errors.New("invalid configuration: mirrored storage requires at least two storage backends")
+	ErrFailoverRequiresBackends   = errors.New("invalid configuration: failover storage requires exactly two storage backends")
+	ErrConflictingReplication     = errors.New("invalid configuration: cannot enable both mirrored and failover storage")
+	ErrInvalidFailoverInterval    = errors.New("invalid configuration: failover probe interval must be positive")
+	ErrInvalidRetention           = errors.New("invalid configuration: retention periods cannot be negative")
+	ErrInvalidRetentionAction     = errors.New("invalid configuration: retention action must be delete or archive")
+	ErrInvalidRetentionInterval   = errors.New("invalid configuration: retention interval must be positive when resources expire")
+	ErrInvalidVerification        = errors.New("invalid configuration: verification interval and expiring period cannot be negative")
+	ErrInvalidSnapshotKey         = errors.New("invalid configuration: snapshot key must be a base64 encoded 32 byte key")
+	ErrInvalidCache               = errors.New("invalid configuration: cache ttl and max entries cannot be negative")
+	ErrInvalidCacheBus            = errors.New("invalid configuration: cache bus must be a nats:// or tls:// url with a subject")
+	ErrUnknownAuthenticator       = errors.New("invalid configuration: authenticators must be mtls, token, apikey, jwt, or hmac")
+	ErrInvalidClockSkew           = errors.New("invalid configuration: auth clock skew cannot be negative")
+	ErrMissingAPIKeys             = errors.New("invalid configuration: the apikey authenticator requires api keys")
+	ErrInvalidJWTSecret           = errors.New("invalid configuration: the jwt authenticator requires a base64 encoded secret")
+	ErrMissingHMACKeys            = errors.New("invalid configuration: the hmac authenticator requires signing keys")
+	ErrAuthRequiresMTLS           = errors.New("invalid configuration: the mtls authenticator requires mtls to be configured")
+	ErrBackendNotEnabled          = errors.New("invalid configuration: storage backend is not enabled")
+	ErrUnknownBackend             = errors.New("invalid configuration: unknown storage backend")
+	ErrConflictingCredentials     = errors.New("invalid configuration: cannot set both a credentials file and credentials json for secret manager storage")
+	ErrInvalidCredentialsJSON     = errors.New("invalid configuration: secret manager credentials json is not valid json")
+	ErrInvalidSecretsReplication  = errors.New("invalid configuration: secret manager kms keys must match the replication locations")
+	ErrMissingSecretsProject      = errors.New("invalid configuration: missing project name for secret manager storage")
+	ErrInvalidSecretsTimeout      = errors.New("invalid configuration: secret manager timeout cannot be negative")
+	ErrInvalidSecretsReload       = errors.New("invalid configuration: secret manager credentials reload interval cannot be negative")
+	ErrReplicaRequiresSecrets     = errors.New("invalid configuration: gcp replication requires secret manager storage")
+	ErrInvalidReplicaProject      = errors.New("invalid configuration: gcp replica project must differ from the secret manager project")
+	ErrInvalidReplicaReconcile    = errors.New("invalid configuration: gcp replica reconcile interval cannot be negative")
+	ErrInvalidKubernetesNamespace = errors.New("invalid configuration: kubernetes namespace must be a dns label")
+	ErrInvalidKubernetesTimeout   = errors.New("invalid configuration: kubernetes timeout cannot be negative")
+	ErrMissingPostgresURL         = errors.New("invalid configuration: missing connection url for postgres storage")
+	ErrMissingPostgresDriver      = errors.New("invalid configuration: missing database driver for postgres storage")
+	ErrInvalidPostgresMaxVersions = errors.New("invalid configuration: postgres max versions cannot be negative")
+	ErrInvalidPostgresTimeout     = errors.New("invalid configuration: postgres timeout cannot be negative")
+	ErrMissingS3Bucket            = errors.New("invalid configuration: missing bucket for s3 storage")
+	ErrMissingS3Region            = errors.New("invalid configuration: missing region for s3 storage")
+	ErrInvalidS3ObjectLock        = errors.New("invalid configuration: s3 object lock requires governance or compliance mode and a positive retention")
+	ErrInvalidS3Timeout           = errors.New("invalid configuration: s3 timeout cannot be negative")
+	ErrMissingGCSBucket           = errors.New("invalid configuration: missing bucket for gcs storage")
+	ErrInvalidGCSTimeout          = errors.New("invalid configuration: gcs timeout cannot be negative")
+	ErrMissingSSMRegion           = errors.New("invalid configuration: missing region for ssm storage")
+	ErrInvalidSSMPath             = errors.New("invalid configuration: ssm path must begin with a slash")
+	ErrInvalidSSMTier             = errors.New("invalid configuration: ssm tier must be Standard, Advanced, or Intelligent-Tiering")
+	ErrInvalidSSMTimeout          = errors.New("invalid configuration: ssm timeout cannot be negative")
+	ErrInvalidCodec               = errors.New("invalid configuration: could not create the codec pipeline")
+	ErrInvalidEncryptionKey       = errors.New("invalid configuration: codec encryption key must be base64 encoded")
 )
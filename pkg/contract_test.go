@@ -0,0 +1,112 @@
+package courier_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// Contract is a golden fixture of the requests that the TRISA directory makes to
+// courier with its courier client and the responses it expects in return.
+type contract struct {
+	Description  string        `json:"description"`
+	Store        contractStore `json:"store"`
+	Interactions []struct {
+		Request struct {
+			Method  string            `json:"method"`
+			Path    string            `json:"path"`
+			Headers map[string]string `json:"headers"`
+			Body    json.RawMessage   `json:"body"`
+		} `json:"request"`
+		Response struct {
+			Status  int               `json:"status"`
+			Headers map[string]string `json:"headers"`
+			Body    json.RawMessage   `json:"body"`
+		} `json:"response"`
+	} `json:"interactions"`
+}
+
+// Contents of the store before the interactions are replayed.
+type contractStore struct {
+	Passwords map[string]string `json:"passwords"`
+}
+
+// Replays the golden contract fixtures against the server so that changes to the API
+// that would break the directory's courier client are caught by the test suite.
+func (s *courierTestSuite) TestContracts() {
+	require := s.Require()
+
+	paths, err := filepath.Glob("testdata/contracts/*.json")
+	require.NoError(err, "could not list contract fixtures")
+	require.NotEmpty(paths, "no contract fixtures found")
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		s.Run(name, func() {
+			data, err := os.ReadFile(path)
+			require.NoError(err, "could not read contract fixture")
+
+			var fixture contract
+			require.NoError(json.Unmarshal(data, &fixture), "could not parse contract fixture")
+
+			s.mockContractStore(fixture.Store)
+			defer s.store.Reset()
+
+			for _, interaction := range fixture.Interactions {
+				req, err := http.NewRequest(interaction.Request.Method, s.courier.URL()+interaction.Request.Path, bytes.NewReader(interaction.Request.Body))
+				require.NoError(err, "could not create request")
+				for key, val := range interaction.Request.Headers {
+					req.Header.Set(key, val)
+				}
+
+				rep, err := http.DefaultClient.Do(req)
+				require.NoError(err, "could not make request")
+				body, err := io.ReadAll(rep.Body)
+				rep.Body.Close()
+				require.NoError(err, "could not read response")
+
+				expected := interaction.Response
+				require.Equal(expected.Status, rep.StatusCode, "%s: unexpected status code", fixture.Description)
+				for key, val := range expected.Headers {
+					require.Equal(val, rep.Header.Get(key), "%s: unexpected %s header", fixture.Description, key)
+				}
+
+				if len(expected.Body) > 0 {
+					require.JSONEq(string(expected.Body), string(body), "%s: unexpected response body", fixture.Description)
+				}
+			}
+		})
+	}
+}
+
+// Configures the store mock to serve the passwords in the contract and accept updates.
+func (s *courierTestSuite) mockContractStore(contents contractStore) {
+	passwords := make(map[string][]byte)
+	for name, password := range contents.Passwords {
+		passwords[name] = []byte(password)
+	}
+
+	s.store.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
+		passwords[name] = password
+		return nil
+	}
+	s.store.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+		if password, ok := passwords[name]; ok {
+			return password, nil
+		}
+		return nil, store.ErrNotFound
+	}
+	s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		return nil, store.ErrNotFound
+	}
+	s.store.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+		return nil
+	}
+}
@@ -0,0 +1,53 @@
+package courier
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// storeError maps typed store errors to HTTP status codes and writes the JSON error
+// response so that handlers behave uniformly across storage backends. The notFound
+// message is returned to the user if the resource does not exist. Store errors wrap
+// backend details such as file paths and rpc errors, so only the typed error message
+// is returned to the user and the full error is logged.
+func storeError(c *gin.Context, err error, notFound string) {
+	c.Error(err)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		c.JSON(http.StatusNotFound, api.ErrorResponse(notFound))
+	case errors.Is(err, store.ErrAlreadyExists):
+		c.JSON(http.StatusConflict, api.ErrorResponse(store.ErrAlreadyExists))
+	case errors.Is(err, store.ErrPayloadTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, api.ErrorResponse(store.ErrPayloadTooLarge))
+	case errors.Is(err, store.ErrNoVersioning):
+		c.JSON(http.StatusNotImplemented, api.ErrorResponse("store does not support versions"))
+	case errors.Is(err, store.ErrUnavailable):
+		c.JSON(http.StatusServiceUnavailable, api.ErrorResponse(store.ErrUnavailable))
+	case errors.Is(err, store.ErrPermissionDenied):
+		// Permission errors are a server misconfiguration, not a client error
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("courier is not permitted to access its store"))
+	case errors.Is(err, store.ErrCorrupted):
+		// Corrupted resources must be restored or redelivered by an operator
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse(store.ErrCorrupted))
+	default:
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("an internal error occurred in the courier store"))
+	}
+}
+
+// storeHealthError returns a stable description of a store failure that is safe to
+// report in responses, since store errors wrap backend details such as file paths and
+// rpc errors. The full error should be logged by the caller.
+func storeHealthError(err error) string {
+	switch {
+	case errors.Is(err, store.ErrUnavailable):
+		return store.ErrUnavailable.Error()
+	case errors.Is(err, store.ErrPermissionDenied):
+		return "courier is not permitted to access its store"
+	default:
+		return "an internal error occurred in the courier store"
+	}
+}
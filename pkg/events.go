@@ -0,0 +1,144 @@
+package courier
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+)
+
+const (
+	// Interval between keepalive comments sent on idle event streams so that proxies
+	// do not close the connection.
+	EventKeepalive = 30 * time.Second
+
+	// Number of events buffered for each subscriber before it is disconnected.
+	eventBuffer = 64
+)
+
+// Events streams delivery events to the client as server-sent events until the client
+// disconnects or the server shuts down, so that downstream automation can react to a
+// delivery without polling. Each event is sent with its type as the event name and the
+// JSON encoded api.Event as the data. Events are not persisted: clients only receive
+// events that occur while they are connected and clients that fall too far behind are
+// disconnected and must reconnect. Only the events of the ids that the policy permits
+// the client to access are sent.
+func (s *Server) Events(c *gin.Context) {
+	stream, cancel := s.events.subscribe()
+	defer cancel()
+
+	// Event streams are long lived so the server write timeout does not apply
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(EventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+
+			if !s.permitted(c, event.ID) {
+				continue
+			}
+			c.SSEvent(event.Type, event)
+		case <-keepalive.C:
+			if _, err := c.Writer.WriteString(": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-s.events.done:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// Publishes an event about the resource with the id to all connected event streams and
+// invalidates the cached responses about it.
+func (s *Server) publish(eventType, id string) {
+	s.invalidate(id)
+	s.recordEvent(eventType)
+	s.events.publish(&api.Event{Type: eventType, ID: id, Timestamp: time.Now().UTC()})
+}
+
+// Publishes a certificate event with the fingerprint of the certificate if the stored
+// certificate data is not encrypted.
+func (s *Server) publishCertificate(eventType, id string, data []byte) {
+	s.invalidate(id)
+	s.recordEvent(eventType)
+	event := &api.Event{Type: eventType, ID: id, Timestamp: time.Now().UTC()}
+	if details, err := certificateDetails(data); err == nil {
+		event.Fingerprint = details.SHA256Fingerprint
+	}
+	s.events.publish(event)
+}
+
+// Events distributes delivery events to the connected event streams. The done channel
+// is closed when the server shuts down so that streams do not delay the shutdown.
+type events struct {
+	sync.Mutex
+	subscribers map[chan *api.Event]struct{}
+	done        chan struct{}
+	once        sync.Once
+}
+
+func newEvents() *events {
+	return &events{
+		subscribers: make(map[chan *api.Event]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Returns a channel that receives every published event and a function that must be
+// called to unsubscribe. The channel is closed if the subscriber falls behind.
+func (e *events) subscribe() (<-chan *api.Event, func()) {
+	e.Lock()
+	defer e.Unlock()
+
+	ch := make(chan *api.Event, eventBuffer)
+	e.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		e.Lock()
+		defer e.Unlock()
+		if _, ok := e.subscribers[ch]; ok {
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Sends the event to all subscribers without blocking, disconnecting any subscriber
+// whose buffer is full rather than silently dropping the event.
+func (e *events) publish(event *api.Event) {
+	e.Lock()
+	defer e.Unlock()
+
+	for ch := range e.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Warn().Str("event", event.Type).Msg("event stream subscriber fell behind and was disconnected")
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Disconnects all event streams so that the server can shut down.
+func (e *events) close() {
+	e.once.Do(func() { close(e.done) })
+}
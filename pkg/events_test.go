@@ -0,0 +1,157 @@
+package courier_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+func (s *courierTestSuite) TestEvents() {
+	require := s.Require()
+
+	s.Run("Stream", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		s.store.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
+			return nil
+		}
+		s.store.OnDeletePassword = func(ctx context.Context, name string) error {
+			return nil
+		}
+		defer s.store.Reset()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		received := make(chan *api.Event, 64)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- s.client.Events(ctx, func(event *api.Event) error {
+				received <- event
+				return nil
+			})
+		}()
+
+		// Events are only sent to connected streams, so deliver passwords until the
+		// stream has subscribed and the first event is received
+		var event *api.Event
+		require.Eventually(func() bool {
+			s.client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "supersecretsquirrel"})
+			select {
+			case event = <-received:
+				return true
+			case <-time.After(20 * time.Millisecond):
+				return false
+			}
+		}, 5*time.Second, 10*time.Millisecond, "no event received from the stream")
+
+		require.Equal(api.EventPasswordStored, event.Type)
+		require.Equal("certID", event.ID)
+		require.False(event.Timestamp.IsZero(), "expected event timestamp")
+
+		// Drain any events from the subscription loop
+		for len(received) > 0 {
+			<-received
+		}
+
+		require.NoError(s.client.DeleteCertificatePassword(context.Background(), "certID"))
+		select {
+		case event = <-received:
+			require.Equal(api.EventPasswordDeleted, event.Type)
+		case <-time.After(5 * time.Second):
+			require.Fail("no delete event received")
+		}
+
+		// Canceling the context should close the stream
+		cancel()
+		require.ErrorIs(<-errc, context.Canceled)
+	})
+
+	s.Run("HandlerError", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		s.store.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
+			return nil
+		}
+		defer s.store.Reset()
+
+		stop := errors.New("stop")
+		errc := make(chan error, 1)
+		go func() {
+			errc <- s.client.Events(context.Background(), func(event *api.Event) error {
+				return stop
+			})
+		}()
+
+		require.Eventually(func() bool {
+			s.client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "supersecretsquirrel"})
+			select {
+			case err := <-errc:
+				require.ErrorIs(err, stop, "expected the handler error to be returned")
+				return true
+			case <-time.After(20 * time.Millisecond):
+				return false
+			}
+		}, 5*time.Second, 10*time.Millisecond, "handler error did not stop the stream")
+	})
+
+	s.Run("ExternalChange", func() {
+		conf := testConfig()
+		conf.LocalStorage.Path = s.T().TempDir()
+		conf.LocalStorage.WatchInterval = 20 * time.Millisecond
+		srv, client, _ := s.startServer(conf)
+		defer srv.Shutdown()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		received := make(chan *api.Event, 64)
+		go client.Events(ctx, func(event *api.Event) error {
+			received <- event
+			return nil
+		})
+
+		// Modify the certificate outside of courier until the stream has subscribed and
+		// the change is detected by the watcher
+		dir := filepath.Join(conf.LocalStorage.Path, "certs", "certID")
+		require.NoError(os.MkdirAll(dir, 0700))
+
+		var event *api.Event
+		for i := 0; event == nil; i++ {
+			require.Less(i, 250, "no external change event received from the stream")
+			require.NoError(os.WriteFile(filepath.Join(dir, "data"), []byte(strings.Repeat("x", i+1)), 0600))
+			select {
+			case event = <-received:
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+
+		require.Equal(api.EventExternalChange, event.Type)
+		require.Equal("certID", event.ID)
+		require.Equal(store.CertificatePrefix, event.Resource)
+		require.Contains([]string{store.ChangeCreated, store.ChangeModified}, event.Change)
+	})
+
+	s.Run("Shutdown", func() {
+		srv, client, _ := s.startServer(testConfig())
+
+		// Event streams should not delay a graceful shutdown
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			srv.Shutdown()
+		}()
+
+		start := time.Now()
+		err := client.Events(context.Background(), func(*api.Event) error { return nil })
+		require.NoError(err, "expected the stream to be closed by the server")
+		require.Less(time.Since(start), 10*time.Second, "shutdown was delayed by the event stream")
+	})
+}
@@ -0,0 +1,93 @@
+package courier_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+// Request made by the GDS certman to deliver a certificate to courier.
+type gdsRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+	Status  int               `json:"status"`
+}
+
+// The GDS certman delivers certificates by storing the pkcs12 password and then the
+// encrypted certificate. These requests are pinned to the recorded deliveries so that
+// changes to the field names or paths of the API cannot break live issuance.
+func (s *courierTestSuite) TestGDSDelivery() {
+	require := s.Require()
+
+	data, err := os.ReadFile("testdata/gds/delivery.json")
+	require.NoError(err, "could not read recorded gds requests")
+
+	var requests []gdsRequest
+	require.NoError(json.Unmarshal(data, &requests), "could not parse recorded gds requests")
+
+	// Load the cert fixture to compare the delivered certificate against
+	sz, err := trust.NewSerializer(true, "supersecretsquirrel")
+	require.NoError(err, "could not create serializer")
+	provider, err := sz.ReadFile("testdata/cert.zip")
+	require.NoError(err, "could not read cert fixture")
+	leaf, err := provider.GetLeafCertificate()
+	require.NoError(err, "could not parse leaf certificate")
+
+	passwords := make(map[string][]byte)
+	certs := make(map[string][]byte)
+	s.store.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
+		passwords[name] = password
+		return nil
+	}
+	s.store.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+		if password, ok := passwords[name]; ok {
+			return password, nil
+		}
+		return nil, store.ErrNotFound
+	}
+	s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		if cert, ok := certs[name]; ok {
+			return cert, nil
+		}
+		return nil, store.ErrNotFound
+	}
+	s.store.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+		certs[name] = cert
+		return nil
+	}
+	defer s.store.Reset()
+
+	// The certman retries failed deliveries so replaying them must also succeed
+	for attempt := 0; attempt < 2; attempt++ {
+		for _, recorded := range requests {
+			req, err := http.NewRequest(recorded.Method, s.courier.URL()+recorded.Path, bytes.NewReader(recorded.Body))
+			require.NoError(err, "could not create request")
+			for key, val := range recorded.Headers {
+				req.Header.Set(key, val)
+			}
+
+			rep, err := http.DefaultClient.Do(req)
+			require.NoError(err, "could not make request")
+			body, _ := io.ReadAll(rep.Body)
+			rep.Body.Close()
+			require.Equal(recorded.Status, rep.StatusCode, "unexpected response to %s %s: %s", recorded.Method, recorded.Path, body)
+		}
+	}
+
+	require.Len(certs, 1, "expected one certificate to be delivered")
+	for _, cert := range certs {
+		delivered, err := trust.New(cert)
+		require.NoError(err, "could not parse delivered certificate")
+		deliveredLeaf, err := delivered.GetLeafCertificate()
+		require.NoError(err, "delivered certificate was not decrypted")
+		require.Equal(leaf.Raw, deliveredLeaf.Raw, "wrong certificate delivered")
+	}
+}
@@ -0,0 +1,119 @@
+package courier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// Secrets that were stored with the alias prefix before it was reserved are renamed
+// with the legacy prefix, e.g. courier-alias-key is renamed legacy-courier-alias-key,
+// so that they can be retrieved by the secret routes again.
+const legacyPrefix = "legacy-"
+
+// LegacyReport summarizes the migration of resources stored under names that are
+// reserved by this version of courier.
+type LegacyReport struct {
+	Detected int
+	Renamed  int
+	Failed   int
+}
+
+// migrateLegacyNames renames the resources stored under legacy names when the server
+// starts and logs a summary, if the migration is enabled.
+func (s *Server) migrateLegacyNames(ctx context.Context) {
+	report, err := s.MigrateLegacyNames(ctx)
+	if report == nil {
+		log.Warn().Err(err).Msg("could not migrate resources stored under legacy names")
+		return
+	}
+
+	msg := log.Info()
+	if err != nil {
+		msg = log.Warn().Err(err)
+	}
+
+	msg.Int("detected", report.Detected).
+		Int("renamed", report.Renamed).
+		Int("failed", report.Failed).
+		Msg("migrated resources stored under legacy names")
+}
+
+// MigrateLegacyNames renames the secrets that were stored with the alias prefix before
+// it was reserved for the aliases of certificates, since the secret routes reject them
+// and they would otherwise be resolved as aliases. Aliases hold the id of a
+// certificate, so secrets whose data is not a valid resource name are legacy secrets.
+// Secrets that cannot be read or renamed are skipped so that one failure does not
+// prevent the others from being renamed; the errors are joined and returned with the
+// report. Local storage files in the flat layout of earlier versions are moved into
+// the resource directories when the store is opened, so they are not migrated here.
+func (s *Server) MigrateLegacyNames(ctx context.Context) (report *LegacyReport, err error) {
+	var names []string
+	if names, err = s.store.List(ctx, store.SecretPrefix); err != nil {
+		return nil, fmt.Errorf("could not list %s resources: %w", store.SecretPrefix, err)
+	}
+
+	var errs []error
+	report = &LegacyReport{}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+
+		if !strings.HasPrefix(name, aliasPrefix) {
+			continue
+		}
+
+		var data []byte
+		if data, err = s.store.GetSecret(ctx, name); err != nil {
+			// The alias was deleted since the secrets were listed
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+
+			report.Failed++
+			errs = append(errs, fmt.Errorf("%s %s: %w", store.SecretPrefix, name, err))
+			continue
+		}
+
+		if resourceName.Match(data) {
+			continue
+		}
+
+		report.Detected++
+		if err = s.renameSecret(ctx, name, legacyPrefix+name, data); err != nil {
+			report.Failed++
+			errs = append(errs, fmt.Errorf("%s %s: %w", store.SecretPrefix, name, err))
+			continue
+		}
+
+		report.Renamed++
+		log.Info().Str("name", name).Str("renamed", legacyPrefix+name).Msg("renamed secret stored under a reserved name")
+	}
+	return report, errors.Join(errs...)
+}
+
+// renameSecret writes the data of the secret under the new name and deletes the
+// secret. If a secret is stored under the new name, e.g. because an earlier rename was
+// interrupted, the secret is only deleted if the data is the same.
+func (s *Server) renameSecret(ctx context.Context, name, rename string, data []byte) (err error) {
+	var current []byte
+	switch current, err = s.store.GetSecret(ctx, rename); {
+	case err == nil:
+		if !bytes.Equal(current, data) {
+			return fmt.Errorf("a different secret is stored as %s", rename)
+		}
+	case errors.Is(err, store.ErrNotFound):
+		if err = s.store.UpdateSecret(ctx, rename, data); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	return s.store.DeleteSecret(ctx, name)
+}
@@ -0,0 +1,70 @@
+package courier_test
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+)
+
+func (s *courierTestSuite) TestMigrateLegacyNames() {
+	require := s.Require()
+	ctx := context.Background()
+
+	conf := testConfig()
+	conf.AllowSecretRetrieval = true
+	srv, client, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(err, "could not open memory store")
+	srv.SetStore(db)
+
+	// Secrets stored with the alias prefix before it was reserved cannot be retrieved
+	require.NoError(db.UpdateSecret(ctx, "courier-alias-sealing_key", []byte{0x00, 0x01, 0xfe}))
+	require.NoError(db.UpdateSecret(ctx, "courier-alias-credentials", []byte("user:password")))
+	require.NoError(db.UpdateSecret(ctx, "courier-alias-conflict", []byte("stored before aliases")))
+	require.NoError(db.UpdateSecret(ctx, "legacy-courier-alias-conflict", []byte("another secret")))
+	require.NoError(db.UpdateSecret(ctx, "courier-alias-example_com", []byte("certID")))
+	require.NoError(db.UpdateSecret(ctx, "unrelated", []byte("secret")))
+
+	_, err = client.RetrieveSecret(ctx, "courier-alias-sealing_key")
+	require.Error(err, "expected reserved secret names to be rejected")
+
+	// Legacy secrets are renamed and aliases are unchanged
+	report, err := srv.MigrateLegacyNames(ctx)
+	require.ErrorContains(err, "courier-alias-conflict", "expected the conflicting secret to be reported")
+	require.Equal(3, report.Detected)
+	require.Equal(2, report.Renamed)
+	require.Equal(1, report.Failed)
+
+	secret, err := client.RetrieveSecret(ctx, "legacy-courier-alias-sealing_key")
+	require.NoError(err, "expected the renamed secret to be retrievable")
+	require.Equal(base64.StdEncoding.EncodeToString([]byte{0x00, 0x01, 0xfe}), secret.Base64Data)
+
+	_, err = db.GetSecret(ctx, "courier-alias-sealing_key")
+	require.ErrorIs(err, store.ErrNotFound, "expected the legacy name to be removed")
+
+	target, err := db.GetSecret(ctx, "courier-alias-example_com")
+	require.NoError(err, "expected the alias to be unchanged")
+	require.Equal([]byte("certID"), target)
+
+	// Secrets are not renamed over a different secret
+	secret, err = client.RetrieveSecret(ctx, "legacy-courier-alias-conflict")
+	require.NoError(err)
+	require.Equal(base64.StdEncoding.EncodeToString([]byte("another secret")), secret.Base64Data)
+	_, err = db.GetSecret(ctx, "courier-alias-conflict")
+	require.NoError(err, "expected the conflicting secret to be kept")
+
+	// An interrupted rename is completed if the data is the same
+	require.NoError(db.UpdateSecret(ctx, "courier-alias-credentials", []byte("user:password")))
+	report, err = srv.MigrateLegacyNames(ctx)
+	require.ErrorContains(err, "courier-alias-conflict")
+	require.Equal(2, report.Detected)
+	require.Equal(1, report.Renamed)
+
+	_, err = db.GetSecret(ctx, "courier-alias-credentials")
+	require.ErrorIs(err, store.ErrNotFound, "expected the interrupted rename to be completed")
+}
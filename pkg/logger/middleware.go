@@ -5,7 +5,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/middleware"
 )
 
 // GinLogger returns a new Gin middleware that performs logging for our JSON APIs using
@@ -26,7 +26,7 @@ func GinLogger(server, version string) gin.HandlerFunc {
 
 		// After request
 		status := c.Writer.Status()
-		logctx := log.With().
+		logctx := middleware.Logger(c).With().
 			Str("path", path).
 			Str("ser_name", server).
 			Str("version", version).
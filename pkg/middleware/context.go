@@ -0,0 +1,108 @@
+/*
+Package middleware provides typed accessors for the values that courier stores in the
+gin context of a request, such as the request id, the request scoped logger, the
+identity of the caller, and the tenant, so that middleware and handlers share them
+without untyped context keys or type assertions at every call site.
+*/
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Key identifies a value of type T in the gin context. Keys are namespaced so that they
+// cannot collide with the keys of third party middleware.
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns a key for values of type T stored under the name.
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: "courier." + name}
+}
+
+// Set stores the value in the context of the request.
+func (k Key[T]) Set(c *gin.Context, value T) {
+	c.Set(k.name, value)
+}
+
+// Get returns the value stored in the context of the request and whether it was set.
+func (k Key[T]) Get(c *gin.Context) (value T, ok bool) {
+	var v any
+	if v, ok = c.Get(k.name); !ok {
+		return value, false
+	}
+	value, ok = v.(T)
+	return value, ok
+}
+
+// Identity describes the authenticated caller of a request.
+type Identity struct {
+	Subject string // common name of the client certificate or the name of the token
+	Method  string // how the caller was authenticated, e.g. mtls or token
+}
+
+// Authentication methods of an Identity.
+const (
+	AuthMTLS   = "mtls"
+	AuthToken  = "token"
+	AuthAPIKey = "apikey"
+	AuthJWT    = "jwt"
+	AuthHMAC   = "hmac"
+)
+
+var (
+	requestIDKey = NewKey[string]("request_id")
+	loggerKey    = NewKey[zerolog.Logger]("logger")
+	identityKey  = NewKey[*Identity]("identity")
+	tenantKey    = NewKey[string]("tenant")
+)
+
+// SetRequestID stores the id of the request.
+func SetRequestID(c *gin.Context, id string) {
+	requestIDKey.Set(c, id)
+}
+
+// RequestID returns the id of the request or an empty string if it was not assigned.
+func RequestID(c *gin.Context) string {
+	id, _ := requestIDKey.Get(c)
+	return id
+}
+
+// SetLogger stores the request scoped logger.
+func SetLogger(c *gin.Context, logger zerolog.Logger) {
+	loggerKey.Set(c, logger)
+}
+
+// Logger returns the request scoped logger, which includes the request id, or the
+// global logger if no logger was stored for the request.
+func Logger(c *gin.Context) *zerolog.Logger {
+	if logger, ok := loggerKey.Get(c); ok {
+		return &logger
+	}
+	return &log.Logger
+}
+
+// SetIdentity stores the authenticated caller of the request.
+func SetIdentity(c *gin.Context, identity *Identity) {
+	identityKey.Set(c, identity)
+}
+
+// GetIdentity returns the authenticated caller of the request, if any.
+func GetIdentity(c *gin.Context) (*Identity, bool) {
+	identity, ok := identityKey.Get(c)
+	return identity, ok && identity != nil
+}
+
+// SetTenant stores the tenant that the request is made on behalf of.
+func SetTenant(c *gin.Context, tenant string) {
+	tenantKey.Set(c, tenant)
+}
+
+// Tenant returns the tenant of the request or an empty string if it was not set.
+func Tenant(c *gin.Context) string {
+	tenant, _ := tenantKey.Get(c)
+	return tenant
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestIDHeader is the header that request ids are read from and returned in.
+const RequestIDHeader = "X-Request-ID"
+
+// Request ids from clients are only accepted if they cannot inject content into logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Context returns middleware that populates the typed context of every request: the
+// request id, which is taken from the X-Request-ID header if the client sent a valid
+// one and returned in the response, a logger that includes the request id, and the
+// identity of clients that authenticated with a certificate.
+func Context() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		SetRequestID(c, id)
+		SetLogger(c, log.With().Str("request_id", id).Logger())
+		c.Header(RequestIDHeader, id)
+
+		if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+			SetIdentity(c, &Identity{Subject: c.Request.TLS.PeerCertificates[0].Subject.CommonName, Method: AuthMTLS})
+		}
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,94 @@
+package middleware_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/middleware"
+)
+
+func TestKey(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	key := middleware.NewKey[int]("count")
+	_, ok := key.Get(c)
+	require.False(t, ok, "expected an unset key not to be found")
+
+	key.Set(c, 42)
+	value, ok := key.Get(c)
+	require.True(t, ok)
+	require.Equal(t, 42, value)
+
+	// A key with the same name but a different type does not return the value
+	_, ok = middleware.NewKey[string]("count").Get(c)
+	require.False(t, ok, "expected a value of the wrong type not to be returned")
+
+	// Helpers return zero values if nothing was stored
+	require.Empty(t, middleware.RequestID(c))
+	require.Empty(t, middleware.Tenant(c))
+	require.NotNil(t, middleware.Logger(c))
+	_, ok = middleware.GetIdentity(c)
+	require.False(t, ok)
+
+	middleware.SetTenant(c, "alice")
+	require.Equal(t, "alice", middleware.Tenant(c))
+}
+
+func TestContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.Context())
+
+	var (
+		requestID string
+		identity  *middleware.Identity
+	)
+	router.GET("/", func(c *gin.Context) {
+		requestID = middleware.RequestID(c)
+		identity, _ = middleware.GetIdentity(c)
+		c.Status(http.StatusNoContent)
+	})
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		requestID, identity = "", nil
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Generated", func(t *testing.T) {
+		w := serve(httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Len(t, requestID, 32, "expected a random request id to be assigned")
+		require.Equal(t, requestID, w.Header().Get(middleware.RequestIDHeader))
+		require.Nil(t, identity, "expected no identity without a client certificate")
+	})
+
+	t.Run("FromHeader", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(middleware.RequestIDHeader, "upstream-1234")
+		w := serve(req)
+		require.Equal(t, "upstream-1234", requestID)
+		require.Equal(t, "upstream-1234", w.Header().Get(middleware.RequestIDHeader))
+	})
+
+	t.Run("InvalidHeader", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(middleware.RequestIDHeader, "forged\nlog line")
+		serve(req)
+		require.NotEqual(t, "forged\nlog line", requestID)
+		require.Len(t, requestID, 32, "expected an invalid request id to be replaced")
+	})
+
+	t.Run("ClientCertificate", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "node.example.com"}}}}
+		serve(req)
+		require.Equal(t, &middleware.Identity{Subject: "node.example.com", Method: middleware.AuthMTLS}, identity)
+	})
+}
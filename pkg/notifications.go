@@ -0,0 +1,120 @@
+package courier
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/websocket"
+)
+
+// Notifications upgrades the request to a WebSocket on which clients subscribe to the
+// delivery events of specific certificate ids. Clients send api.SubscriptionRequest
+// messages and each id is acknowledged with a subscribed or unsubscribed event; the
+// events of subscribed ids are then sent as JSON encoded api.Event messages. Clients
+// are authenticated by the delivery chain and each id is authorized by the policy when
+// it is subscribed; ids that the policy denies are acknowledged with a not found event
+// and are not subscribed. Invalid requests close the connection with a policy violation
+// and the connection is closed with going away if the server shuts down or the client
+// falls too far behind.
+func (s *Server) Notifications(c *gin.Context) {
+	conn, err := websocket.Upgrade(c.Writer, c.Request)
+	if err != nil {
+		// The upgrade writes the error response
+		return
+	}
+	defer conn.Close(websocket.CloseNormal, "")
+
+	stream, cancel := s.events.subscribe()
+	defer cancel()
+
+	// Read subscription requests until the connection is closed
+	requests := make(chan []byte)
+	closed, done := make(chan struct{}), make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(closed)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			select {
+			case requests <- data:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(EventKeepalive)
+	defer keepalive.Stop()
+
+	subscribed := make(map[string]struct{})
+	for {
+		select {
+		case data := <-requests:
+			req := &api.SubscriptionRequest{}
+			if err = json.Unmarshal(data, req); err != nil {
+				conn.Close(websocket.CloseUnsupportedData, "could not parse subscription request")
+				return
+			}
+
+			var ack string
+			switch req.Action {
+			case api.ActionSubscribe:
+				ack = api.EventSubscribed
+			case api.ActionUnsubscribe:
+				ack = api.EventUnsubscribed
+			default:
+				conn.Close(websocket.ClosePolicyViolation, "unknown subscription action")
+				return
+			}
+
+			for _, id := range req.IDs {
+				if !resourceName.MatchString(id) {
+					conn.Close(websocket.ClosePolicyViolation, "invalid id in subscription request")
+					return
+				}
+			}
+
+			for _, id := range req.IDs {
+				reply := ack
+				switch {
+				case ack == api.EventUnsubscribed:
+					delete(subscribed, id)
+				case s.permitted(c, id):
+					subscribed[id] = struct{}{}
+				default:
+					reply = api.EventNotFound
+				}
+
+				if err = conn.WriteJSON(&api.Event{Type: reply, ID: id, Timestamp: time.Now().UTC()}); err != nil {
+					return
+				}
+			}
+		case event, ok := <-stream:
+			if !ok {
+				conn.Close(websocket.CloseGoingAway, "subscriber fell behind")
+				return
+			}
+
+			if _, ok := subscribed[event.ID]; ok {
+				if err = conn.WriteJSON(event); err != nil {
+					return
+				}
+			}
+		case <-keepalive.C:
+			if err = conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-s.events.done:
+			conn.Close(websocket.CloseGoingAway, "server is shutting down")
+			return
+		}
+	}
+}
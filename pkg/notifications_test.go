@@ -0,0 +1,244 @@
+package courier_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/auth"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+	"github.com/trisacrypto/courier/pkg/websocket"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+func (s *courierTestSuite) TestNotifications() {
+	require := s.Require()
+
+	// Load the cert fixture
+	sz, err := trust.NewSerializer(true, "supersecretsquirrel")
+	require.NoError(err, "could not create serializer")
+	provider, err := sz.ReadFile("testdata/cert.zip")
+	require.NoError(err, "could not read cert fixture")
+	decrypted, err := provider.Encode()
+	require.NoError(err, "could not read cert fixture")
+	leaf, err := provider.GetLeafCertificate()
+	require.NoError(err, "could not parse leaf certificate")
+	fingerprint := sha256.Sum256(leaf.Raw)
+
+	s.Run("Subscribe", func() {
+		s.store.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			return nil
+		}
+		defer s.store.Reset()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		received := make(chan *api.Event, 16)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- s.client.Subscribe(ctx, []string{"certID"}, func(event *api.Event) error {
+				received <- event
+				return nil
+			})
+		}()
+
+		// Events should only be sent once the subscription is acknowledged
+		event := s.nextEvent(received)
+		require.Equal(api.EventSubscribed, event.Type)
+		require.Equal("certID", event.ID)
+
+		// Events for other ids should not be sent
+		store := func(id string) {
+			err := s.client.StoreCertificate(context.Background(), &api.StoreCertificateRequest{
+				ID:                id,
+				ContentType:       api.ContentTypePEM,
+				Base64Certificate: base64.StdEncoding.EncodeToString(decrypted),
+			})
+			require.NoError(err, "could not store certificate")
+		}
+		store("otherID")
+		store("certID")
+
+		event = s.nextEvent(received)
+		require.Equal(api.EventCertificateStored, event.Type)
+		require.Equal("certID", event.ID)
+		require.Equal(hex.EncodeToString(fingerprint[:]), event.Fingerprint)
+		require.False(event.Timestamp.IsZero(), "expected event timestamp")
+
+		// Canceling the context should close the connection
+		cancel()
+		require.ErrorIs(<-errc, context.Canceled)
+	})
+
+	s.Run("HandlerError", func() {
+		stop := errors.New("stop")
+		err := s.client.Subscribe(context.Background(), []string{"certID"}, func(event *api.Event) error {
+			return stop
+		})
+		require.ErrorIs(err, stop, "expected the handler error to be returned")
+	})
+
+	s.Run("MissingIDs", func() {
+		err := s.client.Subscribe(context.Background(), nil, func(*api.Event) error { return nil })
+		require.ErrorIs(err, api.ErrIDRequired)
+	})
+
+	s.Run("InvalidRequests", func() {
+		endpoint := strings.Replace(s.courier.URL(), "http", "ws", 1) + "/v1/ws"
+		for _, req := range []*api.SubscriptionRequest{
+			{Action: "publish", IDs: []string{"certID"}},
+			{Action: api.ActionSubscribe, IDs: []string{"../certID"}},
+		} {
+			conn, err := websocket.Dial(context.Background(), endpoint, nil, nil)
+			require.NoError(err, "could not connect to websocket")
+			require.NoError(conn.WriteJSON(req), "could not send subscription request")
+
+			var closed *websocket.CloseError
+			_, _, err = conn.ReadMessage()
+			require.ErrorAs(err, &closed, "expected the server to close the connection")
+			require.Equal(websocket.ClosePolicyViolation, closed.Code)
+		}
+	})
+
+	s.Run("Shutdown", func() {
+		srv, client, _ := s.startServer(testConfig())
+
+		// Subscriptions should not delay a graceful shutdown
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			srv.Shutdown()
+		}()
+
+		start := time.Now()
+		err := client.Subscribe(context.Background(), []string{"certID"}, func(*api.Event) error { return nil })
+		require.NoError(err, "expected the connection to be closed by the server")
+		require.Less(time.Since(start), 10*time.Second, "shutdown was delayed by the subscription")
+	})
+}
+
+// Returns the next event received on the channel or fails the test.
+func (s *courierTestSuite) nextEvent(events <-chan *api.Event) *api.Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		s.FailNow("no event received")
+		return nil
+	}
+}
+
+func (s *courierTestSuite) TestStreamAuthentication() {
+	require := s.Require()
+	ctx := context.Background()
+
+	conf := testConfig()
+	conf.Auth.Delivery = []string{"apikey"}
+	conf.Auth.APIKeys = []string{"alice:alice-key"}
+	srv, _, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	// The event stream requires delivery credentials
+	rep, err := http.Get(srv.URL() + "/v1/events")
+	require.NoError(err, "could not make request")
+	rep.Body.Close()
+	require.Equal(http.StatusUnauthorized, rep.StatusCode, "expected the event stream to require authentication")
+
+	// The websocket handshake requires delivery credentials
+	_, err = websocket.Dial(ctx, srv.URL()+"/v1/ws", nil, nil)
+	require.ErrorIs(err, websocket.ErrBadHandshake, "expected the websocket to require authentication")
+
+	conn, err := websocket.Dial(ctx, srv.URL()+"/v1/ws", http.Header{auth.APIKeyHeader: {"alice-key"}}, nil)
+	require.NoError(err, "expected the websocket to be opened with delivery credentials")
+	conn.Close(websocket.CloseNormal, "")
+}
+
+func (s *courierTestSuite) TestStreamAuthorization() {
+	require := s.Require()
+
+	// Streams can only disclose the allowed id, other routes are not restricted
+	path := filepath.Join(s.T().TempDir(), "policy.rules")
+	require.NoError(os.WriteFile(path, []byte(`allow: id == "allowed" || !(route in ["/v1/events", "/v1/ws"])`), 0600))
+
+	conf := testConfig()
+	conf.Auth.Policy = path
+	srv, client, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(err, "could not open memory store")
+	srv.SetStore(db)
+
+	deliver := func(id string) {
+		err := client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: id, Password: "supersecretsquirrel", Force: true})
+		require.NoError(err, "could not store password")
+	}
+
+	s.Run("Subscribe", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		received := make(chan *api.Event, 16)
+		go client.Subscribe(ctx, []string{"denied", "allowed"}, func(event *api.Event) error {
+			received <- event
+			return nil
+		})
+
+		// Denied ids are acknowledged as not found and are not subscribed
+		event := s.nextEvent(received)
+		require.Equal(api.EventNotFound, event.Type)
+		require.Equal("denied", event.ID)
+
+		event = s.nextEvent(received)
+		require.Equal(api.EventSubscribed, event.Type)
+		require.Equal("allowed", event.ID)
+
+		deliver("denied")
+		deliver("allowed")
+
+		event = s.nextEvent(received)
+		require.Equal(api.EventPasswordStored, event.Type)
+		require.Equal("allowed", event.ID, "expected the events of the denied id not to be sent")
+	})
+
+	s.Run("Events", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		received := make(chan *api.Event, 64)
+		go client.Events(ctx, func(event *api.Event) error {
+			received <- event
+			return nil
+		})
+
+		// Deliver both ids until the stream has connected and an event is received
+		var event *api.Event
+		require.Eventually(func() bool {
+			deliver("denied")
+			deliver("allowed")
+			select {
+			case event = <-received:
+				return true
+			case <-time.After(20 * time.Millisecond):
+				return false
+			}
+		}, 5*time.Second, 10*time.Millisecond, "no event received from the stream")
+
+		for {
+			require.Equal("allowed", event.ID, "expected the events of the denied id not to be sent")
+			if len(received) == 0 {
+				return
+			}
+			event = <-received
+		}
+	})
+}
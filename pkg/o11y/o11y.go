@@ -17,10 +17,28 @@ func init() {
 	prometheus.MustRegister(
 		Passwords,
 		Certificates,
+		Secrets,
 		Requests,
 		Durations,
 		RequestSizeBytes,
 		ReplySizeBytes,
+		StoreOperations,
+		StoreDurations,
+		StoreCorruptions,
+		StoreExternalChanges,
+		StoreFailovers,
+		StoreFailedOver,
+		StoreReplications,
+		StoreReadFailovers,
+		RetentionPurged,
+		RetentionErrors,
+		RetentionArchived,
+		CertificateVerifications,
+		Throttled,
+		Oversized,
+		UploadsInFlight,
+		UploadsQueued,
+		CachedResponses,
 	)
 }
 
@@ -30,10 +48,17 @@ const (
 )
 
 const (
-	code   = "code"
-	method = "method"
-	host   = "host"
-	path   = "path"
+	code      = "code"
+	method    = "method"
+	host      = "host"
+	path      = "path"
+	backend   = "backend"
+	operation = "operation"
+	result    = "result"
+	reason    = "reason"
+	resource  = "resource"
+	change    = "change"
+	event     = "event"
 )
 
 var (
@@ -53,6 +78,14 @@ var (
 		Help:      "counts the number of certificates successfully delivered to courier",
 	})
 
+	// Secrets records the number of generic secrets posted to courier.
+	Secrets = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "secrets",
+		Help:      "counts the number of generic secrets successfully posted to courier",
+	})
+
 	// Standard HTTP Request Metrics
 	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: Namespace,
@@ -83,6 +116,156 @@ var (
 	}, []string{code, method, host, path})
 )
 
+var (
+	// StoreOperations records the number of store operations by backend and result.
+	StoreOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "store_operations",
+		Help:      "the number of store operations, partitioned by backend, operation, and result",
+	}, []string{backend, operation, result})
+
+	// StoreDurations records the latency of store operations by backend.
+	StoreDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "store_duration_seconds",
+		Help:      "store operation latencies in seconds",
+	}, []string{backend, operation})
+
+	// StoreCorruptions records the number of reads that failed their integrity check.
+	StoreCorruptions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "store_corruptions",
+		Help:      "the number of stored resources that failed their integrity check when read, partitioned by backend and operation",
+	}, []string{backend, operation})
+
+	// StoreExternalChanges records the number of changes to stored files made outside
+	// of courier that were detected by the store.
+	StoreExternalChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "store_external_changes",
+		Help:      "the number of changes to stored files made outside of courier, partitioned by resource type and kind of change",
+	}, []string{resource, change})
+
+	// StoreFailovers records the number of times the store failed over to the secondary
+	// backend and recovered to the primary backend.
+	StoreFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "store_failovers",
+		Help:      "the number of failovers to the secondary store and recoveries to the primary store, partitioned by event",
+	}, []string{event})
+
+	// StoreFailedOver records whether requests are served by the secondary backend.
+	StoreFailedOver = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "store_failed_over",
+		Help:      "set to one while requests are served by the secondary store",
+	})
+
+	// StoreReplications records the number of secrets written to or deleted from the
+	// secret manager replica project, including copies made by reconciliation.
+	StoreReplications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "store_replications",
+		Help:      "the number of secrets replicated to the secret manager replica project, partitioned by operation and result",
+	}, []string{operation, result})
+
+	// StoreReadFailovers records the number of reads that failed on the primary store and
+	// were retried on a replica, i.e. a mirrored store or the secret manager replica.
+	StoreReadFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "store_read_failovers",
+		Help:      "the number of reads retried on a replica after the primary store failed, partitioned by operation and result",
+	}, []string{operation, result})
+)
+
+var (
+	// RetentionPurged records the number of resources deleted by the retention policy.
+	RetentionPurged = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "retention_purged",
+		Help:      "the number of expired resources deleted by the retention policy, partitioned by resource type",
+	}, []string{resource})
+
+	// RetentionErrors records the number of expired resources that could not be checked
+	// or deleted by the retention policy.
+	RetentionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "retention_errors",
+		Help:      "the number of resources that the retention policy could not check or delete, partitioned by resource type",
+	}, []string{resource})
+
+	// RetentionArchived records the number of expired certificates whose private keys
+	// were removed by the retention policy.
+	RetentionArchived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "retention_archived",
+		Help:      "the number of expired certificates archived by the retention policy by removing their private keys",
+	})
+
+	// CertificateVerifications records the result of verifying each stored certificate.
+	CertificateVerifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "certificate_verifications",
+		Help:      "the number of stored certificates checked by the verification job, partitioned by result",
+	}, []string{result})
+)
+
+var (
+	// Throttled records the number of certificate uploads rejected by the throttle.
+	Throttled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "uploads_throttled",
+		Help:      "the number of certificate uploads rejected with 429, partitioned by the rate or concurrency limit that was exceeded",
+	}, []string{reason})
+
+	// Oversized records the number of certificate uploads rejected for their size.
+	Oversized = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "uploads_oversized",
+		Help:      "the number of certificate uploads rejected with 413, partitioned by the upload or decoded certificate size limit that was exceeded",
+	}, []string{reason})
+
+	// UploadsInFlight records the number of certificate uploads being processed.
+	UploadsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "uploads_in_flight",
+		Help:      "the number of certificate uploads holding a processing slot",
+	})
+
+	// UploadsQueued records the number of certificate uploads waiting for a slot.
+	UploadsQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "uploads_queued",
+		Help:      "the number of certificate uploads waiting for a processing slot",
+	})
+)
+
+var (
+	// CachedResponses records the number of responses served from or added to the cache.
+	CachedResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "cached_responses",
+		Help:      "the number of cacheable requests, partitioned by whether the response was a hit or a miss",
+	}, []string{result})
+)
+
 // Prometheus returns the collector endpoint to add to the gin router.
 func Prometheus() gin.HandlerFunc {
 	return gin.WrapH(promhttp.Handler())
@@ -0,0 +1,14 @@
+package courier
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+)
+
+// OpenAPI serves the OpenAPI specification of the courier API so that integrators can
+// generate clients in languages other than Go.
+func (s *Server) OpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", api.OpenAPI)
+}
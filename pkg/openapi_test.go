@@ -0,0 +1,61 @@
+package courier_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+)
+
+func (s *courierTestSuite) TestOpenAPI() {
+	require := s.Require()
+
+	rep, err := http.Get(s.courier.URL() + "/v1/openapi.json")
+	require.NoError(err, "could not get openapi specification from server")
+	defer rep.Body.Close()
+	require.Equal(http.StatusOK, rep.StatusCode, "expected 200 status code")
+	require.Contains(rep.Header.Get("Content-Type"), "application/json", "expected json content type")
+
+	spec := struct {
+		OpenAPI string                                `json:"openapi"`
+		Info    struct{ Version string }              `json:"info"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	require.NoError(json.NewDecoder(rep.Body).Decode(&spec), "could not decode openapi specification")
+	require.Equal("3.0.3", spec.OpenAPI, "unexpected openapi version")
+	require.Equal(api.Version, spec.Info.Version, "spec should describe the current api version")
+
+	// Every route served by courier should be described by the specification, except
+	// for the probe and metrics endpoints which are not part of the api.
+	operational := map[string]bool{"/healthz": true, "/livez": true, "/readyz": true, "/startupz": true, "/metrics": true}
+	routes := make(map[string][]string)
+	for _, route := range s.courier.Routes() {
+		if operational[route.Path] {
+			continue
+		}
+		path := routeParam.ReplaceAllString(route.Path, "{$1}")
+		routes[path] = append(routes[path], strings.ToLower(route.Method))
+	}
+	require.NotEmpty(routes, "expected courier to register api routes")
+
+	require.Len(spec.Paths, len(routes), "spec paths do not match courier routes")
+	for path, methods := range routes {
+		require.Contains(spec.Paths, path, "spec is missing path %s", path)
+		for _, method := range methods {
+			require.Contains(spec.Paths[path], method, "spec is missing %s %s", method, path)
+		}
+
+		// The spec should not describe operations that courier does not serve
+		for key := range spec.Paths[path] {
+			if key == "parameters" || key == "summary" || key == "description" {
+				continue
+			}
+			require.Contains(methods, key, "spec describes %s %s which is not served", key, path)
+		}
+	}
+}
+
+// Matches gin path parameters so that they can be converted to openapi templates.
+var routeParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
@@ -0,0 +1,74 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+// Names of the normalizations that can be applied to pkcs12 passwords.
+const (
+	NormalizeStripBOM  = "strip_bom"
+	NormalizeTrimSpace = "trim_space"
+)
+
+// The UTF-8 encoded byte order mark that editors prepend to some text files.
+const byteOrderMark = "\ufeff"
+
+var (
+	errInvalidUTF8      = errors.New("pkcs12 password is not valid utf-8")
+	errPasswordMismatch = errors.New("pkcs12 password cannot decrypt the stored certificate")
+)
+
+// Normalizes the pkcs12 password as described by the configuration and returns the
+// names of the normalizations that modified the password so that they can be audited.
+// The byte order mark is stripped before whitespace is trimmed so that whitespace
+// following the mark is also removed. Invalid UTF-8 is replaced with the replacement
+// character when the JSON request is decoded, so passwords that contain it are also
+// rejected when UTF-8 is required.
+func normalizePassword(conf config.PasswordConfig, password string) (_ string, applied []string, err error) {
+	if conf.RequireUTF8 && (!utf8.ValidString(password) || strings.ContainsRune(password, utf8.RuneError)) {
+		return "", nil, errInvalidUTF8
+	}
+
+	if conf.StripBOM && strings.HasPrefix(password, byteOrderMark) {
+		password = strings.TrimPrefix(password, byteOrderMark)
+		applied = append(applied, NormalizeStripBOM)
+	}
+
+	if conf.TrimSpace {
+		if trimmed := strings.TrimSpace(password); trimmed != password {
+			password = trimmed
+			applied = append(applied, NormalizeTrimSpace)
+		}
+	}
+
+	return password, applied, nil
+}
+
+// Returns errPasswordMismatch if an encrypted certificate is stored with the id and the
+// password cannot decrypt it. Certificates that are stored decrypted do not depend on
+// the password and are not checked.
+func (s *Server) verifyPassword(ctx context.Context, id, password string) (err error) {
+	var cert []byte
+	if cert, err = s.store.GetCertificate(ctx, id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if isPEM(cert) {
+		return nil
+	}
+
+	if _, err = trust.Decrypt(cert, password); err != nil {
+		return errPasswordMismatch
+	}
+	return nil
+}
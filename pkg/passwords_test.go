@@ -0,0 +1,119 @@
+package courier_test
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+func (s *courierTestSuite) TestPasswordNormalization() {
+	require := s.Require()
+
+	conf := testConfig()
+	conf.Passwords.TrimSpace = true
+	conf.Passwords.StripBOM = true
+	conf.Passwords.RequireUTF8 = true
+
+	srv, client, db := s.startServer(conf)
+	defer srv.Shutdown()
+
+	var stored []byte
+	db.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		return nil, store.ErrNotFound
+	}
+	db.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
+		stored = password
+		return nil
+	}
+
+	testCases := []struct {
+		password string
+		expected string
+	}{
+		{"supersecretsquirrel", "supersecretsquirrel"},
+		{"supersecretsquirrel\n", "supersecretsquirrel"},
+		{"\ufeffsupersecretsquirrel\r\n", "supersecretsquirrel"},
+		{"\ufeff  super secret squirrel\t", "super secret squirrel"},
+	}
+
+	for _, tc := range testCases {
+		stored = nil
+		err := client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: tc.password})
+		require.NoError(err, "could not store password %q", tc.password)
+		require.Equal([]byte(tc.expected), stored, "password %q was not normalized", tc.password)
+	}
+
+	// Passwords that are empty after normalization should be rejected
+	err := client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "\ufeff \n"})
+	s.CheckHTTPStatus(err, http.StatusBadRequest, "expected bad request for an empty password")
+
+	// Passwords that are not valid utf-8 should be rejected
+	err = client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "super\xffsecret"})
+	s.CheckHTTPStatus(err, http.StatusBadRequest, "expected bad request for an invalid password")
+
+	// Passwords should be stored as is if normalization is disabled
+	s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		return nil, store.ErrNotFound
+	}
+	s.store.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
+		stored = password
+		return nil
+	}
+	defer s.store.Reset()
+
+	err = s.client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "\ufeffsupersecretsquirrel\n"})
+	require.NoError(err, "could not store password")
+	require.Equal([]byte("\ufeffsupersecretsquirrel\n"), stored, "password should not be normalized by default")
+}
+
+func (s *courierTestSuite) TestPasswordVerification() {
+	require := s.Require()
+
+	// Load the cert fixture
+	sz, err := trust.NewSerializer(true, "supersecretsquirrel")
+	require.NoError(err, "could not create serializer")
+	provider, err := sz.ReadFile("testdata/cert.zip")
+	require.NoError(err, "could not read cert fixture")
+	decrypted, err := provider.Encode()
+	require.NoError(err, "could not read cert fixture")
+	encrypted, err := provider.Encrypt("supersecretsquirrel")
+	require.NoError(err, "could not encrypt cert fixture")
+
+	var stored []byte
+	s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		return encrypted, nil
+	}
+	s.store.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
+		stored = password
+		return nil
+	}
+	defer s.store.Reset()
+
+	// The password should be stored if it decrypts the stored certificate
+	err = s.client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "supersecretsquirrel"})
+	require.NoError(err, "could not store password")
+	require.Equal([]byte("supersecretsquirrel"), stored, "password was not stored")
+
+	// The password should be rejected if it cannot decrypt the stored certificate
+	stored = nil
+	err = s.client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "supersecretsquirel"})
+	s.CheckHTTPStatus(err, http.StatusConflict, "expected conflict for the wrong password")
+	require.Nil(stored, "wrong password should not be stored")
+
+	// The password should be stored anyway if forced
+	err = s.client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "supersecretsquirel", Force: true})
+	require.NoError(err, "could not force storing the password")
+	require.Equal([]byte("supersecretsquirel"), stored, "forced password was not stored")
+
+	// Decrypted certificates do not depend on the password
+	stored = nil
+	s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		return decrypted, nil
+	}
+	err = s.client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "anything"})
+	require.NoError(err, "password should be stored for a decrypted certificate")
+	require.Equal([]byte("anything"), stored, "password was not stored")
+}
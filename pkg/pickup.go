@@ -0,0 +1,49 @@
+package courier
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/middleware"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// oneTime returns true if the resource must be deleted when it is retrieved, either
+// because one-time pickup is configured or the request sets the once query parameter.
+// The query parameter cannot disable a configured one-time pickup.
+func (s *Server) oneTime(c *gin.Context) (once bool, err error) {
+	if param := c.Query("once"); param != "" {
+		if once, err = strconv.ParseBool(param); err != nil {
+			return false, errors.New("could not parse once query parameter")
+		}
+	}
+	return once || s.conf.OneTimePickup, nil
+}
+
+// pickup claims a one-time retrieval of the resource by deleting it before it is
+// returned, so that the resource is only returned to the request that deleted it and
+// concurrent retrievals cannot both receive it. If the resource was deleted by another
+// request a not found response is written and false is returned.
+func (s *Server) pickup(c *gin.Context, prefix, id, notFound string) bool {
+	if err := s.store.Delete(c.Request.Context(), prefix, id); err != nil {
+		storeError(c, err, notFound)
+		return false
+	}
+
+	switch prefix {
+	case store.PasswordPrefix:
+		s.publish(api.EventPasswordDeleted, id)
+	case store.CertificatePrefix:
+		s.publish(api.EventCertificateDeleted, id)
+	}
+
+	middleware.Logger(c).Info().
+		Str("audit", "pickup").
+		Str("resource", prefix).
+		Str("id", id).
+		Str("client_ip", c.ClientIP()).
+		Msg("deleted resource after one-time retrieval")
+	return true
+}
@@ -0,0 +1,101 @@
+package courier_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+)
+
+func (s *courierTestSuite) TestPickup() {
+	require := s.Require()
+	ctx := context.Background()
+
+	conf := testConfig()
+	conf.AllowPasswordRetrieval = true
+	srv, client, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(err, "could not open memory store")
+	srv.SetStore(db)
+
+	s.Run("Certificate", func() {
+		require.NoError(db.UpdateCertificate(ctx, "alice", []byte("certificate")))
+
+		// Retrievals without the once parameter do not delete the certificate
+		_, err := client.RetrieveCertificate(ctx, "alice")
+		require.NoError(err, "could not retrieve certificate")
+
+		rep, err := client.PickupCertificate(ctx, "alice")
+		require.NoError(err, "could not pick up certificate")
+		require.Equal("Y2VydGlmaWNhdGU=", rep.Base64Certificate)
+
+		_, err = client.RetrieveCertificate(ctx, "alice")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "expected the certificate to be deleted")
+	})
+
+	s.Run("Password", func() {
+		require.NoError(db.UpdatePassword(ctx, "alice", []byte("supersecret")))
+
+		rep, err := client.PickupCertificatePassword(ctx, "alice")
+		require.NoError(err, "could not pick up password")
+		require.Equal("supersecret", rep.Password)
+
+		_, err = client.PickupCertificatePassword(ctx, "alice")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "expected the password to be deleted")
+	})
+
+	s.Run("Concurrent", func() {
+		require.NoError(db.UpdateCertificate(ctx, "bob", []byte("certificate")))
+
+		var (
+			wg        sync.WaitGroup
+			successes atomic.Int32
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := client.PickupCertificate(ctx, "bob"); err == nil {
+					successes.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		require.Equal(int32(1), successes.Load(), "expected exactly one pickup to receive the certificate")
+	})
+
+	s.Run("InvalidParam", func() {
+		require.NoError(db.UpdateCertificate(ctx, "carol", []byte("certificate")))
+
+		client, err := api.New(srv.URL(), api.WithRetries(0))
+		require.NoError(err)
+
+		rep, err := http.Get(srv.URL() + "/v1/certs/carol?once=maybe")
+		require.NoError(err)
+		rep.Body.Close()
+		require.Equal(http.StatusBadRequest, rep.StatusCode)
+
+		_, err = client.RetrieveCertificate(ctx, "carol")
+		require.NoError(err, "expected the certificate not to be deleted")
+	})
+
+	s.Run("Configured", func() {
+		conf.OneTimePickup = true
+		srv, client, _ := s.startServer(conf)
+		defer srv.Shutdown()
+		srv.SetStore(db)
+
+		require.NoError(db.UpdatePassword(ctx, "dave", []byte("supersecret")))
+		_, err := client.RetrieveCertificatePassword(ctx, "dave")
+		require.NoError(err, "could not retrieve password")
+
+		_, err = client.RetrieveCertificatePassword(ctx, "dave")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "expected every retrieval to delete the password")
+	})
+}
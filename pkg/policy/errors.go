@@ -0,0 +1,11 @@
+package policy
+
+import "errors"
+
+var (
+	ErrSyntax        = errors.New("policy syntax error")
+	ErrUnknownName   = errors.New("policy references an unknown name")
+	ErrType          = errors.New("policy expression has the wrong type")
+	ErrNoRules       = errors.New("policy does not contain any rules")
+	ErrInvalidEffect = errors.New("policy rules must start with allow: or deny:")
+)
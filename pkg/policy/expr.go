@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+)
+
+// environment declares the variables that expressions can reference. The fields of
+// the identity are declared as qualified names so that referencing a field that does
+// not exist is an error when the policy is parsed rather than when it is evaluated.
+var environment = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("identity.subject", cel.StringType),
+		cel.Variable("identity.method", cel.StringType),
+		cel.Variable("method", cel.StringType),
+		cel.Variable("route", cel.StringType),
+		cel.Variable("id", cel.StringType),
+		cel.Variable("tenant", cel.StringType),
+		cel.Variable("ip", cel.StringType),
+		cel.Variable("metadata", cel.MapType(cel.StringType, cel.StringType)),
+	)
+})
+
+// compile parses and type checks the expression, which must evaluate to a bool, and
+// returns the program that evaluates it. Regular expressions that are string literals
+// are compiled with the program so that invalid patterns are reported as syntax errors.
+func compile(src string) (_ cel.Program, err error) {
+	var env *cel.Env
+	if env, err = environment(); err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Parse(src)
+	if issues.Err() != nil {
+		return nil, issueError(ErrSyntax, issues)
+	}
+
+	if ast, issues = env.Check(ast); issues.Err() != nil {
+		if strings.Contains(issues.Errors()[0].Message, "undeclared reference") {
+			return nil, issueError(ErrUnknownName, issues)
+		}
+		return nil, issueError(ErrType, issues)
+	}
+
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("%w: expected a bool but the expression is a %s", ErrType, ast.OutputType())
+	}
+
+	var program cel.Program
+	if program, err = env.Program(ast, cel.EvalOptions(cel.OptOptimize)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSyntax, err)
+	}
+	return program, nil
+}
+
+// evalBool evaluates the program of a rule. Errors at runtime, e.g. comparing values
+// whose types are only known when the rule is evaluated, are returned so that the
+// request is denied.
+func evalBool(program cel.Program, vars map[string]any) (bool, error) {
+	out, _, err := program.Eval(vars)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrType, err)
+	}
+
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: expected a bool", ErrType)
+	}
+	return b, nil
+}
+
+// issueError returns the first issue reported by the parser or type checker.
+func issueError(kind error, issues *cel.Issues) error {
+	issue := issues.Errors()[0]
+	return fmt.Errorf("%w: column %d: %s", kind, issue.Location.Column()+1, issue.Message)
+}
+
+// headers is the metadata of a request. Headers that were not sent are empty strings
+// when they are selected so that rules do not have to test for them first; the in
+// operator still only matches the headers that were sent.
+type headers struct {
+	traits.Mapper
+}
+
+func newHeaders(metadata map[string]string) headers {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return headers{types.NewStringStringMap(types.DefaultTypeAdapter, metadata).(traits.Mapper)}
+}
+
+func (h headers) Find(key ref.Val) (ref.Val, bool) {
+	if value, ok := h.Mapper.Find(key); ok {
+		return value, true
+	}
+
+	if _, ok := key.(types.String); ok {
+		return types.String(""), true
+	}
+	return nil, false
+}
+
+func (h headers) Get(key ref.Val) ref.Val {
+	if value, ok := h.Find(key); ok {
+		return value
+	}
+	return types.NewErr("no such key: %v", key)
+}
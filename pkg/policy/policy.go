@@ -0,0 +1,217 @@
+/*
+Package policy evaluates authorization rules for each request so that organization
+specific rules, e.g. which clients may delete certificates or which tenants may read
+which ids, can be expressed in configuration rather than in code.
+
+A policy is a file of rules, each of which starts with allow: or deny: followed by an
+expression that is evaluated with the request as input. Lines that start with # are
+comments and indented lines continue the expression of the previous rule:
+
+	# Only the operations team can delete resources
+	deny: method == "DELETE" && identity.subject != "ops"
+
+	# Clients can only read the certificates of their own organization
+	allow: id.startsWith(identity.subject + "-")
+	  || identity.method == "token"
+
+A request is denied if any deny rule matches. Otherwise it is allowed if an allow rule
+matches, or if the policy has no allow rules. A rule that cannot be evaluated, e.g.
+because it compares a string with an int, denies the request so that mistakes in the
+policy fail closed.
+
+Expressions are written in the Common Expression Language (CEL) and are type checked
+when the policy is parsed, so a rule that references an unknown variable or compares
+values of different types is rejected before it is loaded. The variables are
+identity.subject and identity.method, the http method, the route, e.g.
+/v1/certs/:id, the id of the resource, the tenant, the client ip, and the metadata of
+the request, which maps the lowercase names of the request headers to their values,
+e.g. metadata["x-team"]. All of them are strings; identity fields and headers that
+are not set are empty strings, and "x-team" in metadata tests whether a header was
+sent. Every CEL operator, macro, and standard function can be used, e.g. the
+startsWith, endsWith, contains, and matches methods of strings.
+*/
+package policy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Effects of a rule that matches a request.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Rule is a single allow or deny rule of a policy.
+type Rule struct {
+	Effect  string
+	Expr    string
+	Line    int
+	program cel.Program
+}
+
+// Policy is a parsed set of rules.
+type Policy struct {
+	rules  []*Rule
+	allows int
+}
+
+// Input describes the request that a policy is evaluated for.
+type Input struct {
+	Identity Identity
+	Method   string
+	Route    string
+	ID       string
+	Tenant   string
+	IP       string
+	Metadata map[string]string
+}
+
+// Identity describes the authenticated caller of the request, which is empty if the
+// request was not authenticated.
+type Identity struct {
+	Subject string
+	Method  string
+}
+
+// Decision is the result of evaluating a policy. The rule is the rule that decided the
+// request, which is nil if no rule matched. If a rule could not be evaluated the
+// request is denied and the error is returned with the rule.
+type Decision struct {
+	Allowed bool
+	Rule    *Rule
+	Err     error
+}
+
+// Parse the rules of a policy.
+func Parse(src string) (_ *Policy, err error) {
+	policy := &Policy{}
+	var rule *Rule
+
+	for i, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Indented lines continue the expression of the previous rule
+		if rule != nil && unicode.IsSpace(rune(line[0])) {
+			rule.Expr += " " + trimmed
+			continue
+		}
+
+		effect, expr, _ := strings.Cut(trimmed, ":")
+		effect = strings.TrimSpace(effect)
+		if effect != Allow && effect != Deny {
+			return nil, fmt.Errorf("line %d: %w", i+1, ErrInvalidEffect)
+		}
+
+		rule = &Rule{Effect: effect, Expr: strings.TrimSpace(expr), Line: i + 1}
+		policy.rules = append(policy.rules, rule)
+		if effect == Allow {
+			policy.allows++
+		}
+	}
+
+	if len(policy.rules) == 0 {
+		return nil, ErrNoRules
+	}
+
+	for _, rule := range policy.rules {
+		if rule.program, err = compile(rule.Expr); err != nil {
+			return nil, fmt.Errorf("line %d: %w", rule.Line, err)
+		}
+	}
+	return policy, nil
+}
+
+// Evaluate the policy for the request.
+func (p *Policy) Evaluate(in *Input) Decision {
+	vars := in.vars()
+
+	// Deny rules take precedence over allow rules regardless of their order
+	for _, effect := range []string{Deny, Allow} {
+		for _, rule := range p.rules {
+			if rule.Effect != effect {
+				continue
+			}
+
+			matched, err := evalBool(rule.program, vars)
+			if err != nil {
+				return Decision{Allowed: false, Rule: rule, Err: err}
+			}
+
+			if matched {
+				return Decision{Allowed: effect == Allow, Rule: rule}
+			}
+		}
+	}
+	return Decision{Allowed: p.allows == 0}
+}
+
+// Rules returns the number of rules in the policy.
+func (p *Policy) Rules() int {
+	return len(p.rules)
+}
+
+func (in *Input) vars() map[string]any {
+	return map[string]any{
+		"identity.subject": in.Identity.Subject,
+		"identity.method":  in.Identity.Method,
+		"method":           in.Method,
+		"route":            in.Route,
+		"id":               in.ID,
+		"tenant":           in.Tenant,
+		"ip":               in.IP,
+		"metadata":         newHeaders(in.Metadata),
+	}
+}
+
+// Engine evaluates the policy in a file and can reload the file, e.g. when the server
+// receives a SIGHUP, without interrupting requests that are being evaluated.
+type Engine struct {
+	path   string
+	policy atomic.Pointer[Policy]
+}
+
+// Load the policy in the file.
+func Load(path string) (e *Engine, err error) {
+	e = &Engine{path: path}
+	if err = e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload the policy from the file. If the file cannot be read or parsed the error is
+// returned and the current policy is kept.
+func (e *Engine) Reload() (err error) {
+	var data []byte
+	if data, err = os.ReadFile(e.path); err != nil {
+		return err
+	}
+
+	var policy *Policy
+	if policy, err = Parse(string(data)); err != nil {
+		return fmt.Errorf("could not parse %s: %w", e.path, err)
+	}
+
+	e.policy.Store(policy)
+	return nil
+}
+
+// Evaluate the current policy for the request.
+func (e *Engine) Evaluate(in *Input) Decision {
+	return e.policy.Load().Evaluate(in)
+}
+
+// Policy returns the current policy.
+func (e *Engine) Policy() *Policy {
+	return e.policy.Load()
+}
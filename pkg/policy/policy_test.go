@@ -0,0 +1,189 @@
+package policy_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/policy"
+)
+
+func TestExpressions(t *testing.T) {
+	in := &policy.Input{
+		Identity: policy.Identity{Subject: "acme-node", Method: "mtls"},
+		Method:   "GET",
+		Route:    "/v1/certs/:id",
+		ID:       "acme-123",
+		Tenant:   "acme",
+		IP:       "10.0.0.1",
+		Metadata: map[string]string{"x-team": "ops"},
+	}
+
+	tests := []struct {
+		expr    string
+		matches bool
+	}{
+		{`method == "GET"`, true},
+		{`method != 'GET'`, false},
+		{`identity.subject == "acme-node" && identity.method == "mtls"`, true},
+		{`id.startsWith(tenant + "-")`, true},
+		{`id.endsWith("123") && route.contains("certs")`, true},
+		{`id.matches("^acme-[0-9]+$")`, true},
+		{`!(method in ["DELETE", "PUT"])`, true},
+		{`metadata["x-team"] == "ops"`, true},
+		{`metadata.missing == ""`, true},
+		{`"x-team" in metadata`, true},
+		{`"x-other" in metadata`, false},
+		{`size(id) > 5 && size(["a", "b"]) == 2`, true},
+		{`size(metadata) >= 1 && 1 + 1 == 2`, true},
+		{`ip < "10.0.0.2"`, true},
+		{`false || method == "POST"`, false},
+		{`tenant == "" || id.startsWith(tenant)`, true},
+		{`identity.subject == "" && identity.method == ""`, false},
+
+		// Precedence
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`!false && false`, false},
+		{`1 + 2 * 3 == 7 && -1 < 0`, true},
+		{`method == "GET" || method == "PUT" && false`, true},
+		{`method != "GET" ? false : id == "acme-123"`, true},
+
+		// Membership
+		{`method in ["GET", "HEAD"]`, true},
+		{`method in []`, false},
+		{`"x-team" in metadata && !("x-other" in metadata)`, true},
+		{`metadata.exists(key, key.startsWith("x-"))`, true},
+
+		// Regular expressions are RE2 and match anywhere in the string unless anchored
+		{`id.matches("cme")`, true},
+		{`id.matches("^cme")`, false},
+		{`id.matches(tenant)`, true},
+		{`id.matches("^" + tenant + "-[0-9]{3}$")`, true},
+	}
+
+	for _, tc := range tests {
+		p, err := policy.Parse("allow: " + tc.expr)
+		require.NoError(t, err, "could not parse %s", tc.expr)
+
+		decision := p.Evaluate(in)
+		require.NoError(t, decision.Err, "could not evaluate %s", tc.expr)
+		require.Equal(t, tc.matches, decision.Allowed, "wrong result for %s", tc.expr)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err error
+	}{
+		{"", policy.ErrNoRules},
+		{"# only comments", policy.ErrNoRules},
+		{"permit: true", policy.ErrInvalidEffect},
+		{"allow: method ==", policy.ErrSyntax},
+		{"allow: method == 'GET", policy.ErrSyntax},
+		{"allow: (method == \"GET\"", policy.ErrSyntax},
+		{"allow: method @ 1", policy.ErrSyntax},
+		{"allow: user == \"admin\"", policy.ErrUnknownName},
+		{"allow: identity.name == \"admin\"", policy.ErrUnknownName},
+		{"allow: id.lower() == \"admin\"", policy.ErrUnknownName},
+		{"allow: len(id) > 1", policy.ErrUnknownName},
+		{"allow: method.name == \"GET\"", policy.ErrType},
+		{"allow: id == 1", policy.ErrType},
+		{"allow: id", policy.ErrType},
+		{"allow: size(id) + \"1\" > 1", policy.ErrType},
+		{"allow: id.matches(\"[\")", policy.ErrSyntax},
+		{"allow: id.startsWith()", policy.ErrType},
+	}
+
+	for _, tc := range tests {
+		_, err := policy.Parse(tc.src)
+		require.ErrorIs(t, err, tc.err, "wrong error for %q", tc.src)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	p, err := policy.Parse(`
+# Only the operations team can delete resources
+deny: method == "DELETE" && identity.subject != "ops"
+
+# Clients can only access the certificates of their own organization
+allow: id.startsWith(identity.subject + "-")
+  || identity.method == "token"
+`)
+	require.NoError(t, err, "could not parse policy")
+	require.Equal(t, 2, p.Rules())
+
+	decision := p.Evaluate(&policy.Input{Identity: policy.Identity{Subject: "acme", Method: "mtls"}, Method: "GET", ID: "acme-1"})
+	require.True(t, decision.Allowed)
+	require.Equal(t, 6, decision.Rule.Line)
+
+	// Deny rules take precedence over allow rules
+	decision = p.Evaluate(&policy.Input{Identity: policy.Identity{Subject: "acme", Method: "mtls"}, Method: "DELETE", ID: "acme-1"})
+	require.False(t, decision.Allowed)
+	require.Equal(t, policy.Deny, decision.Rule.Effect)
+	require.Equal(t, 3, decision.Rule.Line)
+
+	// Requests are denied if no allow rule matches
+	decision = p.Evaluate(&policy.Input{Identity: policy.Identity{Subject: "acme", Method: "mtls"}, Method: "GET", ID: "other-1"})
+	require.False(t, decision.Allowed)
+	require.Nil(t, decision.Rule)
+
+	// Continuation lines are part of the expression of the rule
+	decision = p.Evaluate(&policy.Input{Identity: policy.Identity{Subject: "admin", Method: "token"}, Method: "GET", ID: "other-1"})
+	require.True(t, decision.Allowed)
+
+	// Requests are allowed if the policy only has deny rules
+	p, err = policy.Parse(`deny: tenant == "blocked"`)
+	require.NoError(t, err)
+	require.True(t, p.Evaluate(&policy.Input{Tenant: "acme"}).Allowed)
+	require.False(t, p.Evaluate(&policy.Input{Tenant: "blocked"}).Allowed)
+
+	// Rules that cannot be evaluated fail closed
+	tests := []string{
+		`allow: dyn(id) < 1`,
+		`allow: int(id) > 0`,
+		`allow: [1, 2][size(id)] == 1`,
+		`allow: 1 / (size(id) - 4) == 1`,
+	}
+
+	for _, src := range tests {
+		p, err = policy.Parse(src + "\nallow: true")
+		require.NoError(t, err, "could not parse %s", src)
+
+		decision = p.Evaluate(&policy.Input{ID: "acme"})
+		require.False(t, decision.Allowed, "expected %s to deny the request", src)
+		require.Equal(t, 1, decision.Rule.Line)
+		require.ErrorIs(t, decision.Err, policy.ErrType, "wrong error for %s", src)
+	}
+
+	// Deny rules that cannot be evaluated also deny the request
+	p, err = policy.Parse(`deny: int(id) > 0`)
+	require.NoError(t, err)
+	decision = p.Evaluate(&policy.Input{ID: "acme"})
+	require.False(t, decision.Allowed)
+	require.ErrorIs(t, decision.Err, policy.ErrType)
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.rules")
+	require.NoError(t, os.WriteFile(path, []byte(`allow: method == "GET"`), 0600))
+
+	_, err := policy.Load(filepath.Join(t.TempDir(), "missing.rules"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	engine, err := policy.Load(path)
+	require.NoError(t, err, "could not load policy")
+	require.True(t, engine.Evaluate(&policy.Input{Method: "GET"}).Allowed)
+	require.False(t, engine.Evaluate(&policy.Input{Method: "PUT"}).Allowed)
+
+	require.NoError(t, os.WriteFile(path, []byte(`allow: method in ["GET", "PUT"]`), 0600))
+	require.NoError(t, engine.Reload(), "could not reload policy")
+	require.True(t, engine.Evaluate(&policy.Input{Method: "PUT"}).Allowed)
+
+	// An invalid policy is not loaded
+	require.NoError(t, os.WriteFile(path, []byte(`allow: method in`), 0600))
+	require.ErrorIs(t, engine.Reload(), policy.ErrSyntax)
+	require.True(t, engine.Evaluate(&policy.Input{Method: "PUT"}).Allowed, "the previous policy should be kept")
+}
@@ -0,0 +1,109 @@
+package courier
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/middleware"
+)
+
+// Maximum number of distinct ids recorded for each client in a probe window.
+const maxProbeIDs = 100
+
+// AuditProbes records the requests for resources by id that are not found or forbidden
+// and logs a warning when a client receives more of them within the probe window than
+// the configured threshold, which indicates that it may be enumerating resource ids.
+// Each client is logged at most once per window.
+func (s *Server) AuditProbes(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if status := c.Writer.Status(); status != http.StatusNotFound && status != http.StatusForbidden {
+			return
+		}
+
+		client := c.ClientIP()
+		if count, ids, alert := s.probes.record(client, c.Param(param), time.Now()); alert {
+			ctx := middleware.Logger(c).Warn().
+				Str("client_ip", client).
+				Str("method", c.Request.Method).
+				Str("path", c.FullPath()).
+				Int("count", count).
+				Int("distinct_ids", ids).
+				Dur("window", s.probes.window)
+
+			if identity, ok := middleware.GetIdentity(c); ok && identity.Method == middleware.AuthMTLS {
+				ctx = ctx.Str("client_cert", identity.Subject)
+			}
+			ctx.Msg("possible resource id enumeration")
+		}
+	}
+}
+
+// forbidden responds to a request that the server is configured not to allow. Under
+// the uniform disclosure policy the response is identical to the response for a
+// missing resource so that callers cannot learn which ids exist.
+func (s *Server) forbidden(c *gin.Context, reason, notFound string) {
+	if s.conf.Disclosure.Detailed() {
+		c.JSON(http.StatusForbidden, api.ErrorResponse(reason))
+		return
+	}
+	c.JSON(http.StatusNotFound, api.ErrorResponse(notFound))
+}
+
+// probes counts the not found and forbidden responses sent to each client in fixed
+// windows. Clients whose window has expired are removed once per window so that the
+// number of tracked clients does not grow with the number of clients.
+type probes struct {
+	sync.Mutex
+	threshold int
+	window    time.Duration
+	clients   map[string]*probe
+	swept     time.Time
+}
+
+type probe struct {
+	start time.Time
+	count int
+	ids   map[string]struct{}
+}
+
+func newProbes(threshold int, window time.Duration) *probes {
+	return &probes{
+		threshold: threshold,
+		window:    window,
+		clients:   make(map[string]*probe),
+	}
+}
+
+// record the response to the client for the id at the time and return the number of
+// responses and distinct ids in the current window. Alert is true when the count
+// first reaches the threshold in the window.
+func (p *probes) record(client, id string, now time.Time) (count, ids int, alert bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if now.Sub(p.swept) > p.window {
+		for key, entry := range p.clients {
+			if now.Sub(entry.start) > p.window {
+				delete(p.clients, key)
+			}
+		}
+		p.swept = now
+	}
+
+	entry, ok := p.clients[client]
+	if !ok || now.Sub(entry.start) > p.window {
+		entry = &probe{start: now, ids: make(map[string]struct{})}
+		p.clients[client] = entry
+	}
+
+	entry.count++
+	if len(entry.ids) < maxProbeIDs {
+		entry.ids[id] = struct{}{}
+	}
+	return entry.count, len(entry.ids), entry.count == p.threshold
+}
@@ -0,0 +1,93 @@
+package courier_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+func (s *courierTestSuite) TestDisclosurePolicy() {
+	require := s.Require()
+
+	s.Run("Uniform", func() {
+		srv, client, db := s.startServer(testConfig())
+		defer srv.Shutdown()
+
+		db.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+
+		// A forbidden request should look the same as a request for a missing password
+		rep, err := http.Get(srv.URL() + "/v1/certs/certID/pkcs12password")
+		require.NoError(err, "could not send request")
+		rep.Body.Close()
+		require.Equal(http.StatusNotFound, rep.StatusCode)
+
+		_, err = client.StatCertificatePassword(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "forbidden head requests should not disclose the password")
+
+		_, err = client.RetrieveSecret(context.Background(), "signing-key")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "forbidden requests should be indistinguishable from missing secrets")
+	})
+
+	s.Run("Detailed", func() {
+		conf := testConfig()
+		conf.Disclosure.Policy = config.DisclosureDetailed
+		srv, client, _ := s.startServer(conf)
+		defer srv.Shutdown()
+
+		_, err := client.RetrieveCertificatePassword(context.Background(), "certID")
+		s.CheckHTTPStatus(err, http.StatusForbidden, "wrong error code when password retrieval is disabled")
+
+		_, err = client.RetrieveSecret(context.Background(), "signing-key")
+		s.CheckHTTPStatus(err, http.StatusForbidden, "wrong error code when secret retrieval is disabled")
+	})
+}
+
+func (s *courierTestSuite) TestAuditProbes() {
+	require := s.Require()
+
+	conf := testConfig()
+	conf.Disclosure.ProbeThreshold = 3
+	conf.Disclosure.ProbeWindow = time.Minute
+	srv, client, db := s.startServer(conf)
+	defer srv.Shutdown()
+
+	db.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		if name == "exists" {
+			return []byte("certificate"), nil
+		}
+		return nil, store.ErrNotFound
+	}
+
+	// Capture the logs to check that probing is reported
+	logs := &bytes.Buffer{}
+	prev := log.Logger
+	log.Logger = zerolog.New(logs)
+	defer func() { log.Logger = prev }()
+
+	// Successful requests are not counted
+	for i := 0; i < 5; i++ {
+		_, err := client.RetrieveCertificate(context.Background(), "exists")
+		require.NoError(err, "could not retrieve certificate")
+	}
+	require.NotContains(logs.String(), "possible resource id enumeration")
+
+	for _, id := range []string{"a1", "a2", "a2", "a3", "a4", "a5"} {
+		_, err := client.RetrieveCertificate(context.Background(), id)
+		s.CheckHTTPStatus(err, http.StatusNotFound, "expected missing certificate")
+	}
+
+	// The client should only be reported once per window
+	require.Equal(1, strings.Count(logs.String(), "possible resource id enumeration"), "expected probing to be logged once")
+	require.Contains(logs.String(), `"count":3`)
+	require.Contains(logs.String(), `"distinct_ids":2`)
+	require.Contains(logs.String(), `"client_ip":"127.0.0.1"`)
+}
@@ -1,9 +1,20 @@
 package courier
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+const (
+	// Interval between store checks while the server is starting and the deadline of
+	// each check, e.g. to allow for a slow secret manager cold start.
+	startupInterval = 2 * time.Second
+	startupTimeout  = 10 * time.Second
 )
 
 // Determines if the server is healthy or not.
@@ -20,6 +31,27 @@ func (s *Server) IsReady() bool {
 	return s.ready
 }
 
+// Determines if the server has completed its startup checks or not.
+func (s *Server) IsStarted() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.startup
+}
+
+// Returns the error from the most recent store health probe, if any.
+func (s *Server) StoreError() error {
+	s.RLock()
+	defer s.RUnlock()
+	return s.storeErr
+}
+
+// Set the result of the most recent store health probe.
+func (s *Server) SetStoreError(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.storeErr = err
+}
+
 // Set the server health state to the status bool.
 func (s *Server) SetHealthy(status bool) {
 	s.Lock()
@@ -34,6 +66,13 @@ func (s *Server) SetReady(status bool) {
 	s.ready = status
 }
 
+// Set the server startup state to the status bool.
+func (s *Server) SetStarted(status bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.startup = status
+}
+
 func (s *Server) Healthz(c *gin.Context) {
 	status := http.StatusOK
 	if !s.IsHealthy() {
@@ -44,8 +83,75 @@ func (s *Server) Healthz(c *gin.Context) {
 
 func (s *Server) Readyz(c *gin.Context) {
 	status := http.StatusOK
-	if !s.IsReady() {
+	if !s.IsReady() || !s.IsStarted() || s.StoreError() != nil {
 		status = http.StatusServiceUnavailable
 	}
 	c.Data(status, "text/plain", []byte(http.StatusText(status)))
 }
+
+// Startupz reports that the server has started once the configuration was validated,
+// the TLS material was loaded, the listeners are serving, and the store was reached.
+// Unlike the ready probe, it never fails again once startup has completed, so that a
+// Kubernetes startup probe can allow for a slow store connection without the liveness
+// and readiness probes marking the pod unhealthy in the meantime.
+func (s *Server) Startupz(c *gin.Context) {
+	status := http.StatusOK
+	if !s.IsStarted() {
+		status = http.StatusServiceUnavailable
+	}
+	c.Data(status, "text/plain", []byte(http.StatusText(status)))
+}
+
+// Checks the connection to the store until it succeeds and then marks the server as
+// started. The result of each check is also reported by the ready probe.
+func (s *Server) checkStartup(ctx context.Context, checker store.HealthChecker) {
+	ticker := time.NewTicker(startupInterval)
+	defer ticker.Stop()
+
+	for {
+		cctx, cancel := context.WithTimeout(ctx, startupTimeout)
+		err := checker.Check(cctx)
+		cancel()
+
+		s.SetStoreError(err)
+		if err == nil {
+			s.SetStarted(true)
+			log.Info().Msg("store is reachable, courier startup complete")
+			return
+		}
+
+		log.Warn().Err(err).Msg("waiting for the store to become reachable")
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Periodically checks the connection to the store so that a broken connection (e.g. a
+// revoked credential) is reported by the ready probe before the next delivery fails.
+func (s *Server) probeStore(ctx context.Context, checker store.HealthChecker) {
+	ticker := time.NewTicker(s.conf.StoreProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pctx, cancel := context.WithTimeout(ctx, s.conf.StoreProbeInterval)
+		err := checker.Check(pctx)
+		cancel()
+
+		switch prev := s.StoreError(); {
+		case err != nil && prev == nil:
+			log.Warn().Err(err).Msg("store health check failed")
+		case err == nil && prev != nil:
+			log.Info().Msg("store health check recovered")
+		}
+		s.SetStoreError(err)
+	}
+}
@@ -0,0 +1,245 @@
+/*
+Package proxyproto implements a listener that reads the PROXY protocol header sent by
+TCP load balancers (e.g. haproxy or AWS network load balancers) at the start of each
+connection so that the address of the original client is available to the server,
+including when TLS is terminated by the server itself. Both the human readable v1 and
+the binary v2 formats of the header are supported.
+*/
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoHeader      = errors.New("connection did not start with a proxy protocol header")
+	ErrInvalidHeader = errors.New("invalid proxy protocol header")
+)
+
+const (
+	// Maximum length of a v1 header including the trailing CRLF.
+	maxV1Length = 107
+
+	// Length of the fixed part of a v2 header.
+	v2HeaderLength = 16
+)
+
+// Signature that starts every v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// NewListener wraps the listener so that every accepted connection must start with a
+// PROXY protocol header. The header is read on the first read from the connection or
+// the first call to RemoteAddr, waiting at most timeout for it if timeout is positive.
+// Connections without a valid header return an error when they are read from.
+func NewListener(inner net.Listener, timeout time.Duration) net.Listener {
+	return &Listener{Listener: inner, timeout: timeout}
+}
+
+// Listener accepts connections that start with a PROXY protocol header.
+type Listener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+// Accept waits for and returns the next connection to the listener. The header is not
+// read here so that a slow client cannot block other connections from being accepted.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// Conn is a connection whose remote and local addresses are read from the PROXY
+// protocol header rather than from the socket, which is connected to the proxy.
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	src     net.Addr
+	dst     net.Addr
+	err     error
+}
+
+// Read reads data from the connection after the PROXY protocol header.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the address of the client that connected to the proxy. If the
+// header could not be read or the proxy did not forward an address (e.g. for its own
+// health checks), the address of the socket peer is returned instead.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address that the client connected to on the proxy, falling
+// back to the local address of the socket.
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) readHeader() {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	var prefix []byte
+	if prefix, c.err = c.reader.Peek(5); c.err != nil {
+		return
+	}
+
+	if string(prefix) == "PROXY" {
+		c.src, c.dst, c.err = readV1(c.reader)
+		return
+	}
+
+	if prefix, c.err = c.reader.Peek(len(v2Signature)); c.err != nil {
+		return
+	}
+
+	if !bytes.Equal(prefix, v2Signature) {
+		c.err = ErrNoHeader
+		return
+	}
+	c.src, c.dst, c.err = readV2(c.reader)
+}
+
+// Reads a human readable header such as "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443".
+func readV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) < maxV1Length {
+		var b byte
+		if b, err = r.ReadByte(); err != nil {
+			return nil, nil, err
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: v1 header is not terminated by CRLF", ErrInvalidHeader)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 {
+		return nil, nil, fmt.Errorf("%w: v1 header is missing the protocol", ErrInvalidHeader)
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		// The proxy could not determine the client, e.g. for its own health checks
+		return nil, nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported v1 protocol %q", ErrInvalidHeader, fields[1])
+	}
+
+	if len(fields) != 6 {
+		return nil, nil, fmt.Errorf("%w: v1 header has %d fields", ErrInvalidHeader, len(fields))
+	}
+
+	if src, err = parseV1Addr(fields[1], fields[2], fields[4]); err != nil {
+		return nil, nil, err
+	}
+
+	if dst, err = parseV1Addr(fields[1], fields[3], fields[5]); err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseV1Addr(proto, host, port string) (_ net.Addr, err error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (proto == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("%w: invalid %s address %q", ErrInvalidHeader, proto, host)
+	}
+
+	var n uint64
+	if n, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidHeader, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(n)}, nil
+}
+
+// Reads a binary header, only the addresses of TCP over IPv4 and IPv6 are used. Any
+// type-length-value extensions that follow the addresses are discarded.
+func readV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	header := make([]byte, v2HeaderLength)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+
+	if version := header[12] >> 4; version != 2 {
+		return nil, nil, fmt.Errorf("%w: unsupported v2 version %d", ErrInvalidHeader, version)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	switch command := header[12] & 0x0f; command {
+	case 0x0:
+		// LOCAL connections are made by the proxy itself, e.g. for health checks
+		return nil, nil, nil
+	case 0x1:
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported v2 command %d", ErrInvalidHeader, command)
+	}
+
+	var size int
+	switch family := header[13]; family {
+	case 0x11:
+		// TCP over IPv4
+		size = net.IPv4len
+	case 0x21:
+		// TCP over IPv6
+		size = net.IPv6len
+	default:
+		// Unspecified, UDP, and unix socket addresses are not meaningful to the server
+		return nil, nil, nil
+	}
+
+	if len(payload) < 2*size+4 {
+		return nil, nil, fmt.Errorf("%w: v2 address block is too short", ErrInvalidHeader)
+	}
+
+	src = &net.TCPAddr{
+		IP:   net.IP(payload[:size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size:])),
+	}
+	dst = &net.TCPAddr{
+		IP:   net.IP(payload[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size+2:])),
+	}
+	return src, dst, nil
+}
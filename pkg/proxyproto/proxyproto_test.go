@@ -0,0 +1,128 @@
+package proxyproto_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/proxyproto"
+)
+
+func TestListener(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header []byte
+		remote string
+		local  string
+	}{
+		{"V1TCP4", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), "192.0.2.1:56324", "198.51.100.1:443"},
+		{"V1TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), "[2001:db8::1]:56324", "[2001:db8::2]:443"},
+		{"V1Unknown", []byte("PROXY UNKNOWN\r\n"), "", ""},
+		{"V2TCP4", v2Header(0x1, 0x11, []byte{192, 0, 2, 1}, []byte{198, 51, 100, 1}, 56324, 443), "192.0.2.1:56324", "198.51.100.1:443"},
+		{"V2TCP6", v2Header(0x1, 0x21, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 56324, 443), "[2001:db8::1]:56324", "[2001:db8::2]:443"},
+		{"V2Local", v2Header(0x0, 0x00, nil, nil, 0, 0), "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, client := accept(t)
+			_, err := client.Write(append(tc.header, []byte("hello")...))
+			require.NoError(t, err, "could not write to connection")
+
+			// Addresses fall back to the socket if the proxy did not forward them
+			remote, local := tc.remote, tc.local
+			if remote == "" {
+				remote, local = client.LocalAddr().String(), client.RemoteAddr().String()
+			}
+			require.Equal(t, remote, conn.RemoteAddr().String(), "wrong remote address")
+			require.Equal(t, local, conn.LocalAddr().String(), "wrong local address")
+
+			// The header should not be returned to the server
+			data := make([]byte, 5)
+			_, err = io.ReadFull(conn, data)
+			require.NoError(t, err, "could not read from connection")
+			require.Equal(t, []byte("hello"), data)
+		})
+	}
+}
+
+func TestInvalidHeader(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header []byte
+		err    error
+	}{
+		{"NoHeader", []byte("GET / HTTP/1.1\r\nHost: courier\r\n\r\n"), proxyproto.ErrNoHeader},
+		{"V1NoCRLF", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n"), proxyproto.ErrInvalidHeader},
+		{"V1Protocol", []byte("PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n"), proxyproto.ErrInvalidHeader},
+		{"V1Family", []byte("PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n"), proxyproto.ErrInvalidHeader},
+		{"V1Port", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 70000 443\r\n"), proxyproto.ErrInvalidHeader},
+		{"V1Fields", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n"), proxyproto.ErrInvalidHeader},
+		{"V2Command", v2Header(0x2, 0x11, []byte{192, 0, 2, 1}, []byte{198, 51, 100, 1}, 56324, 443), proxyproto.ErrInvalidHeader},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, client := accept(t)
+			_, err := client.Write(tc.header)
+			require.NoError(t, err, "could not write to connection")
+
+			_, err = conn.Read(make([]byte, 1))
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String(), "expected socket address")
+		})
+	}
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	conn, _ := accept(t)
+
+	// Clients that never send a header should not hold the connection open
+	_, err := conn.Read(make([]byte, 1))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	require.True(t, netErr.Timeout(), "expected a timeout error")
+}
+
+// Accept a connection on a proxy protocol listener and return it along with the client
+// side of the connection.
+func accept(t *testing.T) (conn, client net.Conn) {
+	sock, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "could not listen")
+
+	ln := proxyproto.NewListener(sock, 50*time.Millisecond)
+	t.Cleanup(func() { ln.Close() })
+
+	client, err = net.Dial("tcp", sock.Addr().String())
+	require.NoError(t, err, "could not dial listener")
+	t.Cleanup(func() { client.Close() })
+
+	conn, err = ln.Accept()
+	require.NoError(t, err, "could not accept connection")
+	t.Cleanup(func() { conn.Close() })
+	return conn, client
+}
+
+// Create a v2 header with the command, address family, and addresses.
+func v2Header(command, family byte, src, dst net.IP, sport, dport uint16) []byte {
+	header := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x20|command, family, 0, 0)
+
+	var addrs []byte
+	switch family {
+	case 0x11:
+		addrs = append(append(addrs, src.To4()...), dst.To4()...)
+	case 0x21:
+		addrs = append(append(addrs, src.To16()...), dst.To16()...)
+	}
+
+	if len(addrs) > 0 {
+		addrs = binary.BigEndian.AppendUint16(addrs, sport)
+		addrs = binary.BigEndian.AppendUint16(addrs, dport)
+	}
+
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+	return append(header, addrs...)
+}
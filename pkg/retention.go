@@ -0,0 +1,306 @@
+package courier
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/o11y"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+// collectGarbage deletes expired resources at the retention interval until the context
+// is cancelled when the server is shut down.
+func (s *Server) collectGarbage(ctx context.Context) {
+	ticker := time.NewTicker(s.conf.Retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.CollectGarbage(ctx); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("could not delete every expired resource")
+		}
+	}
+}
+
+// CollectGarbage deletes the passwords and certificates that have not been written for
+// longer than their retention period and the certificates that expired longer ago than
+// the expired period, and returns the number of resources that were deleted or
+// archived. Every removal is audit logged and published as an event. Resources that
+// cannot be checked or removed are skipped so that one failure does not prevent the
+// others from being removed; the errors are joined and returned. In a dry run nothing
+// is removed and the resources that would be removed are audit logged instead.
+func (s *Server) CollectGarbage(ctx context.Context) (purged int, err error) {
+	policies := []struct {
+		prefix string
+		period time.Duration
+		event  string
+	}{
+		{store.PasswordPrefix, s.conf.Retention.Passwords, api.EventPasswordDeleted},
+		{store.CertificatePrefix, s.conf.Retention.Certificates, api.EventCertificateDeleted},
+	}
+
+	var errs []error
+	for _, policy := range policies {
+		if policy.period <= 0 {
+			continue
+		}
+
+		var ids []string
+		if ids, err = s.store.List(ctx, policy.prefix); err != nil {
+			o11y.RetentionErrors.WithLabelValues(policy.prefix).Inc()
+			errs = append(errs, fmt.Errorf("could not list %s resources: %w", policy.prefix, err))
+			continue
+		}
+
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return purged, ctx.Err()
+			}
+
+			var deleted bool
+			if deleted, err = s.expire(ctx, policy.prefix, id, policy.period); err != nil {
+				o11y.RetentionErrors.WithLabelValues(policy.prefix).Inc()
+				errs = append(errs, fmt.Errorf("%s %s: %w", policy.prefix, id, err))
+				continue
+			}
+
+			if deleted {
+				purged++
+				o11y.RetentionPurged.WithLabelValues(policy.prefix).Inc()
+				s.publish(policy.event, id)
+			}
+		}
+	}
+
+	if s.conf.Retention.Expired > 0 {
+		var removed int
+		if removed, err = s.collectExpired(ctx); ctx.Err() != nil {
+			return purged + removed, ctx.Err()
+		}
+
+		purged += removed
+		errs = append(errs, err)
+	}
+	return purged, errors.Join(errs...)
+}
+
+// collectExpired removes the certificates that expired longer ago than the expired
+// period and returns the number of certificates that were removed.
+func (s *Server) collectExpired(ctx context.Context) (removed int, err error) {
+	var ids []string
+	if ids, err = s.store.List(ctx, store.CertificatePrefix); err != nil {
+		o11y.RetentionErrors.WithLabelValues(store.CertificatePrefix).Inc()
+		return 0, fmt.Errorf("could not list %s resources: %w", store.CertificatePrefix, err)
+	}
+
+	var errs []error
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+
+		var ok bool
+		if ok, err = s.expireCertificate(ctx, id); err != nil {
+			o11y.RetentionErrors.WithLabelValues(store.CertificatePrefix).Inc()
+			errs = append(errs, fmt.Errorf("%s %s: %w", store.CertificatePrefix, id, err))
+			continue
+		}
+
+		if ok {
+			removed++
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// expire deletes the resource if it has not been written for longer than the period.
+// Resources that are deleted concurrently are ignored.
+func (s *Server) expire(ctx context.Context, prefix, id string, period time.Duration) (_ bool, err error) {
+	var modified time.Time
+	if modified, err = s.store.Modified(ctx, prefix, id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	age := time.Since(modified)
+	if age <= period {
+		return false, nil
+	}
+
+	if s.conf.Retention.DryRun {
+		log.Info().
+			Str("audit", "retention").
+			Bool("dry_run", true).
+			Str("resource", prefix).
+			Str("id", id).
+			Time("modified", modified).
+			Dur("age", age).
+			Dur("retention", period).
+			Msg("would delete expired resource")
+		return false, nil
+	}
+
+	if err = s.store.Delete(ctx, prefix, id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	log.Info().
+		Str("audit", "retention").
+		Str("resource", prefix).
+		Str("id", id).
+		Time("modified", modified).
+		Dur("age", age).
+		Dur("retention", period).
+		Msg("deleted expired resource")
+	return true, nil
+}
+
+// expireCertificate removes the certificate if its leaf expired longer ago than the
+// expired period. The certificate and its pkcs12 password are deleted unless expired
+// certificates are archived, in which case the certificate is replaced by its public
+// chain, its prior versions are pruned, and its password is deleted so that no key
+// material is kept. Certificates that were stored encrypted are decrypted with their
+// password to read the expiration; they are skipped if the password is not stored.
+// Archived certificates are not archived again.
+func (s *Server) expireCertificate(ctx context.Context, id string) (_ bool, err error) {
+	var data []byte
+	if data, err = s.store.GetCertificate(ctx, id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var provider *trust.Provider
+	if provider, err = s.storedProvider(ctx, id, data); err != nil || provider == nil {
+		return false, err
+	}
+
+	var leaf *x509.Certificate
+	if leaf, err = provider.GetLeafCertificate(); err != nil {
+		return false, fmt.Errorf("could not parse certificate: %w", err)
+	}
+
+	expired := time.Since(leaf.NotAfter)
+	if expired <= s.conf.Retention.Expired {
+		return false, nil
+	}
+
+	archive := s.conf.Retention.Archive()
+	if archive && !provider.IsPrivate() {
+		return false, nil
+	}
+
+	action := config.RetentionDelete
+	if archive {
+		action = config.RetentionArchive
+	}
+
+	audit := func(msg string) {
+		log.Info().
+			Str("audit", "retention").
+			Bool("dry_run", s.conf.Retention.DryRun).
+			Str("resource", store.CertificatePrefix).
+			Str("id", id).
+			Str("action", action).
+			Time("not_after", leaf.NotAfter).
+			Dur("expired", expired).
+			Dur("retention", s.conf.Retention.Expired).
+			Msg(msg)
+	}
+
+	if s.conf.Retention.DryRun {
+		audit("would remove expired certificate")
+		return false, nil
+	}
+
+	if archive {
+		var public []byte
+		if public, err = provider.Public().Encode(); err != nil {
+			return false, err
+		}
+
+		if err = s.store.UpdateCertificate(ctx, id, public); err != nil {
+			return false, err
+		}
+
+		if err = s.store.PruneCertificateVersions(ctx, id, 1); err != nil && !errors.Is(err, store.ErrNoVersioning) {
+			return false, err
+		}
+
+		if err = s.deleteExpiredPassword(ctx, id); err != nil {
+			return false, err
+		}
+
+		audit("archived expired certificate")
+		o11y.RetentionArchived.Inc()
+		s.publishCertificate(api.EventCertificateArchived, id, public)
+		return true, nil
+	}
+
+	if err = s.store.DeleteCertificate(ctx, id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err = s.deleteExpiredPassword(ctx, id); err != nil {
+		return false, err
+	}
+
+	audit("deleted expired certificate")
+	o11y.RetentionPurged.WithLabelValues(store.CertificatePrefix).Inc()
+	s.publish(api.EventCertificateDeleted, id)
+	return true, nil
+}
+
+// storedProvider parses the stored certificate, decrypting it with its stored pkcs12
+// password if it was stored without decryption. A nil provider is returned if the
+// certificate is encrypted and its password is not stored.
+func (s *Server) storedProvider(ctx context.Context, id string, data []byte) (_ *trust.Provider, err error) {
+	if block, _ := pem.Decode(data); block != nil {
+		return trust.New(data)
+	}
+
+	var password []byte
+	if password, err = s.store.GetPassword(ctx, id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return trust.Decrypt(data, string(password))
+}
+
+// deleteExpiredPassword deletes the pkcs12 password of a removed certificate if it is
+// stored and publishes its deletion.
+func (s *Server) deleteExpiredPassword(ctx context.Context, id string) (err error) {
+	if err = s.store.DeletePassword(ctx, id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	s.publish(api.EventPasswordDeleted, id)
+	return nil
+}
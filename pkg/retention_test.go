@@ -0,0 +1,199 @@
+package courier_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+func (s *courierTestSuite) TestCollectGarbage() {
+	require := s.Require()
+	ctx := context.Background()
+
+	conf := testConfig()
+	conf.Retention.Passwords = time.Hour
+	conf.Retention.Interval = time.Hour
+	srv, _, db := s.startServer(conf)
+	defer srv.Shutdown()
+
+	now := time.Now()
+	modified := map[string]time.Time{
+		"expired": now.Add(-2 * time.Hour),
+		"fresh":   now.Add(-time.Minute),
+		"broken":  now.Add(-2 * time.Hour),
+	}
+
+	db.OnList = func(_ context.Context, prefix string) ([]string, error) {
+		require.Equal(store.PasswordPrefix, prefix, "expected only passwords to expire")
+		return []string{"broken", "expired", "fresh", "missing"}, nil
+	}
+
+	db.OnModified = func(_ context.Context, _, name string) (time.Time, error) {
+		if ts, ok := modified[name]; ok {
+			return ts, nil
+		}
+		return time.Time{}, store.ErrNotFound
+	}
+
+	var deleted []string
+	db.OnDelete = func(_ context.Context, prefix, name string) error {
+		if name == "broken" {
+			return errors.New("permission denied")
+		}
+		deleted = append(deleted, name)
+		return nil
+	}
+
+	// Expired resources are deleted even if another resource cannot be deleted
+	purged, err := srv.CollectGarbage(ctx)
+	require.Error(err, "expected the failed deletion to be reported")
+	require.ErrorContains(err, "broken")
+	require.Equal(1, purged)
+	require.Equal([]string{"expired"}, deleted, "expected only the expired password to be deleted")
+
+	// Failures of one policy do not prevent the other policies from running
+	conf.Retention.Certificates = time.Hour
+	conf.Retention.Expired = time.Hour
+	srv, _, db = s.startServer(conf)
+	defer srv.Shutdown()
+
+	db.OnList = func(_ context.Context, prefix string) ([]string, error) {
+		if prefix == store.PasswordPrefix {
+			return []string{"broken"}, nil
+		}
+		return []string{"expired"}, nil
+	}
+	db.OnModified = func(_ context.Context, _, name string) (time.Time, error) {
+		return modified[name], nil
+	}
+	db.OnGetCertificate = func(context.Context, string) ([]byte, error) {
+		return nil, errors.New("certificate unavailable")
+	}
+
+	deleted = nil
+	db.OnDelete = func(_ context.Context, prefix, name string) error {
+		if name == "broken" {
+			return errors.New("permission denied")
+		}
+		deleted = append(deleted, prefix+"/"+name)
+		return nil
+	}
+
+	purged, err = srv.CollectGarbage(ctx)
+	require.ErrorContains(err, "permission denied", "expected the password policy error to be reported")
+	require.ErrorContains(err, "certificate unavailable", "expected the expired certificate error to be reported")
+	require.Equal(1, purged)
+	require.Equal([]string{store.CertificatePrefix + "/expired"}, deleted, "expected the certificate policy to run")
+}
+
+func (s *courierTestSuite) TestCollectExpired() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// The fixture expired in 2022 and is stored both decrypted and encrypted
+	sz, err := trust.NewSerializer(true, "supersecretsquirrel")
+	require.NoError(err, "could not create serializer")
+	provider, err := sz.ReadFile("testdata/cert.zip")
+	require.NoError(err, "could not read cert fixture")
+	expired, err := provider.Encode()
+	require.NoError(err, "could not encode cert fixture")
+	encrypted, err := provider.Encrypt("supersecretsquirrel")
+	require.NoError(err, "could not encrypt cert fixture")
+
+	setup := func(action string, dryRun bool) (*memory.Store, func(), func() (int, error)) {
+		conf := testConfig()
+		conf.Retention.Expired = 24 * time.Hour
+		conf.Retention.Action = action
+		conf.Retention.DryRun = dryRun
+		conf.Retention.Interval = time.Hour
+		srv, _, _ := s.startServer(conf)
+
+		db, err := memory.Open(config.MemoryStorageConfig{Enabled: true, MaxVersions: 10})
+		require.NoError(err, "could not open in-memory store")
+		srv.SetStore(db)
+
+		require.NoError(db.UpdateCertificate(ctx, "expired", expired))
+		require.NoError(db.UpdatePassword(ctx, "expired", []byte("supersecretsquirrel")))
+		require.NoError(db.UpdateCertificate(ctx, "encrypted", encrypted))
+		require.NoError(db.UpdatePassword(ctx, "encrypted", []byte("supersecretsquirrel")))
+		require.NoError(db.UpdateCertificate(ctx, "nopassword", encrypted))
+		require.NoError(db.UpdateCertificate(ctx, "recent", selfSignedCertificate(s.T(), -time.Hour)))
+		require.NoError(db.UpdateCertificate(ctx, "valid", selfSignedCertificate(s.T(), 365*24*time.Hour)))
+
+		return db, func() { srv.Shutdown() }, func() (int, error) { return srv.CollectGarbage(ctx) }
+	}
+
+	exists := func(db *memory.Store, prefix, id string) bool {
+		ok, err := db.Exists(ctx, prefix, id)
+		require.NoError(err)
+		return ok
+	}
+
+	s.Run("Delete", func() {
+		db, shutdown, collect := setup(config.RetentionDelete, false)
+		defer shutdown()
+
+		removed, err := collect()
+		require.NoError(err, "could not collect expired certificates")
+		require.Equal(2, removed)
+
+		for _, id := range []string{"expired", "encrypted"} {
+			require.False(exists(db, store.CertificatePrefix, id), "expected %s certificate to be deleted", id)
+			require.False(exists(db, store.PasswordPrefix, id), "expected %s password to be deleted", id)
+		}
+
+		// Certificates that expired recently or cannot be decrypted are kept
+		for _, id := range []string{"nopassword", "recent", "valid"} {
+			require.True(exists(db, store.CertificatePrefix, id), "expected %s certificate to be kept", id)
+		}
+	})
+
+	s.Run("Archive", func() {
+		db, shutdown, collect := setup(config.RetentionArchive, false)
+		defer shutdown()
+
+		removed, err := collect()
+		require.NoError(err, "could not collect expired certificates")
+		require.Equal(2, removed)
+
+		public, err := provider.Public().Encode()
+		require.NoError(err, "could not encode public chain")
+
+		for _, id := range []string{"expired", "encrypted"} {
+			data, err := db.GetCertificate(ctx, id)
+			require.NoError(err, "expected %s certificate to be archived", id)
+			require.Equal(public, data, "expected only the public chain to be kept")
+			require.False(bytes.Contains(data, []byte("PRIVATE KEY")))
+			require.False(exists(db, store.PasswordPrefix, id), "expected %s password to be deleted", id)
+
+			versions, err := db.ListCertificateVersions(ctx, id)
+			require.NoError(err)
+			require.Len(versions, 1, "expected prior versions with the private key to be pruned")
+		}
+
+		// Archived certificates are not archived again
+		removed, err = collect()
+		require.NoError(err, "could not collect expired certificates")
+		require.Equal(0, removed)
+	})
+
+	s.Run("DryRun", func() {
+		db, shutdown, collect := setup(config.RetentionDelete, true)
+		defer shutdown()
+
+		removed, err := collect()
+		require.NoError(err, "could not collect expired certificates")
+		require.Equal(0, removed)
+
+		data, err := db.GetCertificate(ctx, "expired")
+		require.NoError(err, "expected certificate to be kept in a dry run")
+		require.Equal(expired, data)
+		require.True(exists(db, store.PasswordPrefix, "expired"), "expected password to be kept in a dry run")
+	})
+}
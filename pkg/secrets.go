@@ -0,0 +1,89 @@
+package courier
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/o11y"
+)
+
+// StoreSecret decodes the base64 encoded secret data in the request and stores it
+// with the name in the URL, replacing any secret previously stored with the name.
+func (s *Server) StoreSecret(c *gin.Context) {
+	var (
+		err  error
+		data []byte
+	)
+
+	name := c.Param("name")
+
+	// Parse the request body
+	req := &api.StoreSecretRequest{}
+	if err = c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	// Secret data is required
+	if req.Base64Data == "" {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse("missing secret data in request"))
+		return
+	}
+
+	if data, err = base64.StdEncoding.DecodeString(req.Base64Data); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	if err = s.store.UpdateSecret(c.Request.Context(), name, data); err != nil {
+		storeError(c, err, "secret not found")
+		return
+	}
+
+	// Return 204 No Content
+	o11y.Secrets.Inc()
+	c.Status(http.StatusNoContent)
+}
+
+// RetrieveSecret returns the secret stored with the name as base64 encoded data.
+// Because secrets may be sensitive key material, retrieval must be explicitly enabled
+// in the server configuration; otherwise the response depends on the disclosure
+// policy.
+func (s *Server) RetrieveSecret(c *gin.Context) {
+	if !s.conf.AllowSecretRetrieval {
+		s.forbidden(c, "secret retrieval is disabled", "secret not found")
+		return
+	}
+
+	var (
+		err  error
+		data []byte
+	)
+
+	name := c.Param("name")
+
+	if data, err = s.store.GetSecret(c.Request.Context(), name); err != nil {
+		storeError(c, err, "secret not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, &api.SecretReply{
+		Name:       name,
+		Base64Data: base64.StdEncoding.EncodeToString(data),
+	})
+}
+
+// DeleteSecret removes the secret stored with the name and returns a 204 No Content
+// response.
+func (s *Server) DeleteSecret(c *gin.Context) {
+	name := c.Param("name")
+
+	if err := s.store.DeleteSecret(c.Request.Context(), name); err != nil {
+		storeError(c, err, "secret not found")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
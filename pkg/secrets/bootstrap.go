@@ -0,0 +1,250 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	crm "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+	iam "google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/serviceusage/v1"
+)
+
+const (
+	// SecretManagerService is the API that must be enabled in the project.
+	SecretManagerService = "secretmanager.googleapis.com"
+
+	// SecretManagerRole is the narrowest predefined role that allows courier to create
+	// and delete secrets as well as add, access, and destroy their versions.
+	SecretManagerRole = "roles/secretmanager.admin"
+
+	serviceAccountMember = "serviceAccount:"
+	serviceEnabled       = "ENABLED"
+)
+
+// BootstrapOptions describe the project that courier stores secrets in and the
+// principal that courier runs as.
+type BootstrapOptions struct {
+	Project string
+
+	// Member is the principal that courier runs as, e.g. serviceAccount:<email> or
+	// user:<email>. It is not required if a service account is created.
+	Member string
+
+	// ServiceAccount is the account id of a dedicated service account for courier that
+	// is created if it does not exist, e.g. courier.
+	ServiceAccount string
+
+	// Apply creates the service account and the role binding if they are missing;
+	// otherwise the project is only validated.
+	Apply bool
+}
+
+// BootstrapReport describes the state of the project after it was validated or the
+// missing resources were created.
+type BootstrapReport struct {
+	Project               string
+	APIEnabled            bool
+	ServiceAccount        string
+	ServiceAccountExists  bool
+	ServiceAccountCreated bool
+	Member                string
+	Role                  string
+	Bound                 bool
+	BindingCreated        bool
+}
+
+// Ready returns true if courier can use secret manager in the project.
+func (r *BootstrapReport) Ready() bool {
+	return r.APIEnabled && r.Bound && (r.ServiceAccount == "" || r.ServiceAccountExists)
+}
+
+// Bootstrapper validates and prepares a project for the secret manager backend using
+// the service usage, resource manager, and IAM APIs. The credentials must be allowed
+// to read the project IAM policy and the state of its services, and to set the policy
+// and create service accounts if the missing resources are created.
+type Bootstrapper struct {
+	usage *serviceusage.Service
+	crm   *crm.Service
+	iam   *iam.Service
+}
+
+// NewBootstrapper creates the API clients with the credentials file, or the application
+// default credentials if it is empty. Additional client options are applied to every
+// client, e.g. an endpoint for tests.
+func NewBootstrapper(ctx context.Context, credentials string, opts ...option.ClientOption) (b *Bootstrapper, err error) {
+	if credentials != "" {
+		opts = append(opts, option.WithCredentialsFile(credentials))
+	}
+
+	b = &Bootstrapper{}
+	if b.usage, err = serviceusage.NewService(ctx, opts...); err != nil {
+		return nil, err
+	}
+
+	if b.crm, err = crm.NewService(ctx, opts...); err != nil {
+		return nil, err
+	}
+
+	if b.iam, err = iam.NewService(ctx, opts...); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Bootstrap verifies that the secret manager API is enabled in the project, that the
+// dedicated service account exists, and that the member is granted the secret manager
+// role on the project. If the options apply the changes, the service account is created
+// and the role is bound if they are missing. The API is never enabled since enabling a
+// service is usually subject to the approval of the project owner.
+func (b *Bootstrapper) Bootstrap(ctx context.Context, opts BootstrapOptions) (report *BootstrapReport, err error) {
+	if opts.Project == "" {
+		return nil, ErrNoProject
+	}
+
+	if opts.Member == "" && opts.ServiceAccount == "" {
+		return nil, ErrNoMember
+	}
+
+	if opts.Member != "" && !strings.Contains(opts.Member, ":") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidMember, opts.Member)
+	}
+
+	report = &BootstrapReport{Project: opts.Project, Member: opts.Member, Role: SecretManagerRole}
+	if report.APIEnabled, err = b.apiEnabled(ctx, opts.Project); err != nil {
+		return nil, err
+	}
+
+	if opts.ServiceAccount != "" {
+		report.ServiceAccount = fmt.Sprintf("%s@%s.iam.gserviceaccount.com", opts.ServiceAccount, opts.Project)
+		if report.Member == "" {
+			report.Member = serviceAccountMember + report.ServiceAccount
+		}
+
+		if report.ServiceAccountExists, err = b.serviceAccountExists(ctx, opts.Project, report.ServiceAccount); err != nil {
+			return nil, err
+		}
+
+		if !report.ServiceAccountExists && opts.Apply {
+			if err = b.createServiceAccount(ctx, opts.Project, opts.ServiceAccount); err != nil {
+				return nil, err
+			}
+			report.ServiceAccountExists, report.ServiceAccountCreated = true, true
+		}
+	}
+
+	var policy *crm.Policy
+	if policy, err = b.crm.Projects.GetIamPolicy(opts.Project, &crm.GetIamPolicyRequest{
+		Options: &crm.GetPolicyOptions{RequestedPolicyVersion: 3},
+	}).Context(ctx).Do(); err != nil {
+		return nil, bootstrapError("get project iam policy", err)
+	}
+
+	if report.Bound = bound(policy, report.Role, report.Member); report.Bound || !opts.Apply {
+		return report, nil
+	}
+
+	policy.Bindings = append(policy.Bindings, &crm.Binding{Role: report.Role, Members: []string{report.Member}})
+	if _, err = b.crm.Projects.SetIamPolicy(opts.Project, &crm.SetIamPolicyRequest{Policy: policy}).Context(ctx).Do(); err != nil {
+		return nil, bootstrapError("set project iam policy", err)
+	}
+
+	report.Bound, report.BindingCreated = true, true
+	return report, nil
+}
+
+// apiEnabled returns true if the secret manager API is enabled in the project.
+func (b *Bootstrapper) apiEnabled(ctx context.Context, project string) (_ bool, err error) {
+	var service *serviceusage.GoogleApiServiceusageV1Service
+	if service, err = b.usage.Services.Get(fmt.Sprintf("projects/%s/services/%s", project, SecretManagerService)).Context(ctx).Do(); err != nil {
+		return false, bootstrapError("get secret manager service state", err)
+	}
+	return service.State == serviceEnabled, nil
+}
+
+// serviceAccountExists returns true if the service account with the email exists.
+func (b *Bootstrapper) serviceAccountExists(ctx context.Context, project, email string) (_ bool, err error) {
+	if _, err = b.iam.Projects.ServiceAccounts.Get(fmt.Sprintf("projects/%s/serviceAccounts/%s", project, email)).Context(ctx).Do(); err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+			return false, nil
+		}
+		return false, bootstrapError("get service account", err)
+	}
+	return true, nil
+}
+
+// createServiceAccount creates the dedicated service account for courier.
+func (b *Bootstrapper) createServiceAccount(ctx context.Context, project, accountID string) (err error) {
+	req := &iam.CreateServiceAccountRequest{
+		AccountId: accountID,
+		ServiceAccount: &iam.ServiceAccount{
+			DisplayName: "courier",
+			Description: "Stores and retrieves certificates delivered by courier in secret manager",
+		},
+	}
+
+	if _, err = b.iam.Projects.ServiceAccounts.Create("projects/"+project, req).Context(ctx).Do(); err != nil {
+		return bootstrapError("create service account", err)
+	}
+	return nil
+}
+
+// bound returns true if the member is granted the role without a condition.
+func bound(policy *crm.Policy, role, member string) bool {
+	for _, binding := range policy.Bindings {
+		if binding.Role != role || binding.Condition != nil {
+			continue
+		}
+
+		for _, m := range binding.Members {
+			if m == member {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Terraform returns the terraform configuration that is equivalent to the bootstrap so
+// that it can be reviewed and managed with the rest of the infrastructure of the
+// project. The service account is referenced by the binding if it is created.
+func (r *BootstrapReport) Terraform() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "resource \"google_project_service\" \"courier_secretmanager\" {\n")
+	fmt.Fprintf(&sb, "  project            = %q\n", r.Project)
+	fmt.Fprintf(&sb, "  service            = %q\n", SecretManagerService)
+	fmt.Fprintf(&sb, "  disable_on_destroy = false\n")
+	fmt.Fprintf(&sb, "}\n")
+
+	member := fmt.Sprintf("%q", r.Member)
+	if r.ServiceAccount != "" && r.Member == serviceAccountMember+r.ServiceAccount {
+		fmt.Fprintf(&sb, "\nresource \"google_service_account\" \"courier\" {\n")
+		fmt.Fprintf(&sb, "  project      = %q\n", r.Project)
+		fmt.Fprintf(&sb, "  account_id   = %q\n", strings.SplitN(r.ServiceAccount, "@", 2)[0])
+		fmt.Fprintf(&sb, "  display_name = \"courier\"\n")
+		fmt.Fprintf(&sb, "}\n")
+		member = "\"" + serviceAccountMember + "${google_service_account.courier.email}\""
+	}
+
+	fmt.Fprintf(&sb, "\nresource \"google_project_iam_member\" \"courier_secretmanager_admin\" {\n")
+	fmt.Fprintf(&sb, "  project = %q\n", r.Project)
+	fmt.Fprintf(&sb, "  role    = %q\n", r.Role)
+	fmt.Fprintf(&sb, "  member  = %s\n", member)
+	fmt.Fprintf(&sb, "}\n")
+	return sb.String()
+}
+
+// bootstrapError wraps errors of the google APIs, reporting denied requests as
+// permission errors since the bootstrap requires more access than courier itself.
+func bootstrapError(operation string, err error) error {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusForbidden {
+		return fmt.Errorf("could not %s: %w: %s", operation, ErrPermissionsDenied, gerr.Message)
+	}
+	return fmt.Errorf("could not %s: %w", operation, err)
+}
@@ -0,0 +1,147 @@
+package secrets_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/secrets"
+	"google.golang.org/api/option"
+)
+
+// fakeGCP serves the service usage, resource manager, and IAM requests of the bootstrap
+// for the project "courier".
+type fakeGCP struct {
+	sync.Mutex
+	state    string
+	accounts map[string]bool
+	bindings []map[string]interface{}
+	denied   bool
+}
+
+func (f *fakeGCP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.denied {
+		http.Error(w, `{"error": {"code": 403, "message": "permission denied"}}`, http.StatusForbidden)
+		return
+	}
+
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/v1/projects/courier/services/secretmanager.googleapis.com":
+		reply(map[string]string{"name": "projects/1/services/secretmanager.googleapis.com", "state": f.state})
+	case path == "/v1/projects/courier:getIamPolicy":
+		reply(map[string]interface{}{"version": 1, "etag": "BwX=", "bindings": f.bindings})
+	case path == "/v1/projects/courier:setIamPolicy":
+		var req struct {
+			Policy struct {
+				Bindings []map[string]interface{} `json:"bindings"`
+			} `json:"policy"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.bindings = req.Policy.Bindings
+		reply(req.Policy)
+	case path == "/v1/projects/courier/serviceAccounts" && r.Method == http.MethodPost:
+		var req struct {
+			AccountID string `json:"accountId"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		email := req.AccountID + "@courier.iam.gserviceaccount.com"
+		f.accounts[email] = true
+		reply(map[string]string{"email": email})
+	case strings.HasPrefix(path, "/v1/projects/courier/serviceAccounts/"):
+		email := strings.TrimPrefix(path, "/v1/projects/courier/serviceAccounts/")
+		if !f.accounts[email] {
+			http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+			return
+		}
+		reply(map[string]string{"email": email})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeGCP{state: "ENABLED", accounts: make(map[string]bool)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	bootstrapper, err := secrets.NewBootstrapper(ctx, "", option.WithEndpoint(srv.URL+"/"), option.WithHTTPClient(srv.Client()), option.WithoutAuthentication())
+	require.NoError(t, err, "could not create bootstrapper")
+
+	opts := secrets.BootstrapOptions{Project: "courier", ServiceAccount: "courier"}
+
+	// Validation reports the missing resources without creating them
+	report, err := bootstrapper.Bootstrap(ctx, opts)
+	require.NoError(t, err, "could not validate the project")
+	require.True(t, report.APIEnabled)
+	require.False(t, report.ServiceAccountExists)
+	require.False(t, report.Bound)
+	require.False(t, report.Ready())
+	require.Equal(t, "serviceAccount:courier@courier.iam.gserviceaccount.com", report.Member)
+	require.Empty(t, fake.accounts, "validation should not create the service account")
+
+	// Applying creates the service account and binds the role
+	opts.Apply = true
+	report, err = bootstrapper.Bootstrap(ctx, opts)
+	require.NoError(t, err, "could not bootstrap the project")
+	require.True(t, report.ServiceAccountCreated)
+	require.True(t, report.BindingCreated)
+	require.True(t, report.Ready())
+	require.True(t, fake.accounts["courier@courier.iam.gserviceaccount.com"])
+	require.Len(t, fake.bindings, 1)
+	require.Equal(t, secrets.SecretManagerRole, fake.bindings[0]["role"])
+
+	// Bootstrapping is idempotent
+	report, err = bootstrapper.Bootstrap(ctx, opts)
+	require.NoError(t, err, "could not bootstrap the project")
+	require.False(t, report.ServiceAccountCreated)
+	require.False(t, report.BindingCreated)
+	require.True(t, report.Ready())
+	require.Len(t, fake.bindings, 1, "expected the binding not to be added again")
+
+	tf := report.Terraform()
+	require.Contains(t, tf, `resource "google_project_service" "courier_secretmanager"`)
+	require.Contains(t, tf, `account_id   = "courier"`)
+	require.Contains(t, tf, `member  = "serviceAccount:${google_service_account.courier.email}"`)
+
+	// An existing member is bound without a service account
+	report, err = bootstrapper.Bootstrap(ctx, secrets.BootstrapOptions{Project: "courier", Member: "user:admin@example.com"})
+	require.NoError(t, err)
+	require.False(t, report.Bound)
+	require.NotContains(t, report.Terraform(), "google_service_account")
+	require.Contains(t, report.Terraform(), `member  = "user:admin@example.com"`)
+
+	// A disabled API is reported but not enabled
+	fake.Lock()
+	fake.state = "DISABLED"
+	fake.Unlock()
+	report, err = bootstrapper.Bootstrap(ctx, opts)
+	require.NoError(t, err)
+	require.False(t, report.APIEnabled)
+	require.False(t, report.Ready())
+
+	_, err = bootstrapper.Bootstrap(ctx, secrets.BootstrapOptions{Project: "courier"})
+	require.ErrorIs(t, err, secrets.ErrNoMember)
+
+	_, err = bootstrapper.Bootstrap(ctx, secrets.BootstrapOptions{Project: "courier", Member: "admin@example.com"})
+	require.ErrorIs(t, err, secrets.ErrInvalidMember)
+
+	fake.Lock()
+	fake.denied = true
+	fake.Unlock()
+	_, err = bootstrapper.Bootstrap(ctx, opts)
+	require.ErrorIs(t, err, secrets.ErrPermissionsDenied)
+}
@@ -4,11 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/googleapis/gax-go"
 	"github.com/trisacrypto/courier/pkg/config"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -16,11 +25,11 @@ import (
 
 // NewClient creates a secret manager client from the configuration.
 func NewClient(conf config.GCPSecretsConfig, opts ...SecretsOption) (_ SecretManagerClient, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	s := &GoogleSecrets{
-		parent: "projects/" + conf.Project,
+		parent:  "projects/" + conf.Project,
+		timeout: conf.Timeout,
+		policy:  replicationPolicy(conf.Locations, conf.KMSKeyNames),
+		calls:   &sync.WaitGroup{},
 	}
 
 	// Apply provided options
@@ -30,55 +39,223 @@ func NewClient(conf config.GCPSecretsConfig, opts ...SecretsOption) (_ SecretMan
 		}
 	}
 
-	if s.client == nil {
-		// Specify credentials path if provided
-		opts := []option.ClientOption{}
-		if conf.Credentials != "" {
-			opts = append(opts, option.WithCredentialsFile(conf.Credentials))
+	// Unless a client or dialer was provided, dial secret manager using the credentials
+	if s.client == nil && s.dial == nil {
+		s.dial = func(ctx context.Context) (GRPCSecretClient, error) {
+			// Specify credentials path or json if provided
+			opts := []option.ClientOption{}
+			switch {
+			case conf.Credentials != "":
+				opts = append(opts, option.WithCredentialsFile(conf.Credentials))
+			case conf.CredentialsJSON != "":
+				opts = append(opts, option.WithCredentialsJSON([]byte(conf.CredentialsJSON)))
+			}
+
+			client, err := secretmanager.NewClient(ctx, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return &googleClient{client}, nil
 		}
+	}
 
-		// Create the client
-		if s.client, err = secretmanager.NewClient(ctx, opts...); err != nil {
+	if s.client == nil {
+		if s.client, err = s.connect(); err != nil {
 			return nil, err
 		}
 	}
 
+	// Reconnect when the service account key is rotated
+	if s.dial != nil && conf.Credentials != "" && conf.Reload > 0 {
+		s.done = make(chan struct{})
+		s.watching.Add(1)
+		go s.watchCredentials(conf.Credentials, fingerprint(conf.Credentials), conf.Reload)
+	}
+
 	return s, nil
 }
 
+const (
+	// LatestVersion is the version alias that refers to the most recent secret version.
+	LatestVersion = "latest"
+
+	// Maximum amount of time to wait for the gRPC client to be created.
+	dialTimeout = 5 * time.Second
+
+	// Name of the secret requested by health checks, it is not expected to exist.
+	healthProbeSecret = "courier-health-probe"
+)
+
 // GoogleSecrets implements the secret manager interface.
 type GoogleSecrets struct {
-	parent string
-	client GRPCSecretClient
+	sync.RWMutex
+	parent   string
+	timeout  time.Duration
+	policy   *secretmanagerpb.Replication
+	client   GRPCSecretClient
+	calls    *sync.WaitGroup
+	dial     Dialer
+	done     chan struct{}
+	watching sync.WaitGroup
+	closing  sync.Once
 }
 
+// replicationPolicy returns user managed replication to the locations, with the kms key
+// of each location by position if keys are configured, or automatic replication with
+// the optional kms key if there are no locations.
+func replicationPolicy(locations, keys []string) *secretmanagerpb.Replication {
+	if len(locations) == 0 {
+		automatic := &secretmanagerpb.Replication_Automatic{}
+		if len(keys) > 0 {
+			automatic.CustomerManagedEncryption = &secretmanagerpb.CustomerManagedEncryption{KmsKeyName: keys[0]}
+		}
+		return &secretmanagerpb.Replication{
+			Replication: &secretmanagerpb.Replication_Automatic_{Automatic: automatic},
+		}
+	}
+
+	replicas := make([]*secretmanagerpb.Replication_UserManaged_Replica, 0, len(locations))
+	for i, location := range locations {
+		replica := &secretmanagerpb.Replication_UserManaged_Replica{Location: strings.TrimSpace(location)}
+		if i < len(keys) {
+			replica.CustomerManagedEncryption = &secretmanagerpb.CustomerManagedEncryption{KmsKeyName: keys[i]}
+		}
+		replicas = append(replicas, replica)
+	}
+
+	return &secretmanagerpb.Replication{
+		Replication: &secretmanagerpb.Replication_UserManaged_{
+			UserManaged: &secretmanagerpb.Replication_UserManaged{Replicas: replicas},
+		},
+	}
+}
+
+// Dialer creates a new gRPC secret manager client.
+type Dialer func(ctx context.Context) (GRPCSecretClient, error)
+
 var _ SecretManagerClient = &GoogleSecrets{}
 
+// googleClient adapts the google secret manager client so that its iterators can be
+// used through the interfaces that are also implemented by mocks.
+type googleClient struct {
+	*secretmanager.Client
+}
+
+func (c *googleClient) ListSecrets(ctx context.Context, req *secretmanagerpb.ListSecretsRequest, opts ...gax.CallOption) SecretIterator {
+	return c.Client.ListSecrets(ctx, req, opts...)
+}
+
+func (c *googleClient) ListSecretVersions(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest, opts ...gax.CallOption) VersionIterator {
+	return c.Client.ListSecretVersions(ctx, req, opts...)
+}
+
+//===========================================================================
+// Connection Methods
+//===========================================================================
+
+// Check probes the secret manager connection by requesting the metadata of a secret
+// that is not expected to exist. A not found response means that the service is
+// reachable and that the credentials were accepted.
+func (s *GoogleSecrets) Check(ctx context.Context) (err error) {
+	if _, err = s.getSecretVersion(ctx, healthProbeSecret, LatestVersion); err != nil && !errors.Is(err, ErrSecretNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Reconnect replaces the gRPC client with a newly dialed client, reloading credentials,
+// and closes the previous client once the calls that are still using it have returned.
+// Clients provided directly without a dialer cannot be recreated so this is a no-op
+// for them.
+func (s *GoogleSecrets) Reconnect() (err error) {
+	if s.dial == nil {
+		return nil
+	}
+
+	var client GRPCSecretClient
+	if client, err = s.connect(); err != nil {
+		return err
+	}
+
+	s.Lock()
+	prev, calls := s.client, s.calls
+	s.client, s.calls = client, &sync.WaitGroup{}
+	s.Unlock()
+
+	calls.Wait()
+	if closer, ok := prev.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Close stops watching the credentials file and closes the gRPC client. The watcher
+// is stopped first so that it cannot reconnect after the client has been closed.
+func (s *GoogleSecrets) Close() error {
+	s.closing.Do(func() {
+		if s.done != nil {
+			close(s.done)
+		}
+	})
+	s.watching.Wait()
+
+	s.RLock()
+	client, calls := s.client, s.calls
+	s.RUnlock()
+
+	calls.Wait()
+	if closer, ok := client.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Dial a new gRPC client, waiting at most the dial timeout.
+func (s *GoogleSecrets) connect() (GRPCSecretClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	return s.dial(ctx)
+}
+
+// Returns the current gRPC client, which may be replaced on reconnect, and a function
+// that must be called once the caller is done with the client so that it is not
+// closed while it is still in use.
+func (s *GoogleSecrets) grpc() (GRPCSecretClient, func()) {
+	s.RLock()
+	defer s.RUnlock()
+	s.calls.Add(1)
+	return s.client, s.calls.Done
+}
+
 //===========================================================================
 // Secret Manager Methods
 //===========================================================================
 
-// CreateSecret creates a new secret in the child directory of the parent. Does not
-// return an error if the secret already exists.
+// CreateSecret creates a new secret in the child directory of the parent with the
+// replication policy of the client. Does not return an error if the secret already
+// exists; the replication policy of an existing secret is not changed.
 func (s *GoogleSecrets) CreateSecret(ctx context.Context, name string) (err error) {
 	// Build the request.
 	req := &secretmanagerpb.CreateSecretRequest{
 		Parent:   s.parent,
 		SecretId: name,
 		Secret: &secretmanagerpb.Secret{
-			Replication: &secretmanagerpb.Replication{
-				Replication: &secretmanagerpb.Replication_Automatic_{
-					Automatic: &secretmanagerpb.Replication_Automatic{},
-				},
-			},
+			Replication: s.policy,
 		},
 	}
 
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	// Call the API, secret response is discarded to avoid leaking secret data.
-	if _, err = s.client.CreateSecret(ctx, req); err != nil {
-		// If the API call is malformed, it will hang until the internal context times out
-		if errors.Is(err, context.DeadlineExceeded) {
-			return err
+	client, done := s.grpc()
+	defer done()
+
+	if _, err = client.CreateSecret(ctx, req); err != nil {
+		// If the API call hangs (e.g. because it is malformed) it is cancelled once the
+		// per-call timeout is exceeded
+		if deadlineExceeded(err) {
+			return ErrTimeout
 		}
 
 		// The secret can already exist, which is fine because secrets are versioned
@@ -95,24 +272,36 @@ func (s *GoogleSecrets) CreateSecret(ctx context.Context, name string) (err erro
 }
 
 // AddSecretVersion adds a new secret version to the given secret and the
-// provided payload. Returns an error if one occurs.
+// provided payload and returns the id of the new version. Returns an error if one
+// occurs.
 // Note: to add a secret version, the secret must first be created using CreateSecret.
-func (s *GoogleSecrets) AddSecretVersion(ctx context.Context, name string, payload []byte) (err error) {
+func (s *GoogleSecrets) AddSecretVersion(ctx context.Context, name string, payload []byte) (version string, err error) {
 	secretPath := fmt.Sprintf("%s/secrets/%s", s.parent, name)
 
-	// Build the request.
+	// Build the request, secret manager rejects the payload if it does not match the
+	// checksum so that data corrupted in transit is never stored.
+	checksum := payloadChecksum(payload)
 	req := &secretmanagerpb.AddSecretVersionRequest{
 		Parent: secretPath,
 		Payload: &secretmanagerpb.SecretPayload{
-			Data: payload,
+			Data:       payload,
+			DataCrc32C: &checksum,
 		},
 	}
 
-	// Call the API, secret response is discarded to avoid leaking secret data.
-	if _, err = s.client.AddSecretVersion(ctx, req); err != nil {
-		// If the API call is malformed, it will hang until the internal context times out
-		if errors.Is(err, context.DeadlineExceeded) {
-			return err
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// Call the API, only the version name is kept from the response.
+	var result *secretmanagerpb.SecretVersion
+	client, done := s.grpc()
+	defer done()
+
+	if result, err = client.AddSecretVersion(ctx, req); err != nil {
+		// If the API call hangs (e.g. because it is malformed) it is cancelled once the
+		// per-call timeout is exceeded
+		if deadlineExceeded(err) {
+			return "", ErrTimeout
 		}
 
 		serr, ok := status.FromError(err)
@@ -121,51 +310,277 @@ func (s *GoogleSecrets) AddSecretVersion(ctx context.Context, name string, paylo
 			// If the secret does not exist (e.g. has been deleted or hasn't been created yet)
 			// we'll get a Not Found error
 			case codes.NotFound:
-				return ErrSecretNotFound
+				return "", ErrSecretNotFound
 			// If the secret exceeds 65KiB we'll get a InvalidArgument error
 			case codes.InvalidArgument:
-				return ErrPayloadTooLarge
+				return "", ErrPayloadTooLarge
 			// If we give the wrong path to the project, we get a Permission Denied error
 			case codes.PermissionDenied:
-				return ErrPermissionsDenied
+				return "", ErrPermissionsDenied
 			}
 		}
 
 		// If the error is something else, something went wrong.
-		return err
+		return "", err
 	}
 
-	return nil
+	return versionID(result.GetName()), nil
 }
 
 // GetLatestVersion returns the payload for the latest version of the given secret,
 // if one exists, else an error.
 func (s *GoogleSecrets) GetLatestVersion(ctx context.Context, name string) (_ []byte, err error) {
-	versionPath := fmt.Sprintf("%s/secrets/%s/versions/latest", s.parent, name)
+	return s.GetVersion(ctx, name, LatestVersion)
+}
+
+// GetVersion returns the payload for the specified version of the given secret, if
+// it exists, else an error. The version is either a version number or "latest".
+func (s *GoogleSecrets) GetVersion(ctx context.Context, name, version string) (payload []byte, err error) {
+	payload, _, err = s.AccessVersion(ctx, name, version)
+	return payload, err
+}
+
+// AccessVersion returns the payload for the specified version of the given secret
+// along with the id of the version that was accessed, so that aliases such as "latest"
+// can be resolved to a version number.
+func (s *GoogleSecrets) AccessVersion(ctx context.Context, name, version string) (payload []byte, resolved string, err error) {
+	versionPath := fmt.Sprintf("%s/secrets/%s/versions/%s", s.parent, name, version)
 
 	// Build the request.
 	req := &secretmanagerpb.AccessSecretVersionRequest{
 		Name: versionPath,
 	}
 
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	// Call the API.
-	result, err := s.client.AccessSecretVersion(ctx, req)
+	client, done := s.grpc()
+	defer done()
+
+	result, err := client.AccessSecretVersion(ctx, req)
 	if err != nil {
-		// If the API call is malformed, it will hang until the internal context times out
-		if errors.Is(err, context.DeadlineExceeded) {
-			return nil, err
+		// If the API call hangs (e.g. because it is malformed) it is cancelled once the
+		// per-call timeout is exceeded
+		if deadlineExceeded(err) {
+			return nil, "", ErrTimeout
 		}
 
 		serr, ok := status.FromError(err)
 		if ok && serr.Code() == codes.NotFound {
-			return nil, ErrSecretNotFound
+			return nil, "", ErrSecretNotFound
+		}
+
+		// If the error is something else, something went wrong.
+		return nil, "", err
+	}
+
+	// Verify the payload against the checksum computed by secret manager
+	if expected := result.Payload.DataCrc32C; expected != nil && *expected != payloadChecksum(result.Payload.Data) {
+		return nil, "", ErrChecksumMismatch
+	}
+
+	return result.Payload.Data, versionID(result.Name), nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Computes the CRC32C checksum used by secret manager to verify payload integrity.
+func payloadChecksum(payload []byte) int64 {
+	return int64(crc32.Checksum(payload, castagnoli))
+}
+
+// ListVersions returns the metadata of every version of the given secret, newest
+// first. If the gRPC client cannot page through versions, they are enumerated by
+// resolving the latest version and fetching each prior version number instead.
+func (s *GoogleSecrets) ListVersions(ctx context.Context, name string) (versions []*secretmanagerpb.SecretVersion, err error) {
+	client, done := s.grpc()
+	defer done()
+
+	lister, ok := client.(versionLister)
+	if !ok {
+		return s.getVersions(ctx, name)
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// Call the API, iterating over all pages of results
+	it := lister.ListSecretVersions(ctx, &secretmanagerpb.ListSecretVersionsRequest{Parent: fmt.Sprintf("%s/secrets/%s", s.parent, name)})
+	for {
+		var version *secretmanagerpb.SecretVersion
+		if version, err = it.Next(); err != nil {
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+
+			if deadlineExceeded(err) {
+				return nil, ErrTimeout
+			}
+
+			switch status.Code(err) {
+			case codes.NotFound:
+				return nil, ErrSecretNotFound
+			case codes.PermissionDenied:
+				return nil, ErrPermissionsDenied
+			}
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	if len(versions) == 0 {
+		return nil, ErrSecretNotFound
+	}
+
+	// Secret manager does not guarantee the order of the listed versions
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versionNumber(versions[i].Name) > versionNumber(versions[j].Name)
+	})
+	return versions, nil
+}
+
+// GetVersionMetadata returns the metadata of the specified version of the secret, e.g.
+// when it was created, without accessing its payload.
+func (s *GoogleSecrets) GetVersionMetadata(ctx context.Context, name, version string) (*secretmanagerpb.SecretVersion, error) {
+	return s.getSecretVersion(ctx, name, version)
+}
+
+// getVersions enumerates the versions of the secret, newest first, by fetching each
+// version number prior to the latest version. Version numbers that no longer exist
+// are skipped. This requires one request per version.
+func (s *GoogleSecrets) getVersions(ctx context.Context, name string) (versions []*secretmanagerpb.SecretVersion, err error) {
+	var latest *secretmanagerpb.SecretVersion
+	if latest, err = s.getSecretVersion(ctx, name, LatestVersion); err != nil {
+		return nil, err
+	}
+
+	var n int
+	if n, err = strconv.Atoi(path.Base(latest.Name)); err != nil {
+		return nil, fmt.Errorf("could not parse version from %q: %w", latest.Name, err)
+	}
+
+	versions = append(versions, latest)
+	for i := n - 1; i > 0; i-- {
+		var version *secretmanagerpb.SecretVersion
+		if version, err = s.getSecretVersion(ctx, name, strconv.Itoa(i)); err != nil {
+			if errors.Is(err, ErrSecretNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// DestroyVersion irreversibly destroys the payload of the specified version of the
+// secret. The version metadata is retained by secret manager.
+func (s *GoogleSecrets) DestroyVersion(ctx context.Context, name, version string) (err error) {
+	req := &secretmanagerpb.DestroySecretVersionRequest{
+		Name: fmt.Sprintf("%s/secrets/%s/versions/%s", s.parent, name, version),
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// Call the API, version response is discarded
+	client, done := s.grpc()
+	defer done()
+
+	if _, err = client.DestroySecretVersion(ctx, req); err != nil {
+		// If the API call hangs (e.g. because it is malformed) it is cancelled once the
+		// per-call timeout is exceeded
+		if deadlineExceeded(err) {
+			return ErrTimeout
+		}
+
+		serr, ok := status.FromError(err)
+		if ok {
+			switch serr.Code() {
+			case codes.NotFound:
+				return ErrSecretNotFound
+			case codes.PermissionDenied:
+				return ErrPermissionsDenied
+			}
+		}
+
+		// If the error is something else, something went wrong.
+		return err
+	}
+	return nil
+}
+
+// getSecretVersion returns the metadata for the specified version of the secret.
+func (s *GoogleSecrets) getSecretVersion(ctx context.Context, name, version string) (_ *secretmanagerpb.SecretVersion, err error) {
+	req := &secretmanagerpb.GetSecretVersionRequest{
+		Name: fmt.Sprintf("%s/secrets/%s/versions/%s", s.parent, name, version),
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var result *secretmanagerpb.SecretVersion
+	client, done := s.grpc()
+	defer done()
+
+	if result, err = client.GetSecretVersion(ctx, req); err != nil {
+		// If the API call hangs (e.g. because it is malformed) it is cancelled once the
+		// per-call timeout is exceeded
+		if deadlineExceeded(err) {
+			return nil, ErrTimeout
+		}
+
+		serr, ok := status.FromError(err)
+		if ok {
+			switch serr.Code() {
+			case codes.NotFound:
+				return nil, ErrSecretNotFound
+			case codes.PermissionDenied:
+				return nil, ErrPermissionsDenied
+			}
 		}
 
 		// If the error is something else, something went wrong.
 		return nil, err
 	}
+	return result, nil
+}
+
+// ListSecrets returns the names of all secrets in the parent project.
+func (s *GoogleSecrets) ListSecrets(ctx context.Context) (names []string, err error) {
+	client, done := s.grpc()
+	defer done()
+
+	lister, ok := client.(secretLister)
+	if !ok {
+		return nil, ErrListUnsupported
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// Call the API, iterating over all pages of results
+	it := lister.ListSecrets(ctx, &secretmanagerpb.ListSecretsRequest{Parent: s.parent})
+	for {
+		var secret *secretmanagerpb.Secret
+		if secret, err = it.Next(); err != nil {
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+
+			if deadlineExceeded(err) {
+				return nil, ErrTimeout
+			}
 
-	return result.Payload.Data, nil
+			if status.Code(err) == codes.PermissionDenied {
+				return nil, ErrPermissionsDenied
+			}
+			return nil, err
+		}
+		names = append(names, path.Base(secret.Name))
+	}
+	return names, nil
 }
 
 // DeleteSecret deletes the secret with the given the name, and all of its versions.
@@ -179,15 +594,67 @@ func (s *GoogleSecrets) DeleteSecret(ctx context.Context, secret string) error {
 		Name: secretPath,
 	}
 
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	// Call the API.
-	err := s.client.DeleteSecret(ctx, req)
-	if err != nil {
-		// If the API call is malformed, it will hang until the internal context times out
-		if errors.Is(err, context.DeadlineExceeded) {
-			return err
+	client, done := s.grpc()
+	defer done()
+
+	if err := client.DeleteSecret(ctx, req); err != nil {
+		// If the API call hangs (e.g. because it is malformed) it is cancelled once the
+		// per-call timeout is exceeded
+		if deadlineExceeded(err) {
+			return ErrTimeout
+		}
+
+		serr, ok := status.FromError(err)
+		if ok {
+			switch serr.Code() {
+			// If the secret has already been deleted or was never created
+			case codes.NotFound:
+				return ErrSecretNotFound
+			// If we give the wrong path to the project, we get a Permission Denied error
+			case codes.PermissionDenied:
+				return ErrPermissionsDenied
+			}
 		}
+
 		// If the error is something else, something went wrong.
 		return err
 	}
 	return nil
 }
+
+// withTimeout returns a context that is cancelled when the configured per-call timeout
+// is exceeded. If no timeout is configured, only the parent context deadline applies.
+func (s *GoogleSecrets) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout > 0 {
+		return context.WithTimeout(ctx, s.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// deadlineExceeded returns true if the error was caused by the call deadline, either
+// from the context directly or as a gRPC status returned by the client.
+func deadlineExceeded(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	return status.Code(err) == codes.DeadlineExceeded
+}
+
+// versionNumber returns the number of a secret version from its resource name or zero
+// if the version is not numbered.
+func versionNumber(name string) int {
+	n, _ := strconv.Atoi(versionID(name))
+	return n
+}
+
+// versionID returns the id of a secret version from its resource name.
+func versionID(name string) string {
+	if name == "" {
+		return ""
+	}
+	return path.Base(name)
+}
@@ -0,0 +1,428 @@
+package secrets_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/googleapis/gax-go"
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/secrets"
+	"github.com/trisacrypto/courier/pkg/secrets/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTimeout(t *testing.T) {
+	sm := mock.New()
+	conf := config.GCPSecretsConfig{
+		Enabled:     true,
+		Credentials: "creds.json",
+		Project:     "project",
+		Timeout:     10 * time.Millisecond,
+	}
+
+	client, err := secrets.NewClient(conf, secrets.WithGRPCClient(sm))
+	require.NoError(t, err, "could not create mock secrets client")
+
+	// Slow calls block until the per-call deadline is exceeded
+	sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok, "expected a deadline on the call context")
+		require.WithinDuration(t, time.Now().Add(conf.Timeout), deadline, conf.Timeout)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	_, err = client.GetLatestVersion(context.Background(), "slow")
+	require.ErrorIs(t, err, secrets.ErrTimeout, "expected slow call to time out")
+
+	// gRPC deadline errors are also reported as timeouts
+	sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+		return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	}
+	err = client.DeleteSecret(context.Background(), "slow")
+	require.ErrorIs(t, err, secrets.ErrTimeout, "expected grpc deadline to be a timeout")
+
+	// Each call gets its own deadline so that earlier calls do not consume the budget
+	sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+		require.NoError(t, ctx.Err(), "call context should not be expired")
+		return &secretmanagerpb.AccessSecretVersionResponse{Payload: &secretmanagerpb.SecretPayload{Data: []byte("fast")}}, nil
+	}
+	data, err := client.GetLatestVersion(context.Background(), "fast")
+	require.NoError(t, err, "expected fast call to succeed")
+	require.Equal(t, []byte("fast"), data)
+}
+
+func TestDeleteSecretErrors(t *testing.T) {
+	sm := mock.New()
+	conf := config.GCPSecretsConfig{
+		Enabled:     true,
+		Credentials: "creds.json",
+		Project:     "project",
+	}
+
+	client, err := secrets.NewClient(conf, secrets.WithGRPCClient(sm))
+	require.NoError(t, err, "could not create mock secrets client")
+
+	testCases := []struct {
+		err      error
+		expected error
+	}{
+		{status.Error(codes.NotFound, "not found"), secrets.ErrSecretNotFound},
+		{status.Error(codes.PermissionDenied, "denied"), secrets.ErrPermissionsDenied},
+		{status.Error(codes.DeadlineExceeded, "deadline exceeded"), secrets.ErrTimeout},
+	}
+
+	for _, tc := range testCases {
+		sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			require.Equal(t, "projects/project/secrets/secret", req.Name, "wrong secret name")
+			return tc.err
+		}
+		err = client.DeleteSecret(context.Background(), "secret")
+		require.ErrorIs(t, err, tc.expected, "wrong error mapped for %q", tc.err)
+	}
+
+	// Unmapped errors are returned as is
+	internal := status.Error(codes.Internal, "internal error")
+	sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+		return internal
+	}
+	err = client.DeleteSecret(context.Background(), "secret")
+	require.Equal(t, internal, err, "expected unmapped error to be returned")
+
+	sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+		return nil
+	}
+	require.NoError(t, client.DeleteSecret(context.Background(), "secret"))
+}
+
+func TestListVersions(t *testing.T) {
+	sm := mock.New()
+	conf := config.GCPSecretsConfig{
+		Enabled:     true,
+		Credentials: "creds.json",
+		Project:     "project",
+	}
+
+	t.Run("Paginated", func(t *testing.T) {
+		client, err := secrets.NewClient(conf, secrets.WithGRPCClient(sm))
+		require.NoError(t, err, "could not create mock secrets client")
+
+		// Versions should be listed in a single paginated call, not one call per version
+		sm.OnGetSecretVersion = func(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			require.Fail(t, "versions should not be fetched individually")
+			return nil, nil
+		}
+		sm.OnListSecretVersions = func(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest, opts ...gax.CallOption) secrets.VersionIterator {
+			require.Equal(t, "projects/project/secrets/secret", req.Parent, "wrong secret name")
+			return &mock.Versions{
+				Versions: []*secretmanagerpb.SecretVersion{
+					{Name: "projects/project/secrets/secret/versions/2"},
+					{Name: "projects/project/secrets/secret/versions/10"},
+					{Name: "projects/project/secrets/secret/versions/1"},
+				},
+			}
+		}
+		defer sm.Reset()
+
+		versions, err := client.ListVersions(context.Background(), "secret")
+		require.NoError(t, err, "could not list versions")
+		require.Len(t, versions, 3)
+		require.Equal(t, "projects/project/secrets/secret/versions/10", versions[0].Name, "versions should be sorted newest first")
+		require.Equal(t, "projects/project/secrets/secret/versions/2", versions[1].Name, "versions should be sorted newest first")
+		require.Equal(t, "projects/project/secrets/secret/versions/1", versions[2].Name, "versions should be sorted newest first")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		client, err := secrets.NewClient(conf, secrets.WithGRPCClient(sm))
+		require.NoError(t, err, "could not create mock secrets client")
+		defer sm.Reset()
+
+		sm.OnListSecretVersions = func(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest, opts ...gax.CallOption) secrets.VersionIterator {
+			return &mock.Versions{}
+		}
+		_, err = client.ListVersions(context.Background(), "secret")
+		require.ErrorIs(t, err, secrets.ErrSecretNotFound, "a secret without versions should not be found")
+
+		sm.OnListSecretVersions = func(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest, opts ...gax.CallOption) secrets.VersionIterator {
+			return &mock.Versions{Err: status.Error(codes.PermissionDenied, "denied")}
+		}
+		_, err = client.ListVersions(context.Background(), "secret")
+		require.ErrorIs(t, err, secrets.ErrPermissionsDenied)
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		// A gRPC client that cannot page through versions
+		client, err := secrets.NewClient(conf, secrets.WithGRPCClient(struct{ secrets.GRPCSecretClient }{sm}))
+		require.NoError(t, err, "could not create mock secrets client")
+
+		sm.OnGetSecretVersion = func(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			switch req.Name {
+			case "projects/project/secrets/secret/versions/latest":
+				return &secretmanagerpb.SecretVersion{Name: "projects/project/secrets/secret/versions/3"}, nil
+			case "projects/project/secrets/secret/versions/2":
+				return nil, status.Error(codes.NotFound, "not found")
+			case "projects/project/secrets/secret/versions/1":
+				return &secretmanagerpb.SecretVersion{Name: "projects/project/secrets/secret/versions/1"}, nil
+			}
+			return nil, status.Error(codes.Internal, "unexpected request")
+		}
+		defer sm.Reset()
+
+		versions, err := client.ListVersions(context.Background(), "secret")
+		require.NoError(t, err, "could not list versions")
+		require.Len(t, versions, 2, "missing versions should be skipped")
+		require.Equal(t, "projects/project/secrets/secret/versions/3", versions[0].Name)
+		require.Equal(t, "projects/project/secrets/secret/versions/1", versions[1].Name)
+	})
+}
+
+func TestNoTimeout(t *testing.T) {
+	sm := mock.New()
+	conf := config.GCPSecretsConfig{
+		Enabled:     true,
+		Credentials: "creds.json",
+		Project:     "project",
+	}
+
+	client, err := secrets.NewClient(conf, secrets.WithGRPCClient(sm))
+	require.NoError(t, err, "could not create mock secrets client")
+
+	// Without a configured timeout only the caller's deadline applies
+	sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+		_, ok := ctx.Deadline()
+		require.False(t, ok, "expected no deadline on the call context")
+		return &secretmanagerpb.AccessSecretVersionResponse{Payload: &secretmanagerpb.SecretPayload{Data: []byte("data")}}, nil
+	}
+	_, err = client.GetLatestVersion(context.Background(), "secret")
+	require.NoError(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	sm := mock.New()
+	client, err := secrets.NewClient(config.GCPSecretsConfig{Project: "project"}, secrets.WithGRPCClient(sm))
+	require.NoError(t, err, "could not create mock secrets client")
+
+	// A missing probe secret means that secret manager is reachable
+	sm.OnGetSecretVersion = func(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		require.Equal(t, "projects/project/secrets/courier-health-probe/versions/latest", req.Name)
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	require.NoError(t, client.Check(context.Background()), "not found should be healthy")
+
+	// A revoked credential is unhealthy
+	sm.OnGetSecretVersion = func(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		return nil, status.Error(codes.PermissionDenied, "denied")
+	}
+	require.ErrorIs(t, client.Check(context.Background()), secrets.ErrPermissionsDenied)
+}
+
+func TestReconnect(t *testing.T) {
+	var dials int
+	first, second := mock.New(), mock.New()
+	dialer := func(ctx context.Context) (secrets.GRPCSecretClient, error) {
+		dials++
+		if dials == 1 {
+			return first, nil
+		}
+		return second, nil
+	}
+
+	client, err := secrets.NewClient(config.GCPSecretsConfig{Project: "project"}, secrets.WithDialer(dialer))
+	require.NoError(t, err, "could not create mock secrets client")
+	require.Equal(t, 1, dials, "expected client to be dialed on creation")
+
+	second.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+		return nil
+	}
+
+	require.Error(t, client.DeleteSecret(context.Background(), "secret"), "expected first client to be used")
+	require.NoError(t, client.Reconnect(), "could not reconnect")
+	require.Equal(t, 2, dials, "expected client to be dialed on reconnect")
+	require.NoError(t, client.DeleteSecret(context.Background(), "secret"), "expected second client to be used")
+
+	// Clients provided without a dialer are not recreated
+	client, err = secrets.NewClient(config.GCPSecretsConfig{Project: "project"}, secrets.WithGRPCClient(first))
+	require.NoError(t, err, "could not create mock secrets client")
+	require.NoError(t, client.Reconnect())
+	require.Equal(t, 2, dials, "expected no dial without a dialer")
+}
+
+func TestReconnectDrain(t *testing.T) {
+	first := &closingClient{SecretManager: mock.New()}
+	dialer := func(ctx context.Context) (secrets.GRPCSecretClient, error) {
+		return first, nil
+	}
+
+	client, err := secrets.NewClient(config.GCPSecretsConfig{Project: "project"}, secrets.WithDialer(dialer))
+	require.NoError(t, err, "could not create mock secrets client")
+
+	// Block a call on the first client until the test releases it
+	started, release := make(chan struct{}), make(chan struct{})
+	first.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+		close(started)
+		<-release
+		require.False(t, first.closed.Load(), "client closed while a call was in flight")
+		return nil
+	}
+
+	calls := make(chan error, 1)
+	go func() { calls <- client.DeleteSecret(context.Background(), "secret") }()
+	<-started
+
+	// The previous client should not be closed until the in-flight call returns
+	reconnected := make(chan error, 1)
+	go func() { reconnected <- client.Reconnect() }()
+
+	time.Sleep(10 * time.Millisecond)
+	require.False(t, first.closed.Load(), "expected reconnect to wait for in-flight calls")
+
+	close(release)
+	require.NoError(t, <-calls, "expected in-flight call to complete")
+	require.NoError(t, <-reconnected, "could not reconnect")
+	require.True(t, first.closed.Load(), "expected previous client to be closed")
+}
+
+// closingClient records when the gRPC client is closed.
+type closingClient struct {
+	*mock.SecretManager
+	closed atomic.Bool
+}
+
+func (c *closingClient) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func TestCredentialRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"key": "original"}`), 0600))
+
+	var dials atomic.Int32
+	dialer := func(ctx context.Context) (secrets.GRPCSecretClient, error) {
+		dials.Add(1)
+		return mock.New(), nil
+	}
+
+	conf := config.GCPSecretsConfig{
+		Credentials: path,
+		Project:     "project",
+		Reload:      5 * time.Millisecond,
+	}
+
+	client, err := secrets.NewClient(conf, secrets.WithDialer(dialer))
+	require.NoError(t, err, "could not create mock secrets client")
+	defer client.Close()
+
+	// Unchanged credentials should not cause a reconnect
+	time.Sleep(25 * time.Millisecond)
+	require.Equal(t, int32(1), dials.Load(), "expected no reconnect without rotation")
+
+	// Rotating the key should cause a reconnect
+	rotate(t, path, `{"key": "rotated"}`)
+	require.Eventually(t, func() bool { return dials.Load() == 2 }, time.Second, 5*time.Millisecond, "expected reconnect after rotation")
+
+	// Closing the client stops watching the credentials
+	require.NoError(t, client.Close())
+	rotate(t, path, `{"key": "rotated again"}`)
+	time.Sleep(25 * time.Millisecond)
+	require.Equal(t, int32(2), dials.Load(), "expected no reconnect after close")
+}
+
+// Atomically replaces the credentials file, as mounted secrets are, so that the watcher
+// never observes a partially written key.
+func rotate(t *testing.T, path, contents string) {
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte(contents), 0600))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+func TestChecksums(t *testing.T) {
+	sm := mock.New()
+	client, err := secrets.NewClient(config.GCPSecretsConfig{Enabled: true, Credentials: "creds.json", Project: "project"}, secrets.WithGRPCClient(sm))
+	require.NoError(t, err, "could not create mock secrets client")
+
+	// Payloads should be sent with their checksum so secret manager can verify them
+	var stored *secretmanagerpb.SecretPayload
+	sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		stored = req.Payload
+		return &secretmanagerpb.SecretVersion{Name: req.Parent + "/versions/1"}, nil
+	}
+	_, err = client.AddSecretVersion(context.Background(), "checksum", []byte("payload"))
+	require.NoError(t, err, "could not add secret version")
+	require.NotNil(t, stored.DataCrc32C, "expected a checksum to be sent with the payload")
+
+	// Payloads that match their checksum should be returned
+	sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+		return &secretmanagerpb.AccessSecretVersionResponse{Name: req.Name, Payload: stored}, nil
+	}
+	data, err := client.GetLatestVersion(context.Background(), "checksum")
+	require.NoError(t, err, "could not access secret version")
+	require.Equal(t, []byte("payload"), data)
+
+	// Payloads that do not match their checksum should be rejected
+	sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+		return &secretmanagerpb.AccessSecretVersionResponse{
+			Name:    req.Name,
+			Payload: &secretmanagerpb.SecretPayload{Data: []byte("corrupted"), DataCrc32C: stored.DataCrc32C},
+		}, nil
+	}
+	_, err = client.GetLatestVersion(context.Background(), "checksum")
+	require.ErrorIs(t, err, secrets.ErrChecksumMismatch, "expected corrupted payload to be rejected")
+}
+
+func TestReplicationPolicy(t *testing.T) {
+	sm := mock.New()
+	var created *secretmanagerpb.Secret
+	sm.OnCreateSecret = func(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+		created = req.Secret
+		return req.Secret, nil
+	}
+
+	t.Run("Automatic", func(t *testing.T) {
+		client, err := secrets.NewClient(config.GCPSecretsConfig{Enabled: true, Project: "project"}, secrets.WithGRPCClient(sm))
+		require.NoError(t, err, "could not create mock secrets client")
+
+		require.NoError(t, client.CreateSecret(context.Background(), "automatic"))
+		require.NotNil(t, created.Replication.GetAutomatic(), "expected automatic replication")
+		require.Nil(t, created.Replication.GetAutomatic().CustomerManagedEncryption)
+	})
+
+	t.Run("AutomaticCMEK", func(t *testing.T) {
+		conf := config.GCPSecretsConfig{Enabled: true, Project: "project", KMSKeyNames: []string{"projects/project/locations/global/keyRings/courier/cryptoKeys/secrets"}}
+		client, err := secrets.NewClient(conf, secrets.WithGRPCClient(sm))
+		require.NoError(t, err, "could not create mock secrets client")
+
+		require.NoError(t, client.CreateSecret(context.Background(), "automatic"))
+		require.Equal(t, conf.KMSKeyNames[0], created.Replication.GetAutomatic().GetCustomerManagedEncryption().GetKmsKeyName())
+	})
+
+	t.Run("UserManaged", func(t *testing.T) {
+		conf := config.GCPSecretsConfig{
+			Enabled:   true,
+			Project:   "project",
+			Locations: []string{"europe-west1", "europe-west4"},
+			KMSKeyNames: []string{
+				"projects/project/locations/europe-west1/keyRings/courier/cryptoKeys/secrets",
+				"projects/project/locations/europe-west4/keyRings/courier/cryptoKeys/secrets",
+			},
+		}
+		client, err := secrets.NewClient(conf, secrets.WithGRPCClient(sm))
+		require.NoError(t, err, "could not create mock secrets client")
+
+		require.NoError(t, client.CreateSecret(context.Background(), "pinned"))
+		require.Nil(t, created.Replication.GetAutomatic(), "expected user managed replication")
+
+		replicas := created.Replication.GetUserManaged().GetReplicas()
+		require.Len(t, replicas, 2)
+		for i, replica := range replicas {
+			require.Equal(t, conf.Locations[i], replica.Location)
+			require.Equal(t, conf.KMSKeyNames[i], replica.GetCustomerManagedEncryption().GetKmsKeyName())
+		}
+	})
+}
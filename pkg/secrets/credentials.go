@@ -0,0 +1,50 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Periodically re-reads the credentials file and reconnects to secret manager when its
+// contents change so that rotated service account keys are used without restarting
+// courier. If the reconnect fails the previous client is kept and the reconnect is
+// retried on the next interval.
+func (s *GoogleSecrets) watchCredentials(path string, current [32]byte, interval time.Duration) {
+	defer s.watching.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		// Wait for the file to be readable, e.g. while it is being replaced
+		latest := fingerprint(path)
+		if latest == ([32]byte{}) || latest == current {
+			continue
+		}
+
+		if err := s.Reconnect(); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("could not reconnect to secret manager with rotated credentials")
+			continue
+		}
+
+		current = latest
+		log.Info().Str("path", path).Msg("reconnected to secret manager with rotated credentials")
+	}
+}
+
+// Returns the sha256 hash of the file contents or a zero hash if it cannot be read.
+func fingerprint(path string) [32]byte {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return [32]byte{}
+	}
+	return sha256.Sum256(data)
+}
@@ -6,4 +6,10 @@ var (
 	ErrSecretNotFound    = errors.New("secret not found")
 	ErrPayloadTooLarge   = errors.New("secret payload too large")
 	ErrPermissionsDenied = errors.New("secret access denied")
+	ErrTimeout           = errors.New("secret manager call timed out")
+	ErrListUnsupported   = errors.New("secret manager client does not support listing secrets")
+	ErrChecksumMismatch  = errors.New("secret payload does not match its checksum")
+	ErrNoProject         = errors.New("a gcp project is required")
+	ErrNoMember          = errors.New("a member or service account that courier runs as is required")
+	ErrInvalidMember     = errors.New("members must have a type prefix, e.g. serviceAccount:<email>")
 )
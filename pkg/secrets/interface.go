@@ -11,9 +11,44 @@ import (
 // enable mocking.
 type SecretManagerClient interface {
 	GetLatestVersion(ctx context.Context, name string) ([]byte, error)
+	GetVersion(ctx context.Context, name, version string) ([]byte, error)
+	AccessVersion(ctx context.Context, name, version string) (payload []byte, resolved string, err error)
+	ListVersions(ctx context.Context, name string) ([]*secretmanagerpb.SecretVersion, error)
+	GetVersionMetadata(ctx context.Context, name, version string) (*secretmanagerpb.SecretVersion, error)
+	DestroyVersion(ctx context.Context, name, version string) error
 	CreateSecret(ctx context.Context, name string) error
-	AddSecretVersion(ctx context.Context, name string, payload []byte) error
+	AddSecretVersion(ctx context.Context, name string, payload []byte) (version string, err error)
 	DeleteSecret(ctx context.Context, name string) error
+	ListSecrets(ctx context.Context) ([]string, error)
+	Check(ctx context.Context) error
+	Reconnect() error
+	Close() error
+}
+
+// secretLister is implemented by gRPC clients that can page through the secrets in a
+// project. It is optional because secrets cannot be enumerated any other way, so
+// clients that do not implement it cannot list secrets.
+type secretLister interface {
+	ListSecrets(context.Context, *secretmanagerpb.ListSecretsRequest, ...gax.CallOption) SecretIterator
+}
+
+// SecretIterator pages through secrets, it is implemented by the google secret manager
+// iterator and returns iterator.Done when there are no more secrets.
+type SecretIterator interface {
+	Next() (*secretmanagerpb.Secret, error)
+}
+
+// versionLister is implemented by gRPC clients that can page through the versions of
+// a secret. It is optional so that clients which only implement GRPCSecretClient can
+// still list versions, albeit one request per version.
+type versionLister interface {
+	ListSecretVersions(context.Context, *secretmanagerpb.ListSecretVersionsRequest, ...gax.CallOption) VersionIterator
+}
+
+// VersionIterator pages through secret versions, it is implemented by the google secret
+// manager iterator and returns iterator.Done when there are no more versions.
+type VersionIterator interface {
+	Next() (*secretmanagerpb.SecretVersion, error)
 }
 
 // gRPCSecretClient describes a lower level interface in order to mock the google secret
@@ -23,5 +58,6 @@ type GRPCSecretClient interface {
 	GetSecretVersion(context.Context, *secretmanagerpb.GetSecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
 	AddSecretVersion(context.Context, *secretmanagerpb.AddSecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
 	AccessSecretVersion(context.Context, *secretmanagerpb.AccessSecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error)
+	DestroySecretVersion(context.Context, *secretmanagerpb.DestroySecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
 	DeleteSecret(context.Context, *secretmanagerpb.DeleteSecretRequest, ...gax.CallOption) error
 }
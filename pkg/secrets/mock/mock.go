@@ -6,6 +6,7 @@ import (
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/googleapis/gax-go"
 	"github.com/trisacrypto/courier/pkg/secrets"
+	"google.golang.org/api/iterator"
 )
 
 // New returns a new secrets client mock. The On* functions can be used to configure
@@ -30,17 +31,29 @@ func (s *SecretManager) Reset() {
 	s.OnAccessSecretVersion = func(context.Context, *secretmanagerpb.AccessSecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
 		return nil, ErrNotConfigured
 	}
+	s.OnDestroySecretVersion = func(context.Context, *secretmanagerpb.DestroySecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		return nil, ErrNotConfigured
+	}
 	s.OnDeleteSecret = func(context.Context, *secretmanagerpb.DeleteSecretRequest, ...gax.CallOption) error {
 		return ErrNotConfigured
 	}
+	s.OnListSecrets = func(context.Context, *secretmanagerpb.ListSecretsRequest, ...gax.CallOption) secrets.SecretIterator {
+		return &Secrets{Err: ErrNotConfigured}
+	}
+	s.OnListSecretVersions = func(context.Context, *secretmanagerpb.ListSecretVersionsRequest, ...gax.CallOption) secrets.VersionIterator {
+		return &Versions{Err: ErrNotConfigured}
+	}
 }
 
 type SecretManager struct {
-	OnCreateSecret        func(context.Context, *secretmanagerpb.CreateSecretRequest, ...gax.CallOption) (*secretmanagerpb.Secret, error)
-	OnGetSecretVersion    func(context.Context, *secretmanagerpb.GetSecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
-	OnAddSecretVersion    func(context.Context, *secretmanagerpb.AddSecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
-	OnAccessSecretVersion func(context.Context, *secretmanagerpb.AccessSecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error)
-	OnDeleteSecret        func(context.Context, *secretmanagerpb.DeleteSecretRequest, ...gax.CallOption) error
+	OnCreateSecret         func(context.Context, *secretmanagerpb.CreateSecretRequest, ...gax.CallOption) (*secretmanagerpb.Secret, error)
+	OnGetSecretVersion     func(context.Context, *secretmanagerpb.GetSecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
+	OnAddSecretVersion     func(context.Context, *secretmanagerpb.AddSecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
+	OnAccessSecretVersion  func(context.Context, *secretmanagerpb.AccessSecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error)
+	OnDestroySecretVersion func(context.Context, *secretmanagerpb.DestroySecretVersionRequest, ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
+	OnDeleteSecret         func(context.Context, *secretmanagerpb.DeleteSecretRequest, ...gax.CallOption) error
+	OnListSecrets          func(context.Context, *secretmanagerpb.ListSecretsRequest, ...gax.CallOption) secrets.SecretIterator
+	OnListSecretVersions   func(context.Context, *secretmanagerpb.ListSecretVersionsRequest, ...gax.CallOption) secrets.VersionIterator
 }
 
 var _ secrets.GRPCSecretClient = &SecretManager{}
@@ -61,6 +74,56 @@ func (s *SecretManager) AccessSecretVersion(ctx context.Context, req *secretmana
 	return s.OnAccessSecretVersion(ctx, req, opts...)
 }
 
+func (s *SecretManager) DestroySecretVersion(ctx context.Context, req *secretmanagerpb.DestroySecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	return s.OnDestroySecretVersion(ctx, req, opts...)
+}
+
 func (s *SecretManager) DeleteSecret(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
 	return s.OnDeleteSecret(ctx, req, opts...)
 }
+
+func (s *SecretManager) ListSecrets(ctx context.Context, req *secretmanagerpb.ListSecretsRequest, opts ...gax.CallOption) secrets.SecretIterator {
+	return s.OnListSecrets(ctx, req, opts...)
+}
+
+func (s *SecretManager) ListSecretVersions(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest, opts ...gax.CallOption) secrets.VersionIterator {
+	return s.OnListSecretVersions(ctx, req, opts...)
+}
+
+// Secrets is a secret iterator that returns each of its secrets in order and then
+// returns Err if it is set or iterator.Done if it is not.
+type Secrets struct {
+	Secrets []*secretmanagerpb.Secret
+	Err     error
+}
+
+func (i *Secrets) Next() (secret *secretmanagerpb.Secret, err error) {
+	if len(i.Secrets) == 0 {
+		if i.Err != nil {
+			return nil, i.Err
+		}
+		return nil, iterator.Done
+	}
+
+	secret, i.Secrets = i.Secrets[0], i.Secrets[1:]
+	return secret, nil
+}
+
+// Versions is a secret version iterator that returns each of its versions in order
+// and then returns Err if it is set or iterator.Done if it is not.
+type Versions struct {
+	Versions []*secretmanagerpb.SecretVersion
+	Err      error
+}
+
+func (v *Versions) Next() (version *secretmanagerpb.SecretVersion, err error) {
+	if len(v.Versions) == 0 {
+		if v.Err != nil {
+			return nil, v.Err
+		}
+		return nil, iterator.Done
+	}
+
+	version, v.Versions = v.Versions[0], v.Versions[1:]
+	return version, nil
+}
@@ -3,6 +3,15 @@ package secrets
 // SecretsOption allows us to configure the secrets client when it is created.
 type SecretsOption func(s *GoogleSecrets) error
 
+// WithDialer specifies how the gRPC client is created, both when the client is first
+// constructed and when it is recreated on reconnect.
+func WithDialer(dial Dialer) SecretsOption {
+	return func(s *GoogleSecrets) error {
+		s.dial = dial
+		return nil
+	}
+}
+
 func WithGRPCClient(client GRPCSecretClient) SecretsOption {
 	return func(s *GoogleSecrets) error {
 		s.client = client
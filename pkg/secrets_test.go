@@ -0,0 +1,148 @@
+package courier_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+func (s *courierTestSuite) TestStoreSecret() {
+	require := s.Require()
+
+	s.Run("HappyPath", func() {
+		req := &api.StoreSecretRequest{
+			Name:       "signing-key_1",
+			Base64Data: base64.StdEncoding.EncodeToString([]byte("supersecretsquirrel")),
+		}
+		s.store.OnUpdateSecret = func(ctx context.Context, name string, secret []byte) error {
+			require.Equal(req.Name, name, "wrong secret name passed to store")
+			require.Equal([]byte("supersecretsquirrel"), secret, "wrong secret data passed to store")
+			return nil
+		}
+		defer s.store.Reset()
+
+		err := s.client.StoreSecret(context.Background(), req)
+		require.NoError(err, "could not store secret")
+	})
+
+	s.Run("MissingData", func() {
+		req := &api.StoreSecretRequest{
+			Name: "signing-key",
+		}
+		err := s.client.StoreSecret(context.Background(), req)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for missing secret data")
+	})
+
+	s.Run("InvalidBase64", func() {
+		req := &api.StoreSecretRequest{
+			Name:       "signing-key",
+			Base64Data: "not base64!",
+		}
+		err := s.client.StoreSecret(context.Background(), req)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for invalid base64 data")
+	})
+
+	s.Run("InvalidName", func() {
+		for _, name := range []string{"signing.key", "signing@2", "signing%20key", strings.Repeat("a", 201)} {
+			req := &api.StoreSecretRequest{
+				Name:       name,
+				Base64Data: base64.StdEncoding.EncodeToString([]byte("supersecretsquirrel")),
+			}
+			err := s.client.StoreSecret(context.Background(), req)
+			s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for invalid secret name %q", name)
+		}
+	})
+
+	s.Run("StoreError", func() {
+		s.store.OnUpdateSecret = func(ctx context.Context, name string, secret []byte) error {
+			return store.ErrPayloadTooLarge
+		}
+		defer s.store.Reset()
+
+		req := &api.StoreSecretRequest{
+			Name:       "signing-key",
+			Base64Data: base64.StdEncoding.EncodeToString([]byte("supersecretsquirrel")),
+		}
+		err := s.client.StoreSecret(context.Background(), req)
+		s.CheckHTTPStatus(err, http.StatusRequestEntityTooLarge, "wrong error code for large secret")
+	})
+}
+
+func (s *courierTestSuite) TestRetrieveSecret() {
+	require := s.Require()
+
+	s.Run("HappyPath", func() {
+		s.store.OnGetSecret = func(ctx context.Context, name string) ([]byte, error) {
+			require.Equal("signing-key", name, "wrong secret name passed to store")
+			return []byte("supersecretsquirrel"), nil
+		}
+		defer s.store.Reset()
+
+		rep, err := s.client.RetrieveSecret(context.Background(), "signing-key")
+		require.NoError(err, "could not retrieve secret")
+		require.Equal("signing-key", rep.Name)
+		require.Equal(base64.StdEncoding.EncodeToString([]byte("supersecretsquirrel")), rep.Base64Data)
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnGetSecret = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.RetrieveSecret(context.Background(), "signing-key")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing secret")
+	})
+
+	s.Run("InvalidName", func() {
+		_, err := s.client.RetrieveSecret(context.Background(), "signing.key")
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for invalid secret name")
+	})
+
+	s.Run("RetrievalDisabled", func() {
+		srv, client, db := s.startServer(testConfig())
+		defer srv.Shutdown()
+
+		db.OnGetSecret = func(ctx context.Context, name string) ([]byte, error) {
+			require.Fail("store should not be called when secret retrieval is disabled")
+			return nil, nil
+		}
+
+		_, err := client.RetrieveSecret(context.Background(), "signing-key")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "forbidden requests should be indistinguishable from missing secrets")
+	})
+}
+
+func (s *courierTestSuite) TestDeleteSecret() {
+	require := s.Require()
+
+	s.Run("HappyPath", func() {
+		s.store.OnDeleteSecret = func(ctx context.Context, name string) error {
+			require.Equal("signing-key", name, "wrong secret name passed to store")
+			return nil
+		}
+		defer s.store.Reset()
+
+		err := s.client.DeleteSecret(context.Background(), "signing-key")
+		require.NoError(err, "could not delete secret")
+	})
+
+	s.Run("NotFound", func() {
+		s.store.OnDeleteSecret = func(ctx context.Context, name string) error {
+			return store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		err := s.client.DeleteSecret(context.Background(), "signing-key")
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for missing secret")
+	})
+
+	s.Run("InvalidName", func() {
+		err := s.client.DeleteSecret(context.Background(), "signing.key")
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "wrong error code for invalid secret name")
+	})
+}
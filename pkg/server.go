@@ -2,24 +2,41 @@ package courier
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/auth"
 	"github.com/trisacrypto/courier/pkg/config"
 	"github.com/trisacrypto/courier/pkg/logger"
+	"github.com/trisacrypto/courier/pkg/middleware"
 	"github.com/trisacrypto/courier/pkg/o11y"
+	"github.com/trisacrypto/courier/pkg/policy"
+	"github.com/trisacrypto/courier/pkg/proxyproto"
 	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/codec"
+	"github.com/trisacrypto/courier/pkg/store/failover"
 	"github.com/trisacrypto/courier/pkg/store/gcloud"
+	"github.com/trisacrypto/courier/pkg/store/gcs"
+	"github.com/trisacrypto/courier/pkg/store/kube"
 	"github.com/trisacrypto/courier/pkg/store/local"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+	"github.com/trisacrypto/courier/pkg/store/mirror"
+	"github.com/trisacrypto/courier/pkg/store/postgres"
+	"github.com/trisacrypto/courier/pkg/store/s3"
+	"github.com/trisacrypto/courier/pkg/store/ssm"
 )
 
 func init() {
@@ -52,23 +69,66 @@ func New(conf config.Config) (s *Server, err error) {
 
 	// Create the server object
 	s = &Server{
-		conf:  conf,
-		echan: make(chan error, 1),
+		conf:     conf,
+		echan:    make(chan error, 1),
+		arrivals: newArrivals(),
+		events:   newEvents(),
+		activity: newActivity(),
 	}
 
-	// Open the store
-	if !s.conf.Maintenance {
-		switch {
-		case s.conf.LocalStorage.Enabled:
-			if s.store, err = local.Open(s.conf.LocalStorage); err != nil {
-				return nil, err
-			}
-		case s.conf.GCPSecretManager.Enabled:
-			if s.store, err = gcloud.Open(s.conf.GCPSecretManager); err != nil {
+	if conf.TraceRequests > 0 {
+		s.traces = newTraces(conf.TraceRequests)
+	}
+
+	if conf.Disclosure.ProbeThreshold > 0 {
+		s.probes = newProbes(conf.Disclosure.ProbeThreshold, conf.Disclosure.ProbeWindow)
+	}
+
+	if conf.Decryption.MaxConcurrent > 0 || conf.Decryption.Rate > 0 {
+		s.throttle = newThrottle(conf.Decryption)
+	}
+
+	if conf.Cache.TTL > 0 {
+		s.cache = newResponses(conf.Cache.TTL, conf.Cache.MaxEntries)
+		if conf.Cache.Bus != "" {
+			if err = s.connectPeers(conf.Cache); err != nil {
 				return nil, err
 			}
-		default:
-			return nil, errors.New("no storage backend configured")
+		}
+	}
+
+	// Create the authenticator chains of the route groups
+	if len(conf.Auth.Delivery) > 0 {
+		if s.delivery, err = auth.New(conf.Auth.Delivery, conf); err != nil {
+			return nil, err
+		}
+	}
+
+	admin := conf.Auth.Admin
+	if len(admin) == 0 {
+		admin = []string{auth.Token}
+	}
+
+	if s.admin, err = auth.New(admin, conf); err != nil {
+		return nil, err
+	}
+
+	// Load the policy that authorizes authenticated requests
+	if conf.Auth.Policy != "" {
+		if s.policy, err = policy.Load(conf.Auth.Policy); err != nil {
+			return nil, err
+		}
+	}
+
+	// Open the store
+	if !s.conf.Maintenance {
+		if s.store, err = OpenStore(s.conf); err != nil {
+			return nil, err
+		}
+
+		// Release waiting requests when resources are stored outside of courier
+		if notifier, ok := store.Notifier(s.store); ok {
+			notifier.OnChange(s.storeChanged)
 		}
 	}
 
@@ -82,6 +142,10 @@ func New(conf config.Config) (s *Server, err error) {
 	s.router.UseRawPath = false
 	s.router.UnescapePathValues = true
 
+	if err = s.setupClientIP(); err != nil {
+		return nil, err
+	}
+
 	if err = s.setupRoutes(); err != nil {
 		return nil, err
 	}
@@ -95,6 +159,11 @@ func New(conf config.Config) (s *Server, err error) {
 		WriteTimeout:      20 * time.Second,
 		IdleTimeout:       90 * time.Second,
 	}
+	s.srv.RegisterOnShutdown(s.arrivals.close)
+	s.srv.RegisterOnShutdown(s.events.close)
+	if s.peers != nil {
+		s.srv.RegisterOnShutdown(s.peers.close)
+	}
 
 	// Use TLS if configured
 	if !conf.MTLS.Insecure {
@@ -106,25 +175,142 @@ func New(conf config.Config) (s *Server, err error) {
 	return s, nil
 }
 
+// OpenStore opens the storage backend enabled in the configuration, wrapped by
+// wrapBackend. If storage is mirrored or fails over, every enabled backend is opened
+// and wrapped separately before it is replicated.
+func OpenStore(conf config.Config) (db store.Store, err error) {
+	var pipeline *codec.Pipeline
+	if pipeline, err = conf.Codec.Serializer(); err != nil {
+		return nil, err
+	}
+
+	if conf.MirrorStorage || conf.Failover.Enabled {
+		var stores []store.Store
+		if stores, err = openBackends(conf, pipeline); err != nil {
+			return nil, err
+		}
+
+		if conf.MirrorStorage {
+			return mirror.New(stores...), nil
+		}
+		return failover.New(conf.Failover, stores[0], stores[1]), nil
+	}
+
+	backend := conf.StorageBackend()
+	if db, err = openBackend(conf, backend); err != nil {
+		return nil, err
+	}
+	return wrapBackend(db, backend, pipeline), nil
+}
+
+// openBackends opens and wraps every enabled storage backend in order, closing the
+// backends that were opened if any backend cannot be opened.
+func openBackends(conf config.Config, pipeline *codec.Pipeline) (stores []store.Store, err error) {
+	for _, backend := range conf.StorageBackends() {
+		var db store.Store
+		if db, err = openBackend(conf, backend); err != nil {
+			for _, opened := range stores {
+				opened.Close()
+			}
+			return nil, err
+		}
+		stores = append(stores, wrapBackend(db, backend, pipeline))
+	}
+	return stores, nil
+}
+
+// OpenBackend validates and opens the named storage backend outside of the server, e.g.
+// to migrate resources between backends, wrapped like the backends of the server.
+// Resources are encoded and decoded with the configured codec, so a migration copies
+// the decoded resources.
+func OpenBackend(conf config.Config, backend string) (db store.Store, err error) {
+	if err = conf.ValidateBackend(backend); err != nil {
+		return nil, err
+	}
+
+	var pipeline *codec.Pipeline
+	if pipeline, err = conf.Codec.Serializer(); err != nil {
+		return nil, err
+	}
+
+	if db, err = openBackend(conf, backend); err != nil {
+		return nil, err
+	}
+	return wrapBackend(db, backend, pipeline), nil
+}
+
+// wrapBackend encodes the payloads of the storage backend with the codec pipeline, if
+// one is configured, and instruments it to record metrics and slow operations. Every
+// backend is wrapped in the same order so that the latency and failures of the codec,
+// e.g. payloads that cannot be decrypted, are recorded for the backend in every
+// configuration.
+func wrapBackend(db store.Store, backend string, pipeline *codec.Pipeline) store.Store {
+	if pipeline != nil {
+		db = store.Encoded(db, pipeline)
+	}
+	return store.Instrumented(db, backend)
+}
+
+// openBackend opens the named storage backend.
+func openBackend(conf config.Config, backend string) (store.Store, error) {
+	switch backend {
+	case "local":
+		return local.Open(conf.LocalStorage)
+	case "memory":
+		return memory.Open(conf.InMemoryStorage)
+	case "gcp_secret_manager":
+		return gcloud.Open(conf.GCPSecretManager, gcloud.WithReplication(conf.GCPReplica))
+	case "kubernetes":
+		return kube.Open(conf.Kubernetes)
+	case "postgres":
+		return postgres.Open(conf.Postgres)
+	case "s3":
+		return s3.Open(conf.S3)
+	case "gcs":
+		return gcs.Open(conf.GCS)
+	case "ssm":
+		return ssm.Open(conf.SSM)
+	default:
+		return nil, errors.New("no storage backend configured")
+	}
+}
+
 // Server defines the courier service and its webhook handlers.
 type Server struct {
 	sync.RWMutex
-	conf    config.Config // Primary source of truth for server configuration
-	srv     *http.Server  // The HTTP server for handling requests
-	router  *gin.Engine   // The gin router for muxing requests to handlers
-	store   store.Store   // Manages certificate and password storage
-	healthy bool          // Indicates that the service is online and healthy
-	ready   bool          // Indicates that the service is ready to accept requests
-	started time.Time     // The timestamp the server was started (for uptime)
-	url     string        // The endpoint that the server is hosted on
-	echan   chan error    // Sending errors on this channel stops the server
+	conf      config.Config      // Primary source of truth for server configuration
+	srv       *http.Server       // The HTTP server for handling requests
+	router    *gin.Engine        // The gin router for muxing requests to handlers
+	store     store.Store        // Manages certificate and password storage
+	healthy   bool               // Indicates that the service is online and healthy
+	ready     bool               // Indicates that the service is ready to accept requests
+	startup   bool               // Indicates that the startup checks have completed
+	storeErr  error              // The most recent error from the store health probe
+	delivered time.Time          // The timestamp of the last certificate delivery
+	arrivals  *arrivals          // Notifies requests waiting for a certificate to arrive
+	events    *events            // Streams delivery events to subscribed clients
+	activity  *activity          // Counts resources stored and failures since start
+	traces    *traces            // Recent requests kept for debugging, nil if disabled
+	probes    *probes            // Not found responses counted per client, nil if disabled
+	throttle  *throttle          // Limits certificate uploads, nil if disabled
+	cache     *responses         // Caches status and metadata responses, nil if disabled
+	peers     *peers             // Shares cache invalidations with replicas, nil if disabled
+	delivery  auth.Chain         // Authenticates certificate and secret requests, nil if disabled
+	admin     auth.Chain         // Authenticates admin api requests
+	policy    *policy.Engine     // Authorizes certificate, secret, and admin requests, nil if disabled
+	simulate  sync.Mutex         // Held while a delivery simulation is running
+	verifier  verifier           // Verifies stored certificates and keeps the last report
+	started   time.Time          // The timestamp the server was started (for uptime)
+	urls      []string           // The endpoints that the server is hosted on
+	echan     chan error         // Sending errors on this channel stops the server
+	stop      context.CancelFunc // Stops background routines such as the store probe
 }
 
 // Serve API requests.
 func (s *Server) Serve() (err error) {
-	// Catch OS signals for graceful shutdowns
+	// Catch OS signals for graceful shutdowns, launchd stops daemons with SIGTERM
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-quit
 		s.echan <- s.Shutdown()
@@ -133,25 +319,76 @@ func (s *Server) Serve() (err error) {
 	// Set healthy status
 	s.SetHealthy(true)
 
-	// Create the listen socket
-	var sock net.Listener
-	if sock, err = net.Listen("tcp", s.conf.BindAddr); err != nil {
+	// Create the listen sockets, all of which share the same handler
+	var listeners []config.Listener
+	if listeners, err = s.conf.GetListeners(); err != nil {
 		return err
 	}
 
-	// Set the URL from the socket
-	s.SetURL(sock)
+	socks := make([]net.Listener, 0, len(listeners))
+	for _, listener := range listeners {
+		var sock net.Listener
+		if sock, err = s.listen(listener); err != nil {
+			for _, sock := range socks {
+				sock.Close()
+			}
+			return err
+		}
+		socks = append(socks, sock)
+	}
 	s.started = time.Now()
 
 	// Serve the API
-	go func() {
-		if err = s.srv.Serve(sock); err != nil && err != http.ErrServerClosed {
-			s.echan <- err
-		}
-	}()
+	for _, sock := range socks {
+		go func(sock net.Listener) {
+			if err := s.srv.Serve(sock); err != nil && err != http.ErrServerClosed {
+				s.echan <- err
+			}
+		}(sock)
+	}
 
 	s.SetReady(true)
-	log.Info().Str("listen", s.url).Str("version", Version()).Msg("courier server started")
+	s.logConfig()
+
+	// Background routines are stopped when the server is shut down
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Lock()
+	s.stop = cancel
+	s.Unlock()
+
+	// The server has started once the store is reachable if the store can be checked
+	checker, ok := s.store.(store.HealthChecker)
+	if ok && !s.conf.Maintenance {
+		go s.checkStartup(ctx, checker)
+	} else {
+		s.SetStarted(true)
+	}
+
+	// Periodically check the connection to the store if the store supports it
+	if ok && !s.conf.Maintenance && s.conf.StoreProbeInterval > 0 {
+		go s.probeStore(ctx, checker)
+	}
+
+	// Rename the resources stored under legacy names if the migration is enabled
+	if s.conf.MigrateLegacyNames && !s.conf.Maintenance {
+		go s.migrateLegacyNames(ctx)
+	}
+
+	// Periodically delete expired resources if a retention policy is configured
+	if s.conf.Retention.Enabled() && !s.conf.Maintenance {
+		go s.collectGarbage(ctx)
+	}
+
+	// Periodically verify the stored certificates if a verification interval is set
+	if s.conf.Verification.Enabled() && !s.conf.Maintenance {
+		go s.verifyCertificates(ctx)
+	}
+
+	// Reload the authorization policy when the server receives a SIGHUP
+	if s.policy != nil {
+		go s.reloadPolicy(ctx)
+	}
+	log.Info().Strs("listen", s.URLs()).Str("version", Version()).Msg("courier server started")
 
 	// Wait for shutdown or an error
 	if err = <-s.echan; err != nil {
@@ -160,6 +397,12 @@ func (s *Server) Serve() (err error) {
 	return nil
 }
 
+// Stop shuts down the server gracefully and returns from Serve, e.g. when the service
+// manager stops the service.
+func (s *Server) Stop() {
+	s.echan <- s.Shutdown()
+}
+
 // Shutdown the server gracefully.
 func (s *Server) Shutdown() (err error) {
 	log.Info().Msg("gracefully shutting down courier server")
@@ -167,6 +410,12 @@ func (s *Server) Shutdown() (err error) {
 	s.SetHealthy(false)
 	s.srv.SetKeepAlivesEnabled(false)
 
+	s.RLock()
+	if s.stop != nil {
+		s.stop()
+	}
+	s.RUnlock()
+
 	// Ensure shutdown happens within 30 seconds
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -185,6 +434,26 @@ func (s *Server) Shutdown() (err error) {
 	return err
 }
 
+// Configure which request headers are trusted to report the client ip address. When
+// the PROXY protocol is enabled the client address is read from the connection, so
+// client ip headers are ignored unless trusted proxies are explicitly configured.
+func (s *Server) setupClientIP() (err error) {
+	switch platform := s.conf.Proxy.TrustedPlatform; strings.ToLower(platform) {
+	case "":
+	case "google":
+		s.router.TrustedPlatform = gin.PlatformGoogleAppEngine
+	case "cloudflare":
+		s.router.TrustedPlatform = gin.PlatformCloudflare
+	default:
+		s.router.TrustedPlatform = platform
+	}
+
+	if len(s.conf.Proxy.TrustedProxies) == 0 && !s.conf.Proxy.Protocol {
+		return nil
+	}
+	return s.router.SetTrustedProxies(s.conf.Proxy.TrustedProxies)
+}
+
 // Setup the routes for the courier service.
 func (s *Server) setupRoutes() (err error) {
 	// Kubernetes probe endpoints -- add routes before middleware to ensure these
@@ -192,33 +461,35 @@ func (s *Server) setupRoutes() (err error) {
 	s.router.GET("/healthz", s.Healthz)
 	s.router.GET("/livez", s.Healthz)
 	s.router.GET("/readyz", s.Readyz)
-
-	// Add prometheus metrics collector endpoint before middleware is added
-	s.router.GET("/metrics", o11y.Prometheus())
+	s.router.GET("/startupz", s.Startupz)
 
 	middlewares := []gin.HandlerFunc{
+		middleware.Context(),
 		logger.GinLogger("courier", Version()),
-		o11y.Metrics(),
-		gin.Recovery(),
-		s.Available(),
 	}
 
+	// Add prometheus metrics collector endpoint before middleware is added
+	if s.conf.Metrics {
+		s.router.GET("/metrics", o11y.Prometheus())
+		middlewares = append(middlewares, o11y.Metrics())
+	}
+
+	// Trace requests before recovery so that the responses to panics are recorded
+	if s.traces != nil {
+		middlewares = append(middlewares, s.Trace())
+	}
+	middlewares = append(middlewares, gin.Recovery(), s.Available())
+
 	// Add the middlewares to the router
 	s.router.Use(middlewares...)
 
+	// Unversioned routes for API version negotiation
+	s.router.GET("/versions", s.Versions)
+
 	// API routes
-	v1 := s.router.Group("/v1")
-	{
-		// Status route
-		v1.GET("/status", s.Status)
-
-		// Certificate routes
-		certs := v1.Group("/certs")
-		{
-			certs.POST("/:id", s.StoreCertificate)
-			certs.POST("/:id/pkcs12password", s.StoreCertificatePassword)
-		}
-	}
+	s.setupVersions(map[string]func(*gin.RouterGroup){
+		api.Version: s.setupV1Routes,
+	})
 
 	// Not found and method not allowed routes
 	s.router.NoRoute(api.NotFound)
@@ -226,15 +497,184 @@ func (s *Server) setupRoutes() (err error) {
 	return nil
 }
 
-// Set the URL of the server from the socket
-func (s *Server) SetURL(sock net.Listener) {
-	s.Lock()
-	sockAddr := sock.Addr().String()
-	if s.conf.MTLS.Insecure {
-		s.url = "http://" + sockAddr
-	} else {
-		s.url = "https://" + sockAddr
+// Log a summary of the effective configuration and warn about risky combinations so
+// that misconfigurations can be detected from the server logs.
+func (s *Server) logConfig() {
+	tlsMode, authMode := "insecure", "none"
+	if !s.conf.MTLS.Insecure {
+		tlsMode, authMode = "tls", "mtls"
 	}
+
+	if len(s.conf.Auth.Delivery) > 0 {
+		authMode = strings.Join(s.conf.Auth.Delivery, ",")
+	}
+
+	// Listeners are validated when the server starts so the error has been reported
+	listeners, _ := s.conf.GetListeners()
+	addrs := make([]string, 0, len(listeners))
+	for _, listener := range listeners {
+		addrs = append(addrs, listener.URL(listener.Addr))
+	}
+
+	log.Info().
+		Strs("listeners", addrs).
+		Str("mode", s.conf.Mode).
+		Str("log_level", s.conf.LogLevel.String()).
+		Bool("maintenance", s.conf.Maintenance).
+		Str("storage", s.conf.StorageBackend()).
+		Str("tls", tlsMode).
+		Str("auth", authMode).
+		Bool("proxy_protocol", s.conf.Proxy.Protocol).
+		Bool("metrics", s.conf.Metrics).
+		Msg("courier configuration")
+
+	for _, warning := range s.conf.Warnings() {
+		log.Warn().Msg(warning)
+	}
+}
+
+// Setup the routes for version 1 of the courier API.
+func (s *Server) setupV1Routes(v1 *gin.RouterGroup) {
+	// Status route
+	v1.GET("/status", s.cacheable(s.Status)...)
+	v1.GET("/stats", s.Stats)
+	v1.GET("/version", s.cacheable(s.BuildInfo)...)
+	v1.GET("/openapi.json", s.OpenAPI)
+
+	// Admin routes are authenticated by the admin chain, which defaults to the token
+	adminMiddleware := []gin.HandlerFunc{s.AdminAuth()}
+	if s.policy != nil {
+		adminMiddleware = append(adminMiddleware, s.Authorize("", "admin api is not enabled"))
+	}
+
+	admin := v1.Group("/admin", adminMiddleware...)
+	{
+		admin.GET("/config", s.AdminConfig)
+		admin.GET("/snapshot", s.AdminSnapshot)
+		admin.POST("/restore", s.AdminRestore)
+		admin.GET("/verify", s.AdminVerification)
+		admin.POST("/verify", s.AdminVerify)
+		admin.GET("/debug/requests", s.TraceDump)
+		admin.POST("/simulate", s.Simulate)
+	}
+
+	// Delivery event streams are authenticated by the delivery chain; they disclose the
+	// events of many ids so the policy is evaluated for the id of each event rather than
+	// once for the stream
+	streamMiddleware := []gin.HandlerFunc{}
+	if s.delivery != nil {
+		streamMiddleware = append(streamMiddleware, auth.Middleware(s.delivery, "courier"))
+	}
+
+	streams := v1.Group("", streamMiddleware...)
+	{
+		streams.GET("/events", s.Events)
+		streams.GET("/ws", s.Notifications)
+	}
+
+	// Resource routes are authenticated by the delivery chain and audited for id
+	// enumeration if enabled
+	certMiddleware := []gin.HandlerFunc{validName("id"), s.RecordFailures()}
+	secretMiddleware := []gin.HandlerFunc{validName("name"), s.RecordFailures()}
+	if s.delivery != nil {
+		certMiddleware = append([]gin.HandlerFunc{auth.Middleware(s.delivery, "courier")}, certMiddleware...)
+		secretMiddleware = append([]gin.HandlerFunc{auth.Middleware(s.delivery, "courier")}, secretMiddleware...)
+	}
+	if s.probes != nil {
+		certMiddleware = append(certMiddleware, s.AuditProbes("id"))
+		secretMiddleware = append(secretMiddleware, s.AuditProbes("name"))
+	}
+
+	// Certificate ids are resolved from their aliases once the request is authenticated
+	certMiddleware = append(certMiddleware, reservedID("id"), s.ResolveAlias())
+	secretMiddleware = append(secretMiddleware, reservedName("name"))
+
+	// Requests are authorized by the policy once the certificate id has been resolved
+	if s.policy != nil {
+		certMiddleware = append(certMiddleware, s.Authorize("id", "certificate not found"))
+		secretMiddleware = append(secretMiddleware, s.Authorize("name", "secret not found"))
+	}
+
+	// Certificate uploads are throttled separately from the other routes if enabled
+	storeCertificate := []gin.HandlerFunc{s.StoreCertificate}
+	if s.throttle != nil {
+		storeCertificate = append([]gin.HandlerFunc{s.Throttle()}, storeCertificate...)
+	}
+
+	// Certificate routes
+	certs := v1.Group("/certs", certMiddleware...)
+	{
+		certs.GET("/:id", s.RetrieveCertificate)
+		certs.HEAD("/:id", s.HeadCertificate)
+		certs.POST("/:id", storeCertificate...)
+		certs.DELETE("/:id", s.DeleteCertificate)
+		certs.GET("/:id/details", s.cacheable(s.CertificateDetails)...)
+		certs.GET("/:id/metadata", s.cacheable(s.CertificateMetadata)...)
+		certs.GET("/:id/public", s.cacheable(s.PublicCertificate)...)
+		certs.POST("/:id/public/url", s.PublicCertificateURL)
+		certs.GET("/:id/download", s.DownloadCertificate)
+		certs.GET("/:id/wait", s.WaitForCertificate)
+		certs.GET("/:id/versions", s.cacheable(s.ListCertificateVersions)...)
+		certs.GET("/:id/versions/:version", s.RetrieveCertificateVersion)
+		certs.POST("/:id/versions/:version/restore", s.RestoreCertificateVersion)
+		certs.GET("/:id/pkcs12password", s.RetrieveCertificatePassword)
+		certs.HEAD("/:id/pkcs12password", s.HeadCertificatePassword)
+		certs.POST("/:id/pkcs12password", s.StoreCertificatePassword)
+		certs.DELETE("/:id/pkcs12password", s.DeleteCertificatePassword)
+		certs.GET("/:id/aliases", s.ListAliases)
+		certs.PUT("/:id/aliases/:alias", validName("alias"), reservedID("alias"), s.AddAlias)
+		certs.DELETE("/:id/aliases/:alias", validName("alias"), s.DeleteAlias)
+	}
+
+	// Generic secret routes
+	secrets := v1.Group("/secrets", secretMiddleware...)
+	{
+		secrets.GET("/:name", s.RetrieveSecret)
+		secrets.PUT("/:name", s.StoreSecret)
+		secrets.DELETE("/:name", s.DeleteSecret)
+	}
+}
+
+// Resource names are restricted to characters that are valid in both local file names
+// and secret manager secret ids. This also prevents names from colliding with the
+// version and metadata files that the local store keeps alongside each resource.
+var resourceName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,200}$`)
+
+// validName returns middleware that rejects requests whose URL parameter is not a
+// valid resource name before they reach the store.
+func validName(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !resourceName.MatchString(c.Param(param)) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, api.ErrorResponse("invalid "+param+" in request"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// Create the listen socket for the listener and record its URL. The client address is
+// read from the header sent by a TCP load balancer before TLS is terminated so that
+// mTLS can be used behind the load balancer.
+func (s *Server) listen(listener config.Listener) (sock net.Listener, err error) {
+	if sock, err = net.Listen("tcp", listener.Addr); err != nil {
+		return nil, err
+	}
+	s.AddURL(listener.URL(sock.Addr().String()))
+
+	if s.conf.Proxy.Protocol {
+		sock = proxyproto.NewListener(sock, s.conf.Proxy.HeaderTimeout)
+	}
+
+	if listener.TLS {
+		sock = tls.NewListener(sock, s.srv.TLSConfig)
+	}
+	return sock, nil
+}
+
+// AddURL records a URL that the server is hosted on.
+func (s *Server) AddURL(url string) {
+	s.Lock()
+	s.urls = append(s.urls, url)
 	s.Unlock()
 }
 
@@ -242,11 +682,26 @@ func (s *Server) SetURL(sock net.Listener) {
 // Helpers for testing
 //===========================================================================
 
-// URL returns the URL of the server.
+// URL returns the URL of the first listener of the server.
 func (s *Server) URL() string {
 	s.RLock()
 	defer s.RUnlock()
-	return s.url
+	if len(s.urls) == 0 {
+		return ""
+	}
+	return s.urls[0]
+}
+
+// URLs returns the URLs of every listener of the server.
+func (s *Server) URLs() []string {
+	s.RLock()
+	defer s.RUnlock()
+	return append([]string(nil), s.urls...)
+}
+
+// Routes returns the method and path of every route registered with the router.
+func (s *Server) Routes() gin.RoutesInfo {
+	return s.router.Routes()
 }
 
 // SetStore directly sets the store for the server.
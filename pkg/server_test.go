@@ -1,14 +1,25 @@
 package courier_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/suite"
 	courier "github.com/trisacrypto/courier/pkg"
 	"github.com/trisacrypto/courier/pkg/api/v1"
 	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/o11y"
 	"github.com/trisacrypto/courier/pkg/store/mock"
 )
 
@@ -22,12 +33,18 @@ type courierTestSuite struct {
 }
 
 func (s *courierTestSuite) SetupSuite() {
-	require := s.Require()
+	conf := testConfig()
+	conf.AllowPasswordRetrieval = true
+	conf.AllowSecretRetrieval = true
+	s.courier, s.client, s.store = s.startServer(conf)
+}
 
-	// Configuration to start a fully functional server for localhost testing.
-	conf, err := config.Config{
+// Returns the configuration to start a fully functional server for localhost testing.
+func testConfig() config.Config {
+	return config.Config{
 		BindAddr: "127.0.0.1:0",
 		Mode:     gin.TestMode,
+		Metrics:  true,
 		MTLS: config.MTLSConfig{
 			Insecure: true,
 		},
@@ -35,27 +52,36 @@ func (s *courierTestSuite) SetupSuite() {
 			Enabled: true,
 			Path:    "/tmp/courier",
 		},
-	}.Mark()
+	}
+}
+
+// Starts a server with the configuration backed by a mock store and returns an API
+// client to make requests to it. Tests that start their own server with a different
+// configuration than the suite must shut it down when they are done.
+func (s *courierTestSuite) startServer(conf config.Config) (srv *courier.Server, client api.CourierClient, db *mock.Store) {
+	require := s.Require()
+
+	conf, err := conf.Mark()
 	require.NoError(err, "could not create test configuration")
 
 	// Create the server
-	s.courier, err = courier.New(conf)
+	srv, err = courier.New(conf)
 	require.NoError(err, "could not create test server")
 
 	// Use a mock store for testing
-	s.store = mock.New()
-	s.courier.SetStore(s.store)
+	db = mock.New()
+	srv.SetStore(db)
 
-	// Start the server, which will run for the duration of the test suite
-	go s.courier.Serve()
+	// Start the server, which will run until it is shutdown
+	go srv.Serve()
 
 	// Wait for the server to start serving the API
 	time.Sleep(500 * time.Millisecond)
 
 	// Create an API client to use in tests (no retries, no backoff for testing errors)
-	url := s.courier.URL()
-	s.client, err = api.New(url, api.WithRetries(0), api.WithZeroBackoff())
+	client, err = api.New(srv.URL(), api.WithRetries(0), api.WithZeroBackoff())
 	require.NoError(err, "could not create test client")
+	return srv, client, db
 }
 
 func (s *courierTestSuite) TearDownSuite() {
@@ -75,3 +101,146 @@ func (s *courierTestSuite) CheckHTTPStatus(err error, status int, msgAndArgs ...
 	require.True(ok, "expected error to be a StatusError")
 	require.Equal(status, statusErr.Code, msgAndArgs...)
 }
+
+func (s *courierTestSuite) TestProxyProtocol() {
+	require := s.Require()
+
+	conf := testConfig()
+	conf.Proxy.Protocol = true
+	conf.Proxy.HeaderTimeout = 100 * time.Millisecond
+	srv, _, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	// Capture the request logs to check the client ip that was logged
+	logs := &bytes.Buffer{}
+	prev := log.Logger
+	log.Logger = zerolog.New(logs)
+	defer func() { log.Logger = prev }()
+
+	addr := strings.TrimPrefix(srv.URL(), "http://")
+	request := "GET /v1/status HTTP/1.1\r\nHost: courier\r\nX-Forwarded-For: 203.0.113.7\r\nConnection: close\r\n\r\n"
+
+	s.Run("Header", func() {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(err, "could not connect to server")
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n" + request))
+		require.NoError(err, "could not write request")
+
+		rep, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(err, "could not read response")
+		rep.Body.Close()
+		require.Equal(http.StatusOK, rep.StatusCode)
+
+		// The forwarded header is not trusted since no proxies are configured
+		require.Contains(logs.String(), `"client_ip":"192.0.2.1"`, "expected client ip from proxy header")
+	})
+
+	s.Run("NoHeader", func() {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(err, "could not connect to server")
+		defer conn.Close()
+
+		_, err = conn.Write([]byte(request))
+		require.NoError(err, "could not write request")
+
+		// The request is rejected without being routed to the handlers
+		rep, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(err, "could not read response")
+		rep.Body.Close()
+		require.Equal(http.StatusBadRequest, rep.StatusCode, "requests without a proxy header should be rejected")
+	})
+}
+
+func (s *courierTestSuite) TestListeners() {
+	require := s.Require()
+
+	conf := testConfig()
+	conf.Listeners = []string{"http://127.0.0.1:0", "http://localhost:0"}
+	srv, _, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	// Every listener should serve the api
+	urls := srv.URLs()
+	require.Len(urls, 2, "expected a url for each listener")
+	for _, url := range urls {
+		rep, err := http.Get(url + "/v1/status")
+		require.NoError(err, "could not get status from %s", url)
+		rep.Body.Close()
+		require.Equal(http.StatusOK, rep.StatusCode)
+	}
+}
+
+func (s *courierTestSuite) TestOpenStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	keys := []string{
+		base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+		base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)),
+	}
+
+	configs := map[string]func(config.Config) config.Config{
+		"single": func(conf config.Config) config.Config {
+			return conf
+		},
+		"mirror": func(conf config.Config) config.Config {
+			conf.MirrorStorage = true
+			conf.InMemoryStorage.Enabled = true
+			return conf
+		},
+	}
+
+	for name, configure := range configs {
+		s.Run(name, func() {
+			conf := testConfig()
+			conf.LocalStorage.Path = s.T().TempDir()
+			conf.Codec.Pipeline = []string{"aesgcm"}
+			conf.Codec.EncryptionKey = keys[0]
+			conf = configure(conf)
+
+			db, err := courier.OpenStore(conf)
+			require.NoError(err, "could not open store")
+			require.NoError(db.UpdateSecret(ctx, "encoded", []byte("secret")))
+			require.NoError(db.Close())
+
+			// Payloads that the codec cannot decode are recorded for the backend
+			conf.Codec.EncryptionKey = keys[1]
+			db, err = courier.OpenStore(conf)
+			require.NoError(err, "could not reopen store")
+			defer db.Close()
+
+			corruptions := o11y.StoreCorruptions.WithLabelValues("local", "get_secret")
+			before := testutil.ToFloat64(corruptions)
+			_, err = db.GetSecret(ctx, "encoded")
+			require.Error(err, "expected the payload encrypted with another key not to be read")
+			require.Equal(before+1, testutil.ToFloat64(corruptions), "expected the codec failure to be counted")
+		})
+	}
+}
+
+func (s *courierTestSuite) TestLogConfig() {
+	require := s.Require()
+
+	// Capture the configuration that is logged when the server starts
+	logs := &bytes.Buffer{}
+	prev := log.Logger
+	log.Logger = zerolog.New(logs)
+	defer func() { log.Logger = prev }()
+
+	conf := testConfig()
+	conf.Listeners = []string{"http://127.0.0.1:0", "http://localhost:0"}
+	conf.Metrics = false
+	srv, _, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	require.Contains(logs.String(), `"listeners":["http://127.0.0.1:0","http://localhost:0"]`, "expected every listener to be logged")
+	require.Contains(logs.String(), `"metrics":false`, "expected the metrics setting to be logged")
+
+	// Metrics are not served if they are disabled
+	rep, err := http.Get(srv.URL() + "/metrics")
+	require.NoError(err, "could not make metrics request")
+	rep.Body.Close()
+	require.Equal(http.StatusNotFound, rep.StatusCode)
+}
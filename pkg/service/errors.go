@@ -0,0 +1,12 @@
+package service
+
+import "errors"
+
+var (
+	ErrUnsupported      = errors.New("native services are not supported on this platform, use a container or systemd instead")
+	ErrMissingName      = errors.New("service name is required")
+	ErrRelativeExe      = errors.New("service executable must be an absolute path")
+	ErrAlreadyInstalled = errors.New("service is already installed")
+	ErrNotInstalled     = errors.New("service is not installed")
+	ErrStopTimeout      = errors.New("timed out waiting for the service to stop")
+)
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// LaunchdDir is the directory that system-wide launchd daemons are installed in.
+const LaunchdDir = "/Library/LaunchDaemons"
+
+// LaunchdLogDir is the directory that the output of the launchd daemon is written to.
+const LaunchdLogDir = "/Library/Logs"
+
+var plistTemplate = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": escape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{ xml .Name }}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{ xml .Executable }}</string>
+		{{- range .Arguments }}
+		<string>{{ xml . }}</string>
+		{{- end }}
+	</array>
+	{{- if .WorkingDirectory }}
+	<key>WorkingDirectory</key>
+	<string>{{ xml .WorkingDirectory }}</string>
+	{{- end }}
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>{{ xml .LogFile }}</string>
+	<key>StandardErrorPath</key>
+	<string>{{ xml .LogFile }}</string>
+</dict>
+</plist>
+`))
+
+// PlistPath returns the path of the property list of the named launchd daemon.
+func PlistPath(name string) string {
+	return filepath.Join(LaunchdDir, name+".plist")
+}
+
+// Plist renders the launchd property list that runs the service at boot and restarts
+// it if it exits. The output of the service is appended to a log file named after it.
+func Plist(conf Config) ([]byte, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := plistTemplate.Execute(buf, struct {
+		Config
+		LogFile string
+	}{conf, filepath.Join(LaunchdLogDir, conf.Name+".log")}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func escape(s string) string {
+	sb := &strings.Builder{}
+	xml.EscapeText(sb, []byte(s))
+	return sb.String()
+}
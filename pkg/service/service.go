@@ -0,0 +1,32 @@
+/*
+Package service installs and controls courier as a native Windows service or macOS
+launchd daemon for operators that do not run courier in a container.
+*/
+package service
+
+import "path/filepath"
+
+// DefaultName is the name of the Windows service and the label of the launchd daemon.
+const DefaultName = "courier"
+
+// Config describes how the service runs courier.
+type Config struct {
+	Name             string   // The name of the Windows service or label of the launchd daemon
+	DisplayName      string   // The name shown in the Windows service manager
+	Description      string   // A description of the service
+	Executable       string   // The absolute path to the courier executable
+	Arguments        []string // The arguments to run the executable with, e.g. serve
+	WorkingDirectory string   // The working directory of the launchd daemon, ignored on Windows
+}
+
+// Validate the service configuration.
+func (c Config) Validate() error {
+	if c.Name == "" {
+		return ErrMissingName
+	}
+
+	if !filepath.IsAbs(c.Executable) {
+		return ErrRelativeExe
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+)
+
+// Install the service as a launchd daemon. The daemon is not loaded until it is started.
+func Install(conf Config) (err error) {
+	var plist []byte
+	if plist, err = Plist(conf); err != nil {
+		return err
+	}
+
+	var f *os.File
+	if f, err = os.OpenFile(PlistPath(conf.Name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return ErrAlreadyInstalled
+		}
+		return err
+	}
+
+	if _, err = f.Write(plist); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Uninstall unloads the launchd daemon if it is running and removes its property list.
+func Uninstall(name string) (err error) {
+	if err = installed(name); err != nil {
+		return err
+	}
+
+	// The daemon may not be loaded, in which case it cannot be unloaded
+	launchctl("unload", PlistPath(name))
+	return os.Remove(PlistPath(name))
+}
+
+// Start loads the launchd daemon, which runs it now and at every boot.
+func Start(name string) (err error) {
+	if err = installed(name); err != nil {
+		return err
+	}
+	return launchctl("load", "-w", PlistPath(name))
+}
+
+// Stop unloads the launchd daemon, which stops it and keeps it from running at boot
+// until it is started again.
+func Stop(name string) (err error) {
+	if err = installed(name); err != nil {
+		return err
+	}
+	return launchctl("unload", "-w", PlistPath(name))
+}
+
+// Run runs the function directly since launchd stops the daemon with a signal.
+func Run(fn func(stop <-chan struct{}) error) error {
+	return fn(make(chan struct{}))
+}
+
+func installed(name string) error {
+	if _, err := os.Stat(PlistPath(name)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotInstalled
+		}
+		return err
+	}
+	return nil
+}
+
+func launchctl(args ...string) error {
+	if out, err := exec.Command("launchctl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("launchctl %s: %w: %s", args[0], err, out)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build !darwin && !windows
+
+package service
+
+// Install is not supported on this platform.
+func Install(conf Config) error {
+	return ErrUnsupported
+}
+
+// Uninstall is not supported on this platform.
+func Uninstall(name string) error {
+	return ErrUnsupported
+}
+
+// Start is not supported on this platform.
+func Start(name string) error {
+	return ErrUnsupported
+}
+
+// Stop is not supported on this platform.
+func Stop(name string) error {
+	return ErrUnsupported
+}
+
+// Run runs the function directly since the process is stopped with a signal.
+func Run(fn func(stop <-chan struct{}) error) error {
+	return fn(make(chan struct{}))
+}
@@ -0,0 +1,43 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/service"
+)
+
+func TestValidate(t *testing.T) {
+	conf := service.Config{Name: service.DefaultName, Executable: "/usr/local/bin/courier"}
+	require.NoError(t, conf.Validate())
+
+	conf.Executable = "courier"
+	require.ErrorIs(t, conf.Validate(), service.ErrRelativeExe)
+
+	conf.Name = ""
+	require.ErrorIs(t, conf.Validate(), service.ErrMissingName)
+}
+
+func TestPlist(t *testing.T) {
+	conf := service.Config{
+		Name:             service.DefaultName,
+		Executable:       "/usr/local/bin/courier",
+		Arguments:        []string{"--env-file", "/etc/courier/prod & test.env", "serve"},
+		WorkingDirectory: "/var/lib/courier",
+	}
+
+	plist, err := service.Plist(conf)
+	require.NoError(t, err)
+	require.Contains(t, string(plist), "<key>Label</key>\n\t<string>courier</string>")
+	require.Contains(t, string(plist), "\t\t<string>/usr/local/bin/courier</string>\n\t\t<string>--env-file</string>\n\t\t<string>/etc/courier/prod &amp; test.env</string>\n\t\t<string>serve</string>\n\t</array>", "expected escaped arguments in order")
+	require.Contains(t, string(plist), "<key>WorkingDirectory</key>\n\t<string>/var/lib/courier</string>")
+	require.Contains(t, string(plist), "<string>/Library/Logs/courier.log</string>")
+
+	conf.WorkingDirectory = ""
+	plist, err = service.Plist(conf)
+	require.NoError(t, err)
+	require.NotContains(t, string(plist), "WorkingDirectory")
+
+	_, err = service.Plist(service.Config{Name: service.DefaultName})
+	require.ErrorIs(t, err, service.ErrRelativeExe)
+}
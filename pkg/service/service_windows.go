@@ -0,0 +1,174 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Install the service in the Windows service manager to start automatically at boot.
+// The service is not started until it is started or the machine is restarted.
+func Install(conf Config) (err error) {
+	if err = conf.Validate(); err != nil {
+		return err
+	}
+
+	var m *mgr.Mgr
+	if m, err = mgr.Connect(); err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	var s *mgr.Service
+	if s, err = m.OpenService(conf.Name); err == nil {
+		s.Close()
+		return ErrAlreadyInstalled
+	}
+
+	cfg := mgr.Config{
+		DisplayName: conf.DisplayName,
+		Description: conf.Description,
+		StartType:   mgr.StartAutomatic,
+	}
+
+	if s, err = m.CreateService(conf.Name, conf.Executable, cfg, conf.Arguments...); err != nil {
+		return err
+	}
+	return s.Close()
+}
+
+// Uninstall stops the service if it is running and removes it from the service manager.
+func Uninstall(name string) (err error) {
+	return control(name, func(s *mgr.Service) error {
+		if err := stop(s); err != nil {
+			return err
+		}
+		return s.Delete()
+	})
+}
+
+// Start the service.
+func Start(name string) error {
+	return control(name, func(s *mgr.Service) error {
+		return s.Start()
+	})
+}
+
+// Stop the service and wait for it to exit.
+func Stop(name string) error {
+	return control(name, stop)
+}
+
+// Run runs the function as a Windows service if the process was started by the service
+// manager; otherwise the function is run directly. The stop channel is closed when the
+// service manager stops the service.
+func Run(fn func(stop <-chan struct{}) error) (err error) {
+	var isService bool
+	if isService, err = svc.IsWindowsService(); err != nil {
+		return err
+	}
+
+	if !isService {
+		return fn(make(chan struct{}))
+	}
+
+	// The name is ignored by services that run in their own process
+	h := &handler{run: fn}
+	if err = svc.Run(DefaultName, h); err != nil {
+		return err
+	}
+	return h.err
+}
+
+type handler struct {
+	run func(stop <-chan struct{}) error
+	err error
+}
+
+// Execute runs the service until it exits or the service manager stops it.
+func (h *handler) Execute(_ []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.run(stop)
+	}()
+
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case h.err = <-errc:
+			return h.exit()
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				close(stop)
+				h.err = <-errc
+				return h.exit()
+			}
+		}
+	}
+}
+
+func (h *handler) exit() (bool, uint32) {
+	if h.err != nil {
+		return false, 1
+	}
+	return false, 0
+}
+
+func control(name string, fn func(*mgr.Service) error) (err error) {
+	var m *mgr.Mgr
+	if m, err = mgr.Connect(); err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	var s *mgr.Service
+	if s, err = m.OpenService(name); err != nil {
+		if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			return ErrNotInstalled
+		}
+		return fmt.Errorf("could not open service %s: %w", name, err)
+	}
+	defer s.Close()
+	return fn(s)
+}
+
+// stop the service if it is running and wait up to 30 seconds for it to exit, which is
+// as long as the server waits for requests to finish.
+func stop(s *mgr.Service) (err error) {
+	var status svc.Status
+	if status, err = s.Query(); err != nil {
+		return err
+	}
+
+	if status.State == svc.Stopped {
+		return nil
+	}
+
+	if status, err = s.Control(svc.Stop); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return ErrStopTimeout
+		}
+
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package courier
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+// Names of the steps of a simulated delivery in the order that they are run.
+const (
+	StepGenerate            = "generate"
+	StepStorePassword       = "store_password"
+	StepStoreCertificate    = "store_certificate"
+	StepRetrieveCertificate = "retrieve_certificate"
+	StepVerify              = "verify"
+	StepDeleteCertificate   = "delete_certificate"
+	StepDeletePassword      = "delete_password"
+)
+
+// Simulate runs a synthetic delivery through the server under the reserved simulation
+// id: a throwaway certificate is generated and encrypted with a random password, the
+// password and certificate are delivered, the decrypted certificate is retrieved and
+// verified, and then both are deleted. The requests are handled by the router, so the
+// delivery passes through the same middleware, handlers, and storage backend as a real
+// delivery and is published to event subscribers like one. The timing and result of
+// every step is returned; the stored resources are deleted even if a step fails. Only
+// one simulation runs at a time, concurrent requests return 409 Conflict.
+func (s *Server) Simulate(c *gin.Context) {
+	if !s.simulate.TryLock() {
+		c.JSON(http.StatusConflict, api.ErrorResponse("a delivery simulation is already running"))
+		return
+	}
+	defer s.simulate.Unlock()
+
+	sim := &simulation{server: s, origin: c.Request, reply: &api.SimulationReply{ID: api.SimulationID}}
+	start := time.Now()
+
+	var (
+		encrypted []byte
+		password  string
+		serial    *big.Int
+	)
+
+	ok := sim.step(StepGenerate, func() (_ int, err error) {
+		encrypted, password, serial, err = generateCertificate()
+		return 0, err
+	})
+
+	ok = ok && sim.step(StepStorePassword, func() (int, error) {
+		return sim.do(http.MethodPost, "/pkcs12password", &api.StorePasswordRequest{ID: api.SimulationID, Password: password, Force: true}, nil)
+	})
+
+	ok = ok && sim.step(StepStoreCertificate, func() (int, error) {
+		req := &api.StoreCertificateRequest{ID: api.SimulationID, Base64Certificate: base64.StdEncoding.EncodeToString(encrypted)}
+		return sim.do(http.MethodPost, "", req, nil)
+	})
+
+	rep := &api.CertificateReply{}
+	ok = ok && sim.step(StepRetrieveCertificate, func() (int, error) {
+		return sim.do(http.MethodGet, "", nil, rep)
+	})
+
+	ok = ok && sim.step(StepVerify, func() (_ int, err error) {
+		return 0, verifyCertificate(rep.Base64Certificate, serial)
+	})
+
+	// Always clean up the resources that may have been stored by the simulation
+	ok = sim.step(StepDeleteCertificate, func() (int, error) {
+		return sim.do(http.MethodDelete, "", nil, nil)
+	}) && ok
+
+	ok = sim.step(StepDeletePassword, func() (int, error) {
+		return sim.do(http.MethodDelete, "/pkcs12password", nil, nil)
+	}) && ok
+
+	sim.reply.Success = ok
+	sim.reply.Duration = time.Since(start).String()
+
+	log.Info().Bool("success", ok).Str("duration", sim.reply.Duration).Msg("delivery simulation completed")
+	c.JSON(http.StatusOK, sim.reply)
+}
+
+// simulationKey marks the context of the requests made by a delivery simulation so that
+// they are permitted to use the reserved simulation id.
+type simulationKey struct{}
+
+// reservedID returns middleware that rejects requests for the certificate id reserved
+// for delivery simulations unless they are made by a simulation, so that a real
+// delivery cannot be overwritten and then deleted by the next simulation.
+func reservedID(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param(param) == api.SimulationID && c.Request.Context().Value(simulationKey{}) == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, api.ErrorResponse(param+" is reserved by courier"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// simulation records the steps of a simulated delivery.
+type simulation struct {
+	server *Server
+	origin *http.Request
+	reply  *api.SimulationReply
+}
+
+// step runs and times the step, recording its result in the reply. Returns true if the
+// step succeeded so that subsequent steps can be skipped if it failed.
+func (s *simulation) step(name string, fn func() (int, error)) bool {
+	start := time.Now()
+	status, err := fn()
+
+	step := &api.SimulationStep{Name: name, Status: status, Duration: time.Since(start).String()}
+	if err != nil {
+		step.Error = err.Error()
+	}
+
+	s.reply.Steps = append(s.reply.Steps, step)
+	return err == nil
+}
+
+// do handles a request for the simulation certificate with the router and decodes the
+// response into out if it is not nil. The request is attributed to the client that
+// requested the simulation and carries its credentials and TLS state so that it is
+// authenticated by the delivery chain like the requests of the client. Returns the
+// status of the response and an error if the status is not successful.
+func (s *simulation) do(method, path string, in, out interface{}) (status int, err error) {
+	var body io.Reader
+	if in != nil {
+		var data []byte
+		if data, err = json.Marshal(in); err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	var req *http.Request
+	url := "/" + api.Version + "/certs/" + api.SimulationID + path
+	ctx := context.WithValue(s.origin.Context(), simulationKey{}, true)
+	if req, err = http.NewRequestWithContext(ctx, method, url, body); err != nil {
+		return 0, err
+	}
+	req.RemoteAddr = s.origin.RemoteAddr
+	req.TLS = s.origin.TLS
+	for _, key := range credentialHeaders {
+		if values := s.origin.Header.Values(key); len(values) > 0 {
+			req.Header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	rec := httptest.NewRecorder()
+	s.server.router.ServeHTTP(rec, req)
+
+	if rec.Code < 200 || rec.Code >= 300 {
+		reply := &api.Reply{}
+		if json.Unmarshal(rec.Body.Bytes(), reply) == nil && reply.Error != "" {
+			return rec.Code, errors.New(reply.Error)
+		}
+		return rec.Code, fmt.Errorf("[%d] %s", rec.Code, http.StatusText(rec.Code))
+	}
+
+	if out != nil {
+		if err = json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			return rec.Code, fmt.Errorf("could not decode response: %w", err)
+		}
+	}
+	return rec.Code, nil
+}
+
+// generateCertificate creates a short-lived self-signed certificate and encrypts it as
+// pkcs12 data with a random password. The serial number of the certificate is returned
+// so that the certificate can be identified once it has been decrypted.
+func generateCertificate() (encrypted []byte, password string, serial *big.Int, err error) {
+	var key *ecdsa.PrivateKey
+	if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, "", nil, err
+	}
+
+	if serial, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128)); err != nil {
+		return nil, "", nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: api.SimulationID, Organization: []string{"Courier Delivery Simulation"}},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{api.SimulationID},
+	}
+
+	var der []byte
+	if der, err = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key); err != nil {
+		return nil, "", nil, err
+	}
+
+	var chain, pk []byte
+	if chain, err = trust.PEMEncodeCertificate(&x509.Certificate{Raw: der}); err != nil {
+		return nil, "", nil, err
+	}
+
+	if pk, err = trust.PEMEncodePrivateKey(key); err != nil {
+		return nil, "", nil, err
+	}
+
+	var provider *trust.Provider
+	if provider, err = trust.New(append(chain, pk...)); err != nil {
+		return nil, "", nil, err
+	}
+
+	secret := make([]byte, 24)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, "", nil, err
+	}
+	password = base64.RawURLEncoding.EncodeToString(secret)
+
+	if encrypted, err = provider.Encrypt(password); err != nil {
+		return nil, "", nil, err
+	}
+	return encrypted, password, serial, nil
+}
+
+// verifyCertificate checks that the retrieved certificate was decrypted and that it is
+// the certificate that was generated for the simulation.
+func verifyCertificate(b64 string, serial *big.Int) (err error) {
+	var data []byte
+	if data, err = base64.StdEncoding.DecodeString(b64); err != nil {
+		return fmt.Errorf("could not decode retrieved certificate: %w", err)
+	}
+
+	var provider *trust.Provider
+	if provider, err = trust.New(data); err != nil {
+		return fmt.Errorf("retrieved certificate was not decrypted: %w", err)
+	}
+
+	if !provider.IsPrivate() {
+		return errors.New("retrieved certificate is missing its private key")
+	}
+
+	var leaf *x509.Certificate
+	if leaf, err = provider.GetLeafCertificate(); err != nil {
+		return fmt.Errorf("could not parse retrieved certificate: %w", err)
+	}
+
+	if leaf.SerialNumber.Cmp(serial) != 0 {
+		return errors.New("retrieved certificate does not match the delivered certificate")
+	}
+	return nil
+}
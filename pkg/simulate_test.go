@@ -0,0 +1,127 @@
+package courier_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	courier "github.com/trisacrypto/courier/pkg"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/auth"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+)
+
+func (s *courierTestSuite) TestSimulate() {
+	require := s.Require()
+	ctx := context.Background()
+
+	conf := testConfig()
+	conf.AdminToken = "admin-token"
+	srv, client, _ := s.startServer(conf)
+	defer srv.Shutdown()
+
+	admin, err := api.New(srv.URL(), api.WithRetries(0), api.WithAdminToken("admin-token"))
+	require.NoError(err, "could not create admin client")
+
+	s.Run("Unauthenticated", func() {
+		_, err := client.Simulate(ctx)
+		s.CheckHTTPStatus(err, http.StatusUnauthorized, "expected the simulation to require the admin token")
+	})
+
+	s.Run("HappyPath", func() {
+		db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+		require.NoError(err, "could not open memory store")
+		srv.SetStore(db)
+
+		rep, err := admin.Simulate(ctx)
+		require.NoError(err, "could not run the simulation")
+		require.True(rep.Success, "expected the simulation to succeed")
+		require.Equal(api.SimulationID, rep.ID)
+		require.NotEmpty(rep.Duration)
+
+		names := make([]string, 0, len(rep.Steps))
+		for _, step := range rep.Steps {
+			require.Empty(step.Error, "expected step %s to succeed", step.Name)
+			names = append(names, step.Name)
+		}
+		require.Equal([]string{
+			courier.StepGenerate, courier.StepStorePassword, courier.StepStoreCertificate,
+			courier.StepRetrieveCertificate, courier.StepVerify, courier.StepDeleteCertificate,
+			courier.StepDeletePassword,
+		}, names)
+
+		// The simulated resources are cleaned up
+		counts, err := db.Count(ctx)
+		require.NoError(err)
+		require.Equal(store.Counts{}, counts, "expected the simulated resources to be deleted")
+	})
+
+	s.Run("StoreFailure", func() {
+		// The unconfigured mock store fails every request
+		srv, _, _ := s.startServer(conf)
+		defer srv.Shutdown()
+
+		admin, err := api.New(srv.URL(), api.WithRetries(0), api.WithAdminToken("admin-token"))
+		require.NoError(err, "could not create admin client")
+
+		rep, err := admin.Simulate(ctx)
+		require.NoError(err, "expected the failure to be reported in the reply")
+		require.False(rep.Success, "expected the simulation to fail")
+		require.Len(rep.Steps, 4, "expected the delivery to stop and the resources to be cleaned up")
+		require.Equal(courier.StepStorePassword, rep.Steps[1].Name)
+		require.NotEmpty(rep.Steps[1].Error)
+		require.Equal(courier.StepDeletePassword, rep.Steps[3].Name)
+	})
+
+	s.Run("ReservedID", func() {
+		err := client.StoreCertificatePassword(ctx, &api.StorePasswordRequest{ID: api.SimulationID, Password: "supersecretsquirrel"})
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "expected the simulation id to be reserved")
+
+		_, err = client.RetrieveCertificate(ctx, api.SimulationID)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "expected the simulation id to be reserved")
+
+		err = client.AddAlias(ctx, "certID", api.SimulationID)
+		s.CheckHTTPStatus(err, http.StatusBadRequest, "expected the simulation id to be reserved for aliases")
+	})
+
+	s.Run("DeliveryAuth", func() {
+		conf := conf
+		conf.Auth.Delivery = []string{"apikey"}
+		conf.Auth.APIKeys = []string{"alice:alice-key"}
+		srv, _, _ := s.startServer(conf)
+		defer srv.Shutdown()
+
+		db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+		require.NoError(err, "could not open memory store")
+		srv.SetStore(db)
+
+		simulate := func(key string) *api.SimulationReply {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL()+"/v1/admin/simulate", nil)
+			require.NoError(err, "could not create request")
+			req.Header.Set("Authorization", "Bearer admin-token")
+			if key != "" {
+				req.Header.Set(auth.APIKeyHeader, key)
+			}
+
+			rep, err := http.DefaultClient.Do(req)
+			require.NoError(err, "could not make request")
+			defer rep.Body.Close()
+			require.Equal(http.StatusOK, rep.StatusCode)
+
+			out := &api.SimulationReply{}
+			require.NoError(json.NewDecoder(rep.Body).Decode(out), "could not decode reply")
+			return out
+		}
+
+		// The deliveries of the simulation are authenticated with the caller's api key
+		rep := simulate("alice-key")
+		require.True(rep.Success, "expected the simulation to succeed with delivery credentials")
+
+		// The simulation fails without delivery credentials
+		rep = simulate("")
+		require.False(rep.Success, "expected the simulation to fail without delivery credentials")
+		require.Equal(http.StatusUnauthorized, rep.Steps[1].Status)
+	})
+}
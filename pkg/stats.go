@@ -0,0 +1,59 @@
+package courier
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+)
+
+// Stats returns the number of certificates, passwords, and secrets in the store, the
+// timestamp of the last certificate delivery since the server started, and the health
+// of the storage backend. If the store cannot be counted the counts are omitted and
+// the store is reported as unhealthy rather than failing the request.
+func (s *Server) Stats(c *gin.Context) {
+	out := &api.StatsReply{
+		Store: api.StoreHealth{
+			Backend: s.conf.StorageBackend(),
+			Healthy: true,
+		},
+	}
+
+	if delivered := s.LastDelivery(); !delivered.IsZero() {
+		out.LastDelivery = &delivered
+	}
+
+	// Report the most recent health probe failure, if any, the probe logs the details
+	if err := s.StoreError(); err != nil {
+		out.Store.Healthy = false
+		out.Store.Error = storeHealthError(err)
+	}
+
+	counts, err := s.store.Count(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		out.Store.Healthy = false
+		out.Store.Error = storeHealthError(err)
+	} else {
+		out.Certificates = &counts.Certificates
+		out.Passwords = &counts.Passwords
+		out.Secrets = &counts.Secrets
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// LastDelivery returns the timestamp of the last certificate stored by the server.
+func (s *Server) LastDelivery() time.Time {
+	s.RLock()
+	defer s.RUnlock()
+	return s.delivered
+}
+
+// Record that a certificate was delivered to the store.
+func (s *Server) setLastDelivery(ts time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.delivered = ts
+}
@@ -0,0 +1,72 @@
+package courier_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+func (s *courierTestSuite) TestStats() {
+	require := s.Require()
+
+	s.Run("HappyPath", func() {
+		s.store.OnCount = func(ctx context.Context) (store.Counts, error) {
+			return store.Counts{Certificates: 3, Passwords: 2}, nil
+		}
+		s.store.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			return nil
+		}
+		defer s.store.Reset()
+
+		// Deliver a certificate so that the last delivery is recorded
+		err := s.client.StoreCertificate(context.Background(), &api.StoreCertificateRequest{
+			ID:                "certID",
+			Base64Certificate: base64.StdEncoding.EncodeToString([]byte("certificate")),
+			NoDecrypt:         true,
+		})
+		require.NoError(err, "could not store certificate")
+
+		stats, err := s.client.Stats(context.Background())
+		require.NoError(err, "could not get stats")
+		require.Equal(3, *stats.Certificates)
+		require.Equal(2, *stats.Passwords)
+		require.NotNil(stats.LastDelivery, "expected last delivery timestamp")
+		require.Equal("local", stats.Store.Backend)
+		require.True(stats.Store.Healthy)
+		require.Empty(stats.Store.Error)
+	})
+
+	s.Run("StoreError", func() {
+		s.store.OnCount = func(ctx context.Context) (store.Counts, error) {
+			return store.Counts{}, errors.New("could not list /var/lib/courier/certs")
+		}
+		defer s.store.Reset()
+
+		stats, err := s.client.Stats(context.Background())
+		require.NoError(err, "stats should be returned when the store cannot be counted")
+		require.Nil(stats.Certificates)
+		require.Nil(stats.Passwords)
+		require.False(stats.Store.Healthy)
+		require.Equal("an internal error occurred in the courier store", stats.Store.Error)
+		require.NotContains(stats.Store.Error, "/var/lib/courier", "store details should not be returned")
+	})
+
+	s.Run("ProbeError", func() {
+		s.store.OnCount = func(ctx context.Context) (store.Counts, error) {
+			return store.Counts{}, nil
+		}
+		defer s.store.Reset()
+
+		s.courier.SetStoreError(fmt.Errorf("%w: rpc error: code = Unavailable", store.ErrUnavailable))
+		defer s.courier.SetStoreError(nil)
+
+		stats, err := s.client.Stats(context.Background())
+		require.NoError(err, "stats should be returned when the store is unhealthy")
+		require.False(stats.Store.Healthy)
+		require.Equal(store.ErrUnavailable.Error(), stats.Store.Error)
+	})
+}
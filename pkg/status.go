@@ -19,9 +19,10 @@ func (s *Server) Status(c *gin.Context) {
 	// At this point the status is always OK, the available middleware will handle the
 	// stopping status.
 	out := &api.StatusReply{
-		Status:  serverStatusOK,
-		Version: Version(),
-		Uptime:  time.Since(s.started).String(),
+		Status:   serverStatusOK,
+		Version:  Version(),
+		Uptime:   time.Since(s.started).String(),
+		Counters: s.activity.counters(s.started, time.Now()),
 	}
 
 	c.JSON(http.StatusOK, out)
@@ -1,6 +1,18 @@
 package courier_test
 
-import "context"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	courier "github.com/trisacrypto/courier/pkg"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/mock"
+)
 
 func (s *courierTestSuite) TestStatus() {
 	require := s.Require()
@@ -14,3 +26,119 @@ func (s *courierTestSuite) TestStatus() {
 	require.NotEmpty(status.Uptime, "uptime missing from response")
 	require.NotEmpty(status.Version, "version missing from response")
 }
+
+func (s *courierTestSuite) TestStatusCounters() {
+	require := s.Require()
+	ctx := context.Background()
+
+	srv, client, db := s.startServer(testConfig())
+	defer srv.Shutdown()
+
+	status, err := client.Status(ctx)
+	require.NoError(err, "could not get status from server")
+	require.NotNil(status.Counters, "expected counters in the status")
+	require.False(status.Counters.Since.IsZero(), "expected the start time of the counters")
+	require.Equal(api.ActivityCounts{}, status.Counters.Total)
+
+	db.OnGetCertificate = func(context.Context, string) ([]byte, error) { return nil, store.ErrNotFound }
+	db.OnUpdatePassword = func(context.Context, string, []byte) error { return nil }
+	require.NoError(client.StoreCertificatePassword(ctx, &api.StorePasswordRequest{ID: "alice", Password: "supersecret"}))
+	require.NoError(client.StoreCertificatePassword(ctx, &api.StorePasswordRequest{ID: "bob", Password: "supersecret"}))
+
+	// Server errors are counted as failures but client errors are not
+	db.OnUpdatePassword = func(context.Context, string, []byte) error { return errors.New("disk full") }
+	err = client.StoreCertificatePassword(ctx, &api.StorePasswordRequest{ID: "carol", Password: "supersecret"})
+	s.CheckHTTPStatus(err, http.StatusInternalServerError)
+
+	db.OnUpdatePassword = func(context.Context, string, []byte) error { return store.ErrAlreadyExists }
+	err = client.StoreCertificatePassword(ctx, &api.StorePasswordRequest{ID: "carol", Password: "supersecret"})
+	s.CheckHTTPStatus(err, http.StatusConflict)
+
+	status, err = client.Status(ctx)
+	require.NoError(err, "could not get status from server")
+	expected := api.ActivityCounts{PasswordsStored: 2, Failures: 1}
+	require.Equal(expected, status.Counters.Total)
+	require.Equal(expected, status.Counters.LastHour)
+}
+
+func (s *courierTestSuite) TestBuildInfo() {
+	require := s.Require()
+
+	info, err := s.client.BuildInfo(context.Background())
+	require.NoError(err, "could not get build info from server")
+	require.Equal(courier.Version(), info.Version, "wrong version in response")
+	require.Equal(runtime.Version(), info.GoVersion, "wrong go version in response")
+}
+
+func (s *courierTestSuite) TestReadyz() {
+	require := s.Require()
+
+	rep, err := http.Get(s.courier.URL() + "/readyz")
+	require.NoError(err, "could not make ready probe request")
+	rep.Body.Close()
+	require.Equal(http.StatusOK, rep.StatusCode, "expected server to be ready")
+
+	// A failed store health probe should make the server unready
+	s.courier.SetStoreError(store.ErrUnavailable)
+	defer s.courier.SetStoreError(nil)
+
+	rep, err = http.Get(s.courier.URL() + "/readyz")
+	require.NoError(err, "could not make ready probe request")
+	rep.Body.Close()
+	require.Equal(http.StatusServiceUnavailable, rep.StatusCode, "expected server to be unready when the store is unhealthy")
+}
+
+// checkedStore is a mock store whose health check fails until it is marked reachable.
+type checkedStore struct {
+	*mock.Store
+	reachable atomic.Bool
+}
+
+func (c *checkedStore) Check(context.Context) error {
+	if !c.reachable.Load() {
+		return store.ErrUnavailable
+	}
+	return nil
+}
+
+func (s *courierTestSuite) TestStartupz() {
+	require := s.Require()
+
+	probe := func(srv *courier.Server, path string) int {
+		rep, err := http.Get(srv.URL() + path)
+		require.NoError(err, "could not make %s probe request", path)
+		rep.Body.Close()
+		return rep.StatusCode
+	}
+
+	// A store without health checks does not delay startup
+	require.Equal(http.StatusOK, probe(s.courier, "/startupz"), "expected server to be started")
+
+	conf, err := testConfig().Mark()
+	require.NoError(err, "could not create test configuration")
+
+	srv, err := courier.New(conf)
+	require.NoError(err, "could not create test server")
+
+	db := &checkedStore{Store: mock.New()}
+	srv.SetStore(db)
+	go srv.Serve()
+	defer srv.Shutdown()
+	time.Sleep(500 * time.Millisecond)
+
+	// The server is alive but has not started until the store is reachable
+	require.Equal(http.StatusOK, probe(srv, "/livez"), "expected server to be alive while starting")
+	require.Equal(http.StatusServiceUnavailable, probe(srv, "/startupz"), "expected server to be starting")
+	require.Equal(http.StatusServiceUnavailable, probe(srv, "/readyz"), "expected server to be unready while starting")
+
+	db.reachable.Store(true)
+	require.Eventually(func() bool {
+		return probe(srv, "/startupz") == http.StatusOK
+	}, 5*time.Second, 100*time.Millisecond, "expected server to start once the store is reachable")
+	require.Equal(http.StatusOK, probe(srv, "/readyz"), "expected server to be ready once started")
+
+	// Startup does not fail again when the store becomes unreachable
+	srv.SetStoreError(store.ErrUnavailable)
+	require.Equal(http.StatusOK, probe(srv, "/startupz"), "expected server to remain started")
+	require.Equal(http.StatusServiceUnavailable, probe(srv, "/readyz"), "expected server to be unready")
+}
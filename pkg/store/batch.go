@@ -0,0 +1,88 @@
+package store
+
+import "errors"
+
+// Batch collects password and certificate writes that are applied to a store together
+// with WriteBatch. Writes are applied in the order they were added; if any write fails
+// the writes already applied are rolled back on a best-effort basis (see the backend
+// WriteBatch documentation for the exact semantics) so that related resources, such
+// as a certificate and the password that encrypts it, are not left inconsistent.
+type Batch struct {
+	ops []Op
+}
+
+// Op is a single write in a batch. If Delete is true the named resource is deleted,
+// otherwise Data is written as a new version of the resource.
+type Op struct {
+	Prefix string
+	Name   string
+	Data   []byte
+	Delete bool
+}
+
+// UpdatePassword adds a password write to the batch.
+func (b *Batch) UpdatePassword(name string, password []byte) *Batch {
+	b.ops = append(b.ops, Op{Prefix: PasswordPrefix, Name: name, Data: password})
+	return b
+}
+
+// DeletePassword adds a password delete to the batch.
+func (b *Batch) DeletePassword(name string) *Batch {
+	b.ops = append(b.ops, Op{Prefix: PasswordPrefix, Name: name, Delete: true})
+	return b
+}
+
+// UpdateCertificate adds a certificate write to the batch.
+func (b *Batch) UpdateCertificate(name string, cert []byte) *Batch {
+	b.ops = append(b.ops, Op{Prefix: CertificatePrefix, Name: name, Data: cert})
+	return b
+}
+
+// DeleteCertificate adds a certificate delete to the batch.
+func (b *Batch) DeleteCertificate(name string) *Batch {
+	b.ops = append(b.ops, Op{Prefix: CertificatePrefix, Name: name, Delete: true})
+	return b
+}
+
+// UpdateSecret adds a secret write to the batch.
+func (b *Batch) UpdateSecret(name string, secret []byte) *Batch {
+	b.ops = append(b.ops, Op{Prefix: SecretPrefix, Name: name, Data: secret})
+	return b
+}
+
+// DeleteSecret adds a secret delete to the batch.
+func (b *Batch) DeleteSecret(name string) *Batch {
+	b.ops = append(b.ops, Op{Prefix: SecretPrefix, Name: name, Delete: true})
+	return b
+}
+
+// Ops returns the writes in the batch in the order they are applied.
+func (b *Batch) Ops() []Op {
+	return b.ops
+}
+
+// Len returns the number of writes in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Undo records the compensating actions for the writes applied by a batch so that a
+// backend can revert them if a later write in the batch fails.
+type Undo []func() error
+
+// Push records the action that reverts the most recently applied write.
+func (u *Undo) Push(revert func() error) {
+	*u = append(*u, revert)
+}
+
+// Rollback runs the recorded actions in reverse order and returns the error that
+// caused the rollback. If an action fails the rollback stops and ErrRollbackFailed is
+// joined to the returned error since the store may be left inconsistent.
+func (u Undo) Rollback(err error) error {
+	for i := len(u) - 1; i >= 0; i-- {
+		if rerr := u[i](); rerr != nil {
+			return errors.Join(err, ErrRollbackFailed, rerr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,22 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Checksum returns the hex encoded SHA-256 digest of the data, which backends store
+// alongside each resource so that it can be verified when the resource is read.
+func Checksum(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// VerifyChecksum returns ErrCorrupted if the data does not match the checksum.
+func VerifyChecksum(data []byte, checksum string) error {
+	if actual := Checksum(data); actual != checksum {
+		return fmt.Errorf("%w: expected sha256 %s but computed %s", ErrCorrupted, checksum, actual)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+/*
+Package codec implements the serialization pipeline that is applied to payloads before
+they are written to a store, so that compression, at-rest encryption, and encoding are
+configured once rather than reimplemented by every backend.
+
+Encoded payloads are prefixed with a header that names the codecs that were applied so
+that a payload can be decoded even if the configured pipeline has since changed, and
+payloads without the header are returned unmodified so that resources stored before a
+pipeline was configured can still be read.
+*/
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Header is prepended to every payload encoded by a pipeline, followed by the comma
+// separated names of the codecs in the order they were applied and a newline.
+const Header = "courier-codec:"
+
+// Names of the codecs that are built into courier.
+const (
+	GzipName   = "gzip"
+	AESGCMName = "aesgcm"
+	Base64Name = "base64"
+)
+
+// Codec is a single reversible transformation of a payload. Encode may return ErrSkip
+// to leave the payload unmodified, e.g. if compression would not make it smaller, in
+// which case the codec is omitted from the header of the payload.
+type Codec interface {
+	// Name identifies the codec in the header of encoded payloads and must not
+	// contain commas or whitespace.
+	Name() string
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// Pipeline applies a sequence of codecs to payloads when they are encoded and reverses
+// them when they are decoded.
+type Pipeline struct {
+	codecs []Codec
+	known  map[string]Codec
+}
+
+// NewPipeline returns a pipeline that applies the codecs in order when encoding, e.g.
+// compress, then encrypt, then encode. With no codecs payloads are written unmodified
+// but payloads written by another pipeline can still be decoded if the pipeline
+// accepts their codecs.
+func NewPipeline(codecs ...Codec) *Pipeline {
+	p := &Pipeline{codecs: codecs, known: make(map[string]Codec)}
+	return p.Accept(codecs...)
+}
+
+// Accept registers codecs that can be used to decode payloads without applying them to
+// new payloads, e.g. so that payloads can be read while migrating to a new pipeline.
+func (p *Pipeline) Accept(codecs ...Codec) *Pipeline {
+	for _, codec := range codecs {
+		p.known[codec.Name()] = codec
+	}
+	return p
+}
+
+// Names returns the names of the codecs applied by the pipeline in order.
+func (p *Pipeline) Names() []string {
+	names := make([]string, 0, len(p.codecs))
+	for _, codec := range p.codecs {
+		names = append(names, codec.Name())
+	}
+	return names
+}
+
+// Encode applies every codec in the pipeline and prefixes the result with the header,
+// which names the codecs that were applied. If every codec was skipped the payload is
+// returned unmodified.
+func (p *Pipeline) Encode(data []byte) (_ []byte, err error) {
+	applied := make([]string, 0, len(p.codecs))
+	for _, codec := range p.codecs {
+		var encoded []byte
+		if encoded, err = codec.Encode(data); err != nil {
+			if errors.Is(err, ErrSkip) {
+				continue
+			}
+			return nil, fmt.Errorf("could not encode payload with %s: %w", codec.Name(), err)
+		}
+		data = encoded
+		applied = append(applied, codec.Name())
+	}
+
+	if len(applied) == 0 {
+		return data, nil
+	}
+
+	header := Header + strings.Join(applied, ",") + "\n"
+	return append([]byte(header), data...), nil
+}
+
+// Decode reverses the codecs named in the header of the payload. Payloads without a
+// header are returned unmodified.
+func (p *Pipeline) Decode(data []byte) (_ []byte, err error) {
+	if !bytes.HasPrefix(data, []byte(Header)) {
+		return data, nil
+	}
+
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, ErrMalformedHeader
+	}
+
+	names := strings.Split(string(data[len(Header):end]), ",")
+	data = data[end+1:]
+
+	for i := len(names) - 1; i >= 0; i-- {
+		codec, ok := p.known[names[i]]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, names[i])
+		}
+
+		if data, err = codec.Decode(data); err != nil {
+			return nil, fmt.Errorf("could not decode payload with %s: %w", codec.Name(), err)
+		}
+	}
+	return data, nil
+}
+
+// Parse creates a pipeline from the configured codec names. The key is required by the
+// aesgcm codec and, if it is specified, encrypted payloads can be decoded even if the
+// aesgcm codec is not in the pipeline. The built in gzip and base64 codecs are always
+// accepted when decoding. Codecs cannot be repeated and compression must be applied
+// before encryption since encrypted payloads cannot be compressed.
+func Parse(names []string, key []byte) (_ *Pipeline, err error) {
+	var aead Codec
+	if len(key) > 0 {
+		if aead, err = AESGCM(key); err != nil {
+			return nil, err
+		}
+	}
+
+	codecs := make([]Codec, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("%w: %q", ErrRepeatedCodec, name)
+		}
+		seen[name] = true
+
+		switch name {
+		case GzipName:
+			if seen[AESGCMName] {
+				return nil, ErrCompressAfterEncrypt
+			}
+			codecs = append(codecs, Gzip())
+		case Base64Name:
+			codecs = append(codecs, Base64())
+		case AESGCMName:
+			if aead == nil {
+				return nil, ErrMissingKey
+			}
+			codecs = append(codecs, aead)
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, name)
+		}
+	}
+
+	pipeline := NewPipeline(codecs...).Accept(Gzip(), Base64())
+	if aead != nil {
+		pipeline.Accept(aead)
+	}
+	return pipeline, nil
+}
+
+var (
+	ErrSkip                 = errors.New("codec was not applied to the payload")
+	ErrUnknownCodec         = errors.New("unknown payload codec")
+	ErrRepeatedCodec        = errors.New("payload codec is repeated in the pipeline")
+	ErrCompressAfterEncrypt = errors.New("payloads must be compressed before they are encrypted")
+	ErrMalformedHeader      = errors.New("encoded payload has a malformed codec header")
+	ErrMissingKey           = errors.New("the aesgcm codec requires an encryption key")
+	ErrInvalidKey           = errors.New("encryption key must be 16, 24, or 32 bytes")
+	ErrDecrypt              = errors.New("could not decrypt payload")
+)
@@ -0,0 +1,106 @@
+package codec_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/store/codec"
+)
+
+func TestPipeline(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err, "could not generate key")
+
+	payload := bytes.Repeat([]byte("supersecretsquirrel"), 64)
+
+	pipeline, err := codec.Parse([]string{"gzip", "aesgcm", "base64"}, key)
+	require.NoError(t, err, "could not parse pipeline")
+	require.Equal(t, []string{"gzip", "aesgcm", "base64"}, pipeline.Names())
+
+	encoded, err := pipeline.Encode(payload)
+	require.NoError(t, err, "could not encode payload")
+	require.True(t, bytes.HasPrefix(encoded, []byte("courier-codec:gzip,aesgcm,base64\n")), "expected codec header")
+	require.NotContains(t, string(encoded), "supersecretsquirrel", "payload should be encrypted")
+
+	decoded, err := pipeline.Decode(encoded)
+	require.NoError(t, err, "could not decode payload")
+	require.Equal(t, payload, decoded)
+
+	// Payloads without a header should be returned unmodified
+	decoded, err = pipeline.Decode([]byte("legacy"))
+	require.NoError(t, err, "could not decode legacy payload")
+	require.Equal(t, []byte("legacy"), decoded)
+
+	// Payloads written by another pipeline should be decoded using their header
+	other, err := codec.Parse([]string{"base64"}, key)
+	require.NoError(t, err, "could not parse pipeline")
+	decoded, err = other.Decode(encoded)
+	require.NoError(t, err, "could not decode payload with a different pipeline")
+	require.Equal(t, payload, decoded)
+
+	// Payloads that do not compress should be stored uncompressed
+	random := make([]byte, 512)
+	_, err = rand.Read(random)
+	require.NoError(t, err, "could not generate payload")
+
+	encoded, err = pipeline.Encode(random)
+	require.NoError(t, err, "could not encode payload")
+	require.True(t, bytes.HasPrefix(encoded, []byte("courier-codec:aesgcm,base64\n")), "expected compression to be skipped")
+
+	decoded, err = pipeline.Decode(encoded)
+	require.NoError(t, err, "could not decode payload")
+	require.Equal(t, random, decoded)
+
+	// If every codec is skipped the payload should be written unmodified
+	compress := codec.NewPipeline(codec.Gzip())
+	encoded, err = compress.Encode(random)
+	require.NoError(t, err, "could not encode payload")
+	require.Equal(t, random, encoded)
+
+	// An empty pipeline should write payloads unmodified
+	encoded, err = codec.NewPipeline().Encode(payload)
+	require.NoError(t, err, "could not encode payload")
+	require.Equal(t, payload, encoded)
+}
+
+func TestPipelineErrors(t *testing.T) {
+	key := make([]byte, 16)
+	pipeline, err := codec.Parse([]string{"aesgcm"}, key)
+	require.NoError(t, err, "could not parse pipeline")
+
+	encoded, err := pipeline.Encode([]byte("secret"))
+	require.NoError(t, err, "could not encode payload")
+
+	// Payloads encrypted with another key cannot be decrypted
+	other, err := codec.Parse([]string{"aesgcm"}, bytes.Repeat([]byte{1}, 16))
+	require.NoError(t, err, "could not parse pipeline")
+	_, err = other.Decode(encoded)
+	require.ErrorIs(t, err, codec.ErrDecrypt)
+
+	// Encrypted payloads cannot be decoded without the key
+	other, err = codec.Parse([]string{"gzip"}, nil)
+	require.NoError(t, err, "could not parse pipeline")
+	_, err = other.Decode(encoded)
+	require.ErrorIs(t, err, codec.ErrUnknownCodec)
+
+	_, err = pipeline.Decode([]byte("courier-codec:gzip"))
+	require.ErrorIs(t, err, codec.ErrMalformedHeader)
+
+	_, err = codec.Parse([]string{"zstd"}, nil)
+	require.ErrorIs(t, err, codec.ErrUnknownCodec)
+
+	_, err = codec.Parse([]string{"gzip", "base64", "gzip"}, nil)
+	require.ErrorIs(t, err, codec.ErrRepeatedCodec)
+
+	_, err = codec.Parse([]string{"aesgcm", "gzip"}, key)
+	require.ErrorIs(t, err, codec.ErrCompressAfterEncrypt)
+
+	_, err = codec.Parse([]string{"aesgcm"}, nil)
+	require.ErrorIs(t, err, codec.ErrMissingKey)
+
+	_, err = codec.Parse(nil, []byte("short"))
+	require.ErrorIs(t, err, codec.ErrInvalidKey)
+}
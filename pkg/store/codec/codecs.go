@@ -0,0 +1,118 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+)
+
+// Gzip returns a codec that compresses payloads. Payloads that do not get smaller when
+// compressed, such as encrypted pkcs12 data, are skipped and stored uncompressed.
+func Gzip() Codec {
+	return gzipCodec{}
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return GzipName }
+
+func (gzipCodec) Encode(data []byte) (_ []byte, err error) {
+	var buf bytes.Buffer
+	var w *gzip.Writer
+	if w, err = gzip.NewWriterLevel(&buf, gzip.BestCompression); err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(data) {
+		return nil, ErrSkip
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(data []byte) (_ []byte, err error) {
+	var r *gzip.Reader
+	if r, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// AESGCM returns a codec that encrypts payloads with AES-GCM using the key, which must
+// be 16, 24, or 32 bytes long. A random nonce is generated for every payload and is
+// prepended to the ciphertext.
+func AESGCM(key []byte) (_ Codec, err error) {
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	var aead cipher.AEAD
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return aesgcmCodec{aead: aead}, nil
+}
+
+type aesgcmCodec struct {
+	aead cipher.AEAD
+}
+
+func (aesgcmCodec) Name() string { return AESGCMName }
+
+func (c aesgcmCodec) Encode(data []byte) (_ []byte, err error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (c aesgcmCodec) Decode(data []byte) (_ []byte, err error) {
+	size := c.aead.NonceSize()
+	if len(data) < size {
+		return nil, ErrDecrypt
+	}
+
+	if data, err = c.aead.Open(nil, data[:size], data[size:], nil); err != nil {
+		return nil, ErrDecrypt
+	}
+	return data, nil
+}
+
+// Base64 returns a codec that encodes payloads as standard base64 text, e.g. for
+// backends that can only store printable data.
+func Base64() Codec {
+	return base64Codec{}
+}
+
+type base64Codec struct{}
+
+func (base64Codec) Name() string { return Base64Name }
+
+func (base64Codec) Encode(data []byte) ([]byte, error) {
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(out, data)
+	return out, nil
+}
+
+func (base64Codec) Decode(data []byte) (_ []byte, err error) {
+	out := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	var n int
+	if n, err = base64.StdEncoding.Decode(out, data); err != nil {
+		return nil, err
+	}
+	return out[:n], nil
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// Serializer transforms payloads before they are written to a backend and reverses the
+// transformation when they are read, e.g. the codec pipeline.
+type Serializer interface {
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// Encoded wraps a store so that every password, certificate, and secret is encoded by
+// the serializer before it is written and decoded after it is read, independently of
+// the backend. Payloads that cannot be decoded, e.g. because they were encrypted with a
+// different key, are reported as ErrCorrupted. Concurrency tokens and versions are
+// passed through unmodified. The returned store only implements HealthChecker if the
+// wrapped store does.
+func Encoded(store Store, serializer Serializer) Store {
+	wrapped := &encoded{Store: store, serializer: serializer}
+	if checker, ok := store.(HealthChecker); ok {
+		return &encodedChecker{encoded: wrapped, HealthChecker: checker}
+	}
+	return wrapped
+}
+
+type encoded struct {
+	Store
+	serializer Serializer
+}
+
+// encodedChecker forwards the health checks of stores that support them.
+type encodedChecker struct {
+	*encoded
+	HealthChecker
+}
+
+var (
+	_ Store         = &encoded{}
+	_ Store         = &encodedChecker{}
+	_ HealthChecker = &encodedChecker{}
+)
+
+// Unwrap returns the store that payloads are encoded for.
+func (s *encoded) Unwrap() Store {
+	return s.Store
+}
+
+func (s *encoded) WriteBatch(ctx context.Context, batch *Batch) (err error) {
+	encoded := &Batch{ops: make([]Op, 0, batch.Len())}
+	for _, op := range batch.Ops() {
+		if !op.Delete {
+			if op.Data, err = s.serializer.Encode(op.Data); err != nil {
+				return err
+			}
+		}
+		encoded.ops = append(encoded.ops, op)
+	}
+	return s.Store.WriteBatch(ctx, encoded)
+}
+
+func (s *encoded) GetPassword(ctx context.Context, name string) ([]byte, error) {
+	return s.decode(s.Store.GetPassword(ctx, name))
+}
+
+func (s *encoded) GetPasswordVersion(ctx context.Context, name, version string) ([]byte, error) {
+	return s.decode(s.Store.GetPasswordVersion(ctx, name, version))
+}
+
+func (s *encoded) GetPasswordWithToken(ctx context.Context, name string) (password []byte, token string, err error) {
+	if password, token, err = s.Store.GetPasswordWithToken(ctx, name); err != nil {
+		return nil, token, err
+	}
+	password, err = s.decode(password, nil)
+	return password, token, err
+}
+
+func (s *encoded) UpdatePassword(ctx context.Context, name string, password []byte) (err error) {
+	if password, err = s.serializer.Encode(password); err != nil {
+		return err
+	}
+	return s.Store.UpdatePassword(ctx, name, password)
+}
+
+func (s *encoded) CompareAndUpdatePassword(ctx context.Context, name, token string, password []byte) (_ string, err error) {
+	if password, err = s.serializer.Encode(password); err != nil {
+		return "", err
+	}
+	return s.Store.CompareAndUpdatePassword(ctx, name, token, password)
+}
+
+func (s *encoded) GetCertificate(ctx context.Context, name string) ([]byte, error) {
+	return s.decode(s.Store.GetCertificate(ctx, name))
+}
+
+func (s *encoded) GetCertificateVersion(ctx context.Context, name, version string) ([]byte, error) {
+	return s.decode(s.Store.GetCertificateVersion(ctx, name, version))
+}
+
+func (s *encoded) GetCertificateWithToken(ctx context.Context, name string) (cert []byte, token string, err error) {
+	if cert, token, err = s.Store.GetCertificateWithToken(ctx, name); err != nil {
+		return nil, token, err
+	}
+	cert, err = s.decode(cert, nil)
+	return cert, token, err
+}
+
+func (s *encoded) UpdateCertificate(ctx context.Context, name string, cert []byte) (err error) {
+	if cert, err = s.serializer.Encode(cert); err != nil {
+		return err
+	}
+	return s.Store.UpdateCertificate(ctx, name, cert)
+}
+
+func (s *encoded) CompareAndUpdateCertificate(ctx context.Context, name, token string, cert []byte) (_ string, err error) {
+	if cert, err = s.serializer.Encode(cert); err != nil {
+		return "", err
+	}
+	return s.Store.CompareAndUpdateCertificate(ctx, name, token, cert)
+}
+
+func (s *encoded) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	return s.decode(s.Store.GetSecret(ctx, name))
+}
+
+func (s *encoded) UpdateSecret(ctx context.Context, name string, secret []byte) (err error) {
+	if secret, err = s.serializer.Encode(secret); err != nil {
+		return err
+	}
+	return s.Store.UpdateSecret(ctx, name, secret)
+}
+
+// Decodes the data returned by the backend, reporting decoding errors as corruption.
+func (s *encoded) decode(data []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = s.serializer.Decode(data); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupted, err)
+	}
+	return data, nil
+}
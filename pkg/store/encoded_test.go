@@ -0,0 +1,69 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/codec"
+	"github.com/trisacrypto/courier/pkg/store/mock"
+)
+
+func TestEncoded(t *testing.T) {
+	ctx := context.Background()
+	backend := mock.New()
+	encoded := store.Encoded(backend, codec.NewPipeline(codec.Gzip(), codec.Base64()))
+	certificate := bytes.Repeat([]byte("certificate"), 16)
+
+	// Payloads should be encoded before they are written to the backend
+	var stored []byte
+	backend.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+		stored = cert
+		return nil
+	}
+	require.NoError(t, encoded.UpdateCertificate(ctx, "certID", certificate))
+	require.True(t, bytes.HasPrefix(stored, []byte(codec.Header+"gzip,base64\n")), "expected payload to be encoded")
+
+	// And decoded when they are read
+	backend.OnGetCertificateWithToken = func(ctx context.Context, name string) ([]byte, string, error) {
+		return stored, "token", nil
+	}
+	cert, token, err := encoded.GetCertificateWithToken(ctx, "certID")
+	require.NoError(t, err, "could not get certificate")
+	require.Equal(t, certificate, cert)
+	require.Equal(t, "token", token, "expected token to be passed through")
+
+	// Batches should be encoded except for deletes
+	backend.OnWriteBatch = func(ctx context.Context, batch *store.Batch) error {
+		ops := batch.Ops()
+		require.Len(t, ops, 2)
+		require.True(t, bytes.HasPrefix(ops[0].Data, []byte(codec.Header)), "expected write to be encoded")
+		require.Nil(t, ops[1].Data, "expected delete to be unmodified")
+		return nil
+	}
+	batch := (&store.Batch{}).UpdatePassword("certID", []byte("password")).DeleteSecret("certID")
+	require.NoError(t, encoded.WriteBatch(ctx, batch))
+	require.Equal(t, []byte("password"), batch.Ops()[0].Data, "the caller's batch should not be modified")
+
+	// Payloads that cannot be decoded are corrupted
+	backend.OnGetSecret = func(ctx context.Context, name string) ([]byte, error) {
+		return []byte(codec.Header + "base64\n!!!"), nil
+	}
+	_, err = encoded.GetSecret(ctx, "secret")
+	require.ErrorIs(t, err, store.ErrCorrupted)
+
+	// Backend errors should be passed through
+	backend.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+		return nil, store.ErrNotFound
+	}
+	_, err = encoded.GetPassword(ctx, "certID")
+	require.ErrorIs(t, err, store.ErrNotFound)
+
+	// Health checks should only be forwarded to stores that support them
+	_, ok := encoded.(store.HealthChecker)
+	require.False(t, ok, "encoded store should not implement HealthChecker if the backend does not")
+	_, ok = store.Encoded(&checker{Store: mock.New()}, codec.NewPipeline()).(store.HealthChecker)
+	require.True(t, ok, "encoded store should implement HealthChecker if the backend does")
+}
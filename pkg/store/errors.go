@@ -2,6 +2,19 @@ package store
 
 import "errors"
 
+// Typed errors returned by every store backend so that callers can handle storage
+// failures uniformly regardless of which backend is configured.
 var (
-	ErrNotFound = errors.New("resource not found in store")
+	ErrNotFound         = errors.New("resource not found in store")
+	ErrAlreadyExists    = errors.New("resource already exists in store")
+	ErrPayloadTooLarge  = errors.New("resource is too large for store")
+	ErrPermissionDenied = errors.New("permission denied by store")
+	ErrUnavailable      = errors.New("store is currently unavailable")
+	ErrNoVersioning     = errors.New("store does not support versions")
+	ErrCannotReencrypt  = errors.New("stored certificate cannot be decrypted with the stored pkcs12 password")
+	ErrRollbackFailed   = errors.New("store could not roll back a failed batch")
+	ErrVersionMismatch  = errors.New("resource has been modified in store")
+	ErrInvalidKeep      = errors.New("at least one version must be kept when pruning")
+	ErrCorrupted        = errors.New("resource failed its integrity check in store")
+	ErrUnknownResource  = errors.New("unknown resource type in store")
 )
@@ -0,0 +1,584 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/o11y"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// New returns a store that uses the primary store until an operation on it fails
+// because the primary is unavailable, then fails over to the secondary store and
+// retries the operation on it. While failed over, the primary is probed at the
+// configured interval and every operation is served by the secondary until a probe
+// succeeds and the resources written or deleted on the secondary during the outage
+// have been copied back to or deleted from the primary. Resources that are not found
+// on the primary are read from the secondary and deletes are applied to both stores,
+// so that resources left on the secondary, e.g. by an outage that ended while courier
+// was restarting, can still be read and deleted. Concurrency tokens are those of the
+// store that served the read, so a token read before a failover will not match.
+func New(conf config.FailoverConfig, primary, secondary store.Store) *Store {
+	s := &Store{
+		primary:   primary,
+		secondary: secondary,
+		interval:  conf.ProbeInterval,
+		pending:   make(map[resource]uint64),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	o11y.StoreFailedOver.Set(0)
+	go s.probe()
+	return s
+}
+
+// Store implements the store.Store interface by failing over between two stores.
+type Store struct {
+	sync.RWMutex
+	primary    store.Store
+	secondary  store.Store
+	failedOver bool
+	pending    map[resource]uint64 // resources changed on the secondary while failed over
+	changes    uint64
+	writing    sync.RWMutex // held while writes are applied and recorded as pending
+	interval   time.Duration
+	stop       chan struct{}
+	done       chan struct{}
+	closing    sync.Once
+}
+
+var (
+	_ store.Store          = &Store{}
+	_ store.HealthChecker  = &Store{}
+	_ store.ChangeNotifier = &Store{}
+)
+
+// Close stops probing the primary and closes both stores.
+func (s *Store) Close() error {
+	s.closing.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+	return errors.Join(s.primary.Close(), s.secondary.Close())
+}
+
+// Check the connection to the store that is serving requests, so that the service is
+// healthy while it is failed over to a healthy secondary.
+func (s *Store) Check(ctx context.Context) error {
+	db, _ := s.active()
+	return check(ctx, db)
+}
+
+// FailedOver returns true if requests are currently served by the secondary store.
+func (s *Store) FailedOver() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.failedOver
+}
+
+// OnChange registers the function with both stores if they detect changes made
+// outside of courier.
+func (s *Store) OnChange(fn func(store.Change)) {
+	for _, db := range []store.Store{s.primary, s.secondary} {
+		if notifier, ok := store.Notifier(db); ok {
+			notifier.OnChange(fn)
+		}
+	}
+}
+
+func (s *Store) Count(ctx context.Context) (store.Counts, error) {
+	return do(ctx, s, func(db store.Store) (store.Counts, error) {
+		return db.Count(ctx)
+	})
+}
+
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) error {
+	changed := make([]resource, 0, batch.Len())
+	for _, op := range batch.Ops() {
+		changed = append(changed, resource{op.Prefix, op.Name})
+	}
+
+	_, err := write(ctx, s, func(db store.Store) (struct{}, error) {
+		return struct{}{}, db.WriteBatch(ctx, batch)
+	}, changed...)
+	return err
+}
+
+// List the resources of the type in the active store. While the primary is active, the
+// resources of the secondary are also listed since they can still be read.
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	_, primary := s.active()
+	ids, err := do(ctx, s, func(db store.Store) ([]string, error) {
+		return db.List(ctx, prefix)
+	})
+	if err != nil || !primary || s.FailedOver() {
+		return ids, err
+	}
+
+	var fallback []string
+	if fallback, err = s.secondary.List(ctx, prefix); err != nil {
+		return ids, nil
+	}
+	return merge(ids, fallback), nil
+}
+
+// Exists checks if the active store has the resource, or the secondary while the
+// primary is active since resources are read from the secondary if they are not found.
+func (s *Store) Exists(ctx context.Context, prefix, name string) (bool, error) {
+	_, primary := s.active()
+	exists, err := do(ctx, s, func(db store.Store) (bool, error) {
+		return db.Exists(ctx, prefix, name)
+	})
+	if err != nil || exists || !primary || s.FailedOver() {
+		return exists, err
+	}
+
+	if exists, err = s.secondary.Exists(ctx, prefix, name); err != nil {
+		return false, nil
+	}
+	return exists, nil
+}
+
+func (s *Store) Modified(ctx context.Context, prefix, name string) (time.Time, error) {
+	return read(ctx, s, func(db store.Store) (time.Time, error) {
+		return db.Modified(ctx, prefix, name)
+	})
+}
+
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return s.remove(ctx, prefix, name, func(db store.Store) error {
+		return db.Delete(ctx, prefix, name)
+	})
+}
+
+//===========================================================================
+// Password Methods
+//===========================================================================
+
+func (s *Store) GetPassword(ctx context.Context, name string) ([]byte, error) {
+	return read(ctx, s, func(db store.Store) ([]byte, error) {
+		return db.GetPassword(ctx, name)
+	})
+}
+
+func (s *Store) GetPasswordVersion(ctx context.Context, name, version string) ([]byte, error) {
+	return read(ctx, s, func(db store.Store) ([]byte, error) {
+		return db.GetPasswordVersion(ctx, name, version)
+	})
+}
+
+func (s *Store) GetPasswordWithToken(ctx context.Context, name string) (password []byte, token string, err error) {
+	var res tokened
+	res, err = read(ctx, s, func(db store.Store) (res tokened, err error) {
+		res.data, res.token, err = db.GetPasswordWithToken(ctx, name)
+		return res, err
+	})
+	return res.data, res.token, err
+}
+
+func (s *Store) UpdatePassword(ctx context.Context, name string, password []byte) error {
+	_, err := write(ctx, s, func(db store.Store) (struct{}, error) {
+		return struct{}{}, db.UpdatePassword(ctx, name, password)
+	}, resource{store.PasswordPrefix, name})
+	return err
+}
+
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, name, token string, password []byte) (string, error) {
+	return write(ctx, s, func(db store.Store) (string, error) {
+		return db.CompareAndUpdatePassword(ctx, name, token, password)
+	}, resource{store.PasswordPrefix, name})
+}
+
+func (s *Store) DeletePassword(ctx context.Context, name string) error {
+	return s.remove(ctx, store.PasswordPrefix, name, func(db store.Store) error {
+		return db.DeletePassword(ctx, name)
+	})
+}
+
+func (s *Store) PrunePasswordVersions(ctx context.Context, name string, keep int) error {
+	return s.exec(ctx, func(db store.Store) error {
+		return db.PrunePasswordVersions(ctx, name, keep)
+	})
+}
+
+//===========================================================================
+// Certificate Methods
+//===========================================================================
+
+func (s *Store) GetCertificate(ctx context.Context, name string) ([]byte, error) {
+	return read(ctx, s, func(db store.Store) ([]byte, error) {
+		return db.GetCertificate(ctx, name)
+	})
+}
+
+func (s *Store) GetCertificateVersion(ctx context.Context, name, version string) ([]byte, error) {
+	return read(ctx, s, func(db store.Store) ([]byte, error) {
+		return db.GetCertificateVersion(ctx, name, version)
+	})
+}
+
+func (s *Store) GetCertificateWithToken(ctx context.Context, name string) (cert []byte, token string, err error) {
+	var res tokened
+	res, err = read(ctx, s, func(db store.Store) (res tokened, err error) {
+		res.data, res.token, err = db.GetCertificateWithToken(ctx, name)
+		return res, err
+	})
+	return res.data, res.token, err
+}
+
+func (s *Store) ListCertificateVersions(ctx context.Context, name string) ([]store.Version, error) {
+	return read(ctx, s, func(db store.Store) ([]store.Version, error) {
+		return db.ListCertificateVersions(ctx, name)
+	})
+}
+
+func (s *Store) UpdateCertificate(ctx context.Context, name string, cert []byte) error {
+	_, err := write(ctx, s, func(db store.Store) (struct{}, error) {
+		return struct{}{}, db.UpdateCertificate(ctx, name, cert)
+	}, resource{store.CertificatePrefix, name})
+	return err
+}
+
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, name, token string, cert []byte) (string, error) {
+	return write(ctx, s, func(db store.Store) (string, error) {
+		return db.CompareAndUpdateCertificate(ctx, name, token, cert)
+	}, resource{store.CertificatePrefix, name})
+}
+
+func (s *Store) DeleteCertificate(ctx context.Context, name string) error {
+	return s.remove(ctx, store.CertificatePrefix, name, func(db store.Store) error {
+		return db.DeleteCertificate(ctx, name)
+	})
+}
+
+func (s *Store) PruneCertificateVersions(ctx context.Context, name string, keep int) error {
+	return s.exec(ctx, func(db store.Store) error {
+		return db.PruneCertificateVersions(ctx, name, keep)
+	})
+}
+
+//===========================================================================
+// Secret Methods
+//===========================================================================
+
+func (s *Store) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	return read(ctx, s, func(db store.Store) ([]byte, error) {
+		return db.GetSecret(ctx, name)
+	})
+}
+
+func (s *Store) UpdateSecret(ctx context.Context, name string, secret []byte) error {
+	_, err := write(ctx, s, func(db store.Store) (struct{}, error) {
+		return struct{}{}, db.UpdateSecret(ctx, name, secret)
+	}, resource{store.SecretPrefix, name})
+	return err
+}
+
+func (s *Store) DeleteSecret(ctx context.Context, name string) error {
+	return s.remove(ctx, store.SecretPrefix, name, func(db store.Store) error {
+		return db.DeleteSecret(ctx, name)
+	})
+}
+
+//===========================================================================
+// Failover
+//===========================================================================
+
+type tokened struct {
+	data  []byte
+	token string
+}
+
+// resource identifies a stored resource by the prefix of its type and its name.
+type resource struct {
+	prefix string
+	name   string
+}
+
+// do runs the operation on the active store. If the primary fails because it is
+// unavailable, the store fails over and the operation is retried on the secondary.
+func do[T any](ctx context.Context, s *Store, fn func(store.Store) (T, error)) (res T, err error) {
+	db, primary := s.active()
+	if res, err = fn(db); primary && unavailable(ctx, err) {
+		s.failover(err)
+		return fn(s.secondary)
+	}
+	return res, err
+}
+
+// read runs the read like do and also reads from the secondary if the resource is not
+// found on the primary. The error of the primary is returned if neither has it.
+func read[T any](ctx context.Context, s *Store, fn func(store.Store) (T, error)) (res T, err error) {
+	db, primary := s.active()
+	if res, err = fn(db); !primary {
+		return res, err
+	}
+
+	switch {
+	case unavailable(ctx, err):
+		s.failover(err)
+		return fn(s.secondary)
+	case errors.Is(err, store.ErrNotFound):
+		if fallback, ferr := fn(s.secondary); ferr == nil {
+			return fallback, nil
+		}
+	}
+	return res, err
+}
+
+// write runs the write like do. If the write is applied to the secondary, the changed
+// resources are recorded so that they are reconciled with the primary before it is
+// used again.
+func write[T any](ctx context.Context, s *Store, fn func(store.Store) (T, error), changed ...resource) (res T, err error) {
+	s.writing.RLock()
+	defer s.writing.RUnlock()
+
+	db, primary := s.active()
+	if res, err = fn(db); primary && unavailable(ctx, err) {
+		s.failover(err)
+		db, primary = s.secondary, false
+		res, err = fn(db)
+	}
+
+	if !primary && err == nil {
+		s.changed(changed...)
+	}
+	return res, err
+}
+
+// remove runs the delete like do. While the primary is active the resource is also
+// deleted from the secondary, since resources that are not found on the primary are
+// read from the secondary. While failed over, the delete is recorded so that the
+// resource is also deleted from the primary before it is used again.
+func (s *Store) remove(ctx context.Context, prefix, name string, fn func(store.Store) error) (err error) {
+	s.writing.RLock()
+	defer s.writing.RUnlock()
+
+	db, primary := s.active()
+	if err = fn(db); primary && unavailable(ctx, err) {
+		s.failover(err)
+		db, primary = s.secondary, false
+		err = fn(db)
+	}
+
+	if !primary {
+		if err == nil || errors.Is(err, store.ErrNotFound) {
+			s.changed(resource{prefix, name})
+		}
+		return err
+	}
+
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	switch serr := fn(s.secondary); {
+	case serr == nil:
+		return nil
+	case !errors.Is(serr, store.ErrNotFound):
+		log.Warn().Err(serr).Str("prefix", prefix).Str("name", name).Msg("could not delete resource from the secondary store")
+	}
+	return err
+}
+
+func (s *Store) exec(ctx context.Context, fn func(store.Store) error) error {
+	_, err := do(ctx, s, func(db store.Store) (struct{}, error) {
+		return struct{}{}, fn(db)
+	})
+	return err
+}
+
+// merge returns the sorted union of the ids.
+func merge(ids, others []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		seen[id] = struct{}{}
+	}
+
+	for _, id := range others {
+		if _, ok := seen[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+// active returns the store that serves requests and whether it is the primary.
+func (s *Store) active() (store.Store, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.failedOver {
+		return s.secondary, false
+	}
+	return s.primary, true
+}
+
+// failover serves every request from the secondary until the primary recovers.
+func (s *Store) failover(err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.failedOver {
+		return
+	}
+
+	s.failedOver = true
+	o11y.StoreFailovers.WithLabelValues("failover").Inc()
+	o11y.StoreFailedOver.Set(1)
+	log.Warn().Err(err).Msg("primary store is unavailable, failing over to the secondary store")
+}
+
+// changed records the resources that were changed on the secondary while failed over.
+func (s *Store) changed(resources ...resource) {
+	s.Lock()
+	defer s.Unlock()
+	for _, r := range resources {
+		s.changes++
+		s.pending[r] = s.changes
+	}
+}
+
+// reconcile copies the latest version of the resources that were written to the
+// secondary while failed over to the primary and deletes the resources that were
+// deleted from the secondary, so that the primary does not serve stale resources once
+// it has recovered. Resources are no longer pending once they have been reconciled,
+// unless they were changed again while they were reconciled.
+func (s *Store) reconcile(ctx context.Context) (err error) {
+	s.RLock()
+	pending := make(map[resource]uint64, len(s.pending))
+	for r, change := range s.pending {
+		pending[r] = change
+	}
+	s.RUnlock()
+
+	var errs []error
+	for r, change := range pending {
+		if err = s.copyBack(ctx, r); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		s.Lock()
+		if s.pending[r] == change {
+			delete(s.pending, r)
+		}
+		s.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// copyBack makes the primary copy of the resource match the secondary.
+func (s *Store) copyBack(ctx context.Context, r resource) error {
+	data, err := store.GetResource(ctx, s.secondary, r.prefix, r.name)
+	switch {
+	case err == nil:
+		return store.PutResource(ctx, s.primary, r.prefix, r.name, data)
+	case errors.Is(err, store.ErrNotFound):
+		if err = s.primary.Delete(ctx, r.prefix, r.name); errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	default:
+		return err
+	}
+}
+
+// recover serves requests from the primary again if every resource changed on the
+// secondary has been reconciled. Returns false if resources are still pending.
+func (s *Store) recover() bool {
+	// Wait for writes in progress so that they are recorded before the failback
+	s.writing.Lock()
+	defer s.writing.Unlock()
+
+	s.Lock()
+	defer s.Unlock()
+	if !s.failedOver {
+		return true
+	}
+
+	if len(s.pending) > 0 {
+		return false
+	}
+
+	s.failedOver = false
+	o11y.StoreFailovers.WithLabelValues("recovery").Inc()
+	o11y.StoreFailedOver.Set(0)
+	log.Info().Msg("primary store has recovered, failing back from the secondary store")
+	return true
+}
+
+// probe checks the primary at the interval while the store is failed over.
+func (s *Store) probe() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !s.FailedOver() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		err := check(ctx, s.primary)
+		cancel()
+
+		if err != nil {
+			continue
+		}
+
+		// Resources are reconciled without a deadline since there may be many of them
+		if err = s.reconcile(context.Background()); err != nil {
+			log.Warn().Err(err).Msg("could not reconcile the primary store with the secondary store")
+			continue
+		}
+
+		// Resources changed during the reconciliation are reconciled on the next probe
+		s.recover()
+	}
+}
+
+// check verifies the connection to the store with its health check, or by counting
+// the stored resources if it does not support health checks.
+func check(ctx context.Context, db store.Store) (err error) {
+	if checker, ok := db.(store.HealthChecker); ok {
+		return checker.Check(ctx)
+	}
+	_, err = db.Count(ctx)
+	return err
+}
+
+// unavailable returns true if the error indicates that the store could not serve the
+// request rather than that the request itself could not be served, e.g. because the
+// resource does not exist or has been modified, which would fail on any store. Errors
+// returned after the context of the caller is canceled or its deadline is exceeded are
+// caused by the caller, so one slow or abandoned request does not fail over the store;
+// deadlines exceeded by the timeout of the backend itself still fail over.
+func unavailable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	for _, target := range []error{
+		store.ErrNotFound, store.ErrAlreadyExists, store.ErrPayloadTooLarge, store.ErrNoVersioning,
+		store.ErrCannotReencrypt, store.ErrVersionMismatch, store.ErrInvalidKeep, store.ErrCorrupted,
+		store.ErrUnknownResource, context.Canceled,
+	} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+package failover_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/failover"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+	"github.com/trisacrypto/courier/pkg/store/mock"
+)
+
+// unreliable is an in-memory store that can be made unavailable.
+type unreliable struct {
+	*mock.Store
+	backend *memory.Store
+	down    atomic.Bool
+}
+
+func newUnreliable(t *testing.T) *unreliable {
+	backend, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(t, err, "could not open in-memory storage backend")
+
+	db := &unreliable{Store: mock.New(), backend: backend}
+	db.OnCount = func(ctx context.Context) (store.Counts, error) {
+		if db.down.Load() {
+			return store.Counts{}, store.ErrUnavailable
+		}
+		return backend.Count(ctx)
+	}
+	db.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+		if db.down.Load() {
+			return store.ErrUnavailable
+		}
+		return backend.UpdateCertificate(ctx, name, cert)
+	}
+	db.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		if db.down.Load() {
+			return nil, store.ErrUnavailable
+		}
+		return backend.GetCertificate(ctx, name)
+	}
+	db.OnDeleteCertificate = func(ctx context.Context, name string) error {
+		if db.down.Load() {
+			return store.ErrUnavailable
+		}
+		return backend.DeleteCertificate(ctx, name)
+	}
+	db.OnDelete = func(ctx context.Context, prefix, name string) error {
+		if db.down.Load() {
+			return store.ErrUnavailable
+		}
+		return backend.Delete(ctx, prefix, name)
+	}
+	return db
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newUnreliable(t), newUnreliable(t)
+	db := failover.New(config.FailoverConfig{Enabled: true, ProbeInterval: 10 * time.Millisecond}, primary, secondary)
+	defer db.Close()
+
+	// Requests are served by the primary while it is available
+	require.NoError(t, db.UpdateCertificate(ctx, "before", []byte("before")))
+	_, err := primary.backend.GetCertificate(ctx, "before")
+	require.NoError(t, err, "expected the certificate to be stored in the primary")
+	_, err = secondary.backend.GetCertificate(ctx, "before")
+	require.ErrorIs(t, err, store.ErrNotFound, "expected the secondary to be unused")
+
+	// Errors that would fail on any store do not fail over
+	_, err = db.GetCertificate(ctx, "does-not-exist")
+	require.ErrorIs(t, err, store.ErrNotFound)
+	require.False(t, db.FailedOver(), "missing resources should not fail over")
+
+	// The failed request is retried on the secondary when the primary is unavailable
+	primary.down.Store(true)
+	require.NoError(t, db.UpdateCertificate(ctx, "during", []byte("during")), "expected the write to fail over")
+	require.True(t, db.FailedOver(), "expected the store to fail over")
+	require.NoError(t, db.Check(ctx), "expected the store to be healthy while failed over")
+
+	cert, err := db.GetCertificate(ctx, "during")
+	require.NoError(t, err)
+	require.Equal(t, []byte("during"), cert)
+
+	// The primary is used again once it has recovered
+	primary.down.Store(false)
+	require.Eventually(t, func() bool { return !db.FailedOver() }, time.Second, 5*time.Millisecond, "expected the primary to recover")
+
+	require.NoError(t, db.UpdateCertificate(ctx, "after", []byte("after")))
+	_, err = primary.backend.GetCertificate(ctx, "after")
+	require.NoError(t, err, "expected the certificate to be stored in the primary")
+
+	// Resources stored during the outage are copied back to the primary
+	cert, err = primary.backend.GetCertificate(ctx, "during")
+	require.NoError(t, err, "expected the certificate to be copied to the primary")
+	require.Equal(t, []byte("during"), cert)
+
+	cert, err = db.GetCertificate(ctx, "during")
+	require.NoError(t, err)
+	require.Equal(t, []byte("during"), cert)
+}
+
+func TestCallerDeadline(t *testing.T) {
+	primary, secondary := newUnreliable(t), newUnreliable(t)
+	db := failover.New(config.FailoverConfig{Enabled: true, ProbeInterval: time.Hour}, primary, secondary)
+	defer db.Close()
+
+	// The primary takes longer than the caller is willing to wait
+	primary.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := db.GetCertificate(ctx, "slow")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.False(t, db.FailedOver(), "the deadline of the caller should not fail over")
+
+	// Deadlines exceeded by the backend itself still fail over
+	primary.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		return nil, context.DeadlineExceeded
+	}
+
+	require.NoError(t, secondary.backend.UpdateCertificate(context.Background(), "slow", []byte("slow")))
+	cert, err := db.GetCertificate(context.Background(), "slow")
+	require.NoError(t, err, "expected the read to fail over")
+	require.Equal(t, []byte("slow"), cert)
+	require.True(t, db.FailedOver(), "expected the backend timeout to fail over")
+}
+
+func TestUpdateDuringOutage(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newUnreliable(t), newUnreliable(t)
+	db := failover.New(config.FailoverConfig{Enabled: true, ProbeInterval: 10 * time.Millisecond}, primary, secondary)
+	defer db.Close()
+
+	require.NoError(t, db.UpdateCertificate(ctx, "updated", []byte("original")))
+	require.NoError(t, db.UpdateCertificate(ctx, "deleted", []byte("original")))
+
+	// Update and delete the certificates while the primary is unavailable
+	primary.down.Store(true)
+	require.NoError(t, db.UpdateCertificate(ctx, "updated", []byte("renewed")))
+	require.True(t, db.FailedOver(), "expected the store to fail over")
+
+	// The certificate is not on the secondary, but must be deleted from the primary
+	require.ErrorIs(t, db.DeleteCertificate(ctx, "deleted"), store.ErrNotFound)
+
+	// The primary is reconciled with the secondary before it is used again
+	primary.down.Store(false)
+	require.Eventually(t, func() bool { return !db.FailedOver() }, time.Second, 5*time.Millisecond, "expected the primary to recover")
+
+	cert, err := db.GetCertificate(ctx, "updated")
+	require.NoError(t, err)
+	require.Equal(t, []byte("renewed"), cert, "expected the update during the outage not to be shadowed by the primary")
+
+	_, err = db.GetCertificate(ctx, "deleted")
+	require.ErrorIs(t, err, store.ErrNotFound, "expected the delete during the outage to be applied to the primary")
+}
+
+func TestDeleteAfterRecovery(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newUnreliable(t), newUnreliable(t)
+	db := failover.New(config.FailoverConfig{Enabled: true, ProbeInterval: 10 * time.Millisecond}, primary, secondary)
+	defer db.Close()
+
+	// Deliver a certificate during an outage
+	primary.down.Store(true)
+	require.NoError(t, db.UpdateCertificate(ctx, "during", []byte("during")))
+	primary.down.Store(false)
+	require.Eventually(t, func() bool { return !db.FailedOver() }, time.Second, 5*time.Millisecond, "expected the primary to recover")
+
+	// A certificate left only on the secondary, e.g. if courier restarted during the
+	// outage, is read from the secondary
+	require.NoError(t, secondary.backend.UpdateCertificate(ctx, "orphaned", []byte("orphaned")))
+	cert, err := db.GetCertificate(ctx, "orphaned")
+	require.NoError(t, err, "expected the read to fall back to the secondary")
+	require.Equal(t, []byte("orphaned"), cert)
+
+	// Deletes after the recovery remove the certificates from both stores
+	for _, name := range []string{"during", "orphaned"} {
+		require.NoError(t, db.DeleteCertificate(ctx, name), "expected %s to be deleted", name)
+
+		_, err = db.GetCertificate(ctx, name)
+		require.ErrorIs(t, err, store.ErrNotFound, "expected %s not to be readable once deleted", name)
+		_, err = secondary.backend.GetCertificate(ctx, name)
+		require.ErrorIs(t, err, store.ErrNotFound, "expected %s to be deleted from the secondary", name)
+	}
+
+	require.ErrorIs(t, db.Delete(ctx, store.CertificatePrefix, "orphaned"), store.ErrNotFound)
+}
@@ -0,0 +1,48 @@
+package gcloud
+
+import "sync"
+
+// defaultCacheSize is the maximum number of missing secret names tracked by the store
+// before the cache is cleared.
+const defaultCacheSize = 4096
+
+// missingCache is a small, bounded cache of secrets that are known not to exist in
+// secret manager. It is only an optimization: a name that is not in the cache may or
+// may not exist, and writes to it fall back to adding a version first.
+type missingCache struct {
+	sync.Mutex
+	size  int
+	names map[string]struct{}
+}
+
+func newMissingCache(size int) *missingCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &missingCache{size: size, names: make(map[string]struct{})}
+}
+
+// missing returns true only if the secret is known not to exist.
+func (m *missingCache) missing(name string) bool {
+	m.Lock()
+	defer m.Unlock()
+	_, ok := m.names[name]
+	return ok
+}
+
+// add records that the secret does not exist, clearing the cache if it is full.
+func (m *missingCache) add(name string) {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.names[name]; !ok && len(m.names) >= m.size {
+		m.names = make(map[string]struct{})
+	}
+	m.names[name] = struct{}{}
+}
+
+// remove records that the secret exists so it is no longer tracked by the cache.
+func (m *missingCache) remove(name string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.names, name)
+}
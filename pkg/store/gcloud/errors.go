@@ -0,0 +1,7 @@
+package gcloud
+
+import "errors"
+
+var (
+	ErrNoReplica = errors.New("secret manager replication is not configured")
+)
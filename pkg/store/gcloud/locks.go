@@ -0,0 +1,43 @@
+package gcloud
+
+import "sync"
+
+// nameLocks serializes operations on the same secret without blocking operations on
+// other secrets. Locks are removed once they are no longer held or waited on so that
+// the number of locks does not grow with the number of secrets.
+type nameLocks struct {
+	sync.Mutex
+	locks map[string]*nameLock
+}
+
+type nameLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock acquires the lock for the named secret and returns a function to release it.
+func (l *nameLocks) lock(name string) (unlock func()) {
+	l.Mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*nameLock)
+	}
+
+	entry, ok := l.locks[name]
+	if !ok {
+		entry = &nameLock{}
+		l.locks[name] = entry
+	}
+	entry.refs++
+	l.Mutex.Unlock()
+
+	entry.Lock()
+	return func() {
+		entry.Unlock()
+
+		l.Mutex.Lock()
+		defer l.Mutex.Unlock()
+		if entry.refs--; entry.refs == 0 {
+			delete(l.locks, name)
+		}
+	}
+}
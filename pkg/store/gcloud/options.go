@@ -1,6 +1,9 @@
 package gcloud
 
-import "github.com/trisacrypto/courier/pkg/secrets"
+import (
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/secrets"
+)
 
 // StoreOption allows us to configure the store when it is created.
 type StoreOption func(s *Store) error
@@ -11,3 +14,20 @@ func WithClient(client secrets.SecretManagerClient) StoreOption {
 		return nil
 	}
 }
+
+// WithReplication replicates the secrets written by the store to another project.
+func WithReplication(conf config.GCPReplicaConfig) StoreOption {
+	return func(s *Store) error {
+		s.replication = conf
+		return nil
+	}
+}
+
+// WithReplicaClient replicates the secrets written by the store with the client rather
+// than a client created for the replica project.
+func WithReplicaClient(client secrets.SecretManagerClient) StoreOption {
+	return func(s *Store) error {
+		s.replica = client
+		return nil
+	}
+}
@@ -0,0 +1,230 @@
+package gcloud
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/o11y"
+	"github.com/trisacrypto/courier/pkg/secrets"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// Operations that are applied to the replica project.
+const (
+	replicateWrite     = "write"
+	replicateDelete    = "delete"
+	replicateReconcile = "reconcile"
+)
+
+// openReplica creates the secret manager client of the replica project, which uses the
+// credentials of the primary project unless the replica has its own and the locations
+// and keys of the replica, and starts the reconciliation of the replica if it is
+// configured.
+func (s *Store) openReplica(conf config.GCPSecretsConfig) (err error) {
+	if s.replica == nil {
+		if s.replica, err = secrets.NewClient(s.replication.Secrets(conf)); err != nil {
+			return err
+		}
+	}
+
+	if s.replication.Reconcile > 0 {
+		var ctx context.Context
+		ctx, s.stop = context.WithCancel(context.Background())
+		s.reconciling.Add(1)
+		go s.reconcileEvery(ctx, s.replication.Reconcile)
+	}
+	return nil
+}
+
+// closeReplica stops the reconciliation of the replica and closes its client.
+func (s *Store) closeReplica() error {
+	if s.replica == nil {
+		return nil
+	}
+
+	if s.stop != nil {
+		s.stop()
+		s.reconciling.Wait()
+	}
+	return s.replica.Close()
+}
+
+// replicate adds the payload to the named secret in the replica project after it was
+// written to the primary project. The write has already succeeded, so a failure is
+// logged and counted rather than returned and the secret is copied to the replica by
+// the next reconciliation. The replica write is not cancelled with the request.
+func (s *Store) replicate(ctx context.Context, name string, payload []byte) {
+	if s.replica == nil {
+		return
+	}
+
+	err := addReplicaVersion(context.WithoutCancel(ctx), s.replica, name, payload)
+	replicated(replicateWrite, name, err)
+}
+
+// replicateDeletion deletes the named secret from the replica project after it was
+// deleted from the primary project.
+func (s *Store) replicateDeletion(ctx context.Context, name string) {
+	if s.replica == nil {
+		return
+	}
+
+	err := s.replica.DeleteSecret(context.WithoutCancel(ctx), name)
+	if errors.Is(err, secrets.ErrSecretNotFound) {
+		err = nil
+	}
+	replicated(replicateDelete, name, err)
+}
+
+// readReplica retries a read of the latest version of the named secret on the replica
+// project if the read failed because the primary project is unavailable, so that
+// deliveries can still be retrieved during an outage of the primary. Versions and
+// concurrency tokens differ between the projects, so only latest versions are read
+// from the replica. The error of the primary is returned if the replica read fails.
+func (s *Store) readReplica(ctx context.Context, operation, name string, payload []byte, err error) ([]byte, error) {
+	if err == nil || s.replica == nil || !transportFailure(err) {
+		return payload, err
+	}
+
+	result := "ok"
+	data, rerr := s.replica.GetLatestVersion(ctx, name)
+	if rerr != nil {
+		result = "error"
+	}
+
+	log.Warn().Err(err).AnErr("replica", rerr).Str("operation", operation).Str("secret", name).Str("result", result).Msg("secret manager read failed, failing over to the replica project")
+	o11y.StoreReadFailovers.WithLabelValues(operation, result).Inc()
+
+	if rerr != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Reconcile copies the latest version of every secret in the primary project to the
+// replica project if it is missing from the replica or its latest version differs, and
+// returns the number of secrets that were copied. Secrets that are only in the replica
+// are not deleted so that an incident that removes secrets from the primary project
+// cannot remove them from the replica as well.
+func (s *Store) Reconcile(ctx context.Context) (copied int, err error) {
+	if s.replica == nil {
+		return 0, ErrNoReplica
+	}
+
+	var names []string
+	if names, err = s.client.ListSecrets(ctx); err != nil {
+		return 0, storeError(err)
+	}
+
+	var errs []error
+	for _, name := range names {
+		if err = ctx.Err(); err != nil {
+			return copied, err
+		}
+
+		// Only secrets created by courier are replicated
+		if !resourceSecret(name) {
+			continue
+		}
+
+		var payload []byte
+		if payload, err = s.client.GetLatestVersion(ctx, name); err != nil {
+			// The secret was deleted or has no versions since it was listed
+			if errors.Is(err, secrets.ErrSecretNotFound) {
+				continue
+			}
+			errs = append(errs, storeError(err))
+			continue
+		}
+
+		var current []byte
+		if current, err = s.replica.GetLatestVersion(ctx, name); err == nil && bytes.Equal(current, payload) {
+			continue
+		}
+
+		if err != nil && !errors.Is(err, secrets.ErrSecretNotFound) {
+			errs = append(errs, storeError(err))
+			replicated(replicateReconcile, name, err)
+			continue
+		}
+
+		err = addReplicaVersion(ctx, s.replica, name, payload)
+		replicated(replicateReconcile, name, err)
+		if err != nil {
+			errs = append(errs, storeError(err))
+			continue
+		}
+		copied++
+	}
+	return copied, errors.Join(errs...)
+}
+
+// reconcileEvery reconciles the replica when the store is opened and then at every
+// interval until the context is cancelled when the store is closed.
+func (s *Store) reconcileEvery(ctx context.Context, interval time.Duration) {
+	defer s.reconciling.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		pass, cancel := context.WithTimeout(ctx, interval)
+		copied, err := s.Reconcile(pass)
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Warn().Err(err).Int("copied", copied).Msg("could not reconcile the secret manager replica")
+		case copied > 0:
+			log.Info().Int("copied", copied).Msg("copied missing or stale secrets to the secret manager replica")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// addReplicaVersion adds a version of the payload to the named secret, creating the
+// secret if it does not exist in the replica project.
+func addReplicaVersion(ctx context.Context, client secrets.SecretManagerClient, name string, payload []byte) (err error) {
+	if _, err = client.AddSecretVersion(ctx, name, payload); !errors.Is(err, secrets.ErrSecretNotFound) {
+		return err
+	}
+
+	if err = client.CreateSecret(ctx, name); err != nil {
+		return err
+	}
+
+	_, err = client.AddSecretVersion(ctx, name, payload)
+	return err
+}
+
+// resourceSecret returns true if the named secret stores a courier resource.
+func resourceSecret(name string) bool {
+	for _, prefix := range store.Prefixes {
+		if strings.HasPrefix(name, prefix+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// replicated records the result of an operation applied to the replica project.
+func replicated(operation, name string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+		log.Warn().Err(err).Str("operation", operation).Str("secret", name).Msg("could not replicate secret to the secret manager replica")
+	}
+	o11y.StoreReplications.WithLabelValues(operation, result).Inc()
+}
@@ -3,15 +3,26 @@ package gcloud
 import (
 	"context"
 	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/rs/zerolog/log"
 	"github.com/trisacrypto/courier/pkg/config"
 	"github.com/trisacrypto/courier/pkg/secrets"
 	"github.com/trisacrypto/courier/pkg/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Open the google cloud storage backend.
 func Open(conf config.GCPSecretsConfig, opts ...StoreOption) (store *Store, err error) {
-	store = &Store{}
+	store = &Store{missing: newMissingCache(defaultCacheSize)}
 
 	// Apply provided options
 	for _, opt := range opts {
@@ -26,19 +37,165 @@ func Open(conf config.GCPSecretsConfig, opts ...StoreOption) (store *Store, err
 		}
 	}
 
+	// Replicate secrets to another project for disaster recovery
+	if store.replica != nil || store.replication.Enabled() {
+		if err = store.openReplica(conf); err != nil {
+			store.client.Close()
+			return nil, err
+		}
+	}
+
 	return store, nil
 }
 
 // Store implements the store.Store interface for google cloud storage using secret
 // manager
 type Store struct {
-	client secrets.SecretManagerClient
+	client      secrets.SecretManagerClient
+	missing     *missingCache
+	failures    atomic.Int32
+	compare     nameLocks
+	replica     secrets.SecretManagerClient
+	replication config.GCPReplicaConfig
+	stop        context.CancelFunc
+	reconciling sync.WaitGroup
 }
 
-var _ store.Store = &Store{}
+var (
+	_ store.Store         = &Store{}
+	_ store.HealthChecker = &Store{}
+)
+
+// Number of consecutive health checks that fail because of transport or credential
+// errors before the secret manager client is recreated.
+const reconnectAfter = 3
 
-// Close the google cloud storage backend.
+// Close the google cloud storage backend, stopping the reconciliation of the replica
+// if it is running.
 func (s *Store) Close() error {
+	return errors.Join(s.closeReplica(), s.client.Close())
+}
+
+// Check the connection to secret manager. If the connection fails persistently due to
+// transport or credential errors the client is recreated so that the next check (and
+// any subsequent requests) use a fresh connection with reloaded credentials.
+func (s *Store) Check(ctx context.Context) (err error) {
+	if err = s.client.Check(ctx); err == nil {
+		s.failures.Store(0)
+		return nil
+	}
+
+	if transportFailure(err) && s.failures.Add(1) >= reconnectAfter {
+		s.failures.Store(0)
+		log.Warn().Err(err).Msg("recreating secret manager client after repeated connection failures")
+		if rerr := s.client.Reconnect(); rerr != nil {
+			return errors.Join(storeError(err), rerr)
+		}
+	}
+	return storeError(err)
+}
+
+// Count the certificates and passwords stored in secret manager.
+func (s *Store) Count(ctx context.Context) (counts store.Counts, err error) {
+	var names []string
+	if names, err = s.client.ListSecrets(ctx); err != nil {
+		return counts, storeError(err)
+	}
+
+	for _, name := range names {
+		switch {
+		case strings.HasPrefix(name, store.CertificatePrefix+"-"):
+			counts.Certificates++
+		case strings.HasPrefix(name, store.PasswordPrefix+"-"):
+			counts.Passwords++
+		case strings.HasPrefix(name, store.SecretPrefix+"-"):
+			counts.Secrets++
+		}
+	}
+	return counts, nil
+}
+
+// List the ids of the resources of the type stored in secret manager.
+func (s *Store) List(ctx context.Context, prefix string) (ids []string, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if names, err = s.client.ListSecrets(ctx); err != nil {
+		return nil, storeError(err)
+	}
+
+	for _, name := range names {
+		if id, ok := strings.CutPrefix(name, prefix+"-"); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Exists checks if the resource is in the secret manager by accessing its latest
+// version, since a secret without versions cannot be read.
+func (s *Store) Exists(ctx context.Context, prefix, name string) (_ bool, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return false, err
+	}
+
+	_, err = s.client.GetLatestVersion(ctx, s.fullName(prefix, name))
+	return store.Found(storeError(err))
+}
+
+// Modified returns when the latest version of the secret was created.
+func (s *Store) Modified(ctx context.Context, prefix, name string) (_ time.Time, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return time.Time{}, err
+	}
+
+	var version *secretmanagerpb.SecretVersion
+	if version, err = s.client.GetVersionMetadata(ctx, s.fullName(prefix, name), secrets.LatestVersion); err != nil {
+		return time.Time{}, storeError(err)
+	}
+	return version.CreateTime.AsTime(), nil
+}
+
+// Delete the resource like the delete method of its type.
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return store.DeleteResource(ctx, s, prefix, name)
+}
+
+// WriteBatch applies the writes in the batch to secret manager. Secret manager does not
+// support transactions, so if a write fails the writes that were already applied are
+// compensated: the prior latest payload of each resource is added back as a new
+// version and resources that were created by the batch are deleted. Version history
+// is not restored and concurrent readers may observe the intermediate state.
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) (err error) {
+	var undo store.Undo
+	for _, op := range batch.Ops() {
+		name := s.fullName(op.Prefix, op.Name)
+
+		// Read the current payload so that the write can be reverted
+		found := true
+		var prior []byte
+		if prior, err = s.client.GetLatestVersion(ctx, name); err != nil {
+			if !errors.Is(err, secrets.ErrSecretNotFound) {
+				return undo.Rollback(storeError(err))
+			}
+			found = false
+		}
+		undo.Push(s.revert(ctx, name, prior, found))
+
+		if op.Delete {
+			err = s.deleteSecret(ctx, name)
+		} else {
+			_, err = s.addVersion(ctx, name, op.Data)
+		}
+
+		if err != nil {
+			return undo.Rollback(err)
+		}
+	}
 	return nil
 }
 
@@ -48,26 +205,56 @@ func (s *Store) Close() error {
 
 // GetPassword retrieves a password by id from the google cloud storage backend.
 func (s *Store) GetPassword(ctx context.Context, id string) (password []byte, err error) {
-	if password, err = s.client.GetLatestVersion(ctx, s.fullName(store.PasswordPrefix, id)); err != nil {
-		if errors.Is(err, secrets.ErrSecretNotFound) {
-			return nil, store.ErrNotFound
-		}
+	secretName := s.fullName(store.PasswordPrefix, id)
+	password, err = s.client.GetLatestVersion(ctx, secretName)
+	if password, err = s.readReplica(ctx, "get_password", secretName, password, err); err != nil {
+		return nil, storeError(err)
+	}
 
-		return nil, err
+	return password, nil
+}
+
+// GetPasswordVersion retrieves a specific version of a password by id from the google
+// cloud storage backend.
+func (s *Store) GetPasswordVersion(ctx context.Context, id, version string) (password []byte, err error) {
+	if password, err = s.client.GetVersion(ctx, s.fullName(store.PasswordPrefix, id), version); err != nil {
+		return nil, storeError(err)
 	}
 
 	return password, nil
 }
 
+// GetPasswordWithToken retrieves a password by id from the google cloud storage
+// backend along with its version number, which is used as the concurrency token.
+func (s *Store) GetPasswordWithToken(ctx context.Context, id string) (password []byte, token string, err error) {
+	if password, token, err = s.client.AccessVersion(ctx, s.fullName(store.PasswordPrefix, id), secrets.LatestVersion); err != nil {
+		return nil, "", storeError(err)
+	}
+	return password, token, nil
+}
+
 // UpdatePassword updates a password by id in the google cloud storage backend.
 func (s *Store) UpdatePassword(ctx context.Context, id string, password []byte) (err error) {
-	// Ensure the secret exists, this assumes that an error is not returned if the
-	// secret already exists.
-	if err = s.client.CreateSecret(ctx, s.fullName(store.PasswordPrefix, id)); err != nil {
-		return err
-	}
+	_, err = s.addVersion(ctx, s.fullName(store.PasswordPrefix, id), password)
+	return err
+}
+
+// CompareAndUpdatePassword adds a new version of a password by id if the latest
+// version matches the token and returns the new version number.
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, id, token string, password []byte) (string, error) {
+	return s.compareAndAdd(ctx, s.fullName(store.PasswordPrefix, id), token, password)
+}
+
+// DeletePassword deletes a password and all of its versions by id from the google
+// cloud storage backend.
+func (s *Store) DeletePassword(ctx context.Context, id string) (err error) {
+	return s.deleteSecret(ctx, s.fullName(store.PasswordPrefix, id))
+}
 
-	return s.client.AddSecretVersion(ctx, s.fullName(store.PasswordPrefix, id), password)
+// PrunePasswordVersions destroys all but the keep most recent versions of a password
+// in the google cloud storage backend.
+func (s *Store) PrunePasswordVersions(ctx context.Context, id string, keep int) error {
+	return s.pruneVersions(ctx, s.fullName(store.PasswordPrefix, id), keep)
 }
 
 //===========================================================================
@@ -76,33 +263,264 @@ func (s *Store) UpdatePassword(ctx context.Context, id string, password []byte)
 
 // GetCertificate retrieves a certificate by id from the google cloud storage backend.
 func (s *Store) GetCertificate(ctx context.Context, id string) (cert []byte, err error) {
-	if cert, err = s.client.GetLatestVersion(ctx, s.fullName(store.CertificatePrefix, id)); err != nil {
-		if errors.Is(err, secrets.ErrSecretNotFound) {
-			return nil, store.ErrNotFound
-		}
+	secretName := s.fullName(store.CertificatePrefix, id)
+	cert, err = s.client.GetLatestVersion(ctx, secretName)
+	if cert, err = s.readReplica(ctx, "get_certificate", secretName, cert, err); err != nil {
+		return nil, storeError(err)
+	}
 
-		return nil, err
+	return cert, nil
+}
+
+// GetCertificateVersion retrieves a specific version of a certificate by id from the
+// google cloud storage backend.
+func (s *Store) GetCertificateVersion(ctx context.Context, id, version string) (cert []byte, err error) {
+	if cert, err = s.client.GetVersion(ctx, s.fullName(store.CertificatePrefix, id), version); err != nil {
+		return nil, storeError(err)
 	}
 
 	return cert, nil
 }
 
+// ListCertificateVersions returns the metadata of all versions of a certificate by id
+// from the google cloud storage backend, newest first.
+func (s *Store) ListCertificateVersions(ctx context.Context, id string) (_ []store.Version, err error) {
+	var versions []*secretmanagerpb.SecretVersion
+	if versions, err = s.client.ListVersions(ctx, s.fullName(store.CertificatePrefix, id)); err != nil {
+		return nil, storeError(err)
+	}
+
+	out := make([]store.Version, 0, len(versions))
+	for _, version := range versions {
+		out = append(out, store.Version{
+			Version: path.Base(version.Name),
+			Created: version.CreateTime.AsTime(),
+			State:   strings.ToLower(version.State.String()),
+		})
+	}
+	return out, nil
+}
+
+// GetCertificateWithToken retrieves a certificate by id from the google cloud storage
+// backend along with its version number, which is used as the concurrency token.
+func (s *Store) GetCertificateWithToken(ctx context.Context, id string) (cert []byte, token string, err error) {
+	if cert, token, err = s.client.AccessVersion(ctx, s.fullName(store.CertificatePrefix, id), secrets.LatestVersion); err != nil {
+		return nil, "", storeError(err)
+	}
+	return cert, token, nil
+}
+
 // UpdateCertificate updates a certificate by id in the google cloud storage backend.
 func (s *Store) UpdateCertificate(ctx context.Context, id string, cert []byte) (err error) {
-	// Ensure the secret exists, this assumes that an error is not returned if the
-	// secret already exists.
-	if err = s.client.CreateSecret(ctx, s.fullName(store.CertificatePrefix, id)); err != nil {
-		return err
+	_, err = s.addVersion(ctx, s.fullName(store.CertificatePrefix, id), cert)
+	return err
+}
+
+// CompareAndUpdateCertificate adds a new version of a certificate by id if the latest
+// version matches the token and returns the new version number.
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, id, token string, cert []byte) (string, error) {
+	return s.compareAndAdd(ctx, s.fullName(store.CertificatePrefix, id), token, cert)
+}
+
+// DeleteCertificate deletes a certificate and all of its versions by id from the google
+// cloud storage backend.
+func (s *Store) DeleteCertificate(ctx context.Context, id string) (err error) {
+	return s.deleteSecret(ctx, s.fullName(store.CertificatePrefix, id))
+}
+
+// PruneCertificateVersions destroys all but the keep most recent versions of a
+// certificate in the google cloud storage backend.
+func (s *Store) PruneCertificateVersions(ctx context.Context, id string, keep int) error {
+	return s.pruneVersions(ctx, s.fullName(store.CertificatePrefix, id), keep)
+}
+
+//===========================================================================
+// Secret Methods
+//===========================================================================
+
+// GetSecret retrieves the latest version of a secret by name from the google cloud
+// storage backend.
+func (s *Store) GetSecret(ctx context.Context, name string) (secret []byte, err error) {
+	secretName := s.fullName(store.SecretPrefix, name)
+	secret, err = s.client.GetLatestVersion(ctx, secretName)
+	if secret, err = s.readReplica(ctx, "get_secret", secretName, secret, err); err != nil {
+		return nil, storeError(err)
 	}
+	return secret, nil
+}
 
-	return s.client.AddSecretVersion(ctx, s.fullName(store.CertificatePrefix, id), cert)
+// UpdateSecret adds a new version of a secret by name in the google cloud storage
+// backend, creating the secret if it does not exist.
+func (s *Store) UpdateSecret(ctx context.Context, name string, secret []byte) (err error) {
+	_, err = s.addVersion(ctx, s.fullName(store.SecretPrefix, name), secret)
+	return err
+}
+
+// DeleteSecret deletes a secret and all of its versions by name from the google cloud
+// storage backend.
+func (s *Store) DeleteSecret(ctx context.Context, name string) (err error) {
+	return s.deleteSecret(ctx, s.fullName(store.SecretPrefix, name))
 }
 
 //===========================================================================
 // Helper methods
 //===========================================================================
 
+// pruneVersions destroys the payloads of all but the keep most recent versions of the
+// named secret. Versions that have already been destroyed are skipped.
+func (s *Store) pruneVersions(ctx context.Context, name string, keep int) (err error) {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+
+	var versions []*secretmanagerpb.SecretVersion
+	if versions, err = s.client.ListVersions(ctx, name); err != nil {
+		return storeError(err)
+	}
+
+	if keep >= len(versions) {
+		return nil
+	}
+
+	for _, version := range versions[keep:] {
+		if version.State == secretmanagerpb.SecretVersion_DESTROYED {
+			continue
+		}
+
+		if err = s.client.DestroyVersion(ctx, name, path.Base(version.Name)); err != nil {
+			return storeError(err)
+		}
+	}
+	return nil
+}
+
+// revert returns a function that restores the named secret to the prior payload, or
+// deletes the secret if it did not exist. The rollback is not cancelled with the
+// request context since abandoning it would leave the store inconsistent; each call
+// is still bounded by the client timeout.
+func (s *Store) revert(ctx context.Context, name string, prior []byte, found bool) func() error {
+	ctx = context.WithoutCancel(ctx)
+	return func() (err error) {
+		if !found {
+			if err = s.deleteSecret(ctx, name); errors.Is(err, store.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		_, err = s.addVersion(ctx, name, prior)
+		return err
+	}
+}
+
 // fullName returns the full name of the secret with the given prefix and id.
 func (s *Store) fullName(prefix, id string) string {
 	return prefix + "-" + id
 }
+
+// compareAndAdd adds a new version of the payload to the named secret if the latest
+// version of the secret matches the token. Secret manager does not support conditional
+// writes, so the comparison is serialized with other conditional writes to the same
+// secret from this store but may race with writes from other processes or
+// unconditional writes.
+func (s *Store) compareAndAdd(ctx context.Context, name, token string, payload []byte) (version string, err error) {
+	unlock := s.compare.lock(name)
+	defer unlock()
+
+	var current string
+	if _, current, err = s.client.AccessVersion(ctx, name, secrets.LatestVersion); err != nil {
+		if !errors.Is(err, secrets.ErrSecretNotFound) {
+			return "", storeError(err)
+		}
+		current = ""
+	}
+
+	if current != token {
+		return "", store.ErrVersionMismatch
+	}
+	return s.addVersion(ctx, name, payload)
+}
+
+// addVersion adds a new version of the payload to the named secret. Because most
+// deliveries are to secrets that already exist, the version is added first and the
+// secret is only created if secret manager reports that it is not found, saving a
+// round trip on repeat writes. Secrets known to be missing (e.g. because they were
+// deleted by this store) are created up front.
+func (s *Store) addVersion(ctx context.Context, name string, payload []byte) (version string, err error) {
+	if !s.missing.missing(name) {
+		if version, err = s.client.AddSecretVersion(ctx, name, payload); err == nil {
+			s.missing.remove(name)
+			s.replicate(ctx, name, payload)
+			return version, nil
+		}
+
+		if !errors.Is(err, secrets.ErrSecretNotFound) {
+			return "", storeError(err)
+		}
+	}
+
+	// Create the secret, this assumes that an error is not returned if the secret
+	// already exists.
+	if err = s.client.CreateSecret(ctx, name); err != nil {
+		return "", storeError(err)
+	}
+
+	if version, err = s.client.AddSecretVersion(ctx, name, payload); err != nil {
+		return "", storeError(err)
+	}
+
+	s.missing.remove(name)
+	s.replicate(ctx, name, payload)
+	return version, nil
+}
+
+// deleteSecret deletes the named secret and records that it no longer exists.
+func (s *Store) deleteSecret(ctx context.Context, name string) (err error) {
+	if err = s.client.DeleteSecret(ctx, name); err != nil {
+		if errors.Is(err, secrets.ErrSecretNotFound) {
+			s.missing.add(name)
+		}
+		return storeError(err)
+	}
+
+	s.missing.add(name)
+	s.replicateDeletion(ctx, name)
+	return nil
+}
+
+// transportFailure returns true if the error indicates a broken connection or stale
+// credentials rather than a problem with the request.
+func transportFailure(err error) bool {
+	if errors.Is(err, secrets.ErrTimeout) {
+		return true
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Unauthenticated:
+		return true
+	}
+	return false
+}
+
+// storeError maps secret manager errors to the typed errors exported by the store.
+// Unrecognized errors are returned unmodified.
+func storeError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, secrets.ErrSecretNotFound):
+		return fmt.Errorf("%w: %v", store.ErrNotFound, err)
+	case errors.Is(err, secrets.ErrPayloadTooLarge):
+		return fmt.Errorf("%w: %v", store.ErrPayloadTooLarge, err)
+	case errors.Is(err, secrets.ErrPermissionsDenied):
+		return fmt.Errorf("%w: %v", store.ErrPermissionDenied, err)
+	case errors.Is(err, secrets.ErrTimeout):
+		return fmt.Errorf("%w: %v", store.ErrUnavailable, err)
+	case errors.Is(err, secrets.ErrChecksumMismatch):
+		return fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+	}
+
+	if serr, ok := status.FromError(err); ok && serr.Code() == codes.Unavailable {
+		return fmt.Errorf("%w: %v", store.ErrUnavailable, err)
+	}
+	return err
+}
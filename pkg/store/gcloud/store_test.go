@@ -2,7 +2,9 @@ package gcloud_test
 
 import (
 	"context"
+	"path"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/googleapis/gax-go"
@@ -103,6 +105,9 @@ func (s *gcloudStoreTestSuite) TestUpdatePassword() {
 
 	s.Run("Error", func() {
 		statusErr := status.Error(codes.Internal, "internal error")
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
 		s.sm.OnCreateSecret = func(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
 			return nil, statusErr
 		}
@@ -112,6 +117,40 @@ func (s *gcloudStoreTestSuite) TestUpdatePassword() {
 	})
 }
 
+func (s *gcloudStoreTestSuite) TestDeletePassword() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.Run("HappyPath", func() {
+		s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			require.Equal("projects/project/secrets/pkcs12-password_id", req.Name, "wrong secret name")
+			return nil
+		}
+		defer s.sm.Reset()
+		err := s.store.DeletePassword(ctx, "password_id")
+		require.NoError(err, "should be able to delete a password")
+	})
+
+	s.Run("NotFound", func() {
+		s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			return status.Error(codes.NotFound, "not found")
+		}
+		defer s.sm.Reset()
+		err := s.store.DeletePassword(ctx, "password_id")
+		require.ErrorIs(err, store.ErrNotFound, "should return not found if the password does not exist")
+	})
+
+	s.Run("Error", func() {
+		statusErr := status.Error(codes.Internal, "internal error")
+		s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			return statusErr
+		}
+		defer s.sm.Reset()
+		err := s.store.DeletePassword(ctx, "password_id")
+		require.EqualError(err, statusErr.Error(), "should return error if there was a gRPC error")
+	})
+}
+
 func (s *gcloudStoreTestSuite) TestGetCertificate() {
 	require := s.Require()
 	ctx := context.Background()
@@ -150,6 +189,103 @@ func (s *gcloudStoreTestSuite) TestGetCertificate() {
 	})
 }
 
+func (s *gcloudStoreTestSuite) TestGetCertificateVersion() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.Run("HappyPath", func() {
+		s.sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+			require.Equal("projects/project/secrets/certificate-cert_id/versions/3", req.Name, "wrong version name")
+			return &secretmanagerpb.AccessSecretVersionResponse{
+				Payload: &secretmanagerpb.SecretPayload{
+					Data: []byte("cert"),
+				},
+			}, nil
+		}
+		defer s.sm.Reset()
+		cert, err := s.store.GetCertificateVersion(ctx, "cert_id", "3")
+		require.NoError(err, "should be able to get a certificate version")
+		require.Equal([]byte("cert"), cert, "wrong certificate returned")
+	})
+
+	s.Run("NotFound", func() {
+		s.sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+		defer s.sm.Reset()
+		_, err := s.store.GetCertificateVersion(ctx, "cert_id", "42")
+		require.ErrorIs(err, store.ErrNotFound, "should return error if version does not exist")
+	})
+}
+
+func (s *gcloudStoreTestSuite) TestListCertificateVersions() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.Run("HappyPath", func() {
+		s.sm.OnListSecretVersions = func(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest, opts ...gax.CallOption) secrets.VersionIterator {
+			require.Equal("projects/project/secrets/certificate-cert_id", req.Parent, "wrong secret name")
+			return &mock.Versions{
+				Versions: []*secretmanagerpb.SecretVersion{
+					{Name: "projects/project/secrets/certificate-cert_id/versions/1", State: secretmanagerpb.SecretVersion_DISABLED},
+					{Name: "projects/project/secrets/certificate-cert_id/versions/3", State: secretmanagerpb.SecretVersion_ENABLED},
+				},
+			}
+		}
+		defer s.sm.Reset()
+
+		versions, err := s.store.ListCertificateVersions(ctx, "cert_id")
+		require.NoError(err, "should be able to list certificate versions")
+		require.Len(versions, 2, "wrong number of versions returned")
+		require.Equal("3", versions[0].Version)
+		require.Equal("enabled", versions[0].State)
+		require.Equal("1", versions[1].Version)
+		require.Equal("disabled", versions[1].State)
+	})
+
+	s.Run("NotFound", func() {
+		s.sm.OnListSecretVersions = func(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest, opts ...gax.CallOption) secrets.VersionIterator {
+			return &mock.Versions{Err: status.Error(codes.NotFound, "not found")}
+		}
+		defer s.sm.Reset()
+		_, err := s.store.ListCertificateVersions(ctx, "cert_id")
+		require.ErrorIs(err, store.ErrNotFound, "should return error if certificate does not exist")
+	})
+}
+
+func (s *gcloudStoreTestSuite) TestPruneVersions() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.sm.OnListSecretVersions = func(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest, opts ...gax.CallOption) secrets.VersionIterator {
+		return &mock.Versions{
+			Versions: []*secretmanagerpb.SecretVersion{
+				{Name: "projects/project/secrets/pkcs12-password_id/versions/3", State: secretmanagerpb.SecretVersion_ENABLED},
+				{Name: "projects/project/secrets/pkcs12-password_id/versions/2", State: secretmanagerpb.SecretVersion_ENABLED},
+				{Name: "projects/project/secrets/pkcs12-password_id/versions/1", State: secretmanagerpb.SecretVersion_DESTROYED},
+			},
+		}
+	}
+
+	var destroyed []string
+	s.sm.OnDestroySecretVersion = func(ctx context.Context, req *secretmanagerpb.DestroySecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		destroyed = append(destroyed, req.Name)
+		return &secretmanagerpb.SecretVersion{}, nil
+	}
+	defer s.sm.Reset()
+
+	require.NoError(s.store.PrunePasswordVersions(ctx, "password_id", 1), "should be able to prune versions")
+	require.Equal([]string{"projects/project/secrets/pkcs12-password_id/versions/2"}, destroyed, "only enabled prior versions should be destroyed")
+
+	// The latest version can never be pruned
+	destroyed = nil
+	for _, keep := range []int{0, -1} {
+		require.ErrorIs(s.store.PrunePasswordVersions(ctx, "password_id", keep), store.ErrInvalidKeep)
+		require.ErrorIs(s.store.PruneCertificateVersions(ctx, "cert_id", keep), store.ErrInvalidKeep)
+	}
+	require.Empty(destroyed, "no versions should be destroyed when keep is invalid")
+}
+
 func (s *gcloudStoreTestSuite) TestUpdateCertificate() {
 	requre := s.Require()
 	ctx := context.Background()
@@ -168,6 +304,9 @@ func (s *gcloudStoreTestSuite) TestUpdateCertificate() {
 
 	s.Run("Error", func() {
 		statusErr := status.Error(codes.Internal, "internal error")
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
 		s.sm.OnCreateSecret = func(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
 			return nil, statusErr
 		}
@@ -176,3 +315,590 @@ func (s *gcloudStoreTestSuite) TestUpdateCertificate() {
 		requre.EqualError(err, statusErr.Error(), "should return error if there was a gRPC error")
 	})
 }
+
+func (s *gcloudStoreTestSuite) TestDeleteCertificate() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.Run("HappyPath", func() {
+		s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			require.Equal("projects/project/secrets/certificate-cert_id", req.Name, "wrong secret name")
+			return nil
+		}
+		defer s.sm.Reset()
+		err := s.store.DeleteCertificate(ctx, "cert_id")
+		require.NoError(err, "should be able to delete a certificate")
+	})
+
+	s.Run("Error", func() {
+		statusErr := status.Error(codes.Internal, "internal error")
+		s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			return statusErr
+		}
+		defer s.sm.Reset()
+		err := s.store.DeleteCertificate(ctx, "cert_id")
+		require.EqualError(err, statusErr.Error(), "should return error if there was a gRPC error")
+	})
+
+	s.Run("NotFound", func() {
+		s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			return status.Error(codes.NotFound, "not found")
+		}
+		defer s.sm.Reset()
+		err := s.store.DeleteCertificate(ctx, "cert_id")
+		require.ErrorIs(err, store.ErrNotFound, "should return not found if the secret does not exist")
+	})
+
+	s.Run("PermissionDenied", func() {
+		s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			return status.Error(codes.PermissionDenied, "denied")
+		}
+		defer s.sm.Reset()
+		err := s.store.DeletePassword(ctx, "password_id")
+		require.ErrorIs(err, store.ErrPermissionDenied, "should map permission denied errors")
+		require.ErrorContains(err, "secret access denied", "should keep the underlying secrets error")
+	})
+}
+
+func (s *gcloudStoreTestSuite) TestSecrets() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.Run("GetSecret", func() {
+		s.sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+			require.Equal("projects/project/secrets/secret-secret_id/versions/latest", req.Name, "wrong secret version name")
+			return &secretmanagerpb.AccessSecretVersionResponse{
+				Payload: &secretmanagerpb.SecretPayload{
+					Data: []byte("supersecretsquirrel"),
+				},
+			}, nil
+		}
+		defer s.sm.Reset()
+		secret, err := s.store.GetSecret(ctx, "secret_id")
+		require.NoError(err, "should be able to get a secret")
+		require.Equal([]byte("supersecretsquirrel"), secret, "wrong secret returned")
+	})
+
+	s.Run("GetSecretNotFound", func() {
+		s.sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+		defer s.sm.Reset()
+		_, err := s.store.GetSecret(ctx, "secret_id")
+		require.ErrorIs(err, store.ErrNotFound, "should return error if secret does not exist")
+	})
+
+	s.Run("UpdateSecret", func() {
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			require.Equal("projects/project/secrets/secret-secret_id", req.Parent, "wrong secret name")
+			require.Equal([]byte("supersecretsquirrel"), req.Payload.Data, "wrong secret data")
+			return &secretmanagerpb.SecretVersion{}, nil
+		}
+		defer s.sm.Reset()
+		err := s.store.UpdateSecret(ctx, "secret_id", []byte("supersecretsquirrel"))
+		require.NoError(err, "should be able to update a secret")
+	})
+
+	s.Run("DeleteSecret", func() {
+		s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			require.Equal("projects/project/secrets/secret-secret_id", req.Name, "wrong secret name")
+			return nil
+		}
+		defer s.sm.Reset()
+		err := s.store.DeleteSecret(ctx, "secret_id")
+		require.NoError(err, "should be able to delete a secret")
+	})
+
+	s.Run("DeleteSecretNotFound", func() {
+		s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			return status.Error(codes.NotFound, "not found")
+		}
+		defer s.sm.Reset()
+		err := s.store.DeleteSecret(ctx, "secret_id")
+		require.ErrorIs(err, store.ErrNotFound, "should return not found if the secret does not exist")
+	})
+}
+
+func (s *gcloudStoreTestSuite) TestAddVersionFirst() {
+	require := s.Require()
+	ctx := context.Background()
+
+	var creates, adds int
+	s.sm.OnCreateSecret = func(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+		creates++
+		return &secretmanagerpb.Secret{}, nil
+	}
+	defer s.sm.Reset()
+
+	s.Run("Exists", func() {
+		creates, adds = 0, 0
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			adds++
+			return &secretmanagerpb.SecretVersion{}, nil
+		}
+		require.NoError(s.store.UpdateCertificate(ctx, "exists", []byte("cert")))
+		require.Equal(0, creates, "should not create a secret that exists")
+		require.Equal(1, adds, "expected a single add version round trip")
+	})
+
+	s.Run("NotFound", func() {
+		creates, adds = 0, 0
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			adds++
+			if creates == 0 {
+				return nil, status.Error(codes.NotFound, "not found")
+			}
+			return &secretmanagerpb.SecretVersion{}, nil
+		}
+		require.NoError(s.store.UpdateCertificate(ctx, "new", []byte("cert")))
+		require.Equal(1, creates, "should create a secret that does not exist")
+		require.Equal(2, adds, "expected add version to be retried after create")
+	})
+
+	s.Run("Deleted", func() {
+		s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+			return nil
+		}
+		require.NoError(s.store.DeleteCertificate(ctx, "deleted"))
+
+		creates, adds = 0, 0
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			adds++
+			require.Equal(1, creates, "secret should be created before adding a version")
+			return &secretmanagerpb.SecretVersion{}, nil
+		}
+		require.NoError(s.store.UpdateCertificate(ctx, "deleted", []byte("cert")))
+		require.Equal(1, creates, "should create a secret known to be deleted")
+		require.Equal(1, adds, "expected a single add version round trip")
+	})
+}
+
+func (s *gcloudStoreTestSuite) TestWriteBatch() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+		if req.Name == "projects/project/secrets/pkcs12-batch/versions/latest" {
+			return &secretmanagerpb.AccessSecretVersionResponse{Payload: &secretmanagerpb.SecretPayload{Data: []byte("old")}}, nil
+		}
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	s.sm.OnCreateSecret = func(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+		return &secretmanagerpb.Secret{}, nil
+	}
+
+	var deleted []string
+	s.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+		deleted = append(deleted, req.Name)
+		return nil
+	}
+	defer s.sm.Reset()
+
+	s.Run("HappyPath", func() {
+		var added []string
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			added = append(added, req.Parent+"="+string(req.Payload.Data))
+			return &secretmanagerpb.SecretVersion{}, nil
+		}
+
+		batch := (&store.Batch{}).UpdateCertificate("batch", []byte("new")).UpdatePassword("batch", []byte("new"))
+		require.NoError(s.store.WriteBatch(ctx, batch), "should be able to write a batch")
+		require.Equal([]string{
+			"projects/project/secrets/certificate-batch=new",
+			"projects/project/secrets/pkcs12-batch=new",
+		}, added, "writes should be applied in order")
+	})
+
+	s.Run("Rollback", func() {
+		deleted = nil
+		var added []string
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			if req.Parent == "projects/project/secrets/pkcs12-batch" && string(req.Payload.Data) == "new" {
+				return nil, status.Error(codes.Internal, "internal error")
+			}
+			added = append(added, req.Parent+"="+string(req.Payload.Data))
+			return &secretmanagerpb.SecretVersion{}, nil
+		}
+
+		batch := (&store.Batch{}).UpdateCertificate("rollback", []byte("new")).UpdatePassword("batch", []byte("new"))
+		err := s.store.WriteBatch(ctx, batch)
+		require.Error(err, "expected the batch to fail")
+		require.NotErrorIs(err, store.ErrRollbackFailed, "expected the batch to be rolled back")
+
+		// The prior password is restored and the certificate created by the batch is deleted
+		require.Equal([]string{
+			"projects/project/secrets/certificate-rollback=new",
+			"projects/project/secrets/pkcs12-batch=old",
+		}, added, "expected the prior password to be restored")
+		require.Equal([]string{"projects/project/secrets/certificate-rollback"}, deleted, "expected the new certificate to be deleted")
+	})
+
+	s.Run("RollbackFailed", func() {
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			if req.Parent == "projects/project/secrets/pkcs12-batch" {
+				return nil, status.Error(codes.Internal, "internal error")
+			}
+			return &secretmanagerpb.SecretVersion{}, nil
+		}
+
+		batch := (&store.Batch{}).UpdatePassword("batch", []byte("new"))
+		err := s.store.WriteBatch(ctx, batch)
+		require.ErrorIs(err, store.ErrRollbackFailed, "expected the rollback to fail")
+	})
+}
+
+func (s *gcloudStoreTestSuite) TestConcurrencyTokens() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+		if req.Name == "projects/project/secrets/pkcs12-tokens/versions/latest" {
+			return &secretmanagerpb.AccessSecretVersionResponse{
+				Name:    "projects/project/secrets/pkcs12-tokens/versions/4",
+				Payload: &secretmanagerpb.SecretPayload{Data: []byte("password")},
+			}, nil
+		}
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	s.sm.OnCreateSecret = func(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+		return &secretmanagerpb.Secret{}, nil
+	}
+	s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		return &secretmanagerpb.SecretVersion{Name: req.Parent + "/versions/5"}, nil
+	}
+	defer s.sm.Reset()
+
+	password, token, err := s.store.GetPasswordWithToken(ctx, "tokens")
+	require.NoError(err, "should be able to get the password with its token")
+	require.Equal([]byte("password"), password, "wrong password returned")
+	require.Equal("4", token, "the token should be the latest version number")
+
+	_, err = s.store.CompareAndUpdatePassword(ctx, "tokens", "3", []byte("stale"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "should not update with a stale token")
+
+	_, err = s.store.CompareAndUpdatePassword(ctx, "tokens", "", []byte("stale"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "empty token should not match an existing secret")
+
+	token, err = s.store.CompareAndUpdatePassword(ctx, "tokens", "4", []byte("updated"))
+	require.NoError(err, "should update with the current token")
+	require.Equal("5", token, "should return the new version number")
+
+	_, _, err = s.store.GetCertificateWithToken(ctx, "tokens")
+	require.ErrorIs(err, store.ErrNotFound, "should return not found for a missing certificate")
+
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "tokens", "1", []byte("cert"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "should not update a missing certificate with a token")
+
+	token, err = s.store.CompareAndUpdateCertificate(ctx, "tokens", "", []byte("cert"))
+	require.NoError(err, "should create a missing certificate with an empty token")
+	require.Equal("5", token, "should return the new version number")
+}
+
+func (s *gcloudStoreTestSuite) TestConcurrentConditionalWrites() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// A conditional write to one secret should not block conditional writes to another
+	bobStarted := make(chan struct{})
+	s.sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+		switch req.Name {
+		case "projects/project/secrets/pkcs12-alice/versions/latest":
+			select {
+			case <-bobStarted:
+			case <-time.After(time.Second):
+				return nil, status.Error(codes.DeadlineExceeded, "bob was blocked by alice")
+			}
+		case "projects/project/secrets/pkcs12-bob/versions/latest":
+			close(bobStarted)
+		}
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		return &secretmanagerpb.SecretVersion{Name: req.Parent + "/versions/1"}, nil
+	}
+	defer s.sm.Reset()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := s.store.CompareAndUpdatePassword(ctx, "alice", "", []byte("alice"))
+		errs <- err
+	}()
+
+	// Wait until alice holds her lock before bob writes
+	time.Sleep(10 * time.Millisecond)
+	_, err := s.store.CompareAndUpdatePassword(ctx, "bob", "", []byte("bob"))
+	require.NoError(err, "could not update bob's password")
+	require.NoError(<-errs, "could not update alice's password")
+}
+
+func (s *gcloudStoreTestSuite) TestCheck() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// Use a client that counts reconnects
+	var dials int
+	client, err := secrets.NewClient(s.conf, secrets.WithDialer(func(context.Context) (secrets.GRPCSecretClient, error) {
+		dials++
+		return s.sm, nil
+	}))
+	require.NoError(err, "could not create mock secrets client")
+	db, err := gcloud.Open(s.conf, gcloud.WithClient(client))
+	require.NoError(err, "could not open gcloud storage backend")
+
+	s.sm.OnGetSecretVersion = func(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	defer s.sm.Reset()
+	require.NoError(db.Check(ctx), "expected healthy connection")
+
+	// Persistent transport failures cause the client to be recreated
+	s.sm.OnGetSecretVersion = func(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	}
+	for i := 0; i < 2; i++ {
+		require.ErrorIs(db.Check(ctx), store.ErrUnavailable)
+	}
+	require.Equal(1, dials, "client should not be recreated before the threshold")
+	require.ErrorIs(db.Check(ctx), store.ErrUnavailable)
+	require.Equal(2, dials, "client should be recreated after repeated failures")
+
+	// Request errors do not cause the client to be recreated
+	s.sm.OnGetSecretVersion = func(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		return nil, status.Error(codes.PermissionDenied, "denied")
+	}
+	for i := 0; i < 3; i++ {
+		require.ErrorIs(db.Check(ctx), store.ErrPermissionDenied)
+	}
+	require.Equal(2, dials, "client should not be recreated on permission errors")
+}
+
+func (s *gcloudStoreTestSuite) TestCount() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.Run("HappyPath", func() {
+		s.sm.OnListSecrets = func(ctx context.Context, req *secretmanagerpb.ListSecretsRequest, opts ...gax.CallOption) secrets.SecretIterator {
+			require.Equal("projects/project", req.Parent, "wrong parent in list request")
+			return &mock.Secrets{Secrets: []*secretmanagerpb.Secret{
+				{Name: "projects/project/secrets/certificate-alice"},
+				{Name: "projects/project/secrets/certificate-bob"},
+				{Name: "projects/project/secrets/pkcs12-alice"},
+				{Name: "projects/project/secrets/secret-signing-key"},
+				{Name: "projects/project/secrets/unrelated"},
+			}}
+		}
+		defer s.sm.Reset()
+
+		counts, err := s.store.Count(ctx)
+		require.NoError(err, "could not count secrets")
+		require.Equal(store.Counts{Certificates: 2, Passwords: 1, Secrets: 1}, counts)
+	})
+
+	s.Run("Error", func() {
+		s.sm.OnListSecrets = func(ctx context.Context, req *secretmanagerpb.ListSecretsRequest, opts ...gax.CallOption) secrets.SecretIterator {
+			return &mock.Secrets{
+				Secrets: []*secretmanagerpb.Secret{{Name: "projects/project/secrets/certificate-alice"}},
+				Err:     status.Error(codes.PermissionDenied, "denied"),
+			}
+		}
+		defer s.sm.Reset()
+
+		_, err := s.store.Count(ctx)
+		require.ErrorIs(err, store.ErrPermissionDenied, "should map errors returned while paging")
+	})
+
+	s.Run("Unsupported", func() {
+		// gRPC clients that cannot page through secrets cannot be counted
+		client, err := secrets.NewClient(s.conf, secrets.WithGRPCClient(struct{ secrets.GRPCSecretClient }{s.sm}))
+		require.NoError(err, "could not create mock secrets client")
+		db, err := gcloud.Open(s.conf, gcloud.WithClient(client))
+		require.NoError(err, "could not open gcloud storage backend")
+
+		_, err = db.Count(ctx)
+		require.ErrorIs(err, secrets.ErrListUnsupported)
+	})
+}
+
+func (s *gcloudStoreTestSuite) TestStoreErrors() {
+	require := s.Require()
+	ctx := context.Background()
+
+	s.Run("Unavailable", func() {
+		s.sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+			return nil, status.Error(codes.Unavailable, "unavailable")
+		}
+		defer s.sm.Reset()
+		_, err := s.store.GetCertificate(ctx, "cert_id")
+		require.ErrorIs(err, store.ErrUnavailable, "should map unavailable errors")
+		require.ErrorContains(err, "code = Unavailable", "should keep the underlying rpc error")
+	})
+
+	s.Run("PayloadTooLarge", func() {
+		s.sm.OnCreateSecret = func(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+			return &secretmanagerpb.Secret{}, nil
+		}
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			return nil, status.Error(codes.InvalidArgument, "too large")
+		}
+		defer s.sm.Reset()
+		err := s.store.UpdateCertificate(ctx, "cert_id", []byte("cert"))
+		require.ErrorIs(err, store.ErrPayloadTooLarge, "should map payload too large errors")
+	})
+
+	s.Run("PermissionDenied", func() {
+		s.sm.OnCreateSecret = func(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+			return &secretmanagerpb.Secret{}, nil
+		}
+		s.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+			return nil, status.Error(codes.PermissionDenied, "denied")
+		}
+		defer s.sm.Reset()
+		err := s.store.UpdatePassword(ctx, "password_id", []byte("password"))
+		require.ErrorIs(err, store.ErrPermissionDenied, "should map permission denied errors")
+		require.ErrorContains(err, "denied by store: ", "should keep the underlying error")
+	})
+
+	s.Run("Corrupted", func() {
+		checksum := int64(42)
+		s.sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+			return &secretmanagerpb.AccessSecretVersionResponse{
+				Payload: &secretmanagerpb.SecretPayload{Data: []byte("cert"), DataCrc32C: &checksum},
+			}, nil
+		}
+		defer s.sm.Reset()
+		_, err := s.store.GetCertificate(ctx, "cert_id")
+		require.ErrorIs(err, store.ErrCorrupted, "should map checksum mismatches")
+	})
+}
+
+func (s *gcloudStoreTestSuite) TestReplication() {
+	require := s.Require()
+	ctx := context.Background()
+
+	_, err := s.store.Reconcile(ctx)
+	require.ErrorIs(err, gcloud.ErrNoReplica, "should not reconcile without a replica")
+
+	primary, replica := newProject(), newProject()
+	primaryClient, err := secrets.NewClient(s.conf, secrets.WithGRPCClient(primary.sm))
+	require.NoError(err, "could not create primary secrets client")
+	replicaClient, err := secrets.NewClient(s.conf, secrets.WithGRPCClient(replica.sm))
+	require.NoError(err, "could not create replica secrets client")
+
+	db, err := gcloud.Open(s.conf, gcloud.WithClient(primaryClient), gcloud.WithReplicaClient(replicaClient))
+	require.NoError(err, "could not open replicated gcloud storage backend")
+	defer db.Close()
+
+	// Writes and deletes are applied to both projects
+	require.NoError(db.UpdateCertificate(ctx, "replicated", []byte("certificate")))
+	require.Equal([]byte("certificate"), primary.payloads["certificate-replicated"])
+	require.Equal([]byte("certificate"), replica.payloads["certificate-replicated"], "certificate should be replicated")
+
+	require.NoError(db.DeleteCertificate(ctx, "replicated"))
+	require.NotContains(replica.payloads, "certificate-replicated", "deletion should be replicated")
+
+	// Writes succeed when the replica is unavailable
+	replica.err = status.Error(codes.Unavailable, "replica is down")
+	require.NoError(db.UpdatePassword(ctx, "missed", []byte("password")), "replica failures should not fail writes")
+	require.NoError(db.UpdateSecret(ctx, "stale", []byte("updated")))
+	require.NotContains(replica.payloads, "pkcs12-missed")
+	replica.err = nil
+
+	// Reconciliation copies missing and stale courier secrets to the replica
+	replica.payloads["secret-stale"] = []byte("original")
+	primary.payloads["unrelated"] = []byte("not created by courier")
+
+	copied, err := db.Reconcile(ctx)
+	require.NoError(err, "could not reconcile the replica")
+	require.Equal(2, copied, "expected the missing and stale secrets to be copied")
+	require.Equal([]byte("password"), replica.payloads["pkcs12-missed"])
+	require.Equal([]byte("updated"), replica.payloads["secret-stale"])
+	require.NotContains(replica.payloads, "unrelated", "only courier secrets should be replicated")
+
+	// Secrets that are only in the replica are kept
+	replica.payloads["certificate-recovered"] = []byte("certificate")
+	copied, err = db.Reconcile(ctx)
+	require.NoError(err, "could not reconcile the replica")
+	require.Zero(copied, "the replica should be up to date")
+	require.Contains(replica.payloads, "certificate-recovered", "reconciliation should not delete secrets from the replica")
+
+	// Reads fail over to the replica when the primary is unavailable
+	primary.err = status.Error(codes.Unavailable, "primary is down")
+	password, err := db.GetPassword(ctx, "missed")
+	require.NoError(err, "expected the read to fail over to the replica")
+	require.Equal([]byte("password"), password)
+
+	cert, err := db.GetCertificate(ctx, "recovered")
+	require.NoError(err, "expected the read to fail over to the replica")
+	require.Equal([]byte("certificate"), cert)
+
+	_, err = db.GetSecret(ctx, "missing")
+	require.ErrorIs(err, store.ErrUnavailable, "the error of the primary should be returned if the replica cannot serve the read")
+	primary.err = nil
+
+	// Resources that are not found in the primary are not read from the replica
+	_, err = db.GetCertificate(ctx, "recovered")
+	require.ErrorIs(err, store.ErrNotFound)
+}
+
+// project is a secret manager mock that keeps the latest payload of each secret.
+type project struct {
+	sm       *mock.SecretManager
+	payloads map[string][]byte
+	err      error
+}
+
+func newProject() *project {
+	p := &project{sm: mock.New(), payloads: make(map[string][]byte)}
+	created := make(map[string]bool)
+
+	p.sm.OnCreateSecret = func(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+		if p.err != nil {
+			return nil, p.err
+		}
+		created[req.SecretId] = true
+		return &secretmanagerpb.Secret{Name: req.Parent + "/secrets/" + req.SecretId}, nil
+	}
+
+	p.sm.OnAddSecretVersion = func(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+		name := path.Base(req.Parent)
+		switch {
+		case p.err != nil:
+			return nil, p.err
+		case !created[name] && p.payloads[name] == nil:
+			return nil, status.Error(codes.NotFound, "secret not found")
+		}
+		p.payloads[name] = req.Payload.Data
+		return &secretmanagerpb.SecretVersion{Name: req.Parent + "/versions/1"}, nil
+	}
+
+	p.sm.OnAccessSecretVersion = func(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+		if p.err != nil {
+			return nil, p.err
+		}
+
+		data, ok := p.payloads[path.Base(path.Dir(path.Dir(req.Name)))]
+		if !ok {
+			return nil, status.Error(codes.NotFound, "secret not found")
+		}
+		return &secretmanagerpb.AccessSecretVersionResponse{Name: req.Name, Payload: &secretmanagerpb.SecretPayload{Data: data}}, nil
+	}
+
+	p.sm.OnDeleteSecret = func(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+		name := path.Base(req.Name)
+		if _, ok := p.payloads[name]; !ok {
+			return status.Error(codes.NotFound, "secret not found")
+		}
+		delete(p.payloads, name)
+		delete(created, name)
+		return nil
+	}
+
+	p.sm.OnListSecrets = func(ctx context.Context, req *secretmanagerpb.ListSecretsRequest, opts ...gax.CallOption) secrets.SecretIterator {
+		it := &mock.Secrets{}
+		for name := range p.payloads {
+			it.Secrets = append(it.Secrets, &secretmanagerpb.Secret{Name: req.Parent + "/secrets/" + name})
+		}
+		return it
+	}
+	return p
+}
@@ -0,0 +1,53 @@
+package gcs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/store"
+	"google.golang.org/api/googleapi"
+)
+
+// Header with the generation of a downloaded object.
+const headerGeneration = "X-Goog-Generation"
+
+var (
+	ErrMissingGeneration = errors.New("cloud storage did not return the generation of the object")
+	ErrInvalidPrivateKey = errors.New("gcs credentials do not have a valid rsa private key")
+	ErrCannotSign        = errors.New("gcs credentials have no private key and no signer is configured to sign urls")
+)
+
+// Maps cloud storage api errors to the typed errors exported by the store. Errors that
+// are not api errors occurred before a response was received, e.g. a timeout, and are
+// reported as unavailable.
+func storeError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("%w: %w", store.ErrUnavailable, err)
+	}
+
+	switch {
+	case apiErr.Code == http.StatusNotFound:
+		return fmt.Errorf("%w: %w", store.ErrNotFound, err)
+	case apiErr.Code == http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %w", store.ErrVersionMismatch, err)
+	case apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden:
+		return fmt.Errorf("%w: %w", store.ErrPermissionDenied, err)
+	case apiErr.Code == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %w", store.ErrPayloadTooLarge, err)
+	case apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500:
+		return fmt.Errorf("%w: %w", store.ErrUnavailable, err)
+	}
+	return err
+}
+
+// Parses the RFC 3339 timestamps returned by the api.
+func parseTime(ts string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, ts)
+}
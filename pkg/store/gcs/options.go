@@ -0,0 +1,47 @@
+package gcs
+
+import (
+	"context"
+	"crypto/rsa"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// StoreOption allows us to configure the store when it is created.
+type StoreOption func(s *Store) error
+
+// WithClient uses the cloud storage client rather than creating one from the
+// configured credentials.
+func WithClient(client *storage.Service) StoreOption {
+	return func(s *Store) error {
+		s.client = client
+		return nil
+	}
+}
+
+// WithEndpoint sends unauthenticated requests to the endpoint with the http client,
+// e.g. for tests or a cloud storage emulator. Signed urls also download from the
+// endpoint.
+func WithEndpoint(endpoint string, client *http.Client) StoreOption {
+	return func(s *Store) (err error) {
+		s.endpoint = endpoint
+		s.client, err = storage.NewService(context.Background(),
+			option.WithEndpoint(strings.TrimSuffix(endpoint, "/")+"/storage/v1/"),
+			option.WithHTTPClient(client),
+			option.WithoutAuthentication(),
+		)
+		return err
+	}
+}
+
+// WithSigningKey signs urls as the service account with its private key rather than
+// with the configured credentials.
+func WithSigningKey(email string, key *rsa.PrivateKey) StoreOption {
+	return func(s *Store) error {
+		s.signer = keySigner(email, key)
+		return nil
+	}
+}
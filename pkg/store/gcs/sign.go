@@ -0,0 +1,214 @@
+package gcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/config"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+// Signed urls are signed with V4 signatures, which use the same canonical request and
+// uri encoding as aws signatures, see
+// https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+const (
+	signAlgorithm  = "GOOG4-RSA-SHA256"
+	signDate       = "20060102T150405Z"
+	signScopeDate  = "20060102"
+	signTerminator = "auto/storage/goog4_request"
+	signEndpoint   = "https://storage.googleapis.com"
+
+	// The payload is not known when the url is signed.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// signer signs urls as a service account, either with the private key of the service
+// account or with the iam credentials api if the private key is not available, e.g.
+// with application default credentials on gce or gke.
+type signer struct {
+	email string
+	sign  func(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// keySigner signs urls with the private key of the service account.
+func keySigner(email string, key *rsa.PrivateKey) *signer {
+	return &signer{
+		email: email,
+		sign: func(_ context.Context, data []byte) ([]byte, error) {
+			digest := sha256.Sum256(data)
+			return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		},
+	}
+}
+
+// newSigner returns the signer of the service account in the credentials file if it
+// has a private key, otherwise the signer of the configured service account using the
+// iam credentials api. If neither is available the signer is nil and urls cannot be
+// signed.
+func newSigner(conf config.GCSConfig) (_ *signer, err error) {
+	if conf.Credentials != "" {
+		var data []byte
+		if data, err = os.ReadFile(conf.Credentials); err != nil {
+			return nil, err
+		}
+
+		var creds struct {
+			ClientEmail string `json:"client_email"`
+			PrivateKey  string `json:"private_key"`
+		}
+		if err = json.Unmarshal(data, &creds); err != nil {
+			return nil, fmt.Errorf("could not parse gcs credentials: %w", err)
+		}
+
+		if creds.PrivateKey != "" && (conf.Signer == "" || conf.Signer == creds.ClientEmail) {
+			var key *rsa.PrivateKey
+			if key, err = parsePrivateKey(creds.PrivateKey); err != nil {
+				return nil, err
+			}
+			return keySigner(creds.ClientEmail, key), nil
+		}
+	}
+
+	if conf.Signer == "" {
+		return nil, nil
+	}
+
+	opts := []option.ClientOption{option.WithScopes(iamcredentials.CloudPlatformScope)}
+	if conf.Credentials != "" {
+		opts = append(opts, option.WithCredentialsFile(conf.Credentials))
+	}
+
+	var client *iamcredentials.Service
+	if client, err = iamcredentials.NewService(context.Background(), opts...); err != nil {
+		return nil, err
+	}
+
+	name := "projects/-/serviceAccounts/" + conf.Signer
+	return &signer{
+		email: conf.Signer,
+		sign: func(ctx context.Context, data []byte) (_ []byte, err error) {
+			var rep *iamcredentials.SignBlobResponse
+			if rep, err = client.Projects.ServiceAccounts.SignBlob(name, &iamcredentials.SignBlobRequest{
+				Payload: base64.StdEncoding.EncodeToString(data),
+			}).Context(ctx).Do(); err != nil {
+				return nil, storeError(err)
+			}
+			return base64.StdEncoding.DecodeString(rep.SignedBlob)
+		},
+	}, nil
+}
+
+// signURL returns a url that downloads the live generation of the object from the
+// endpoint without credentials until the ttl expires. The query is signed with the url,
+// e.g. to override the headers of the response.
+func (s *signer) signURL(ctx context.Context, endpoint, bucket, key string, query url.Values, ttl time.Duration, now time.Time) (_ string, err error) {
+	var u *url.URL
+	if u, err = url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + uriEncode(bucket, false) + "/" + uriEncode(key, false)); err != nil {
+		return "", err
+	}
+
+	now = now.UTC()
+	scope := now.Format(signScopeDate) + "/" + signTerminator
+
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("X-Goog-Algorithm", signAlgorithm)
+	query.Set("X-Goog-Credential", s.email+"/"+scope)
+	query.Set("X-Goog-Date", now.Format(signDate))
+	query.Set("X-Goog-Expires", strconv.FormatInt(int64(ttl/time.Second), 10))
+	query.Set("X-Goog-SignedHeaders", "host")
+
+	signedQuery := canonicalQuery(query)
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		u.EscapedPath(),
+		signedQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	stringToSign := strings.Join([]string{signAlgorithm, now.Format(signDate), scope, hashHex(canonicalRequest)}, "\n")
+
+	var signature []byte
+	if signature, err = s.sign(ctx, []byte(stringToSign)); err != nil {
+		return "", err
+	}
+
+	u.RawQuery = signedQuery + "&X-Goog-Signature=" + hex.EncodeToString(signature)
+	return u.String(), nil
+}
+
+// canonicalQuery encodes the query sorted by key and value with every value, even if
+// empty.
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode encodes every byte except the unreserved characters, and slashes if the
+// value is not a query parameter, as required by V4 signatures.
+func uriEncode(value string, query bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '.', b == '_', b == '~':
+			sb.WriteByte(b)
+		case b == '/' && !query:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// hashHex returns the hex encoded sha256 hash of the data.
+func hashHex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// parsePrivateKey parses the pem encoded pkcs8 or pkcs1 rsa private key of a service
+// account.
+func parsePrivateKey(data string) (_ *rsa.PrivateKey, err error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	var key interface{}
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, ErrInvalidPrivateKey
+		}
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidPrivateKey
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,544 @@
+/*
+Package gcs implements a storage backend that stores certificates, passwords, and
+secrets as objects in a Google Cloud Storage bucket. It is a middle ground between the
+local disk and Secret Manager: objects are durable and access controlled by IAM but are
+not subject to Secret Manager's 64KiB payload limit. Objects are encrypted with a
+customer managed encryption key (CMEK) if one is configured.
+
+Each resource is stored under the configured prefix at {prefix}{resource}/{id}, e.g.
+certificate/{id}. The bucket should have object versioning enabled so that prior
+versions are kept as noncurrent generations; the generation number of an object
+identifies each version and the generation of the live object is the concurrency token,
+which is enforced with generation preconditions.
+*/
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// Open the google cloud storage bucket backend.
+func Open(conf config.GCSConfig, opts ...StoreOption) (s *Store, err error) {
+	s = &Store{conf: conf, endpoint: signEndpoint}
+	for _, opt := range opts {
+		if err = opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.signer == nil {
+		if s.signer, err = newSigner(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.client == nil {
+		clientOpts := []option.ClientOption{option.WithScopes(storage.DevstorageReadWriteScope)}
+		if conf.Credentials != "" {
+			clientOpts = append(clientOpts, option.WithCredentialsFile(conf.Credentials))
+		}
+
+		if s.client, err = storage.NewService(context.Background(), clientOpts...); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Store implements the store.Store interface using a google cloud storage bucket.
+type Store struct {
+	conf     config.GCSConfig
+	client   *storage.Service
+	signer   *signer
+	endpoint string
+}
+
+var (
+	_ store.Store         = &Store{}
+	_ store.HealthChecker = &Store{}
+	_ store.PublicStore   = &Store{}
+)
+
+// Close the google cloud storage bucket backend.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Check that the objects in the bucket can be listed.
+func (s *Store) Check(ctx context.Context) (err error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err = s.client.Objects.List(s.conf.Bucket).Prefix(s.conf.Prefix).MaxResults(1).Fields("items(name)").Context(ctx).Do()
+	return storeError(err)
+}
+
+// Count the live resources stored in the bucket.
+func (s *Store) Count(ctx context.Context) (counts store.Counts, err error) {
+	for _, count := range []struct {
+		prefix string
+		n      *int
+	}{
+		{store.CertificatePrefix, &counts.Certificates},
+		{store.PasswordPrefix, &counts.Passwords},
+		{store.SecretPrefix, &counts.Secrets},
+	} {
+		call := s.client.Objects.List(s.conf.Bucket).Prefix(s.conf.Prefix+count.prefix+"/").Fields("items(name)", "nextPageToken")
+		if err = call.Pages(ctx, func(objects *storage.Objects) error {
+			*count.n += len(objects.Items)
+			return nil
+		}); err != nil {
+			return counts, storeError(err)
+		}
+	}
+	return counts, nil
+}
+
+// List the ids of the live resources of the type stored in the bucket.
+func (s *Store) List(ctx context.Context, prefix string) (ids []string, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	call := s.client.Objects.List(s.conf.Bucket).Prefix(s.key(prefix, "")).Fields("items(name)", "nextPageToken")
+	if err = call.Pages(ctx, func(objects *storage.Objects) error {
+		for _, obj := range objects.Items {
+			ids = append(ids, strings.TrimPrefix(obj.Name, s.key(prefix, "")))
+		}
+		return nil
+	}); err != nil {
+		return nil, storeError(err)
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Exists checks if the object of the resource is in the bucket by reading its metadata
+// without downloading it.
+func (s *Store) Exists(ctx context.Context, prefix, name string) (_ bool, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return false, err
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err = s.client.Objects.Get(s.conf.Bucket, s.key(prefix, name)).Context(ctx).Do()
+	return store.Found(storeError(err))
+}
+
+// Modified returns when the live generation of the object was created.
+func (s *Store) Modified(ctx context.Context, prefix, name string) (_ time.Time, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return time.Time{}, err
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var obj *storage.Object
+	if obj, err = s.client.Objects.Get(s.conf.Bucket, s.key(prefix, name)).Context(ctx).Do(); err != nil {
+		return time.Time{}, storeError(err)
+	}
+	return parseTime(obj.TimeCreated)
+}
+
+// Delete the resource like the delete method of its type.
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return store.DeleteResource(ctx, s, prefix, name)
+}
+
+// WriteBatch applies the writes in the batch to the bucket. Cloud storage does not
+// support transactions, so if a write fails the writes that were already applied are
+// compensated: the prior payload of each resource is written again and resources that
+// were created by the batch are deleted. Compensating writes add new generations, and
+// concurrent readers may observe the intermediate state.
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) (err error) {
+	var undo store.Undo
+	for _, op := range batch.Ops() {
+		key := s.key(op.Prefix, op.Name)
+
+		// Read the current payload so that the write can be reverted
+		var prior []byte
+		if prior, _, err = s.get(ctx, key, 0); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return undo.Rollback(err)
+		}
+		undo.Push(s.revert(ctx, key, prior, err == nil))
+
+		if op.Delete {
+			err = s.delete(ctx, key, 0)
+		} else {
+			_, err = s.put(ctx, key, op.Data, nil)
+		}
+
+		if err != nil {
+			return undo.Rollback(err)
+		}
+	}
+	return nil
+}
+
+//===========================================================================
+// Password Methods
+//===========================================================================
+
+// GetPassword retrieves a password by id from the bucket.
+func (s *Store) GetPassword(ctx context.Context, id string) (password []byte, err error) {
+	password, _, err = s.get(ctx, s.key(store.PasswordPrefix, id), 0)
+	return password, err
+}
+
+// GetPasswordVersion retrieves a password by id and generation from the bucket.
+func (s *Store) GetPasswordVersion(ctx context.Context, id, version string) (password []byte, err error) {
+	return s.getVersion(ctx, s.key(store.PasswordPrefix, id), version)
+}
+
+// GetPasswordWithToken retrieves a password by id along with the generation of its
+// object, which is used as the concurrency token.
+func (s *Store) GetPasswordWithToken(ctx context.Context, id string) (password []byte, token string, err error) {
+	return s.getWithToken(ctx, s.key(store.PasswordPrefix, id))
+}
+
+// UpdatePassword writes a new generation of a password by id to the bucket.
+func (s *Store) UpdatePassword(ctx context.Context, id string, password []byte) (err error) {
+	_, err = s.put(ctx, s.key(store.PasswordPrefix, id), password, nil)
+	return err
+}
+
+// CompareAndUpdatePassword writes a new generation of a password by id if its object
+// has not been modified since the token was issued and returns the new token.
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, id, token string, password []byte) (string, error) {
+	return s.update(ctx, s.key(store.PasswordPrefix, id), token, password)
+}
+
+// DeletePassword deletes a password by id from the bucket.
+func (s *Store) DeletePassword(ctx context.Context, id string) error {
+	return s.delete(ctx, s.key(store.PasswordPrefix, id), 0)
+}
+
+// PrunePasswordVersions permanently deletes all but the keep most recent generations
+// of a password.
+func (s *Store) PrunePasswordVersions(ctx context.Context, id string, keep int) error {
+	return s.prune(ctx, s.key(store.PasswordPrefix, id), keep)
+}
+
+//===========================================================================
+// Certificate Methods
+//===========================================================================
+
+// GetCertificate retrieves a certificate by id from the bucket.
+func (s *Store) GetCertificate(ctx context.Context, id string) (cert []byte, err error) {
+	cert, _, err = s.get(ctx, s.key(store.CertificatePrefix, id), 0)
+	return cert, err
+}
+
+// GetCertificateVersion retrieves a certificate by id and generation from the bucket.
+func (s *Store) GetCertificateVersion(ctx context.Context, id, version string) (cert []byte, err error) {
+	return s.getVersion(ctx, s.key(store.CertificatePrefix, id), version)
+}
+
+// GetCertificateWithToken retrieves a certificate by id along with the generation of
+// its object, which is used as the concurrency token.
+func (s *Store) GetCertificateWithToken(ctx context.Context, id string) (cert []byte, token string, err error) {
+	return s.getWithToken(ctx, s.key(store.CertificatePrefix, id))
+}
+
+// ListCertificateVersions returns the live and noncurrent generations of a certificate
+// by id, newest first.
+func (s *Store) ListCertificateVersions(ctx context.Context, id string) (_ []store.Version, err error) {
+	var objects []*storage.Object
+	if objects, err = s.versions(ctx, s.key(store.CertificatePrefix, id)); err != nil {
+		return nil, err
+	}
+
+	// Certificates that have been deleted do not exist even if generations are kept
+	if len(objects) == 0 || objects[0].TimeDeleted != "" {
+		return nil, store.ErrNotFound
+	}
+
+	versions := make([]store.Version, 0, len(objects))
+	for _, obj := range objects {
+		version := store.Version{Version: strconv.FormatInt(obj.Generation, 10), State: "enabled"}
+		version.Created, _ = parseTime(obj.TimeCreated)
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// UpdateCertificate writes a new generation of a certificate by id to the bucket.
+func (s *Store) UpdateCertificate(ctx context.Context, id string, cert []byte) (err error) {
+	_, err = s.put(ctx, s.key(store.CertificatePrefix, id), cert, nil)
+	return err
+}
+
+// CompareAndUpdateCertificate writes a new generation of a certificate by id if its
+// object has not been modified since the token was issued and returns the new token.
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, id, token string, cert []byte) (string, error) {
+	return s.update(ctx, s.key(store.CertificatePrefix, id), token, cert)
+}
+
+// DeleteCertificate deletes a certificate by id from the bucket.
+func (s *Store) DeleteCertificate(ctx context.Context, id string) error {
+	return s.delete(ctx, s.key(store.CertificatePrefix, id), 0)
+}
+
+// PruneCertificateVersions permanently deletes all but the keep most recent
+// generations of a certificate.
+func (s *Store) PruneCertificateVersions(ctx context.Context, id string, keep int) error {
+	return s.prune(ctx, s.key(store.CertificatePrefix, id), keep)
+}
+
+//===========================================================================
+// Secret Methods
+//===========================================================================
+
+// GetSecret retrieves a secret by name from the bucket.
+func (s *Store) GetSecret(ctx context.Context, name string) (data []byte, err error) {
+	data, _, err = s.get(ctx, s.key(store.SecretPrefix, name), 0)
+	return data, err
+}
+
+// UpdateSecret writes a new generation of a secret by name to the bucket.
+func (s *Store) UpdateSecret(ctx context.Context, name string, data []byte) (err error) {
+	_, err = s.put(ctx, s.key(store.SecretPrefix, name), data, nil)
+	return err
+}
+
+// DeleteSecret deletes a secret by name from the bucket.
+func (s *Store) DeleteSecret(ctx context.Context, name string) error {
+	return s.delete(ctx, s.key(store.SecretPrefix, name), 0)
+}
+
+//===========================================================================
+// Object Methods
+//===========================================================================
+
+// Publish writes the artifact to {prefix}public/{name} and returns a V4 signed url that
+// downloads it with the content type until the ttl expires, which is at most seven
+// days. The url is signed with the private key in the credentials file or, if there is
+// none, by the configured signer service account with the iam credentials api.
+func (s *Store) Publish(ctx context.Context, name string, data []byte, contentType string, ttl time.Duration) (_ string, err error) {
+	if s.signer == nil {
+		return "", ErrCannotSign
+	}
+
+	key := s.key(store.PublicPrefix, name)
+	if _, err = s.put(ctx, key, data, nil); err != nil {
+		return "", err
+	}
+	return s.signer.signURL(ctx, s.endpoint, s.conf.Bucket, key, url.Values{"response-content-type": {contentType}}, ttl, time.Now())
+}
+
+// PutObject writes the data to the object at the key relative to the bucket rather than
+// under the prefix of the stored resources, e.g. to keep a snapshot of the store.
+func (s *Store) PutObject(ctx context.Context, key string, data []byte) (err error) {
+	_, err = s.put(ctx, key, data, nil)
+	return err
+}
+
+// GetObject returns the data of the object at the key relative to the bucket.
+func (s *Store) GetObject(ctx context.Context, key string) (data []byte, err error) {
+	data, _, err = s.get(ctx, key, 0)
+	return data, err
+}
+
+//===========================================================================
+// Helper methods
+//===========================================================================
+
+// key returns the object name of the resource.
+func (s *Store) key(prefix, id string) string {
+	return s.conf.Prefix + prefix + "/" + id
+}
+
+// withTimeout bounds a single api call with the configured timeout.
+func (s *Store) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.conf.Timeout > 0 {
+		return context.WithTimeout(ctx, s.conf.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// get downloads the generation of the object, or the live object if the generation is
+// zero, and returns its payload and generation.
+func (s *Store) get(ctx context.Context, key string, generation int64) (data []byte, _ int64, err error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	call := s.client.Objects.Get(s.conf.Bucket, key).Context(ctx)
+	if generation != 0 {
+		call = call.Generation(generation)
+	}
+
+	var rep *http.Response
+	if rep, err = call.Download(); err != nil {
+		return nil, 0, storeError(err)
+	}
+	defer rep.Body.Close()
+
+	if data, err = io.ReadAll(rep.Body); err != nil {
+		return nil, 0, storeError(err)
+	}
+
+	if generation, err = strconv.ParseInt(rep.Header.Get(headerGeneration), 10, 64); err != nil {
+		return nil, 0, ErrMissingGeneration
+	}
+	return data, generation, nil
+}
+
+// getVersion downloads the object by generation, or the live object if the version is
+// the latest version. Invalid generations are not found.
+func (s *Store) getVersion(ctx context.Context, key, version string) (data []byte, err error) {
+	var generation int64
+	if version != store.LatestVersion {
+		if generation, err = strconv.ParseInt(version, 10, 64); err != nil || generation <= 0 {
+			return nil, store.ErrNotFound
+		}
+	}
+
+	data, _, err = s.get(ctx, key, generation)
+	return data, err
+}
+
+// getWithToken downloads the live object and returns its generation as the token.
+func (s *Store) getWithToken(ctx context.Context, key string) (_ []byte, token string, err error) {
+	var (
+		data       []byte
+		generation int64
+	)
+	if data, generation, err = s.get(ctx, key, 0); err != nil {
+		return nil, "", err
+	}
+	return data, strconv.FormatInt(generation, 10), nil
+}
+
+// put uploads a new generation of the object, encrypted with the configured key. If
+// the generation is not nil the upload is only applied if it matches the generation of
+// the live object, where zero matches an object that does not exist.
+func (s *Store) put(ctx context.Context, key string, data []byte, generation *int64) (_ int64, err error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	call := s.client.Objects.Insert(s.conf.Bucket, &storage.Object{Name: key}).
+		Media(bytes.NewReader(data), googleapi.ContentType("application/octet-stream")).
+		Context(ctx)
+
+	if s.conf.KMSKeyName != "" {
+		call = call.KmsKeyName(s.conf.KMSKeyName)
+	}
+
+	if generation != nil {
+		call = call.IfGenerationMatch(*generation)
+	}
+
+	var obj *storage.Object
+	if obj, err = call.Do(); err != nil {
+		return 0, storeError(err)
+	}
+	return obj.Generation, nil
+}
+
+// update uploads the payload if the token matches the generation of the live object,
+// where an empty token only matches a resource that does not exist.
+func (s *Store) update(ctx context.Context, key, token string, data []byte) (_ string, err error) {
+	var generation int64
+	if token != "" {
+		if generation, err = strconv.ParseInt(token, 10, 64); err != nil || generation <= 0 {
+			return "", store.ErrVersionMismatch
+		}
+	}
+
+	if generation, err = s.put(ctx, key, data, &generation); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return "", store.ErrVersionMismatch
+		}
+		return "", err
+	}
+	return strconv.FormatInt(generation, 10), nil
+}
+
+// delete deletes the generation of the object, or the live object if the generation
+// is zero, which is kept as a noncurrent generation if versioning is enabled.
+func (s *Store) delete(ctx context.Context, key string, generation int64) (err error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	call := s.client.Objects.Delete(s.conf.Bucket, key).Context(ctx)
+	if generation != 0 {
+		call = call.Generation(generation)
+	}
+	return storeError(call.Do())
+}
+
+// versions returns the live and noncurrent generations of the object, newest first.
+func (s *Store) versions(ctx context.Context, key string) (objects []*storage.Object, err error) {
+	call := s.client.Objects.List(s.conf.Bucket).Prefix(key).Versions(true).
+		Fields("items(name,generation,timeCreated,timeDeleted)", "nextPageToken")
+
+	if err = call.Pages(ctx, func(page *storage.Objects) error {
+		for _, obj := range page.Items {
+			if obj.Name == key {
+				objects = append(objects, obj)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, storeError(err)
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Generation > objects[j].Generation })
+	return objects, nil
+}
+
+// prune permanently deletes all but the keep most recent generations of the object.
+func (s *Store) prune(ctx context.Context, key string, keep int) (err error) {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+
+	var objects []*storage.Object
+	if objects, err = s.versions(ctx, key); err != nil {
+		return err
+	}
+
+	for i := keep; i < len(objects); i++ {
+		if err = s.delete(ctx, key, objects[i].Generation); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// revert returns a function that restores the object to the prior payload, or deletes
+// it if it did not exist. The rollback is not cancelled with the request context since
+// abandoning it would leave the store inconsistent; each call is still bounded by the
+// configured timeout.
+func (s *Store) revert(ctx context.Context, key string, prior []byte, existed bool) func() error {
+	ctx = context.WithoutCancel(ctx)
+	return func() (err error) {
+		if !existed {
+			if err = s.delete(ctx, key, 0); errors.Is(err, store.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		_, err = s.put(ctx, key, prior, nil)
+		return err
+	}
+}
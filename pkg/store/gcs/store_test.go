@@ -0,0 +1,574 @@
+package gcs_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/gcs"
+)
+
+const kmsKeyName = "projects/courier/locations/global/keyRings/courier/cryptoKeys/deliveries"
+
+const signerEmail = "courier@courier.iam.gserviceaccount.com"
+
+type gcsStoreTestSuite struct {
+	suite.Suite
+	api   *fakeGCS
+	srv   *httptest.Server
+	store *gcs.Store
+}
+
+func (s *gcsStoreTestSuite) SetupSuite() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	s.NoError(err, "could not generate signing key")
+
+	s.api = newFakeGCS("courier")
+	s.api.signer = &key.PublicKey
+	s.srv = httptest.NewServer(s.api)
+
+	conf := config.GCSConfig{Enabled: true, Bucket: "courier", Prefix: "deliveries/", KMSKeyName: kmsKeyName, Timeout: 5 * time.Second}
+	s.store, err = gcs.Open(conf, gcs.WithEndpoint(s.srv.URL, s.srv.Client()), gcs.WithSigningKey(signerEmail, key))
+	s.NoError(err, "could not open gcs storage backend")
+}
+
+func (s *gcsStoreTestSuite) TearDownSuite() {
+	s.NoError(s.store.Close(), "could not close gcs storage backend")
+	s.srv.Close()
+}
+
+func (s *gcsStoreTestSuite) SetupTest() {
+	s.api.reset()
+}
+
+func TestGCSStore(t *testing.T) {
+	suite.Run(t, new(gcsStoreTestSuite))
+}
+
+func TestSigningCredentials(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err, "could not generate signing key")
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	api := newFakeGCS("courier")
+	api.signer = &key.PublicKey
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	// The url is signed with the private key of the service account in the credentials
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	creds, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": signerEmail,
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	require.NoError(t, os.WriteFile(path, creds, 0600))
+
+	db, err := gcs.Open(config.GCSConfig{Enabled: true, Bucket: "courier", Credentials: path}, gcs.WithEndpoint(srv.URL, srv.Client()))
+	require.NoError(t, err, "could not open gcs storage backend")
+
+	signed, err := db.Publish(context.Background(), "cert-id.pem", []byte("chain"), "application/x-pem-file", time.Minute)
+	require.NoError(t, err, "could not publish artifact")
+
+	rep, err := http.Get(signed)
+	require.NoError(t, err)
+	rep.Body.Close()
+	require.Equal(t, http.StatusOK, rep.StatusCode, "url was not signed with the private key")
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"client_email": "courier", "private_key": "not a key"}`), 0600))
+	_, err = gcs.Open(config.GCSConfig{Enabled: true, Bucket: "courier", Credentials: path}, gcs.WithEndpoint(srv.URL, srv.Client()))
+	require.ErrorIs(t, err, gcs.ErrInvalidPrivateKey)
+}
+
+func (s *gcsStoreTestSuite) TestPasswordStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	_, err := s.store.GetPassword(ctx, "does-not-exist")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if password does not exist")
+
+	password := []byte("password")
+	require.NoError(s.store.UpdatePassword(ctx, "password-id", password), "should be able to create a password")
+
+	actual, err := s.store.GetPassword(ctx, "password-id")
+	require.NoError(err, "should be able to get a password")
+	require.Equal(password, actual, "wrong password returned")
+
+	// The password should be written under the prefix with the customer managed key
+	obj, ok := s.api.live("deliveries/pkcs12/password-id")
+	require.True(ok, "password object was not created")
+	require.Equal(kmsKeyName, obj.kmsKeyName)
+
+	require.NoError(s.store.UpdatePassword(ctx, "password-id", []byte("updated")), "should be able to update a password")
+	actual, err = s.store.GetPassword(ctx, "password-id")
+	require.NoError(err, "should be able to get a password")
+	require.Equal([]byte("updated"), actual, "password was not updated")
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err, "should be able to count the store")
+	require.Equal(1, counts.Passwords, "wrong number of passwords counted")
+
+	// Prior generations are kept by the bucket
+	actual, err = s.store.GetPasswordVersion(ctx, "password-id", strconv.FormatInt(obj.generation, 10))
+	require.NoError(err, "should be able to get a prior generation")
+	require.Equal(password, actual)
+
+	_, err = s.store.GetPasswordVersion(ctx, "password-id", "not-a-generation")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if the generation is invalid")
+
+	require.ErrorIs(s.store.PrunePasswordVersions(ctx, "password-id", 0), store.ErrInvalidKeep)
+	require.NoError(s.store.PrunePasswordVersions(ctx, "password-id", 1))
+	_, err = s.store.GetPasswordVersion(ctx, "password-id", strconv.FormatInt(obj.generation, 10))
+	require.ErrorIs(err, store.ErrNotFound, "pruned generation should not exist")
+
+	require.NoError(s.store.DeletePassword(ctx, "password-id"), "should be able to delete a password")
+	_, err = s.store.GetPassword(ctx, "password-id")
+	require.ErrorIs(err, store.ErrNotFound, "password should not exist after delete")
+	require.ErrorIs(s.store.DeletePassword(ctx, "password-id"), store.ErrNotFound, "should return error if password does not exist")
+}
+
+func (s *gcsStoreTestSuite) TestCertificateStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	_, err := s.store.ListCertificateVersions(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if certificate does not exist")
+
+	// An empty token only matches a certificate that does not exist
+	token, err := s.store.CompareAndUpdateCertificate(ctx, "cert-id", "", []byte("certificate"))
+	require.NoError(err, "should be able to create a certificate")
+	require.NotEmpty(token, "expected a token to be returned")
+
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", "", []byte("certificate"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "empty token should not match an existing certificate")
+
+	cert, current, err := s.store.GetCertificateWithToken(ctx, "cert-id")
+	require.NoError(err, "should be able to get a certificate with its token")
+	require.Equal([]byte("certificate"), cert)
+	require.Equal(token, current, "wrong token returned")
+
+	// The token should be invalidated by an update
+	require.NoError(s.store.UpdateCertificate(ctx, "cert-id", []byte("renewed")))
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", token, []byte("stale"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "stale token should not match")
+
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", "not-a-generation", []byte("stale"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "invalid token should not match")
+
+	_, current, err = s.store.GetCertificateWithToken(ctx, "cert-id")
+	require.NoError(err)
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", current, []byte("current"))
+	require.NoError(err, "current token should match")
+
+	cert, err = s.store.GetCertificateVersion(ctx, "cert-id", store.LatestVersion)
+	require.NoError(err, "should be able to get the latest version")
+	require.Equal([]byte("current"), cert)
+
+	versions, err := s.store.ListCertificateVersions(ctx, "cert-id")
+	require.NoError(err, "should be able to list certificate versions")
+	require.Len(versions, 3)
+	for i := 1; i < len(versions); i++ {
+		require.Greater(versions[i-1].Version, versions[i].Version, "versions should be newest first")
+	}
+
+	// Deleted certificates do not have versions even though the generations are kept
+	require.NoError(s.store.DeleteCertificate(ctx, "cert-id"))
+	_, err = s.store.ListCertificateVersions(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "deleted certificate should have no versions")
+}
+
+func (s *gcsStoreTestSuite) TestSecretStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// Payloads are not limited to secret manager's 64KiB
+	data := []byte(strings.Repeat("s", 128*1024))
+	require.NoError(s.store.UpdateSecret(ctx, "webhook", data))
+	actual, err := s.store.GetSecret(ctx, "webhook")
+	require.NoError(err)
+	require.Equal(data, actual)
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err)
+	require.Equal(store.Counts{Secrets: 1}, counts)
+
+	exists, err := s.store.Exists(ctx, store.SecretPrefix, "webhook")
+	require.NoError(err)
+	require.True(exists, "expected the secret to exist")
+
+	require.NoError(s.store.Delete(ctx, store.SecretPrefix, "webhook"))
+	_, err = s.store.GetSecret(ctx, "webhook")
+	require.ErrorIs(err, store.ErrNotFound)
+
+	exists, err = s.store.Exists(ctx, store.SecretPrefix, "webhook")
+	require.NoError(err)
+	require.False(exists, "expected the deleted secret not to exist")
+}
+
+func (s *gcsStoreTestSuite) TestWriteBatch() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.UpdatePassword(ctx, "cert-id", []byte("old password")))
+
+	// A failed write should roll back the writes that were already applied
+	s.api.fail("deliveries/certificate/cert-id", http.StatusForbidden)
+	batch := (&store.Batch{}).UpdatePassword("cert-id", []byte("new password")).UpdateCertificate("cert-id", []byte("certificate"))
+	err := s.store.WriteBatch(ctx, batch)
+	require.ErrorIs(err, store.ErrPermissionDenied, "expected the write error to be returned")
+
+	password, err := s.store.GetPassword(ctx, "cert-id")
+	require.NoError(err)
+	require.Equal([]byte("old password"), password, "password write was not rolled back")
+
+	s.api.fail("", 0)
+	require.NoError(s.store.WriteBatch(ctx, batch), "could not write batch")
+	password, err = s.store.GetPassword(ctx, "cert-id")
+	require.NoError(err)
+	require.Equal([]byte("new password"), password)
+	_, err = s.store.GetCertificate(ctx, "cert-id")
+	require.NoError(err, "certificate was not written")
+}
+
+func (s *gcsStoreTestSuite) TestPublish() {
+	require := s.Require()
+	ctx := context.Background()
+
+	signed, err := s.store.Publish(ctx, "cert-id.pem", []byte("chain"), "application/x-pem-file", time.Hour)
+	require.NoError(err, "should be able to publish an artifact")
+
+	obj, ok := s.api.live("deliveries/public/cert-id.pem")
+	require.True(ok, "the artifact was not written under the prefix")
+	require.Equal([]byte("chain"), obj.data)
+
+	u, err := url.Parse(signed)
+	require.NoError(err, "could not parse signed url")
+	require.Equal("/courier/deliveries/public/cert-id.pem", u.Path)
+	require.Equal("3600", u.Query().Get("X-Goog-Expires"))
+	require.True(strings.HasPrefix(u.Query().Get("X-Goog-Credential"), signerEmail+"/"), "wrong credential")
+
+	// The artifact can be downloaded without credentials if the signature is valid
+	rep, err := http.Get(signed)
+	require.NoError(err, "could not download the artifact")
+	defer rep.Body.Close()
+	require.Equal(http.StatusOK, rep.StatusCode)
+	require.Equal("application/x-pem-file", rep.Header.Get("Content-Type"))
+
+	data, err := io.ReadAll(rep.Body)
+	require.NoError(err)
+	require.Equal([]byte("chain"), data)
+
+	rep, err = http.Get(strings.Replace(signed, "X-Goog-Expires=3600", "X-Goog-Expires=7200", 1))
+	require.NoError(err)
+	rep.Body.Close()
+	require.Equal(http.StatusForbidden, rep.StatusCode, "a modified url should not be accepted")
+
+	// Urls cannot be signed without a private key or signer
+	unsigned, err := gcs.Open(config.GCSConfig{Enabled: true, Bucket: "courier"}, gcs.WithEndpoint(s.srv.URL, s.srv.Client()))
+	require.NoError(err)
+	_, err = unsigned.Publish(ctx, "cert-id.pem", []byte("chain"), "application/x-pem-file", time.Hour)
+	require.ErrorIs(err, gcs.ErrCannotSign)
+}
+
+func (s *gcsStoreTestSuite) TestErrors() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.Check(ctx), "health check should pass")
+
+	s.api.fail("", http.StatusServiceUnavailable)
+	require.ErrorIs(s.store.Check(ctx), store.ErrUnavailable, "expected unavailable error")
+
+	s.api.fail("", http.StatusForbidden)
+	_, err := s.store.GetCertificate(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrPermissionDenied, "expected permission denied error")
+
+	s.api.fail("", http.StatusRequestEntityTooLarge)
+	err = s.store.UpdateCertificate(ctx, "cert-id", []byte("certificate"))
+	require.ErrorIs(err, store.ErrPayloadTooLarge, "expected payload too large error")
+}
+
+// Implements the object endpoints of the cloud storage json api for a single bucket
+// with object versioning enabled.
+type fakeGCS struct {
+	sync.Mutex
+	bucket     string
+	objects    map[string][]*fakeObject
+	generation int64
+	failName   string
+	failCode   int
+	signer     *rsa.PublicKey
+}
+
+type fakeObject struct {
+	name       string
+	data       []byte
+	generation int64
+	kmsKeyName string
+	created    time.Time
+	deleted    time.Time
+}
+
+func newFakeGCS(bucket string) *fakeGCS {
+	api := &fakeGCS{bucket: bucket}
+	api.reset()
+	return api
+}
+
+func (f *fakeGCS) reset() {
+	f.Lock()
+	defer f.Unlock()
+	f.objects = make(map[string][]*fakeObject)
+	f.generation = 1700000000000000
+	f.failName, f.failCode = "", 0
+}
+
+// Fail requests for the named object, or every request if name is empty, with the
+// status code. A zero code stops failing requests.
+func (f *fakeGCS) fail(name string, code int) {
+	f.Lock()
+	defer f.Unlock()
+	f.failName, f.failCode = name, code
+}
+
+// Returns the live generation of the object.
+func (f *fakeGCS) live(name string) (*fakeObject, bool) {
+	f.Lock()
+	defer f.Unlock()
+	obj := f.current(name)
+	return obj, obj != nil
+}
+
+func (f *fakeGCS) current(name string) *fakeObject {
+	generations := f.objects[name]
+	if len(generations) == 0 || !generations[len(generations)-1].deleted.IsZero() {
+		return nil
+	}
+	return generations[len(generations)-1]
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.URL.Query().Has("X-Goog-Signature") {
+		f.download(w, r)
+		return
+	}
+
+	path := r.URL.EscapedPath()
+	upload := strings.HasPrefix(path, "/upload")
+	path = strings.TrimPrefix(path, "/upload")
+
+	prefix := "/storage/v1/b/" + f.bucket + "/o"
+	if !strings.HasPrefix(path, prefix) {
+		f.error(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+
+	name, _ := url.PathUnescape(strings.TrimPrefix(strings.TrimPrefix(path, prefix), "/"))
+	query := r.URL.Query()
+
+	var meta struct {
+		Name string `json:"name"`
+	}
+	var data []byte
+	if upload {
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		parts := multipart.NewReader(r.Body, params["boundary"])
+		part, _ := parts.NextPart()
+		json.NewDecoder(part).Decode(&meta)
+		part, _ = parts.NextPart()
+		data, _ = io.ReadAll(part)
+		name = meta.Name
+	}
+
+	if f.failCode != 0 && (f.failName == "" || f.failName == name) {
+		f.error(w, f.failCode, http.StatusText(f.failCode))
+		return
+	}
+
+	var generation int64
+	if query.Has("generation") {
+		generation, _ = strconv.ParseInt(query.Get("generation"), 10, 64)
+	}
+
+	switch {
+	case upload && r.Method == http.MethodPost:
+		current := f.current(name)
+		if query.Has("ifGenerationMatch") {
+			match, _ := strconv.ParseInt(query.Get("ifGenerationMatch"), 10, 64)
+			if (current == nil && match != 0) || (current != nil && current.generation != match) {
+				f.error(w, http.StatusPreconditionFailed, "precondition failed")
+				return
+			}
+		}
+
+		now := time.Now()
+		if current != nil {
+			current.deleted = now
+		}
+
+		f.generation++
+		obj := &fakeObject{name: name, data: data, generation: f.generation, kmsKeyName: query.Get("kmsKeyName"), created: now}
+		f.objects[name] = append(f.objects[name], obj)
+		json.NewEncoder(w).Encode(f.resource(obj))
+	case r.Method == http.MethodGet && name == "":
+		f.list(w, query.Get("prefix"), query.Get("versions") == "true")
+	case r.Method == http.MethodGet:
+		obj := f.current(name)
+		if generation != 0 {
+			obj = f.find(name, generation)
+		}
+
+		if obj == nil {
+			f.error(w, http.StatusNotFound, "no such object")
+			return
+		}
+
+		// Without alt=media the object metadata is requested rather than its payload
+		if query.Get("alt") != "media" {
+			json.NewEncoder(w).Encode(f.resource(obj))
+			return
+		}
+		w.Header().Set("X-Goog-Generation", strconv.FormatInt(obj.generation, 10))
+		w.Write(obj.data)
+	case r.Method == http.MethodDelete:
+		if generation != 0 {
+			if f.find(name, generation) == nil {
+				f.error(w, http.StatusNotFound, "no such object")
+				return
+			}
+
+			generations := f.objects[name][:0]
+			for _, obj := range f.objects[name] {
+				if obj.generation != generation {
+					generations = append(generations, obj)
+				}
+			}
+			f.objects[name] = generations
+		} else {
+			current := f.current(name)
+			if current == nil {
+				f.error(w, http.StatusNotFound, "no such object")
+				return
+			}
+			current.deleted = time.Now()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.error(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// Downloads the live generation of an object with a V4 signed url, as described in
+// https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+func (f *fakeGCS) download(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	signature, _ := hex.DecodeString(query.Get("X-Goog-Signature"))
+	query.Del("X-Goog-Signature")
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.ReplaceAll(query.Encode(), "+", "%20"),
+		"host:" + r.Host + "\n",
+		query.Get("X-Goog-SignedHeaders"),
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	_, scope, _ := strings.Cut(query.Get("X-Goog-Credential"), "/")
+	stringToSign := strings.Join([]string{query.Get("X-Goog-Algorithm"), query.Get("X-Goog-Date"), scope, hex.EncodeToString(hash[:])}, "\n")
+	digest := sha256.Sum256([]byte(stringToSign))
+	if rsa.VerifyPKCS1v15(f.signer, crypto.SHA256, digest[:], signature) != nil {
+		f.error(w, http.StatusForbidden, "signature does not match")
+		return
+	}
+
+	name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/"+f.bucket+"/"))
+	obj := f.current(name)
+	if obj == nil {
+		f.error(w, http.StatusNotFound, "no such object")
+		return
+	}
+	w.Header().Set("Content-Type", query.Get("response-content-type"))
+	w.Write(obj.data)
+}
+
+func (f *fakeGCS) find(name string, generation int64) *fakeObject {
+	for _, obj := range f.objects[name] {
+		if obj.generation == generation {
+			return obj
+		}
+	}
+	return nil
+}
+
+func (f *fakeGCS) list(w http.ResponseWriter, prefix string, versions bool) {
+	items := make([]map[string]interface{}, 0)
+	for name, generations := range f.objects {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		for _, obj := range generations {
+			if versions || obj.deleted.IsZero() {
+				items = append(items, f.resource(obj))
+			}
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i]["name"].(string) < items[j]["name"].(string) })
+	json.NewEncoder(w).Encode(map[string]interface{}{"kind": "storage#objects", "items": items})
+}
+
+func (f *fakeGCS) resource(obj *fakeObject) map[string]interface{} {
+	resource := map[string]interface{}{
+		"name":        obj.name,
+		"bucket":      f.bucket,
+		"generation":  strconv.FormatInt(obj.generation, 10),
+		"timeCreated": obj.created.Format(time.RFC3339Nano),
+		"size":        fmt.Sprint(len(obj.data)),
+	}
+	if obj.kmsKeyName != "" {
+		resource["kmsKeyName"] = obj.kmsKeyName
+	}
+	if !obj.deleted.IsZero() {
+		resource["timeDeleted"] = obj.deleted.Format(time.RFC3339Nano)
+	}
+	return resource
+}
+
+func (f *fakeGCS) error(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": code, "message": message}})
+}
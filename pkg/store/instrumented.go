@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/o11y"
+)
+
+// SlowOperation is the duration above which store operations are logged as slow.
+var SlowOperation = 500 * time.Millisecond
+
+// Instrumented wraps a store so that every operation is timed and recorded in the
+// prometheus metrics, operations slower than SlowOperation are logged, and errors are
+// annotated with the name of the backend. Annotated errors wrap the original error so
+// that the typed store errors can still be checked with errors.Is. The returned store
+// only implements HealthChecker if the wrapped store does.
+func Instrumented(store Store, backend string) Store {
+	wrapped := &instrumented{store: store, backend: backend}
+	if checker, ok := store.(HealthChecker); ok {
+		return &instrumentedChecker{instrumented: wrapped, checker: checker}
+	}
+	return wrapped
+}
+
+type instrumented struct {
+	store   Store
+	backend string
+}
+
+// instrumentedChecker also instruments the health checks of stores that support them.
+type instrumentedChecker struct {
+	*instrumented
+	checker HealthChecker
+}
+
+var (
+	_ Store         = &instrumented{}
+	_ Store         = &instrumentedChecker{}
+	_ HealthChecker = &instrumentedChecker{}
+)
+
+// Unwrap returns the instrumented store.
+func (s *instrumented) Unwrap() Store {
+	return s.store
+}
+
+func (s *instrumented) Close() (err error) {
+	defer s.observe("close", time.Now(), &err)
+	return s.store.Close()
+}
+
+func (s *instrumentedChecker) Check(ctx context.Context) (err error) {
+	defer s.observe("check", time.Now(), &err)
+	return s.checker.Check(ctx)
+}
+
+func (s *instrumented) Count(ctx context.Context) (_ Counts, err error) {
+	defer s.observe("count", time.Now(), &err)
+	return s.store.Count(ctx)
+}
+
+func (s *instrumented) WriteBatch(ctx context.Context, batch *Batch) (err error) {
+	defer s.observe("write_batch", time.Now(), &err)
+	return s.store.WriteBatch(ctx, batch)
+}
+
+func (s *instrumented) List(ctx context.Context, prefix string) (_ []string, err error) {
+	defer s.observe("list", time.Now(), &err)
+	return s.store.List(ctx, prefix)
+}
+
+func (s *instrumented) Exists(ctx context.Context, prefix, name string) (_ bool, err error) {
+	defer s.observe("exists", time.Now(), &err)
+	return s.store.Exists(ctx, prefix, name)
+}
+
+func (s *instrumented) Modified(ctx context.Context, prefix, name string) (_ time.Time, err error) {
+	defer s.observe("modified", time.Now(), &err)
+	return s.store.Modified(ctx, prefix, name)
+}
+
+func (s *instrumented) Delete(ctx context.Context, prefix, name string) (err error) {
+	defer s.observe("delete", time.Now(), &err)
+	return s.store.Delete(ctx, prefix, name)
+}
+
+func (s *instrumented) GetPassword(ctx context.Context, name string) (_ []byte, err error) {
+	defer s.observe("get_password", time.Now(), &err)
+	return s.store.GetPassword(ctx, name)
+}
+
+func (s *instrumented) GetPasswordVersion(ctx context.Context, name, version string) (_ []byte, err error) {
+	defer s.observe("get_password_version", time.Now(), &err)
+	return s.store.GetPasswordVersion(ctx, name, version)
+}
+
+func (s *instrumented) GetPasswordWithToken(ctx context.Context, name string) (_ []byte, _ string, err error) {
+	defer s.observe("get_password_with_token", time.Now(), &err)
+	return s.store.GetPasswordWithToken(ctx, name)
+}
+
+func (s *instrumented) CompareAndUpdatePassword(ctx context.Context, name, token string, password []byte) (_ string, err error) {
+	defer s.observe("compare_and_update_password", time.Now(), &err)
+	return s.store.CompareAndUpdatePassword(ctx, name, token, password)
+}
+
+func (s *instrumented) UpdatePassword(ctx context.Context, name string, password []byte) (err error) {
+	defer s.observe("update_password", time.Now(), &err)
+	return s.store.UpdatePassword(ctx, name, password)
+}
+
+func (s *instrumented) DeletePassword(ctx context.Context, name string) (err error) {
+	defer s.observe("delete_password", time.Now(), &err)
+	return s.store.DeletePassword(ctx, name)
+}
+
+func (s *instrumented) PrunePasswordVersions(ctx context.Context, name string, keep int) (err error) {
+	defer s.observe("prune_password_versions", time.Now(), &err)
+	return s.store.PrunePasswordVersions(ctx, name, keep)
+}
+
+func (s *instrumented) GetCertificate(ctx context.Context, name string) (_ []byte, err error) {
+	defer s.observe("get_certificate", time.Now(), &err)
+	return s.store.GetCertificate(ctx, name)
+}
+
+func (s *instrumented) GetCertificateVersion(ctx context.Context, name, version string) (_ []byte, err error) {
+	defer s.observe("get_certificate_version", time.Now(), &err)
+	return s.store.GetCertificateVersion(ctx, name, version)
+}
+
+func (s *instrumented) ListCertificateVersions(ctx context.Context, name string) (_ []Version, err error) {
+	defer s.observe("list_certificate_versions", time.Now(), &err)
+	return s.store.ListCertificateVersions(ctx, name)
+}
+
+func (s *instrumented) GetCertificateWithToken(ctx context.Context, name string) (_ []byte, _ string, err error) {
+	defer s.observe("get_certificate_with_token", time.Now(), &err)
+	return s.store.GetCertificateWithToken(ctx, name)
+}
+
+func (s *instrumented) CompareAndUpdateCertificate(ctx context.Context, name, token string, cert []byte) (_ string, err error) {
+	defer s.observe("compare_and_update_certificate", time.Now(), &err)
+	return s.store.CompareAndUpdateCertificate(ctx, name, token, cert)
+}
+
+func (s *instrumented) UpdateCertificate(ctx context.Context, name string, cert []byte) (err error) {
+	defer s.observe("update_certificate", time.Now(), &err)
+	return s.store.UpdateCertificate(ctx, name, cert)
+}
+
+func (s *instrumented) DeleteCertificate(ctx context.Context, name string) (err error) {
+	defer s.observe("delete_certificate", time.Now(), &err)
+	return s.store.DeleteCertificate(ctx, name)
+}
+
+func (s *instrumented) PruneCertificateVersions(ctx context.Context, name string, keep int) (err error) {
+	defer s.observe("prune_certificate_versions", time.Now(), &err)
+	return s.store.PruneCertificateVersions(ctx, name, keep)
+}
+
+func (s *instrumented) GetSecret(ctx context.Context, name string) (_ []byte, err error) {
+	defer s.observe("get_secret", time.Now(), &err)
+	return s.store.GetSecret(ctx, name)
+}
+
+func (s *instrumented) UpdateSecret(ctx context.Context, name string, secret []byte) (err error) {
+	defer s.observe("update_secret", time.Now(), &err)
+	return s.store.UpdateSecret(ctx, name, secret)
+}
+
+func (s *instrumented) DeleteSecret(ctx context.Context, name string) (err error) {
+	defer s.observe("delete_secret", time.Now(), &err)
+	return s.store.DeleteSecret(ctx, name)
+}
+
+// Records the metrics for an operation and annotates the error with the backend name.
+func (s *instrumented) observe(operation string, started time.Time, err *error) {
+	duration := time.Since(started)
+
+	result := "ok"
+	if *err != nil {
+		result = "error"
+		*err = fmt.Errorf("%s store: %w", s.backend, *err)
+	}
+
+	o11y.StoreOperations.WithLabelValues(s.backend, operation, result).Inc()
+	o11y.StoreDurations.WithLabelValues(s.backend, operation).Observe(duration.Seconds())
+
+	// Corruption requires an operator to restore the resource so is always logged
+	if result == "error" && errors.Is(*err, ErrCorrupted) {
+		o11y.StoreCorruptions.WithLabelValues(s.backend, operation).Inc()
+		log.Error().
+			Str("backend", s.backend).
+			Str("operation", operation).
+			Err(*err).
+			Msg("stored resource failed its integrity check")
+	}
+
+	if duration > SlowOperation {
+		log.Warn().
+			Str("backend", s.backend).
+			Str("operation", operation).
+			Dur("duration", duration).
+			Err(*err).
+			Msg("slow store operation")
+	}
+}
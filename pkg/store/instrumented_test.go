@@ -0,0 +1,68 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/mock"
+)
+
+func TestInstrumented(t *testing.T) {
+	ctx := context.Background()
+	backend := mock.New()
+	instrumented := store.Instrumented(backend, "mock")
+
+	// Successful operations should pass results through unmodified
+	backend.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		return []byte("certificate"), nil
+	}
+	cert, err := instrumented.GetCertificate(ctx, "certID")
+	require.NoError(t, err, "expected no error from instrumented store")
+	require.Equal(t, []byte("certificate"), cert)
+
+	// Errors should be annotated with the backend name but remain checkable
+	backend.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+		return nil, store.ErrNotFound
+	}
+	_, err = instrumented.GetPassword(ctx, "certID")
+	require.ErrorIs(t, err, store.ErrNotFound, "expected typed error to be wrapped")
+	require.EqualError(t, err, "mock store: resource not found in store")
+
+	// Corruption errors should remain checkable after being recorded
+	backend.OnGetSecret = func(ctx context.Context, name string) ([]byte, error) {
+		return nil, store.ErrCorrupted
+	}
+	_, err = instrumented.GetSecret(ctx, "secret")
+	require.ErrorIs(t, err, store.ErrCorrupted, "expected corruption error to be wrapped")
+
+	// Unconfigured mock methods should also be wrapped
+	err = instrumented.DeleteCertificate(ctx, "certID")
+	require.True(t, errors.Is(err, mock.ErrNotConfigured), "expected mock error to be wrapped")
+}
+
+func TestInstrumentedHealthChecker(t *testing.T) {
+	// Stores that cannot check their connection should not appear to support it
+	_, ok := store.Instrumented(mock.New(), "mock").(store.HealthChecker)
+	require.False(t, ok, "instrumented store should not implement HealthChecker if the backend does not")
+
+	// Health checks should be forwarded to stores that support them
+	backend := &checker{Store: mock.New(), err: store.ErrUnavailable}
+	checker, ok := store.Instrumented(backend, "checker").(store.HealthChecker)
+	require.True(t, ok, "instrumented store should implement HealthChecker if the backend does")
+	require.ErrorIs(t, checker.Check(context.Background()), store.ErrUnavailable)
+	require.Equal(t, 1, backend.calls, "expected check to be forwarded to the backend")
+}
+
+type checker struct {
+	store.Store
+	err   error
+	calls int
+}
+
+func (c *checker) Check(context.Context) error {
+	c.calls++
+	return c.err
+}
@@ -0,0 +1,197 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/store"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Location of the namespace of the service account that is mounted into every pod.
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Kubernetes limits the data of a secret to 1MiB.
+const maxSecretSize = 1 << 20
+
+// APIError describes a failed Kubernetes API request. It is wrapped by the typed store
+// errors so that the status can be inspected when debugging.
+type APIError struct {
+	Code    int
+	Reason  string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("kubernetes api returned %d %s: %s", e.Code, e.Reason, e.Message)
+}
+
+// Client makes requests to the secrets endpoints of the Kubernetes API in a single
+// namespace with the client-go clientset.
+type client struct {
+	api       kubernetes.Interface
+	namespace string
+	timeout   time.Duration
+}
+
+// Creates a client from the service account credentials mounted into the pod. The
+// token is reloaded by client-go since the kubelet rotates projected tokens.
+func inCluster() (_ *client, err error) {
+	var conf *rest.Config
+	if conf, err = rest.InClusterConfig(); err != nil {
+		if errors.Is(err, rest.ErrNotInCluster) {
+			return nil, ErrNotInCluster
+		}
+		return nil, err
+	}
+	return newClient(conf, nil)
+}
+
+// Creates a client for the API server in the configuration. If the http client is not
+// nil it is used to make requests instead of the transport of the configuration.
+func newClient(conf *rest.Config, httpClient *http.Client) (_ *client, err error) {
+	// Requests are not throttled by the client since every call is a user request;
+	// the API server applies its own priority and fairness limits.
+	conf.QPS = -1
+
+	c := &client{}
+	if httpClient != nil {
+		c.api, err = kubernetes.NewForConfigAndClient(conf, httpClient)
+	} else {
+		c.api, err = kubernetes.NewForConfig(conf)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Returns the namespace of the pod that courier is running in.
+func podNamespace() (_ string, err error) {
+	var namespace []byte
+	if namespace, err = os.ReadFile(namespaceFile); err != nil {
+		return "", fmt.Errorf("could not determine kubernetes namespace: %w", err)
+	}
+	return strings.TrimSpace(string(namespace)), nil
+}
+
+func (c *client) get(ctx context.Context, name string) (_ *corev1.Secret, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	var out *corev1.Secret
+	if out, err = c.api.CoreV1().Secrets(c.namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+		return nil, storeError(err)
+	}
+	return out, nil
+}
+
+func (c *client) list(ctx context.Context, selector string, limit int) (out []corev1.Secret, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	opts := metav1.ListOptions{LabelSelector: selector}
+	if limit > 0 {
+		opts.Limit = int64(limit)
+	}
+
+	for {
+		var page *corev1.SecretList
+		if page, err = c.api.CoreV1().Secrets(c.namespace).List(ctx, opts); err != nil {
+			return nil, storeError(err)
+		}
+		out = append(out, page.Items...)
+
+		if page.Continue == "" || limit > 0 {
+			return out, nil
+		}
+		opts.Continue = page.Continue
+	}
+}
+
+// Creates the secret and returns its resource version.
+func (c *client) create(ctx context.Context, in *corev1.Secret) (_ string, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	var out *corev1.Secret
+	if out, err = c.api.CoreV1().Secrets(c.namespace).Create(ctx, in, metav1.CreateOptions{}); err != nil {
+		return "", storeError(err)
+	}
+	return out.ResourceVersion, nil
+}
+
+// Replaces the secret and returns its new resource version. If the resource version of
+// the secret is set the replacement is only applied if the stored secret has the same
+// resource version, otherwise the replacement is unconditional.
+func (c *client) replace(ctx context.Context, in *corev1.Secret) (_ string, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	var out *corev1.Secret
+	if out, err = c.api.CoreV1().Secrets(c.namespace).Update(ctx, in, metav1.UpdateOptions{}); err != nil {
+		return "", storeError(err)
+	}
+	return out.ResourceVersion, nil
+}
+
+func (c *client) delete(ctx context.Context, name string) (err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	if err = c.api.CoreV1().Secrets(c.namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		return storeError(err)
+	}
+	return nil
+}
+
+// Returns the context of an api call, which is bounded by the client timeout if set.
+func (c *client) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(ctx, c.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Maps the errors of the clientset to the typed errors exported by the store. Requests
+// that did not receive a status from the API server are unavailable.
+func storeError(err error) error {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return fmt.Errorf("%w: %v", store.ErrUnavailable, err)
+	}
+
+	reply := status.Status()
+	apiErr := &APIError{Code: int(reply.Code), Reason: string(reply.Reason), Message: reply.Message}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(apiErr.Code)
+	}
+
+	switch {
+	case apiErr.Code == http.StatusNotFound:
+		return fmt.Errorf("%w: %w", store.ErrNotFound, apiErr)
+	case apiErr.Code == http.StatusConflict && reply.Reason == metav1.StatusReasonAlreadyExists:
+		return fmt.Errorf("%w: %w", store.ErrAlreadyExists, apiErr)
+	case apiErr.Code == http.StatusConflict:
+		return fmt.Errorf("%w: %w", store.ErrVersionMismatch, apiErr)
+	case apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden:
+		return fmt.Errorf("%w: %w", store.ErrPermissionDenied, apiErr)
+	case apiErr.Code == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %w", store.ErrPayloadTooLarge, apiErr)
+	case apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500:
+		return fmt.Errorf("%w: %w", store.ErrUnavailable, apiErr)
+	}
+	return apiErr
+}
+
+var ErrNotInCluster = errors.New("kubernetes storage requires courier to run in a kubernetes cluster")
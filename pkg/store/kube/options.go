@@ -0,0 +1,19 @@
+package kube
+
+import (
+	"net/http"
+
+	"k8s.io/client-go/rest"
+)
+
+// StoreOption allows us to configure the store when it is created.
+type StoreOption func(s *Store) error
+
+// WithAPIServer connects to the Kubernetes API at the endpoint without credentials
+// rather than using the in-cluster configuration, e.g. for tests or a kubectl proxy.
+func WithAPIServer(endpoint string, httpClient *http.Client) StoreOption {
+	return func(s *Store) (err error) {
+		s.client, err = newClient(&rest.Config{Host: endpoint}, httpClient)
+		return err
+	}
+}
@@ -0,0 +1,456 @@
+/*
+Package kube implements a storage backend that stores certificates, passwords, and
+secrets as Kubernetes Secrets in a single namespace so that the TRISA node can mount
+delivered certificates directly. Courier must run in the cluster with a service account
+that can get, list, create, update, and delete secrets in the namespace.
+
+Each resource is stored in its own Opaque secret named after the resource prefix and
+id, e.g. certificate-{id}, with the payload under a single data key. Ids that are not
+valid in Kubernetes names are lowercased with a hash suffix so that they cannot collide.
+Kubernetes does not keep prior versions of secrets, so only the latest version of a
+resource is available and the resource version of the secret is the concurrency token.
+*/
+package kube
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Data keys of the payload of each kind of resource, which are also the file names
+// when the secret is mounted as a volume.
+const (
+	CertificateKey = "certificate"
+	PasswordKey    = "pkcs12password"
+	SecretKey      = "secret"
+)
+
+// Labels and annotations that identify the secrets managed by courier.
+const (
+	ManagedByLabel    = "app.kubernetes.io/managed-by"
+	ManagedBy         = "courier"
+	ResourceLabel     = "courier.trisa.io/resource"
+	IDAnnotation      = "courier.trisa.io/id"
+	UpdatedAnnotation = "courier.trisa.io/updated"
+)
+
+// Open the kubernetes storage backend.
+func Open(conf config.KubernetesConfig, opts ...StoreOption) (s *Store, err error) {
+	s = &Store{}
+	for _, opt := range opts {
+		if err = opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.client == nil {
+		if s.client, err = inCluster(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.client.namespace = conf.Namespace; s.client.namespace == "" {
+		if s.client.namespace, err = podNamespace(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.client.timeout = conf.Timeout
+	return s, nil
+}
+
+// Store implements the store.Store interface using Kubernetes Secrets.
+type Store struct {
+	client *client
+}
+
+var (
+	_ store.Store         = &Store{}
+	_ store.HealthChecker = &Store{}
+)
+
+// Close the kubernetes storage backend.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Check that the secrets in the namespace can be listed.
+func (s *Store) Check(ctx context.Context) (err error) {
+	_, err = s.client.list(ctx, ManagedByLabel+"="+ManagedBy, 1)
+	return err
+}
+
+// Count the resources stored in the namespace.
+func (s *Store) Count(ctx context.Context) (counts store.Counts, err error) {
+	var secrets []corev1.Secret
+	if secrets, err = s.client.list(ctx, ManagedByLabel+"="+ManagedBy, 0); err != nil {
+		return counts, err
+	}
+
+	for _, secret := range secrets {
+		switch secret.Labels[ResourceLabel] {
+		case store.CertificatePrefix:
+			counts.Certificates++
+		case store.PasswordPrefix:
+			counts.Passwords++
+		case store.SecretPrefix:
+			counts.Secrets++
+		}
+	}
+	return counts, nil
+}
+
+// List the ids of the resources of the type stored in the namespace.
+func (s *Store) List(ctx context.Context, prefix string) (ids []string, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	var secrets []corev1.Secret
+	if secrets, err = s.client.list(ctx, ManagedByLabel+"="+ManagedBy+","+ResourceLabel+"="+prefix, 0); err != nil {
+		return nil, err
+	}
+
+	for _, secret := range secrets {
+		if id, ok := secret.Annotations[IDAnnotation]; ok {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Exists checks if the resource is in the kubernetes storage backend.
+func (s *Store) Exists(ctx context.Context, prefix, name string) (_ bool, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return false, err
+	}
+
+	_, _, err = s.get(ctx, prefix, name)
+	return store.Found(err)
+}
+
+// Modified returns when the secret was last written by courier. Secrets written before
+// the time of each write was annotated report when the secret was created.
+func (s *Store) Modified(ctx context.Context, prefix, name string) (_ time.Time, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return time.Time{}, err
+	}
+
+	var secret *corev1.Secret
+	if secret, err = s.client.get(ctx, secretName(prefix, name)); err != nil {
+		return time.Time{}, err
+	}
+
+	if updated, ok := secret.Annotations[UpdatedAnnotation]; ok {
+		var ts time.Time
+		if ts, err = time.Parse(time.RFC3339, updated); err != nil {
+			return time.Time{}, fmt.Errorf("%w: secret %s has an invalid %s annotation", store.ErrCorrupted, secret.Name, UpdatedAnnotation)
+		}
+		return ts, nil
+	}
+
+	if secret.CreationTimestamp.IsZero() {
+		return time.Time{}, fmt.Errorf("%w: secret %s has no creation timestamp", store.ErrCorrupted, secret.Name)
+	}
+	return secret.CreationTimestamp.Time, nil
+}
+
+// Delete the resource like the delete method of its type.
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return store.DeleteResource(ctx, s, prefix, name)
+}
+
+// WriteBatch applies the writes in the batch to the namespace. Kubernetes does not
+// support transactions, so if a write fails the writes that were already applied are
+// compensated: the prior payload of each resource is restored and resources that were
+// created by the batch are deleted. Concurrent readers may observe the intermediate
+// state.
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) (err error) {
+	var undo store.Undo
+	for _, op := range batch.Ops() {
+		// Read the current payload so that the write can be reverted
+		found := true
+		var prior []byte
+		if prior, _, err = s.get(ctx, op.Prefix, op.Name); err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				return undo.Rollback(err)
+			}
+			found = false
+		}
+		undo.Push(s.revert(ctx, op.Prefix, op.Name, prior, found))
+
+		if op.Delete {
+			err = s.delete(ctx, op.Prefix, op.Name)
+		} else {
+			_, err = s.update(ctx, op.Prefix, op.Name, op.Data, "", false)
+		}
+
+		if err != nil {
+			return undo.Rollback(err)
+		}
+	}
+	return nil
+}
+
+//===========================================================================
+// Password Methods
+//===========================================================================
+
+// GetPassword retrieves a password by id from the kubernetes storage backend.
+func (s *Store) GetPassword(ctx context.Context, id string) (password []byte, err error) {
+	password, _, err = s.get(ctx, store.PasswordPrefix, id)
+	return password, err
+}
+
+// GetPasswordVersion retrieves a password by id from the kubernetes storage backend.
+// Kubernetes only keeps the latest version so any other version returns an error.
+func (s *Store) GetPasswordVersion(ctx context.Context, id, version string) (password []byte, err error) {
+	if version != store.LatestVersion {
+		return nil, store.ErrNoVersioning
+	}
+	return s.GetPassword(ctx, id)
+}
+
+// GetPasswordWithToken retrieves a password by id along with the resource version of
+// its secret, which is used as the concurrency token.
+func (s *Store) GetPasswordWithToken(ctx context.Context, id string) (password []byte, token string, err error) {
+	return s.get(ctx, store.PasswordPrefix, id)
+}
+
+// UpdatePassword creates or replaces a password by id in the kubernetes storage backend.
+func (s *Store) UpdatePassword(ctx context.Context, id string, password []byte) (err error) {
+	_, err = s.update(ctx, store.PasswordPrefix, id, password, "", false)
+	return err
+}
+
+// CompareAndUpdatePassword replaces a password by id if its secret has not been
+// modified since the token was issued and returns the new token.
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, id, token string, password []byte) (string, error) {
+	return s.update(ctx, store.PasswordPrefix, id, password, token, true)
+}
+
+// DeletePassword deletes a password by id from the kubernetes storage backend.
+func (s *Store) DeletePassword(ctx context.Context, id string) (err error) {
+	return s.delete(ctx, store.PasswordPrefix, id)
+}
+
+// PrunePasswordVersions is a no-op since Kubernetes only keeps the latest version.
+func (s *Store) PrunePasswordVersions(ctx context.Context, id string, keep int) error {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+	return nil
+}
+
+//===========================================================================
+// Certificate Methods
+//===========================================================================
+
+// GetCertificate retrieves a certificate by id from the kubernetes storage backend.
+func (s *Store) GetCertificate(ctx context.Context, id string) (cert []byte, err error) {
+	cert, _, err = s.get(ctx, store.CertificatePrefix, id)
+	return cert, err
+}
+
+// GetCertificateVersion retrieves a certificate by id from the kubernetes storage
+// backend. Kubernetes only keeps the latest version so any other version returns an
+// error.
+func (s *Store) GetCertificateVersion(ctx context.Context, id, version string) (cert []byte, err error) {
+	if version != store.LatestVersion {
+		return nil, store.ErrNoVersioning
+	}
+	return s.GetCertificate(ctx, id)
+}
+
+// ListCertificateVersions returns the latest version of a certificate by id, which is
+// the only version kept by the kubernetes storage backend.
+func (s *Store) ListCertificateVersions(ctx context.Context, id string) (_ []store.Version, err error) {
+	var secret *corev1.Secret
+	if secret, err = s.client.get(ctx, secretName(store.CertificatePrefix, id)); err != nil {
+		return nil, err
+	}
+
+	version := store.Version{Version: store.LatestVersion, State: "enabled", Created: secret.CreationTimestamp.Time}
+	return []store.Version{version}, nil
+}
+
+// GetCertificateWithToken retrieves a certificate by id along with the resource
+// version of its secret, which is used as the concurrency token.
+func (s *Store) GetCertificateWithToken(ctx context.Context, id string) (cert []byte, token string, err error) {
+	return s.get(ctx, store.CertificatePrefix, id)
+}
+
+// UpdateCertificate creates or replaces a certificate by id in the kubernetes storage
+// backend.
+func (s *Store) UpdateCertificate(ctx context.Context, id string, cert []byte) (err error) {
+	_, err = s.update(ctx, store.CertificatePrefix, id, cert, "", false)
+	return err
+}
+
+// CompareAndUpdateCertificate replaces a certificate by id if its secret has not been
+// modified since the token was issued and returns the new token.
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, id, token string, cert []byte) (string, error) {
+	return s.update(ctx, store.CertificatePrefix, id, cert, token, true)
+}
+
+// DeleteCertificate deletes a certificate by id from the kubernetes storage backend.
+func (s *Store) DeleteCertificate(ctx context.Context, id string) (err error) {
+	return s.delete(ctx, store.CertificatePrefix, id)
+}
+
+// PruneCertificateVersions is a no-op since Kubernetes only keeps the latest version.
+func (s *Store) PruneCertificateVersions(ctx context.Context, id string, keep int) error {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+	return nil
+}
+
+//===========================================================================
+// Secret Methods
+//===========================================================================
+
+// GetSecret retrieves a secret by name from the kubernetes storage backend.
+func (s *Store) GetSecret(ctx context.Context, name string) (data []byte, err error) {
+	data, _, err = s.get(ctx, store.SecretPrefix, name)
+	return data, err
+}
+
+// UpdateSecret creates or replaces a secret by name in the kubernetes storage backend.
+func (s *Store) UpdateSecret(ctx context.Context, name string, data []byte) (err error) {
+	_, err = s.update(ctx, store.SecretPrefix, name, data, "", false)
+	return err
+}
+
+// DeleteSecret deletes a secret by name from the kubernetes storage backend.
+func (s *Store) DeleteSecret(ctx context.Context, name string) (err error) {
+	return s.delete(ctx, store.SecretPrefix, name)
+}
+
+//===========================================================================
+// Helper methods
+//===========================================================================
+
+// get returns the payload of the resource and the resource version of its secret.
+func (s *Store) get(ctx context.Context, prefix, id string) (_ []byte, token string, err error) {
+	var secret *corev1.Secret
+	if secret, err = s.client.get(ctx, secretName(prefix, id)); err != nil {
+		return nil, "", err
+	}
+
+	payload, ok := secret.Data[dataKey(prefix)]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: secret %s has no %s key", store.ErrCorrupted, secret.Name, dataKey(prefix))
+	}
+	return payload, secret.ResourceVersion, nil
+}
+
+// update writes the payload of the resource and returns the new resource version of
+// its secret. If conditional is true the write is only applied if the resource version
+// matches the token, where an empty token only matches a resource that does not exist;
+// otherwise the secret is replaced or created as necessary.
+func (s *Store) update(ctx context.Context, prefix, id string, payload []byte, token string, conditional bool) (version string, err error) {
+	if len(payload) > maxSecretSize {
+		return "", store.ErrPayloadTooLarge
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            secretName(prefix, id),
+			Namespace:       s.client.namespace,
+			Labels:          map[string]string{ManagedByLabel: ManagedBy, ResourceLabel: prefix},
+			Annotations:     map[string]string{IDAnnotation: id, UpdatedAnnotation: time.Now().UTC().Format(time.RFC3339)},
+			ResourceVersion: token,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{dataKey(prefix): payload},
+	}
+
+	if conditional {
+		if token == "" {
+			if version, err = s.client.create(ctx, secret); errors.Is(err, store.ErrAlreadyExists) {
+				return "", store.ErrVersionMismatch
+			}
+			return version, err
+		}
+
+		if version, err = s.client.replace(ctx, secret); errors.Is(err, store.ErrNotFound) {
+			return "", store.ErrVersionMismatch
+		}
+		return version, err
+	}
+
+	// Most writes replace a secret that already exists so it is only created if it is
+	// not found; if it was created concurrently it is replaced again.
+	if version, err = s.client.replace(ctx, secret); !errors.Is(err, store.ErrNotFound) {
+		return version, err
+	}
+
+	if version, err = s.client.create(ctx, secret); !errors.Is(err, store.ErrAlreadyExists) {
+		return version, err
+	}
+	return s.client.replace(ctx, secret)
+}
+
+func (s *Store) delete(ctx context.Context, prefix, id string) error {
+	return s.client.delete(ctx, secretName(prefix, id))
+}
+
+// revert returns a function that restores the resource to the prior payload, or
+// deletes the resource if it did not exist. The rollback is not cancelled with the
+// request context since abandoning it would leave the store inconsistent; each call
+// is still bounded by the client timeout.
+func (s *Store) revert(ctx context.Context, prefix, id string, prior []byte, found bool) func() error {
+	ctx = context.WithoutCancel(ctx)
+	return func() (err error) {
+		if !found {
+			if err = s.delete(ctx, prefix, id); errors.Is(err, store.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		_, err = s.update(ctx, prefix, id, prior, "", false)
+		return err
+	}
+}
+
+// Ids that can be used in secret names without modification.
+var validName = regexp.MustCompile(`^[a-z0-9-]*[a-z0-9]$`)
+
+// secretName returns the name of the secret that stores the resource. Ids that are not
+// valid in Kubernetes names are lowercased, underscores are replaced with dashes, and a
+// hash of the id is appended so that ids that differ only by case cannot collide.
+func secretName(prefix, id string) string {
+	if validName.MatchString(id) {
+		return prefix + "-" + id
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	name := strings.ReplaceAll(strings.ToLower(id), "_", "-")
+	return prefix + "-" + name + "-" + hex.EncodeToString(sum[:8])
+}
+
+func dataKey(prefix string) string {
+	switch prefix {
+	case store.CertificatePrefix:
+		return CertificateKey
+	case store.PasswordPrefix:
+		return PasswordKey
+	default:
+		return SecretKey
+	}
+}
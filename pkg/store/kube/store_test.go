@@ -0,0 +1,342 @@
+package kube_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/kube"
+)
+
+type kubeStoreTestSuite struct {
+	suite.Suite
+	api   *fakeAPI
+	srv   *httptest.Server
+	store *kube.Store
+}
+
+func (s *kubeStoreTestSuite) SetupSuite() {
+	var err error
+	s.api = newFakeAPI("courier")
+	s.srv = httptest.NewServer(s.api)
+	s.store, err = kube.Open(config.KubernetesConfig{Enabled: true, Namespace: "courier", Timeout: 5 * time.Second}, kube.WithAPIServer(s.srv.URL, s.srv.Client()))
+	s.NoError(err, "could not open kubernetes storage backend")
+}
+
+func (s *kubeStoreTestSuite) TearDownSuite() {
+	s.NoError(s.store.Close(), "could not close kubernetes storage backend")
+	s.srv.Close()
+}
+
+func (s *kubeStoreTestSuite) SetupTest() {
+	s.api.reset()
+}
+
+func TestKubeStore(t *testing.T) {
+	suite.Run(t, new(kubeStoreTestSuite))
+}
+
+func (s *kubeStoreTestSuite) TestPasswordStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	_, err := s.store.GetPassword(ctx, "does-not-exist")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if password does not exist")
+
+	password := []byte("password")
+	require.NoError(s.store.UpdatePassword(ctx, "password-id", password), "should be able to create a password")
+
+	actual, err := s.store.GetPassword(ctx, "password-id")
+	require.NoError(err, "should be able to get a password")
+	require.Equal(password, actual, "wrong password returned")
+
+	// The password should be stored in a secret that can be mounted by the node
+	secret, ok := s.api.secret("pkcs12-password-id")
+	require.True(ok, "password secret was not created")
+	require.Equal(password, secret.Data[kube.PasswordKey], "wrong password data key")
+	require.Equal(kube.ManagedBy, secret.Metadata.Labels[kube.ManagedByLabel])
+	require.Equal("password-id", secret.Metadata.Annotations[kube.IDAnnotation])
+
+	// Updating the password should replace the secret
+	require.NoError(s.store.UpdatePassword(ctx, "password-id", []byte("updated")), "should be able to update a password")
+	actual, err = s.store.GetPassword(ctx, "password-id")
+	require.NoError(err, "should be able to get a password")
+	require.Equal([]byte("updated"), actual, "password was not updated")
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err, "should be able to count the store")
+	require.Equal(1, counts.Passwords, "wrong number of passwords counted")
+
+	// Only the latest version is available
+	_, err = s.store.GetPasswordVersion(ctx, "password-id", "1")
+	require.ErrorIs(err, store.ErrNoVersioning, "kubernetes store should not support versions")
+	require.ErrorIs(s.store.PrunePasswordVersions(ctx, "password-id", 0), store.ErrInvalidKeep)
+	require.NoError(s.store.PrunePasswordVersions(ctx, "password-id", 1))
+
+	require.NoError(s.store.DeletePassword(ctx, "password-id"), "should be able to delete a password")
+	_, err = s.store.GetPassword(ctx, "password-id")
+	require.ErrorIs(err, store.ErrNotFound, "password should not exist after delete")
+	require.ErrorIs(s.store.DeletePassword(ctx, "password-id"), store.ErrNotFound, "should return error if password does not exist")
+}
+
+func (s *kubeStoreTestSuite) TestCertificateStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// An empty token only matches a certificate that does not exist
+	token, err := s.store.CompareAndUpdateCertificate(ctx, "cert-id", "", []byte("certificate"))
+	require.NoError(err, "should be able to create a certificate")
+	require.NotEmpty(token, "expected a token to be returned")
+
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", "", []byte("certificate"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "empty token should not match an existing certificate")
+
+	cert, current, err := s.store.GetCertificateWithToken(ctx, "cert-id")
+	require.NoError(err, "should be able to get a certificate with its token")
+	require.Equal([]byte("certificate"), cert)
+	require.Equal(token, current, "wrong token returned")
+
+	// The token should be invalidated by an update
+	require.NoError(s.store.UpdateCertificate(ctx, "cert-id", []byte("renewed")))
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", token, []byte("stale"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "stale token should not match")
+
+	_, current, err = s.store.GetCertificateWithToken(ctx, "cert-id")
+	require.NoError(err)
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", current, []byte("current"))
+	require.NoError(err, "current token should match")
+
+	cert, err = s.store.GetCertificateVersion(ctx, "cert-id", store.LatestVersion)
+	require.NoError(err, "should be able to get the latest version")
+	require.Equal([]byte("current"), cert)
+
+	versions, err := s.store.ListCertificateVersions(ctx, "cert-id")
+	require.NoError(err, "should be able to list versions")
+	require.Len(versions, 1, "only the latest version should be listed")
+	require.Equal(store.LatestVersion, versions[0].Version)
+	require.False(versions[0].Created.IsZero(), "expected the creation timestamp")
+
+	// Deleted certificates should not match a token
+	require.NoError(s.store.DeleteCertificate(ctx, "cert-id"))
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", current, []byte("deleted"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "token should not match a deleted certificate")
+	_, err = s.store.ListCertificateVersions(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound)
+}
+
+func (s *kubeStoreTestSuite) TestSecretNames() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// Ids that are not valid kubernetes names should not collide
+	require.NoError(s.store.UpdateSecret(ctx, "API_Key", []byte("upper")))
+	require.NoError(s.store.UpdateSecret(ctx, "api_key", []byte("lower")))
+	require.NoError(s.store.UpdateSecret(ctx, "api-key", []byte("dash")))
+
+	for id, expected := range map[string]string{"API_Key": "upper", "api_key": "lower", "api-key": "dash"} {
+		actual, err := s.store.GetSecret(ctx, id)
+		require.NoError(err, "could not get secret %q", id)
+		require.Equal([]byte(expected), actual, "wrong secret returned for %q", id)
+	}
+
+	for name := range s.api.secrets {
+		require.Regexp(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`, name, "secret name is not a valid kubernetes name")
+	}
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err)
+	require.Equal(3, counts.Secrets, "wrong number of secrets counted")
+}
+
+func (s *kubeStoreTestSuite) TestWriteBatch() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.UpdatePassword(ctx, "cert-id", []byte("old password")))
+
+	// A failed write should roll back the writes that were already applied
+	s.api.fail("certificate-cert-id", http.StatusForbidden)
+	batch := (&store.Batch{}).UpdatePassword("cert-id", []byte("new password")).UpdateCertificate("cert-id", []byte("certificate"))
+	err := s.store.WriteBatch(ctx, batch)
+	require.ErrorIs(err, store.ErrPermissionDenied, "expected the write error to be returned")
+
+	password, err := s.store.GetPassword(ctx, "cert-id")
+	require.NoError(err)
+	require.Equal([]byte("old password"), password, "password write was not rolled back")
+
+	s.api.fail("", 0)
+	require.NoError(s.store.WriteBatch(ctx, batch), "could not write batch")
+	password, err = s.store.GetPassword(ctx, "cert-id")
+	require.NoError(err)
+	require.Equal([]byte("new password"), password)
+	_, err = s.store.GetCertificate(ctx, "cert-id")
+	require.NoError(err, "certificate was not written")
+}
+
+func (s *kubeStoreTestSuite) TestErrors() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.Check(ctx), "health check should pass")
+
+	s.api.fail("", http.StatusServiceUnavailable)
+	require.ErrorIs(s.store.Check(ctx), store.ErrUnavailable, "expected unavailable error")
+	s.api.fail("", http.StatusForbidden)
+	_, err := s.store.GetCertificate(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrPermissionDenied, "expected permission denied error")
+
+	var apiErr *kube.APIError
+	require.ErrorAs(err, &apiErr, "expected the api error to be wrapped")
+	require.Equal(http.StatusForbidden, apiErr.Code)
+	s.api.fail("", 0)
+
+	err = s.store.UpdateCertificate(ctx, "cert-id", make([]byte, 2<<20))
+	require.ErrorIs(err, store.ErrPayloadTooLarge, "secrets larger than 1MiB should be rejected")
+}
+
+// Implements the secrets endpoints of the kubernetes api in a single namespace.
+type fakeAPI struct {
+	sync.Mutex
+	namespace string
+	secrets   map[string]*fakeSecret
+	version   int
+	failName  string
+	failCode  int
+}
+
+type fakeSecret struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	Metadata   struct {
+		Name              string            `json:"name"`
+		Namespace         string            `json:"namespace,omitempty"`
+		Labels            map[string]string `json:"labels,omitempty"`
+		Annotations       map[string]string `json:"annotations,omitempty"`
+		ResourceVersion   string            `json:"resourceVersion,omitempty"`
+		CreationTimestamp *time.Time        `json:"creationTimestamp,omitempty"`
+	} `json:"metadata"`
+	Type string            `json:"type,omitempty"`
+	Data map[string][]byte `json:"data,omitempty"`
+}
+
+func newFakeAPI(namespace string) *fakeAPI {
+	api := &fakeAPI{namespace: namespace}
+	api.reset()
+	return api
+}
+
+func (f *fakeAPI) reset() {
+	f.Lock()
+	defer f.Unlock()
+	f.secrets = make(map[string]*fakeSecret)
+	f.failName, f.failCode = "", 0
+}
+
+// Fail requests for the named secret, or every request if name is empty, with the
+// status code. A zero code stops failing requests.
+func (f *fakeAPI) fail(name string, code int) {
+	f.Lock()
+	defer f.Unlock()
+	f.failName, f.failCode = name, code
+}
+
+func (f *fakeAPI) secret(name string) (*fakeSecret, bool) {
+	f.Lock()
+	defer f.Unlock()
+	secret, ok := f.secrets[name]
+	return secret, ok
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	prefix := fmt.Sprintf("/api/v1/namespaces/%s/secrets", f.namespace)
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		f.status(w, http.StatusNotFound, "NotFound", "unknown path")
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	w.Header().Set("Content-Type", "application/json")
+
+	if f.failCode != 0 && (f.failName == "" || f.failName == name) {
+		f.status(w, f.failCode, http.StatusText(f.failCode), "injected failure")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		selector := strings.SplitN(r.URL.Query().Get("labelSelector"), "=", 2)
+		items := make([]*fakeSecret, 0, len(f.secrets))
+		for _, secret := range f.secrets {
+			if len(selector) == 2 && secret.Metadata.Labels[selector[0]] != selector[1] {
+				continue
+			}
+			items = append(items, secret)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": "SecretList", "apiVersion": "v1", "items": items})
+	case r.Method == http.MethodGet:
+		secret, ok := f.secrets[name]
+		if !ok {
+			f.status(w, http.StatusNotFound, "NotFound", "secret not found")
+			return
+		}
+		json.NewEncoder(w).Encode(secret)
+	case r.Method == http.MethodPost:
+		secret := &fakeSecret{}
+		json.NewDecoder(r.Body).Decode(secret)
+		if _, ok := f.secrets[secret.Metadata.Name]; ok {
+			f.status(w, http.StatusConflict, "AlreadyExists", "secret already exists")
+			return
+		}
+		now := time.Now().UTC()
+		secret.Metadata.CreationTimestamp = &now
+		f.store(w, http.StatusCreated, secret)
+	case r.Method == http.MethodPut:
+		secret := &fakeSecret{}
+		json.NewDecoder(r.Body).Decode(secret)
+		current, ok := f.secrets[name]
+		if !ok {
+			f.status(w, http.StatusNotFound, "NotFound", "secret not found")
+			return
+		}
+		if secret.Metadata.ResourceVersion != "" && secret.Metadata.ResourceVersion != current.Metadata.ResourceVersion {
+			f.status(w, http.StatusConflict, "Conflict", "the object has been modified")
+			return
+		}
+		secret.Metadata.CreationTimestamp = current.Metadata.CreationTimestamp
+		f.store(w, http.StatusOK, secret)
+	case r.Method == http.MethodDelete:
+		if _, ok := f.secrets[name]; !ok {
+			f.status(w, http.StatusNotFound, "NotFound", "secret not found")
+			return
+		}
+		delete(f.secrets, name)
+		f.status(w, http.StatusOK, "", "")
+	default:
+		f.status(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	}
+}
+
+func (f *fakeAPI) store(w http.ResponseWriter, code int, secret *fakeSecret) {
+	f.version++
+	secret.Metadata.ResourceVersion = fmt.Sprint(f.version)
+	secret.Kind, secret.APIVersion = "Secret", "v1"
+	f.secrets[secret.Metadata.Name] = secret
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(secret)
+}
+
+func (f *fakeAPI) status(w http.ResponseWriter, code int, reason, message string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"kind": "Status", "apiVersion": "v1", "code": code, "reason": reason, "message": message})
+}
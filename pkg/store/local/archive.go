@@ -0,0 +1,252 @@
+package local
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// Exported archives begin with the header followed by the file data encrypted with the
+// current key, which is a gzipped tarball of the files of every resource.
+const archiveHeader = "courier-local-archive-v1:"
+
+// Export writes every file in the storage directory that stores a resource, its prior
+// versions, its checksums, or its metadata to a single archive encrypted with the
+// current key and returns the number of resource files that were exported, e.g. to
+// move a courier instance to another host. The files are archived as they are stored,
+// so the archive can only be imported by a store that has the keys that the files were
+// encrypted with; run Rekey first to encrypt every file with the current key. Every
+// resource file is read before it is archived so that corrupted files are not copied.
+// Temporary and quarantined files are not exported.
+func (s *Store) Export(w io.Writer) (n int, err error) {
+	if s.keys == nil {
+		return 0, ErrNotEncrypted
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+
+	err = s.walk(func(_, _, path string) (err error) {
+		name := filepath.Base(path)
+		if !isArchivedFile(name) {
+			return nil
+		}
+
+		if isResourceFile(name) {
+			if _, err = s.read(path); err != nil {
+				return fmt.Errorf("could not export %s: %w", s.relPath(path), err)
+			}
+			n++
+		}
+
+		var contents []byte
+		if contents, err = os.ReadFile(path); err != nil {
+			return storeError(err)
+		}
+
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filepath.ToSlash(s.relPath(path)),
+			Mode:     int64(s.fileMode),
+			Size:     int64(len(contents)),
+			ModTime:  time.Now().UTC(),
+		}
+
+		if info, err := os.Stat(path); err == nil {
+			hdr.ModTime = info.ModTime()
+		}
+
+		if err = archive.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		_, err = archive.Write(contents)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err = archive.Close(); err != nil {
+		return 0, err
+	}
+
+	if err = gz.Close(); err != nil {
+		return 0, err
+	}
+
+	var sealed []byte
+	if sealed, err = s.keys.seal(buf.Bytes(), []byte(archiveHeader)); err != nil {
+		return 0, err
+	}
+
+	if _, err = io.WriteString(w, archiveHeader); err != nil {
+		return 0, err
+	}
+
+	if _, err = w.Write(sealed); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Import writes the files of an archive created by Export into the storage directory
+// and returns the number of resource files that were imported. The archive must be
+// decrypted with one of the configured keys and is only imported into a store that has
+// no resources so that existing resources and their versions are not mixed with the
+// imported files. Every resource file is read after the archive is written; if any
+// file cannot be read the imported files are removed and the error is returned.
+func (s *Store) Import(r io.Reader) (n int, err error) {
+	if s.keys == nil {
+		return 0, ErrNotEncrypted
+	}
+
+	var data []byte
+	if data, err = io.ReadAll(r); err != nil {
+		return 0, err
+	}
+
+	if !bytes.HasPrefix(data, []byte(archiveHeader)) {
+		return 0, ErrNotArchive
+	}
+	data = data[len(archiveHeader):]
+
+	if !encrypted(data) {
+		return 0, fmt.Errorf("%w: missing encryption header", store.ErrCorrupted)
+	}
+
+	if data, err = s.keys.open(data, []byte(archiveHeader)); err != nil {
+		return 0, err
+	}
+
+	var gz *gzip.Reader
+	if gz, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+		return 0, fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+	}
+	defer gz.Close()
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, prefix := range store.Prefixes {
+		var ids []string
+		if ids, err = s.ids(prefix); err != nil {
+			return 0, storeError(err)
+		}
+
+		if len(ids) > 0 {
+			return 0, ErrNotEmpty
+		}
+	}
+
+	var written []string
+	defer func() {
+		if err != nil {
+			s.rollbackImport(written)
+		}
+	}()
+
+	archive := tar.NewReader(gz)
+	for {
+		var hdr *tar.Header
+		if hdr, err = archive.Next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+				break
+			}
+			return 0, fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+		}
+
+		var path string
+		if path, err = s.archivedPath(hdr); err != nil {
+			return 0, err
+		}
+
+		var contents []byte
+		if contents, err = io.ReadAll(archive); err != nil {
+			return 0, fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+		}
+
+		if err = s.writeAtomic(path, contents); err != nil {
+			return 0, storeError(err)
+		}
+		written = append(written, path)
+	}
+
+	for _, path := range written {
+		if !isResourceFile(filepath.Base(path)) {
+			continue
+		}
+
+		if _, err = s.read(path); err != nil {
+			return 0, fmt.Errorf("could not import %s: %w", s.relPath(path), err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// archivedPath returns the path in the storage directory of a file in an archive. Only
+// regular files in the directory of a resource are imported, so that an archive
+// cannot write outside of the storage directory or replace the lock or salt files.
+func (s *Store) archivedPath(hdr *tar.Header) (_ string, err error) {
+	if hdr.Typeflag != tar.TypeReg {
+		return "", fmt.Errorf("%w: %s is not a regular file", ErrInvalidArchive, hdr.Name)
+	}
+
+	parts := strings.Split(hdr.Name, "/")
+	if len(parts) != 3 || path.Clean(hdr.Name) != hdr.Name || !isArchivedFile(parts[2]) {
+		return "", fmt.Errorf("%w: %s is not a resource file", ErrInvalidArchive, hdr.Name)
+	}
+
+	for _, dir := range layoutDirs {
+		if parts[0] == dir {
+			return filepath.Join(s.path, parts[0], parts[1], parts[2]), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s is not a resource file", ErrInvalidArchive, hdr.Name)
+}
+
+// rollbackImport removes the files written by an import that failed along with the
+// directories of their resources.
+func (s *Store) rollbackImport(paths []string) {
+	for _, path := range paths {
+		if err := s.remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		dir := filepath.Dir(path)
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+			s.remove(dir)
+		}
+	}
+}
+
+// isArchivedFile returns true if the file in the directory of a resource is exported:
+// its data, prior versions, checksums, and metadata.
+func isArchivedFile(name string) bool {
+	if strings.HasPrefix(name, tempPrefix) {
+		return false
+	}
+
+	name = strings.TrimSuffix(name, checksumExt)
+	if name == dataFile+metaExt {
+		return true
+	}
+	return isResourceFile(name)
+}
@@ -0,0 +1,177 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+const (
+	// Files are written to a temporary file in the storage directory that is renamed
+	// over the destination once its contents have been synced to disk.
+	tempPrefix = ".tmp-"
+
+	// Partial and corrupted files found when the store is opened are moved into the
+	// quarantine directory rather than deleted so that they can be inspected.
+	quarantineDir = ".quarantine"
+)
+
+// writeAtomic replaces the file at path with the data so that the file either has its
+// previous contents or the new contents if the process dies during the write. The data
+// is written to a temporary file that is synced before it is renamed over the path,
+// and the directory is synced so that the rename survives a power failure. The file is
+// created with the mode so that its contents are never readable with other permissions.
+func writeAtomic(path string, data []byte, mode os.FileMode) (err error) {
+	var f *os.File
+	if f, err = os.CreateTemp(filepath.Dir(path), tempPrefix+filepath.Base(path)+"-*"); err != nil {
+		return err
+	}
+
+	// Remove the temporary file if it could not be renamed
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err = f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// removeDurable removes the file at path and syncs the directory so that a deleted
+// password or certificate is not restored by a power failure after the removal.
+func removeDurable(path string) (err error) {
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the directory entries so that renames and removals are durable.
+func syncDir(path string) (err error) {
+	var dir *os.File
+	if dir, err = os.Open(path); err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	// Some platforms do not support syncing directories
+	if err = dir.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return err
+	}
+	return nil
+}
+
+// recover quarantines the temporary files left behind by writes that were interrupted
+// before they were renamed, and the resource files that cannot be read because they
+// were truncated by a write that was interrupted before writes were atomic, so that
+// the affected ids can be delivered again. Files that cannot be decrypted because
+// their key is not configured, or that have not been encrypted since encryption was
+// enabled, are not corrupted and are left in place. Returns the names of the files
+// that were quarantined.
+func (s *Store) recover() (quarantined []string, err error) {
+	var paths []string
+
+	// Temporary files of earlier versions of courier are in the storage directory
+	var entries []fs.DirEntry
+	if entries, err = os.ReadDir(s.path); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), tempPrefix) {
+			paths = append(paths, filepath.Join(s.path, entry.Name()))
+		}
+	}
+
+	err = s.walk(func(_, _, path string) (err error) {
+		switch name := filepath.Base(path); {
+		case strings.HasPrefix(name, tempPrefix):
+		case isResourceFile(name):
+			if _, err = s.read(path); !errors.Is(err, store.ErrCorrupted) {
+				return nil
+			}
+		default:
+			return nil
+		}
+
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		if err = s.quarantine(path); err != nil {
+			return quarantined, err
+		}
+
+		rel := s.relPath(path)
+		quarantined = append(quarantined, rel)
+		log.Warn().Str("path", s.path).Str("file", rel).Msg("quarantined corrupted or partially written local storage file")
+	}
+	return quarantined, nil
+}
+
+// quarantine moves the file at path and its checksum into the same place in the
+// quarantine directory, adding a timestamp to the name so that earlier quarantined
+// files are not replaced.
+func (s *Store) quarantine(path string) (err error) {
+	dir := filepath.Join(s.path, quarantineDir, filepath.Dir(s.relPath(path)))
+	if err = os.MkdirAll(dir, s.dirMode); err != nil {
+		return err
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405.000000000")
+	for _, file := range []string{path, path + checksumExt} {
+		if err = os.Rename(file, filepath.Join(dir, filepath.Base(file)+"."+stamp)); err != nil {
+			if file != path && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("could not quarantine %s: %w", s.relPath(file), err)
+		}
+	}
+
+	if err = syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// read returns the verified data of a resource file, decompressing archives.
+func (s *Store) read(path string) ([]byte, error) {
+	if strings.HasSuffix(path, archiveExt) {
+		return s.readFile(path)
+	}
+	return s.readRaw(path)
+}
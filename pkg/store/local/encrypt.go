@@ -0,0 +1,224 @@
+package local
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Encrypted files begin with the header followed by the id of the key that
+	// encrypted them, the nonce, and the AES-256-GCM ciphertext. The header and the
+	// resource that the file stores are authenticated with the ciphertext so that
+	// encrypted files cannot be swapped between resources. Files encrypted before the
+	// resource was authenticated have the legacy header, which is the only data that
+	// is authenticated with their ciphertext, and are rewritten by rekeying.
+	encryptedHeader = "courier-aes256gcm-v2:"
+	legacyHeader    = "courier-aes256gcm:"
+	keyIDSize       = 8
+	keySize         = 32
+
+	// Passphrases are stretched with scrypt using a random salt that is created the
+	// first time the store is opened with a passphrase.
+	saltFile = ".courier-salt"
+	saltSize = 16
+	scryptN  = 1 << 15
+	scryptR  = 8
+	scryptP  = 1
+)
+
+// keyring holds the key that files are encrypted with and the keys that files
+// encrypted before a key rotation can be decrypted with.
+type keyring struct {
+	current *key
+	keys    map[string]*key
+}
+
+type key struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// loadKeyring returns the keys configured for the local store or nil if encryption is
+// not enabled.
+func loadKeyring(conf config.LocalStorageConfig) (ring *keyring, err error) {
+	var current []byte
+	switch {
+	case conf.KeyFile != "":
+		if current, err = readKeyFile(conf.KeyFile); err != nil {
+			return nil, err
+		}
+	case conf.Passphrase != "":
+		if current, err = deriveKey(conf.Path, conf.Passphrase, fileMode(conf)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	ring = &keyring{keys: make(map[string]*key)}
+	if ring.current, err = ring.add(current); err != nil {
+		return nil, err
+	}
+
+	for _, path := range conf.OldKeyFiles {
+		var previous []byte
+		if previous, err = readKeyFile(path); err != nil {
+			return nil, err
+		}
+
+		if _, err = ring.add(previous); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.OldPassphrase != "" {
+		var previous []byte
+		if previous, err = deriveKey(conf.Path, conf.OldPassphrase, fileMode(conf)); err != nil {
+			return nil, err
+		}
+
+		if _, err = ring.add(previous); err != nil {
+			return nil, err
+		}
+	}
+	return ring, nil
+}
+
+func (r *keyring) add(secret []byte) (_ *key, err error) {
+	var block cipher.Block
+	if block, err = aes.NewCipher(secret); err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	k := &key{id: keyID(secret)}
+	if k.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+
+	r.keys[string(k.id)] = k
+	return k, nil
+}
+
+// seal encrypts the file data of the resource with the current key.
+func (r *keyring) seal(data, resource []byte) (_ []byte, err error) {
+	out := make([]byte, 0, len(encryptedHeader)+keyIDSize+r.current.aead.NonceSize()+len(data)+r.current.aead.Overhead())
+	out = append(out, encryptedHeader...)
+	out = append(out, r.current.id...)
+
+	nonce := make([]byte, r.current.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return r.current.aead.Seal(out, nonce, data, additionalData(resource)), nil
+}
+
+// open decrypts the file data of the resource with the key that it was encrypted with.
+// Files that fail authentication have been modified on disk or were encrypted for
+// another resource and are reported as corrupted.
+func (r *keyring) open(data, resource []byte) (_ []byte, err error) {
+	ad := additionalData(resource)
+	if bytes.HasPrefix(data, []byte(legacyHeader)) {
+		data, ad = data[len(legacyHeader):], []byte(legacyHeader)
+	} else {
+		data = data[len(encryptedHeader):]
+	}
+
+	if len(data) < keyIDSize {
+		return nil, fmt.Errorf("%w: truncated encryption header", store.ErrCorrupted)
+	}
+
+	k, ok := r.keys[string(data[:keyIDSize])]
+	if !ok {
+		return nil, fmt.Errorf("%w: key id %s", ErrUnknownKey, hex.EncodeToString(data[:keyIDSize]))
+	}
+	data = data[keyIDSize:]
+
+	size := k.aead.NonceSize()
+	if len(data) < size {
+		return nil, fmt.Errorf("%w: truncated encryption header", store.ErrCorrupted)
+	}
+
+	if data, err = k.aead.Open(nil, data[:size], data[size:], ad); err != nil {
+		return nil, fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+	}
+	return data, nil
+}
+
+// isCurrent returns true if the file data was encrypted with the current key and
+// authenticates the resource that it stores.
+func (r *keyring) isCurrent(data []byte) bool {
+	if !bytes.HasPrefix(data, []byte(encryptedHeader)) {
+		return false
+	}
+
+	data = data[len(encryptedHeader):]
+	return len(data) >= keyIDSize && bytes.Equal(data[:keyIDSize], r.current.id)
+}
+
+// encrypted returns true if the file data was written by an encrypted store.
+func encrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedHeader)) || bytes.HasPrefix(data, []byte(legacyHeader))
+}
+
+// additionalData returns the data that is authenticated with the ciphertext of the
+// resource: the header followed by the resource.
+func additionalData(resource []byte) []byte {
+	return append([]byte(encryptedHeader), resource...)
+}
+
+// readKeyFile reads a base64 encoded 32 byte key from the file.
+func readKeyFile(path string) (secret []byte, err error) {
+	var data []byte
+	if data, err = os.ReadFile(path); err != nil {
+		return nil, fmt.Errorf("could not read encryption key file: %w", err)
+	}
+
+	if secret, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err != nil || len(secret) != keySize {
+		return nil, fmt.Errorf("%w: %s must contain a base64 encoded 32 byte key", ErrInvalidKey, filepath.Base(path))
+	}
+	return secret, nil
+}
+
+// deriveKey stretches the passphrase into a 32 byte key with the salt of the store at
+// path, creating the salt if it does not exist. The salt is not secret but it must be
+// kept with the files since they cannot be decrypted without it.
+func deriveKey(path, passphrase string, mode os.FileMode) (_ []byte, err error) {
+	var salt []byte
+	if salt, err = os.ReadFile(filepath.Join(path, saltFile)); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		salt = make([]byte, saltSize)
+		if _, err = rand.Read(salt); err != nil {
+			return nil, err
+		}
+
+		if err = writeAtomic(filepath.Join(path, saltFile), salt, mode); err != nil {
+			return nil, err
+		}
+	}
+	return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keySize)
+}
+
+// keyID identifies a key in the header of encrypted files without revealing it.
+func keyID(secret []byte) []byte {
+	sum := sha256.Sum256(append([]byte(encryptedHeader), secret...))
+	return sum[:keyIDSize]
+}
@@ -0,0 +1,15 @@
+package local
+
+import "errors"
+
+var (
+	ErrInvalidKey     = errors.New("local storage encryption keys must be 32 bytes long")
+	ErrUnknownKey     = errors.New("file was encrypted with a key that is not configured")
+	ErrMissingKey     = errors.New("file is encrypted but no local storage encryption key is configured")
+	ErrNotEncrypted   = errors.New("local storage encryption is not enabled")
+	ErrUnencrypted    = errors.New("file is not encrypted but local storage encryption is enabled")
+	ErrWrongOwner     = errors.New("local storage directory is not owned by the configured owner")
+	ErrNotArchive     = errors.New("data is not an exported local storage archive")
+	ErrInvalidArchive = errors.New("archive contains a file that is not a local storage resource")
+	ErrNotEmpty       = errors.New("archives can only be imported into local storage without resources")
+)
@@ -0,0 +1,232 @@
+package local
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// Each resource is stored in its own directory, e.g. certs/<id>/, that holds the data
+// of the latest version, the prior versions, the checksums, and the metadata. Within
+// the directory the latest version is the data file, prior versions append their
+// number, e.g. data@3, and the other files append their extension to the file they
+// describe, e.g. data.gz.sha256 or data.meta.
+const dataFile = "data"
+
+// layoutDirs are the directories that hold the resources of each type.
+var layoutDirs = map[string]string{
+	store.CertificatePrefix: "certs",
+	store.PasswordPrefix:    "passwords",
+	store.SecretPrefix:      "secrets",
+}
+
+// resourceDir returns the directory of the named resource. The name is escaped so that
+// it is always a single path element inside the directory of its type.
+func (s *Store) resourceDir(prefix, name string) string {
+	return filepath.Join(s.path, layoutDirs[prefix], escapeName(name))
+}
+
+// ids returns the names of the resources of the type that have a latest version.
+// Directories left behind by a write that was rolled back are ignored.
+func (s *Store) ids(prefix string) (names []string, err error) {
+	var entries []fs.DirEntry
+	if entries, err = os.ReadDir(filepath.Join(s.path, layoutDirs[prefix])); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		var name string
+		if name, err = url.PathUnescape(entry.Name()); err != nil {
+			continue
+		}
+
+		if _, err = os.Stat(s.resourcePath(prefix, name)); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// walk calls fn with the path of every file in the directory of every resource,
+// including temporary files, in the order of the resource types.
+func (s *Store) walk(fn func(prefix, name, path string) error) (err error) {
+	for _, prefix := range store.Prefixes {
+		root := filepath.Join(s.path, layoutDirs[prefix])
+
+		var dirs []fs.DirEntry
+		if dirs, err = os.ReadDir(root); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		for _, dir := range dirs {
+			if !dir.IsDir() {
+				continue
+			}
+
+			var name string
+			if name, err = url.PathUnescape(dir.Name()); err != nil {
+				continue
+			}
+
+			var files []fs.DirEntry
+			if files, err = os.ReadDir(filepath.Join(root, dir.Name())); err != nil {
+				// The resource was removed since the directory was read
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				return err
+			}
+
+			for _, file := range files {
+				if file.IsDir() {
+					continue
+				}
+
+				if err = fn(prefix, name, filepath.Join(root, dir.Name(), file.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// mkdirAll creates the directory of a resource and the directory of its type if they
+// do not exist, applying the directory mode regardless of the umask.
+func (s *Store) mkdirAll(dir string) (err error) {
+	if _, err = os.Stat(dir); err == nil {
+		return nil
+	}
+
+	if err = os.MkdirAll(dir, s.dirMode); err != nil {
+		return err
+	}
+
+	for ; len(dir) > len(s.path); dir = filepath.Dir(dir) {
+		if err = os.Chmod(dir, s.dirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDir removes the directory of a deleted resource once it is empty. A directory
+// that still holds files, e.g. a temporary file, is left in place.
+func (s *Store) removeDir(prefix, name string) (err error) {
+	dir := s.resourceDir(prefix, name)
+
+	var entries []fs.DirEntry
+	if entries, err = os.ReadDir(dir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if len(entries) > 0 {
+		return nil
+	}
+	return s.remove(dir)
+}
+
+// migrate moves the files of resources stored by earlier versions of courier, which
+// kept every file in the storage directory named for the type and id of its resource,
+// e.g. certificate-<id>@3, into the directory of the resource. Each file is renamed
+// so that an interrupted migration continues when the store is next opened.
+func (s *Store) migrate() (migrated int, err error) {
+	var entries []fs.DirEntry
+	if entries, err = os.ReadDir(s.path); err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !hasResourcePrefix(entry.Name()) {
+			continue
+		}
+
+		prefix, name, suffix := parseLegacyName(entry.Name())
+		dir := s.resourceDir(prefix, name)
+		if err = s.mkdirAll(dir); err != nil {
+			return migrated, err
+		}
+
+		if err = os.Rename(filepath.Join(s.path, entry.Name()), filepath.Join(dir, dataFile+suffix)); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		if err = syncDir(s.path); err != nil {
+			return migrated, err
+		}
+		log.Info().Str("path", s.path).Int("files", migrated).Msg("migrated local storage files to the resource directory layout")
+	}
+	return migrated, nil
+}
+
+// parsePath returns the resource type and id of a file in the directory of a resource
+// from its path relative to the storage directory.
+func parsePath(rel string) (prefix, name string) {
+	parts := strings.Split(rel, string(filepath.Separator))
+	if len(parts) != 3 {
+		return "", ""
+	}
+
+	for kind, dir := range layoutDirs {
+		if parts[0] == dir {
+			prefix = kind
+		}
+	}
+
+	name, _ = url.PathUnescape(parts[1])
+	return prefix, name
+}
+
+// parseLegacyName returns the resource type and id of a file named by earlier versions
+// of courier along with the suffix that follows the id, e.g. @3 or .gz.sha256.
+func parseLegacyName(file string) (prefix, name, suffix string) {
+	var rest string
+	prefix, rest, _ = strings.Cut(file, "-")
+
+	name = strings.TrimSuffix(rest, checksumExt)
+	name = strings.TrimSuffix(name, archiveExt)
+	name = strings.TrimSuffix(name, metaExt)
+
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			name = name[:i]
+		}
+	}
+	return prefix, name, rest[len(name):]
+}
+
+// escapeName escapes the name of a resource so that it can be used as a directory name.
+// Names that consist only of dots are escaped so that they do not refer to a parent.
+func escapeName(name string) string {
+	if strings.Trim(name, ".") == "" {
+		return strings.ReplaceAll(name, ".", "%2E")
+	}
+	return url.PathEscape(name)
+}
@@ -0,0 +1,112 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// lockFile is the file in the storage directory that courier processes lock while they
+// read or write the store. Other tools that read the storage directory, e.g. a backup
+// sidecar, can take a shared lock on it to read consistent files.
+const lockFile = ".courier-lock"
+
+// rwlock serializes access to the storage directory both between the goroutines of the
+// store, with a read-write mutex, and between processes that use the same directory,
+// with an advisory lock on the lock file. Readers in the process share one shared lock
+// on the file, which is taken by the first reader and released by the last, since the
+// lock is held by the open file rather than by the goroutine.
+type rwlock struct {
+	mu      sync.RWMutex
+	state   sync.Mutex
+	readers int
+	file    *os.File
+}
+
+// openLock opens the lock file in the storage directory. If the file system does not
+// support advisory locks, e.g. some network file systems, a warning is logged and only
+// goroutines in the process are serialized.
+func (l *rwlock) openLock(path string, mode os.FileMode) (err error) {
+	if l.file, err = os.OpenFile(filepath.Join(path, lockFile), os.O_RDWR|os.O_CREATE, mode); err != nil {
+		return err
+	}
+
+	if err = flock(l.file, lockShared); err == nil {
+		return flock(l.file, lockRelease)
+	}
+
+	if !lockUnsupported(err) {
+		l.file.Close()
+		l.file = nil
+		return err
+	}
+
+	log.Warn().Err(err).Str("path", path).Msg("local storage file system does not support file locks, concurrent courier processes are not serialized")
+	l.file.Close()
+	l.file = nil
+	return nil
+}
+
+// closeLock closes the lock file, releasing any lock that is held on it.
+func (l *rwlock) closeLock() error {
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Lock acquires the write lock for the goroutine and an exclusive lock on the file.
+func (l *rwlock) Lock() {
+	l.mu.Lock()
+	l.flock(lockExclusive)
+}
+
+// Unlock releases the exclusive lock on the file and the write lock.
+func (l *rwlock) Unlock() {
+	l.flock(lockRelease)
+	l.mu.Unlock()
+}
+
+// RLock acquires a read lock for the goroutine and a shared lock on the file if no
+// other goroutine in the process holds it.
+func (l *rwlock) RLock() {
+	l.mu.RLock()
+
+	l.state.Lock()
+	defer l.state.Unlock()
+	if l.readers == 0 {
+		l.flock(lockShared)
+	}
+	l.readers++
+}
+
+// RUnlock releases the read lock and the shared lock on the file if this was the last
+// reader in the process.
+func (l *rwlock) RUnlock() {
+	l.state.Lock()
+	l.readers--
+	if l.readers == 0 {
+		l.flock(lockRelease)
+	}
+	l.state.Unlock()
+
+	l.mu.RUnlock()
+}
+
+// flock applies the lock operation to the lock file, blocking until it is acquired.
+// The lock cannot fail once the file system is known to support it, so an error is
+// logged rather than returned to the many callers that hold the lock.
+func (l *rwlock) flock(how int) {
+	if l.file == nil {
+		return
+	}
+
+	if err := flock(l.file, how); err != nil {
+		log.Error().Err(err).Str("file", l.file.Name()).Msg("could not lock local storage directory")
+	}
+}
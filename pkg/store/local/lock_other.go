@@ -0,0 +1,30 @@
+//go:build !windows
+
+package local
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const (
+	lockShared    = syscall.LOCK_SH
+	lockExclusive = syscall.LOCK_EX
+	lockRelease   = syscall.LOCK_UN
+)
+
+// flock applies an advisory lock operation to the file, retrying if it is interrupted.
+func flock(f *os.File, how int) (err error) {
+	for {
+		if err = syscall.Flock(int(f.Fd()), how); !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+	}
+}
+
+// lockUnsupported returns true if the error is returned by file systems that do not
+// support advisory locks.
+func lockUnsupported(err error) bool {
+	return errors.Is(err, syscall.ENOLCK) || errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS)
+}
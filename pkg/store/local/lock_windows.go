@@ -0,0 +1,26 @@
+//go:build windows
+
+package local
+
+import (
+	"errors"
+	"os"
+)
+
+const (
+	lockShared = iota
+	lockExclusive
+	lockRelease
+)
+
+var errLockUnsupported = errors.New("advisory file locks are not supported on windows")
+
+// flock is not supported on windows, so only goroutines in the process are serialized.
+func flock(f *os.File, how int) error {
+	return errLockUnsupported
+}
+
+// lockUnsupported returns true for every error since locks are not supported.
+func lockUnsupported(err error) bool {
+	return true
+}
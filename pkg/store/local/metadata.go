@@ -0,0 +1,150 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// metadata is the JSON record kept in a file alongside each resource. The generation
+// is the number of times the resource has been written and is used as the concurrency
+// token. Metadata files written before the record was JSON only contain the generation.
+type metadata struct {
+	Generation int       `json:"generation"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+	Checksum   string    `json:"sha256"`
+	Size       int64     `json:"size"`
+	Encrypted  bool      `json:"encrypted"`
+}
+
+var _ store.MetadataStore = &Store{}
+
+// Metadata returns the metadata recorded for the latest version of the resource. The
+// metadata of resources written before it was recorded is derived from the file.
+func (s *Store) Metadata(ctx context.Context, prefix, name string) (_ *store.Metadata, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	var info fs.FileInfo
+	if info, err = os.Stat(s.resourcePath(prefix, name)); err != nil {
+		return nil, storeError(err)
+	}
+
+	var meta *metadata
+	if meta, err = s.readMeta(prefix, name); err != nil {
+		return nil, storeError(err)
+	}
+
+	if meta.Updated.IsZero() {
+		var contents []byte
+		if contents, err = os.ReadFile(s.resourcePath(prefix, name)); err != nil {
+			return nil, storeError(err)
+		}
+
+		meta.Updated = info.ModTime()
+		meta.Encrypted = encrypted(contents)
+	}
+
+	return &store.Metadata{
+		Created:   meta.Created,
+		Updated:   meta.Updated,
+		Checksum:  meta.Checksum,
+		Size:      meta.Size,
+		Encrypted: meta.Encrypted,
+	}, nil
+}
+
+// generation returns the number of times the named resource has been written.
+// Resources written before metadata files were kept are at generation 0.
+func (s *Store) generation(prefix, name string) (_ string, err error) {
+	var meta *metadata
+	if meta, err = s.readMeta(prefix, name); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(meta.Generation), nil
+}
+
+// readMeta returns the metadata of the named resource, which is empty if the resource
+// has no metadata file.
+func (s *Store) readMeta(prefix, name string) (meta *metadata, err error) {
+	var data []byte
+	if data, err = os.ReadFile(s.fullPath(prefix, name, metaExt)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &metadata{}, nil
+		}
+		return nil, err
+	}
+
+	meta = &metadata{}
+	if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+		meta.Generation = n
+		return meta, nil
+	}
+
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata for %s-%s: %w", prefix, name, err)
+	}
+	return meta, nil
+}
+
+// writeMeta records a write of the data of the named resource, incrementing its
+// generation. The created timestamp is kept from the first write that was recorded.
+func (s *Store) writeMeta(prefix, name string, data []byte) (err error) {
+	var meta *metadata
+	if meta, err = s.readMeta(prefix, name); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if meta.Created.IsZero() {
+		meta.Created = now
+	}
+
+	meta.Generation++
+	meta.Updated = now
+	meta.Checksum = store.Checksum(data)
+	meta.Size = int64(len(data))
+	meta.Encrypted = s.keys != nil
+	return s.saveMeta(prefix, name, meta)
+}
+
+// markEncrypted records that the latest version of the named resource was rewritten
+// with encryption, without changing its generation since the data is unchanged.
+func (s *Store) markEncrypted(prefix, name string) (err error) {
+	var meta *metadata
+	if meta, err = s.readMeta(prefix, name); err != nil {
+		return err
+	}
+
+	meta.Encrypted = true
+	return s.saveMeta(prefix, name, meta)
+}
+
+func (s *Store) saveMeta(prefix, name string, meta *metadata) (err error) {
+	var data []byte
+	if data, err = json.Marshal(meta); err != nil {
+		return err
+	}
+	return s.writeAtomic(s.fullPath(prefix, name, metaExt), data)
+}
+
+// removeMeta removes the metadata file of a deleted resource.
+func (s *Store) removeMeta(prefix, name string) (err error) {
+	if err = s.remove(s.fullPath(prefix, name, metaExt)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
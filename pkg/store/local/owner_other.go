@@ -0,0 +1,43 @@
+//go:build !windows
+
+package local
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// checkOwner returns ErrWrongOwner if courier is running as root and the storage
+// directory is not owned by the configured user, e.g. because the path was mistyped
+// and points at another user's directory. The owner is not checked if it is not
+// configured or if courier is not running as root.
+func checkOwner(path, owner string) (err error) {
+	if owner == "" || os.Geteuid() != 0 {
+		return nil
+	}
+
+	var uid uint64
+	if uid, err = strconv.ParseUint(owner, 10, 32); err != nil {
+		var u *user.User
+		if u, err = user.Lookup(owner); err != nil {
+			return fmt.Errorf("could not look up local storage owner %q: %w", owner, err)
+		}
+
+		if uid, err = strconv.ParseUint(u.Uid, 10, 32); err != nil {
+			return fmt.Errorf("could not parse uid of local storage owner %q: %w", owner, err)
+		}
+	}
+
+	var info os.FileInfo
+	if info, err = os.Stat(path); err != nil {
+		return err
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && uint64(stat.Uid) != uid {
+		return fmt.Errorf("%w: %s is owned by uid %d, not %s", ErrWrongOwner, path, stat.Uid, owner)
+	}
+	return nil
+}
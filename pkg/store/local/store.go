@@ -4,46 +4,364 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"sync"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	"github.com/trisacrypto/courier/pkg/config"
 	"github.com/trisacrypto/courier/pkg/store"
 )
 
 const (
-	archiveExt = ".gz"
+	archiveExt  = ".gz"
+	metaExt     = ".meta"
+	checksumExt = ".sha256"
+
+	// Stored files contain private keys and passwords so by default the directory and
+	// files are only accessible by courier.
+	defaultDirMode  = 0700
+	defaultFileMode = 0600
 )
 
-// Open the local storage backend.
+// Open the local storage backend. If an encryption key file or passphrase is
+// configured, files are encrypted with AES-256-GCM when they are written.
 func Open(conf config.LocalStorageConfig) (store *Store, err error) {
 	store = &Store{
-		path: conf.Path,
+		path:        conf.Path,
+		maxVersions: conf.MaxVersions,
+		raw:         conf.Raw,
+		dirMode:     defaultDirMode,
+		fileMode:    fileMode(conf),
+	}
+
+	if conf.DirMode != 0 {
+		store.dirMode = os.FileMode(conf.DirMode)
 	}
 
-	// Ensure the path exists
-	if err = os.MkdirAll(conf.Path, 0755); err != nil {
+	// Ensure the path exists with the configured permissions
+	if err = store.mkdir(); err != nil {
 		return nil, err
 	}
 
+	// Refuse to store files in a directory owned by another user when running as root
+	if err = checkOwner(conf.Path, conf.Owner); err != nil {
+		return nil, err
+	}
+
+	// Serialize access to the path with other courier processes that use it
+	if err = store.openLock(conf.Path, store.fileMode); err != nil {
+		return nil, err
+	}
+
+	if err = store.prepare(conf); err != nil {
+		store.closeLock()
+		return nil, err
+	}
+
+	// Poll the path for changes made outside of courier
+	if conf.WatchInterval > 0 {
+		if err = store.watch(conf.WatchInterval); err != nil {
+			store.closeLock()
+			return nil, err
+		}
+	}
+
 	return store, nil
 }
 
-// Store implements the store.Store interface for local storage.
+// prepare loads the encryption keys and brings the files in the path up to date while
+// holding the write lock, so that other processes do not create a different salt or
+// read files that are being migrated or quarantined.
+func (s *Store) prepare(conf config.LocalStorageConfig) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// Load the encryption keys, which may require the salt stored in the path
+	if s.keys, err = loadKeyring(conf); err != nil {
+		return err
+	}
+
+	// Move the files of an earlier version of courier into the resource directories
+	if _, err = s.migrate(); err != nil {
+		return err
+	}
+
+	// Quarantine any files left behind by writes that were interrupted
+	if _, err = s.recover(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// mkdir creates the storage directory with the directory mode, which is applied
+// regardless of the umask. The permissions of an existing directory are not changed
+// but a warning is logged if they allow more access than the directory mode.
+func (s *Store) mkdir() (err error) {
+	var info fs.FileInfo
+	if info, err = os.Stat(s.path); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("local storage path %s is not a directory", s.path)
+		}
+
+		if perm := info.Mode().Perm(); perm&^s.dirMode != 0 {
+			log.Warn().Str("path", s.path).Str("mode", fmt.Sprintf("%04o", perm)).Msg("local storage directory allows more access than the configured directory mode")
+		}
+		return nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err = os.MkdirAll(s.path, s.dirMode); err != nil {
+		return err
+	}
+	return os.Chmod(s.path, s.dirMode)
+}
+
+// fileMode returns the permissions of the files in the store.
+func fileMode(conf config.LocalStorageConfig) os.FileMode {
+	if conf.FileMode != 0 {
+		return os.FileMode(conf.FileMode)
+	}
+	return defaultFileMode
+}
+
+// Store implements the store.Store interface for local storage. The lock is held by
+// every read and write so that courier processes that share the path do not observe
+// partially written resources.
 type Store struct {
-	sync.RWMutex
-	path string
+	rwlock
+	path        string
+	maxVersions int
+	raw         bool
+	dirMode     os.FileMode
+	fileMode    os.FileMode
+	keys        *keyring
+	rekeying    bool // unencrypted files are only read while they are rekeyed
+	watcher     *watcher
 }
 
 var _ store.Store = &Store{}
 
-// Close the local storage backend.
+// Close the local storage backend, stopping the watcher if it is running and releasing
+// the lock file.
 func (s *Store) Close() error {
+	s.stopWatching()
+	return s.closeLock()
+}
+
+// Count the certificates and passwords in the local storage backend, excluding prior
+// versions of certificates.
+func (s *Store) Count(ctx context.Context) (counts store.Counts, err error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, count := range []struct {
+		prefix string
+		n      *int
+	}{
+		{store.CertificatePrefix, &counts.Certificates},
+		{store.PasswordPrefix, &counts.Passwords},
+		{store.SecretPrefix, &counts.Secrets},
+	} {
+		var ids []string
+		if ids, err = s.ids(count.prefix); err != nil {
+			return counts, storeError(err)
+		}
+		*count.n = len(ids)
+	}
+	return counts, nil
+}
+
+// List the ids of the resources of the type in the local storage backend, excluding
+// prior versions of certificates.
+func (s *Store) List(ctx context.Context, prefix string) (ids []string, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	if ids, err = s.ids(prefix); err != nil {
+		return nil, storeError(err)
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Exists checks if the resource is in the local storage backend without reading it.
+func (s *Store) Exists(ctx context.Context, prefix, name string) (_ bool, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return false, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	if _, err = os.Stat(s.resourcePath(prefix, name)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, storeError(err)
+	}
+	return true, nil
+}
+
+// Modified returns the modification time of the file of the resource.
+func (s *Store) Modified(ctx context.Context, prefix, name string) (_ time.Time, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return time.Time{}, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	var info fs.FileInfo
+	if info, err = os.Stat(s.resourcePath(prefix, name)); err != nil {
+		return time.Time{}, storeError(err)
+	}
+	return info.ModTime(), nil
+}
+
+// resourcePath returns the path of the file of the latest version of the resource.
+// Passwords and secrets that were written before the store was switched between plain
+// files and archives are read in their previous format until they are written again.
+func (s *Store) resourcePath(prefix, name string) string {
+	path := s.fullPath(prefix, name, s.ext(prefix))
+	if prefix == store.CertificatePrefix {
+		return path
+	}
+
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if other := s.otherPath(prefix, name); exists(other) {
+			return other
+		}
+	}
+	return path
+}
+
+// ext returns the extension of the file of the latest version of resources of the
+// type. Certificate archives are stored as they are uploaded, and passwords and
+// secrets are stored as gzip archives unless the store is raw.
+func (s *Store) ext(prefix string) string {
+	if prefix == store.CertificatePrefix || s.raw {
+		return ""
+	}
+	return archiveExt
+}
+
+// otherPath returns the path of the latest version of a password or secret in the
+// format that the store does not write.
+func (s *Store) otherPath(prefix, name string) string {
+	if s.ext(prefix) == "" {
+		return s.fullPath(prefix, name, archiveExt)
+	}
+	return s.fullPath(prefix, name, "")
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Delete the resource like the delete method of its type.
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return store.DeleteResource(ctx, s, prefix, name)
+}
+
+// WriteBatch applies the writes in the batch while holding the store lock so that
+// readers never observe a partially applied batch. The files of each resource are
+// read before it is written; if any write fails the files of every resource written
+// by the batch are restored, including the certificate version history.
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var undo store.Undo
+	for _, op := range batch.Ops() {
+		var restore func() error
+		if restore, err = s.snapshot(op.Prefix, op.Name); err != nil {
+			return undo.Rollback(storeError(err))
+		}
+		undo.Push(restore)
+
+		if err = s.apply(op); err != nil {
+			return undo.Rollback(err)
+		}
+	}
 	return nil
 }
 
+// Rekey encrypts every file that is not encrypted with the current key, including
+// files that were written before encryption was enabled, and returns the number of
+// files that were rewritten. Once the store has been rekeyed the previous keys can be
+// removed from the configuration.
+func (s *Store) Rekey(ctx context.Context) (n int, err error) {
+	if s.keys == nil {
+		return 0, ErrNotEncrypted
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.rekeying = true
+	defer func() { s.rekeying = false }()
+
+	err = s.walk(func(prefix, name, path string) (err error) {
+		if !isResourceFile(filepath.Base(path)) {
+			return nil
+		}
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		var contents []byte
+		if contents, err = os.ReadFile(path); err != nil {
+			return storeError(err)
+		}
+
+		if encrypted(contents) && s.keys.isCurrent(contents) {
+			return nil
+		}
+
+		var data []byte
+		if data, err = s.read(path); err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(path, archiveExt) {
+			err = s.writeFile(path, data)
+		} else {
+			err = storeError(s.writeRaw(path, data))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Only the latest version of a resource is described by its metadata
+		if path == s.resourcePath(prefix, name) {
+			if err = s.markEncrypted(prefix, name); err != nil {
+				return storeError(err)
+			}
+		}
+		n++
+		return nil
+	})
+	return n, storeError(err)
+}
+
 //===========================================================================
 // Password Methods
 //===========================================================================
@@ -52,7 +370,48 @@ func (s *Store) Close() error {
 func (s *Store) GetPassword(ctx context.Context, id string) (password []byte, err error) {
 	s.RLock()
 	defer s.RUnlock()
-	return s.readFile(s.fullPath(store.PasswordPrefix, id, archiveExt))
+	return s.read(s.resourcePath(store.PasswordPrefix, id))
+}
+
+// GetPasswordVersion retrieves a password by id from the local storage backend. The
+// local backend only keeps the latest version so any other version returns an error.
+func (s *Store) GetPasswordVersion(ctx context.Context, id, version string) (password []byte, err error) {
+	if version != store.LatestVersion {
+		return nil, store.ErrNoVersioning
+	}
+	return s.GetPassword(ctx, id)
+}
+
+// GetPasswordWithToken retrieves a password by id along with its generation, which
+// is used as the concurrency token.
+func (s *Store) GetPasswordWithToken(ctx context.Context, id string) (password []byte, token string, err error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if password, err = s.read(s.resourcePath(store.PasswordPrefix, id)); err != nil {
+		return nil, "", err
+	}
+
+	if token, err = s.generation(store.PasswordPrefix, id); err != nil {
+		return nil, "", storeError(err)
+	}
+	return password, token, nil
+}
+
+// CompareAndUpdatePassword updates a password by id if its generation matches the
+// token and returns the new generation.
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, id, token string, password []byte) (_ string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err = s.compare(store.PasswordPrefix, id, token); err != nil {
+		return "", err
+	}
+
+	if err = s.updatePassword(id, password); err != nil {
+		return "", err
+	}
+	return s.generation(store.PasswordPrefix, id)
 }
 
 // UpdatePassword updates a password by id in the local storage backend. If the
@@ -60,7 +419,23 @@ func (s *Store) GetPassword(ctx context.Context, id string) (password []byte, er
 func (s *Store) UpdatePassword(ctx context.Context, id string, password []byte) (err error) {
 	s.Lock()
 	defer s.Unlock()
-	return s.writeFile(s.fullPath(store.PasswordPrefix, id, archiveExt), password)
+	return s.updatePassword(id, password)
+}
+
+// DeletePassword removes a password by id from the local storage backend.
+func (s *Store) DeletePassword(ctx context.Context, id string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.deletePassword(id)
+}
+
+// PrunePasswordVersions is a no-op for the local storage backend since only the
+// latest version of each password is kept.
+func (s *Store) PrunePasswordVersions(ctx context.Context, id string, keep int) error {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+	return nil
 }
 
 //===========================================================================
@@ -73,48 +448,452 @@ func (s *Store) GetCertificate(ctx context.Context, name string) (cert []byte, e
 	defer s.RUnlock()
 
 	// Load the certificate archive into bytes
-	if cert, err = os.ReadFile(s.fullPath(store.CertificatePrefix, name, "")); err != nil {
-		if os.IsNotExist(err) {
-			return nil, store.ErrNotFound
-		}
-		return nil, err
+	return s.readRaw(s.fullPath(store.CertificatePrefix, name, ""))
+}
+
+// GetCertificateVersion retrieves a specific version of the certificate data by id
+// from the local storage backend.
+func (s *Store) GetCertificateVersion(ctx context.Context, name, version string) (cert []byte, err error) {
+	if version == store.LatestVersion {
+		return s.GetCertificate(ctx, name)
+	}
+
+	var n int
+	if n, err = strconv.Atoi(version); err != nil || n < 1 {
+		return nil, store.ErrNotFound
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.readRaw(s.versionPath(store.CertificatePrefix, name, n))
+}
+
+// GetCertificateWithToken retrieves certificate data by id along with its
+// generation, which is used as the concurrency token.
+func (s *Store) GetCertificateWithToken(ctx context.Context, name string) (cert []byte, token string, err error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if cert, err = s.readRaw(s.fullPath(store.CertificatePrefix, name, "")); err != nil {
+		return nil, "", err
 	}
 
-	return cert, nil
+	if token, err = s.generation(store.CertificatePrefix, name); err != nil {
+		return nil, "", storeError(err)
+	}
+	return cert, token, nil
 }
 
-// UpdateCertificate updates certificate data in the local storage backend.
+// CompareAndUpdateCertificate updates certificate data by id if its generation
+// matches the token and returns the new generation.
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, name, token string, cert []byte) (_ string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err = s.compare(store.CertificatePrefix, name, token); err != nil {
+		return "", err
+	}
+
+	if err = s.updateCertificate(name, cert); err != nil {
+		return "", err
+	}
+	return s.generation(store.CertificatePrefix, name)
+}
+
+// ListCertificateVersions returns the versions of the certificate data by id that are
+// kept by the local storage backend, newest first.
+func (s *Store) ListCertificateVersions(ctx context.Context, name string) (versions []store.Version, err error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	// Ensure the certificate exists
+	if _, err = os.Stat(s.fullPath(store.CertificatePrefix, name, "")); err != nil {
+		return nil, storeError(err)
+	}
+
+	var numbers []int
+	if numbers, err = s.versions(store.CertificatePrefix, name); err != nil {
+		return nil, storeError(err)
+	}
+
+	versions = make([]store.Version, 0, len(numbers))
+	for _, n := range numbers {
+		var info fs.FileInfo
+		if info, err = os.Stat(s.versionPath(store.CertificatePrefix, name, n)); err != nil {
+			return nil, storeError(err)
+		}
+
+		versions = append(versions, store.Version{
+			Version: strconv.Itoa(n),
+			Created: info.ModTime(),
+			State:   "enabled",
+		})
+	}
+	return versions, nil
+}
+
+// UpdateCertificate updates certificate data in the local storage backend. A copy of
+// the data is kept as a new version so that prior versions can be retrieved.
 func (s *Store) UpdateCertificate(ctx context.Context, name string, cert []byte) (err error) {
 	s.Lock()
 	defer s.Unlock()
-	return os.WriteFile(s.fullPath(store.CertificatePrefix, name, ""), cert, 0644)
+	return s.updateCertificate(name, cert)
+}
+
+// DeleteCertificate removes certificate data and all of its versions from the local
+// storage backend.
+func (s *Store) DeleteCertificate(ctx context.Context, name string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.deleteCertificate(name)
+}
+
+// PruneCertificateVersions removes all but the keep most recent versions of the
+// certificate data from the local storage backend.
+func (s *Store) PruneCertificateVersions(ctx context.Context, name string, keep int) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return storeError(s.pruneVersions(store.CertificatePrefix, name, keep))
+}
+
+//===========================================================================
+// Secret Methods
+//===========================================================================
+
+// GetSecret retrieves a secret by name from the local storage backend.
+func (s *Store) GetSecret(ctx context.Context, name string) (secret []byte, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.read(s.resourcePath(store.SecretPrefix, name))
+}
+
+// UpdateSecret creates or overwrites a secret by name in the local storage backend.
+func (s *Store) UpdateSecret(ctx context.Context, name string, secret []byte) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.updateSecret(name, secret)
+}
+
+// DeleteSecret removes a secret by name from the local storage backend.
+func (s *Store) DeleteSecret(ctx context.Context, name string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.deleteSecret(name)
+}
+
+//===========================================================================
+// Write methods (the caller must hold the write lock)
+//===========================================================================
+
+// apply a single batch write to the local storage backend.
+func (s *Store) apply(op store.Op) error {
+	switch {
+	case op.Prefix == store.PasswordPrefix && op.Delete:
+		return s.deletePassword(op.Name)
+	case op.Prefix == store.PasswordPrefix:
+		return s.updatePassword(op.Name, op.Data)
+	case op.Prefix == store.CertificatePrefix && op.Delete:
+		return s.deleteCertificate(op.Name)
+	case op.Prefix == store.CertificatePrefix:
+		return s.updateCertificate(op.Name, op.Data)
+	case op.Prefix == store.SecretPrefix && op.Delete:
+		return s.deleteSecret(op.Name)
+	case op.Prefix == store.SecretPrefix:
+		return s.updateSecret(op.Name, op.Data)
+	default:
+		return fmt.Errorf("unknown resource type %q in batch", op.Prefix)
+	}
+}
+
+func (s *Store) updatePassword(id string, password []byte) (err error) {
+	return s.writeLatest(store.PasswordPrefix, id, password)
+}
+
+func (s *Store) deletePassword(id string) (err error) {
+	return s.removeLatest(store.PasswordPrefix, id)
+}
+
+func (s *Store) updateCertificate(name string, cert []byte) (err error) {
+	var numbers []int
+	if numbers, err = s.versions(store.CertificatePrefix, name); err != nil {
+		return storeError(err)
+	}
+
+	next := 1
+	if len(numbers) > 0 {
+		next = numbers[0] + 1
+	}
+
+	if err = s.writeRaw(s.versionPath(store.CertificatePrefix, name, next), cert); err != nil {
+		return storeError(err)
+	}
+	if err = s.writeRaw(s.fullPath(store.CertificatePrefix, name, ""), cert); err != nil {
+		return storeError(err)
+	}
+
+	if err = s.writeMeta(store.CertificatePrefix, name, cert); err != nil {
+		return storeError(err)
+	}
+
+	// Remove the oldest versions that exceed the configured history
+	if s.maxVersions > 0 {
+		return storeError(s.pruneVersions(store.CertificatePrefix, name, s.maxVersions))
+	}
+	return nil
+}
+
+func (s *Store) deleteCertificate(name string) (err error) {
+	if err = s.removeFile(s.fullPath(store.CertificatePrefix, name, "")); err != nil {
+		return storeError(err)
+	}
+
+	var numbers []int
+	if numbers, err = s.versions(store.CertificatePrefix, name); err != nil {
+		return storeError(err)
+	}
+
+	for _, n := range numbers {
+		if err = s.removeFile(s.versionPath(store.CertificatePrefix, name, n)); err != nil {
+			return storeError(err)
+		}
+	}
+	if err = s.removeMeta(store.CertificatePrefix, name); err != nil {
+		return storeError(err)
+	}
+	return storeError(s.removeDir(store.CertificatePrefix, name))
+}
+
+func (s *Store) updateSecret(name string, secret []byte) (err error) {
+	return s.writeLatest(store.SecretPrefix, name, secret)
+}
+
+func (s *Store) deleteSecret(name string) (err error) {
+	return s.removeLatest(store.SecretPrefix, name)
+}
+
+// writeLatest writes the only version of a password or secret as a plain file if the
+// store is raw and as an archive otherwise, removing the file in the other format.
+func (s *Store) writeLatest(prefix, name string, data []byte) (err error) {
+	path := s.fullPath(prefix, name, s.ext(prefix))
+	if s.raw {
+		err = storeError(s.writeRaw(path, data))
+	} else {
+		err = s.writeFile(path, data)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = s.removeFile(s.otherPath(prefix, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return storeError(err)
+	}
+	return storeError(s.writeMeta(prefix, name, data))
+}
+
+// removeLatest removes a password or secret along with its metadata and directory.
+func (s *Store) removeLatest(prefix, name string) (err error) {
+	if err = s.removeFile(s.resourcePath(prefix, name)); err != nil {
+		return storeError(err)
+	}
+	if err = s.removeMeta(prefix, name); err != nil {
+		return storeError(err)
+	}
+	return storeError(s.removeDir(prefix, name))
+}
+
+// compare returns ErrVersionMismatch unless the generation of the named resource
+// matches the token. An empty token only matches a resource that does not exist.
+func (s *Store) compare(prefix, name, token string) (err error) {
+	if _, err = os.Stat(s.resourcePath(prefix, name)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			if token == "" {
+				return nil
+			}
+			return store.ErrVersionMismatch
+		}
+		return storeError(err)
+	}
+
+	var current string
+	if current, err = s.generation(prefix, name); err != nil {
+		return storeError(err)
+	}
+
+	if current != token {
+		return store.ErrVersionMismatch
+	}
+	return nil
+}
+
+// pruneVersions removes all but the keep most recent versions of the named resource.
+func (s *Store) pruneVersions(prefix, name string, keep int) (err error) {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+
+	var numbers []int
+	if numbers, err = s.versions(prefix, name); err != nil {
+		return err
+	}
+
+	if keep >= len(numbers) {
+		return nil
+	}
+
+	for _, n := range numbers[keep:] {
+		if err = s.removeFile(s.versionPath(prefix, name, n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshot reads the files of the named resource, including all of its versions, and
+// returns a function that restores the resource to that state by rewriting the files
+// and removing any files that were created since the snapshot was taken.
+func (s *Store) snapshot(prefix, name string) (restore func() error, err error) {
+	var paths []string
+	if paths, err = s.files(prefix, name); err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		if files[path], err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	restore = func() (err error) {
+		var current []string
+		if current, err = s.files(prefix, name); err != nil {
+			return err
+		}
+
+		for _, path := range current {
+			if _, ok := files[path]; !ok {
+				if err = s.remove(path); err != nil {
+					return err
+				}
+			}
+		}
+
+		for path, data := range files {
+			if err = s.writeAtomic(path, data); err != nil {
+				return err
+			}
+		}
+		return s.removeDir(prefix, name)
+	}
+	return restore, nil
 }
 
 //===========================================================================
 // Helper methods
 //===========================================================================
 
-// fullPath returns the full path to an archive file in the local storage backend.
+// fullPath returns the full path to a file in the directory of the named resource.
 func (s *Store) fullPath(prefix, name, ext string) string {
-	return filepath.Join(s.path, prefix+"-"+name+ext)
+	return filepath.Join(s.resourceDir(prefix, name), dataFile+ext)
 }
 
-// read returns file data by archive path from the local storage
-func (s *Store) readFile(path string) (data []byte, err error) {
-	var f *os.File
-	if f, err = os.Open(path); err != nil {
-		if os.IsNotExist(err) {
-			return nil, store.ErrNotFound
+// versionPath returns the path to a specific version of a file in the local storage.
+func (s *Store) versionPath(prefix, name string, version int) string {
+	return s.fullPath(prefix, name, "@"+strconv.Itoa(version))
+}
+
+// versions returns the version numbers stored for the named file, newest first.
+func (s *Store) versions(prefix, name string) (numbers []int, err error) {
+	var entries []fs.DirEntry
+	if entries, err = os.ReadDir(s.resourceDir(prefix, name)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
 		}
 		return nil, err
 	}
 
+	base := dataFile + "@"
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), base) {
+			continue
+		}
+
+		var n int
+		if n, err = strconv.Atoi(strings.TrimPrefix(entry.Name(), base)); err != nil {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(numbers)))
+	return numbers, nil
+}
+
+// files returns the paths of all files stored for the named resource.
+func (s *Store) files(prefix, name string) (paths []string, err error) {
+	dir := s.resourceDir(prefix, name)
+
+	var entries []fs.DirEntry
+	if entries, err = os.ReadDir(dir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempPrefix) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, entry.Name()))
+	}
+	return paths, nil
+}
+
+// read returns file data by archive path from the local storage and verifies it
+// against its checksum. Archives that cannot be decompressed are also corrupted.
+func (s *Store) readFile(path string) (data []byte, err error) {
+	if data, err = os.ReadFile(path); err != nil {
+		return nil, storeError(err)
+	}
+
+	if data, err = s.decrypt(path, data); err != nil {
+		return nil, err
+	}
+
 	var reader *gzip.Reader
-	if reader, err = gzip.NewReader(f); err != nil {
+	if reader, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+	}
+
+	if data, err = io.ReadAll(reader); err != nil {
+		return nil, fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+	}
+
+	if err = s.verify(path, data); err != nil {
 		return nil, err
 	}
+	return data, nil
+}
 
-	return io.ReadAll(reader)
+// readRaw returns the data of an uncompressed file and verifies it against its
+// checksum.
+func (s *Store) readRaw(path string) (data []byte, err error) {
+	if data, err = os.ReadFile(path); err != nil {
+		return nil, storeError(err)
+	}
+
+	if data, err = s.decrypt(path, data); err != nil {
+		return nil, err
+	}
+
+	if err = s.verify(path, data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 // write saves file data to an archive file in the local storage
@@ -130,5 +909,215 @@ func (s *Store) writeFile(path string, data []byte) (err error) {
 	if err = writer.Close(); err != nil {
 		return err
 	}
-	return os.WriteFile(path, b.Bytes(), 0644)
+
+	return storeError(s.write(path, b.Bytes(), data))
+}
+
+// writeRaw saves the data to an uncompressed file along with its checksum.
+func (s *Store) writeRaw(path string, data []byte) (err error) {
+	return s.write(path, data, data)
+}
+
+// write saves the contents of the file that stores the data. The file and its checksum
+// cannot be replaced together, so the checksum of the data is written alongside the
+// checksum of the current file first and reduced to the checksum of the data once the
+// file has been replaced; if the write is interrupted the file matches one of them. If
+// encryption is enabled the contents are encrypted and the checksum of the data is not
+// kept since it would reveal a digest of the plaintext and the ciphertext is already
+// authenticated.
+func (s *Store) write(path string, contents, data []byte) (err error) {
+	if s.keys == nil {
+		checksum := store.Checksum(data)
+
+		var current string
+		if current, err = s.currentChecksum(path); err != nil {
+			return err
+		}
+
+		if current != "" {
+			if err = s.writeChecksum(path, checksum, current); err != nil {
+				return err
+			}
+		}
+
+		if err = s.writeAtomic(path, contents); err != nil {
+			return err
+		}
+		return s.writeChecksum(path, checksum)
+	}
+
+	if contents, err = s.keys.seal(contents, s.resource(path)); err != nil {
+		return err
+	}
+
+	// Remove the checksum first so that it is never kept alongside encrypted contents
+	if err = s.remove(path + checksumExt); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return s.writeAtomic(path, contents)
+}
+
+// decrypt returns the contents of the file at path, decrypting them if they were
+// written by an encrypted store. If encryption is enabled, files that are not
+// encrypted are only read while they are rekeyed, since their contents are not
+// authenticated and could have been placed in the storage directory by anyone.
+func (s *Store) decrypt(path string, contents []byte) (_ []byte, err error) {
+	if !encrypted(contents) {
+		if s.keys != nil && !s.rekeying {
+			return nil, fmt.Errorf("%w: %s", ErrUnencrypted, filepath.Base(path))
+		}
+		return contents, nil
+	}
+
+	if s.keys == nil {
+		return nil, fmt.Errorf("%w: %s", ErrMissingKey, filepath.Base(path))
+	}
+
+	if contents, err = s.keys.open(contents, s.resource(path)); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, filepath.Base(path))
+	}
+	return contents, nil
+}
+
+// resource identifies the resource stored in the file at path by its type and id so
+// that it is authenticated with the encrypted contents of the file.
+func (s *Store) resource(path string) []byte {
+	prefix, name := parsePath(s.relPath(path))
+	return []byte(prefix + "/" + name)
+}
+
+// writeChecksum saves the checksums, one per line, in a file alongside the file at
+// path. Checksums are computed from the uncompressed data so that they verify the data
+// that is returned to the caller.
+func (s *Store) writeChecksum(path string, checksums ...string) error {
+	return s.writeAtomic(path+checksumExt, []byte(strings.Join(checksums, "\n")))
+}
+
+// readChecksums returns the checksums stored alongside the file at path, which has
+// more than one checksum if a write of the file was interrupted. Files written before
+// checksums were kept have no checksums.
+func readChecksums(path string) (_ []string, err error) {
+	var data []byte
+	if data, err = os.ReadFile(path + checksumExt); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, storeError(err)
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// currentChecksum returns the checksum of the data of the file at path that a write
+// must keep until the file has been replaced, or an empty string if the file does not
+// have a checksum.
+func (s *Store) currentChecksum(path string) (_ string, err error) {
+	var checksums []string
+	if checksums, err = readChecksums(path); err != nil || len(checksums) < 2 {
+		if len(checksums) == 1 {
+			return checksums[0], err
+		}
+		return "", err
+	}
+
+	// A prior write was interrupted, so the file matches one of the checksums
+	var contents []byte
+	if contents, err = os.ReadFile(path); err != nil {
+		return "", storeError(err)
+	}
+
+	if strings.HasSuffix(path, archiveExt) {
+		var reader *gzip.Reader
+		if reader, err = gzip.NewReader(bytes.NewReader(contents)); err != nil {
+			return "", fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+		}
+
+		if contents, err = io.ReadAll(reader); err != nil {
+			return "", fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+		}
+	}
+	return store.Checksum(contents), nil
+}
+
+// verify returns ErrCorrupted if the data read from the file at path does not match
+// any of the checksums stored alongside it, e.g. because of bit rot or a manual edit.
+// Files written before checksums were kept have no checksum file and are not verified.
+func (s *Store) verify(path string, data []byte) (err error) {
+	var checksums []string
+	if checksums, err = readChecksums(path); err != nil || checksums == nil {
+		return err
+	}
+
+	for _, checksum := range checksums {
+		if err = store.VerifyChecksum(data, checksum); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", err, filepath.Base(path))
+}
+
+// removeFile removes the file at path along with its checksum.
+func (s *Store) removeFile(path string) (err error) {
+	if err = s.remove(path); err != nil {
+		return err
+	}
+
+	if err = s.remove(path + checksumExt); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeAtomic atomically replaces the file at path with the data, recording the write
+// so that it is not reported as an out-of-band change. The directory of the resource
+// is created if this is its first file.
+func (s *Store) writeAtomic(path string, data []byte) (err error) {
+	if err = s.mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	s.touch(path)
+	return writeAtomic(path, data, s.fileMode)
+}
+
+// remove durably removes the file at path, recording the removal so that it is not
+// reported as an out-of-band change.
+func (s *Store) remove(path string) error {
+	s.touch(path)
+	return removeDurable(path)
+}
+
+// isResourceFile returns true if the file in the directory of a resource stores the
+// data of the resource rather than its metadata or checksum.
+func isResourceFile(name string) bool {
+	if strings.HasSuffix(name, metaExt) || strings.HasSuffix(name, checksumExt) {
+		return false
+	}
+	return name == dataFile || name == dataFile+archiveExt || strings.HasPrefix(name, dataFile+"@")
+}
+
+// hasResourcePrefix returns true if the file in the storage directory belongs to a
+// resource stored by an earlier version of courier.
+func hasResourcePrefix(name string) bool {
+	for _, prefix := range []string{store.CertificatePrefix, store.PasswordPrefix, store.SecretPrefix} {
+		if strings.HasPrefix(name, prefix+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// storeError maps file system errors to the typed errors exported by the store.
+func storeError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%w: %v", store.ErrNotFound, err)
+	case errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("%w: %v", store.ErrAlreadyExists, err)
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("%w: %v", store.ErrPermissionDenied, err)
+	default:
+		return err
+	}
 }
@@ -1,12 +1,21 @@
 package local_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/suite"
 	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/o11y"
 	"github.com/trisacrypto/courier/pkg/store"
 	"github.com/trisacrypto/courier/pkg/store/local"
 )
@@ -56,6 +65,27 @@ func (s *localStoreTestSuite) TestPasswordStore() {
 	actual, err := s.store.GetPassword(ctx, "password_id")
 	require.NoError(err, "should be able to get a password")
 	require.Equal(password, actual, "wrong password returned")
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err, "should be able to count the store")
+	require.Equal(1, counts.Passwords, "wrong number of passwords counted")
+
+	// Only the latest version is available from the local store
+	actual, err = s.store.GetPasswordVersion(ctx, "password_id", store.LatestVersion)
+	require.NoError(err, "should be able to get the latest password version")
+	require.Equal(password, actual, "wrong password returned")
+	_, err = s.store.GetPasswordVersion(ctx, "password_id", "1")
+	require.ErrorIs(err, store.ErrNoVersioning, "local store should not support versions")
+
+	// Delete the password
+	err = s.store.DeletePassword(ctx, "password_id")
+	require.NoError(err, "should be able to delete a password")
+
+	_, err = s.store.GetPassword(ctx, "password_id")
+	require.ErrorIs(err, store.ErrNotFound, "password should not exist after delete")
+
+	err = s.store.DeletePassword(ctx, "password_id")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if password does not exist")
 }
 
 func (s *localStoreTestSuite) TestCertificateStore() {
@@ -75,4 +105,1076 @@ func (s *localStoreTestSuite) TestCertificateStore() {
 	actual, err := s.store.GetCertificate(ctx, "certificate_id")
 	require.NoError(err, "should be able to get a certificate")
 	require.Equal(cert, actual, "wrong certificate returned")
+
+	// Delete the certificate
+	err = s.store.DeleteCertificate(ctx, "certificate_id")
+	require.NoError(err, "should be able to delete a certificate")
+
+	_, err = s.store.GetCertificate(ctx, "certificate_id")
+	require.ErrorIs(err, store.ErrNotFound, "certificate should not exist after delete")
+
+	err = s.store.DeleteCertificate(ctx, "certificate_id")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if certificate does not exist")
+}
+
+func (s *localStoreTestSuite) TestSecretStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// Try to get a secret that does not exist
+	_, err := s.store.GetSecret(ctx, "does-not-exist")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if secret does not exist")
+
+	// Create a secret
+	secret := []byte("supersecretsquirrel")
+	err = s.store.UpdateSecret(ctx, "secret_id", secret)
+	require.NoError(err, "should be able to create a secret")
+
+	// Get the secret
+	actual, err := s.store.GetSecret(ctx, "secret_id")
+	require.NoError(err, "should be able to get a secret")
+	require.Equal(secret, actual, "wrong secret returned")
+
+	// Secrets should not be confused with passwords or certificates of the same name
+	_, err = s.store.GetPassword(ctx, "secret_id")
+	require.ErrorIs(err, store.ErrNotFound, "secret should not be returned as a password")
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err, "should be able to count the store")
+	require.Equal(1, counts.Secrets, "wrong number of secrets counted")
+
+	// Replace the secret
+	secret = []byte("anothersecret")
+	err = s.store.UpdateSecret(ctx, "secret_id", secret)
+	require.NoError(err, "should be able to replace a secret")
+
+	actual, err = s.store.GetSecret(ctx, "secret_id")
+	require.NoError(err, "should be able to get a secret")
+	require.Equal(secret, actual, "wrong secret returned after replace")
+
+	// Delete the secret
+	err = s.store.DeleteSecret(ctx, "secret_id")
+	require.NoError(err, "should be able to delete a secret")
+
+	_, err = s.store.GetSecret(ctx, "secret_id")
+	require.ErrorIs(err, store.ErrNotFound, "secret should not exist after delete")
+
+	err = s.store.DeleteSecret(ctx, "secret_id")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if secret does not exist")
+}
+
+func (s *localStoreTestSuite) TestCertificateVersions() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// Listing versions of a certificate that does not exist should error
+	_, err := s.store.ListCertificateVersions(ctx, "does-not-exist")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if certificate does not exist")
+
+	// Create several versions of a certificate
+	for _, cert := range []string{"first", "second", "third"} {
+		require.NoError(s.store.UpdateCertificate(ctx, "versioned", []byte(cert)))
+	}
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err, "should be able to count the store")
+	require.Equal(1, counts.Certificates, "prior versions should not be counted")
+
+	versions, err := s.store.ListCertificateVersions(ctx, "versioned")
+	require.NoError(err, "should be able to list certificate versions")
+	require.Len(versions, 3, "wrong number of versions returned")
+	require.Equal("3", versions[0].Version, "versions should be ordered newest first")
+	require.Equal("1", versions[2].Version, "versions should be ordered newest first")
+
+	actual, err := s.store.GetCertificateVersion(ctx, "versioned", "2")
+	require.NoError(err, "should be able to get a prior version")
+	require.Equal([]byte("second"), actual, "wrong version returned")
+
+	actual, err = s.store.GetCertificateVersion(ctx, "versioned", store.LatestVersion)
+	require.NoError(err, "should be able to get the latest version")
+	require.Equal([]byte("third"), actual, "wrong version returned")
+
+	_, err = s.store.GetCertificateVersion(ctx, "versioned", "4")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if version does not exist")
+
+	// The latest version can never be pruned
+	for _, keep := range []int{0, -1} {
+		require.ErrorIs(s.store.PruneCertificateVersions(ctx, "versioned", keep), store.ErrInvalidKeep)
+		require.ErrorIs(s.store.PrunePasswordVersions(ctx, "versioned", keep), store.ErrInvalidKeep)
+	}
+	versions, err = s.store.ListCertificateVersions(ctx, "versioned")
+	require.NoError(err, "should be able to list certificate versions")
+	require.Len(versions, 3, "no versions should be removed when keep is invalid")
+
+	// Pruning removes all but the most recent versions
+	require.NoError(s.store.PruneCertificateVersions(ctx, "versioned", 1))
+	versions, err = s.store.ListCertificateVersions(ctx, "versioned")
+	require.NoError(err, "should be able to list certificate versions")
+	require.Len(versions, 1, "expected prior versions to be pruned")
+	require.Equal("3", versions[0].Version, "expected latest version to be kept")
+
+	// Deleting the certificate removes all of its versions
+	require.NoError(s.store.DeleteCertificate(ctx, "versioned"))
+	_, err = s.store.GetCertificateVersion(ctx, "versioned", "3")
+	require.ErrorIs(err, store.ErrNotFound, "versions should not exist after delete")
+}
+
+func (s *localStoreTestSuite) TestMaxVersions() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// Open a store that only keeps the two most recent versions
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: s.T().TempDir(), MaxVersions: 2})
+	require.NoError(err, "could not open local storage backend")
+	defer db.Close()
+
+	for _, cert := range []string{"first", "second", "third"} {
+		require.NoError(db.UpdateCertificate(ctx, "versioned", []byte(cert)))
+	}
+
+	versions, err := db.ListCertificateVersions(ctx, "versioned")
+	require.NoError(err, "should be able to list certificate versions")
+	require.Len(versions, 2, "expected versions beyond the max to be pruned")
+	require.Equal("3", versions[0].Version)
+	require.Equal("2", versions[1].Version)
+
+	_, err = db.GetCertificateVersion(ctx, "versioned", "1")
+	require.ErrorIs(err, store.ErrNotFound, "oldest version should have been pruned")
+}
+
+func (s *localStoreTestSuite) TestFileModes() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.UpdateCertificate(ctx, "private", []byte("certificate")))
+	require.NoError(s.store.UpdatePassword(ctx, "private", []byte("password")))
+	defer s.store.DeleteCertificate(ctx, "private")
+	defer s.store.DeletePassword(ctx, "private")
+
+	// Certificates, their versions, and passwords should only be readable by courier
+	paths, err := filepath.Glob(filepath.Join(s.conf.Path, "*", "private", "*"))
+	require.NoError(err)
+	require.NotEmpty(paths)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		require.NoError(err)
+		require.Equal(os.FileMode(0600), info.Mode().Perm(), "wrong file mode for %s", path)
+	}
+}
+
+func (s *localStoreTestSuite) TestChecksums() {
+	require := s.Require()
+	ctx := context.Background()
+
+	dir := s.T().TempDir()
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+	defer db.Close()
+
+	require.NoError(db.UpdateCertificate(ctx, "checked", []byte("certificate")))
+	require.NoError(db.UpdatePassword(ctx, "checked", []byte("password")))
+	require.NoError(db.UpdateSecret(ctx, "checked", []byte("secret")))
+
+	// Checksums should not be counted as resources
+	counts, err := db.Count(ctx)
+	require.NoError(err, "could not count resources")
+	require.Equal(store.Counts{Certificates: 1, Passwords: 1, Secrets: 1}, counts)
+
+	// Manually edited certificates should fail their integrity check
+	path := filepath.Join(dir, "certs", "checked", "data")
+	require.NoError(os.WriteFile(path, []byte("edited"), 0600))
+	_, err = db.GetCertificate(ctx, "checked")
+	require.ErrorIs(err, store.ErrCorrupted, "expected edited certificate to be corrupted")
+	_, _, err = db.GetCertificateWithToken(ctx, "checked")
+	require.ErrorIs(err, store.ErrCorrupted, "expected edited certificate to be corrupted")
+
+	// Corrupted reads are counted when the store is instrumented by the server
+	corruptions := o11y.StoreCorruptions.WithLabelValues("local", "get_certificate")
+	before := testutil.ToFloat64(corruptions)
+	_, err = store.Instrumented(db, "local").GetCertificate(ctx, "checked")
+	require.ErrorIs(err, store.ErrCorrupted, "expected instrumented store to return the corruption")
+	require.Equal(before+1, testutil.ToFloat64(corruptions), "expected the corruption to be counted")
+
+	// Prior versions are verified separately from the latest version
+	cert, err := db.GetCertificateVersion(ctx, "checked", "1")
+	require.NoError(err, "unmodified version should pass its integrity check")
+	require.Equal([]byte("certificate"), cert)
+
+	// Damaged archives should also be reported as corrupted
+	path = filepath.Join(dir, "passwords", "checked", "data.gz")
+	data, err := os.ReadFile(path)
+	require.NoError(err)
+	data[len(data)-5] ^= 0xff
+	require.NoError(os.WriteFile(path, data, 0600))
+	_, err = db.GetPassword(ctx, "checked")
+	require.ErrorIs(err, store.ErrCorrupted, "expected damaged password archive to be corrupted")
+
+	// Files written before checksums were kept are not verified
+	require.NoError(os.Remove(filepath.Join(dir, "secrets", "checked", "data.gz.sha256")))
+	secret, err := db.GetSecret(ctx, "checked")
+	require.NoError(err, "files without checksums should still be readable")
+	require.Equal([]byte("secret"), secret)
+
+	// A write interrupted after its checksum was written leaves both checksums, so the
+	// file is readable whether or not it was replaced
+	require.NoError(db.UpdateSecret(ctx, "checked", []byte("secret")))
+	sidecar := filepath.Join(dir, "secrets", "checked", "data.gz.sha256")
+	require.NoError(os.WriteFile(sidecar, []byte(store.Checksum([]byte("secret 2"))+"\n"+store.Checksum([]byte("secret"))), 0600))
+	secret, err = db.GetSecret(ctx, "checked")
+	require.NoError(err, "expected the file to match the checksum written before the interrupted write")
+	require.Equal([]byte("secret"), secret)
+
+	// The next write keeps only the checksum of the data that it wrote
+	require.NoError(db.UpdateSecret(ctx, "checked", []byte("secret 3")))
+	checksums, err := os.ReadFile(sidecar)
+	require.NoError(err)
+	require.Equal(store.Checksum([]byte("secret 3")), string(checksums))
+	secret, err = db.GetSecret(ctx, "checked")
+	require.NoError(err)
+	require.Equal([]byte("secret 3"), secret)
+
+	// Deleting a resource should remove its checksums
+	require.NoError(db.DeleteCertificate(ctx, "checked"))
+	require.NoError(db.DeletePassword(ctx, "checked"))
+	require.NoError(db.DeleteSecret(ctx, "checked"))
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*", "*.sha256"))
+	require.NoError(err)
+	require.Empty(paths, "expected checksums to be deleted with their resources")
+
+	// The directory of a deleted resource is removed with its files
+	require.NoDirExists(filepath.Join(dir, "certs", "checked"), "expected the resource directory to be removed")
+}
+
+func (s *localStoreTestSuite) TestList() {
+	require := s.Require()
+	ctx := context.Background()
+
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: s.T().TempDir()})
+	require.NoError(err, "could not open local storage backend")
+	defer db.Close()
+
+	require.NoError(db.UpdateCertificate(ctx, "listed", []byte("certificate")))
+	require.NoError(db.UpdateCertificate(ctx, "listed", []byte("certificate 2")))
+	require.NoError(db.UpdateCertificate(ctx, "listed-too", []byte("certificate")))
+	require.NoError(db.UpdatePassword(ctx, "listed", []byte("password")))
+
+	// Checksums, metadata, and prior versions should not be listed as resources
+	for prefix, expected := range map[string][]string{
+		store.CertificatePrefix: {"listed", "listed-too"},
+		store.PasswordPrefix:    {"listed"},
+		store.SecretPrefix:      nil,
+	} {
+		ids, err := db.List(ctx, prefix)
+		require.NoError(err, "could not list resources")
+		require.Equal(expected, ids, "wrong %s ids listed", prefix)
+	}
+
+	_, err = db.List(ctx, "unknown")
+	require.ErrorIs(err, store.ErrUnknownResource)
+
+	// Resources can be checked and deleted by prefix
+	exists, err := db.Exists(ctx, store.CertificatePrefix, "listed")
+	require.NoError(err)
+	require.True(exists, "expected the certificate to exist")
+
+	exists, err = db.Exists(ctx, store.SecretPrefix, "listed")
+	require.NoError(err)
+	require.False(exists, "expected the secret not to exist")
+
+	modified, err := db.Modified(ctx, store.CertificatePrefix, "listed")
+	require.NoError(err)
+	require.WithinDuration(time.Now(), modified, time.Minute)
+
+	_, err = db.Modified(ctx, store.SecretPrefix, "listed")
+	require.ErrorIs(err, store.ErrNotFound)
+
+	require.NoError(db.Delete(ctx, store.PasswordPrefix, "listed"))
+	exists, err = db.Exists(ctx, store.PasswordPrefix, "listed")
+	require.NoError(err)
+	require.False(exists, "expected the password to be deleted")
+	require.ErrorIs(db.Delete(ctx, store.PasswordPrefix, "listed"), store.ErrNotFound)
+	require.ErrorIs(db.Delete(ctx, "unknown", "listed"), store.ErrUnknownResource)
+}
+
+func (s *localStoreTestSuite) TestLayout() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// Write the files of an earlier version of courier, which are all in the directory
+	dir := s.T().TempDir()
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	_, err := gz.Write([]byte("password"))
+	require.NoError(err)
+	require.NoError(gz.Close())
+
+	for name, data := range map[string][]byte{
+		"certificate-a-b":      []byte("certificate v2"),
+		"certificate-a-b@1":    []byte("certificate v1"),
+		"certificate-a-b@2":    []byte("certificate v2"),
+		"certificate-a-b.meta": []byte("2"),
+		"pkcs12-a-b.gz":        archive.Bytes(),
+		"notes.txt":            []byte("notes"),
+	} {
+		require.NoError(os.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+
+	// Opening the store moves the files into the directories of the resources
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+	defer db.Close()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.ElementsMatch([]string{".courier-lock", "certs", "passwords", "notes.txt"}, names, "expected only the resource directories and unrelated files")
+	require.FileExists(filepath.Join(dir, "certs", "a-b", "data@1"))
+
+	cert, token, err := db.GetCertificateWithToken(ctx, "a-b")
+	require.NoError(err, "should be able to read a migrated certificate")
+	require.Equal([]byte("certificate v2"), cert)
+	require.Equal("2", token, "the generation should be migrated with the certificate")
+
+	cert, err = db.GetCertificateVersion(ctx, "a-b", "1")
+	require.NoError(err, "should be able to read a migrated certificate version")
+	require.Equal([]byte("certificate v1"), cert)
+
+	password, err := db.GetPassword(ctx, "a-b")
+	require.NoError(err, "should be able to read a migrated password")
+	require.Equal([]byte("password"), password)
+
+	ids, err := db.List(ctx, store.CertificatePrefix)
+	require.NoError(err)
+	require.Equal([]string{"a-b"}, ids)
+
+	// Names cannot refer to a directory outside of the directory of their type
+	for _, name := range []string{"..", ".", "x/../../y"} {
+		require.NoError(db.UpdateSecret(ctx, name, []byte("secret")), "could not store secret %q", name)
+		secret, err := db.GetSecret(ctx, name)
+		require.NoError(err)
+		require.Equal([]byte("secret"), secret)
+	}
+
+	ids, err = db.List(ctx, store.SecretPrefix)
+	require.NoError(err)
+	require.Equal([]string{".", "..", "x/../../y"}, ids)
+
+	for _, name := range ids {
+		require.NoError(db.DeleteSecret(ctx, name))
+	}
+
+	entries, err = os.ReadDir(filepath.Join(dir, "secrets"))
+	require.NoError(err)
+	require.Empty(entries, "expected the directories of deleted secrets to be removed")
+	require.FileExists(filepath.Join(dir, "notes.txt"))
+}
+
+func (s *localStoreTestSuite) TestRaw() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// Raw stores write passwords and secrets as plain files that other tools can read
+	dir := s.T().TempDir()
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, Raw: true})
+	require.NoError(err, "could not open raw local storage backend")
+
+	require.NoError(db.UpdatePassword(ctx, "raw", []byte("supersecretsquirrel")))
+	require.NoError(db.UpdateSecret(ctx, "raw", []byte("secret")))
+	require.NoError(db.UpdateCertificate(ctx, "raw", []byte("certificate")))
+
+	for path, expected := range map[string]string{
+		filepath.Join("passwords", "raw", "data"): "supersecretsquirrel",
+		filepath.Join("secrets", "raw", "data"):   "secret",
+		filepath.Join("certs", "raw", "data"):     "certificate",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, path))
+		require.NoError(err)
+		require.Equal(expected, string(data), "expected %s to be a plain file", path)
+	}
+	require.NoFileExists(filepath.Join(dir, "passwords", "raw", "data.gz"))
+
+	token, err := db.CompareAndUpdatePassword(ctx, "raw", "1", []byte("rotated"))
+	require.NoError(err, "plain files should have concurrency tokens")
+	require.Equal("2", token)
+
+	counts, err := db.Count(ctx)
+	require.NoError(err)
+	require.Equal(store.Counts{Certificates: 1, Passwords: 1, Secrets: 1}, counts)
+
+	// Plain files are read by stores that write archives until they are written again
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+
+	password, err := db.GetPassword(ctx, "raw")
+	require.NoError(err, "should be able to read a plain password file")
+	require.Equal([]byte("rotated"), password)
+
+	require.NoError(db.UpdatePassword(ctx, "raw", []byte("archived")))
+	require.FileExists(filepath.Join(dir, "passwords", "raw", "data.gz"))
+	require.NoFileExists(filepath.Join(dir, "passwords", "raw", "data"), "the plain file should be replaced by the archive")
+
+	password, err = db.GetPassword(ctx, "raw")
+	require.NoError(err)
+	require.Equal([]byte("archived"), password)
+
+	// Deleting a resource removes it in either format
+	require.NoError(db.DeleteSecret(ctx, "raw"))
+	require.NoDirExists(filepath.Join(dir, "secrets", "raw"))
+	_, err = db.GetSecret(ctx, "raw")
+	require.ErrorIs(err, store.ErrNotFound)
+}
+
+func (s *localStoreTestSuite) TestWriteBatch() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// Write a password and certificate together
+	batch := (&store.Batch{}).UpdatePassword("batch", []byte("password")).UpdateCertificate("batch", []byte("cert"))
+	require.NoError(s.store.WriteBatch(ctx, batch), "should be able to write a batch")
+
+	password, err := s.store.GetPassword(ctx, "batch")
+	require.NoError(err, "should be able to get the batch password")
+	require.Equal([]byte("password"), password, "wrong password returned")
+
+	cert, err := s.store.GetCertificate(ctx, "batch")
+	require.NoError(err, "should be able to get the batch certificate")
+	require.Equal([]byte("cert"), cert, "wrong certificate returned")
+
+	// A failed batch should leave the store unmodified
+	batch = (&store.Batch{}).
+		UpdateCertificate("batch", []byte("new cert")).
+		UpdatePassword("batch", []byte("new password")).
+		UpdateCertificate("created", []byte("created")).
+		DeleteCertificate("does-not-exist")
+	err = s.store.WriteBatch(ctx, batch)
+	require.ErrorIs(err, store.ErrNotFound, "expected the batch to fail")
+	require.NotErrorIs(err, store.ErrRollbackFailed, "expected the batch to be rolled back")
+
+	password, err = s.store.GetPassword(ctx, "batch")
+	require.NoError(err, "should be able to get the batch password")
+	require.Equal([]byte("password"), password, "password should be restored")
+
+	cert, err = s.store.GetCertificate(ctx, "batch")
+	require.NoError(err, "should be able to get the batch certificate")
+	require.Equal([]byte("cert"), cert, "certificate should be restored")
+
+	versions, err := s.store.ListCertificateVersions(ctx, "batch")
+	require.NoError(err, "should be able to list certificate versions")
+	require.Len(versions, 1, "version created by the failed batch should be removed")
+
+	_, err = s.store.GetCertificate(ctx, "created")
+	require.ErrorIs(err, store.ErrNotFound, "certificate created by the failed batch should be removed")
+
+	// Clean up so that other tests can count the store
+	require.NoError(s.store.DeletePassword(ctx, "batch"))
+	require.NoError(s.store.DeleteCertificate(ctx, "batch"))
+}
+
+func (s *localStoreTestSuite) TestConcurrencyTokens() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// A non-empty token does not match a resource that does not exist
+	_, err := s.store.CompareAndUpdatePassword(ctx, "tokens", "1", []byte("first"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "should not update a missing password with a token")
+
+	// An empty token creates the resource
+	token, err := s.store.CompareAndUpdatePassword(ctx, "tokens", "", []byte("first"))
+	require.NoError(err, "should be able to create a password with an empty token")
+	require.Equal("1", token, "expected first generation")
+
+	_, err = s.store.CompareAndUpdatePassword(ctx, "tokens", "", []byte("again"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "empty token should not match an existing password")
+
+	// Unconditional writes also change the token
+	require.NoError(s.store.UpdatePassword(ctx, "tokens", []byte("second")))
+	password, token, err := s.store.GetPasswordWithToken(ctx, "tokens")
+	require.NoError(err, "should be able to get the password with its token")
+	require.Equal([]byte("second"), password, "wrong password returned")
+	require.Equal("2", token, "expected the token to change after an update")
+
+	_, err = s.store.CompareAndUpdatePassword(ctx, "tokens", "1", []byte("stale"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "should not update with a stale token")
+
+	token, err = s.store.CompareAndUpdatePassword(ctx, "tokens", token, []byte("third"))
+	require.NoError(err, "should update with the current token")
+	require.Equal("3", token, "expected the next generation")
+
+	// Certificates are compared the same way
+	token, err = s.store.CompareAndUpdateCertificate(ctx, "tokens", "", []byte("cert"))
+	require.NoError(err, "should be able to create a certificate with an empty token")
+
+	cert, current, err := s.store.GetCertificateWithToken(ctx, "tokens")
+	require.NoError(err, "should be able to get the certificate with its token")
+	require.Equal([]byte("cert"), cert, "wrong certificate returned")
+	require.Equal(token, current, "wrong token returned")
+
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "tokens", "", []byte("stale"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "should not update with a stale token")
+
+	// Metadata files are not counted as resources
+	counts, err := s.store.Count(ctx)
+	require.NoError(err, "should be able to count the store")
+	require.Equal(1, counts.Certificates, "metadata should not be counted")
+	require.Equal(1, counts.Passwords, "metadata should not be counted")
+
+	// Deleting resets the generation so the resource can be recreated
+	require.NoError(s.store.DeletePassword(ctx, "tokens"))
+	require.NoError(s.store.DeleteCertificate(ctx, "tokens"))
+	token, err = s.store.CompareAndUpdatePassword(ctx, "tokens", "", []byte("recreated"))
+	require.NoError(err, "should be able to recreate a deleted password")
+	require.Equal("1", token, "expected generation to be reset")
+	require.NoError(s.store.DeletePassword(ctx, "tokens"))
+}
+
+func (s *localStoreTestSuite) TestMetadata() {
+	require := s.Require()
+	ctx := context.Background()
+
+	dir := s.T().TempDir()
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+
+	_, err = db.Metadata(ctx, store.CertificatePrefix, "described")
+	require.ErrorIs(err, store.ErrNotFound, "should not describe a missing certificate")
+
+	_, err = db.Metadata(ctx, "unknown", "described")
+	require.ErrorIs(err, store.ErrUnknownResource)
+
+	// The metadata of a new resource describes its data
+	start := time.Now()
+	require.NoError(db.UpdateCertificate(ctx, "described", []byte("first version")))
+
+	meta, err := db.Metadata(ctx, store.CertificatePrefix, "described")
+	require.NoError(err, "should be able to get the metadata of a certificate")
+	require.False(meta.Created.Before(start.Truncate(time.Second)), "created should be set when the certificate is written")
+	require.Equal(meta.Created, meta.Updated)
+	require.Equal(store.Checksum([]byte("first version")), meta.Checksum)
+	require.Equal(int64(13), meta.Size)
+	require.False(meta.Encrypted, "store is not encrypted")
+
+	// Later writes keep the created timestamp
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(db.UpdateCertificate(ctx, "described", []byte("second version")))
+
+	updated, err := db.Metadata(ctx, store.CertificatePrefix, "described")
+	require.NoError(err, "should be able to get the metadata of a certificate")
+	require.Equal(meta.Created, updated.Created, "created should not change when the certificate is rewritten")
+	require.True(updated.Updated.After(meta.Updated), "updated should change when the certificate is rewritten")
+	require.Equal(store.Checksum([]byte("second version")), updated.Checksum)
+	require.Equal(int64(14), updated.Size)
+
+	_, token, err := db.GetCertificateWithToken(ctx, "described")
+	require.NoError(err)
+	require.Equal("2", token, "the generation should be kept in the metadata")
+
+	// Passwords and secrets are also described
+	require.NoError(db.UpdatePassword(ctx, "described", []byte("supersecretsquirrel")))
+	require.NoError(db.UpdateSecret(ctx, "described", []byte("secret")))
+	for _, prefix := range []string{store.PasswordPrefix, store.SecretPrefix} {
+		meta, err := db.Metadata(ctx, prefix, "described")
+		require.NoError(err, "should be able to get the metadata of a %s", prefix)
+		require.False(meta.Created.IsZero(), "created should be set for %s", prefix)
+	}
+
+	// Metadata files written by earlier versions only contain the generation
+	require.NoError(db.UpdatePassword(ctx, "legacy", []byte("legacy")))
+	require.NoError(os.WriteFile(filepath.Join(dir, "passwords", "legacy", "data.meta"), []byte("7"), 0600))
+
+	meta, err = db.Metadata(ctx, store.PasswordPrefix, "legacy")
+	require.NoError(err, "should be able to get the metadata of a legacy password")
+	require.True(meta.Created.IsZero(), "legacy resources have no created timestamp")
+	require.False(meta.Updated.IsZero(), "updated should be the modification time of the file")
+
+	token, err = db.CompareAndUpdatePassword(ctx, "legacy", "7", []byte("rewritten"))
+	require.NoError(err, "the legacy generation should be the concurrency token")
+	require.Equal("8", token)
+
+	meta, err = db.Metadata(ctx, store.PasswordPrefix, "legacy")
+	require.NoError(err)
+	require.False(meta.Created.IsZero(), "created should be set when a legacy resource is written")
+
+	// Deleting a resource removes its metadata
+	require.NoError(db.DeleteSecret(ctx, "described"))
+	_, err = os.Stat(filepath.Join(dir, "secrets", "described", "data.meta"))
+	require.ErrorIs(err, os.ErrNotExist, "metadata should be removed with the secret")
+
+	// Rekeying records that the resources are encrypted
+	key := filepath.Join(s.T().TempDir(), "metadata.key")
+	require.NoError(os.WriteFile(key, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32))), 0600))
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, KeyFile: key})
+	require.NoError(err, "could not open encrypted local storage backend")
+
+	_, err = db.Rekey(ctx)
+	require.NoError(err, "could not rekey the store")
+
+	meta, err = db.Metadata(ctx, store.CertificatePrefix, "described")
+	require.NoError(err)
+	require.True(meta.Encrypted, "certificate should be encrypted after rekeying")
+	require.Equal(updated.Updated, meta.Updated, "rekeying should not change when the certificate was written")
+}
+
+func (s *localStoreTestSuite) TestEncryption() {
+	require := s.Require()
+	ctx := context.Background()
+
+	dir := s.T().TempDir()
+	writeKey := func(name string, key byte) string {
+		path := filepath.Join(s.T().TempDir(), name)
+		require.NoError(os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{key}, 32))), 0600))
+		return path
+	}
+	first, second := writeKey("first.key", 1), writeKey("second.key", 2)
+
+	// Files written before encryption was enabled cannot be read until they are rekeyed
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+	require.NoError(db.UpdateSecret(ctx, "legacy", []byte("plaintext secret")))
+
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, KeyFile: first})
+	require.NoError(err, "could not open encrypted local storage backend")
+
+	require.NoError(db.UpdateCertificate(ctx, "encrypted", []byte("private key material")))
+	require.NoError(db.UpdatePassword(ctx, "encrypted", []byte("supersecretsquirrel")))
+
+	_, err = db.GetSecret(ctx, "legacy")
+	require.ErrorIs(err, local.ErrUnencrypted, "should not read files written before encryption was enabled")
+
+	// Stored files do not contain the plaintext or its checksum
+	for _, name := range []string{"certs/encrypted/data", "certs/encrypted/data@1", "passwords/encrypted/data.gz"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(err)
+		require.NotContains(string(data), "private key material", "%s should be encrypted", name)
+		require.NoFileExists(filepath.Join(dir, name+".sha256"), "encrypted files should not have checksums")
+	}
+
+	cert, err := db.GetCertificate(ctx, "encrypted")
+	require.NoError(err, "should be able to decrypt the certificate")
+	require.Equal([]byte("private key material"), cert)
+
+	// Encrypted files cannot be read without the key
+	plain, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+	_, err = plain.GetCertificate(ctx, "encrypted")
+	require.ErrorIs(err, local.ErrMissingKey)
+
+	// Rotate the key, keeping the first key to read files written before the rotation
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, KeyFile: second, OldKeyFiles: []string{first}})
+	require.NoError(err, "could not open encrypted local storage backend")
+
+	password, err := db.GetPassword(ctx, "encrypted")
+	require.NoError(err, "should be able to read files encrypted with the previous key")
+	require.Equal([]byte("supersecretsquirrel"), password)
+
+	rekeyed, err := db.Rekey(ctx)
+	require.NoError(err, "could not rekey local storage")
+	require.Equal(4, rekeyed, "expected every resource file to be rewritten")
+
+	rekeyed, err = db.Rekey(ctx)
+	require.NoError(err, "could not rekey local storage")
+	require.Zero(rekeyed, "expected rekeying to be idempotent")
+
+	// After rekeying the previous key is no longer required
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, KeyFile: second})
+	require.NoError(err, "could not open encrypted local storage backend")
+
+	for _, version := range []string{"1", store.LatestVersion} {
+		cert, err = db.GetCertificateVersion(ctx, "encrypted", version)
+		require.NoError(err, "should be able to read rekeyed certificate version %s", version)
+		require.Equal([]byte("private key material"), cert)
+	}
+
+	secret, err := db.GetSecret(ctx, "legacy")
+	require.NoError(err, "should be able to read the rekeyed legacy secret")
+	require.Equal([]byte("plaintext secret"), secret)
+
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, KeyFile: first})
+	require.NoError(err, "could not open encrypted local storage backend")
+	_, err = db.GetPassword(ctx, "encrypted")
+	require.ErrorIs(err, local.ErrUnknownKey, "rotated files should not be readable with the old key")
+
+	// Modified ciphertext fails authentication
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, KeyFile: second})
+	require.NoError(err, "could not open encrypted local storage backend")
+
+	path := filepath.Join(dir, "certs", "encrypted", "data")
+	data, err := os.ReadFile(path)
+	require.NoError(err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(os.WriteFile(path, data, 0600))
+
+	_, err = db.GetCertificate(ctx, "encrypted")
+	require.ErrorIs(err, store.ErrCorrupted, "expected modified ciphertext to be corrupted")
+
+	// Ciphertext copied from another resource fails authentication
+	require.NoError(db.UpdateCertificate(ctx, "victim", []byte("victim key material")))
+	require.NoError(db.UpdateCertificate(ctx, "attacker", []byte("attacker key material")))
+	data, err = os.ReadFile(filepath.Join(dir, "certs", "attacker", "data"))
+	require.NoError(err)
+	require.NoError(os.WriteFile(filepath.Join(dir, "certs", "victim", "data"), data, 0600))
+
+	_, err = db.GetCertificate(ctx, "victim")
+	require.ErrorIs(err, store.ErrCorrupted, "expected ciphertext of another resource to be corrupted")
+
+	// Unencrypted files placed in the storage directory are not read
+	require.NoError(os.WriteFile(filepath.Join(dir, "certs", "victim", "data"), []byte("planted key material"), 0600))
+	_, err = db.GetCertificate(ctx, "victim")
+	require.ErrorIs(err, local.ErrUnencrypted, "expected unencrypted files to be rejected")
+}
+
+func (s *localStoreTestSuite) TestPassphrase() {
+	require := s.Require()
+	ctx := context.Background()
+
+	dir := s.T().TempDir()
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, Passphrase: "correct horse battery staple"})
+	require.NoError(err, "could not open encrypted local storage backend")
+	require.NoError(db.UpdatePassword(ctx, "passphrase", []byte("supersecretsquirrel")))
+
+	// The same passphrase derives the same key from the stored salt
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, Passphrase: "correct horse battery staple"})
+	require.NoError(err, "could not open encrypted local storage backend")
+	password, err := db.GetPassword(ctx, "passphrase")
+	require.NoError(err, "should be able to decrypt with the same passphrase")
+	require.Equal([]byte("supersecretsquirrel"), password)
+
+	counts, err := db.Count(ctx)
+	require.NoError(err, "could not count resources")
+	require.Equal(store.Counts{Passwords: 1}, counts, "the salt should not be counted as a resource")
+
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, Passphrase: "wrong"})
+	require.NoError(err, "could not open encrypted local storage backend")
+	_, err = db.GetPassword(ctx, "passphrase")
+	require.ErrorIs(err, local.ErrUnknownKey, "a different passphrase should not decrypt the files")
+
+	// Changing the passphrase keeps the previous passphrase until the store is rekeyed
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, Passphrase: "new", OldPassphrase: "correct horse battery staple"})
+	require.NoError(err, "could not open encrypted local storage backend")
+	rekeyed, err := db.Rekey(ctx)
+	require.NoError(err, "could not rekey local storage")
+	require.Equal(1, rekeyed)
+
+	// Rekeying requires encryption
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+	_, err = db.Rekey(ctx)
+	require.ErrorIs(err, local.ErrNotEncrypted)
+}
+
+func (s *localStoreTestSuite) TestInterruptedWrites() {
+	require := s.Require()
+	ctx := context.Background()
+
+	dir := s.T().TempDir()
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+
+	require.NoError(db.UpdateCertificate(ctx, "intact", []byte("certificate")))
+	require.NoError(db.UpdatePassword(ctx, "intact", []byte("password")))
+	require.NoError(db.UpdateCertificate(ctx, "truncated", []byte("certificate")))
+	require.NoError(db.UpdatePassword(ctx, "truncated", []byte("password")))
+
+	// Completed writes do not leave temporary files behind
+	temps, err := filepath.Glob(filepath.Join(dir, "*", "*", ".tmp-*"))
+	require.NoError(err)
+	require.Empty(temps, "expected temporary files to be renamed")
+
+	// A crash before the temporary file is renamed leaves the previous contents in place
+	require.NoError(os.WriteFile(filepath.Join(dir, "certs", "intact", ".tmp-data-1234"), []byte("cert"), 0600))
+	require.NoError(os.WriteFile(filepath.Join(dir, ".tmp-certificate-intact-1234"), []byte("cert"), 0600))
+	cert, err := db.GetCertificate(ctx, "intact")
+	require.NoError(err, "interrupted write should not affect the stored certificate")
+	require.Equal([]byte("certificate"), cert)
+
+	// Simulate files that were truncated by a crash before writes were atomic
+	for _, name := range []string{"certs/truncated/data", "passwords/truncated/data.gz"} {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		require.NoError(err)
+		require.NoError(os.WriteFile(path, data[:len(data)/2], 0600))
+	}
+
+	// Reopening the store quarantines the partial files
+	db, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+
+	quarantined, err := filepath.Glob(filepath.Join(dir, ".quarantine", "*", "*", "*"))
+	require.NoError(err)
+	require.Len(quarantined, 5, "expected the partial files, their checksums, and the temporary file to be quarantined")
+
+	legacy, err := filepath.Glob(filepath.Join(dir, ".quarantine", ".tmp-certificate-intact-1234.*"))
+	require.NoError(err)
+	require.Len(legacy, 1, "expected the temporary file of an earlier version to be quarantined")
+
+	temps, err = filepath.Glob(filepath.Join(dir, "*", "*", ".tmp-*"))
+	require.NoError(err)
+	require.Empty(temps, "expected temporary files to be quarantined")
+
+	// Intact resources are not affected
+	cert, err = db.GetCertificate(ctx, "intact")
+	require.NoError(err, "should be able to read intact certificate")
+	require.Equal([]byte("certificate"), cert)
+
+	password, err := db.GetPassword(ctx, "intact")
+	require.NoError(err, "should be able to read intact password")
+	require.Equal([]byte("password"), password)
+
+	// The truncated resources are missing and can be delivered again
+	_, err = db.GetCertificate(ctx, "truncated")
+	require.ErrorIs(err, store.ErrNotFound, "truncated certificate should be quarantined")
+	_, err = db.GetPassword(ctx, "truncated")
+	require.ErrorIs(err, store.ErrNotFound, "truncated password should be quarantined")
+
+	require.NoError(db.UpdateCertificate(ctx, "truncated", []byte("redelivered")))
+	require.NoError(db.UpdatePassword(ctx, "truncated", []byte("redelivered")))
+	password, err = db.GetPassword(ctx, "truncated")
+	require.NoError(err, "should be able to read redelivered password")
+	require.Equal([]byte("redelivered"), password)
+
+	counts, err := db.Count(ctx)
+	require.NoError(err, "could not count resources")
+	require.Equal(store.Counts{Certificates: 2, Passwords: 2}, counts, "quarantined files should not be counted")
+}
+
+func (s *localStoreTestSuite) TestLocking() {
+	if runtime.GOOS == "windows" {
+		s.T().Skip("file locks are not supported on windows")
+	}
+
+	require := s.Require()
+	ctx := context.Background()
+
+	// Two stores opened on the same path stand in for two courier processes since the
+	// lock is held by the open lock file.
+	dir := s.T().TempDir()
+	first, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+	defer first.Close()
+
+	second, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir})
+	require.NoError(err, "could not open local storage backend")
+	defer second.Close()
+
+	require.NoError(first.UpdatePassword(ctx, "locked", []byte("first")))
+
+	// Readers in both stores share the lock
+	first.RLock()
+	password, err := second.GetPassword(ctx, "locked")
+	require.NoError(err, "readers in both stores should share the lock")
+	require.Equal([]byte("first"), password)
+
+	// A write by one store waits for the reader in the other store
+	written := make(chan error, 1)
+	go func() { written <- second.UpdatePassword(ctx, "locked", []byte("second")) }()
+
+	select {
+	case err := <-written:
+		require.Fail("write should wait for the reader in the other store", "%v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.RUnlock()
+	select {
+	case err := <-written:
+		require.NoError(err, "write should complete once the reader is done")
+	case <-time.After(time.Second):
+		require.Fail("write did not complete after the reader released the lock")
+	}
+
+	password, err = first.GetPassword(ctx, "locked")
+	require.NoError(err)
+	require.Equal([]byte("second"), password)
+}
+
+func (s *localStoreTestSuite) TestWatch() {
+	require := s.Require()
+	ctx := context.Background()
+
+	dir := s.T().TempDir()
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: dir, WatchInterval: 10 * time.Millisecond})
+	require.NoError(err, "could not open local storage backend")
+	defer db.Close()
+
+	changes := make(chan store.Change, 16)
+	db.OnChange(func(change store.Change) { changes <- change })
+
+	// Changes made by the store are not reported
+	require.NoError(db.UpdateCertificate(ctx, "watched", []byte("certificate")))
+	require.NoError(db.UpdatePassword(ctx, "watched", []byte("password")))
+	require.NoError(db.DeletePassword(ctx, "watched"))
+	select {
+	case change := <-changes:
+		require.Fail("unexpected change reported", "%+v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Files copied into the directory are reported as created
+	require.NoError(os.MkdirAll(filepath.Join(dir, "passwords", "copied"), 0700))
+	require.NoError(os.WriteFile(filepath.Join(dir, "passwords", "copied", "data.gz"), []byte("password"), 0600))
+	select {
+	case change := <-changes:
+		require.Equal(store.Change{Prefix: store.PasswordPrefix, Name: "copied", File: filepath.Join("passwords", "copied", "data.gz"), Kind: store.ChangeCreated}, change)
+	case <-time.After(time.Second):
+		require.Fail("expected the created file to be reported")
+	}
+
+	// Manual edits are reported as modified
+	require.NoError(os.WriteFile(filepath.Join(dir, "certs", "watched", "data"), []byte("edited certificate"), 0600))
+	select {
+	case change := <-changes:
+		require.Equal(store.Change{Prefix: store.CertificatePrefix, Name: "watched", File: filepath.Join("certs", "watched", "data"), Kind: store.ChangeModified}, change)
+	case <-time.After(time.Second):
+		require.Fail("expected the modified file to be reported")
+	}
+
+	// Files that are not stored resources are ignored and removed files are reported
+	require.NoError(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0600))
+	require.NoError(os.Remove(filepath.Join(dir, "certs", "watched", "data.meta")))
+	select {
+	case change := <-changes:
+		require.Equal(store.Change{Prefix: store.CertificatePrefix, Name: "watched", File: filepath.Join("certs", "watched", "data.meta"), Kind: store.ChangeRemoved}, change)
+	case <-time.After(time.Second):
+		require.Fail("expected the removed file to be reported")
+	}
+
+	select {
+	case change := <-changes:
+		require.Fail("unexpected change reported", "%+v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (s *localStoreTestSuite) TestPermissions() {
+	require := s.Require()
+	ctx := context.Background()
+
+	// The directory is created with the directory mode regardless of the umask
+	path := filepath.Join(s.T().TempDir(), "storage")
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: path, DirMode: 0750, FileMode: 0640})
+	require.NoError(err, "could not open local storage backend")
+	defer db.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(err)
+	require.Equal(os.FileMode(0750), info.Mode().Perm())
+
+	require.NoError(db.UpdateCertificate(ctx, "alice", []byte("certificate")))
+	for _, dir := range []string{"certs", filepath.Join("certs", "alice")} {
+		info, err := os.Stat(filepath.Join(path, dir))
+		require.NoError(err)
+		require.Equal(os.FileMode(0750), info.Mode().Perm(), "wrong permissions for %s", dir)
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "certs", "alice", "*"))
+	require.NoError(err)
+	require.NotEmpty(files)
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		require.NoError(err)
+		require.Equal(os.FileMode(0640), info.Mode().Perm(), "wrong permissions for %s", filepath.Base(file))
+	}
+
+	// Files are only readable by courier by default
+	require.NoError(s.store.UpdatePassword(ctx, "permissions", []byte("password")))
+	info, err = os.Stat(filepath.Join(s.conf.Path, "passwords", "permissions", "data.gz"))
+	require.NoError(err)
+	require.Equal(os.FileMode(0600), info.Mode().Perm())
+}
+
+func (s *localStoreTestSuite) TestOwner() {
+	if os.Geteuid() != 0 {
+		s.T().Skip("the owner is only checked when running as root")
+	}
+
+	require := s.Require()
+	path := s.T().TempDir()
+
+	db, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: path, Owner: "root"})
+	require.NoError(err, "expected the directory to be owned by root")
+	db.Close()
+
+	_, err = local.Open(config.LocalStorageConfig{Enabled: true, Path: path, Owner: "4242"})
+	require.ErrorIs(err, local.ErrWrongOwner)
+}
+
+func (s *localStoreTestSuite) TestExportImport() {
+	require := s.Require()
+	ctx := context.Background()
+
+	keyFile := filepath.Join(s.T().TempDir(), "courier.key")
+	require.NoError(os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))), 0600))
+
+	src, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: s.T().TempDir(), KeyFile: keyFile})
+	require.NoError(err, "could not open encrypted local storage backend")
+	defer src.Close()
+
+	require.NoError(src.UpdateCertificate(ctx, "alice", []byte("first certificate")))
+	require.NoError(src.UpdateCertificate(ctx, "alice", []byte("second certificate")))
+	require.NoError(src.UpdatePassword(ctx, "alice", []byte("supersecretsquirrel")))
+	require.NoError(src.UpdateSecret(ctx, "bob", []byte("secret")))
+
+	var archive bytes.Buffer
+	exported, err := src.Export(&archive)
+	require.NoError(err, "could not export local storage")
+	require.Equal(5, exported, "expected the latest and prior versions of every resource to be exported")
+	require.NotContains(archive.String(), "supersecretsquirrel", "expected the archive to be encrypted")
+
+	// The archive is imported into a store on another path with the same key
+	dst, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: s.T().TempDir(), KeyFile: keyFile})
+	require.NoError(err, "could not open encrypted local storage backend")
+	defer dst.Close()
+
+	imported, err := dst.Import(bytes.NewReader(archive.Bytes()))
+	require.NoError(err, "could not import local storage archive")
+	require.Equal(exported, imported)
+
+	cert, err := dst.GetCertificateVersion(ctx, "alice", "1")
+	require.NoError(err, "expected prior versions to be imported")
+	require.Equal([]byte("first certificate"), cert)
+
+	cert, err = dst.GetCertificate(ctx, "alice")
+	require.NoError(err)
+	require.Equal([]byte("second certificate"), cert)
+
+	password, err := dst.GetPassword(ctx, "alice")
+	require.NoError(err)
+	require.Equal([]byte("supersecretsquirrel"), password)
+
+	srcMeta, err := src.Metadata(ctx, store.CertificatePrefix, "alice")
+	require.NoError(err)
+	dstMeta, err := dst.Metadata(ctx, store.CertificatePrefix, "alice")
+	require.NoError(err)
+	require.Equal(srcMeta, dstMeta, "expected the metadata to be imported")
+
+	// Archives are not imported into a store with resources
+	_, err = dst.Import(bytes.NewReader(archive.Bytes()))
+	require.ErrorIs(err, local.ErrNotEmpty)
+
+	// Archives cannot be imported without the key that encrypted them
+	otherKey := filepath.Join(s.T().TempDir(), "other.key")
+	require.NoError(os.WriteFile(otherKey, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32))), 0600))
+
+	other, err := local.Open(config.LocalStorageConfig{Enabled: true, Path: s.T().TempDir(), KeyFile: otherKey})
+	require.NoError(err, "could not open encrypted local storage backend")
+	defer other.Close()
+
+	_, err = other.Import(bytes.NewReader(archive.Bytes()))
+	require.ErrorIs(err, local.ErrUnknownKey)
+
+	_, err = other.Import(bytes.NewReader([]byte("not an archive")))
+	require.ErrorIs(err, local.ErrNotArchive)
+
+	// Modified archives fail authentication
+	data := bytes.Clone(archive.Bytes())
+	data[len(data)-1] ^= 0xff
+	_, err = dst.Import(bytes.NewReader(data))
+	require.ErrorIs(err, store.ErrCorrupted)
+
+	// Exporting and importing requires encryption
+	_, err = s.store.Export(io.Discard)
+	require.ErrorIs(err, local.ErrNotEncrypted)
 }
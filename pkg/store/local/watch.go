@@ -0,0 +1,186 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/o11y"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// watcher polls the storage directory for changes to the files of stored resources
+// that were not made by the store, e.g. manual edits or other tools, so that they can
+// be logged and caches of the stored resources can be refreshed. Writes made by the
+// store are recorded as they are made so that only out-of-band changes are reported;
+// an external change to a file that the store also wrote within the same interval is
+// not reported.
+type watcher struct {
+	interval time.Duration
+	files    map[string]fileState
+	touched  map[string]struct{}
+	handlers []func(store.Change)
+	stop     chan struct{}
+	done     chan struct{}
+	closing  sync.Once
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// watch starts polling the storage directory at the interval.
+func (s *Store) watch(interval time.Duration) (err error) {
+	w := &watcher{
+		interval: interval,
+		touched:  make(map[string]struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if w.files, err = s.scan(); err != nil {
+		return err
+	}
+
+	s.watcher = w
+	go s.poll()
+	return nil
+}
+
+// OnChange registers a function that is called with each change to the files of a
+// stored resource that was made outside of courier. Changes are only detected if the
+// watch interval is configured.
+func (s *Store) OnChange(fn func(store.Change)) {
+	s.Lock()
+	defer s.Unlock()
+	if s.watcher != nil {
+		s.watcher.handlers = append(s.watcher.handlers, fn)
+	}
+}
+
+func (s *Store) poll() {
+	defer close(s.watcher.done)
+	ticker := time.NewTicker(s.watcher.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.watcher.stop:
+			return
+		case <-ticker.C:
+		}
+
+		changes, handlers, err := s.changes()
+		if err != nil {
+			log.Warn().Err(err).Str("path", s.path).Msg("could not scan local storage directory for changes")
+			continue
+		}
+
+		for _, change := range changes {
+			log.Warn().
+				Str("path", s.path).
+				Str("file", change.File).
+				Str("resource", change.Prefix).
+				Str("id", change.Name).
+				Str("change", change.Kind).
+				Msg("local storage file was changed outside of courier")
+			o11y.StoreExternalChanges.WithLabelValues(change.Prefix, change.Kind).Inc()
+
+			for _, handler := range handlers {
+				handler(change)
+			}
+		}
+	}
+}
+
+// changes compares the storage directory with the previous scan and returns the
+// changes that were not made by the store along with the registered handlers.
+func (s *Store) changes() (changes []store.Change, handlers []func(store.Change), err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var current map[string]fileState
+	if current, err = s.scan(); err != nil {
+		return nil, nil, err
+	}
+
+	w := s.watcher
+	for name, state := range current {
+		prev, ok := w.files[name]
+		switch {
+		case !ok:
+			changes = appendChange(changes, w, name, store.ChangeCreated)
+		case prev != state:
+			changes = appendChange(changes, w, name, store.ChangeModified)
+		}
+	}
+
+	for name := range w.files {
+		if _, ok := current[name]; !ok {
+			changes = appendChange(changes, w, name, store.ChangeRemoved)
+		}
+	}
+
+	w.files = current
+	w.touched = make(map[string]struct{})
+	return changes, w.handlers, nil
+}
+
+func appendChange(changes []store.Change, w *watcher, file, kind string) []store.Change {
+	if _, ok := w.touched[file]; ok {
+		return changes
+	}
+
+	prefix, name := parsePath(file)
+	return append(changes, store.Change{Prefix: prefix, Name: name, File: file, Kind: kind})
+}
+
+// scan returns the size and modification time of every file of a stored resource by
+// its path relative to the storage directory.
+func (s *Store) scan() (files map[string]fileState, err error) {
+	files = make(map[string]fileState)
+	err = s.walk(func(_, _, path string) error {
+		if strings.HasPrefix(filepath.Base(path), tempPrefix) {
+			return nil
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			// The file was removed since the directory was read
+			return nil
+		}
+		files[s.relPath(path)] = fileState{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return files, err
+}
+
+// touch records that the store wrote or removed the file at path so that the change is
+// not reported by the watcher. The caller must hold the write lock.
+func (s *Store) touch(path string) {
+	if s.watcher != nil {
+		s.watcher.touched[s.relPath(path)] = struct{}{}
+	}
+}
+
+// relPath returns the path of a file relative to the storage directory.
+func (s *Store) relPath(path string) string {
+	if rel, err := filepath.Rel(s.path, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// stopWatching stops polling the storage directory and waits for the poll to return.
+func (s *Store) stopWatching() {
+	if s.watcher != nil {
+		s.watcher.closing.Do(func() {
+			close(s.watcher.stop)
+			<-s.watcher.done
+		})
+	}
+}
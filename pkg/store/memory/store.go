@@ -0,0 +1,452 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// Open the in-memory storage backend. Resources are only held by the process so they
+// are lost when the server stops, which makes the backend suitable for demos, CI, and
+// ephemeral test environments but not for production.
+func Open(conf config.MemoryStorageConfig) (*Store, error) {
+	return &Store{
+		maxVersions: conf.MaxVersions,
+		resources:   make(map[string]*resource),
+	}, nil
+}
+
+// Store implements the store.Store interface in memory. Every write of a password or
+// certificate is kept as a new numbered version, and the latest version number is
+// used as the concurrency token. Data is copied when it is written and read so that
+// callers cannot modify the stored resources.
+type Store struct {
+	sync.RWMutex
+	maxVersions int
+	resources   map[string]*resource
+}
+
+// resource holds the versions of a stored resource, oldest first.
+type resource struct {
+	versions []version
+}
+
+type version struct {
+	number  int
+	data    []byte
+	created time.Time
+}
+
+var _ store.Store = &Store{}
+
+// Close the in-memory storage backend, which discards every stored resource.
+func (s *Store) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.resources = make(map[string]*resource)
+	return nil
+}
+
+// Count the certificates, passwords, and secrets in the in-memory storage backend,
+// excluding prior versions.
+func (s *Store) Count(ctx context.Context) (counts store.Counts, err error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for key := range s.resources {
+		switch prefix, _, _ := strings.Cut(key, "-"); prefix {
+		case store.CertificatePrefix:
+			counts.Certificates++
+		case store.PasswordPrefix:
+			counts.Passwords++
+		case store.SecretPrefix:
+			counts.Secrets++
+		}
+	}
+	return counts, nil
+}
+
+// List the ids of the resources of the type in the in-memory storage backend.
+func (s *Store) List(ctx context.Context, prefix string) (ids []string, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	for k := range s.resources {
+		if id, ok := strings.CutPrefix(k, prefix+"-"); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Exists checks if the resource is in the in-memory storage backend.
+func (s *Store) Exists(ctx context.Context, prefix, name string) (_ bool, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return false, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.resources[key(prefix, name)]
+	return ok, nil
+}
+
+// Modified returns when the latest version of the resource was written.
+func (s *Store) Modified(ctx context.Context, prefix, name string) (_ time.Time, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return time.Time{}, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	res, ok := s.resources[key(prefix, name)]
+	if !ok || len(res.versions) == 0 {
+		return time.Time{}, notFound(prefix, name)
+	}
+	return res.versions[len(res.versions)-1].created, nil
+}
+
+// Delete the resource like the delete method of its type.
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return store.DeleteResource(ctx, s, prefix, name)
+}
+
+// WriteBatch applies the writes in the batch while holding the store lock so that
+// readers never observe a partially applied batch. If any write fails every resource
+// written by the batch is restored, including its version history.
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var undo store.Undo
+	for _, op := range batch.Ops() {
+		undo.Push(s.snapshot(op.Prefix, op.Name))
+		if err = s.apply(op); err != nil {
+			return undo.Rollback(err)
+		}
+	}
+	return nil
+}
+
+//===========================================================================
+// Password Methods
+//===========================================================================
+
+// GetPassword retrieves the latest version of a password by id.
+func (s *Store) GetPassword(ctx context.Context, id string) (password []byte, err error) {
+	password, _, err = s.GetPasswordWithToken(ctx, id)
+	return password, err
+}
+
+// GetPasswordVersion retrieves a specific version of a password by id.
+func (s *Store) GetPasswordVersion(ctx context.Context, id, version string) (password []byte, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.getVersion(store.PasswordPrefix, id, version)
+}
+
+// GetPasswordWithToken retrieves a password by id along with its version number,
+// which is used as the concurrency token.
+func (s *Store) GetPasswordWithToken(ctx context.Context, id string) (password []byte, token string, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.get(store.PasswordPrefix, id)
+}
+
+// UpdatePassword stores the password as a new version of the password with the id.
+func (s *Store) UpdatePassword(ctx context.Context, id string, password []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	s.put(store.PasswordPrefix, id, password)
+	return nil
+}
+
+// CompareAndUpdatePassword updates a password by id if its latest version matches the
+// token and returns the new version.
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, id, token string, password []byte) (_ string, err error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.compareAndPut(store.PasswordPrefix, id, token, password)
+}
+
+// DeletePassword removes a password and all of its versions by id.
+func (s *Store) DeletePassword(ctx context.Context, id string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.delete(store.PasswordPrefix, id)
+}
+
+// PrunePasswordVersions removes all but the keep most recent versions of a password.
+func (s *Store) PrunePasswordVersions(ctx context.Context, id string, keep int) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.prune(store.PasswordPrefix, id, keep)
+}
+
+//===========================================================================
+// Certificate Methods
+//===========================================================================
+
+// GetCertificate retrieves the latest version of certificate data by id.
+func (s *Store) GetCertificate(ctx context.Context, name string) (cert []byte, err error) {
+	cert, _, err = s.GetCertificateWithToken(ctx, name)
+	return cert, err
+}
+
+// GetCertificateVersion retrieves a specific version of certificate data by id.
+func (s *Store) GetCertificateVersion(ctx context.Context, name, version string) (cert []byte, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.getVersion(store.CertificatePrefix, name, version)
+}
+
+// GetCertificateWithToken retrieves certificate data by id along with its version
+// number, which is used as the concurrency token.
+func (s *Store) GetCertificateWithToken(ctx context.Context, name string) (cert []byte, token string, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.get(store.CertificatePrefix, name)
+}
+
+// ListCertificateVersions returns the versions of the certificate data by id that are
+// kept by the in-memory storage backend, newest first.
+func (s *Store) ListCertificateVersions(ctx context.Context, name string) (versions []store.Version, err error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	res, ok := s.resources[key(store.CertificatePrefix, name)]
+	if !ok {
+		return nil, notFound(store.CertificatePrefix, name)
+	}
+
+	versions = make([]store.Version, 0, len(res.versions))
+	for i := len(res.versions) - 1; i >= 0; i-- {
+		versions = append(versions, store.Version{
+			Version: strconv.Itoa(res.versions[i].number),
+			Created: res.versions[i].created,
+			State:   "enabled",
+		})
+	}
+	return versions, nil
+}
+
+// UpdateCertificate stores the certificate data as a new version of the certificate
+// with the id.
+func (s *Store) UpdateCertificate(ctx context.Context, name string, cert []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	s.put(store.CertificatePrefix, name, cert)
+	return nil
+}
+
+// CompareAndUpdateCertificate updates certificate data by id if its latest version
+// matches the token and returns the new version.
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, name, token string, cert []byte) (_ string, err error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.compareAndPut(store.CertificatePrefix, name, token, cert)
+}
+
+// DeleteCertificate removes certificate data and all of its versions by id.
+func (s *Store) DeleteCertificate(ctx context.Context, name string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.delete(store.CertificatePrefix, name)
+}
+
+// PruneCertificateVersions removes all but the keep most recent versions of the
+// certificate data.
+func (s *Store) PruneCertificateVersions(ctx context.Context, name string, keep int) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.prune(store.CertificatePrefix, name, keep)
+}
+
+//===========================================================================
+// Secret Methods
+//===========================================================================
+
+// GetSecret retrieves a secret by name.
+func (s *Store) GetSecret(ctx context.Context, name string) (secret []byte, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	secret, _, err = s.get(store.SecretPrefix, name)
+	return secret, err
+}
+
+// UpdateSecret creates or overwrites a secret by name. Only the latest version of a
+// secret is kept.
+func (s *Store) UpdateSecret(ctx context.Context, name string, secret []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	s.put(store.SecretPrefix, name, secret)
+	return nil
+}
+
+// DeleteSecret removes a secret by name.
+func (s *Store) DeleteSecret(ctx context.Context, name string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.delete(store.SecretPrefix, name)
+}
+
+//===========================================================================
+// Helper methods (the caller must hold the lock)
+//===========================================================================
+
+// apply a single batch write to the in-memory storage backend.
+func (s *Store) apply(op store.Op) error {
+	switch op.Prefix {
+	case store.PasswordPrefix, store.CertificatePrefix, store.SecretPrefix:
+		if op.Delete {
+			return s.delete(op.Prefix, op.Name)
+		}
+		s.put(op.Prefix, op.Name, op.Data)
+		return nil
+	default:
+		return fmt.Errorf("unknown resource type %q in batch", op.Prefix)
+	}
+}
+
+// get returns a copy of the latest version of the resource and its version number.
+func (s *Store) get(prefix, name string) (_ []byte, token string, err error) {
+	res, ok := s.resources[key(prefix, name)]
+	if !ok {
+		return nil, "", notFound(prefix, name)
+	}
+
+	latest := res.versions[len(res.versions)-1]
+	return clone(latest.data), strconv.Itoa(latest.number), nil
+}
+
+// getVersion returns a copy of the numbered version of the resource.
+func (s *Store) getVersion(prefix, name, number string) (_ []byte, err error) {
+	if number == store.LatestVersion {
+		var data []byte
+		data, _, err = s.get(prefix, name)
+		return data, err
+	}
+
+	res, ok := s.resources[key(prefix, name)]
+	if !ok {
+		return nil, notFound(prefix, name)
+	}
+
+	for _, v := range res.versions {
+		if strconv.Itoa(v.number) == number {
+			return clone(v.data), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: version %s of %s", store.ErrNotFound, number, key(prefix, name))
+}
+
+// put stores a copy of the data as the next version of the resource and prunes the
+// versions that exceed the configured history. Secrets are not versioned.
+func (s *Store) put(prefix, name string, data []byte) int {
+	res, ok := s.resources[key(prefix, name)]
+	if !ok {
+		res = &resource{}
+		s.resources[key(prefix, name)] = res
+	}
+
+	next := 1
+	if n := len(res.versions); n > 0 {
+		next = res.versions[n-1].number + 1
+	}
+	res.versions = append(res.versions, version{number: next, data: clone(data), created: time.Now()})
+
+	switch {
+	case prefix == store.SecretPrefix:
+		res.versions = res.versions[len(res.versions)-1:]
+	case s.maxVersions > 0 && len(res.versions) > s.maxVersions:
+		res.versions = res.versions[len(res.versions)-s.maxVersions:]
+	}
+	return next
+}
+
+// compareAndPut stores the data if the latest version of the resource matches the
+// token. An empty token only matches a resource that does not exist.
+func (s *Store) compareAndPut(prefix, name, token string, data []byte) (_ string, err error) {
+	var current string
+	if _, current, err = s.get(prefix, name); err != nil && token != "" {
+		return "", store.ErrVersionMismatch
+	}
+
+	if current != token {
+		return "", store.ErrVersionMismatch
+	}
+	return strconv.Itoa(s.put(prefix, name, data)), nil
+}
+
+// delete removes the resource and all of its versions.
+func (s *Store) delete(prefix, name string) error {
+	if _, ok := s.resources[key(prefix, name)]; !ok {
+		return notFound(prefix, name)
+	}
+	delete(s.resources, key(prefix, name))
+	return nil
+}
+
+// prune removes all but the keep most recent versions of the resource.
+func (s *Store) prune(prefix, name string, keep int) error {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+
+	res, ok := s.resources[key(prefix, name)]
+	if !ok {
+		return notFound(prefix, name)
+	}
+
+	if len(res.versions) > keep {
+		res.versions = res.versions[len(res.versions)-keep:]
+	}
+	return nil
+}
+
+// snapshot returns a function that restores the resource to its current state,
+// removing it if it does not currently exist.
+func (s *Store) snapshot(prefix, name string) func() error {
+	k := key(prefix, name)
+	res, ok := s.resources[k]
+	if !ok {
+		return func() error {
+			delete(s.resources, k)
+			return nil
+		}
+	}
+
+	prev := &resource{versions: append([]version(nil), res.versions...)}
+	return func() error {
+		s.resources[k] = prev
+		return nil
+	}
+}
+
+func key(prefix, name string) string {
+	return prefix + "-" + name
+}
+
+func notFound(prefix, name string) error {
+	return fmt.Errorf("%w: %s", store.ErrNotFound, key(prefix, name))
+}
+
+func clone(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(data)), data...)
+}
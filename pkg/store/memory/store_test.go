@@ -0,0 +1,221 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+)
+
+func openStore(t *testing.T, maxVersions int) *memory.Store {
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true, MaxVersions: maxVersions})
+	require.NoError(t, err, "could not open in-memory storage backend")
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestPasswordStore(t *testing.T) {
+	db := openStore(t, 0)
+	ctx := context.Background()
+
+	// Try to get a password that does not exist
+	_, err := db.GetPassword(ctx, "does-not-exist")
+	require.ErrorIs(t, err, store.ErrNotFound, "should return error if password does not exist")
+
+	// Create and replace a password
+	require.NoError(t, db.UpdatePassword(ctx, "password_id", []byte("first")))
+	require.NoError(t, db.UpdatePassword(ctx, "password_id", []byte("second")))
+
+	actual, err := db.GetPassword(ctx, "password_id")
+	require.NoError(t, err, "should be able to get a password")
+	require.Equal(t, []byte("second"), actual, "wrong password returned")
+
+	// Prior versions of passwords are kept
+	actual, err = db.GetPasswordVersion(ctx, "password_id", "1")
+	require.NoError(t, err, "should be able to get a prior password version")
+	require.Equal(t, []byte("first"), actual, "wrong password version returned")
+
+	actual, err = db.GetPasswordVersion(ctx, "password_id", store.LatestVersion)
+	require.NoError(t, err, "should be able to get the latest password version")
+	require.Equal(t, []byte("second"), actual, "wrong password version returned")
+
+	_, err = db.GetPasswordVersion(ctx, "password_id", "3")
+	require.ErrorIs(t, err, store.ErrNotFound, "should return error if version does not exist")
+
+	counts, err := db.Count(ctx)
+	require.NoError(t, err, "should be able to count the store")
+	require.Equal(t, store.Counts{Passwords: 1}, counts, "prior versions should not be counted")
+
+	// Pruning keeps the most recent versions
+	require.ErrorIs(t, db.PrunePasswordVersions(ctx, "password_id", 0), store.ErrInvalidKeep)
+	require.NoError(t, db.PrunePasswordVersions(ctx, "password_id", 1))
+	_, err = db.GetPasswordVersion(ctx, "password_id", "1")
+	require.ErrorIs(t, err, store.ErrNotFound, "prior version should have been pruned")
+
+	// Delete the password
+	require.NoError(t, db.DeletePassword(ctx, "password_id"))
+	_, err = db.GetPassword(ctx, "password_id")
+	require.ErrorIs(t, err, store.ErrNotFound, "password should not exist after delete")
+
+	err = db.DeletePassword(ctx, "password_id")
+	require.ErrorIs(t, err, store.ErrNotFound, "should return error if password does not exist")
+}
+
+func TestCertificateStore(t *testing.T) {
+	db := openStore(t, 2)
+	ctx := context.Background()
+
+	_, err := db.GetCertificate(ctx, "does-not-exist")
+	require.ErrorIs(t, err, store.ErrNotFound, "should return error if certificate does not exist")
+
+	_, err = db.ListCertificateVersions(ctx, "does-not-exist")
+	require.ErrorIs(t, err, store.ErrNotFound, "should return error if certificate does not exist")
+
+	// Only the configured number of versions are kept
+	for _, cert := range []string{"first", "second", "third"} {
+		require.NoError(t, db.UpdateCertificate(ctx, "versioned", []byte(cert)))
+	}
+
+	versions, err := db.ListCertificateVersions(ctx, "versioned")
+	require.NoError(t, err, "should be able to list certificate versions")
+	require.Len(t, versions, 2, "expected versions beyond the max to be pruned")
+	require.Equal(t, "3", versions[0].Version, "versions should be ordered newest first")
+	require.Equal(t, "2", versions[1].Version, "versions should be ordered newest first")
+
+	actual, err := db.GetCertificateVersion(ctx, "versioned", "2")
+	require.NoError(t, err, "should be able to get a prior version")
+	require.Equal(t, []byte("second"), actual, "wrong version returned")
+
+	_, err = db.GetCertificateVersion(ctx, "versioned", "1")
+	require.ErrorIs(t, err, store.ErrNotFound, "oldest version should have been pruned")
+
+	// Stored data cannot be modified by the caller
+	actual[0] = 'X'
+	actual, err = db.GetCertificateVersion(ctx, "versioned", "2")
+	require.NoError(t, err, "should be able to get a prior version")
+	require.Equal(t, []byte("second"), actual, "stored data should be copied")
+
+	// Deleting the certificate removes all of its versions
+	require.NoError(t, db.DeleteCertificate(ctx, "versioned"))
+	_, err = db.GetCertificateVersion(ctx, "versioned", "3")
+	require.ErrorIs(t, err, store.ErrNotFound, "versions should not exist after delete")
+
+	err = db.DeleteCertificate(ctx, "versioned")
+	require.ErrorIs(t, err, store.ErrNotFound, "should return error if certificate does not exist")
+}
+
+func TestSecretStore(t *testing.T) {
+	db := openStore(t, 0)
+	ctx := context.Background()
+
+	_, err := db.GetSecret(ctx, "does-not-exist")
+	require.ErrorIs(t, err, store.ErrNotFound, "should return error if secret does not exist")
+
+	require.NoError(t, db.UpdateSecret(ctx, "secret_id", []byte("supersecretsquirrel")))
+	require.NoError(t, db.UpdateSecret(ctx, "secret_id", []byte("anothersecret")))
+
+	actual, err := db.GetSecret(ctx, "secret_id")
+	require.NoError(t, err, "should be able to get a secret")
+	require.Equal(t, []byte("anothersecret"), actual, "wrong secret returned after replace")
+
+	// Secrets should not be confused with passwords or certificates of the same name
+	_, err = db.GetPassword(ctx, "secret_id")
+	require.ErrorIs(t, err, store.ErrNotFound, "secret should not be returned as a password")
+
+	counts, err := db.Count(ctx)
+	require.NoError(t, err, "should be able to count the store")
+	require.Equal(t, store.Counts{Secrets: 1}, counts, "wrong number of secrets counted")
+
+	ids, err := db.List(ctx, store.SecretPrefix)
+	require.NoError(t, err, "should be able to list the secrets")
+	require.Equal(t, []string{"secret_id"}, ids, "wrong secrets listed")
+
+	exists, err := db.Exists(ctx, store.SecretPrefix, "secret_id")
+	require.NoError(t, err, "should be able to check if the secret exists")
+	require.True(t, exists, "expected the secret to exist")
+
+	exists, err = db.Exists(ctx, store.PasswordPrefix, "secret_id")
+	require.NoError(t, err, "should be able to check if the password exists")
+	require.False(t, exists, "expected resources of other types not to exist")
+
+	modified, err := db.Modified(ctx, store.SecretPrefix, "secret_id")
+	require.NoError(t, err, "should be able to get when the secret was modified")
+	require.WithinDuration(t, time.Now(), modified, time.Minute)
+
+	_, err = db.Modified(ctx, store.PasswordPrefix, "secret_id")
+	require.ErrorIs(t, err, store.ErrNotFound)
+
+	require.NoError(t, db.DeleteSecret(ctx, "secret_id"))
+	err = db.DeleteSecret(ctx, "secret_id")
+	require.ErrorIs(t, err, store.ErrNotFound, "should return error if secret does not exist")
+}
+
+func TestWriteBatch(t *testing.T) {
+	db := openStore(t, 0)
+	ctx := context.Background()
+
+	batch := (&store.Batch{}).UpdatePassword("batch", []byte("password")).UpdateCertificate("batch", []byte("cert"))
+	require.NoError(t, db.WriteBatch(ctx, batch), "should be able to write a batch")
+
+	// A failed batch should leave the store unmodified
+	batch = (&store.Batch{}).
+		UpdateCertificate("batch", []byte("new cert")).
+		UpdatePassword("batch", []byte("new password")).
+		UpdateCertificate("created", []byte("created")).
+		DeleteCertificate("does-not-exist")
+	err := db.WriteBatch(ctx, batch)
+	require.ErrorIs(t, err, store.ErrNotFound, "expected the batch to fail")
+	require.NotErrorIs(t, err, store.ErrRollbackFailed, "expected the batch to be rolled back")
+
+	password, err := db.GetPassword(ctx, "batch")
+	require.NoError(t, err, "should be able to get the batch password")
+	require.Equal(t, []byte("password"), password, "password should be restored")
+
+	versions, err := db.ListCertificateVersions(ctx, "batch")
+	require.NoError(t, err, "should be able to list certificate versions")
+	require.Len(t, versions, 1, "version created by the failed batch should be removed")
+
+	_, err = db.GetCertificate(ctx, "created")
+	require.ErrorIs(t, err, store.ErrNotFound, "certificate created by the failed batch should be removed")
+}
+
+func TestConcurrencyTokens(t *testing.T) {
+	db := openStore(t, 0)
+	ctx := context.Background()
+
+	// A non-empty token does not match a resource that does not exist
+	_, err := db.CompareAndUpdatePassword(ctx, "tokens", "1", []byte("first"))
+	require.ErrorIs(t, err, store.ErrVersionMismatch, "should not update a missing password with a token")
+
+	token, err := db.CompareAndUpdatePassword(ctx, "tokens", "", []byte("first"))
+	require.NoError(t, err, "should be able to create a password with an empty token")
+	require.Equal(t, "1", token, "expected first version")
+
+	_, err = db.CompareAndUpdatePassword(ctx, "tokens", "", []byte("again"))
+	require.ErrorIs(t, err, store.ErrVersionMismatch, "empty token should not match an existing password")
+
+	// Unconditional writes also change the token
+	require.NoError(t, db.UpdatePassword(ctx, "tokens", []byte("second")))
+	_, token, err = db.GetPasswordWithToken(ctx, "tokens")
+	require.NoError(t, err, "should be able to get the password with its token")
+	require.Equal(t, "2", token, "expected the token to change after an update")
+
+	_, err = db.CompareAndUpdatePassword(ctx, "tokens", "1", []byte("stale"))
+	require.ErrorIs(t, err, store.ErrVersionMismatch, "should not update with a stale token")
+
+	// Certificates are compared the same way
+	token, err = db.CompareAndUpdateCertificate(ctx, "tokens", "", []byte("cert"))
+	require.NoError(t, err, "should be able to create a certificate with an empty token")
+
+	token, err = db.CompareAndUpdateCertificate(ctx, "tokens", token, []byte("updated"))
+	require.NoError(t, err, "should update with the current token")
+
+	cert, current, err := db.GetCertificateWithToken(ctx, "tokens")
+	require.NoError(t, err, "should be able to get the certificate with its token")
+	require.Equal(t, []byte("updated"), cert, "wrong certificate returned")
+	require.Equal(t, token, current, "wrong token returned")
+}
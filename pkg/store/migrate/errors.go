@@ -0,0 +1,8 @@
+package migrate
+
+import "errors"
+
+var (
+	ErrConflict = errors.New("resource already exists in the destination with different data")
+	ErrMismatch = errors.New("resource read back from the destination does not match the source")
+)
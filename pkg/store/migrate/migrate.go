@@ -0,0 +1,164 @@
+/*
+Package migrate copies the stored resources from one storage backend to another, e.g.
+to move a courier deployment from local storage to Google Secret Manager.
+*/
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// Statuses of the resources reported by a migration.
+const (
+	StatusCopied    = "copied"
+	StatusSkipped   = "skipped"
+	StatusPlanned   = "planned"
+	StatusFailed    = "failed"
+	StatusUnchanged = "unchanged"
+)
+
+// Prefixes are the resource types in the order that they are migrated. Passwords are
+// migrated before certificates so that a certificate is never stored in the
+// destination without the password that decrypts it.
+var Prefixes = []string{store.PasswordPrefix, store.CertificatePrefix, store.SecretPrefix}
+
+// Options configure a migration.
+type Options struct {
+	// DryRun reports the resources that would be copied without writing them.
+	DryRun bool
+
+	// Overwrite replaces resources that already exist in the destination with different
+	// data; otherwise they are reported as failed with ErrConflict.
+	Overwrite bool
+
+	// Progress is called with the result of each resource as it is migrated.
+	Progress func(Result)
+}
+
+// Result describes the migration of a single resource. The checksum is the SHA-256
+// digest of the data in the source store.
+type Result struct {
+	Prefix   string
+	Name     string
+	Checksum string
+	Status   string
+	Err      error
+}
+
+// Report summarizes a migration.
+type Report struct {
+	Copied    int
+	Planned   int
+	Unchanged int
+	Skipped   int
+	Failed    int
+	Results   []Result
+}
+
+// Migrate copies the latest version of every password, certificate, and secret in the
+// source store to the destination store. Each copy is verified by reading it back from
+// the destination and comparing its digest with the digest of the source. Resources
+// that already exist in the destination with the same data are left unchanged. Prior
+// versions are not migrated. The migration continues if a resource cannot be migrated;
+// the failures are reported in the results.
+func Migrate(ctx context.Context, src, dst store.Store, opts Options) (report *Report, err error) {
+	report = &Report{}
+	for _, prefix := range Prefixes {
+		var ids []string
+		if ids, err = src.List(ctx, prefix); err != nil {
+			return report, fmt.Errorf("could not list %s resources: %w", prefix, err)
+		}
+
+		for _, id := range ids {
+			if err = ctx.Err(); err != nil {
+				return report, err
+			}
+
+			result := migrate(ctx, src, dst, prefix, id, opts)
+			report.add(result)
+			if opts.Progress != nil {
+				opts.Progress(result)
+			}
+		}
+	}
+	return report, nil
+}
+
+// migrate copies a single resource from the source to the destination.
+func migrate(ctx context.Context, src, dst store.Store, prefix, id string, opts Options) (result Result) {
+	result = Result{Prefix: prefix, Name: id}
+
+	var data []byte
+	if data, result.Err = store.GetResource(ctx, src, prefix, id); result.Err != nil {
+		// The resource was deleted since it was listed
+		if errors.Is(result.Err, store.ErrNotFound) {
+			result.Status, result.Err = StatusSkipped, nil
+			return result
+		}
+		result.Status = StatusFailed
+		return result
+	}
+	result.Checksum = store.Checksum(data)
+
+	// Compare with the resource in the destination, if any
+	existing, err := store.GetResource(ctx, dst, prefix, id)
+	switch {
+	case err == nil:
+		if store.Checksum(existing) == result.Checksum {
+			result.Status = StatusUnchanged
+			return result
+		}
+
+		if !opts.Overwrite {
+			result.Status, result.Err = StatusFailed, ErrConflict
+			return result
+		}
+	case !errors.Is(err, store.ErrNotFound):
+		result.Status, result.Err = StatusFailed, err
+		return result
+	}
+
+	if opts.DryRun {
+		result.Status = StatusPlanned
+		return result
+	}
+
+	if result.Err = store.PutResource(ctx, dst, prefix, id, data); result.Err != nil {
+		result.Status = StatusFailed
+		return result
+	}
+
+	// Verify the copy by reading it back from the destination
+	if existing, result.Err = store.GetResource(ctx, dst, prefix, id); result.Err != nil {
+		result.Status = StatusFailed
+		return result
+	}
+
+	if result.Err = store.VerifyChecksum(existing, result.Checksum); result.Err != nil {
+		result.Status, result.Err = StatusFailed, fmt.Errorf("%w: %w", ErrMismatch, result.Err)
+		return result
+	}
+
+	result.Status = StatusCopied
+	return result
+}
+
+func (r *Report) add(result Result) {
+	switch result.Status {
+	case StatusCopied:
+		r.Copied++
+	case StatusPlanned:
+		r.Planned++
+	case StatusUnchanged:
+		r.Unchanged++
+	case StatusSkipped:
+		r.Skipped++
+	case StatusFailed:
+		r.Failed++
+	}
+	r.Results = append(r.Results, result)
+}
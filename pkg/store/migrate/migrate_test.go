@@ -0,0 +1,106 @@
+package migrate_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+	"github.com/trisacrypto/courier/pkg/store/migrate"
+	"github.com/trisacrypto/courier/pkg/store/mock"
+)
+
+func openMemory(t *testing.T) *memory.Store {
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(t, err, "could not open in-memory storage backend")
+	return db
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	src, dst := openMemory(t), openMemory(t)
+
+	require.NoError(t, src.UpdatePassword(ctx, "alice", []byte("password")))
+	require.NoError(t, src.UpdateCertificate(ctx, "alice", []byte("certificate")))
+	require.NoError(t, src.UpdateCertificate(ctx, "bob", []byte("certificate")))
+	require.NoError(t, src.UpdateSecret(ctx, "carol", []byte("secret")))
+
+	// A dry run does not write to the destination
+	report, err := migrate.Migrate(ctx, src, dst, migrate.Options{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Planned)
+	require.Zero(t, report.Copied)
+
+	counts, err := dst.Count(ctx)
+	require.NoError(t, err)
+	require.Equal(t, store.Counts{}, counts, "expected the dry run not to write to the destination")
+
+	// Resources are copied in order with their digests reported as progress
+	var progress []migrate.Result
+	report, err = migrate.Migrate(ctx, src, dst, migrate.Options{Progress: func(r migrate.Result) { progress = append(progress, r) }})
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Copied)
+	require.Zero(t, report.Failed)
+	require.Equal(t, report.Results, progress)
+	require.Equal(t, store.PasswordPrefix, progress[0].Prefix, "expected passwords to be migrated first")
+	require.Equal(t, store.Checksum([]byte("password")), progress[0].Checksum)
+
+	cert, err := dst.GetCertificate(ctx, "bob")
+	require.NoError(t, err)
+	require.Equal(t, []byte("certificate"), cert)
+
+	// Migrating again leaves identical resources unchanged
+	report, err = migrate.Migrate(ctx, src, dst, migrate.Options{})
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Unchanged)
+}
+
+func TestConflict(t *testing.T) {
+	ctx := context.Background()
+	src, dst := openMemory(t), openMemory(t)
+
+	require.NoError(t, src.UpdateCertificate(ctx, "alice", []byte("new")))
+	require.NoError(t, dst.UpdateCertificate(ctx, "alice", []byte("old")))
+
+	// Different resources in the destination are not overwritten by default
+	report, err := migrate.Migrate(ctx, src, dst, migrate.Options{})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Failed)
+	require.ErrorIs(t, report.Results[0].Err, migrate.ErrConflict)
+
+	cert, err := dst.GetCertificate(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, []byte("old"), cert)
+
+	report, err = migrate.Migrate(ctx, src, dst, migrate.Options{Overwrite: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Copied)
+
+	cert, err = dst.GetCertificate(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), cert)
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	src, backend := openMemory(t), openMemory(t)
+	require.NoError(t, src.UpdateCertificate(ctx, "alice", []byte("certificate")))
+
+	// The destination corrupts the certificate once it has been written
+	dst := mock.New()
+	dst.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+		cert, err := backend.GetCertificate(ctx, name)
+		if err == nil {
+			cert = append(cert, '!')
+		}
+		return cert, err
+	}
+	dst.OnUpdateCertificate = backend.UpdateCertificate
+
+	report, err := migrate.Migrate(ctx, src, dst, migrate.Options{})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Failed)
+	require.ErrorIs(t, report.Results[0].Err, migrate.ErrMismatch)
+}
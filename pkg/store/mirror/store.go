@@ -0,0 +1,387 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/o11y"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// New mirrors writes to every one of the stores and reads from the first store that
+// returns the resource, so that deliveries are durable without external backup tooling
+// and resources can still be read if a backend is unavailable or missed a write. The
+// first store is the primary: concurrency tokens, versions, and counts are those of the
+// primary unless it cannot be read, in which case they are those of the first store
+// that can, and a token returned by a fallback store will not match the primary.
+func New(stores ...store.Store) *Store {
+	return &Store{stores: stores}
+}
+
+// Store implements the store.Store interface by mirroring resources across stores.
+// Writes are applied to every store even if a store fails, and the errors of the
+// stores that failed are returned together.
+type Store struct {
+	stores []store.Store
+}
+
+var (
+	_ store.Store          = &Store{}
+	_ store.HealthChecker  = &Store{}
+	_ store.ChangeNotifier = &Store{}
+)
+
+// Close every mirrored store.
+func (s *Store) Close() error {
+	errs := make([]error, 0, len(s.stores))
+	for _, db := range s.stores {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Check the connection to every mirrored store that supports health checks. Since
+// writes fail unless they succeed on every store, the mirror is unhealthy if any of
+// its stores is.
+func (s *Store) Check(ctx context.Context) error {
+	errs := make([]error, 0, len(s.stores))
+	for _, db := range s.stores {
+		if checker, ok := db.(store.HealthChecker); ok {
+			errs = append(errs, checker.Check(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OnChange registers the function with every mirrored store that detects changes
+// made outside of courier.
+func (s *Store) OnChange(fn func(store.Change)) {
+	for _, db := range s.stores {
+		if notifier, ok := store.Notifier(db); ok {
+			notifier.OnChange(fn)
+		}
+	}
+}
+
+// Count the resources in the primary store.
+func (s *Store) Count(ctx context.Context) (store.Counts, error) {
+	return read(s, "count", func(db store.Store) (store.Counts, error) {
+		return db.Count(ctx)
+	})
+}
+
+// WriteBatch applies the batch to every mirrored store. Each store applies or rolls
+// back the batch atomically, but a batch that fails on one store is not rolled back
+// on the stores where it succeeded.
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) error {
+	return s.write(func(db store.Store) error {
+		return db.WriteBatch(ctx, batch)
+	})
+}
+
+// List the resources of the type in every store, so that resources that are missing
+// from a store can still be listed. Stores that cannot be listed are ignored unless no
+// store can be listed.
+func (s *Store) List(ctx context.Context, prefix string) (ids []string, err error) {
+	var listed bool
+	seen := make(map[string]struct{})
+	for _, db := range s.stores {
+		var names []string
+		if names, err = db.List(ctx, prefix); err != nil {
+			continue
+		}
+
+		listed = true
+		for _, name := range names {
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				ids = append(ids, name)
+			}
+		}
+	}
+
+	if !listed {
+		return nil, err
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Exists checks if any store has the resource. If no store has it but a store could
+// not be checked, the error of that store is returned.
+func (s *Store) Exists(ctx context.Context, prefix, name string) (_ bool, err error) {
+	for _, db := range s.stores {
+		exists, xerr := db.Exists(ctx, prefix, name)
+		switch {
+		case xerr != nil:
+			if err == nil {
+				err = xerr
+			}
+		case exists:
+			return true, nil
+		}
+	}
+	return false, err
+}
+
+// Modified returns when the resource was written to the first store that has it.
+func (s *Store) Modified(ctx context.Context, prefix, name string) (time.Time, error) {
+	return read(s, "modified", func(db store.Store) (time.Time, error) {
+		return db.Modified(ctx, prefix, name)
+	})
+}
+
+// Delete the resource from every store like the delete method of its type.
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return s.remove(func(db store.Store) error {
+		return db.Delete(ctx, prefix, name)
+	})
+}
+
+//===========================================================================
+// Password Methods
+//===========================================================================
+
+func (s *Store) GetPassword(ctx context.Context, name string) ([]byte, error) {
+	return read(s, "get_password", func(db store.Store) ([]byte, error) {
+		return db.GetPassword(ctx, name)
+	})
+}
+
+func (s *Store) GetPasswordVersion(ctx context.Context, name, version string) ([]byte, error) {
+	return read(s, "get_password_version", func(db store.Store) ([]byte, error) {
+		return db.GetPasswordVersion(ctx, name, version)
+	})
+}
+
+func (s *Store) GetPasswordWithToken(ctx context.Context, name string) (password []byte, token string, err error) {
+	var res tokened
+	res, err = read(s, "get_password_with_token", func(db store.Store) (res tokened, err error) {
+		res.data, res.token, err = db.GetPasswordWithToken(ctx, name)
+		return res, err
+	})
+	return res.data, res.token, err
+}
+
+func (s *Store) UpdatePassword(ctx context.Context, name string, password []byte) error {
+	return s.write(func(db store.Store) error {
+		return db.UpdatePassword(ctx, name, password)
+	})
+}
+
+// CompareAndUpdatePassword compares the token with the primary store and only writes
+// the password to the other stores if the primary store was updated.
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, name, token string, password []byte) (_ string, err error) {
+	if token, err = s.stores[0].CompareAndUpdatePassword(ctx, name, token, password); err != nil {
+		return "", err
+	}
+
+	return token, s.mirror(func(db store.Store) error {
+		return db.UpdatePassword(ctx, name, password)
+	})
+}
+
+func (s *Store) DeletePassword(ctx context.Context, name string) error {
+	return s.remove(func(db store.Store) error {
+		return db.DeletePassword(ctx, name)
+	})
+}
+
+func (s *Store) PrunePasswordVersions(ctx context.Context, name string, keep int) error {
+	return s.remove(func(db store.Store) error {
+		return db.PrunePasswordVersions(ctx, name, keep)
+	})
+}
+
+//===========================================================================
+// Certificate Methods
+//===========================================================================
+
+func (s *Store) GetCertificate(ctx context.Context, name string) ([]byte, error) {
+	return read(s, "get_certificate", func(db store.Store) ([]byte, error) {
+		return db.GetCertificate(ctx, name)
+	})
+}
+
+func (s *Store) GetCertificateVersion(ctx context.Context, name, version string) ([]byte, error) {
+	return read(s, "get_certificate_version", func(db store.Store) ([]byte, error) {
+		return db.GetCertificateVersion(ctx, name, version)
+	})
+}
+
+func (s *Store) GetCertificateWithToken(ctx context.Context, name string) (cert []byte, token string, err error) {
+	var res tokened
+	res, err = read(s, "get_certificate_with_token", func(db store.Store) (res tokened, err error) {
+		res.data, res.token, err = db.GetCertificateWithToken(ctx, name)
+		return res, err
+	})
+	return res.data, res.token, err
+}
+
+func (s *Store) ListCertificateVersions(ctx context.Context, name string) ([]store.Version, error) {
+	return read(s, "list_certificate_versions", func(db store.Store) ([]store.Version, error) {
+		return db.ListCertificateVersions(ctx, name)
+	})
+}
+
+func (s *Store) UpdateCertificate(ctx context.Context, name string, cert []byte) error {
+	return s.write(func(db store.Store) error {
+		return db.UpdateCertificate(ctx, name, cert)
+	})
+}
+
+// CompareAndUpdateCertificate compares the token with the primary store and only
+// writes the certificate to the other stores if the primary store was updated.
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, name, token string, cert []byte) (_ string, err error) {
+	if token, err = s.stores[0].CompareAndUpdateCertificate(ctx, name, token, cert); err != nil {
+		return "", err
+	}
+
+	return token, s.mirror(func(db store.Store) error {
+		return db.UpdateCertificate(ctx, name, cert)
+	})
+}
+
+func (s *Store) DeleteCertificate(ctx context.Context, name string) error {
+	return s.remove(func(db store.Store) error {
+		return db.DeleteCertificate(ctx, name)
+	})
+}
+
+func (s *Store) PruneCertificateVersions(ctx context.Context, name string, keep int) error {
+	return s.remove(func(db store.Store) error {
+		return db.PruneCertificateVersions(ctx, name, keep)
+	})
+}
+
+//===========================================================================
+// Secret Methods
+//===========================================================================
+
+func (s *Store) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	return read(s, "get_secret", func(db store.Store) ([]byte, error) {
+		return db.GetSecret(ctx, name)
+	})
+}
+
+func (s *Store) UpdateSecret(ctx context.Context, name string, secret []byte) error {
+	return s.write(func(db store.Store) error {
+		return db.UpdateSecret(ctx, name, secret)
+	})
+}
+
+func (s *Store) DeleteSecret(ctx context.Context, name string) error {
+	return s.remove(func(db store.Store) error {
+		return db.DeleteSecret(ctx, name)
+	})
+}
+
+//===========================================================================
+// Helper methods
+//===========================================================================
+
+type tokened struct {
+	data  []byte
+	token string
+}
+
+// read returns the result of the first store that the read succeeds on. If the read
+// fails on every store, the first error that is not ErrNotFound is returned so that an
+// unavailable store is not reported as a missing resource. A read that fails over from
+// the primary because the primary failed, rather than because it does not have the
+// resource, is logged and counted along with whether another store served it.
+func read[T any](s *Store, operation string, fn func(store.Store) (T, error)) (res T, err error) {
+	var notFound, primary error
+	for i, db := range s.stores {
+		var rerr error
+		if res, rerr = fn(db); rerr == nil {
+			if primary != nil {
+				failedOver(operation, primary, true)
+			}
+			return res, nil
+		}
+
+		switch {
+		case errors.Is(rerr, store.ErrNotFound):
+			if notFound == nil {
+				notFound = rerr
+			}
+		case err == nil:
+			err = rerr
+			if i == 0 {
+				primary = rerr
+			}
+		}
+	}
+
+	if primary != nil && len(s.stores) > 1 {
+		failedOver(operation, primary, false)
+	}
+
+	var zero T
+	if err == nil {
+		err = notFound
+	}
+	return zero, err
+}
+
+// failedOver records a read that failed over from the primary, which failed with err.
+func failedOver(operation string, err error, served bool) {
+	result := "ok"
+	if !served {
+		result = "error"
+	}
+
+	log.Warn().Err(err).Str("operation", operation).Bool("served", served).Msg("primary store read failed, failing over to the mirrored stores")
+	o11y.StoreReadFailovers.WithLabelValues(operation, result).Inc()
+}
+
+// write applies the write to every store and returns the errors of the stores that
+// it failed on.
+func (s *Store) write(fn func(store.Store) error) error {
+	errs := make([]error, 0, len(s.stores))
+	for _, db := range s.stores {
+		errs = append(errs, fn(db))
+	}
+	return errors.Join(errs...)
+}
+
+// mirror applies the write to every store except the primary.
+func (s *Store) mirror(fn func(store.Store) error) error {
+	errs := make([]error, 0, len(s.stores)-1)
+	for _, db := range s.stores[1:] {
+		errs = append(errs, fn(db))
+	}
+	return errors.Join(errs...)
+}
+
+// remove applies the removal to every store. Stores that do not have the resource,
+// e.g. because they missed the write, are ignored unless no store has the resource.
+func (s *Store) remove(fn func(store.Store) error) error {
+	var (
+		found    bool
+		notFound error
+	)
+
+	errs := make([]error, 0, len(s.stores))
+	for _, db := range s.stores {
+		err := fn(db)
+		switch {
+		case err == nil:
+			found = true
+		case errors.Is(err, store.ErrNotFound):
+			notFound = err
+		default:
+			errs = append(errs, err)
+		}
+	}
+
+	if !found && len(errs) == 0 {
+		return notFound
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,126 @@
+package mirror_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+	"github.com/trisacrypto/courier/pkg/store/mirror"
+	"github.com/trisacrypto/courier/pkg/store/mock"
+)
+
+func openMemory(t *testing.T) *memory.Store {
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(t, err, "could not open in-memory storage backend")
+	return db
+}
+
+func TestMirror(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := openMemory(t), openMemory(t)
+	db := mirror.New(primary, secondary)
+	defer db.Close()
+
+	// Writes are applied to every store
+	require.NoError(t, db.UpdateCertificate(ctx, "mirrored", []byte("certificate")))
+	require.NoError(t, db.UpdatePassword(ctx, "mirrored", []byte("password")))
+	require.NoError(t, db.UpdateSecret(ctx, "mirrored", []byte("secret")))
+
+	for _, backend := range []store.Store{primary, secondary} {
+		cert, err := backend.GetCertificate(ctx, "mirrored")
+		require.NoError(t, err, "expected the certificate to be mirrored")
+		require.Equal(t, []byte("certificate"), cert)
+
+		counts, err := backend.Count(ctx)
+		require.NoError(t, err)
+		require.Equal(t, store.Counts{Certificates: 1, Passwords: 1, Secrets: 1}, counts)
+	}
+
+	// Reads fall back to the next store if the resource is missing
+	require.NoError(t, primary.DeletePassword(ctx, "mirrored"))
+	password, err := db.GetPassword(ctx, "mirrored")
+	require.NoError(t, err, "expected the read to fall back to the secondary store")
+	require.Equal(t, []byte("password"), password)
+
+	// Deletes succeed if any store has the resource
+	require.NoError(t, db.DeletePassword(ctx, "mirrored"), "expected the missing password to be ignored")
+	_, err = secondary.GetPassword(ctx, "mirrored")
+	require.ErrorIs(t, err, store.ErrNotFound, "expected the password to be deleted from every store")
+
+	_, err = db.GetPassword(ctx, "mirrored")
+	require.ErrorIs(t, err, store.ErrNotFound)
+	require.ErrorIs(t, db.DeletePassword(ctx, "mirrored"), store.ErrNotFound)
+
+	// Batches are applied to every store
+	batch := (&store.Batch{}).UpdatePassword("batch", []byte("password")).UpdateCertificate("batch", []byte("cert"))
+	require.NoError(t, db.WriteBatch(ctx, batch))
+	_, err = secondary.GetCertificate(ctx, "batch")
+	require.NoError(t, err, "expected the batch to be mirrored")
+
+	// Resources are listed and checked in every store
+	require.NoError(t, secondary.UpdateCertificate(ctx, "secondary", []byte("cert")))
+	ids, err := db.List(ctx, store.CertificatePrefix)
+	require.NoError(t, err)
+	require.Equal(t, []string{"batch", "mirrored", "secondary"}, ids)
+
+	exists, err := db.Exists(ctx, store.CertificatePrefix, "secondary")
+	require.NoError(t, err)
+	require.True(t, exists, "expected the resource in the secondary store to exist")
+
+	require.NoError(t, db.Delete(ctx, store.CertificatePrefix, "secondary"))
+	exists, err = db.Exists(ctx, store.CertificatePrefix, "secondary")
+	require.NoError(t, err)
+	require.False(t, exists, "expected the resource to be deleted from every store")
+}
+
+func TestConcurrencyTokens(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := openMemory(t), openMemory(t)
+	db := mirror.New(primary, secondary)
+
+	// Unconditional writes on the secondary store make its tokens diverge
+	require.NoError(t, secondary.UpdateCertificate(ctx, "tokens", []byte("stale")))
+
+	token, err := db.CompareAndUpdateCertificate(ctx, "tokens", "", []byte("first"))
+	require.NoError(t, err, "tokens should be compared with the primary store")
+
+	_, current, err := db.GetCertificateWithToken(ctx, "tokens")
+	require.NoError(t, err)
+	require.Equal(t, token, current, "expected the token of the primary store")
+
+	_, err = db.CompareAndUpdateCertificate(ctx, "tokens", "", []byte("again"))
+	require.ErrorIs(t, err, store.ErrVersionMismatch)
+
+	cert, err := secondary.GetCertificate(ctx, "tokens")
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), cert, "failed updates should not be mirrored")
+}
+
+func TestUnavailable(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := mock.New(), openMemory(t)
+	db := mirror.New(primary, secondary)
+
+	primary.OnUpdateCertificate = func(context.Context, string, []byte) error {
+		return store.ErrUnavailable
+	}
+	primary.OnGetCertificate = func(context.Context, string) ([]byte, error) {
+		return nil, store.ErrUnavailable
+	}
+
+	// Writes report the stores that failed but are still applied to the others
+	err := db.UpdateCertificate(ctx, "unavailable", []byte("certificate"))
+	require.ErrorIs(t, err, store.ErrUnavailable)
+
+	cert, err := db.GetCertificate(ctx, "unavailable")
+	require.NoError(t, err, "expected the read to fall back to the secondary store")
+	require.Equal(t, []byte("certificate"), cert)
+
+	// An unavailable store is not reported as a missing resource
+	_, err = db.GetCertificate(ctx, "does-not-exist")
+	require.ErrorIs(t, err, store.ErrUnavailable)
+	require.NotErrorIs(t, err, store.ErrNotFound)
+}
@@ -2,6 +2,7 @@ package mock
 
 import (
 	"context"
+	"time"
 
 	"github.com/trisacrypto/courier/pkg/store"
 )
@@ -16,29 +17,129 @@ func New() (s *Store) {
 
 // Reset resets the state of the mock so all functions return an error.
 func (s *Store) Reset() {
+	s.OnCount = func(ctx context.Context) (store.Counts, error) {
+		return store.Counts{}, ErrNotConfigured
+	}
+
+	s.OnWriteBatch = func(ctx context.Context, batch *store.Batch) error {
+		return ErrNotConfigured
+	}
+
+	s.OnList = func(ctx context.Context, prefix string) ([]string, error) {
+		return nil, ErrNotConfigured
+	}
+
+	s.OnExists = func(ctx context.Context, prefix, name string) (bool, error) {
+		return false, ErrNotConfigured
+	}
+
+	s.OnModified = func(ctx context.Context, prefix, name string) (time.Time, error) {
+		return time.Time{}, ErrNotConfigured
+	}
+
+	s.OnDelete = func(ctx context.Context, prefix, name string) error {
+		return ErrNotConfigured
+	}
+
 	s.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
 		return nil, ErrNotConfigured
 	}
 
+	s.OnGetPasswordVersion = func(ctx context.Context, name, version string) ([]byte, error) {
+		return nil, ErrNotConfigured
+	}
+
+	s.OnGetPasswordWithToken = func(ctx context.Context, name string) ([]byte, string, error) {
+		return nil, "", ErrNotConfigured
+	}
+
 	s.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
 		return ErrNotConfigured
 	}
 
+	s.OnCompareAndUpdatePassword = func(ctx context.Context, name, token string, password []byte) (string, error) {
+		return "", ErrNotConfigured
+	}
+
+	s.OnDeletePassword = func(ctx context.Context, name string) error {
+		return ErrNotConfigured
+	}
+
+	s.OnPrunePasswordVersions = func(ctx context.Context, name string, keep int) error {
+		return ErrNotConfigured
+	}
+
 	s.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
 		return nil, ErrNotConfigured
 	}
 
+	s.OnGetCertificateVersion = func(ctx context.Context, name, version string) ([]byte, error) {
+		return nil, ErrNotConfigured
+	}
+
+	s.OnListCertificateVersions = func(ctx context.Context, name string) ([]store.Version, error) {
+		return nil, ErrNotConfigured
+	}
+
+	s.OnGetCertificateWithToken = func(ctx context.Context, name string) ([]byte, string, error) {
+		return nil, "", ErrNotConfigured
+	}
+
 	s.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
 		return ErrNotConfigured
 	}
+
+	s.OnCompareAndUpdateCertificate = func(ctx context.Context, name, token string, cert []byte) (string, error) {
+		return "", ErrNotConfigured
+	}
+
+	s.OnDeleteCertificate = func(ctx context.Context, name string) error {
+		return ErrNotConfigured
+	}
+
+	s.OnPruneCertificateVersions = func(ctx context.Context, name string, keep int) error {
+		return ErrNotConfigured
+	}
+
+	s.OnGetSecret = func(ctx context.Context, name string) ([]byte, error) {
+		return nil, ErrNotConfigured
+	}
+
+	s.OnUpdateSecret = func(ctx context.Context, name string, secret []byte) error {
+		return ErrNotConfigured
+	}
+
+	s.OnDeleteSecret = func(ctx context.Context, name string) error {
+		return ErrNotConfigured
+	}
 }
 
 // Store implements the store.Store interface for mocking the store in tests.
 type Store struct {
-	OnGetPassword       func(ctx context.Context, name string) ([]byte, error)
-	OnUpdatePassword    func(ctx context.Context, name string, password []byte) error
-	OnGetCertificate    func(ctx context.Context, name string) ([]byte, error)
-	OnUpdateCertificate func(ctx context.Context, name string, cert []byte) error
+	OnCount                       func(ctx context.Context) (store.Counts, error)
+	OnWriteBatch                  func(ctx context.Context, batch *store.Batch) error
+	OnList                        func(ctx context.Context, prefix string) ([]string, error)
+	OnExists                      func(ctx context.Context, prefix, name string) (bool, error)
+	OnModified                    func(ctx context.Context, prefix, name string) (time.Time, error)
+	OnDelete                      func(ctx context.Context, prefix, name string) error
+	OnGetPassword                 func(ctx context.Context, name string) ([]byte, error)
+	OnGetPasswordVersion          func(ctx context.Context, name, version string) ([]byte, error)
+	OnGetPasswordWithToken        func(ctx context.Context, name string) ([]byte, string, error)
+	OnUpdatePassword              func(ctx context.Context, name string, password []byte) error
+	OnCompareAndUpdatePassword    func(ctx context.Context, name, token string, password []byte) (string, error)
+	OnDeletePassword              func(ctx context.Context, name string) error
+	OnPrunePasswordVersions       func(ctx context.Context, name string, keep int) error
+	OnGetCertificate              func(ctx context.Context, name string) ([]byte, error)
+	OnGetCertificateVersion       func(ctx context.Context, name, version string) ([]byte, error)
+	OnListCertificateVersions     func(ctx context.Context, name string) ([]store.Version, error)
+	OnGetCertificateWithToken     func(ctx context.Context, name string) ([]byte, string, error)
+	OnUpdateCertificate           func(ctx context.Context, name string, cert []byte) error
+	OnCompareAndUpdateCertificate func(ctx context.Context, name, token string, cert []byte) (string, error)
+	OnDeleteCertificate           func(ctx context.Context, name string) error
+	OnPruneCertificateVersions    func(ctx context.Context, name string, keep int) error
+	OnGetSecret                   func(ctx context.Context, name string) ([]byte, error)
+	OnUpdateSecret                func(ctx context.Context, name string, secret []byte) error
+	OnDeleteSecret                func(ctx context.Context, name string) error
 }
 
 var _ store.Store = &Store{}
@@ -47,18 +148,98 @@ func (s *Store) Close() error {
 	return nil
 }
 
+func (s *Store) Count(ctx context.Context) (store.Counts, error) {
+	return s.OnCount(ctx)
+}
+
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) error {
+	return s.OnWriteBatch(ctx, batch)
+}
+
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	return s.OnList(ctx, prefix)
+}
+
+func (s *Store) Exists(ctx context.Context, prefix, name string) (bool, error) {
+	return s.OnExists(ctx, prefix, name)
+}
+
+func (s *Store) Modified(ctx context.Context, prefix, name string) (time.Time, error) {
+	return s.OnModified(ctx, prefix, name)
+}
+
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return s.OnDelete(ctx, prefix, name)
+}
+
 func (s *Store) GetPassword(ctx context.Context, name string) ([]byte, error) {
 	return s.OnGetPassword(ctx, name)
 }
 
+func (s *Store) GetPasswordVersion(ctx context.Context, name, version string) ([]byte, error) {
+	return s.OnGetPasswordVersion(ctx, name, version)
+}
+
+func (s *Store) GetPasswordWithToken(ctx context.Context, name string) ([]byte, string, error) {
+	return s.OnGetPasswordWithToken(ctx, name)
+}
+
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, name, token string, password []byte) (string, error) {
+	return s.OnCompareAndUpdatePassword(ctx, name, token, password)
+}
+
 func (s *Store) UpdatePassword(ctx context.Context, name string, password []byte) error {
 	return s.OnUpdatePassword(ctx, name, password)
 }
 
+func (s *Store) DeletePassword(ctx context.Context, name string) error {
+	return s.OnDeletePassword(ctx, name)
+}
+
+func (s *Store) PrunePasswordVersions(ctx context.Context, name string, keep int) error {
+	return s.OnPrunePasswordVersions(ctx, name, keep)
+}
+
 func (s *Store) GetCertificate(ctx context.Context, name string) ([]byte, error) {
 	return s.OnGetCertificate(ctx, name)
 }
 
+func (s *Store) GetCertificateVersion(ctx context.Context, name, version string) ([]byte, error) {
+	return s.OnGetCertificateVersion(ctx, name, version)
+}
+
+func (s *Store) ListCertificateVersions(ctx context.Context, name string) ([]store.Version, error) {
+	return s.OnListCertificateVersions(ctx, name)
+}
+
+func (s *Store) GetCertificateWithToken(ctx context.Context, name string) ([]byte, string, error) {
+	return s.OnGetCertificateWithToken(ctx, name)
+}
+
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, name, token string, cert []byte) (string, error) {
+	return s.OnCompareAndUpdateCertificate(ctx, name, token, cert)
+}
+
 func (s *Store) UpdateCertificate(ctx context.Context, name string, cert []byte) error {
 	return s.OnUpdateCertificate(ctx, name, cert)
 }
+
+func (s *Store) DeleteCertificate(ctx context.Context, name string) error {
+	return s.OnDeleteCertificate(ctx, name)
+}
+
+func (s *Store) PruneCertificateVersions(ctx context.Context, name string, keep int) error {
+	return s.OnPruneCertificateVersions(ctx, name, keep)
+}
+
+func (s *Store) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	return s.OnGetSecret(ctx, name)
+}
+
+func (s *Store) UpdateSecret(ctx context.Context, name string, secret []byte) error {
+	return s.OnUpdateSecret(ctx, name, secret)
+}
+
+func (s *Store) DeleteSecret(ctx context.Context, name string) error {
+	return s.OnDeleteSecret(ctx, name)
+}
@@ -0,0 +1,54 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+var (
+	ErrNoDriver          = errors.New("postgres database driver is not registered")
+	ErrMigrationsPending = errors.New("postgres schema is not up to date")
+)
+
+// sqlState returns the SQLSTATE code of errors returned by the postgres server, which
+// the pgx and lib/pq drivers both expose, or an empty string for other errors.
+func sqlState(err error) string {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		return state.SQLState()
+	}
+	return ""
+}
+
+// storeError converts database errors into store errors so that the server can handle
+// them consistently with the other backends.
+func storeError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		return store.ErrNotFound
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return fmt.Errorf("%w: %w", store.ErrUnavailable, err)
+	}
+
+	// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+	switch code := sqlState(err); {
+	case code == "":
+		return err
+	case code == "42501", strings.HasPrefix(code, "28"):
+		return fmt.Errorf("%w: %w", store.ErrPermissionDenied, err)
+	case code == "54000":
+		return fmt.Errorf("%w: %w", store.ErrPayloadTooLarge, err)
+	case code == "40001", code == "40P01", strings.HasPrefix(code, "08"), strings.HasPrefix(code, "53"), strings.HasPrefix(code, "57P"):
+		return fmt.Errorf("%w: %w", store.ErrUnavailable, err)
+	default:
+		return err
+	}
+}
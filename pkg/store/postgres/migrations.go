@@ -0,0 +1,185 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migrations are embedded SQL files named with a sequential version and a description,
+// e.g. 0001_create_resources.sql. Migrations that have been released must never be
+// edited; changes to the schema are made by adding a new migration.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Key of the advisory lock that serializes migrations applied by concurrent replicas.
+const migrationLock = 0x636f7572696572
+
+const (
+	createMigrationsTable = `CREATE TABLE IF NOT EXISTS courier_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied TIMESTAMPTZ NOT NULL)`
+	selectMigrations      = `SELECT version FROM courier_migrations`
+	lockMigrations        = `SELECT pg_advisory_xact_lock($1)`
+	selectMigration       = `SELECT EXISTS (SELECT 1 FROM courier_migrations WHERE version = $1)`
+	insertMigration       = `INSERT INTO courier_migrations (version, name, applied) VALUES ($1, $2, $3)`
+)
+
+// The SQLSTATE returned when the migrations table has not been created yet.
+const undefinedTable = "42P01"
+
+// Migration is a versioned change to the schema of the postgres storage backend.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+// Migrations returns every migration of the schema in the order they are applied.
+func Migrations() (migrations []Migration, err error) {
+	var paths []string
+	if paths, err = fs.Glob(migrationFiles, "migrations/*.sql"); err != nil {
+		return nil, err
+	}
+
+	migrations = make([]Migration, 0, len(paths))
+	for _, p := range paths {
+		parts := migrationName.FindStringSubmatch(path.Base(p))
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", path.Base(p))
+		}
+
+		var data []byte
+		if data, err = migrationFiles.ReadFile(p); err != nil {
+			return nil, err
+		}
+
+		version, _ := strconv.Atoi(parts[1])
+		migrations = append(migrations, Migration{Version: version, Name: parts[2], SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate applies the migrations that have not been applied to the database and
+// returns them. Each migration is applied in its own transaction while holding an
+// advisory lock so that replicas that start at the same time do not apply a migration
+// more than once.
+func Migrate(ctx context.Context, db *sql.DB) (applied []Migration, err error) {
+	var migrations []Migration
+	if migrations, err = Migrations(); err != nil {
+		return nil, err
+	}
+
+	if _, err = db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, storeError(err)
+	}
+
+	for _, migration := range migrations {
+		var ok bool
+		if ok, err = apply(ctx, db, migration); err != nil {
+			return applied, fmt.Errorf("could not apply migration %04d %s: %w", migration.Version, migration.Name, storeError(err))
+		}
+
+		if ok {
+			applied = append(applied, migration)
+		}
+	}
+	return applied, nil
+}
+
+// Pending returns the migrations that have not been applied to the database without
+// modifying it.
+func Pending(ctx context.Context, db *sql.DB) (pending []Migration, err error) {
+	var migrations []Migration
+	if migrations, err = Migrations(); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]struct{})
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, selectMigrations); err != nil {
+		if sqlState(err) == undefinedTable {
+			return migrations, nil
+		}
+		return nil, storeError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, storeError(err)
+		}
+		applied[version] = struct{}{}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, storeError(err)
+	}
+
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// apply the migration unless another replica applied it first, reporting whether it
+// was applied.
+func apply(ctx context.Context, db *sql.DB, migration Migration) (ok bool, err error) {
+	var tx *sql.Tx
+	if tx, err = db.BeginTx(ctx, nil); err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, lockMigrations, int64(migrationLock)); err != nil {
+		return false, err
+	}
+
+	var exists bool
+	if err = tx.QueryRowContext(ctx, selectMigration, migration.Version).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	if exists {
+		return false, nil
+	}
+
+	if _, err = tx.ExecContext(ctx, migration.SQL); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, insertMigration, migration.Version, migration.Name, time.Now().UTC()); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Ensure the schema is current when migrations are not applied by the store.
+func checkMigrations(ctx context.Context, db *sql.DB) (err error) {
+	var pending []Migration
+	if pending, err = Pending(ctx, db); err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d migrations have not been applied", ErrMigrationsPending, len(pending))
+	}
+	return nil
+}
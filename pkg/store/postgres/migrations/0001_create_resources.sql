@@ -0,0 +1,22 @@
+-- Resources hold the latest version of every certificate, password, and secret along
+-- with when it was first created and last updated.
+CREATE TABLE IF NOT EXISTS courier_resources (
+    kind        TEXT NOT NULL,
+    name        TEXT NOT NULL,
+    version     BIGINT NOT NULL,
+    created     TIMESTAMPTZ NOT NULL,
+    updated     TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (kind, name)
+);
+
+-- Versions hold the payloads of the resources; every write adds a new version and old
+-- versions are removed when they are pruned or the resource is deleted.
+CREATE TABLE IF NOT EXISTS courier_versions (
+    kind        TEXT NOT NULL,
+    name        TEXT NOT NULL,
+    version     BIGINT NOT NULL,
+    data        BYTEA NOT NULL,
+    created     TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (kind, name, version),
+    FOREIGN KEY (kind, name) REFERENCES courier_resources (kind, name) ON DELETE CASCADE
+);
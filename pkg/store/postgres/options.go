@@ -0,0 +1,16 @@
+package postgres
+
+import "database/sql"
+
+// StoreOption allows us to configure the store when it is created.
+type StoreOption func(s *Store) error
+
+// WithDB uses the database connection pool rather than connecting with the configured
+// driver and url, e.g. for tests or to share a pool with other components. The store
+// closes the pool when it is closed.
+func WithDB(db *sql.DB) StoreOption {
+	return func(s *Store) error {
+		s.db = db
+		return nil
+	}
+}
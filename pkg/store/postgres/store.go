@@ -0,0 +1,525 @@
+/*
+Package postgres implements a storage backend that stores certificates, passwords, and
+secrets in PostgreSQL tables for teams that already operate postgres and want to use
+its transactions, history, and backups.
+
+Every resource has a row in the courier_resources table with the number of its latest
+version and when it was created and last updated, and every write adds a row with the
+payload to the courier_versions table, so prior versions can be retrieved until they
+are pruned. The version number of the resource is its concurrency token and batches of
+writes are applied in a single transaction. The schema is managed by the embedded
+migrations, which are applied when the store is opened unless disabled.
+
+Courier connects to postgres with database/sql and does not link a driver, so the
+binary must import a driver such as github.com/jackc/pgx/v5/stdlib that registers
+itself under the configured driver name.
+*/
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+const (
+	selectLatest   = `SELECT v.data, r.version FROM courier_resources r JOIN courier_versions v ON v.kind = r.kind AND v.name = r.name AND v.version = r.version WHERE r.kind = $1 AND r.name = $2`
+	selectVersion  = `SELECT data FROM courier_versions WHERE kind = $1 AND name = $2 AND version = $3`
+	listVersions   = `SELECT version, created FROM courier_versions WHERE kind = $1 AND name = $2 ORDER BY version DESC`
+	upsertResource = `INSERT INTO courier_resources (kind, name, version, created, updated) VALUES ($1, $2, 1, $3, $3) ON CONFLICT (kind, name) DO UPDATE SET version = courier_resources.version + 1, updated = EXCLUDED.updated RETURNING version`
+	createResource = `INSERT INTO courier_resources (kind, name, version, created, updated) VALUES ($1, $2, 1, $3, $3) ON CONFLICT (kind, name) DO NOTHING RETURNING version`
+	updateResource = `UPDATE courier_resources SET version = version + 1, updated = $4 WHERE kind = $1 AND name = $2 AND version = $3 RETURNING version`
+	insertVersion  = `INSERT INTO courier_versions (kind, name, version, data, created) VALUES ($1, $2, $3, $4, $5)`
+	pruneVersions  = `DELETE FROM courier_versions WHERE kind = $1 AND name = $2 AND version <= $3`
+	pruneResource  = `DELETE FROM courier_versions v USING courier_resources r WHERE v.kind = r.kind AND v.name = r.name AND r.kind = $1 AND r.name = $2 AND v.version <= r.version - $3`
+	deleteResource = `DELETE FROM courier_resources WHERE kind = $1 AND name = $2`
+	countResources = `SELECT kind, COUNT(*) FROM courier_resources GROUP BY kind`
+	listResources  = `SELECT name FROM courier_resources WHERE kind = $1 ORDER BY name`
+	selectUpdated  = `SELECT updated FROM courier_resources WHERE kind = $1 AND name = $2`
+)
+
+// Connect to the postgres database with the configured driver without checking the
+// schema, e.g. to apply migrations.
+func Connect(conf config.PostgresConfig) (db *sql.DB, err error) {
+	var registered bool
+	for _, name := range sql.Drivers() {
+		if name == conf.Driver {
+			registered = true
+			break
+		}
+	}
+
+	if !registered {
+		return nil, fmt.Errorf("%w: %q", ErrNoDriver, conf.Driver)
+	}
+	return sql.Open(conf.Driver, conf.URL)
+}
+
+// Open the postgres storage backend, applying any pending migrations if configured,
+// otherwise an error is returned if the schema is not up to date.
+func Open(conf config.PostgresConfig, opts ...StoreOption) (s *Store, err error) {
+	s = &Store{timeout: conf.Timeout, maxVersions: conf.MaxVersions}
+	for _, opt := range opts {
+		if err = opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.db == nil {
+		if s.db, err = Connect(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := s.context(context.Background())
+	defer cancel()
+
+	if conf.Migrate {
+		_, err = Migrate(ctx, s.db)
+	} else {
+		err = checkMigrations(ctx, s.db)
+	}
+
+	if err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Store implements the store.Store interface using PostgreSQL.
+type Store struct {
+	db          *sql.DB
+	timeout     time.Duration
+	maxVersions int
+}
+
+var (
+	_ store.Store         = &Store{}
+	_ store.HealthChecker = &Store{}
+)
+
+// Close the connections to the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Check that the database can be reached.
+func (s *Store) Check(ctx context.Context) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	return storeError(s.db.PingContext(ctx))
+}
+
+// Count the resources stored in the database.
+func (s *Store) Count(ctx context.Context) (counts store.Counts, err error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+
+	var rows *sql.Rows
+	if rows, err = s.db.QueryContext(ctx, countResources); err != nil {
+		return counts, storeError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			kind string
+			n    int
+		)
+		if err = rows.Scan(&kind, &n); err != nil {
+			return counts, storeError(err)
+		}
+
+		switch kind {
+		case store.CertificatePrefix:
+			counts.Certificates = n
+		case store.PasswordPrefix:
+			counts.Passwords = n
+		case store.SecretPrefix:
+			counts.Secrets = n
+		}
+	}
+	return counts, storeError(rows.Err())
+}
+
+// List the ids of the resources of the type stored in the database.
+func (s *Store) List(ctx context.Context, prefix string) (ids []string, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+
+	var rows *sql.Rows
+	if rows, err = s.db.QueryContext(ctx, listResources, prefix); err != nil {
+		return nil, storeError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, storeError(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, storeError(rows.Err())
+}
+
+// Exists checks if the resource is in the database.
+func (s *Store) Exists(ctx context.Context, prefix, name string) (_ bool, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return false, err
+	}
+
+	_, _, err = s.get(ctx, prefix, name)
+	return store.Found(err)
+}
+
+// Modified returns when the latest version of the resource was written.
+func (s *Store) Modified(ctx context.Context, prefix, name string) (updated time.Time, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return time.Time{}, err
+	}
+
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+
+	if err = s.db.QueryRowContext(ctx, selectUpdated, prefix, name).Scan(&updated); err != nil {
+		return time.Time{}, storeError(err)
+	}
+	return updated, nil
+}
+
+// Delete the resource like the delete method of its type.
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return store.DeleteResource(ctx, s, prefix, name)
+}
+
+// WriteBatch applies the writes in the batch in a single transaction so that either
+// all of the writes are applied or none of them are.
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) error {
+	return s.transact(ctx, func(ctx context.Context, tx *sql.Tx) (err error) {
+		for _, op := range batch.Ops() {
+			if op.Delete {
+				err = s.delete(ctx, tx, op.Prefix, op.Name)
+			} else {
+				_, err = s.write(ctx, tx, op.Prefix, op.Name, op.Data, "", false)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//===========================================================================
+// Password Methods
+//===========================================================================
+
+// GetPassword retrieves the latest version of a password by id from the database.
+func (s *Store) GetPassword(ctx context.Context, id string) (password []byte, err error) {
+	password, _, err = s.get(ctx, store.PasswordPrefix, id)
+	return password, err
+}
+
+// GetPasswordVersion retrieves a specific version of a password by id from the
+// database.
+func (s *Store) GetPasswordVersion(ctx context.Context, id, version string) ([]byte, error) {
+	return s.version(ctx, store.PasswordPrefix, id, version)
+}
+
+// GetPasswordWithToken retrieves a password by id along with its version number,
+// which is used as the concurrency token.
+func (s *Store) GetPasswordWithToken(ctx context.Context, id string) (password []byte, token string, err error) {
+	return s.get(ctx, store.PasswordPrefix, id)
+}
+
+// UpdatePassword stores a new version of a password by id in the database.
+func (s *Store) UpdatePassword(ctx context.Context, id string, password []byte) (err error) {
+	_, err = s.update(ctx, store.PasswordPrefix, id, password, "", false)
+	return err
+}
+
+// CompareAndUpdatePassword stores a new version of a password by id if its version
+// number matches the token and returns the new version number.
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, id, token string, password []byte) (string, error) {
+	return s.update(ctx, store.PasswordPrefix, id, password, token, true)
+}
+
+// DeletePassword deletes a password and all of its versions by id from the database.
+func (s *Store) DeletePassword(ctx context.Context, id string) error {
+	return s.transact(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return s.delete(ctx, tx, store.PasswordPrefix, id)
+	})
+}
+
+// PrunePasswordVersions removes all but the keep most recent versions of a password.
+func (s *Store) PrunePasswordVersions(ctx context.Context, id string, keep int) error {
+	return s.prune(ctx, store.PasswordPrefix, id, keep)
+}
+
+//===========================================================================
+// Certificate Methods
+//===========================================================================
+
+// GetCertificate retrieves the latest version of a certificate by id from the
+// database.
+func (s *Store) GetCertificate(ctx context.Context, id string) (cert []byte, err error) {
+	cert, _, err = s.get(ctx, store.CertificatePrefix, id)
+	return cert, err
+}
+
+// GetCertificateVersion retrieves a specific version of a certificate by id from the
+// database.
+func (s *Store) GetCertificateVersion(ctx context.Context, id, version string) ([]byte, error) {
+	return s.version(ctx, store.CertificatePrefix, id, version)
+}
+
+// GetCertificateWithToken retrieves a certificate by id along with its version number,
+// which is used as the concurrency token.
+func (s *Store) GetCertificateWithToken(ctx context.Context, id string) (cert []byte, token string, err error) {
+	return s.get(ctx, store.CertificatePrefix, id)
+}
+
+// ListCertificateVersions returns the versions of a certificate by id that are kept in
+// the database, newest first.
+func (s *Store) ListCertificateVersions(ctx context.Context, id string) (versions []store.Version, err error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+
+	var rows *sql.Rows
+	if rows, err = s.db.QueryContext(ctx, listVersions, store.CertificatePrefix, id); err != nil {
+		return nil, storeError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			version int64
+			created time.Time
+		)
+		if err = rows.Scan(&version, &created); err != nil {
+			return nil, storeError(err)
+		}
+
+		versions = append(versions, store.Version{
+			Version: strconv.FormatInt(version, 10),
+			Created: created,
+			State:   "enabled",
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, storeError(err)
+	}
+
+	// The latest version is never pruned so a certificate always has a version
+	if len(versions) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return versions, nil
+}
+
+// UpdateCertificate stores a new version of a certificate by id in the database.
+func (s *Store) UpdateCertificate(ctx context.Context, id string, cert []byte) (err error) {
+	_, err = s.update(ctx, store.CertificatePrefix, id, cert, "", false)
+	return err
+}
+
+// CompareAndUpdateCertificate stores a new version of a certificate by id if its
+// version number matches the token and returns the new version number.
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, id, token string, cert []byte) (string, error) {
+	return s.update(ctx, store.CertificatePrefix, id, cert, token, true)
+}
+
+// DeleteCertificate deletes a certificate and all of its versions by id from the
+// database.
+func (s *Store) DeleteCertificate(ctx context.Context, id string) error {
+	return s.transact(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return s.delete(ctx, tx, store.CertificatePrefix, id)
+	})
+}
+
+// PruneCertificateVersions removes all but the keep most recent versions of a
+// certificate.
+func (s *Store) PruneCertificateVersions(ctx context.Context, id string, keep int) error {
+	return s.prune(ctx, store.CertificatePrefix, id, keep)
+}
+
+//===========================================================================
+// Secret Methods
+//===========================================================================
+
+// GetSecret retrieves the latest version of a secret by name from the database.
+func (s *Store) GetSecret(ctx context.Context, name string) (secret []byte, err error) {
+	secret, _, err = s.get(ctx, store.SecretPrefix, name)
+	return secret, err
+}
+
+// UpdateSecret stores a new version of a secret by name in the database.
+func (s *Store) UpdateSecret(ctx context.Context, name string, secret []byte) (err error) {
+	_, err = s.update(ctx, store.SecretPrefix, name, secret, "", false)
+	return err
+}
+
+// DeleteSecret deletes a secret and all of its versions by name from the database.
+func (s *Store) DeleteSecret(ctx context.Context, name string) error {
+	return s.transact(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return s.delete(ctx, tx, store.SecretPrefix, name)
+	})
+}
+
+//===========================================================================
+// Helper methods
+//===========================================================================
+
+// context bounds the context with the configured query deadline.
+func (s *Store) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout > 0 {
+		return context.WithTimeout(ctx, s.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// transact runs the function in a transaction that is committed if it succeeds and
+// rolled back otherwise. The function must use the context it is passed, which is
+// bounded by the query deadline.
+func (s *Store) transact(ctx context.Context, fn func(context.Context, *sql.Tx) error) (err error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+
+	var tx *sql.Tx
+	if tx, err = s.db.BeginTx(ctx, nil); err != nil {
+		return storeError(err)
+	}
+	defer tx.Rollback()
+
+	if err = fn(ctx, tx); err != nil {
+		return storeError(err)
+	}
+	return storeError(tx.Commit())
+}
+
+// get returns the latest payload of the resource and its version number.
+func (s *Store) get(ctx context.Context, kind, name string) (data []byte, token string, err error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+
+	var version int64
+	if err = s.db.QueryRowContext(ctx, selectLatest, kind, name).Scan(&data, &version); err != nil {
+		return nil, "", storeError(err)
+	}
+	return data, strconv.FormatInt(version, 10), nil
+}
+
+// version returns the payload of a specific version of the resource.
+func (s *Store) version(ctx context.Context, kind, name, version string) (data []byte, err error) {
+	if version == store.LatestVersion {
+		data, _, err = s.get(ctx, kind, name)
+		return data, err
+	}
+
+	var n int64
+	if n, err = strconv.ParseInt(version, 10, 64); err != nil || n < 1 {
+		return nil, store.ErrNotFound
+	}
+
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+
+	if err = s.db.QueryRowContext(ctx, selectVersion, kind, name, n).Scan(&data); err != nil {
+		return nil, storeError(err)
+	}
+	return data, nil
+}
+
+// update writes a new version of the resource in its own transaction.
+func (s *Store) update(ctx context.Context, kind, name string, data []byte, token string, conditional bool) (version string, err error) {
+	err = s.transact(ctx, func(ctx context.Context, tx *sql.Tx) (err error) {
+		version, err = s.write(ctx, tx, kind, name, data, token, conditional)
+		return err
+	})
+	return version, err
+}
+
+// write adds a new version of the resource and returns its version number, pruning
+// versions beyond the configured maximum. If conditional is true the write is only
+// applied if the latest version matches the token, where an empty token only matches a
+// resource that does not exist.
+func (s *Store) write(ctx context.Context, tx *sql.Tx, kind, name string, data []byte, token string, conditional bool) (_ string, err error) {
+	now := time.Now().UTC()
+
+	var version int64
+	switch {
+	case !conditional:
+		err = tx.QueryRowContext(ctx, upsertResource, kind, name, now).Scan(&version)
+	case token == "":
+		err = tx.QueryRowContext(ctx, createResource, kind, name, now).Scan(&version)
+	default:
+		var current int64
+		if current, err = strconv.ParseInt(token, 10, 64); err != nil {
+			return "", store.ErrVersionMismatch
+		}
+		err = tx.QueryRowContext(ctx, updateResource, kind, name, current, now).Scan(&version)
+	}
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", store.ErrVersionMismatch
+		}
+		return "", err
+	}
+
+	if _, err = tx.ExecContext(ctx, insertVersion, kind, name, version, data, now); err != nil {
+		return "", err
+	}
+
+	if s.maxVersions > 0 && version > int64(s.maxVersions) {
+		if _, err = tx.ExecContext(ctx, pruneVersions, kind, name, version-int64(s.maxVersions)); err != nil {
+			return "", err
+		}
+	}
+	return strconv.FormatInt(version, 10), nil
+}
+
+// delete removes the resource, whose versions are removed by the foreign key.
+func (s *Store) delete(ctx context.Context, tx *sql.Tx, kind, name string) (err error) {
+	var result sql.Result
+	if result, err = tx.ExecContext(ctx, deleteResource, kind, name); err != nil {
+		return err
+	}
+
+	var n int64
+	if n, err = result.RowsAffected(); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// prune removes all but the keep most recent versions of the resource.
+func (s *Store) prune(ctx context.Context, kind, name string, keep int) (err error) {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+
+	_, err = s.db.ExecContext(ctx, pruneResource, kind, name, keep)
+	return storeError(err)
+}
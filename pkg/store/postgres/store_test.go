@@ -0,0 +1,562 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/postgres"
+)
+
+type postgresStoreTestSuite struct {
+	suite.Suite
+	db    *fakeDB
+	store *postgres.Store
+}
+
+func (s *postgresStoreTestSuite) SetupTest() {
+	var err error
+	s.db = newFakeDB()
+	conf := config.PostgresConfig{Enabled: true, Migrate: true, MaxVersions: 3, Timeout: 5 * time.Second}
+	s.store, err = postgres.Open(conf, postgres.WithDB(sql.OpenDB(s.db)))
+	s.NoError(err, "could not open postgres storage backend")
+}
+
+func (s *postgresStoreTestSuite) TearDownTest() {
+	s.NoError(s.store.Close(), "could not close postgres storage backend")
+}
+
+func TestPostgresStore(t *testing.T) {
+	suite.Run(t, new(postgresStoreTestSuite))
+}
+
+func (s *postgresStoreTestSuite) TestPasswordStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	_, err := s.store.GetPassword(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "expected not found before the password is stored")
+
+	require.NoError(s.store.UpdatePassword(ctx, "cert-id", []byte("first password")))
+	require.NoError(s.store.UpdatePassword(ctx, "cert-id", []byte("second password")))
+
+	password, token, err := s.store.GetPasswordWithToken(ctx, "cert-id")
+	require.NoError(err, "could not get password")
+	require.Equal([]byte("second password"), password)
+	require.Equal("2", token, "the version number should be the token")
+
+	// Prior versions should be kept
+	password, err = s.store.GetPasswordVersion(ctx, "cert-id", "1")
+	require.NoError(err, "could not get prior version")
+	require.Equal([]byte("first password"), password)
+
+	_, err = s.store.GetPasswordVersion(ctx, "cert-id", "abc")
+	require.ErrorIs(err, store.ErrNotFound, "invalid versions should not be found")
+
+	// Conditional updates should only be applied to the latest version
+	_, err = s.store.CompareAndUpdatePassword(ctx, "cert-id", "1", []byte("stale password"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "expected a stale token to be rejected")
+	_, err = s.store.CompareAndUpdatePassword(ctx, "cert-id", "", []byte("stale password"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "an empty token should only match a new resource")
+
+	token, err = s.store.CompareAndUpdatePassword(ctx, "cert-id", token, []byte("third password"))
+	require.NoError(err, "could not update the password with the current token")
+	require.Equal("3", token)
+
+	// Pruning should keep the most recent versions
+	require.ErrorIs(s.store.PrunePasswordVersions(ctx, "cert-id", 0), store.ErrInvalidKeep)
+	require.NoError(s.store.PrunePasswordVersions(ctx, "cert-id", 1), "could not prune password versions")
+	_, err = s.store.GetPasswordVersion(ctx, "cert-id", "2")
+	require.ErrorIs(err, store.ErrNotFound, "expected pruned version to be removed")
+	password, err = s.store.GetPasswordVersion(ctx, "cert-id", store.LatestVersion)
+	require.NoError(err, "the latest version should not be pruned")
+	require.Equal([]byte("third password"), password)
+
+	require.NoError(s.store.DeletePassword(ctx, "cert-id"), "could not delete password")
+	_, err = s.store.GetPassword(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "expected password to be deleted")
+	require.ErrorIs(s.store.DeletePassword(ctx, "cert-id"), store.ErrNotFound)
+	require.Empty(s.db.versions, "versions should be deleted with the resource")
+}
+
+func (s *postgresStoreTestSuite) TestCertificateStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	_, err := s.store.ListCertificateVersions(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "expected not found before the certificate is stored")
+
+	token, err := s.store.CompareAndUpdateCertificate(ctx, "cert-id", "", []byte("certificate 1"))
+	require.NoError(err, "an empty token should create the certificate")
+	require.Equal("1", token)
+
+	for i := 2; i <= 5; i++ {
+		require.NoError(s.store.UpdateCertificate(ctx, "cert-id", []byte(fmt.Sprintf("certificate %d", i))))
+	}
+
+	cert, err := s.store.GetCertificate(ctx, "cert-id")
+	require.NoError(err, "could not get certificate")
+	require.Equal([]byte("certificate 5"), cert)
+
+	// Only the configured maximum number of versions should be kept, newest first
+	versions, err := s.store.ListCertificateVersions(ctx, "cert-id")
+	require.NoError(err, "could not list certificate versions")
+	require.Len(versions, 3)
+	for i, version := range versions {
+		require.Equal(fmt.Sprintf("%d", 5-i), version.Version)
+		require.Equal("enabled", version.State)
+		require.False(version.Created.IsZero(), "expected the created timestamp to be set")
+	}
+
+	_, err = s.store.GetCertificateVersion(ctx, "cert-id", "2")
+	require.ErrorIs(err, store.ErrNotFound, "expected versions beyond the maximum to be pruned")
+	cert, err = s.store.GetCertificateVersion(ctx, "cert-id", "3")
+	require.NoError(err, "could not get prior version")
+	require.Equal([]byte("certificate 3"), cert)
+
+	require.NoError(s.store.DeleteCertificate(ctx, "cert-id"), "could not delete certificate")
+	_, err = s.store.GetCertificate(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "expected certificate to be deleted")
+}
+
+func (s *postgresStoreTestSuite) TestSecretStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.UpdateSecret(ctx, "sealing-key", []byte("supersecret")))
+	secret, err := s.store.GetSecret(ctx, "sealing-key")
+	require.NoError(err, "could not get secret")
+	require.Equal([]byte("supersecret"), secret)
+
+	require.NoError(s.store.UpdatePassword(ctx, "cert-id", []byte("password")))
+	require.NoError(s.store.UpdateCertificate(ctx, "cert-id", []byte("certificate")))
+	require.NoError(s.store.UpdateCertificate(ctx, "other-id", []byte("certificate")))
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err, "could not count resources")
+	require.Equal(store.Counts{Certificates: 2, Passwords: 1, Secrets: 1}, counts)
+
+	ids, err := s.store.List(ctx, store.CertificatePrefix)
+	require.NoError(err, "could not list certificates")
+	require.Equal([]string{"cert-id", "other-id"}, ids)
+
+	exists, err := s.store.Exists(ctx, store.CertificatePrefix, "other-id")
+	require.NoError(err, "could not check if the certificate exists")
+	require.True(exists, "expected the certificate to exist")
+
+	exists, err = s.store.Exists(ctx, store.CertificatePrefix, "does-not-exist")
+	require.NoError(err, "could not check if the certificate exists")
+	require.False(exists, "expected the certificate not to exist")
+
+	modified, err := s.store.Modified(ctx, store.CertificatePrefix, "other-id")
+	require.NoError(err, "could not get the modification time of the certificate")
+	require.WithinDuration(time.Now(), modified, time.Minute)
+
+	_, err = s.store.Modified(ctx, store.CertificatePrefix, "does-not-exist")
+	require.ErrorIs(err, store.ErrNotFound)
+
+	require.NoError(s.store.DeleteSecret(ctx, "sealing-key"), "could not delete secret")
+	_, err = s.store.GetSecret(ctx, "sealing-key")
+	require.ErrorIs(err, store.ErrNotFound, "expected secret to be deleted")
+}
+
+func (s *postgresStoreTestSuite) TestWriteBatch() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.UpdatePassword(ctx, "cert-id", []byte("old password")))
+
+	// A failed write should roll back the transaction
+	batch := (&store.Batch{}).UpdatePassword("cert-id", []byte("new password")).DeleteCertificate("cert-id")
+	err := s.store.WriteBatch(ctx, batch)
+	require.ErrorIs(err, store.ErrNotFound, "expected the delete error to be returned")
+
+	password, err := s.store.GetPassword(ctx, "cert-id")
+	require.NoError(err)
+	require.Equal([]byte("old password"), password, "password write was not rolled back")
+
+	batch = (&store.Batch{}).UpdatePassword("cert-id", []byte("new password")).UpdateCertificate("cert-id", []byte("certificate"))
+	require.NoError(s.store.WriteBatch(ctx, batch), "could not write batch")
+	password, err = s.store.GetPassword(ctx, "cert-id")
+	require.NoError(err)
+	require.Equal([]byte("new password"), password)
+	_, err = s.store.GetCertificate(ctx, "cert-id")
+	require.NoError(err, "certificate was not written")
+}
+
+func (s *postgresStoreTestSuite) TestErrors() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.Check(ctx), "health check should pass")
+
+	s.db.fail(&pgError{code: "08006"})
+	require.ErrorIs(s.store.Check(ctx), store.ErrUnavailable, "expected unavailable error")
+
+	s.db.fail(&pgError{code: "42501"})
+	_, err := s.store.GetCertificate(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrPermissionDenied, "expected permission denied error")
+
+	var pgErr *pgError
+	require.ErrorAs(err, &pgErr, "expected the database error to be wrapped")
+
+	s.db.fail(&pgError{code: "54000"})
+	err = s.store.UpdateCertificate(ctx, "cert-id", []byte("certificate"))
+	require.ErrorIs(err, store.ErrPayloadTooLarge, "expected payload too large error")
+	_, err = s.store.GetCertificate(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "the failed write should not be applied")
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDB()
+	db := sql.OpenDB(fake)
+	defer db.Close()
+
+	migrations, err := postgres.Migrations()
+	require.NoError(t, err, "could not load migrations")
+	require.NotEmpty(t, migrations, "expected embedded migrations")
+	for i, migration := range migrations {
+		require.Equal(t, i+1, migration.Version, "migrations should be numbered sequentially")
+		require.NotEmpty(t, migration.SQL)
+	}
+
+	// All migrations are pending before the migrations table is created
+	pending, err := postgres.Pending(ctx, db)
+	require.NoError(t, err, "could not check pending migrations")
+	require.Equal(t, migrations, pending)
+
+	// The store should not open if the schema is not current and migrations are disabled
+	_, err = postgres.Open(config.PostgresConfig{Enabled: true}, postgres.WithDB(sql.OpenDB(fake)))
+	require.ErrorIs(t, err, postgres.ErrMigrationsPending)
+
+	applied, err := postgres.Migrate(ctx, db)
+	require.NoError(t, err, "could not apply migrations")
+	require.Equal(t, migrations, applied)
+
+	pending, err = postgres.Pending(ctx, db)
+	require.NoError(t, err, "could not check pending migrations")
+	require.Empty(t, pending, "expected all migrations to be applied")
+
+	applied, err = postgres.Migrate(ctx, db)
+	require.NoError(t, err, "could not apply migrations")
+	require.Empty(t, applied, "migrations should only be applied once")
+
+	s, err := postgres.Open(config.PostgresConfig{Enabled: true}, postgres.WithDB(sql.OpenDB(fake)))
+	require.NoError(t, err, "store should open when the schema is current")
+	require.NoError(t, s.Close())
+}
+
+func TestConnect(t *testing.T) {
+	_, err := postgres.Connect(config.PostgresConfig{Enabled: true, URL: "postgres://localhost/courier", Driver: "unregistered"})
+	require.ErrorIs(t, err, postgres.ErrNoDriver, "expected an error if the driver is not registered")
+}
+
+//===========================================================================
+// Fake database
+//===========================================================================
+
+// Implements the queries of the postgres store against in-memory tables. Transactions
+// snapshot the tables and restore them when they are rolled back.
+type fakeDB struct {
+	sync.Mutex
+	migrations map[int64]string
+	schema     bool
+	resources  map[fakeKey]*fakeResource
+	versions   map[fakeKey]*fakeVersion
+	snapshot   *fakeDB
+	err        error
+}
+
+type fakeKey struct {
+	kind    string
+	name    string
+	version int64
+}
+
+type fakeResource struct {
+	version int64
+	created time.Time
+	updated time.Time
+}
+
+type fakeVersion struct {
+	data    []byte
+	created time.Time
+}
+
+// Errors returned by the postgres server expose their SQLSTATE code.
+type pgError struct {
+	code string
+}
+
+func (e *pgError) Error() string    { return "postgres error " + e.code }
+func (e *pgError) SQLState() string { return e.code }
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		resources: make(map[fakeKey]*fakeResource),
+		versions:  make(map[fakeKey]*fakeVersion),
+	}
+}
+
+// fail returns the error from the next query.
+func (f *fakeDB) fail(err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.err = err
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+func (f *fakeDB) clone() *fakeDB {
+	c := newFakeDB()
+	c.schema = f.schema
+	if f.migrations != nil {
+		c.migrations = make(map[int64]string)
+		for k, v := range f.migrations {
+			c.migrations[k] = v
+		}
+	}
+	for k, v := range f.resources {
+		r := *v
+		c.resources[k] = &r
+	}
+	for k, v := range f.versions {
+		c.versions[k] = v
+	}
+	return c
+}
+
+// Executes the query, returning the columns and rows of the result.
+func (f *fakeDB) query(query string, args []driver.NamedValue) (cols []string, rows [][]driver.Value, affected int64, err error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.err != nil {
+		err, f.err = f.err, nil
+		return nil, nil, 0, err
+	}
+
+	arg := func(i int) driver.Value { return args[i].Value }
+	key := func() fakeKey { return fakeKey{kind: arg(0).(string), name: arg(1).(string)} }
+
+	if strings.Contains(query, "courier_resources") || strings.Contains(query, "courier_versions") {
+		if !f.schema && !strings.HasPrefix(query, "--") {
+			return nil, nil, 0, &pgError{code: "42P01"}
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS courier_migrations"):
+		if f.migrations == nil {
+			f.migrations = make(map[int64]string)
+		}
+	case strings.HasPrefix(query, "SELECT version FROM courier_migrations"):
+		if f.migrations == nil {
+			return nil, nil, 0, &pgError{code: "42P01"}
+		}
+		cols = []string{"version"}
+		for version := range f.migrations {
+			rows = append(rows, []driver.Value{version})
+		}
+	case strings.HasPrefix(query, "SELECT pg_advisory_xact_lock"):
+	case strings.HasPrefix(query, "SELECT EXISTS (SELECT 1 FROM courier_migrations"):
+		_, ok := f.migrations[arg(0).(int64)]
+		return []string{"exists"}, [][]driver.Value{{ok}}, 0, nil
+	case strings.HasPrefix(query, "INSERT INTO courier_migrations"):
+		f.migrations[arg(0).(int64)] = arg(1).(string)
+	case strings.HasPrefix(query, "--"):
+		// The migration that creates the schema starts with a comment
+		f.schema = true
+	case strings.HasPrefix(query, "SELECT v.data, r.version"):
+		cols = []string{"data", "version"}
+		if r, ok := f.resources[key()]; ok {
+			k := key()
+			k.version = r.version
+			rows = append(rows, []driver.Value{f.versions[k].data, r.version})
+		}
+	case strings.HasPrefix(query, "SELECT data FROM courier_versions"):
+		cols = []string{"data"}
+		k := key()
+		k.version = arg(2).(int64)
+		if v, ok := f.versions[k]; ok {
+			rows = append(rows, []driver.Value{v.data})
+		}
+	case strings.HasPrefix(query, "SELECT version, created FROM courier_versions"):
+		cols = []string{"version", "created"}
+		for k, v := range f.versions {
+			if k.kind == arg(0) && k.name == arg(1) {
+				rows = append(rows, []driver.Value{k.version, v.created})
+			}
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i][0].(int64) > rows[j][0].(int64) })
+	case strings.HasPrefix(query, "INSERT INTO courier_resources"):
+		cols = []string{"version"}
+		r, ok := f.resources[key()]
+		switch {
+		case !ok:
+			r = &fakeResource{version: 1, created: arg(2).(time.Time), updated: arg(2).(time.Time)}
+			f.resources[key()] = r
+		case strings.Contains(query, "DO NOTHING"):
+			return cols, nil, 0, nil
+		default:
+			r.version++
+			r.updated = arg(2).(time.Time)
+		}
+		rows = append(rows, []driver.Value{r.version})
+	case strings.HasPrefix(query, "UPDATE courier_resources"):
+		cols = []string{"version"}
+		if r, ok := f.resources[key()]; ok && r.version == arg(2).(int64) {
+			r.version++
+			r.updated = arg(3).(time.Time)
+			rows = append(rows, []driver.Value{r.version})
+		}
+	case strings.HasPrefix(query, "INSERT INTO courier_versions"):
+		k := key()
+		k.version = arg(2).(int64)
+		f.versions[k] = &fakeVersion{data: arg(3).([]byte), created: arg(4).(time.Time)}
+		affected = 1
+	case strings.HasPrefix(query, "DELETE FROM courier_versions v USING"):
+		if r, ok := f.resources[key()]; ok {
+			affected = f.prune(key(), r.version-arg(2).(int64))
+		}
+	case strings.HasPrefix(query, "DELETE FROM courier_versions"):
+		affected = f.prune(key(), arg(2).(int64))
+	case strings.HasPrefix(query, "DELETE FROM courier_resources"):
+		if _, ok := f.resources[key()]; ok {
+			delete(f.resources, key())
+			f.prune(key(), 1<<62)
+			affected = 1
+		}
+	case strings.HasPrefix(query, "SELECT name FROM courier_resources"):
+		cols = []string{"name"}
+		for k := range f.resources {
+			if k.kind == arg(0).(string) {
+				rows = append(rows, []driver.Value{k.name})
+			}
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i][0].(string) < rows[j][0].(string) })
+	case strings.HasPrefix(query, "SELECT updated FROM courier_resources"):
+		cols = []string{"updated"}
+		if r, ok := f.resources[key()]; ok {
+			rows = append(rows, []driver.Value{r.updated})
+		}
+	case strings.HasPrefix(query, "SELECT kind, COUNT(*)"):
+		cols = []string{"kind", "count"}
+		counts := make(map[string]int64)
+		for k := range f.resources {
+			counts[k.kind]++
+		}
+		for kind, n := range counts {
+			rows = append(rows, []driver.Value{kind, n})
+		}
+	default:
+		return nil, nil, 0, fmt.Errorf("unhandled query %q", query)
+	}
+	return cols, rows, affected, nil
+}
+
+// Removes the versions of the resource up to and including the version.
+func (f *fakeDB) prune(key fakeKey, version int64) (n int64) {
+	for k := range f.versions {
+		if k.kind == key.kind && k.name == key.name && k.version <= version {
+			delete(f.versions, k)
+			n++
+		}
+	}
+	return n
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+var (
+	_ driver.ExecerContext  = &fakeConn{}
+	_ driver.QueryerContext = &fakeConn{}
+	_ driver.ConnBeginTx    = &fakeConn{}
+	_ driver.Pinger         = &fakeConn{}
+)
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.db.Lock()
+	defer c.db.Unlock()
+	c.db.snapshot = c.db.clone()
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.Lock()
+	defer c.db.Unlock()
+	c.db.snapshot = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.Lock()
+	defer c.db.Unlock()
+	if snapshot := c.db.snapshot; snapshot != nil {
+		c.db.migrations, c.db.schema = snapshot.migrations, snapshot.schema
+		c.db.resources, c.db.versions = snapshot.resources, snapshot.versions
+		c.db.snapshot = nil
+	}
+	return nil
+}
+
+func (c *fakeConn) Ping(context.Context) (err error) {
+	_, _, _, err = c.db.query("SELECT pg_advisory_xact_lock", nil)
+	return err
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, _, affected, err := c.db.query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	cols, rows, _, err := c.db.query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{cols: cols, rows: rows}, nil
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+// RotatePassword replaces the pkcs12 password stored with the id. If the certificate
+// is stored in its encrypted pkcs12 form it is decrypted with the current password and
+// re-encrypted with the new password; certificates that are stored decrypted do not
+// depend on the password and are not modified. The certificate and password are
+// written in a single batch so that the stored certificate can always be decrypted
+// with the stored password. Returns true if the certificate was re-encrypted.
+func RotatePassword(ctx context.Context, db Store, id string, password []byte) (reencrypted bool, err error) {
+	var current []byte
+	if current, err = db.GetPassword(ctx, id); err != nil {
+		return false, err
+	}
+
+	var cert []byte
+	if cert, err = db.GetCertificate(ctx, id); err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+
+	batch := &Batch{}
+	if len(cert) > 0 {
+		var provider *trust.Provider
+		if provider, err = trust.Decrypt(cert, string(current)); err != nil {
+			// The certificate is not encrypted if it can be parsed as a PEM chain
+			if !isDecrypted(cert) {
+				return false, ErrCannotReencrypt
+			}
+		} else {
+			var data []byte
+			if data, err = provider.Encrypt(string(password)); err != nil {
+				return false, err
+			}
+
+			batch.UpdateCertificate(id, data)
+			reencrypted = true
+		}
+	}
+
+	batch.UpdatePassword(id, password)
+	if err = db.WriteBatch(ctx, batch); err != nil {
+		return false, err
+	}
+	return reencrypted, nil
+}
+
+// Returns true if the certificate data is a PEM encoded certificate chain.
+func isDecrypted(cert []byte) bool {
+	provider, err := trust.New(cert)
+	if err != nil {
+		return false
+	}
+
+	_, err = provider.GetLeafCertificate()
+	return err == nil
+}
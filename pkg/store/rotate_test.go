@@ -0,0 +1,96 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/mock"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+func TestRotatePassword(t *testing.T) {
+	ctx := context.Background()
+
+	// Load the cert fixture
+	sz, err := trust.NewSerializer(true, "supersecretsquirrel")
+	require.NoError(t, err, "could not create serializer")
+	provider, err := sz.ReadFile("../testdata/cert.zip")
+	require.NoError(t, err, "could not read cert fixture")
+	decrypted, err := provider.Encode()
+	require.NoError(t, err, "could not encode cert fixture")
+	encrypted, err := provider.Encrypt("supersecretsquirrel")
+	require.NoError(t, err, "could not encrypt cert fixture")
+
+	// Returns a batch writer that records the batch writes by resource prefix
+	writeBatch := func(writes map[string][]byte) func(context.Context, *store.Batch) error {
+		return func(_ context.Context, batch *store.Batch) error {
+			for _, op := range batch.Ops() {
+				writes[op.Prefix] = op.Data
+			}
+			return nil
+		}
+	}
+
+	t.Run("Encrypted", func(t *testing.T) {
+		db := mock.New()
+		writes := make(map[string][]byte)
+		db.OnGetPassword = func(context.Context, string) ([]byte, error) { return []byte("supersecretsquirrel"), nil }
+		db.OnGetCertificate = func(context.Context, string) ([]byte, error) { return encrypted, nil }
+		db.OnWriteBatch = writeBatch(writes)
+
+		reencrypted, err := store.RotatePassword(ctx, db, "certID", []byte("newpassword"))
+		require.NoError(t, err, "could not rotate password")
+		require.True(t, reencrypted, "expected certificate to be re-encrypted")
+		require.Equal(t, []byte("newpassword"), writes[store.PasswordPrefix])
+
+		_, err = trust.Decrypt(writes[store.CertificatePrefix], "newpassword")
+		require.NoError(t, err, "certificate should be encrypted with the new password")
+	})
+
+	t.Run("Decrypted", func(t *testing.T) {
+		db := mock.New()
+		writes := make(map[string][]byte)
+		db.OnGetPassword = func(context.Context, string) ([]byte, error) { return []byte("supersecretsquirrel"), nil }
+		db.OnGetCertificate = func(context.Context, string) ([]byte, error) { return decrypted, nil }
+		db.OnWriteBatch = writeBatch(writes)
+
+		reencrypted, err := store.RotatePassword(ctx, db, "certID", []byte("newpassword"))
+		require.NoError(t, err, "could not rotate password")
+		require.False(t, reencrypted, "decrypted certificates should not be modified")
+		require.NotContains(t, writes, store.CertificatePrefix, "decrypted certificates should not be written")
+	})
+
+	t.Run("NoCertificate", func(t *testing.T) {
+		db := mock.New()
+		writes := make(map[string][]byte)
+		db.OnGetPassword = func(context.Context, string) ([]byte, error) { return []byte("supersecretsquirrel"), nil }
+		db.OnGetCertificate = func(context.Context, string) ([]byte, error) { return nil, store.ErrNotFound }
+		db.OnWriteBatch = writeBatch(writes)
+
+		reencrypted, err := store.RotatePassword(ctx, db, "certID", []byte("newpassword"))
+		require.NoError(t, err, "could not rotate password")
+		require.False(t, reencrypted)
+		require.Equal(t, []byte("newpassword"), writes[store.PasswordPrefix])
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		db := mock.New()
+		db.OnGetPassword = func(context.Context, string) ([]byte, error) { return []byte("wrong"), nil }
+		db.OnGetCertificate = func(context.Context, string) ([]byte, error) { return encrypted, nil }
+
+		_, err := store.RotatePassword(ctx, db, "certID", []byte("newpassword"))
+		require.ErrorIs(t, err, store.ErrCannotReencrypt)
+	})
+
+	t.Run("BatchFailed", func(t *testing.T) {
+		db := mock.New()
+		db.OnGetPassword = func(context.Context, string) ([]byte, error) { return []byte("supersecretsquirrel"), nil }
+		db.OnGetCertificate = func(context.Context, string) ([]byte, error) { return encrypted, nil }
+		db.OnWriteBatch = func(context.Context, *store.Batch) error { return store.ErrUnavailable }
+
+		_, err := store.RotatePassword(ctx, db, "certID", []byte("newpassword"))
+		require.ErrorIs(t, err, store.ErrUnavailable)
+	})
+}
@@ -0,0 +1,313 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// S3 error codes that are handled by the store.
+const (
+	codeNoSuchKey           = "NoSuchKey"
+	codeNoSuchVersion       = "NoSuchVersion"
+	codeNotFound            = "NotFound"
+	codeEntityTooLarge      = "EntityTooLarge"
+	codePreconditionFailed  = "PreconditionFailed"
+	codeConditionalConflict = "ConditionalRequestConflict"
+	codeInvalidArgument     = "InvalidArgument"
+)
+
+// APIError describes a failed S3 API request. It is wrapped by the typed store errors
+// so that the error code and request id can be inspected when debugging.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("s3 returned %d %s: %s (request id %s)", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// Object is a stored object or a version of an object.
+type object struct {
+	Data      []byte
+	ETag      string
+	VersionID string
+}
+
+// ObjectVersion is a version or delete marker returned when listing object versions.
+type objectVersion struct {
+	Key          string
+	VersionID    string
+	LastModified time.Time
+}
+
+// Client makes requests to the objects of a single bucket with the s3 api client and
+// applies the encryption, retention, and deadline configured for the store.
+type client struct {
+	api       *s3.Client
+	presigner *s3.PresignClient
+	bucket    string
+	timeout   time.Duration
+	kmsKeyID  string
+	lockMode  string
+	retention time.Duration
+	now       func() time.Time
+}
+
+// Returns the object and its etag and version id. If the version id is empty the
+// latest version is returned.
+func (c *client) get(ctx context.Context, key, versionID string) (_ *object, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	in := &s3.GetObjectInput{Bucket: aws.String(c.bucket), Key: aws.String(key)}
+	if versionID != "" {
+		in.VersionId = aws.String(versionID)
+	}
+
+	var out *s3.GetObjectOutput
+	if out, err = c.api.GetObject(ctx, in); err != nil {
+		return nil, storeError(err, versionID != "")
+	}
+	defer out.Body.Close()
+
+	obj := &object{ETag: aws.ToString(out.ETag), VersionID: aws.ToString(out.VersionId)}
+	if obj.Data, err = io.ReadAll(out.Body); err != nil {
+		return nil, fmt.Errorf("%w: %v", store.ErrUnavailable, err)
+	}
+	return obj, nil
+}
+
+// Checks that the object exists and returns when it was last modified.
+func (c *client) head(ctx context.Context, key string) (modified time.Time, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	var out *s3.HeadObjectOutput
+	if out, err = c.api.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(c.bucket), Key: aws.String(key)}); err != nil {
+		return time.Time{}, storeError(err, false)
+	}
+
+	if out.LastModified == nil {
+		return time.Time{}, fmt.Errorf("s3 did not return the last modified time of %s", key)
+	}
+	return *out.LastModified, nil
+}
+
+// Writes the object with the configured encryption and retention and returns its new
+// etag. If ifMatch is set the write is only applied if the etag of the stored object
+// matches; if ifNoneMatch is true the write is only applied if no object is stored.
+func (c *client) put(ctx context.Context, key string, data []byte, ifMatch string, ifNoneMatch bool) (_ string, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	// Object lock requires the integrity of the payload to be checked by s3
+	sum := md5.Sum(data)
+	in := &s3.PutObjectInput{
+		Bucket:      aws.String(c.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/octet-stream"),
+		ContentMD5:  aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+	}
+
+	if c.kmsKeyID != "" {
+		in.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		in.SSEKMSKeyId = aws.String(c.kmsKeyID)
+	}
+
+	if c.lockMode != "" {
+		in.ObjectLockMode = types.ObjectLockMode(c.lockMode)
+		in.ObjectLockRetainUntilDate = aws.Time(c.now().Add(c.retention).UTC())
+	}
+
+	switch {
+	case ifMatch != "":
+		in.IfMatch = aws.String(ifMatch)
+	case ifNoneMatch:
+		in.IfNoneMatch = aws.String("*")
+	}
+
+	var out *s3.PutObjectOutput
+	if out, err = c.api.PutObject(ctx, in); err != nil {
+		return "", storeError(err, false)
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// Deletes the object, which adds a delete marker in versioned buckets, or permanently
+// deletes a version of the object if the version id is set.
+func (c *client) delete(ctx context.Context, key, versionID string) (err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	in := &s3.DeleteObjectInput{Bucket: aws.String(c.bucket), Key: aws.String(key)}
+	if versionID != "" {
+		in.VersionId = aws.String(versionID)
+	}
+
+	if _, err = c.api.DeleteObject(ctx, in); err != nil {
+		return storeError(err, versionID != "")
+	}
+	return nil
+}
+
+// Returns the versions of the object since it was last deleted, newest first.
+func (c *client) versions(ctx context.Context, key string) (versions []objectVersion, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	var deleted time.Time
+	in := &s3.ListObjectVersionsInput{Bucket: aws.String(c.bucket), Prefix: aws.String(key)}
+	for {
+		var out *s3.ListObjectVersionsOutput
+		if out, err = c.api.ListObjectVersions(ctx, in); err != nil {
+			return nil, storeError(err, false)
+		}
+
+		for _, version := range out.Versions {
+			if aws.ToString(version.Key) == key {
+				versions = append(versions, objectVersion{
+					Key:          key,
+					VersionID:    aws.ToString(version.VersionId),
+					LastModified: aws.ToTime(version.LastModified),
+				})
+			}
+		}
+
+		for _, marker := range out.DeleteMarkers {
+			if aws.ToString(marker.Key) == key && aws.ToTime(marker.LastModified).After(deleted) {
+				deleted = aws.ToTime(marker.LastModified)
+			}
+		}
+
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		in.KeyMarker = out.NextKeyMarker
+		in.VersionIdMarker = out.NextVersionIdMarker
+	}
+
+	// Versions from before the object was deleted are not part of its history
+	current := versions[:0]
+	for _, version := range versions {
+		if version.LastModified.After(deleted) {
+			current = append(current, version)
+		}
+	}
+	return current, nil
+}
+
+// Returns the keys of the objects with the prefix, up to the limit if it is positive.
+func (c *client) keys(ctx context.Context, prefix string, limit int) (keys []string, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	in := &s3.ListObjectsV2Input{Bucket: aws.String(c.bucket), Prefix: aws.String(prefix)}
+	if limit > 0 {
+		in.MaxKeys = aws.Int32(int32(limit))
+	}
+
+	for {
+		var out *s3.ListObjectsV2Output
+		if out, err = c.api.ListObjectsV2(ctx, in); err != nil {
+			return nil, storeError(err, false)
+		}
+
+		for _, content := range out.Contents {
+			keys = append(keys, aws.ToString(content.Key))
+		}
+
+		if !aws.ToBool(out.IsTruncated) || limit > 0 {
+			return keys, nil
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
+}
+
+// Returns a url that downloads the latest version of the object without credentials
+// until the ttl expires, sent with the content type.
+func (c *client) presign(ctx context.Context, key, contentType string, ttl time.Duration) (_ string, err error) {
+	in := &s3.GetObjectInput{
+		Bucket:              aws.String(c.bucket),
+		Key:                 aws.String(key),
+		ResponseContentType: aws.String(contentType),
+	}
+
+	var req *v4.PresignedHTTPRequest
+	if req, err = c.presigner.PresignGetObject(ctx, in, s3.WithPresignExpires(ttl)); err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// Returns the context of an api call, which is bounded by the client timeout if set.
+func (c *client) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(ctx, c.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Maps the errors of the s3 api client to the typed errors exported by the store.
+// Requests that did not receive a response are unavailable, and invalid version ids
+// are reported as not found when a specific version was requested.
+func storeError(err error, version bool) error {
+	var rep *awshttp.ResponseError
+	if !errors.As(err, &rep) {
+		return fmt.Errorf("%w: %v", store.ErrUnavailable, err)
+	}
+
+	apiErr := &APIError{StatusCode: rep.HTTPStatusCode(), RequestID: rep.ServiceRequestID()}
+
+	var generic smithy.APIError
+	if errors.As(err, &generic) {
+		apiErr.Code = generic.ErrorCode()
+		apiErr.Message = generic.ErrorMessage()
+	}
+
+	if apiErr.Code == "" {
+		apiErr.Code = strings.ReplaceAll(http.StatusText(apiErr.StatusCode), " ", "")
+	}
+	return apiError(apiErr, version)
+}
+
+// Maps S3 API errors to the typed errors exported by the store.
+func apiError(err *APIError, version bool) error {
+	switch {
+	case err.StatusCode == http.StatusNotFound && (err.Code == codeNoSuchKey || err.Code == codeNoSuchVersion || err.Code == codeNotFound):
+		return fmt.Errorf("%w: %w", store.ErrNotFound, err)
+	case err.StatusCode == http.StatusBadRequest && err.Code == codeInvalidArgument && version:
+		return fmt.Errorf("%w: %w", store.ErrNotFound, err)
+	case err.Code == codePreconditionFailed || err.Code == codeConditionalConflict:
+		return fmt.Errorf("%w: %w", store.ErrVersionMismatch, err)
+	case err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %w", store.ErrPermissionDenied, err)
+	case err.Code == codeEntityTooLarge || err.StatusCode == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %w", store.ErrPayloadTooLarge, err)
+	case err.StatusCode == http.StatusNotFound, err.StatusCode == http.StatusTooManyRequests, err.StatusCode >= 500:
+		// Missing buckets are reported as unavailable rather than as missing objects
+		return fmt.Errorf("%w: %w", store.ErrUnavailable, err)
+	}
+	return err
+}
+
+var ErrMissingCredentials = errors.New("s3 storage requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to be set")
@@ -0,0 +1,441 @@
+/*
+Package s3 implements a storage backend that stores certificates, passwords, and
+secrets as objects in an Amazon S3 bucket so that deliveries land in versioned object
+storage. Objects are encrypted with SSE-KMS if a key is configured and can be retained
+with S3 Object Lock so that delivered certificates cannot be removed before the
+retention period expires.
+
+Each resource is stored under the configured prefix at {prefix}{resource}/{id}, e.g.
+certificate/{id}. The bucket should have versioning enabled so that prior versions are
+available; the version id assigned by S3 identifies each version and the etag of the
+latest version is the concurrency token, which is enforced with conditional writes.
+Deleting a resource adds a delete marker so that its prior versions are kept until they
+are pruned or expired by a lifecycle rule.
+
+Requests are made with the aws sdk and signed with the credentials in the standard
+AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables.
+*/
+package s3
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// Open the s3 storage backend.
+func Open(conf config.S3Config, opts ...StoreOption) (s *Store, err error) {
+	s = &Store{
+		prefix: conf.Prefix,
+		client: &client{
+			bucket:    conf.Bucket,
+			timeout:   conf.Timeout,
+			kmsKeyID:  conf.KMSKeyID,
+			lockMode:  strings.ToUpper(conf.ObjectLockMode),
+			retention: conf.ObjectLockRetention,
+			now:       time.Now,
+		},
+		opts: s3.Options{Region: conf.Region},
+	}
+
+	if conf.Endpoint != "" {
+		s.opts.BaseEndpoint = aws.String(conf.Endpoint)
+		s.opts.UsePathStyle = true
+	}
+
+	for _, opt := range opts {
+		if err = opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.opts.Credentials == nil {
+		creds := aws.Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return nil, ErrMissingCredentials
+		}
+		s.opts.Credentials = credentials.StaticCredentialsProvider{Value: creds}
+	}
+
+	s.client.api = s3.New(s.opts)
+	s.client.presigner = s3.NewPresignClient(s.client.api)
+	return s, nil
+}
+
+// Store implements the store.Store interface using an S3 bucket.
+type Store struct {
+	client *client
+	prefix string
+	opts   s3.Options // configures the api client when the store is opened
+}
+
+var (
+	_ store.Store         = &Store{}
+	_ store.HealthChecker = &Store{}
+	_ store.PublicStore   = &Store{}
+)
+
+// Close the s3 storage backend.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Check that the objects in the bucket can be listed.
+func (s *Store) Check(ctx context.Context) (err error) {
+	_, err = s.client.keys(ctx, s.prefix, 1)
+	return err
+}
+
+// Count the resources stored in the bucket.
+func (s *Store) Count(ctx context.Context) (counts store.Counts, err error) {
+	for _, count := range []struct {
+		prefix string
+		n      *int
+	}{
+		{store.CertificatePrefix, &counts.Certificates},
+		{store.PasswordPrefix, &counts.Passwords},
+		{store.SecretPrefix, &counts.Secrets},
+	} {
+		var keys []string
+		if keys, err = s.client.keys(ctx, s.prefix+count.prefix+"/", 0); err != nil {
+			return counts, err
+		}
+		*count.n = len(keys)
+	}
+	return counts, nil
+}
+
+// List the ids of the resources of the type stored in the bucket.
+func (s *Store) List(ctx context.Context, prefix string) (ids []string, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	if keys, err = s.client.keys(ctx, s.key(prefix, ""), 0); err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		ids = append(ids, strings.TrimPrefix(key, s.key(prefix, "")))
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Exists checks if the object of the resource is in the bucket without downloading it.
+func (s *Store) Exists(ctx context.Context, prefix, name string) (_ bool, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return false, err
+	}
+	_, err = s.client.head(ctx, s.key(prefix, name))
+	return store.Found(err)
+}
+
+// Modified returns when the latest version of the object was written.
+func (s *Store) Modified(ctx context.Context, prefix, name string) (_ time.Time, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return time.Time{}, err
+	}
+	return s.client.head(ctx, s.key(prefix, name))
+}
+
+// Delete the resource like the delete method of its type.
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return store.DeleteResource(ctx, s, prefix, name)
+}
+
+// WriteBatch applies the writes in the batch to the bucket. S3 does not support
+// transactions, so if a write fails the writes that were already applied are
+// compensated: the prior payload of each resource is written again and resources that
+// were created by the batch are deleted. Compensating writes add new versions, and
+// concurrent readers may observe the intermediate state.
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) (err error) {
+	var undo store.Undo
+	for _, op := range batch.Ops() {
+		key := s.key(op.Prefix, op.Name)
+
+		// Read the current payload so that the write can be reverted
+		var prior *object
+		if prior, err = s.client.get(ctx, key, ""); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return undo.Rollback(err)
+		}
+		undo.Push(s.revert(ctx, key, prior))
+
+		if op.Delete {
+			err = s.delete(ctx, key)
+		} else {
+			_, err = s.client.put(ctx, key, op.Data, "", false)
+		}
+
+		if err != nil {
+			return undo.Rollback(err)
+		}
+	}
+	return nil
+}
+
+//===========================================================================
+// Password Methods
+//===========================================================================
+
+// GetPassword retrieves a password by id from the s3 storage backend.
+func (s *Store) GetPassword(ctx context.Context, id string) (password []byte, err error) {
+	password, _, err = s.get(ctx, store.PasswordPrefix, id, "")
+	return password, err
+}
+
+// GetPasswordVersion retrieves a password by id and S3 version id from the s3 storage
+// backend.
+func (s *Store) GetPasswordVersion(ctx context.Context, id, version string) (password []byte, err error) {
+	password, _, err = s.get(ctx, store.PasswordPrefix, id, version)
+	return password, err
+}
+
+// GetPasswordWithToken retrieves a password by id along with the etag of its object,
+// which is used as the concurrency token.
+func (s *Store) GetPasswordWithToken(ctx context.Context, id string) (password []byte, token string, err error) {
+	return s.get(ctx, store.PasswordPrefix, id, "")
+}
+
+// UpdatePassword writes a new version of a password by id to the s3 storage backend.
+func (s *Store) UpdatePassword(ctx context.Context, id string, password []byte) (err error) {
+	_, err = s.client.put(ctx, s.key(store.PasswordPrefix, id), password, "", false)
+	return err
+}
+
+// CompareAndUpdatePassword writes a new version of a password by id if its object has
+// not been modified since the token was issued and returns the new token.
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, id, token string, password []byte) (string, error) {
+	return s.update(ctx, store.PasswordPrefix, id, password, token)
+}
+
+// DeletePassword deletes a password by id from the s3 storage backend.
+func (s *Store) DeletePassword(ctx context.Context, id string) error {
+	return s.delete(ctx, s.key(store.PasswordPrefix, id))
+}
+
+// PrunePasswordVersions permanently deletes all but the keep most recent versions of
+// a password.
+func (s *Store) PrunePasswordVersions(ctx context.Context, id string, keep int) error {
+	return s.prune(ctx, s.key(store.PasswordPrefix, id), keep)
+}
+
+//===========================================================================
+// Certificate Methods
+//===========================================================================
+
+// GetCertificate retrieves a certificate by id from the s3 storage backend.
+func (s *Store) GetCertificate(ctx context.Context, id string) (cert []byte, err error) {
+	cert, _, err = s.get(ctx, store.CertificatePrefix, id, "")
+	return cert, err
+}
+
+// GetCertificateVersion retrieves a certificate by id and S3 version id from the s3
+// storage backend.
+func (s *Store) GetCertificateVersion(ctx context.Context, id, version string) (cert []byte, err error) {
+	cert, _, err = s.get(ctx, store.CertificatePrefix, id, version)
+	return cert, err
+}
+
+// GetCertificateWithToken retrieves a certificate by id along with the etag of its
+// object, which is used as the concurrency token.
+func (s *Store) GetCertificateWithToken(ctx context.Context, id string) (cert []byte, token string, err error) {
+	return s.get(ctx, store.CertificatePrefix, id, "")
+}
+
+// ListCertificateVersions returns the versions of a certificate by id since it was
+// last deleted, newest first.
+func (s *Store) ListCertificateVersions(ctx context.Context, id string) (_ []store.Version, err error) {
+	var versions []objectVersion
+	if versions, err = s.client.versions(ctx, s.key(store.CertificatePrefix, id)); err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, store.ErrNotFound
+	}
+
+	out := make([]store.Version, 0, len(versions))
+	for _, version := range versions {
+		out = append(out, store.Version{
+			Version: version.VersionID,
+			Created: version.LastModified,
+			State:   "enabled",
+		})
+	}
+	return out, nil
+}
+
+// UpdateCertificate writes a new version of a certificate by id to the s3 storage
+// backend.
+func (s *Store) UpdateCertificate(ctx context.Context, id string, cert []byte) (err error) {
+	_, err = s.client.put(ctx, s.key(store.CertificatePrefix, id), cert, "", false)
+	return err
+}
+
+// CompareAndUpdateCertificate writes a new version of a certificate by id if its
+// object has not been modified since the token was issued and returns the new token.
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, id, token string, cert []byte) (string, error) {
+	return s.update(ctx, store.CertificatePrefix, id, cert, token)
+}
+
+// DeleteCertificate deletes a certificate by id from the s3 storage backend.
+func (s *Store) DeleteCertificate(ctx context.Context, id string) error {
+	return s.delete(ctx, s.key(store.CertificatePrefix, id))
+}
+
+// PruneCertificateVersions permanently deletes all but the keep most recent versions
+// of a certificate.
+func (s *Store) PruneCertificateVersions(ctx context.Context, id string, keep int) error {
+	return s.prune(ctx, s.key(store.CertificatePrefix, id), keep)
+}
+
+//===========================================================================
+// Secret Methods
+//===========================================================================
+
+// GetSecret retrieves a secret by name from the s3 storage backend.
+func (s *Store) GetSecret(ctx context.Context, name string) (data []byte, err error) {
+	data, _, err = s.get(ctx, store.SecretPrefix, name, "")
+	return data, err
+}
+
+// UpdateSecret writes a new version of a secret by name to the s3 storage backend.
+func (s *Store) UpdateSecret(ctx context.Context, name string, data []byte) (err error) {
+	_, err = s.client.put(ctx, s.key(store.SecretPrefix, name), data, "", false)
+	return err
+}
+
+// DeleteSecret deletes a secret by name from the s3 storage backend.
+func (s *Store) DeleteSecret(ctx context.Context, name string) error {
+	return s.delete(ctx, s.key(store.SecretPrefix, name))
+}
+
+//===========================================================================
+// Object Methods
+//===========================================================================
+
+// Publish writes the artifact to {prefix}public/{name} and returns a presigned url
+// that downloads it with the content type until the ttl expires. S3 presigned urls are
+// valid for at most seven days and expire earlier if the credentials that signed them
+// are temporary and expire first.
+func (s *Store) Publish(ctx context.Context, name string, data []byte, contentType string, ttl time.Duration) (_ string, err error) {
+	key := s.key(store.PublicPrefix, name)
+	if _, err = s.client.put(ctx, key, data, "", false); err != nil {
+		return "", err
+	}
+	return s.client.presign(ctx, key, contentType, ttl)
+}
+
+// PutObject writes the data to the object at the key relative to the bucket rather than
+// under the prefix of the stored resources, e.g. to keep a snapshot of the store.
+func (s *Store) PutObject(ctx context.Context, key string, data []byte) (err error) {
+	_, err = s.client.put(ctx, key, data, "", false)
+	return err
+}
+
+// GetObject returns the data of the latest version of the object at the key relative
+// to the bucket.
+func (s *Store) GetObject(ctx context.Context, key string) (_ []byte, err error) {
+	var obj *object
+	if obj, err = s.client.get(ctx, key, ""); err != nil {
+		return nil, err
+	}
+	return obj.Data, nil
+}
+
+//===========================================================================
+// Helper methods
+//===========================================================================
+
+// key returns the object key of the resource.
+func (s *Store) key(prefix, id string) string {
+	return s.prefix + prefix + "/" + id
+}
+
+// get returns the payload of the resource and the etag of its object. If the version
+// is empty or latest, the latest version is returned.
+func (s *Store) get(ctx context.Context, prefix, id, version string) (_ []byte, token string, err error) {
+	if version == store.LatestVersion {
+		version = ""
+	}
+
+	var obj *object
+	if obj, err = s.client.get(ctx, s.key(prefix, id), version); err != nil {
+		return nil, "", err
+	}
+	return obj.Data, obj.ETag, nil
+}
+
+// update writes the payload if the etag of the stored object matches the token, where
+// an empty token only matches a resource that does not exist.
+func (s *Store) update(ctx context.Context, prefix, id string, payload []byte, token string) (etag string, err error) {
+	if etag, err = s.client.put(ctx, s.key(prefix, id), payload, token, token == ""); errors.Is(err, store.ErrNotFound) {
+		return "", store.ErrVersionMismatch
+	}
+	return etag, err
+}
+
+// delete adds a delete marker to the object, returning not found if the object does
+// not exist since S3 does not report whether a deleted object existed.
+func (s *Store) delete(ctx context.Context, key string) (err error) {
+	if _, err = s.client.head(ctx, key); err != nil {
+		return err
+	}
+	return s.client.delete(ctx, key, "")
+}
+
+// prune permanently deletes all but the keep most recent versions of the object.
+// Versions that are retained by object lock cannot be pruned until the retention
+// period expires.
+func (s *Store) prune(ctx context.Context, key string, keep int) (err error) {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+
+	var versions []objectVersion
+	if versions, err = s.client.versions(ctx, key); err != nil {
+		return err
+	}
+
+	for i := keep; i < len(versions); i++ {
+		if err = s.client.delete(ctx, key, versions[i].VersionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revert returns a function that restores the object to the prior payload, or deletes
+// it if it did not exist. The rollback is not cancelled with the request context since
+// abandoning it would leave the store inconsistent; each call is still bounded by the
+// client timeout.
+func (s *Store) revert(ctx context.Context, key string, prior *object) func() error {
+	ctx = context.WithoutCancel(ctx)
+	return func() (err error) {
+		if prior == nil {
+			if err = s.delete(ctx, key); errors.Is(err, store.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		_, err = s.client.put(ctx, key, prior.Data, "", false)
+		return err
+	}
+}
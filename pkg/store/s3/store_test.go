@@ -0,0 +1,522 @@
+package s3_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/s3"
+)
+
+type s3StoreTestSuite struct {
+	suite.Suite
+	api   *fakeS3
+	srv   *httptest.Server
+	store *s3.Store
+}
+
+func (s *s3StoreTestSuite) SetupSuite() {
+	var err error
+	s.api = newFakeS3("courier")
+	s.srv = httptest.NewServer(s.api)
+
+	conf := config.S3Config{
+		Enabled:             true,
+		Bucket:              "courier",
+		Prefix:              "deliveries/",
+		Region:              "us-east-1",
+		KMSKeyID:            "alias/courier",
+		ObjectLockMode:      "governance",
+		ObjectLockRetention: 24 * time.Hour,
+		Timeout:             5 * time.Second,
+	}
+
+	creds := s3.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+	s.store, err = s3.Open(conf, s3.WithEndpoint(s.srv.URL, s.srv.Client()), s3.WithCredentials(creds))
+	s.NoError(err, "could not open s3 storage backend")
+}
+
+func (s *s3StoreTestSuite) TearDownSuite() {
+	s.NoError(s.store.Close(), "could not close s3 storage backend")
+	s.srv.Close()
+}
+
+func (s *s3StoreTestSuite) SetupTest() {
+	s.api.reset()
+}
+
+func TestS3Store(t *testing.T) {
+	suite.Run(t, new(s3StoreTestSuite))
+}
+
+func TestOpenMissingCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	_, err := s3.Open(config.S3Config{Enabled: true, Bucket: "courier", Region: "us-east-1"})
+	if err != s3.ErrMissingCredentials {
+		t.Fatalf("expected missing credentials error, got %v", err)
+	}
+}
+
+func (s *s3StoreTestSuite) TestPasswordStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	_, err := s.store.GetPassword(ctx, "does-not-exist")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if password does not exist")
+
+	password := []byte("password")
+	require.NoError(s.store.UpdatePassword(ctx, "password-id", password), "should be able to create a password")
+
+	actual, err := s.store.GetPassword(ctx, "password-id")
+	require.NoError(err, "should be able to get a password")
+	require.Equal(password, actual, "wrong password returned")
+
+	// The password should be written under the prefix with encryption and retention
+	put := s.api.lastPut()
+	require.Equal("deliveries/pkcs12/password-id", put.key)
+	require.Equal("aws:kms", put.header.Get("X-Amz-Server-Side-Encryption"))
+	require.Equal("alias/courier", put.header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+	require.Equal("GOVERNANCE", put.header.Get("X-Amz-Object-Lock-Mode"))
+
+	retain, err := time.Parse(time.RFC3339, put.header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	require.NoError(err, "could not parse retain until date")
+	require.WithinDuration(time.Now().Add(24*time.Hour), retain, time.Minute)
+
+	require.NoError(s.store.UpdatePassword(ctx, "password-id", []byte("updated")), "should be able to update a password")
+	actual, err = s.store.GetPassword(ctx, "password-id")
+	require.NoError(err, "should be able to get a password")
+	require.Equal([]byte("updated"), actual, "password was not updated")
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err, "should be able to count the store")
+	require.Equal(1, counts.Passwords, "wrong number of passwords counted")
+
+	// Prior versions are kept by the bucket
+	actual, err = s.store.GetPasswordVersion(ctx, "password-id", s.api.versionID("deliveries/pkcs12/password-id", 0))
+	require.NoError(err, "should be able to get a prior version")
+	require.Equal(password, actual)
+
+	_, err = s.store.GetPasswordVersion(ctx, "password-id", "not-a-version")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if version does not exist")
+
+	require.ErrorIs(s.store.PrunePasswordVersions(ctx, "password-id", 0), store.ErrInvalidKeep)
+	require.NoError(s.store.PrunePasswordVersions(ctx, "password-id", 1))
+	_, err = s.store.GetPasswordVersion(ctx, "password-id", s.api.versionID("deliveries/pkcs12/password-id", 0))
+	require.ErrorIs(err, store.ErrNotFound, "pruned version should not exist")
+
+	require.NoError(s.store.DeletePassword(ctx, "password-id"), "should be able to delete a password")
+	_, err = s.store.GetPassword(ctx, "password-id")
+	require.ErrorIs(err, store.ErrNotFound, "password should not exist after delete")
+	require.ErrorIs(s.store.DeletePassword(ctx, "password-id"), store.ErrNotFound, "should return error if password does not exist")
+}
+
+func (s *s3StoreTestSuite) TestCertificateStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	_, err := s.store.ListCertificateVersions(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if certificate does not exist")
+
+	// An empty token only matches a certificate that does not exist
+	token, err := s.store.CompareAndUpdateCertificate(ctx, "cert-id", "", []byte("certificate"))
+	require.NoError(err, "should be able to create a certificate")
+	require.NotEmpty(token, "expected a token to be returned")
+
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", "", []byte("certificate"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "empty token should not match an existing certificate")
+
+	cert, current, err := s.store.GetCertificateWithToken(ctx, "cert-id")
+	require.NoError(err, "should be able to get a certificate with its token")
+	require.Equal([]byte("certificate"), cert)
+	require.Equal(token, current, "wrong token returned")
+
+	// The token should be invalidated by an update
+	require.NoError(s.store.UpdateCertificate(ctx, "cert-id", []byte("renewed")))
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", token, []byte("stale"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "stale token should not match")
+
+	_, current, err = s.store.GetCertificateWithToken(ctx, "cert-id")
+	require.NoError(err)
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", current, []byte("current"))
+	require.NoError(err, "current token should match")
+
+	cert, err = s.store.GetCertificateVersion(ctx, "cert-id", store.LatestVersion)
+	require.NoError(err, "should be able to get the latest version")
+	require.Equal([]byte("current"), cert)
+
+	versions, err := s.store.ListCertificateVersions(ctx, "cert-id")
+	require.NoError(err, "should be able to list certificate versions")
+	require.Len(versions, 3)
+	require.Equal(s.api.versionID("deliveries/certificate/cert-id", 2), versions[0].Version, "versions should be newest first")
+
+	// Versions from before the certificate was deleted are not listed
+	require.NoError(s.store.DeleteCertificate(ctx, "cert-id"))
+	_, err = s.store.ListCertificateVersions(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "deleted certificate should have no versions")
+
+	require.NoError(s.store.UpdateCertificate(ctx, "cert-id", []byte("reissued")))
+	versions, err = s.store.ListCertificateVersions(ctx, "cert-id")
+	require.NoError(err)
+	require.Len(versions, 1)
+}
+
+func (s *s3StoreTestSuite) TestSecretStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.UpdateSecret(ctx, "webhook", []byte("secret")))
+	data, err := s.store.GetSecret(ctx, "webhook")
+	require.NoError(err)
+	require.Equal([]byte("secret"), data)
+
+	modified, err := s.store.Modified(ctx, store.SecretPrefix, "webhook")
+	require.NoError(err)
+	require.Equal(s.api.clock, modified.UTC())
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err)
+	require.Equal(store.Counts{Secrets: 1}, counts)
+
+	require.NoError(s.store.DeleteSecret(ctx, "webhook"))
+	_, err = s.store.GetSecret(ctx, "webhook")
+	require.ErrorIs(err, store.ErrNotFound)
+}
+
+func (s *s3StoreTestSuite) TestWriteBatch() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.UpdatePassword(ctx, "cert-id", []byte("old password")))
+
+	// A failed write should roll back the writes that were already applied
+	s.api.fail("deliveries/certificate/cert-id", http.StatusForbidden)
+	batch := (&store.Batch{}).UpdatePassword("cert-id", []byte("new password")).UpdateCertificate("cert-id", []byte("certificate"))
+	err := s.store.WriteBatch(ctx, batch)
+	require.ErrorIs(err, store.ErrPermissionDenied, "expected the write error to be returned")
+
+	password, err := s.store.GetPassword(ctx, "cert-id")
+	require.NoError(err)
+	require.Equal([]byte("old password"), password, "password write was not rolled back")
+
+	s.api.fail("", 0)
+	require.NoError(s.store.WriteBatch(ctx, batch), "could not write batch")
+	password, err = s.store.GetPassword(ctx, "cert-id")
+	require.NoError(err)
+	require.Equal([]byte("new password"), password)
+	_, err = s.store.GetCertificate(ctx, "cert-id")
+	require.NoError(err, "certificate was not written")
+}
+
+func (s *s3StoreTestSuite) TestPublish() {
+	require := s.Require()
+	ctx := context.Background()
+
+	signed, err := s.store.Publish(ctx, "cert-id.pem", []byte("chain"), "application/x-pem-file", time.Hour)
+	require.NoError(err, "should be able to publish an artifact")
+	require.Equal("deliveries/public/cert-id.pem", s.api.lastPut().key)
+
+	u, err := url.Parse(signed)
+	require.NoError(err, "could not parse signed url")
+	require.Equal("/courier/deliveries/public/cert-id.pem", u.Path)
+	require.Equal("3600", u.Query().Get("X-Amz-Expires"))
+	require.Equal("host", u.Query().Get("X-Amz-SignedHeaders"))
+	require.NotEmpty(u.Query().Get("X-Amz-Signature"))
+
+	// The artifact can be downloaded without credentials
+	rep, err := http.Get(signed)
+	require.NoError(err, "could not download the artifact")
+	defer rep.Body.Close()
+	require.Equal(http.StatusOK, rep.StatusCode)
+	require.Equal("application/x-pem-file", rep.Header.Get("Content-Type"))
+
+	data, err := io.ReadAll(rep.Body)
+	require.NoError(err)
+	require.Equal([]byte("chain"), data)
+
+	// Published artifacts are not counted as stored resources
+	counts, err := s.store.Count(ctx)
+	require.NoError(err)
+	require.Equal(store.Counts{}, counts)
+}
+
+func (s *s3StoreTestSuite) TestErrors() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.Check(ctx), "health check should pass")
+
+	s.api.fail("", http.StatusServiceUnavailable)
+	require.ErrorIs(s.store.Check(ctx), store.ErrUnavailable, "expected unavailable error")
+
+	s.api.fail("", http.StatusForbidden)
+	_, err := s.store.GetCertificate(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrPermissionDenied, "expected permission denied error")
+
+	var apiErr *s3.APIError
+	require.ErrorAs(err, &apiErr, "expected the api error to be wrapped")
+	require.Equal(http.StatusForbidden, apiErr.StatusCode)
+	require.Equal("AccessDenied", apiErr.Code)
+
+	s.api.fail("", http.StatusRequestEntityTooLarge)
+	err = s.store.UpdateCertificate(ctx, "cert-id", []byte("certificate"))
+	require.ErrorIs(err, store.ErrPayloadTooLarge, "expected payload too large error")
+}
+
+// Implements the object and versioning endpoints of the s3 api for a single bucket
+// with versioning enabled, using path style requests.
+type fakeS3 struct {
+	sync.Mutex
+	bucket   string
+	objects  map[string][]*fakeVersion
+	written  map[string]int
+	puts     []fakePut
+	clock    time.Time
+	version  int
+	failKey  string
+	failCode int
+}
+
+type fakeVersion struct {
+	id       string
+	data     []byte
+	etag     string
+	modified time.Time
+	deleted  bool
+}
+
+type fakePut struct {
+	key    string
+	header http.Header
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	api := &fakeS3{bucket: bucket}
+	api.reset()
+	return api
+}
+
+func (f *fakeS3) reset() {
+	f.Lock()
+	defer f.Unlock()
+	f.objects = make(map[string][]*fakeVersion)
+	f.written = make(map[string]int)
+	f.puts = nil
+	f.clock = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.failKey, f.failCode = "", 0
+}
+
+// Fail requests for the key, or every request if key is empty, with the status code.
+// A zero code stops failing requests.
+func (f *fakeS3) fail(key string, code int) {
+	f.Lock()
+	defer f.Unlock()
+	f.failKey, f.failCode = key, code
+}
+
+func (f *fakeS3) lastPut() fakePut {
+	f.Lock()
+	defer f.Unlock()
+	return f.puts[len(f.puts)-1]
+}
+
+// Returns the id of the nth version written to the key, including pruned versions.
+func (f *fakeS3) versionID(key string, n int) string {
+	return fmt.Sprintf("%s-v%d", key, n)
+}
+
+// Returns the latest version of the key if it is not a delete marker.
+func (f *fakeS3) latest(key string) *fakeVersion {
+	versions := f.objects[key]
+	if len(versions) == 0 || versions[len(versions)-1].deleted {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	presigned := r.Method == http.MethodGet && strings.HasPrefix(r.URL.Query().Get("X-Amz-Credential"), "AKIDEXAMPLE/")
+	if !presigned && !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		f.error(w, http.StatusForbidden, "AccessDenied", "request is not signed")
+		return
+	}
+
+	// Requests to the bucket may omit the trailing slash
+	prefix := "/" + f.bucket
+	if r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/") {
+		f.error(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	query := r.URL.Query()
+
+	if f.failCode != 0 && (f.failKey == "" || f.failKey == key) {
+		code := strings.ReplaceAll(http.StatusText(f.failCode), " ", "")
+		if f.failCode == http.StatusForbidden {
+			code = "AccessDenied"
+		}
+		f.error(w, f.failCode, code, "injected failure")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && key == "" && query.Has("versions"):
+		f.listVersions(w, query.Get("prefix"))
+	case r.Method == http.MethodGet && key == "":
+		f.listObjects(w, query.Get("prefix"))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		version := f.latest(key)
+		if id := query.Get("versionId"); id != "" {
+			version = nil
+			for _, v := range f.objects[key] {
+				if v.id == id && !v.deleted {
+					version = v
+				}
+			}
+			if version == nil {
+				f.error(w, http.StatusBadRequest, "InvalidArgument", "invalid version id specified")
+				return
+			}
+		}
+
+		if version == nil {
+			f.error(w, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+			return
+		}
+		w.Header().Set("ETag", version.etag)
+		w.Header().Set("X-Amz-Version-Id", version.id)
+		w.Header().Set("Last-Modified", version.modified.UTC().Format(http.TimeFormat))
+		if contentType := query.Get("response-content-type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		if r.Method == http.MethodGet {
+			w.Write(version.data)
+		}
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		current := f.latest(key)
+		if match := r.Header.Get("If-Match"); match != "" && (current == nil || current.etag != match) {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed", "at least one of the preconditions did not hold")
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && current != nil {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed", "at least one of the preconditions did not hold")
+			return
+		}
+
+		version := f.write(key, data, false)
+		f.puts = append(f.puts, fakePut{key: key, header: r.Header.Clone()})
+		w.Header().Set("ETag", version.etag)
+		w.Header().Set("X-Amz-Version-Id", version.id)
+	case r.Method == http.MethodDelete:
+		if id := query.Get("versionId"); id != "" {
+			versions := f.objects[key][:0]
+			for _, v := range f.objects[key] {
+				if v.id != id {
+					versions = append(versions, v)
+				}
+			}
+			f.objects[key] = versions
+		} else {
+			f.write(key, nil, true)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	}
+}
+
+func (f *fakeS3) write(key string, data []byte, deleted bool) *fakeVersion {
+	sum := md5.Sum(data)
+	f.clock = f.clock.Add(time.Second)
+	f.version++
+
+	// Version ids are numbered per key so that tests can refer to them
+	version := &fakeVersion{
+		id:       f.versionID(key, f.written[key]),
+		data:     data,
+		etag:     fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), f.version),
+		modified: f.clock,
+		deleted:  deleted,
+	}
+	f.written[key]++
+	f.objects[key] = append(f.objects[key], version)
+	return version
+}
+
+func (f *fakeS3) listObjects(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key string `xml:"Key"`
+	}
+	result := struct {
+		XMLName  xml.Name  `xml:"ListBucketResult"`
+		Contents []content `xml:"Contents"`
+	}{}
+
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && f.latest(key) != nil {
+			result.Contents = append(result.Contents, content{Key: key})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) listVersions(w http.ResponseWriter, prefix string) {
+	type version struct {
+		Key          string    `xml:"Key"`
+		VersionID    string    `xml:"VersionId"`
+		IsLatest     bool      `xml:"IsLatest"`
+		LastModified time.Time `xml:"LastModified"`
+	}
+	result := struct {
+		XMLName       xml.Name  `xml:"ListVersionsResult"`
+		Versions      []version `xml:"Version"`
+		DeleteMarkers []version `xml:"DeleteMarker"`
+	}{}
+
+	for key, versions := range f.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		// Versions are listed newest first
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := version{Key: key, VersionID: versions[i].id, IsLatest: i == len(versions)-1, LastModified: versions[i].modified}
+			if versions[i].deleted {
+				result.DeleteMarkers = append(result.DeleteMarkers, v)
+			} else {
+				result.Versions = append(result.Versions, v)
+			}
+		}
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string   `xml:"Code"`
+		Message   string   `xml:"Message"`
+		RequestID string   `xml:"RequestId"`
+	}{Code: code, Message: message, RequestID: "fake-request-id"})
+}
@@ -0,0 +1,10 @@
+package snapshot
+
+import "errors"
+
+var (
+	ErrInvalidKey         = errors.New("snapshot key must be 32 bytes")
+	ErrNotSnapshot        = errors.New("data is not a courier snapshot")
+	ErrDecryption         = errors.New("could not decrypt snapshot: wrong key or modified snapshot")
+	ErrUnsupportedVersion = errors.New("unsupported snapshot version")
+)
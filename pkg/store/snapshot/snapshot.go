@@ -0,0 +1,239 @@
+/*
+Package snapshot serializes every stored resource into a single file that is encrypted
+with an operator supplied key so that a courier deployment can be backed up and later
+restored into any storage backend, including a different backend than the snapshot was
+taken from.
+
+A snapshot contains the latest version of every password, certificate, and secret with
+its SHA-256 digest. It is encoded as gzipped JSON and encrypted with AES-256-GCM; the
+encrypted file begins with a header that identifies the snapshot format. Snapshots are
+taken and restored with the migration engine so that every resource is verified by
+reading it back after it is copied.
+*/
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+	"github.com/trisacrypto/courier/pkg/store/migrate"
+)
+
+const (
+	// Encrypted snapshots begin with the header followed by the nonce and the
+	// AES-256-GCM ciphertext of the gzipped JSON snapshot.
+	header  = "courier-snapshot-v1:"
+	version = 1
+	keySize = 32
+)
+
+// Snapshot holds the latest version of every stored resource.
+type Snapshot struct {
+	Version   int        `json:"version"`
+	Created   time.Time  `json:"created"`
+	Resources []Resource `json:"resources"`
+}
+
+// Resource is a single stored password, certificate, or secret.
+type Resource struct {
+	Prefix   string `json:"resource"`
+	Name     string `json:"id"`
+	Data     []byte `json:"data"`
+	Checksum string `json:"checksum"`
+}
+
+// Take copies the latest version of every resource in the source store into a snapshot.
+// Resources that are deleted while the snapshot is taken are skipped; the snapshot is
+// not taken if any other resource cannot be read.
+func Take(ctx context.Context, src store.Store) (snap *Snapshot, err error) {
+	var db *memory.Store
+	if db, err = memory.Open(config.MemoryStorageConfig{Enabled: true, MaxVersions: 1}); err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var report *migrate.Report
+	if report, err = migrate.Migrate(ctx, src, db, migrate.Options{}); err != nil {
+		return nil, err
+	}
+
+	if report.Failed > 0 {
+		for _, result := range report.Results {
+			if result.Status == migrate.StatusFailed {
+				return nil, fmt.Errorf("could not read %s %s: %w", result.Prefix, result.Name, result.Err)
+			}
+		}
+	}
+
+	snap = &Snapshot{Version: version, Created: time.Now().UTC()}
+	for _, result := range report.Results {
+		if result.Status != migrate.StatusCopied {
+			continue
+		}
+
+		var data []byte
+		if data, err = store.GetResource(ctx, db, result.Prefix, result.Name); err != nil {
+			return nil, err
+		}
+
+		snap.Resources = append(snap.Resources, Resource{
+			Prefix:   result.Prefix,
+			Name:     result.Name,
+			Data:     data,
+			Checksum: result.Checksum,
+		})
+	}
+	return snap, nil
+}
+
+// Restore writes every resource in the snapshot to the destination store. Resources are
+// restored with the migration engine, so resources that already exist in the
+// destination with the same data are left unchanged and resources with different data
+// are reported as failed unless the options allow them to be overwritten.
+func Restore(ctx context.Context, dst store.Store, snap *Snapshot, opts migrate.Options) (report *migrate.Report, err error) {
+	var db *memory.Store
+	if db, err = memory.Open(config.MemoryStorageConfig{Enabled: true, MaxVersions: 1}); err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	for _, resource := range snap.Resources {
+		if err = store.PutResource(ctx, db, resource.Prefix, resource.Name, resource.Data); err != nil {
+			return nil, fmt.Errorf("could not load %s %s: %w", resource.Prefix, resource.Name, err)
+		}
+	}
+	return migrate.Migrate(ctx, db, dst, opts)
+}
+
+// Counts returns the number of each type of resource in the snapshot.
+func (s *Snapshot) Counts() (counts store.Counts) {
+	for _, resource := range s.Resources {
+		switch resource.Prefix {
+		case store.PasswordPrefix:
+			counts.Passwords++
+		case store.CertificatePrefix:
+			counts.Certificates++
+		case store.SecretPrefix:
+			counts.Secrets++
+		}
+	}
+	return counts
+}
+
+// Encrypt serializes the snapshot and encrypts it with the 32 byte key.
+func (s *Snapshot) Encrypt(key []byte) (_ []byte, err error) {
+	var aead cipher.AEAD
+	if aead, err = newAEAD(key); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err = json.NewEncoder(gz).Encode(s); err != nil {
+		return nil, err
+	}
+
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(header)+aead.NonceSize()+buf.Len()+aead.Overhead())
+	out = append(out, header...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, buf.Bytes(), []byte(header)), nil
+}
+
+// Decrypt decrypts the snapshot with the 32 byte key that it was encrypted with and
+// verifies the checksum of every resource in it.
+func Decrypt(data, key []byte) (snap *Snapshot, err error) {
+	var aead cipher.AEAD
+	if aead, err = newAEAD(key); err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, []byte(header)) {
+		return nil, ErrNotSnapshot
+	}
+	data = data[len(header):]
+
+	size := aead.NonceSize()
+	if len(data) < size {
+		return nil, fmt.Errorf("%w: truncated snapshot header", store.ErrCorrupted)
+	}
+
+	if data, err = aead.Open(nil, data[:size], data[size:], []byte(header)); err != nil {
+		return nil, ErrDecryption
+	}
+
+	var gz *gzip.Reader
+	if gz, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+	}
+	defer gz.Close()
+
+	snap = &Snapshot{}
+	if err = json.NewDecoder(gz).Decode(snap); err != nil {
+		return nil, fmt.Errorf("%w: %v", store.ErrCorrupted, err)
+	}
+
+	if snap.Version != version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, snap.Version)
+	}
+
+	for _, resource := range snap.Resources {
+		if err = store.CheckPrefix(resource.Prefix); err != nil {
+			return nil, fmt.Errorf("%w: %w", store.ErrCorrupted, err)
+		}
+
+		if err = store.VerifyChecksum(resource.Data, resource.Checksum); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", resource.Prefix, resource.Name, err)
+		}
+	}
+	return snap, nil
+}
+
+// Read reads and decrypts a snapshot, e.g. from a file or request body.
+func Read(r io.Reader, key []byte) (_ *Snapshot, err error) {
+	var data []byte
+	if data, err = io.ReadAll(r); err != nil {
+		return nil, err
+	}
+	return Decrypt(data, key)
+}
+
+// Bucket is implemented by the object storage backends so that encrypted snapshots can
+// be kept in their bucket. Objects are written at the key relative to the bucket rather
+// than under the prefix of the stored resources.
+type Bucket interface {
+	PutObject(ctx context.Context, key string, data []byte) error
+	GetObject(ctx context.Context, key string) ([]byte, error)
+	Close() error
+}
+
+func newAEAD(key []byte) (_ cipher.AEAD, err error) {
+	if len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		return nil, ErrInvalidKey
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,111 @@
+package snapshot_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/memory"
+	"github.com/trisacrypto/courier/pkg/store/migrate"
+	"github.com/trisacrypto/courier/pkg/store/snapshot"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+func openMemory(t *testing.T) *memory.Store {
+	db, err := memory.Open(config.MemoryStorageConfig{Enabled: true})
+	require.NoError(t, err, "could not open in-memory storage backend")
+	return db
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	src, dst := openMemory(t), openMemory(t)
+
+	require.NoError(t, src.UpdatePassword(ctx, "alice", []byte("password")))
+	require.NoError(t, src.UpdateCertificate(ctx, "alice", []byte("certificate")))
+	require.NoError(t, src.UpdateCertificate(ctx, "bob", []byte("certificate")))
+	require.NoError(t, src.UpdateSecret(ctx, "carol", []byte("secret")))
+
+	snap, err := snapshot.Take(ctx, src)
+	require.NoError(t, err, "could not take snapshot")
+	require.Equal(t, store.Counts{Passwords: 1, Certificates: 2, Secrets: 1}, snap.Counts())
+
+	data, err := snap.Encrypt(testKey)
+	require.NoError(t, err, "could not encrypt snapshot")
+	require.NotContains(t, string(data), "password", "expected the snapshot to be encrypted")
+
+	restored, err := snapshot.Decrypt(data, testKey)
+	require.NoError(t, err, "could not decrypt snapshot")
+	require.Equal(t, snap.Resources, restored.Resources)
+	require.True(t, snap.Created.Equal(restored.Created))
+
+	// A dry run does not write to the destination
+	report, err := snapshot.Restore(ctx, dst, restored, migrate.Options{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Planned)
+
+	counts, err := dst.Count(ctx)
+	require.NoError(t, err)
+	require.Equal(t, store.Counts{}, counts)
+
+	// Every resource is restored into the destination
+	report, err = snapshot.Restore(ctx, dst, restored, migrate.Options{})
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Copied)
+
+	password, err := dst.GetPassword(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, []byte("password"), password)
+
+	secret, err := dst.GetSecret(ctx, "carol")
+	require.NoError(t, err)
+	require.Equal(t, []byte("secret"), secret)
+
+	// Resources that were changed since the snapshot are not overwritten by default
+	require.NoError(t, dst.UpdateCertificate(ctx, "bob", []byte("changed")))
+	report, err = snapshot.Restore(ctx, dst, restored, migrate.Options{})
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Unchanged)
+	require.Equal(t, 1, report.Failed)
+
+	report, err = snapshot.Restore(ctx, dst, restored, migrate.Options{Overwrite: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Copied)
+
+	cert, err := dst.GetCertificate(ctx, "bob")
+	require.NoError(t, err)
+	require.Equal(t, []byte("certificate"), cert)
+}
+
+func TestDecrypt(t *testing.T) {
+	ctx := context.Background()
+	src := openMemory(t)
+	require.NoError(t, src.UpdatePassword(ctx, "alice", []byte("password")))
+
+	snap, err := snapshot.Take(ctx, src)
+	require.NoError(t, err)
+
+	data, err := snap.Encrypt(testKey)
+	require.NoError(t, err)
+
+	_, err = snap.Encrypt([]byte("short"))
+	require.ErrorIs(t, err, snapshot.ErrInvalidKey)
+
+	_, err = snapshot.Decrypt(data, bytes.Repeat([]byte{0x24}, 32))
+	require.ErrorIs(t, err, snapshot.ErrDecryption, "expected the wrong key to be rejected")
+
+	_, err = snapshot.Decrypt([]byte("not a snapshot"), testKey)
+	require.ErrorIs(t, err, snapshot.ErrNotSnapshot)
+
+	modified := bytes.Clone(data)
+	modified[len(modified)-1] ^= 0xff
+	_, err = snapshot.Decrypt(modified, testKey)
+	require.ErrorIs(t, err, snapshot.ErrDecryption, "expected a modified snapshot to be rejected")
+
+	_, err = snapshot.Decrypt(data[:25], testKey)
+	require.ErrorIs(t, err, store.ErrCorrupted, "expected a truncated snapshot to be rejected")
+}
@@ -0,0 +1,231 @@
+package ssm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+// SSM error types that are handled by the store.
+const (
+	typeParameterNotFound        = "ParameterNotFound"
+	typeParameterVersionNotFound = "ParameterVersionNotFound"
+	typeParameterAlreadyExists   = "ParameterAlreadyExists"
+	typeAccessDenied             = "AccessDeniedException"
+	typeUnrecognizedClient       = "UnrecognizedClientException"
+	typeThrottling               = "ThrottlingException"
+	typeTooManyUpdates           = "TooManyUpdates"
+)
+
+const (
+	// Maximum number of results returned by a single page of parameters or history.
+	maxResults        = 10
+	maxHistoryResults = 50
+)
+
+// APIError describes a failed SSM API request. It is wrapped by the typed store errors
+// so that the error type and request id can be inspected when debugging.
+type APIError struct {
+	StatusCode int
+	Type       string
+	Message    string
+	RequestID  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("ssm returned %d %s: %s (request id %s)", e.StatusCode, e.Type, e.Message, e.RequestID)
+}
+
+// Parameter is a stored parameter or a version of a parameter from its history.
+type parameter struct {
+	Name             string
+	Value            string
+	Version          int64
+	LastModifiedDate time.Time
+}
+
+// Client makes requests to the SSM API with the api client and applies the key, tier,
+// and deadline configured for the store.
+type client struct {
+	api      *ssm.Client
+	timeout  time.Duration
+	kmsKeyID string
+	tier     string
+}
+
+// Returns the decrypted parameter. The version is appended to the name as a selector
+// if it is not empty.
+func (c *client) get(ctx context.Context, name, version string) (_ *parameter, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	in := &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)}
+	if version != "" {
+		in.Name = aws.String(name + ":" + version)
+	}
+
+	var out *ssm.GetParameterOutput
+	if out, err = c.api.GetParameter(ctx, in); err != nil {
+		return nil, storeError(err)
+	}
+
+	if out.Parameter == nil {
+		return nil, fmt.Errorf("%w: ssm did not return parameter %s", store.ErrUnavailable, name)
+	}
+
+	return &parameter{
+		Name:             aws.ToString(out.Parameter.Name),
+		Value:            aws.ToString(out.Parameter.Value),
+		Version:          out.Parameter.Version,
+		LastModifiedDate: aws.ToTime(out.Parameter.LastModifiedDate).UTC(),
+	}, nil
+}
+
+// Writes the parameter as a secure string with the configured key and tier and
+// returns its new version. If overwrite is false the write is only applied if the
+// parameter does not exist.
+func (c *client) put(ctx context.Context, name, value string, overwrite bool) (_ int64, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	in := &ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String(value),
+		Type:      types.ParameterTypeSecureString,
+		Tier:      types.ParameterTier(c.tier),
+		Overwrite: aws.Bool(overwrite),
+	}
+
+	if c.kmsKeyID != "" {
+		in.KeyId = aws.String(c.kmsKeyID)
+	}
+
+	var out *ssm.PutParameterOutput
+	if out, err = c.api.PutParameter(ctx, in); err != nil {
+		return 0, storeError(err)
+	}
+	return out.Version, nil
+}
+
+// Deletes the parameter along with its history.
+func (c *client) delete(ctx context.Context, name string) (err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	if _, err = c.api.DeleteParameter(ctx, &ssm.DeleteParameterInput{Name: aws.String(name)}); err != nil {
+		return storeError(err)
+	}
+	return nil
+}
+
+// Returns the versions of the parameter without their values, oldest first.
+func (c *client) history(ctx context.Context, name string) (versions []parameter, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	in := &ssm.GetParameterHistoryInput{Name: aws.String(name), MaxResults: aws.Int32(maxHistoryResults)}
+	for {
+		var out *ssm.GetParameterHistoryOutput
+		if out, err = c.api.GetParameterHistory(ctx, in); err != nil {
+			return nil, storeError(err)
+		}
+
+		for _, version := range out.Parameters {
+			versions = append(versions, parameter{
+				Name:             aws.ToString(version.Name),
+				Version:          version.Version,
+				LastModifiedDate: aws.ToTime(version.LastModifiedDate).UTC(),
+			})
+		}
+
+		if aws.ToString(out.NextToken) == "" {
+			return versions, nil
+		}
+		in.NextToken = out.NextToken
+	}
+}
+
+// Returns the names of the parameters directly under the path, up to the limit if it
+// is positive.
+func (c *client) names(ctx context.Context, path string, limit int) (names []string, err error) {
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	in := &ssm.GetParametersByPathInput{Path: aws.String(path), MaxResults: aws.Int32(maxResults)}
+	if limit > 0 && limit < maxResults {
+		in.MaxResults = aws.Int32(int32(limit))
+	}
+
+	for {
+		var out *ssm.GetParametersByPathOutput
+		if out, err = c.api.GetParametersByPath(ctx, in); err != nil {
+			return nil, storeError(err)
+		}
+
+		for _, param := range out.Parameters {
+			names = append(names, aws.ToString(param.Name))
+		}
+
+		if aws.ToString(out.NextToken) == "" || (limit > 0 && len(names) >= limit) {
+			return names, nil
+		}
+		in.NextToken = out.NextToken
+	}
+}
+
+// Returns the context of an api call, which is bounded by the client timeout if set.
+func (c *client) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(ctx, c.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Maps the errors of the ssm api client to the typed errors exported by the store.
+// Requests that did not receive a response are unavailable.
+func storeError(err error) error {
+	var rep *awshttp.ResponseError
+	if !errors.As(err, &rep) {
+		return fmt.Errorf("%w: %v", store.ErrUnavailable, err)
+	}
+
+	apiErr := &APIError{StatusCode: rep.HTTPStatusCode(), RequestID: rep.ServiceRequestID()}
+
+	var generic smithy.APIError
+	if errors.As(err, &generic) {
+		apiErr.Type = generic.ErrorCode()
+		apiErr.Message = generic.ErrorMessage()
+	}
+
+	if apiErr.Type == "" {
+		apiErr.Type = strings.ReplaceAll(http.StatusText(apiErr.StatusCode), " ", "")
+	}
+	return apiError(apiErr)
+}
+
+// Maps SSM API errors to the typed errors exported by the store.
+func apiError(err *APIError) error {
+	switch {
+	case err.Type == typeParameterNotFound || err.Type == typeParameterVersionNotFound:
+		return fmt.Errorf("%w: %w", store.ErrNotFound, err)
+	case err.Type == typeParameterAlreadyExists:
+		return fmt.Errorf("%w: %w", store.ErrVersionMismatch, err)
+	case err.Type == typeAccessDenied || err.Type == typeUnrecognizedClient || err.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %w", store.ErrPermissionDenied, err)
+	case err.Type == typeThrottling || err.Type == typeTooManyUpdates || err.StatusCode >= 500:
+		return fmt.Errorf("%w: %w", store.ErrUnavailable, err)
+	}
+	return err
+}
+
+var ErrMissingCredentials = errors.New("ssm storage requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to be set")
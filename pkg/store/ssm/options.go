@@ -0,0 +1,34 @@
+package ssm
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// Credentials are the aws access keys used to sign requests. The session token is only
+// required for temporary credentials.
+type Credentials = aws.Credentials
+
+// StoreOption allows us to configure the store when it is created.
+type StoreOption func(s *Store) error
+
+// WithEndpoint sends requests to the endpoint with the http client rather than to aws,
+// e.g. for tests.
+func WithEndpoint(endpoint string, httpClient *http.Client) StoreOption {
+	return func(s *Store) error {
+		s.opts.BaseEndpoint = aws.String(endpoint)
+		s.opts.HTTPClient = httpClient
+		return nil
+	}
+}
+
+// WithCredentials signs requests with the credentials rather than the credentials in
+// the environment.
+func WithCredentials(creds Credentials) StoreOption {
+	return func(s *Store) error {
+		s.opts.Credentials = credentials.StaticCredentialsProvider{Value: creds}
+		return nil
+	}
+}
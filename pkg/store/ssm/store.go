@@ -0,0 +1,459 @@
+/*
+Package ssm implements a storage backend that stores certificates, passwords, and
+secrets as SecureString parameters in the AWS Systems Manager Parameter Store, which is
+a lower cost alternative to Secrets Manager for smaller deployments on AWS. Parameters
+are encrypted with the configured KMS key, or with the aws managed key for ssm if no key
+is configured.
+
+Each resource is stored in the parameter hierarchy under the configured path at
+{path}/{resource}/{id}, e.g. /courier/certificate/{id}, so that access can be granted
+with IAM policies on the path. Payloads are base64 encoded since parameter values are
+strings, and must fit in 4KB when encoded for standard parameters or 8KB for advanced
+parameters.
+
+Parameter Store keeps the last 100 versions of each parameter, which are numbered from
+one; the version number of the latest version is the concurrency token. Parameter Store
+only supports conditional writes of new parameters, so updates of existing parameters
+are only serialized within the store. Individual versions cannot be deleted, so pruning
+has no effect, and deleting a parameter removes its entire history.
+
+Requests are made with the aws sdk and signed with the credentials in the standard
+AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables.
+*/
+package ssm
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+const (
+	// Parameters are stored under the path if none is configured.
+	defaultPath = "/courier"
+
+	// Maximum size of the encoded values of standard and advanced parameters.
+	tierStandard    = "Standard"
+	maxStandardSize = 4 * 1024
+	maxAdvancedSize = 8 * 1024
+)
+
+// Open the ssm storage backend.
+func Open(conf config.SSMConfig, opts ...StoreOption) (s *Store, err error) {
+	s = &Store{
+		path: strings.TrimSuffix(conf.Path, "/"),
+		client: &client{
+			timeout:  conf.Timeout,
+			kmsKeyID: conf.KMSKeyID,
+			tier:     conf.Tier,
+		},
+		opts: ssm.Options{Region: conf.Region},
+	}
+
+	if s.path == "" {
+		s.path = defaultPath
+	}
+
+	s.maxSize = maxAdvancedSize
+	if conf.Tier == "" || strings.EqualFold(conf.Tier, tierStandard) {
+		s.maxSize = maxStandardSize
+	}
+
+	if conf.Endpoint != "" {
+		s.opts.BaseEndpoint = aws.String(conf.Endpoint)
+	}
+
+	for _, opt := range opts {
+		if err = opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.opts.Credentials == nil {
+		creds := aws.Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return nil, ErrMissingCredentials
+		}
+		s.opts.Credentials = credentials.StaticCredentialsProvider{Value: creds}
+	}
+
+	s.client.api = ssm.New(s.opts)
+	return s, nil
+}
+
+// Store implements the store.Store interface using the SSM Parameter Store.
+type Store struct {
+	sync.Mutex
+	client  *client
+	path    string
+	maxSize int
+	opts    ssm.Options // configures the api client when the store is opened
+}
+
+var (
+	_ store.Store         = &Store{}
+	_ store.HealthChecker = &Store{}
+)
+
+// Close the ssm storage backend.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Check that the parameters under the path can be listed.
+func (s *Store) Check(ctx context.Context) (err error) {
+	_, err = s.client.names(ctx, s.path, 1)
+	return err
+}
+
+// Count the resources stored under the path.
+func (s *Store) Count(ctx context.Context) (counts store.Counts, err error) {
+	for _, count := range []struct {
+		prefix string
+		n      *int
+	}{
+		{store.CertificatePrefix, &counts.Certificates},
+		{store.PasswordPrefix, &counts.Passwords},
+		{store.SecretPrefix, &counts.Secrets},
+	} {
+		var names []string
+		if names, err = s.client.names(ctx, s.path+"/"+count.prefix, 0); err != nil {
+			return counts, err
+		}
+		*count.n = len(names)
+	}
+	return counts, nil
+}
+
+// List the ids of the resources of the type stored in the parameter store.
+func (s *Store) List(ctx context.Context, prefix string) (ids []string, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if names, err = s.client.names(ctx, s.path+"/"+prefix, 0); err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		ids = append(ids, strings.TrimPrefix(name, s.name(prefix, "")))
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Exists checks if the parameter of the resource is in the parameter store.
+func (s *Store) Exists(ctx context.Context, prefix, name string) (_ bool, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return false, err
+	}
+
+	_, _, err = s.get(ctx, prefix, name, "")
+	return store.Found(err)
+}
+
+// Modified returns when the latest version of the parameter was written.
+func (s *Store) Modified(ctx context.Context, prefix, name string) (_ time.Time, err error) {
+	if err = store.CheckPrefix(prefix); err != nil {
+		return time.Time{}, err
+	}
+
+	var param *parameter
+	if param, err = s.client.get(ctx, s.name(prefix, name), ""); err != nil {
+		return time.Time{}, err
+	}
+	return param.LastModifiedDate, nil
+}
+
+// Delete the resource like the delete method of its type.
+func (s *Store) Delete(ctx context.Context, prefix, name string) error {
+	return store.DeleteResource(ctx, s, prefix, name)
+}
+
+// WriteBatch applies the writes in the batch to the parameter store. Parameter Store
+// does not support transactions, so if a write fails the writes that were already
+// applied are compensated: the prior payload of each resource is written again and
+// resources that were created by the batch are deleted. Compensating writes add new
+// versions, and concurrent readers may observe the intermediate state.
+func (s *Store) WriteBatch(ctx context.Context, batch *store.Batch) (err error) {
+	var undo store.Undo
+	for _, op := range batch.Ops() {
+		name := s.name(op.Prefix, op.Name)
+
+		// Read the current payload so that the write can be reverted
+		var prior *parameter
+		if prior, err = s.client.get(ctx, name, ""); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return undo.Rollback(err)
+		}
+		undo.Push(s.revert(ctx, name, prior))
+
+		if op.Delete {
+			err = s.client.delete(ctx, name)
+		} else {
+			_, err = s.put(ctx, name, op.Data, true)
+		}
+
+		if err != nil {
+			return undo.Rollback(err)
+		}
+	}
+	return nil
+}
+
+//===========================================================================
+// Password Methods
+//===========================================================================
+
+// GetPassword retrieves a password by id from the ssm storage backend.
+func (s *Store) GetPassword(ctx context.Context, id string) (password []byte, err error) {
+	password, _, err = s.get(ctx, store.PasswordPrefix, id, "")
+	return password, err
+}
+
+// GetPasswordVersion retrieves a password by id and parameter version from the ssm
+// storage backend.
+func (s *Store) GetPasswordVersion(ctx context.Context, id, version string) (password []byte, err error) {
+	password, _, err = s.get(ctx, store.PasswordPrefix, id, version)
+	return password, err
+}
+
+// GetPasswordWithToken retrieves a password by id along with the version of its
+// parameter, which is used as the concurrency token.
+func (s *Store) GetPasswordWithToken(ctx context.Context, id string) (password []byte, token string, err error) {
+	return s.get(ctx, store.PasswordPrefix, id, "")
+}
+
+// UpdatePassword writes a new version of a password by id to the ssm storage backend.
+func (s *Store) UpdatePassword(ctx context.Context, id string, password []byte) (err error) {
+	_, err = s.put(ctx, s.name(store.PasswordPrefix, id), password, true)
+	return err
+}
+
+// CompareAndUpdatePassword writes a new version of a password by id if its parameter
+// has not been modified since the token was issued and returns the new token.
+func (s *Store) CompareAndUpdatePassword(ctx context.Context, id, token string, password []byte) (string, error) {
+	return s.update(ctx, store.PasswordPrefix, id, password, token)
+}
+
+// DeletePassword deletes a password by id from the ssm storage backend.
+func (s *Store) DeletePassword(ctx context.Context, id string) error {
+	return s.client.delete(ctx, s.name(store.PasswordPrefix, id))
+}
+
+// PrunePasswordVersions has no effect since Parameter Store does not delete individual
+// versions; the oldest versions are removed once a parameter has 100 versions.
+func (s *Store) PrunePasswordVersions(ctx context.Context, id string, keep int) error {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+	return nil
+}
+
+//===========================================================================
+// Certificate Methods
+//===========================================================================
+
+// GetCertificate retrieves a certificate by id from the ssm storage backend.
+func (s *Store) GetCertificate(ctx context.Context, id string) (cert []byte, err error) {
+	cert, _, err = s.get(ctx, store.CertificatePrefix, id, "")
+	return cert, err
+}
+
+// GetCertificateVersion retrieves a certificate by id and parameter version from the
+// ssm storage backend.
+func (s *Store) GetCertificateVersion(ctx context.Context, id, version string) (cert []byte, err error) {
+	cert, _, err = s.get(ctx, store.CertificatePrefix, id, version)
+	return cert, err
+}
+
+// GetCertificateWithToken retrieves a certificate by id along with the version of its
+// parameter, which is used as the concurrency token.
+func (s *Store) GetCertificateWithToken(ctx context.Context, id string) (cert []byte, token string, err error) {
+	return s.get(ctx, store.CertificatePrefix, id, "")
+}
+
+// ListCertificateVersions returns the versions of a certificate by id that are kept by
+// Parameter Store, newest first.
+func (s *Store) ListCertificateVersions(ctx context.Context, id string) (_ []store.Version, err error) {
+	var history []parameter
+	if history, err = s.client.history(ctx, s.name(store.CertificatePrefix, id)); err != nil {
+		return nil, err
+	}
+
+	if len(history) == 0 {
+		return nil, store.ErrNotFound
+	}
+
+	out := make([]store.Version, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		out = append(out, store.Version{
+			Version: strconv.FormatInt(history[i].Version, 10),
+			Created: history[i].LastModifiedDate,
+			State:   "enabled",
+		})
+	}
+	return out, nil
+}
+
+// UpdateCertificate writes a new version of a certificate by id to the ssm storage
+// backend.
+func (s *Store) UpdateCertificate(ctx context.Context, id string, cert []byte) (err error) {
+	_, err = s.put(ctx, s.name(store.CertificatePrefix, id), cert, true)
+	return err
+}
+
+// CompareAndUpdateCertificate writes a new version of a certificate by id if its
+// parameter has not been modified since the token was issued and returns the new
+// token.
+func (s *Store) CompareAndUpdateCertificate(ctx context.Context, id, token string, cert []byte) (string, error) {
+	return s.update(ctx, store.CertificatePrefix, id, cert, token)
+}
+
+// DeleteCertificate deletes a certificate by id from the ssm storage backend.
+func (s *Store) DeleteCertificate(ctx context.Context, id string) error {
+	return s.client.delete(ctx, s.name(store.CertificatePrefix, id))
+}
+
+// PruneCertificateVersions has no effect since Parameter Store does not delete
+// individual versions; the oldest versions are removed once a parameter has 100
+// versions.
+func (s *Store) PruneCertificateVersions(ctx context.Context, id string, keep int) error {
+	if keep < 1 {
+		return store.ErrInvalidKeep
+	}
+	return nil
+}
+
+//===========================================================================
+// Secret Methods
+//===========================================================================
+
+// GetSecret retrieves a secret by name from the ssm storage backend.
+func (s *Store) GetSecret(ctx context.Context, name string) (data []byte, err error) {
+	data, _, err = s.get(ctx, store.SecretPrefix, name, "")
+	return data, err
+}
+
+// UpdateSecret writes a new version of a secret by name to the ssm storage backend.
+func (s *Store) UpdateSecret(ctx context.Context, name string, data []byte) (err error) {
+	_, err = s.put(ctx, s.name(store.SecretPrefix, name), data, true)
+	return err
+}
+
+// DeleteSecret deletes a secret by name from the ssm storage backend.
+func (s *Store) DeleteSecret(ctx context.Context, name string) error {
+	return s.client.delete(ctx, s.name(store.SecretPrefix, name))
+}
+
+//===========================================================================
+// Helper methods
+//===========================================================================
+
+// name returns the name of the parameter of the resource in the hierarchy.
+func (s *Store) name(prefix, id string) string {
+	return s.path + "/" + prefix + "/" + id
+}
+
+// get returns the decoded payload of the resource and the version of its parameter. If
+// the version is empty or latest, the latest version is returned.
+func (s *Store) get(ctx context.Context, prefix, id, version string) (_ []byte, token string, err error) {
+	if version == store.LatestVersion {
+		version = ""
+	}
+
+	// Labels are not used by the store so only version numbers are valid selectors
+	if version != "" {
+		if _, err = strconv.ParseInt(version, 10, 64); err != nil {
+			return nil, "", store.ErrNotFound
+		}
+	}
+
+	var param *parameter
+	if param, err = s.client.get(ctx, s.name(prefix, id), version); err != nil {
+		return nil, "", err
+	}
+
+	var payload []byte
+	if payload, err = base64.StdEncoding.DecodeString(param.Value); err != nil {
+		return nil, "", store.ErrCorrupted
+	}
+	return payload, strconv.FormatInt(param.Version, 10), nil
+}
+
+// put encodes the payload and writes it to the parameter, returning the new version.
+func (s *Store) put(ctx context.Context, name string, payload []byte, overwrite bool) (_ string, err error) {
+	value := base64.StdEncoding.EncodeToString(payload)
+	if len(value) > s.maxSize {
+		return "", store.ErrPayloadTooLarge
+	}
+
+	var version int64
+	if version, err = s.client.put(ctx, name, value, overwrite); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(version, 10), nil
+}
+
+// update writes the payload if the version of the stored parameter matches the token,
+// where an empty token only matches a resource that does not exist. Creating a
+// parameter is conditional in Parameter Store, but updates are only serialized with
+// the other updates made through this store.
+func (s *Store) update(ctx context.Context, prefix, id string, payload []byte, token string) (_ string, err error) {
+	name := s.name(prefix, id)
+	if token == "" {
+		return s.put(ctx, name, payload, false)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	var param *parameter
+	if param, err = s.client.get(ctx, name, ""); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return "", store.ErrVersionMismatch
+		}
+		return "", err
+	}
+
+	if strconv.FormatInt(param.Version, 10) != token {
+		return "", store.ErrVersionMismatch
+	}
+	return s.put(ctx, name, payload, true)
+}
+
+// revert returns a function that restores the parameter to the prior payload, or
+// deletes it if it did not exist. The rollback is not cancelled with the request
+// context since abandoning it would leave the store inconsistent; each call is still
+// bounded by the client timeout.
+func (s *Store) revert(ctx context.Context, name string, prior *parameter) func() error {
+	ctx = context.WithoutCancel(ctx)
+	return func() (err error) {
+		if prior == nil {
+			if err = s.client.delete(ctx, name); errors.Is(err, store.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		_, err = s.client.put(ctx, name, prior.Value, true)
+		return err
+	}
+}
@@ -0,0 +1,411 @@
+package ssm_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/courier/pkg/store/ssm"
+)
+
+type ssmStoreTestSuite struct {
+	suite.Suite
+	api   *fakeSSM
+	srv   *httptest.Server
+	store *ssm.Store
+}
+
+func (s *ssmStoreTestSuite) SetupSuite() {
+	var err error
+	s.api = newFakeSSM()
+	s.srv = httptest.NewServer(s.api)
+
+	conf := config.SSMConfig{
+		Enabled:  true,
+		Path:     "/trisa/courier/",
+		Region:   "us-east-1",
+		KMSKeyID: "alias/courier",
+		Tier:     "Standard",
+		Timeout:  5 * time.Second,
+	}
+
+	creds := ssm.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+	s.store, err = ssm.Open(conf, ssm.WithEndpoint(s.srv.URL, s.srv.Client()), ssm.WithCredentials(creds))
+	s.NoError(err, "could not open ssm storage backend")
+}
+
+func (s *ssmStoreTestSuite) TearDownSuite() {
+	s.NoError(s.store.Close(), "could not close ssm storage backend")
+	s.srv.Close()
+}
+
+func (s *ssmStoreTestSuite) SetupTest() {
+	s.api.reset()
+}
+
+func TestSSMStore(t *testing.T) {
+	suite.Run(t, new(ssmStoreTestSuite))
+}
+
+func TestOpenMissingCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	_, err := ssm.Open(config.SSMConfig{Enabled: true, Path: "/courier", Region: "us-east-1"})
+	if err != ssm.ErrMissingCredentials {
+		t.Fatalf("expected missing credentials error, got %v", err)
+	}
+}
+
+func (s *ssmStoreTestSuite) TestPasswordStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	_, err := s.store.GetPassword(ctx, "does-not-exist")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if password does not exist")
+
+	password := []byte("password")
+	require.NoError(s.store.UpdatePassword(ctx, "password-id", password), "should be able to create a password")
+
+	actual, err := s.store.GetPassword(ctx, "password-id")
+	require.NoError(err, "should be able to get a password")
+	require.Equal(password, actual, "wrong password returned")
+
+	// The password should be written in the hierarchy as an encrypted secure string
+	put := s.api.lastPut()
+	require.Equal("/trisa/courier/pkcs12/password-id", put.Name)
+	require.Equal("SecureString", put.Type)
+	require.Equal("alias/courier", put.KeyID)
+	require.Equal("Standard", put.Tier)
+
+	require.NoError(s.store.UpdatePassword(ctx, "password-id", []byte("updated")), "should be able to update a password")
+	actual, err = s.store.GetPassword(ctx, "password-id")
+	require.NoError(err, "should be able to get a password")
+	require.Equal([]byte("updated"), actual, "password was not updated")
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err, "should be able to count the store")
+	require.Equal(1, counts.Passwords, "wrong number of passwords counted")
+
+	// Prior versions are kept by the parameter store
+	actual, err = s.store.GetPasswordVersion(ctx, "password-id", "1")
+	require.NoError(err, "should be able to get a prior version")
+	require.Equal(password, actual)
+
+	_, err = s.store.GetPasswordVersion(ctx, "password-id", "3")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if version does not exist")
+
+	_, err = s.store.GetPasswordVersion(ctx, "password-id", "not-a-version")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if version is not a number")
+
+	// Versions cannot be pruned but keep is still validated
+	require.ErrorIs(s.store.PrunePasswordVersions(ctx, "password-id", 0), store.ErrInvalidKeep)
+	require.NoError(s.store.PrunePasswordVersions(ctx, "password-id", 1))
+
+	require.NoError(s.store.DeletePassword(ctx, "password-id"), "should be able to delete a password")
+	_, err = s.store.GetPassword(ctx, "password-id")
+	require.ErrorIs(err, store.ErrNotFound, "password should not exist after delete")
+	require.ErrorIs(s.store.DeletePassword(ctx, "password-id"), store.ErrNotFound, "should return error if password does not exist")
+}
+
+func (s *ssmStoreTestSuite) TestCertificateStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	_, err := s.store.ListCertificateVersions(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "should return error if certificate does not exist")
+
+	// An empty token only matches a certificate that does not exist
+	token, err := s.store.CompareAndUpdateCertificate(ctx, "cert-id", "", []byte("certificate"))
+	require.NoError(err, "should be able to create a certificate")
+	require.Equal("1", token, "expected the parameter version to be returned")
+
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", "", []byte("certificate"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "empty token should not match an existing certificate")
+
+	cert, current, err := s.store.GetCertificateWithToken(ctx, "cert-id")
+	require.NoError(err, "should be able to get a certificate with its token")
+	require.Equal([]byte("certificate"), cert)
+	require.Equal(token, current, "wrong token returned")
+
+	// The token should be invalidated by an update
+	require.NoError(s.store.UpdateCertificate(ctx, "cert-id", []byte("renewed")))
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", token, []byte("stale"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "stale token should not match")
+
+	_, current, err = s.store.GetCertificateWithToken(ctx, "cert-id")
+	require.NoError(err)
+	token, err = s.store.CompareAndUpdateCertificate(ctx, "cert-id", current, []byte("current"))
+	require.NoError(err, "current token should match")
+	require.Equal("3", token)
+
+	_, err = s.store.CompareAndUpdateCertificate(ctx, "missing-id", "1", []byte("certificate"))
+	require.ErrorIs(err, store.ErrVersionMismatch, "token should not match a certificate that does not exist")
+
+	cert, err = s.store.GetCertificateVersion(ctx, "cert-id", store.LatestVersion)
+	require.NoError(err, "should be able to get the latest version")
+	require.Equal([]byte("current"), cert)
+
+	versions, err := s.store.ListCertificateVersions(ctx, "cert-id")
+	require.NoError(err, "should be able to list certificate versions")
+	require.Len(versions, 3)
+	require.Equal("3", versions[0].Version, "versions should be newest first")
+	require.True(versions[0].Created.After(versions[2].Created), "expected the creation time to be parsed")
+
+	// Deleting a parameter removes its history
+	require.NoError(s.store.DeleteCertificate(ctx, "cert-id"))
+	_, err = s.store.ListCertificateVersions(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrNotFound, "deleted certificate should have no versions")
+
+	// Encoded values larger than the standard tier limit are rejected
+	err = s.store.UpdateCertificate(ctx, "cert-id", make([]byte, 4096))
+	require.ErrorIs(err, store.ErrPayloadTooLarge, "expected payload too large error")
+}
+
+func (s *ssmStoreTestSuite) TestSecretStore() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.UpdateSecret(ctx, "webhook", []byte("secret")))
+	data, err := s.store.GetSecret(ctx, "webhook")
+	require.NoError(err)
+	require.Equal([]byte("secret"), data)
+
+	counts, err := s.store.Count(ctx)
+	require.NoError(err)
+	require.Equal(store.Counts{Secrets: 1}, counts)
+
+	require.NoError(s.store.DeleteSecret(ctx, "webhook"))
+	_, err = s.store.GetSecret(ctx, "webhook")
+	require.ErrorIs(err, store.ErrNotFound)
+}
+
+func (s *ssmStoreTestSuite) TestWriteBatch() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.UpdatePassword(ctx, "cert-id", []byte("old password")))
+
+	// A failed write should roll back the writes that were already applied
+	s.api.fail("/trisa/courier/certificate/cert-id", http.StatusBadRequest, "AccessDeniedException")
+	batch := (&store.Batch{}).UpdatePassword("cert-id", []byte("new password")).UpdateCertificate("cert-id", []byte("certificate"))
+	err := s.store.WriteBatch(ctx, batch)
+	require.ErrorIs(err, store.ErrPermissionDenied, "expected the write error to be returned")
+
+	password, err := s.store.GetPassword(ctx, "cert-id")
+	require.NoError(err)
+	require.Equal([]byte("old password"), password, "password write was not rolled back")
+
+	s.api.fail("", 0, "")
+	require.NoError(s.store.WriteBatch(ctx, batch), "could not write batch")
+	password, err = s.store.GetPassword(ctx, "cert-id")
+	require.NoError(err)
+	require.Equal([]byte("new password"), password)
+	_, err = s.store.GetCertificate(ctx, "cert-id")
+	require.NoError(err, "certificate was not written")
+}
+
+func (s *ssmStoreTestSuite) TestErrors() {
+	require := s.Require()
+	ctx := context.Background()
+
+	require.NoError(s.store.Check(ctx), "health check should pass")
+
+	s.api.fail("", http.StatusServiceUnavailable, "InternalServerError")
+	require.ErrorIs(s.store.Check(ctx), store.ErrUnavailable, "expected unavailable error")
+
+	s.api.fail("", http.StatusBadRequest, "ThrottlingException")
+	_, err := s.store.GetCertificate(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrUnavailable, "expected throttled requests to be unavailable")
+
+	s.api.fail("", http.StatusBadRequest, "AccessDeniedException")
+	_, err = s.store.GetCertificate(ctx, "cert-id")
+	require.ErrorIs(err, store.ErrPermissionDenied, "expected permission denied error")
+
+	var apiErr *ssm.APIError
+	require.ErrorAs(err, &apiErr, "expected the api error to be wrapped")
+	require.Equal(http.StatusBadRequest, apiErr.StatusCode)
+	require.Equal("AccessDeniedException", apiErr.Type)
+	require.Equal("fake-request-id", apiErr.RequestID)
+}
+
+// Implements the parameter endpoints of the ssm api used by the store with the aws
+// json protocol.
+type fakeSSM struct {
+	sync.Mutex
+	params   map[string][]fakeParameter
+	puts     []fakePut
+	clock    time.Time
+	failName string
+	failCode int
+	failType string
+}
+
+type fakeParameter struct {
+	Name             string  `json:"Name"`
+	Value            string  `json:"Value,omitempty"`
+	Version          int64   `json:"Version"`
+	LastModifiedDate float64 `json:"LastModifiedDate"`
+}
+
+type fakePut struct {
+	Name      string `json:"Name"`
+	Value     string `json:"Value"`
+	Type      string `json:"Type"`
+	KeyID     string `json:"KeyId"`
+	Tier      string `json:"Tier"`
+	Overwrite bool   `json:"Overwrite"`
+}
+
+type fakeRequest struct {
+	fakePut
+	Path           string `json:"Path"`
+	WithDecryption bool   `json:"WithDecryption"`
+}
+
+func newFakeSSM() *fakeSSM {
+	api := &fakeSSM{}
+	api.reset()
+	return api
+}
+
+func (f *fakeSSM) reset() {
+	f.Lock()
+	defer f.Unlock()
+	f.params = make(map[string][]fakeParameter)
+	f.puts = nil
+	f.clock = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.failName, f.failCode, f.failType = "", 0, ""
+}
+
+// Fail requests for the parameter, or every request if name is empty, with the status
+// code and error type. A zero code stops failing requests.
+func (f *fakeSSM) fail(name string, code int, errType string) {
+	f.Lock()
+	defer f.Unlock()
+	f.failName, f.failCode, f.failType = name, code, errType
+}
+
+func (f *fakeSSM) lastPut() fakePut {
+	f.Lock()
+	defer f.Unlock()
+	return f.puts[len(f.puts)-1]
+}
+
+func (f *fakeSSM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/ssm/aws4_request") {
+		f.error(w, http.StatusBadRequest, "UnrecognizedClientException", "request is not signed")
+		return
+	}
+
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-amz-json-1.1" {
+		f.error(w, http.StatusBadRequest, "SerializationException", "unsupported request")
+		return
+	}
+
+	in := &fakeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+		f.error(w, http.StatusBadRequest, "SerializationException", err.Error())
+		return
+	}
+
+	if f.failCode != 0 && (f.failName == "" || f.failName == in.Name) {
+		f.error(w, f.failCode, f.failType, "injected failure")
+		return
+	}
+
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSSM.") {
+	case "GetParameter":
+		name, selector, _ := strings.Cut(in.Name, ":")
+		versions, ok := f.params[name]
+		if !ok {
+			f.error(w, http.StatusBadRequest, "ParameterNotFound", name)
+			return
+		}
+
+		param := versions[len(versions)-1]
+		if selector != "" {
+			n, _ := strconv.Atoi(selector)
+			if n < 1 || n > len(versions) {
+				f.error(w, http.StatusBadRequest, "ParameterVersionNotFound", in.Name)
+				return
+			}
+			param = versions[n-1]
+		}
+		f.reply(w, map[string]any{"Parameter": param})
+	case "PutParameter":
+		versions, ok := f.params[in.Name]
+		if ok && !in.Overwrite {
+			f.error(w, http.StatusBadRequest, "ParameterAlreadyExists", in.Name)
+			return
+		}
+
+		f.clock = f.clock.Add(time.Minute)
+		param := fakeParameter{Name: in.Name, Value: in.Value, Version: int64(len(versions) + 1), LastModifiedDate: float64(f.clock.Unix()) + 0.5}
+		f.params[in.Name] = append(versions, param)
+		f.puts = append(f.puts, in.fakePut)
+		f.reply(w, map[string]any{"Version": param.Version, "Tier": in.Tier})
+	case "DeleteParameter":
+		if _, ok := f.params[in.Name]; !ok {
+			f.error(w, http.StatusBadRequest, "ParameterNotFound", in.Name)
+			return
+		}
+		delete(f.params, in.Name)
+		f.reply(w, map[string]any{})
+	case "GetParameterHistory":
+		versions, ok := f.params[in.Name]
+		if !ok {
+			f.error(w, http.StatusBadRequest, "ParameterNotFound", in.Name)
+			return
+		}
+
+		history := make([]fakeParameter, 0, len(versions))
+		for _, version := range versions {
+			version.Value = ""
+			history = append(history, version)
+		}
+		f.reply(w, map[string]any{"Parameters": history})
+	case "GetParametersByPath":
+		names := make([]string, 0)
+		for name := range f.params {
+			if strings.HasPrefix(name, in.Path+"/") && !strings.Contains(strings.TrimPrefix(name, in.Path+"/"), "/") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		params := make([]fakeParameter, 0, len(names))
+		for _, name := range names {
+			params = append(params, fakeParameter{Name: name})
+		}
+		f.reply(w, map[string]any{"Parameters": params})
+	default:
+		f.error(w, http.StatusBadRequest, "InvalidAction", r.Header.Get("X-Amz-Target"))
+	}
+}
+
+func (f *fakeSSM) reply(w http.ResponseWriter, out any) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (f *fakeSSM) error(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.Header().Set("X-Amzn-Requestid", "fake-request-id")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"__type":"com.amazonaws.ssm#%s","message":%q}`, errType, message)
+}
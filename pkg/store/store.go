@@ -2,12 +2,18 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"time"
 )
 
 const (
 	PasswordPrefix    = "pkcs12"
 	CertificatePrefix = "certificate"
+	SecretPrefix      = "secret"
+	PublicPrefix      = "public"
+	LatestVersion     = "latest"
 )
 
 // Store is a generic interface for storing and retrieving data.
@@ -15,16 +21,251 @@ type Store interface {
 	io.Closer
 	PasswordStore
 	CertificateStore
+	SecretStore
+	ResourceStore
+	Count(ctx context.Context) (Counts, error)
+	WriteBatch(ctx context.Context, batch *Batch) error
+}
+
+// Counts reports the number of resources held by a store.
+type Counts struct {
+	Certificates int
+	Passwords    int
+	Secrets      int
 }
 
 // PasswordStore is a generic interface for storing and retrieving passwords.
+//
+// The WithToken and CompareAndUpdate methods implement optimistic concurrency: the
+// token returned with the data identifies the stored version and the update is only
+// applied if the resource has not been modified since the token was issued, otherwise
+// ErrVersionMismatch is returned. An empty token only matches a resource that does not
+// exist. Tokens are opaque and are only comparable within a single backend. Backends
+// without conditional writes, such as google secret manager, only serialize updates
+// made through the same store, so tokens are advisory when several processes write to
+// the same backend.
+//
+// The Prune methods remove all but the keep most recent versions of a resource and
+// return ErrInvalidKeep if keep is less than one, since the latest version is never
+// removed by pruning.
 type PasswordStore interface {
 	GetPassword(ctx context.Context, name string) ([]byte, error)
+	GetPasswordVersion(ctx context.Context, name, version string) ([]byte, error)
+	GetPasswordWithToken(ctx context.Context, name string) (password []byte, token string, err error)
 	UpdatePassword(ctx context.Context, name string, password []byte) error
+	CompareAndUpdatePassword(ctx context.Context, name, token string, password []byte) (string, error)
+	DeletePassword(ctx context.Context, name string) error
+	PrunePasswordVersions(ctx context.Context, name string, keep int) error
 }
 
-// CertificateStore is a generic interface for storing and retrieving certificates.
+// CertificateStore is a generic interface for storing and retrieving certificates. The
+// concurrency tokens have the same semantics as in the PasswordStore.
 type CertificateStore interface {
 	GetCertificate(ctx context.Context, name string) ([]byte, error)
+	GetCertificateVersion(ctx context.Context, name, version string) ([]byte, error)
+	GetCertificateWithToken(ctx context.Context, name string) (cert []byte, token string, err error)
+	ListCertificateVersions(ctx context.Context, name string) ([]Version, error)
 	UpdateCertificate(ctx context.Context, name string, cert []byte) error
+	CompareAndUpdateCertificate(ctx context.Context, name, token string, cert []byte) (string, error)
+	DeleteCertificate(ctx context.Context, name string) error
+	PruneCertificateVersions(ctx context.Context, name string, keep int) error
+}
+
+// SecretStore is a generic interface for storing and retrieving other secrets that
+// are delivered to courier, such as sealing keys or API credentials.
+type SecretStore interface {
+	GetSecret(ctx context.Context, name string) ([]byte, error)
+	UpdateSecret(ctx context.Context, name string, secret []byte) error
+	DeleteSecret(ctx context.Context, name string) error
+}
+
+// ResourceStore manages the stored resources of every type by the prefix of the type,
+// e.g. to enumerate, check, or delete resources without a method for each type. List
+// returns the ids of the resources of the type in sorted order, excluding prior
+// versions, Modified returns when the latest version of the resource was written, and
+// Delete removes the resource like the delete method of its type. Every method returns
+// ErrUnknownResource if the prefix is not the prefix of a resource type.
+type ResourceStore interface {
+	List(ctx context.Context, prefix string) ([]string, error)
+	Exists(ctx context.Context, prefix, name string) (bool, error)
+	Modified(ctx context.Context, prefix, name string) (time.Time, error)
+	Delete(ctx context.Context, prefix, name string) error
+}
+
+// Prefixes of every type of stored resource.
+var Prefixes = []string{PasswordPrefix, CertificatePrefix, SecretPrefix}
+
+// CheckPrefix returns ErrUnknownResource if the prefix is not the prefix of a type of
+// stored resource.
+func CheckPrefix(prefix string) error {
+	for _, known := range Prefixes {
+		if prefix == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownResource, prefix)
+}
+
+// GetResource returns the latest version of the resource with the get method of its
+// type, so that every type of resource can be read by its prefix.
+func GetResource(ctx context.Context, db interface {
+	PasswordStore
+	CertificateStore
+	SecretStore
+}, prefix, name string) ([]byte, error) {
+	switch prefix {
+	case PasswordPrefix:
+		return db.GetPassword(ctx, name)
+	case CertificatePrefix:
+		return db.GetCertificate(ctx, name)
+	case SecretPrefix:
+		return db.GetSecret(ctx, name)
+	default:
+		return nil, CheckPrefix(prefix)
+	}
+}
+
+// PutResource writes the resource with the update method of its type, so that every
+// type of resource can be written by its prefix.
+func PutResource(ctx context.Context, db interface {
+	PasswordStore
+	CertificateStore
+	SecretStore
+}, prefix, name string, data []byte) error {
+	switch prefix {
+	case PasswordPrefix:
+		return db.UpdatePassword(ctx, name, data)
+	case CertificatePrefix:
+		return db.UpdateCertificate(ctx, name, data)
+	case SecretPrefix:
+		return db.UpdateSecret(ctx, name, data)
+	default:
+		return CheckPrefix(prefix)
+	}
+}
+
+// DeleteResource deletes the resource with the delete method of its type, so that
+// stores whose types are deleted differently can implement ResourceStore.Delete.
+func DeleteResource(ctx context.Context, db interface {
+	PasswordStore
+	CertificateStore
+	SecretStore
+}, prefix, name string) error {
+	switch prefix {
+	case PasswordPrefix:
+		return db.DeletePassword(ctx, name)
+	case CertificatePrefix:
+		return db.DeleteCertificate(ctx, name)
+	case SecretPrefix:
+		return db.DeleteSecret(ctx, name)
+	default:
+		return CheckPrefix(prefix)
+	}
+}
+
+// Found converts the error of reading a resource into whether the resource exists, so
+// that stores can implement ResourceStore.Exists with a read.
+func Found(err error) (bool, error) {
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// HealthChecker is implemented by stores that can verify the connection to their
+// backend without reading or writing any data.
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// Kinds of changes reported by a ChangeNotifier.
+const (
+	ChangeCreated  = "created"
+	ChangeModified = "modified"
+	ChangeRemoved  = "removed"
+)
+
+// ChangeNotifier is implemented by stores that detect changes made to their backend
+// outside of courier, e.g. manual edits of local storage files, so that caches of the
+// stored resources can be refreshed.
+type ChangeNotifier interface {
+	OnChange(fn func(Change))
+}
+
+// Change describes a change to a file or object of a stored resource that was not
+// made by the store.
+type Change struct {
+	Prefix string
+	Name   string
+	File   string
+	Kind   string
+}
+
+// Notifier returns the ChangeNotifier of the store or of the store that it wraps.
+func Notifier(store Store) (ChangeNotifier, bool) {
+	return find[ChangeNotifier](store)
+}
+
+// PublicStore is implemented by object storage backends that can write a non-sensitive
+// artifact, e.g. a public certificate chain, under {prefix}public/{name} and sign a url
+// that allows anyone to download it directly from the backend until the ttl expires.
+// Published artifacts are not stored resources: they are written without the codec
+// pipeline and are not removed when the resource they were derived from is deleted.
+type PublicStore interface {
+	Publish(ctx context.Context, name string, data []byte, contentType string, ttl time.Duration) (url string, err error)
+}
+
+// Publisher returns the PublicStore of the store or of the store that it wraps.
+func Publisher(store Store) (PublicStore, bool) {
+	return find[PublicStore](store)
+}
+
+// find returns the store, or the first store that it wraps, that implements T.
+func find[T any](store Store) (_ T, ok bool) {
+	for {
+		var impl T
+		if impl, ok = store.(T); ok {
+			return impl, true
+		}
+
+		wrapper, ok := store.(interface{ Unwrap() Store })
+		if !ok {
+			return impl, false
+		}
+		store = wrapper.Unwrap()
+	}
+}
+
+// Version describes a single stored version of a resource.
+type Version struct {
+	Version string
+	Created time.Time
+	State   string
+}
+
+// MetadataStore is implemented by stores that record when each resource was first and
+// last written and how it is stored, e.g. in a metadata file alongside the resource.
+type MetadataStore interface {
+	Metadata(ctx context.Context, prefix, name string) (*Metadata, error)
+}
+
+// Metadata describes the latest version of a stored resource. The checksum and size
+// are of the data written to the backend, which is encoded by the codec pipeline if
+// one is configured. Created is zero if the resource was written before its metadata
+// was recorded.
+type Metadata struct {
+	Created   time.Time
+	Updated   time.Time
+	Checksum  string
+	Size      int64
+	Encrypted bool
+}
+
+// Describer returns the MetadataStore of the store or of the store that it wraps.
+func Describer(store Store) (MetadataStore, bool) {
+	return find[MetadataStore](store)
 }
@@ -0,0 +1,138 @@
+package courier
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/config"
+	"github.com/trisacrypto/courier/pkg/o11y"
+)
+
+// Reasons that certificate uploads are throttled, recorded by the throttle metric.
+const (
+	throttledRate        = "rate"
+	throttledConcurrency = "concurrency"
+)
+
+// Throttle limits the rate and concurrency of certificate uploads, which decrypt pkcs12
+// data and are far more expensive than the other endpoints, so that a flood of uploads
+// cannot starve status checks and password deliveries. Uploads that exceed the rate or
+// that cannot get a slot before the queue timeout are rejected with 429 Too Many
+// Requests and a Retry-After header.
+func (s *Server) Throttle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, wait := s.throttle.allow(time.Now()); !ok {
+			o11y.Throttled.WithLabelValues(throttledRate).Inc()
+			tooManyRequests(c, wait)
+			return
+		}
+
+		if !s.throttle.acquire(c.Request.Context()) {
+			o11y.Throttled.WithLabelValues(throttledConcurrency).Inc()
+			tooManyRequests(c, s.throttle.timeout)
+			return
+		}
+		defer s.throttle.release()
+
+		c.Next()
+	}
+}
+
+// Aborts the request with 429 Too Many Requests and the number of seconds to wait.
+func tooManyRequests(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, api.ErrorResponse("too many certificate uploads, retry later"))
+}
+
+// throttle limits certificate uploads with a token bucket that refills at the rate and
+// a semaphore of processing slots. Either limit is disabled if it is zero.
+type throttle struct {
+	sync.Mutex
+	slots   chan struct{}
+	timeout time.Duration
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+}
+
+func newThrottle(conf config.DecryptionConfig) *throttle {
+	t := &throttle{
+		timeout: conf.QueueTimeout,
+		rate:    conf.Rate,
+		burst:   float64(conf.Burst),
+		tokens:  float64(conf.Burst),
+	}
+
+	if conf.MaxConcurrent > 0 {
+		t.slots = make(chan struct{}, conf.MaxConcurrent)
+	}
+	return t
+}
+
+// Takes a token from the bucket at the time, otherwise returns how long to wait until
+// a token is available.
+func (t *throttle) allow(now time.Time) (bool, time.Duration) {
+	if t.rate <= 0 {
+		return true, 0
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.last.IsZero() {
+		t.tokens = math.Min(t.burst, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	}
+	t.last = now
+
+	if t.tokens < 1 {
+		return false, time.Duration((1 - t.tokens) / t.rate * float64(time.Second))
+	}
+	t.tokens--
+	return true, 0
+}
+
+// Waits for a processing slot until the queue timeout or the request is canceled and
+// returns false if no slot was acquired.
+func (t *throttle) acquire(ctx context.Context) bool {
+	if t.slots == nil {
+		return true
+	}
+
+	o11y.UploadsQueued.Inc()
+	defer o11y.UploadsQueued.Dec()
+
+	select {
+	case t.slots <- struct{}{}:
+		o11y.UploadsInFlight.Inc()
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+
+	select {
+	case t.slots <- struct{}{}:
+		o11y.UploadsInFlight.Inc()
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Releases the processing slot acquired by the upload.
+func (t *throttle) release() {
+	if t.slots != nil {
+		<-t.slots
+		o11y.UploadsInFlight.Dec()
+	}
+}
@@ -0,0 +1,102 @@
+package courier_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+func (s *courierTestSuite) TestThrottle() {
+	require := s.Require()
+
+	// Uploads that are not decrypted are enough to hold a processing slot
+	upload := func(url, id string) *http.Response {
+		body, err := json.Marshal(&api.StoreCertificateRequest{
+			ID:                id,
+			NoDecrypt:         true,
+			Base64Certificate: base64.StdEncoding.EncodeToString([]byte("certificate")),
+		})
+		require.NoError(err, "could not marshal request")
+
+		rep, err := http.Post(url+"/v1/certs/"+id, "application/json", bytes.NewReader(body))
+		require.NoError(err, "could not send request")
+		rep.Body.Close()
+		return rep
+	}
+
+	s.Run("Concurrency", func() {
+		conf := testConfig()
+		conf.Decryption.MaxConcurrent = 1
+		conf.Decryption.QueueTimeout = 50 * time.Millisecond
+		srv, client, db := s.startServer(conf)
+		defer srv.Shutdown()
+
+		// Block the first upload in the store until the test releases it
+		started, release := make(chan struct{}), make(chan struct{})
+		db.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			if name == "blocked" {
+				close(started)
+				<-release
+			}
+			return nil
+		}
+
+		db.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+
+		db.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
+			return nil
+		}
+
+		done := make(chan int, 1)
+		go func() {
+			done <- upload(srv.URL(), "blocked").StatusCode
+		}()
+		<-started
+
+		// Uploads that cannot get a slot before the queue timeout are rejected
+		rep := upload(srv.URL(), "certID")
+		require.Equal(http.StatusTooManyRequests, rep.StatusCode)
+		require.Equal("1", rep.Header.Get("Retry-After"))
+
+		// Other endpoints are not starved by the uploads
+		_, err := client.Status(context.Background())
+		require.NoError(err, "status should not be throttled")
+
+		err = client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "supersecretsquirrel"})
+		require.NoError(err, "password uploads should not be throttled")
+
+		// Once the slot is released uploads are accepted again
+		close(release)
+		require.Equal(http.StatusNoContent, <-done)
+		require.Equal(http.StatusNoContent, upload(srv.URL(), "certID").StatusCode)
+	})
+
+	s.Run("Rate", func() {
+		conf := testConfig()
+		conf.Decryption.Rate = 0.1
+		conf.Decryption.Burst = 2
+		srv, _, db := s.startServer(conf)
+		defer srv.Shutdown()
+
+		db.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			return nil
+		}
+
+		// The burst is accepted before the rate applies
+		for i := 0; i < 2; i++ {
+			require.Equal(http.StatusNoContent, upload(srv.URL(), "certID").StatusCode)
+		}
+
+		rep := upload(srv.URL(), "certID")
+		require.Equal(http.StatusTooManyRequests, rep.StatusCode)
+		require.Equal("10", rep.Header.Get("Retry-After"), "expected to wait for the next token")
+	})
+}
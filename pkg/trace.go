@@ -0,0 +1,202 @@
+package courier
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/auth"
+)
+
+// Maximum number of bytes of each request and response body kept by the trace.
+const maxTraceBody = 4096
+
+// Replaces the values of redacted headers and bodies in traced requests.
+const redacted = "[REDACTED]"
+
+// Headers that carry credentials are redacted from every traced request and are not
+// passed to the authorization policy.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", auth.APIKeyHeader}
+
+// Routes whose request or response bodies contain certificates, private keys,
+// passwords, or secrets, relative to the API version prefix.
+var secretRoutes = []string{
+	"/certs/:id",
+	"/certs/:id/download",
+	"/certs/:id/wait",
+	"/certs/:id/versions/:version",
+	"/certs/:id/pkcs12password",
+	"/secrets/:name",
+	"/admin/snapshot",
+	"/admin/restore",
+}
+
+// Trace records the headers of every request and response, along with the bodies of
+// routes that do not carry secrets, so that encoding and header problems with
+// integrators can be debugged without packet captures. Only the most recent requests
+// are kept in memory.
+func (s *Server) Trace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Do not trace requests for the trace itself
+		if strings.HasSuffix(c.FullPath(), "/debug/requests") {
+			c.Next()
+			return
+		}
+
+		secret := isSecretRoute(c.FullPath())
+		trace := &api.TracedRequest{
+			Timestamp:      time.Now().UTC(),
+			Method:         c.Request.Method,
+			URL:            c.Request.URL.RequestURI(),
+			Proto:          c.Request.Proto,
+			ClientIP:       c.ClientIP(),
+			RequestHeaders: redactHeaders(c.Request.Header),
+		}
+
+		request := &traceBody{}
+		if c.Request.Body != nil {
+			c.Request.Body = readCloser{io.TeeReader(c.Request.Body, request), c.Request.Body}
+		}
+
+		response := &traceWriter{ResponseWriter: c.Writer}
+		c.Writer = response
+
+		c.Next()
+
+		trace.Duration = time.Since(trace.Timestamp).String()
+		trace.Status = c.Writer.Status()
+		trace.ResponseHeaders = redactHeaders(c.Writer.Header())
+		trace.RequestSize, trace.RequestBody = request.size, request.String(secret)
+		trace.ResponseSize, trace.ResponseBody = response.body.size, response.body.String(secret)
+		s.traces.add(trace)
+	}
+}
+
+// TraceDump returns the most recently traced requests, newest first. Tracing must be
+// enabled in the configuration, otherwise a 404 is returned.
+func (s *Server) TraceDump(c *gin.Context) {
+	if s.traces == nil {
+		c.JSON(http.StatusNotFound, api.ErrorResponse("request tracing is not enabled"))
+		return
+	}
+	c.JSON(http.StatusOK, &api.TraceReply{Requests: s.traces.recent()})
+}
+
+func isSecretRoute(path string) bool {
+	for _, route := range secretRoutes {
+		if strings.HasSuffix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the headers with credentials redacted.
+func redactHeaders(header http.Header) http.Header {
+	header = header.Clone()
+	for _, key := range credentialHeaders {
+		key = http.CanonicalHeaderKey(key)
+		if _, ok := header[key]; ok {
+			header[key] = []string{redacted}
+		}
+	}
+	return header
+}
+
+// Traces is a ring buffer of the most recently traced requests.
+type traces struct {
+	sync.Mutex
+	requests []*api.TracedRequest
+	next     int
+	full     bool
+}
+
+func newTraces(size int) *traces {
+	return &traces{requests: make([]*api.TracedRequest, size)}
+}
+
+func (t *traces) add(trace *api.TracedRequest) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.requests[t.next] = trace
+	if t.next = (t.next + 1) % len(t.requests); t.next == 0 {
+		t.full = true
+	}
+}
+
+// Returns the traced requests, newest first.
+func (t *traces) recent() []*api.TracedRequest {
+	t.Lock()
+	defer t.Unlock()
+
+	n := t.next
+	if t.full {
+		n = len(t.requests)
+	}
+
+	out := make([]*api.TracedRequest, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, t.requests[(t.next-i+len(t.requests))%len(t.requests)])
+	}
+	return out
+}
+
+// TraceBody keeps the first bytes of a body and counts its total size.
+type traceBody struct {
+	buf  bytes.Buffer
+	size int64
+}
+
+func (b *traceBody) Write(p []byte) (int, error) {
+	if remaining := maxTraceBody - b.buf.Len(); remaining > 0 {
+		b.buf.Write(p[:min(len(p), remaining)])
+	}
+	b.size += int64(len(p))
+	return len(p), nil
+}
+
+// Returns the body that is kept in the trace, which is redacted for secret routes.
+func (b *traceBody) String(secret bool) string {
+	switch {
+	case b.size == 0:
+		return ""
+	case secret:
+		return redacted
+	}
+	return b.buf.String()
+}
+
+// TraceWriter copies the response body into the trace as it is written.
+type traceWriter struct {
+	gin.ResponseWriter
+	body traceBody
+}
+
+func (w *traceWriter) Write(p []byte) (n int, err error) {
+	n, err = w.ResponseWriter.Write(p)
+	w.body.Write(p[:n])
+	return n, err
+}
+
+func (w *traceWriter) WriteString(s string) (n int, err error) {
+	n, err = w.ResponseWriter.WriteString(s)
+	w.body.Write([]byte(s[:n]))
+	return n, err
+}
+
+// Unwrap allows the response controller to reach the underlying response writer, e.g.
+// to disable the write deadline of event streams.
+func (w *traceWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,242 @@
+package courier
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/o11y"
+	"github.com/trisacrypto/courier/pkg/store"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+// verifier ensures that only one verification of the stored certificates runs at a
+// time and keeps the report of the most recent verification.
+type verifier struct {
+	sync.RWMutex
+	running sync.Mutex
+	report  *api.VerificationReply
+}
+
+// AdminVerify verifies every stored certificate and returns the report, e.g. to check
+// a deployment for silent corruption after a storage incident. Only one verification
+// runs at a time; the request waits for a scheduled verification to finish.
+func (s *Server) AdminVerify(c *gin.Context) {
+	out, err := s.VerifyCertificates(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, api.ErrorResponse("could not verify the stored certificates"))
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// AdminVerification returns the report of the most recent verification, or a 404 if
+// no verification has completed since the server started.
+func (s *Server) AdminVerification(c *gin.Context) {
+	s.verifier.RLock()
+	out := s.verifier.report
+	s.verifier.RUnlock()
+
+	if out == nil {
+		c.JSON(http.StatusNotFound, api.ErrorResponse("no verification has completed"))
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// verifyCertificates verifies every stored certificate at the verification interval
+// until the context is cancelled when the server is shut down.
+func (s *Server) verifyCertificates(ctx context.Context) {
+	ticker := time.NewTicker(s.conf.Verification.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		out, err := s.VerifyCertificates(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Warn().Err(err).Msg("could not verify the stored certificates")
+		case out.Failed > 0 || out.Expiring > 0:
+			log.Warn().Int("failed", out.Failed).Int("expiring", out.Expiring).Int("valid", out.Valid).Msg("stored certificates failed verification or are expiring")
+		default:
+			log.Info().Int("valid", out.Valid).Int("encrypted", out.Encrypted).Msg("verified stored certificates")
+		}
+	}
+}
+
+// VerifyCertificates re-validates every stored certificate and returns a report with
+// the result of each one: the certificate must be read without a checksum failure,
+// which backends that keep checksums verify when a resource is read, parse with a
+// private key that matches the leaf, chain to its own root or a system root, and not
+// be expired. Failures of individual certificates are reported rather than returned;
+// an error is only returned if the certificates cannot be listed. The report is kept
+// so that it can be retrieved by AdminVerification.
+func (s *Server) VerifyCertificates(ctx context.Context) (out *api.VerificationReply, err error) {
+	s.verifier.running.Lock()
+	defer s.verifier.running.Unlock()
+
+	var ids []string
+	if ids, err = s.store.List(ctx, store.CertificatePrefix); err != nil {
+		return nil, fmt.Errorf("could not list certificates: %w", err)
+	}
+
+	out = &api.VerificationReply{
+		Started:      time.Now().UTC(),
+		Certificates: make([]*api.VerifiedCertificate, 0, len(ids)),
+	}
+
+	for _, id := range ids {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		result := s.checkCertificate(ctx, id)
+		if result == nil {
+			// The certificate was deleted since it was listed
+			continue
+		}
+
+		switch result.Status {
+		case api.VerificationValid:
+			out.Valid++
+		case api.VerificationExpiring:
+			out.Expiring++
+		case api.VerificationEncrypted:
+			out.Encrypted++
+		default:
+			out.Failed++
+			log.Warn().Str("id", id).Str("status", result.Status).Str("error", result.Error).Msg("stored certificate failed verification")
+		}
+
+		o11y.CertificateVerifications.WithLabelValues(result.Status).Inc()
+		out.Certificates = append(out.Certificates, result)
+	}
+
+	out.Finished = time.Now().UTC()
+
+	s.verifier.Lock()
+	s.verifier.report = out
+	s.verifier.Unlock()
+	return out, nil
+}
+
+// checkCertificate returns the result of verifying the certificate stored with the id
+// or nil if it no longer exists.
+func (s *Server) checkCertificate(ctx context.Context, id string) *api.VerifiedCertificate {
+	out := &api.VerifiedCertificate{ID: id}
+	data, err := s.store.GetCertificate(ctx, id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return nil
+	case errors.Is(err, store.ErrCorrupted):
+		return failed(out, api.VerificationCorrupted, err)
+	case err != nil:
+		return failed(out, api.VerificationError, err)
+	}
+
+	out.Checksum = store.Checksum(data)
+
+	// Certificates stored without decryption are pkcs12 archives rather than PEM
+	if block, _ := pem.Decode(data); block == nil {
+		out.Status = api.VerificationEncrypted
+		return out
+	}
+
+	var provider *trust.Provider
+	if provider, err = trust.New(data); err != nil {
+		return failed(out, api.VerificationInvalid, err)
+	}
+
+	var leaf *x509.Certificate
+	if leaf, err = provider.GetLeafCertificate(); err != nil {
+		return failed(out, api.VerificationInvalid, err)
+	}
+	out.NotAfter = &leaf.NotAfter
+
+	if provider.IsPrivate() {
+		if _, err = provider.GetKeyPair(); err != nil {
+			return failed(out, api.VerificationInvalid, fmt.Errorf("private key does not match the certificate: %w", err))
+		}
+	}
+
+	if err = verifyChain(provider, leaf); err != nil {
+		return failed(out, api.VerificationInvalid, err)
+	}
+
+	now := time.Now()
+	switch {
+	case now.After(leaf.NotAfter):
+		out.Status = api.VerificationExpired
+	case now.Add(s.conf.Verification.Expiring).After(leaf.NotAfter):
+		out.Status = api.VerificationExpiring
+	default:
+		out.Status = api.VerificationValid
+	}
+	return out
+}
+
+// verifyChain verifies that the leaf chains to a self-signed root in the stored chain,
+// or to a system root if the chain does not include its root; a self-signed leaf is its
+// own root. The chain is verified at a time when the leaf is valid so that expired
+// certificates are reported as expired rather than invalid.
+func verifyChain(provider *trust.Provider, leaf *x509.Certificate) (err error) {
+	opts := x509.VerifyOptions{
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	if opts.CurrentTime.After(leaf.NotAfter) {
+		opts.CurrentTime = leaf.NotAfter
+	}
+
+	var chain []byte
+	if chain, err = provider.Public().Encode(); err != nil {
+		return err
+	}
+
+	for block, rest := pem.Decode(chain); block != nil; block, rest = pem.Decode(rest) {
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return err
+		}
+
+		switch {
+		case cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil:
+			if opts.Roots == nil {
+				opts.Roots = x509.NewCertPool()
+			}
+			opts.Roots.AddCert(cert)
+		case !cert.Equal(leaf):
+			opts.Intermediates.AddCert(cert)
+		}
+	}
+
+	if _, err = leaf.Verify(opts); err != nil {
+		return fmt.Errorf("certificate chain does not verify: %w", err)
+	}
+	return nil
+}
+
+// failed records the status and error of a certificate that failed verification.
+func failed(out *api.VerifiedCertificate, status string, err error) *api.VerifiedCertificate {
+	out.Status = status
+	out.Error = err.Error()
+	return out
+}
@@ -1,6 +1,13 @@
 package courier
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+)
 
 // Version of the current build
 const (
@@ -14,6 +21,9 @@ const (
 // Set the GitVersion via -ldflags="-X 'github.com/trisacrypto/courier/pkg.GitVersion=$(git rev-parse --short HEAD)'"
 var GitVersion string
 
+// Set the BuildDate via -ldflags="-X 'github.com/trisacrypto/courier/pkg.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)'"
+var BuildDate string
+
 // Returns the semantic version for the current build.
 func Version() string {
 	versionCore := fmt.Sprintf("%d.%d.%d", VersionMajor, VersionMinor, VersionPatch)
@@ -31,3 +41,14 @@ func Version() string {
 
 	return versionCore
 }
+
+// BuildInfo returns the semantic version, git revision, build date, and go runtime
+// version of the current build.
+func (s *Server) BuildInfo(c *gin.Context) {
+	c.JSON(http.StatusOK, &api.BuildInfoReply{
+		Version:   Version(),
+		GitCommit: GitVersion,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	})
+}
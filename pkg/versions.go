@@ -0,0 +1,43 @@
+package courier
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+)
+
+// Versions of the API served by courier. New versions are served alongside older
+// versions under their own prefix; to deprecate a version set its Deprecated date,
+// Sunset date, and Successor link so that clients are warned before it is removed.
+var apiVersions = []api.APIVersion{
+	{
+		Version: api.Version,
+		Prefix:  "/" + api.Version,
+	},
+}
+
+// Versions returns the API versions served by courier so clients can negotiate which
+// version of the API to use.
+func (s *Server) Versions(c *gin.Context) {
+	c.JSON(http.StatusOK, &api.VersionsReply{Versions: apiVersions})
+}
+
+// Versioned is middleware that adds the API version header to all responses from a
+// version route group, along with the Deprecation, Sunset, and Link headers if the
+// version has been deprecated.
+func Versioned(version api.APIVersion) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version.SetHeaders(c.Writer.Header())
+		c.Next()
+	}
+}
+
+// Create a route group for each API version and register its routes.
+func (s *Server) setupVersions(routes map[string]func(*gin.RouterGroup)) {
+	for _, version := range apiVersions {
+		if register, ok := routes[version.Version]; ok {
+			register(s.router.Group(version.Prefix, Versioned(version)))
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package courier_test
+
+import (
+	"context"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+)
+
+func (s *courierTestSuite) TestVersions() {
+	require := s.Require()
+
+	// Make a request to the versions endpoint
+	rep, err := s.client.Versions(context.Background())
+	require.NoError(err, "could not get versions from server")
+
+	// The current version of the API should be supported and not deprecated
+	require.True(rep.Supports(api.Version), "expected current api version to be supported")
+	require.False(rep.Supports("v0"), "expected unknown version to be unsupported")
+	for _, version := range rep.Versions {
+		if version.Version == api.Version {
+			require.False(version.IsDeprecated(), "current api version should not be deprecated")
+		}
+	}
+}
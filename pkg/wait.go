@@ -0,0 +1,173 @@
+package courier
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+const (
+	// Default amount of time to wait for a certificate if no timeout is specified.
+	DefaultWaitTimeout = time.Minute
+
+	// Maximum amount of time a client can wait for a certificate in one request.
+	MaxWaitTimeout = 10 * time.Minute
+)
+
+// WaitForCertificate blocks until the certificate with the id is stored and returns it
+// like RetrieveCertificate, so that node bootstrap scripts do not have to poll in a
+// loop. If the password query parameter is true the request also waits for the pkcs12
+// password to be stored. The timeout query parameter limits how long the request waits
+// and a 404 Not Found response is returned if the certificate has not arrived in time.
+func (s *Server) WaitForCertificate(c *gin.Context) {
+	var (
+		err          error
+		data         []byte
+		timeout      = DefaultWaitTimeout
+		withPassword bool
+	)
+
+	if param := c.Query("timeout"); param != "" {
+		if timeout, err = time.ParseDuration(param); err != nil || timeout <= 0 || timeout > MaxWaitTimeout {
+			c.JSON(http.StatusBadRequest, api.ErrorResponse("timeout must be a positive duration of at most 10m"))
+			return
+		}
+	}
+
+	if param := c.Query("password"); param != "" {
+		if withPassword, err = strconv.ParseBool(param); err != nil {
+			c.JSON(http.StatusBadRequest, api.ErrorResponse("could not parse password query parameter"))
+			return
+		}
+	}
+
+	// Extend the write timeout of the server so that the response can be written
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(timeout + 20*time.Second))
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	id := c.Param("id")
+	for {
+		// Subscribe before checking the store so that an arrival cannot be missed
+		arrived := s.arrivals.wait(id)
+
+		if data, err = s.arrived(ctx, id, withPassword); err == nil {
+			break
+		}
+
+		if !errors.Is(err, store.ErrNotFound) && ctx.Err() == nil {
+			storeError(c, err, "certificate not found")
+			return
+		}
+
+		select {
+		case <-arrived:
+		case <-s.arrivals.done:
+			c.JSON(http.StatusServiceUnavailable, api.ErrorResponse("server is shutting down"))
+			return
+		case <-ctx.Done():
+			c.JSON(http.StatusNotFound, api.ErrorResponse("certificate did not arrive before the timeout"))
+			return
+		}
+	}
+
+	c.Header("ETag", contentETag(data))
+	c.JSON(http.StatusOK, &api.CertificateReply{
+		ID:                id,
+		Base64Certificate: base64.StdEncoding.EncodeToString(data),
+	})
+}
+
+// Returns the certificate if it and, if required, its password have been stored.
+func (s *Server) arrived(ctx context.Context, id string, withPassword bool) (data []byte, err error) {
+	if withPassword {
+		if _, err = s.store.GetPassword(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return s.store.GetCertificate(ctx, id)
+}
+
+// storeChanged releases the requests waiting for a certificate or password that was
+// created or modified outside of courier, e.g. copied into the local storage path, and
+// invalidates the cached metadata of certificates that were changed. Changes to
+// certificates and passwords are published as external change events so that
+// tampering or a misconfigured sidecar can be noticed by event stream subscribers.
+func (s *Server) storeChanged(change store.Change) {
+	if change.Prefix == store.CertificatePrefix {
+		s.invalidate(change.Name)
+	}
+
+	if change.Prefix == store.CertificatePrefix || change.Prefix == store.PasswordPrefix {
+		s.events.publish(&api.Event{
+			Type:      api.EventExternalChange,
+			ID:        change.Name,
+			Timestamp: time.Now().UTC(),
+			Resource:  change.Prefix,
+			Change:    change.Kind,
+		})
+	}
+
+	if change.Kind == store.ChangeRemoved {
+		return
+	}
+
+	if change.Prefix == store.CertificatePrefix || change.Prefix == store.PasswordPrefix {
+		s.arrivals.notify(change.Name)
+	}
+}
+
+// Arrivals notifies requests that are waiting for a certificate or password to be
+// stored. Waiters are released when anything is stored with the id and must check the
+// store again. The done channel is closed when the server shuts down so that waiting
+// requests do not delay the shutdown.
+type arrivals struct {
+	sync.Mutex
+	waiters map[string]chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newArrivals() *arrivals {
+	return &arrivals{
+		waiters: make(map[string]chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Returns a channel that is closed when something is stored with the id.
+func (a *arrivals) wait(id string) <-chan struct{} {
+	a.Lock()
+	defer a.Unlock()
+
+	ch, ok := a.waiters[id]
+	if !ok {
+		ch = make(chan struct{})
+		a.waiters[id] = ch
+	}
+	return ch
+}
+
+// Releases all waiters for the id.
+func (a *arrivals) notify(id string) {
+	a.Lock()
+	defer a.Unlock()
+	if ch, ok := a.waiters[id]; ok {
+		close(ch)
+		delete(a.waiters, id)
+	}
+}
+
+// Releases all waiters so that the server can shut down.
+func (a *arrivals) close() {
+	a.once.Do(func() { close(a.done) })
+}
@@ -0,0 +1,137 @@
+package courier_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/trisacrypto/courier/pkg/api/v1"
+	"github.com/trisacrypto/courier/pkg/store"
+)
+
+func (s *courierTestSuite) TestWaitForCertificate() {
+	require := s.Require()
+
+	s.Run("Arrives", func() {
+		var stored atomic.Bool
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			if !stored.Load() {
+				return nil, store.ErrNotFound
+			}
+			return []byte("certificate"), nil
+		}
+		s.store.OnUpdateCertificate = func(ctx context.Context, name string, cert []byte) error {
+			stored.Store(true)
+			return nil
+		}
+		defer s.store.Reset()
+
+		// Deliver the certificate while the client is waiting for it
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			s.client.StoreCertificate(context.Background(), &api.StoreCertificateRequest{
+				ID:                "certID",
+				NoDecrypt:         true,
+				Base64Certificate: base64.StdEncoding.EncodeToString([]byte("certificate")),
+			})
+		}()
+
+		rep, err := s.client.WaitForCertificate(context.Background(), "certID", 5*time.Second, false)
+		require.NoError(err, "could not wait for certificate")
+		require.Equal("certID", rep.ID, "wrong certificate id returned")
+		require.Equal(base64.StdEncoding.EncodeToString([]byte("certificate")), rep.Base64Certificate, "wrong certificate data returned")
+		require.NotEmpty(rep.ETag, "expected an etag to be returned")
+	})
+
+	s.Run("AlreadyStored", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return []byte("certificate"), nil
+		}
+		defer s.store.Reset()
+
+		rep, err := s.client.WaitForCertificate(context.Background(), "certID", 0, false)
+		require.NoError(err, "could not wait for certificate")
+		require.Equal(base64.StdEncoding.EncodeToString([]byte("certificate")), rep.Base64Certificate, "wrong certificate data returned")
+	})
+
+	s.Run("WithPassword", func() {
+		var stored atomic.Bool
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return []byte("-----BEGIN CERTIFICATE-----"), nil
+		}
+		s.store.OnGetPassword = func(ctx context.Context, name string) ([]byte, error) {
+			if !stored.Load() {
+				return nil, store.ErrNotFound
+			}
+			return []byte("supersecretsquirrel"), nil
+		}
+		s.store.OnUpdatePassword = func(ctx context.Context, name string, password []byte) error {
+			stored.Store(true)
+			return nil
+		}
+		defer s.store.Reset()
+
+		// Should not return until the password is stored
+		_, err := s.client.WaitForCertificate(context.Background(), "certID", 50*time.Millisecond, true)
+		s.CheckHTTPStatus(err, http.StatusNotFound, "expected a timeout while the password is missing")
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			s.client.StoreCertificatePassword(context.Background(), &api.StorePasswordRequest{ID: "certID", Password: "supersecretsquirrel"})
+		}()
+
+		_, err = s.client.WaitForCertificate(context.Background(), "certID", 5*time.Second, true)
+		require.NoError(err, "could not wait for certificate and password")
+	})
+
+	s.Run("Timeout", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+		defer s.store.Reset()
+
+		start := time.Now()
+		_, err := s.client.WaitForCertificate(context.Background(), "certID", 50*time.Millisecond, false)
+		s.CheckHTTPStatus(err, http.StatusNotFound, "wrong error code for timeout")
+		require.Less(time.Since(start), 5*time.Second, "request should not be retried")
+	})
+
+	s.Run("InvalidTimeout", func() {
+		for _, timeout := range []string{"soon", "-1m", "1h"} {
+			rep, err := http.Get(s.courier.URL() + "/v1/certs/certID/wait?timeout=" + timeout)
+			require.NoError(err, "could not make request")
+			rep.Body.Close()
+			require.Equal(http.StatusBadRequest, rep.StatusCode, "expected bad request for timeout %q", timeout)
+		}
+	})
+
+	s.Run("StoreError", func() {
+		s.store.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrUnavailable
+		}
+		defer s.store.Reset()
+
+		_, err := s.client.WaitForCertificate(context.Background(), "certID", time.Second, false)
+		s.CheckHTTPStatus(err, http.StatusServiceUnavailable, "wrong error code for store error")
+	})
+
+	s.Run("Shutdown", func() {
+		srv, client, db := s.startServer(testConfig())
+		db.OnGetCertificate = func(ctx context.Context, name string) ([]byte, error) {
+			return nil, store.ErrNotFound
+		}
+
+		// Waiting requests should not delay a graceful shutdown
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			srv.Shutdown()
+		}()
+
+		start := time.Now()
+		_, err := client.WaitForCertificate(context.Background(), "certID", 5*time.Minute, false)
+		require.Error(err, "expected an error when the server shuts down")
+		require.Less(time.Since(start), 10*time.Second, "shutdown was delayed by the waiting request")
+	})
+}
@@ -0,0 +1,198 @@
+/*
+Package websocket adapts github.com/gorilla/websocket to the connections used for
+courier notifications: servers upgrade requests from the same origin, clients dial
+ws, wss, http, or https urls, and a connection is read by one goroutine while others
+write to it. Pings are answered and closes are acknowledged by the underlying
+implementation; extensions and subprotocols are not negotiated.
+*/
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message and control frame opcodes.
+const (
+	TextMessage   = websocket.TextMessage
+	BinaryMessage = websocket.BinaryMessage
+	CloseMessage  = websocket.CloseMessage
+	PingMessage   = websocket.PingMessage
+	PongMessage   = websocket.PongMessage
+)
+
+// Close status codes used by courier.
+const (
+	CloseNormal          = websocket.CloseNormalClosure
+	CloseGoingAway       = websocket.CloseGoingAway
+	CloseProtocolError   = websocket.CloseProtocolError
+	CloseUnsupportedData = websocket.CloseUnsupportedData
+	ClosePolicyViolation = websocket.ClosePolicyViolation
+	CloseTooLarge        = websocket.CloseMessageTooBig
+)
+
+// MaxMessageSize is the largest message that will be read from a connection.
+const MaxMessageSize = 64 * 1024
+
+// Time allowed to send the close message when a connection is closed.
+const closeTimeout = 5 * time.Second
+
+var (
+	ErrBadHandshake    = websocket.ErrBadHandshake
+	ErrMessageTooLarge = websocket.ErrReadLimit
+	ErrClosed          = errors.New("websocket: connection closed")
+)
+
+// CloseError is returned by ReadMessage when the peer closes the connection.
+type CloseError = websocket.CloseError
+
+// The default origin check of the upgrader only accepts requests without an Origin
+// header, which is only sent by browsers, or from the origin of the server so that
+// other sites cannot open connections with the credentials of the browser.
+var upgrader = websocket.Upgrader{
+	HandshakeTimeout: 10 * time.Second,
+}
+
+// Conn is a WebSocket connection. A single goroutine may read from the connection
+// while other goroutines write to it.
+type Conn struct {
+	conn   *websocket.Conn
+	wmu    sync.Mutex
+	closed bool
+}
+
+// Upgrade completes the opening handshake of a WebSocket request and takes over the
+// underlying connection, which must be closed by the caller. If the request is not a
+// valid WebSocket request an error response is written and ErrBadHandshake returned.
+// Requests from browsers must be made from the same origin as the server.
+func Upgrade(w http.ResponseWriter, r *http.Request) (_ *Conn, err error) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+
+	var conn *websocket.Conn
+	if conn, err = upgrader.Upgrade(w, r, nil); err != nil {
+		// The upgrader writes the error response
+		var handshake websocket.HandshakeError
+		if errors.As(err, &handshake) {
+			return nil, fmt.Errorf("%w: %s", ErrBadHandshake, handshake.Error())
+		}
+		return nil, err
+	}
+	return newConn(conn), nil
+}
+
+// Dial opens a WebSocket connection to the ws, wss, http, or https url. The TLS
+// configuration is used for wss and https urls and may be nil.
+func Dial(ctx context.Context, endpoint string, header http.Header, conf *tls.Config) (_ *Conn, err error) {
+	var u *url.URL
+	if u, err = url.Parse(endpoint); err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "ws"
+	case "wss", "https":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("websocket: unsupported url scheme %q", u.Scheme)
+	}
+
+	dialer := &websocket.Dialer{TLSClientConfig: conf, HandshakeTimeout: 30 * time.Second}
+
+	var (
+		conn *websocket.Conn
+		rep  *http.Response
+	)
+	if conn, rep, err = dialer.DialContext(ctx, u.String(), header); err != nil {
+		if errors.Is(err, ErrBadHandshake) && rep != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadHandshake, rep.Status)
+		}
+		return nil, err
+	}
+	return newConn(conn), nil
+}
+
+func newConn(conn *websocket.Conn) *Conn {
+	conn.SetReadLimit(MaxMessageSize)
+	return &Conn{conn: conn}
+}
+
+// ReadMessage returns the next text or binary message from the connection. Pings are
+// answered automatically and a CloseError is returned when the peer closes the
+// connection, after the close has been acknowledged. Messages that are larger than
+// MaxMessageSize close the connection and return ErrMessageTooLarge.
+func (c *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	if opcode, data, err = c.conn.ReadMessage(); err != nil {
+		var closed *CloseError
+		if errors.As(err, &closed) {
+			c.Close(closed.Code, "")
+		}
+		return 0, nil, err
+	}
+	return opcode, data, nil
+}
+
+// ReadJSON reads the next message and unmarshals it into v.
+func (c *Conn) ReadJSON(v interface{}) (err error) {
+	var data []byte
+	if _, data, err = c.ReadMessage(); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMessage writes a message to the connection.
+func (c *Conn) WriteMessage(opcode int, data []byte) (err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	if err = c.conn.WriteMessage(opcode, data); errors.Is(err, websocket.ErrCloseSent) {
+		return ErrClosed
+	}
+	return err
+}
+
+// WriteJSON marshals v and writes it to the connection as a text message.
+func (c *Conn) WriteJSON(v interface{}) (err error) {
+	var data []byte
+	if data, err = json.Marshal(v); err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, data)
+}
+
+// Close sends a close message with the status code and reason, if one has not already
+// been sent, and closes the underlying connection.
+func (c *Conn) Close(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	c.conn.WriteControl(CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(closeTimeout))
+	return c.conn.Close()
+}
+
+// SetReadDeadline sets the deadline for the next read from the connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
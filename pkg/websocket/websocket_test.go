@@ -0,0 +1,207 @@
+package websocket_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/trisacrypto/courier/pkg/websocket"
+)
+
+func TestWebSocket(t *testing.T) {
+	// Create a server that pings the client and echoes messages
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close(websocket.CloseNormal, "")
+
+		conn.WriteMessage(websocket.PingMessage, []byte("ping"))
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(opcode, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	endpoint := strings.Replace(ts.URL, "http", "ws", 1)
+	conn, err := websocket.Dial(context.Background(), endpoint, nil, nil)
+	require.NoError(t, err, "could not dial websocket")
+
+	// Messages of every length encoding should be echoed
+	for _, size := range []int{0, 125, 126, 65535, websocket.MaxMessageSize} {
+		data := bytes.Repeat([]byte("a"), size)
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, data))
+
+		opcode, echo, err := conn.ReadMessage()
+		require.NoError(t, err, "could not read message of size %d", size)
+		require.Equal(t, websocket.BinaryMessage, opcode)
+		require.Equal(t, len(data), len(echo))
+		require.True(t, bytes.Equal(data, echo), "wrong message echoed")
+	}
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"hello": "world"}))
+	var reply map[string]string
+	require.NoError(t, conn.ReadJSON(&reply))
+	require.Equal(t, map[string]string{"hello": "world"}, reply)
+
+	// Messages that are too large should close the connection
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, make([]byte, websocket.MaxMessageSize+1)))
+	var closed *websocket.CloseError
+	_, _, err = conn.ReadMessage()
+	require.ErrorAs(t, err, &closed)
+	require.Equal(t, websocket.CloseTooLarge, closed.Code)
+
+	require.ErrorIs(t, conn.WriteMessage(websocket.TextMessage, nil), websocket.ErrClosed)
+}
+
+func TestBadHandshake(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := websocket.Upgrade(w, r)
+		require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	}))
+	defer ts.Close()
+
+	rep, err := http.Get(ts.URL)
+	require.NoError(t, err, "could not make request")
+	rep.Body.Close()
+	require.Equal(t, http.StatusUpgradeRequired, rep.StatusCode)
+
+	// Dialing a server that does not upgrade the connection should fail
+	ts = httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	_, err = websocket.Dial(context.Background(), ts.URL, nil, nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+
+	_, err = websocket.Dial(context.Background(), "ftp://localhost", nil, nil)
+	require.Error(t, err, "expected unsupported scheme error")
+}
+
+func TestCrossOrigin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		conn.Close(websocket.CloseNormal, "")
+	}))
+	defer ts.Close()
+
+	// Browsers on other sites cannot open connections
+	_, err := websocket.Dial(context.Background(), ts.URL, http.Header{"Origin": {"https://example.com"}}, nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+
+	// Requests from the same origin or without an origin are upgraded
+	conn, err := websocket.Dial(context.Background(), ts.URL, http.Header{"Origin": {ts.URL}}, nil)
+	require.NoError(t, err, "expected same origin requests to be upgraded")
+	conn.Close(websocket.CloseNormal, "")
+
+	conn, err = websocket.Dial(context.Background(), ts.URL, nil, nil)
+	require.NoError(t, err, "expected requests without an origin to be upgraded")
+	conn.Close(websocket.CloseNormal, "")
+}
+
+func TestDialCanceled(t *testing.T) {
+	// Create a server that never responds to the handshake
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := websocket.Dial(ctx, ts.URL, nil, nil)
+	require.Error(t, err, "expected the handshake to be aborted")
+}
+
+func TestFragmentedMessages(t *testing.T) {
+	// Small write buffers split every message into many continuation frames
+	message := bytes.Repeat([]byte("fragmented "), 1024)
+	peer := gorilla.Upgrader{WriteBufferSize: 128}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := peer.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Interleave a ping with the fragments of a message
+		writer, err := conn.NextWriter(gorilla.TextMessage)
+		if err != nil {
+			return
+		}
+		writer.Write(message[:len(message)/2])
+		conn.WriteControl(gorilla.PingMessage, []byte("ping"), time.Now().Add(time.Second))
+		writer.Write(message[len(message)/2:])
+		writer.Close()
+
+		// Echo the messages of the client
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(opcode, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	conn, err := websocket.Dial(context.Background(), ts.URL, nil, nil)
+	require.NoError(t, err, "could not dial websocket")
+	defer conn.Close(websocket.CloseNormal, "")
+
+	opcode, data, err := conn.ReadMessage()
+	require.NoError(t, err, "could not read fragmented message")
+	require.Equal(t, websocket.TextMessage, opcode)
+	require.Equal(t, message, data)
+
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, message))
+	opcode, data, err = conn.ReadMessage()
+	require.NoError(t, err, "could not read echoed message")
+	require.Equal(t, websocket.BinaryMessage, opcode)
+	require.Equal(t, message, data)
+
+	// Connections upgraded by courier read messages fragmented by other clients
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close(websocket.CloseNormal, "")
+
+		opcode, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(opcode, data)
+	}))
+	defer ts.Close()
+
+	dialer := gorilla.Dialer{WriteBufferSize: 128}
+	client, _, err := dialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	require.NoError(t, err, "could not dial courier websocket")
+	defer client.Close()
+
+	require.NoError(t, client.WriteMessage(gorilla.TextMessage, message))
+	opcode, data, err = client.ReadMessage()
+	require.NoError(t, err, "could not read echoed message")
+	require.Equal(t, gorilla.TextMessage, opcode)
+	require.Equal(t, message, data)
+}